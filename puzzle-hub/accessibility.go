@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Accessibility options
+//
+// Each profile can turn on adjustments in its preferences, and the
+// generators honor them for signed-in players on every request:
+//
+//	dyslexia_friendly      Spelling words with regular spellings and no
+//	                       b/d or p/q look-alikes, hints about sounds
+//	simplified_language    Short, plain definitions, sentences, hints and
+//	                       word problems
+//	number_range           The Yohaku number range to use when a game
+//	                       doesn't ask for one, e.g. 1-20 for bigger numbers
+//	reduced_time_pressure  Untimed Yohaku and Sudoku, twice as long for
+//	                       multiplication drills
+//
+// Requests can also turn the spelling and word problem options on for
+// themselves. Clients should switch to a dyslexia-friendly font when the
+// preference is set; the server only changes the content.
+
+type AccessibilitySettings struct {
+	DyslexiaFriendly    bool         `json:"dyslexia_friendly" dynamodbav:"dyslexia_friendly"`
+	SimplifiedLanguage  bool         `json:"simplified_language" dynamodbav:"simplified_language"`
+	NumberRange         *NumberRange `json:"number_range,omitempty" dynamodbav:"number_range,omitempty"`
+	ReducedTimePressure bool         `json:"reduced_time_pressure" dynamodbav:"reduced_time_pressure"`
+}
+
+// UpdateAccessibilityRequest is a partial update; omitted fields are
+// unchanged and a number_range of 0-0 clears the range
+type UpdateAccessibilityRequest struct {
+	DyslexiaFriendly    *bool        `json:"dyslexia_friendly"`
+	SimplifiedLanguage  *bool        `json:"simplified_language"`
+	NumberRange         *NumberRange `json:"number_range"`
+	ReducedTimePressure *bool        `json:"reduced_time_pressure"`
+}
+
+// apply updates settings from the request and returns the problem, or ""
+func (request *UpdateAccessibilityRequest) apply(settings *AccessibilitySettings) string {
+	if request.NumberRange != nil {
+		r := *request.NumberRange
+		switch {
+		case r.Min == 0 && r.Max == 0:
+			settings.NumberRange = nil
+		case r.Min < 0 || r.Max > 1000 || r.Min >= r.Max:
+			return "Number range must be within 0 to 1000, with min below max"
+		default:
+			settings.NumberRange = &r
+		}
+	}
+	if request.DyslexiaFriendly != nil {
+		settings.DyslexiaFriendly = *request.DyslexiaFriendly
+	}
+	if request.SimplifiedLanguage != nil {
+		settings.SimplifiedLanguage = *request.SimplifiedLanguage
+	}
+	if request.ReducedTimePressure != nil {
+		settings.ReducedTimePressure = *request.ReducedTimePressure
+	}
+	return ""
+}
+
+// accessibilityFor returns the signed-in player's settings, or none for
+// anonymous players
+func (h *PuzzleHub) accessibilityFor(c *gin.Context) AccessibilitySettings {
+	userID := optionalUserID(c)
+	if userID == "" {
+		return AccessibilitySettings{}
+	}
+	prefs, err := h.getUserPreferences(userID)
+	if err != nil {
+		// Play on without the adjustments rather than fail the game
+		requestLogger(c).Error("error fetching preferences", "error", err)
+		return AccessibilitySettings{}
+	}
+	return prefs.Accessibility
+}
+
+func (a AccessibilitySettings) applyToSpelling(criteria *GenerationCriteria) {
+	criteria.DyslexiaFriendly = criteria.DyslexiaFriendly || a.DyslexiaFriendly
+	criteria.SimplifiedLanguage = criteria.SimplifiedLanguage || a.SimplifiedLanguage
+}
+
+func (a AccessibilitySettings) applyToWordProblems(request *WordProblemRequest) {
+	request.SimplifiedLanguage = request.SimplifiedLanguage || a.SimplifiedLanguage
+}
+
+// applyToYohaku is called before defaults are filled in, so the profile's
+// range only replaces a range the request left out
+func (a AccessibilitySettings) applyToYohaku(settings *GameSettings) {
+	if a.NumberRange != nil && settings.Range.Min == 0 && settings.Range.Max == 0 {
+		settings.Range = *a.NumberRange
+	}
+	if a.ReducedTimePressure {
+		settings.Untimed = true
+	}
+}
+
+func (a AccessibilitySettings) applyToSudoku(settings *SudokuSettings) {
+	if a.ReducedTimePressure {
+		settings.Untimed = true
+	}
+}
+
+// applyToFactDrill doubles the (already defaulted) drill timer
+func (a AccessibilitySettings) applyToFactDrill(settings *FactDrillSettings) {
+	if a.ReducedTimePressure {
+		settings.TimerDuration = min(settings.TimerDuration*2, maxDrillTimer)
+	}
+}
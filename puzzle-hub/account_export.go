@@ -0,0 +1,390 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/gin-gonic/gin"
+)
+
+// Account export
+//
+// POST /export/account queues a job that gathers everything the user made
+// into one ZIP, uploads it to ACCOUNT_EXPORT_S3_BUCKET under
+// account-exports/<user>/ and emails them a presigned download link that
+// expires after accountExportLinkTTL (give the bucket a lifecycle rule that
+// deletes the prefix after the same time). One export per user per UTC day.
+//
+// The archive layout is described in accountExportReadme, which is also
+// written into the archive itself.
+
+const (
+	accountExportJob     = "account-export"
+	accountExportPrefix  = "account-exports"
+	accountExportLinkTTL = 7 * 24 * time.Hour // The longest a presigned URL lives
+)
+
+const accountExportReadme = `Puzzle Hub account export
+=========================
+
+profile.json             Your account: id, name, email, timezone, language,
+                         preferences and when this export was made.
+progress/results.csv     Every finished session, one row each:
+                         activity,score,max_score,duration_seconds,created_at
+                         Activities are spelling, writing, story, yohaku,
+                         math_facts, sudoku, quiz and flashcards.
+spelling/bees.json       Spelling bee sessions with each word, your attempt
+                         and whether it was right.
+writing/results.csv      The writing rows of progress/results.csv. Texts you
+                         check in the writing coach aren't stored, so only
+                         the results are.
+stories/stories.json     Saved story drafts: title, genre, content, blurb.
+stories/<id>.txt         Each story as plain text, title first.
+logs/log_types.json      Your log types with their fields.
+logs/<log type id>.csv   Entries of one log type: id,entry_date,created_at,
+                         then one column per field. Lists are joined with
+                         "; ", checkboxes are true or false.
+feedback/feedback.json   Feedback you sent, with the replies on each.
+
+Times are RFC 3339 in UTC and dates are YYYY-MM-DD.
+`
+
+// accountExportRequest is the account-export job payload
+type accountExportRequest struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	Day    string `json:"day"` // The claimed UTC day, released if the export fails
+}
+
+// requestAccountExport queues an export of the user's account
+func (h *PuzzleHub) requestAccountExport(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	if h.AccountExportBucket == "" || h.NotificationFromEmail == "" {
+		respondErrorCode(c, http.StatusServiceUnavailable, "account_export_not_configured", "Account export isn't set up on this server", nil)
+		return
+	}
+	if userObj.IsGuest || userObj.Email == "" {
+		respondError(c, http.StatusForbidden, "Sign in with an email account to export it")
+		return
+	}
+
+	day := time.Now().UTC().Format("2006-01-02")
+	claimed, err := h.claimJobRun(accountExportJob, userObj.ID+"#"+day)
+	if err != nil {
+		requestLogger(c).Error("error claiming account export", "error", err)
+		respondStorageError(c, err, "Failed to start export")
+		return
+	}
+	if !claimed {
+		respondError(c, http.StatusTooManyRequests, "You already exported your account today; check your email for the link")
+		return
+	}
+
+	payload := accountExportRequest{UserID: userObj.ID, Email: userObj.Email, Name: userObj.Name, Day: day}
+	jobID, err := h.Jobs.Enqueue(c.Request.Context(), accountExportJob, payload, 0)
+	if err != nil {
+		h.releaseJobRun(accountExportJob, userObj.ID+"#"+day)
+		requestLogger(c).Error("error queueing account export", "error", err)
+		respondStorageError(c, err, "Failed to start export")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "We'll email a download link to " + userObj.Email + " when the export is ready",
+		"job_id":  jobID,
+	})
+}
+
+// runAccountExport is the account-export job handler
+func (h *PuzzleHub) runAccountExport(ctx context.Context, payload json.RawMessage) error {
+	var request accountExportRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return fmt.Errorf("invalid account export payload: %v", err)
+	}
+
+	archive, err := h.buildAccountExport(ctx, request)
+	if err == nil {
+		err = h.deliverAccountExport(ctx, request, archive)
+	}
+	if err != nil {
+		// Let them ask again today; the queue retries this job meanwhile
+		h.releaseJobRun(accountExportJob, request.UserID+"#"+request.Day)
+		return err
+	}
+	return nil
+}
+
+// buildAccountExport writes the user's data into a ZIP
+func (h *PuzzleHub) buildAccountExport(ctx context.Context, request accountExportRequest) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	add := func(name string, data []byte) error {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		return err
+	}
+	addJSON := func(name string, value interface{}) error {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, data)
+	}
+
+	if err := add("README.txt", []byte(accountExportReadme)); err != nil {
+		return nil, err
+	}
+
+	prefs, err := h.getUserPreferences(request.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preferences: %v", err)
+	}
+	err = addJSON("profile.json", gin.H{
+		"user_id":     request.UserID,
+		"name":        request.Name,
+		"email":       request.Email,
+		"timezone":    h.savedTimezone(request.UserID),
+		"language":    prefs.Language,
+		"preferences": prefs,
+		"exported_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Progress, with the writing rows on their own too
+	results, err := h.getActivityResults(request.UserID, time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read progress: %v", err)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.Before(results[j].CreatedAt) })
+	var writing []ActivityResult
+	for _, result := range results {
+		if result.Activity == "writing" {
+			writing = append(writing, result)
+		}
+	}
+	if err := add("progress/results.csv", activityResultsCSV(results)); err != nil {
+		return nil, err
+	}
+	if err := add("writing/results.csv", activityResultsCSV(writing)); err != nil {
+		return nil, err
+	}
+
+	bees, err := queryAll[SpellingBeeSession](h.DynamoDB, userItemsQuery("puzzle-hub-spelling-bee-sessions", request.UserID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spelling bees: %v", err)
+	}
+	for i := range bees {
+		for j := range bees[i].Words {
+			bees[i].Words[j].Index = j
+		}
+	}
+	if err := addJSON("spelling/bees.json", bees); err != nil {
+		return nil, err
+	}
+
+	stories, err := queryAll[StoryDraft](h.DynamoDB, userItemsQuery("puzzle-hub-story-drafts", request.UserID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stories: %v", err)
+	}
+	if err := addJSON("stories/stories.json", stories); err != nil {
+		return nil, err
+	}
+	for _, story := range stories {
+		if err := add("stories/"+story.ID+".txt", []byte(story.Title+"\n\n"+story.Content+"\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	logTypes, err := h.Store.ListLogTypes(request.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log types: %v", err)
+	}
+	for i := range logTypes {
+		if logTypes[i].Fields, err = h.getLogFields(logTypes[i].ID); err != nil {
+			return nil, fmt.Errorf("failed to read fields of %s: %v", logTypes[i].ID, err)
+		}
+		entries, err := h.getUserLogEntries(request.UserID, logTypes[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read entries of %s: %v", logTypes[i].ID, err)
+		}
+		if err := add("logs/"+logTypes[i].ID+".csv", logEntriesCSV(logTypes[i].Fields, entries)); err != nil {
+			return nil, err
+		}
+	}
+	if err := addJSON("logs/log_types.json", logTypes); err != nil {
+		return nil, err
+	}
+
+	feedbackList, err := h.Store.ListFeedback(FeedbackFilter{UserID: request.UserID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feedback: %v", err)
+	}
+	type exportedFeedback struct {
+		Feedback
+		Comments []FeedbackComment `json:"comments"`
+	}
+	exported := make([]exportedFeedback, 0, len(feedbackList))
+	for _, feedback := range feedbackList {
+		comments, err := h.getFeedbackComments(feedback.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read comments on %s: %v", feedback.ID, err)
+		}
+		exported = append(exported, exportedFeedback{Feedback: feedback, Comments: comments})
+	}
+	if err := addJSON("feedback/feedback.json", exported); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deliverAccountExport uploads the archive and emails its link
+func (h *PuzzleHub) deliverAccountExport(ctx context.Context, request accountExportRequest, archive []byte) error {
+	token, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/%s/%s.zip", accountExportPrefix, request.UserID, token)
+	_, err = h.S3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:             aws.String(h.AccountExportBucket),
+		Key:                aws.String(key),
+		Body:               bytes.NewReader(archive),
+		ContentType:        aws.String("application/zip"),
+		ContentDisposition: aws.String(fmt.Sprintf("attachment; filename=%q", "puzzle-hub-export-"+request.Day+".zip")),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload export: %v", err)
+	}
+
+	req, _ := h.S3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(h.AccountExportBucket),
+		Key:    aws.String(key),
+	})
+	url, err := req.Presign(accountExportLinkTTL)
+	if err != nil {
+		return fmt.Errorf("failed to presign export: %v", err)
+	}
+
+	expires := time.Now().Add(accountExportLinkTTL).UTC().Format("January 2, 2006")
+	body := fmt.Sprintf("Hi %s,\n\nYour Puzzle Hub export is ready. Download it here until %s:\n\n%s\n\n"+
+		"The ZIP has a README.txt describing each file. If you didn't ask for this export, sign out your other sessions in settings.",
+		request.Name, expires, url)
+	_, err = h.SES.SendEmailWithContext(ctx, &ses.SendEmailInput{
+		Source:      aws.String(h.NotificationFromEmail),
+		Destination: &ses.Destination{ToAddresses: []*string{aws.String(request.Email)}},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String("Your Puzzle Hub export is ready"), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(body), Charset: aws.String("UTF-8")},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to email export link: %v", err)
+	}
+
+	h.notify(ctx, Notification{
+		UserID: request.UserID,
+		Kind:   notificationAccountExport,
+		Title:  "Your account export is ready",
+		Body:   "We emailed the download link to " + request.Email + ". It works until " + expires + ".",
+	}, "")
+	log.Printf("📦 Exported account %s (%d bytes) to %s", request.UserID, len(archive), key)
+	return nil
+}
+
+// userItemsQuery queries a table keyed by user_id for all of a user's items
+func userItemsQuery(table, userID string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		TableName:              aws.String(tableName(table)),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	}
+}
+
+func activityResultsCSV(results []ActivityResult) []byte {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"activity", "score", "max_score", "duration_seconds", "created_at"})
+	for _, result := range results {
+		writer.Write([]string{
+			result.Activity,
+			strconv.FormatFloat(result.Score, 'f', -1, 64),
+			strconv.FormatFloat(result.MaxScore, 'f', -1, 64),
+			strconv.Itoa(result.DurationSeconds),
+			result.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	return buf.Bytes()
+}
+
+// logEntriesCSV writes one row per entry and one column per field, in the
+// fields' display order
+func logEntriesCSV(fields []LogField, entries []LogEntry) []byte {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	header := []string{"id", "entry_date", "created_at"}
+	for _, field := range fields {
+		header = append(header, field.FieldName)
+	}
+	writer.Write(header)
+
+	for _, entry := range entries {
+		row := []string{entry.ID, entry.EntryDate, entry.CreatedAt.UTC().Format(time.RFC3339)}
+		for _, field := range fields {
+			row = append(row, exportValue(entry.Values[field.FieldName]))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return buf.Bytes()
+}
+
+// exportValue formats an entry value for a CSV cell
+func exportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = exportValue(item)
+		}
+		return strings.Join(items, "; ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// Admin console
+//
+// Everything under /admin requires the admin role. List endpoints take
+// ?limit= (default 50, at most 200) and ?offset= and answer with the page of
+// items under their usual key, the page's count, and a "page" object:
+//
+//	{"feedback": [...], "count": 50, "page": {"limit": 50, "offset": 0, "total": 130, "next_offset": 50}}
+//
+// next_offset is left out on the last page. The lists are built in memory
+// (they come from scans or small tables), so offsets are stable as long as
+// nothing is added in between.
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 200
+)
+
+type pageParams struct {
+	Limit  int
+	Offset int
+}
+
+type PurgeCacheRequest struct {
+	Keys   []string `json:"keys,omitempty"`   // Exact keys, e.g. user-prefs:<id>
+	Prefix string   `json:"prefix,omitempty"` // e.g. log-types: or ai:
+}
+
+// parsePageParams reads ?limit and ?offset, answering 400 when invalid
+func parsePageParams(c *gin.Context) (pageParams, bool) {
+	page := pageParams{Limit: defaultAdminPageSize}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxAdminPageSize {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAdminPageSize))
+			return page, false
+		}
+		page.Limit = limit
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			respondError(c, http.StatusBadRequest, "offset must be a non-negative integer")
+			return page, false
+		}
+		page.Offset = offset
+	}
+	return page, true
+}
+
+// paginate cuts one page out of items and describes it
+func paginate[T any](items []T, page pageParams) ([]T, gin.H) {
+	total := len(items)
+	start := min(page.Offset, total)
+	end := min(start+page.Limit, total)
+
+	meta := gin.H{
+		"limit":  page.Limit,
+		"offset": page.Offset,
+		"total":  total,
+	}
+	if end < total {
+		meta["next_offset"] = end
+	}
+	return items[start:end], meta
+}
+
+// respondPage writes a page of items under key, plus any extra fields
+func respondPage[T any](c *gin.Context, key string, items []T, page pageParams, extra gin.H) {
+	pageItems, meta := paginate(items, page)
+	response := gin.H{
+		key:     pageItems,
+		"count": len(pageItems),
+		"page":  meta,
+	}
+	for name, value := range extra {
+		response[name] = value
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// adminLookupUser gathers what the server knows about one user. There is
+// no user table: the profile comes from the cache and is missing for users
+// who haven't logged in within userProfileTTL.
+func (h *PuzzleHub) adminLookupUser(c *gin.Context) {
+	userID := c.Param("id")
+	ctx := c.Request.Context()
+
+	assignment, err := h.Store.GetUserRole(userID)
+	if err != nil {
+		requestLogger(c).Error("error fetching role", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to look up user")
+		return
+	}
+	prefs, err := h.getUserPreferences(userID)
+	if err != nil {
+		requestLogger(c).Error("error fetching preferences", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to look up user")
+		return
+	}
+	feedback, err := h.Store.ListFeedback(FeedbackFilter{UserID: userID})
+	if err != nil {
+		requestLogger(c).Error("error fetching feedback", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to look up user")
+		return
+	}
+
+	period := time.Now().UTC().Format("2006-01")
+	records, err := h.queryAIUsage(&dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-ai-usage")),
+		IndexName:              aws.String("user_id-index"),
+		KeyConditionExpression: aws.String("user_id = :user_id AND period = :period"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+			":period":  {S: aws.String(period)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching AI usage", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to look up user")
+		return
+	}
+	usage, _, _ := summarizeAIUsage(records)
+
+	response := gin.H{
+		"user_id":         userID,
+		"guest":           isGuestID(userID),
+		"role_assignment": assignment,
+		"preferences":     prefs,
+		"feedback_count":  len(feedback),
+		"ai_usage":        gin.H{"period": period, "totals": usage},
+	}
+	var profile userProfile
+	email := ""
+	if getCachedJSON(ctx, h.Cache, "user_profile", userProfileKey(userID), &profile) {
+		response["profile"] = profile
+		email = profile.Email
+	}
+	response["effective_role"] = h.resolveRole(userID, email)
+	c.JSON(http.StatusOK, response)
+}
+
+// adminPurgeCache drops cached values: the given keys, everything under a
+// prefix, or with neither, the whole cache. With the memory backend only
+// this instance's cache is purged.
+func (h *PuzzleHub) adminPurgeCache(c *gin.Context) {
+	var request PurgeCacheRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondBindError(c, err)
+			return
+		}
+	}
+	ctx := c.Request.Context()
+
+	purged := 0
+	if len(request.Keys) > 0 {
+		if err := h.Cache.Delete(ctx, request.Keys...); err != nil {
+			requestLogger(c).Error("error purging cache keys", "error", err)
+			respondStorageError(c, err, "Failed to purge cache")
+			return
+		}
+		purged += len(request.Keys)
+	}
+	if request.Prefix != "" || len(request.Keys) == 0 {
+		count, err := h.Cache.Purge(ctx, request.Prefix)
+		if err != nil {
+			requestLogger(c).Error("error purging cache prefix", "error", err, "prefix", request.Prefix)
+			respondStorageError(c, err, "Failed to purge cache")
+			return
+		}
+		purged += count
+	}
+
+	scope := "all"
+	switch {
+	case request.Prefix != "" && len(request.Keys) > 0:
+		scope = "keys and prefix " + request.Prefix
+	case request.Prefix != "":
+		scope = "prefix " + request.Prefix
+	case len(request.Keys) > 0:
+		scope = strings.Join(request.Keys, ", ")
+	}
+	requestLogger(c).Info("purged cache", "scope", scope, "purged", purged)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Cache purged",
+		"purged":  purged,
+	})
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Materialized log analytics.
+//
+// Every entry write adjusts a handful of counter buckets in Storage
+// (puzzle-hub-log-aggregates on DynamoDB) so analytics never has to read
+// raw entries:
+//
+//	total                 all entries of the log type
+//	month#2025-01         entries dated in that month
+//	week#2025-W01         entries dated in that ISO week
+//	day#2025-01-02        entries dated on that day
+//
+// Each bucket holds "count" plus "sum:<field>" and "num:<field>" for every
+// numeric value, which is enough to serve sums and averages. The total
+// bucket also carries "initialized"; buckets for log types that predate this
+// table are rebuilt from the entries the first time they are read.
+//
+// GET /logs/analytics/:logTypeId serves its counters, monthly trend, daily
+// activity and numeric field totals from these buckets. Only what they
+// can't hold (each day's entries, minimums and maximums, sample values and
+// value frequencies) comes from the last analyticsDetailDays of entries.
+// Log alerts and dashboards read the week buckets.
+
+const (
+	aggregateTotalBucket = "total"
+	analyticsDetailDays  = 90 // Days of raw entries read for per-entry detail
+
+	aggregateCountAttr       = "count"
+	aggregateInitializedAttr = "initialized"
+	aggregateSumPrefix       = "sum:"
+	aggregateNumPrefix       = "num:"
+)
+
+// LogAggregate is one decoded bucket item
+type LogAggregate struct {
+	Bucket string
+	Count  int
+	Sums   map[string]float64
+	Counts map[string]int // Number of entries contributing to each sum
+}
+
+// FieldSummary is the per-field summary attached to MonthlyData
+type FieldSummary struct {
+	Sum     float64 `json:"sum"`
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+func aggregateBuckets(entryDate string) []string {
+	date, err := time.Parse("2006-01-02", entryDate)
+	if err != nil {
+		return []string{aggregateTotalBucket}
+	}
+
+	return []string{
+		aggregateTotalBucket,
+		"month#" + date.Format("2006-01"),
+		weekBucket(date),
+		"day#" + entryDate,
+	}
+}
+
+// weekBucket is the bucket of the ISO week containing day
+func weekBucket(day time.Time) string {
+	year, week := day.ISOWeek()
+	return fmt.Sprintf("week#%04d-W%02d", year, week)
+}
+
+// adjustLogAggregates adds (sign = 1) or removes (sign = -1) an entry's
+// contribution to its aggregate buckets. Failures are logged; a rebuild
+// corrects any drift.
+func (h *PuzzleHub) adjustLogAggregates(entry *LogEntry, sign int) {
+	delta := LogAggregate{Count: sign, Sums: map[string]float64{}, Counts: map[string]int{}}
+	for fieldName, raw := range entry.Values {
+		if value, ok := numericValue(raw); ok {
+			delta.Sums[fieldName] = value * float64(sign)
+			delta.Counts[fieldName] = sign
+		}
+	}
+
+	if err := h.Store.AddLogAggregates(entry.LogTypeID, aggregateBuckets(entry.EntryDate), delta); err != nil {
+		log.Printf("Error updating log aggregates of %s: %v", entry.LogTypeID, err)
+	}
+}
+
+// initializeLogAggregates marks a brand new log type's aggregates as complete
+func (h *PuzzleHub) initializeLogAggregates(logTypeID string) {
+	if err := h.Store.InitializeLogAggregates(logTypeID); err != nil {
+		log.Printf("Error initializing log aggregates for %s: %v", logTypeID, err)
+	}
+}
+
+// getLogAggregates returns the aggregate buckets of a log type from the
+// given bucket key onwards, rebuilding them from raw entries if they were
+// never initialized.
+func (h *PuzzleHub) getLogAggregates(userID, logTypeID, fromBucket string) (map[string]LogAggregate, error) {
+	aggregates, initialized, err := h.Store.ListLogAggregates(logTypeID, fromBucket)
+	if err != nil || initialized {
+		return aggregates, err
+	}
+
+	if err := h.rebuildLogAggregates(userID, logTypeID); err != nil {
+		return nil, err
+	}
+	aggregates, _, err = h.Store.ListLogAggregates(logTypeID, fromBucket)
+	return aggregates, err
+}
+
+// rebuildLogAggregates recomputes every bucket of a log type from its entries
+func (h *PuzzleHub) rebuildLogAggregates(userID, logTypeID string) error {
+	entries, err := h.allLogTypeEntries(userID, logTypeID)
+	if err != nil {
+		return fmt.Errorf("failed to read entries: %v", err)
+	}
+
+	buckets := bucketLogEntries(entries)
+	if err := h.Store.ReplaceLogAggregates(logTypeID, buckets); err != nil {
+		return fmt.Errorf("failed to write aggregates: %v", err)
+	}
+
+	log.Printf("📊 Rebuilt %d aggregate buckets for log type %s from %d entries", len(buckets), logTypeID, len(entries))
+	return nil
+}
+
+// bucketLogEntries computes the aggregate buckets of entries in memory
+func bucketLogEntries(entries []LogEntry) map[string]*LogAggregate {
+	buckets := map[string]*LogAggregate{
+		aggregateTotalBucket: {Bucket: aggregateTotalBucket, Sums: map[string]float64{}, Counts: map[string]int{}},
+	}
+	for _, entry := range entries {
+		for _, bucket := range aggregateBuckets(entry.EntryDate) {
+			agg, ok := buckets[bucket]
+			if !ok {
+				agg = &LogAggregate{Bucket: bucket, Sums: map[string]float64{}, Counts: map[string]int{}}
+				buckets[bucket] = agg
+			}
+			agg.Count++
+			for fieldName, raw := range entry.Values {
+				if value, ok := numericValue(raw); ok {
+					agg.Sums[fieldName] += value
+					agg.Counts[fieldName]++
+				}
+			}
+		}
+	}
+	return buckets
+}
+
+// addAggregateValue applies one stored counter ("count", "sum:<field>" or
+// "num:<field>") to agg
+func addAggregateValue(agg *LogAggregate, name string, number float64) {
+	switch {
+	case name == aggregateCountAttr:
+		agg.Count += int(number)
+	case strings.HasPrefix(name, aggregateSumPrefix):
+		agg.Sums[strings.TrimPrefix(name, aggregateSumPrefix)] += number
+	case strings.HasPrefix(name, aggregateNumPrefix):
+		agg.Counts[strings.TrimPrefix(name, aggregateNumPrefix)] += int(number)
+	}
+}
+
+// summarizeLogAggregates turns a log type's buckets into the LogAnalytics
+// counters. Only fields in numericFields are summarized when it is non-nil.
+func summarizeLogAggregates(aggregates map[string]LogAggregate, loc *time.Location, numericFields map[string]bool) (total, thisMonth, thisWeek int, monthly []MonthlyData) {
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	currentMonth := "month#" + today.Format("2006-01")
+
+	recentDays := make(map[string]bool, 7)
+	for i := 0; i < 7; i++ {
+		recentDays["day#"+today.AddDate(0, 0, -i).Format("2006-01-02")] = true
+	}
+
+	for bucket, agg := range aggregates {
+		switch {
+		case bucket == aggregateTotalBucket:
+			total = agg.Count
+		case bucket == currentMonth:
+			thisMonth = agg.Count
+		case recentDays[bucket]:
+			thisWeek += agg.Count
+		}
+
+		if strings.HasPrefix(bucket, "month#") && agg.Count > 0 {
+			summary := make(map[string]FieldSummary)
+			for fieldName, sum := range agg.Sums {
+				count := agg.Counts[fieldName]
+				if count <= 0 || (numericFields != nil && !numericFields[fieldName]) {
+					continue
+				}
+				summary[fieldName] = FieldSummary{Sum: sum, Average: sum / float64(count), Count: count}
+			}
+			monthly = append(monthly, MonthlyData{
+				Month:   strings.TrimPrefix(bucket, "month#"),
+				Count:   agg.Count,
+				Summary: summary,
+			})
+		}
+	}
+
+	sort.Slice(monthly, func(i, j int) bool {
+		return monthly[i].Month < monthly[j].Month
+	})
+	return total, thisMonth, thisWeek, monthly
+}
+
+// recentAggregatesFrom is the smallest bucket key summarizeLogAggregates
+// needs: the day buckets of the last week sort before every month, week
+// and total bucket.
+func recentAggregatesFrom(loc *time.Location) string {
+	return "day#" + time.Now().In(loc).AddDate(0, 0, -6).Format("2006-01-02")
+}
+
+// analyticsDetailFrom is the first entry date read for per-entry detail
+func analyticsDetailFrom(loc *time.Location) string {
+	return time.Now().In(loc).AddDate(0, 0, -(analyticsDetailDays - 1)).Format("2006-01-02")
+}
+
+// dailyActivityFromAggregates returns each day's count and numeric sums from
+// the day buckets. Days covered by recent also list their entries.
+func dailyActivityFromAggregates(aggregates map[string]LogAggregate, recent []LogEntry, numericFields map[string]bool) map[string]interface{} {
+	entriesByDay := make(map[string][]map[string]interface{})
+	for _, entry := range recent {
+		entriesByDay[entry.EntryDate] = append(entriesByDay[entry.EntryDate], map[string]interface{}{
+			"id":     entry.ID,
+			"values": entry.Values,
+		})
+	}
+
+	dailyActivity := make(map[string]interface{})
+	for bucket, agg := range aggregates {
+		day, ok := strings.CutPrefix(bucket, "day#")
+		if !ok || agg.Count <= 0 {
+			continue
+		}
+		sums := make(map[string]float64)
+		for fieldName, sum := range agg.Sums {
+			if agg.Counts[fieldName] > 0 && numericFields[fieldName] {
+				sums[fieldName] = sum
+			}
+		}
+		entries := entriesByDay[day]
+		if entries == nil {
+			entries = []map[string]interface{}{}
+		}
+		dailyActivity[day] = map[string]interface{}{
+			"count":   agg.Count,
+			"sums":    sums,
+			"entries": entries,
+		}
+	}
+	return dailyActivity
+}
+
+// applyFieldTotals replaces the entry counts, sums and averages of numeric
+// fields, computed from recent entries, with the totals bucket's
+func applyFieldTotals(fieldAnalytics map[string]interface{}, total LogAggregate, numericFields map[string]bool) {
+	for fieldName, stats := range fieldAnalytics {
+		fieldStats, ok := stats.(map[string]interface{})
+		if !ok || !numericFields[fieldName] {
+			continue
+		}
+		fieldStats["total_entries"] = total.Count
+		count := total.Counts[fieldName]
+		fieldStats["filled_entries"] = count
+		if count > 0 {
+			fieldStats["sum"] = total.Sums[fieldName]
+			fieldStats["average"] = total.Sums[fieldName] / float64(count)
+		}
+	}
+}
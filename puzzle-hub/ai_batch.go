@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Concurrent batched generation
+//
+// Requests for many items are split into sub-prompts that runAIBatch fans
+// out over a bounded pool of workers, with the caller merging the results.
+// Every provider call, batched or not, also holds a slot from that
+// provider's limiter while it is in flight, so all requests together stay
+// within AI_CONCURRENCY_<PROVIDER> concurrent calls (defaults in
+// aiDefaultConcurrency). Retry backoff waits don't hold a slot.
+
+const (
+	aiBatchWorkers     = 4
+	spellingBatchSize  = 10 // Words per spelling sub-prompt
+	maxWritingBatch    = 10 // Essays per batch analysis request
+	maxWritingBatchLen = 20000
+)
+
+var aiDefaultConcurrency = map[string]int{
+	"openai":     8,
+	"perplexity": 3,
+	"claude":     4,
+	"gemini":     4,
+}
+
+// aiLimiters holds one concurrency limiter per provider
+type aiLimiters struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// acquire waits for a free slot for provider, returning the function that
+// gives it back
+func (l *aiLimiters) acquire(ctx context.Context, provider string) (func(), error) {
+	l.mu.Lock()
+	if l.slots == nil {
+		l.slots = make(map[string]chan struct{})
+	}
+	slots, ok := l.slots[provider]
+	if !ok {
+		limit := aiDefaultConcurrency[provider]
+		if value, err := strconv.Atoi(os.Getenv("AI_CONCURRENCY_" + strings.ToUpper(provider))); err == nil && value > 0 {
+			limit = value
+		}
+		if limit <= 0 {
+			limit = 1
+		}
+		slots = make(chan struct{}, limit)
+		l.slots[provider] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runAIBatch calls run for jobs 0..jobs-1 on up to workers goroutines and
+// returns each job's error. Jobs not yet started when ctx is done fail
+// with ctx's error.
+func runAIBatch(ctx context.Context, jobs, workers int, run func(ctx context.Context, job int) error) []error {
+	errs := make([]error, jobs)
+	if workers > jobs {
+		workers = jobs
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range next {
+				if err := ctx.Err(); err != nil {
+					errs[job] = err
+					continue
+				}
+				errs[job] = run(ctx, job)
+			}
+		}()
+	}
+	for job := 0; job < jobs; job++ {
+		next <- job
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// spellingLetterRange gives batch i of n its own slice of the alphabet so
+// concurrent sub-prompts don't all pick the same words
+func spellingLetterRange(i, n int) (byte, byte) {
+	from := byte('A' + i*26/n)
+	to := byte('A' + (i+1)*26/n - 1)
+	return from, to
+}
+
+// generateSpellingBatch asks for criteria.WordCount problems, split into
+// concurrent sub-prompts of up to spellingBatchSize words, and merges them
+// without duplicates. It fails only if every sub-prompt failed.
+func (h *PuzzleHub) generateSpellingBatch(ctx context.Context, criteria GenerationCriteria, userID string, choice promptChoice) ([]SpellingProblem, error) {
+	batches := (criteria.WordCount + spellingBatchSize - 1) / spellingBatchSize
+	if batches < 1 {
+		batches = 1
+	}
+
+	generation := newAIGeneration()
+	call := aiCall{Feature: "spelling", UserID: userID, Prompt: choice.PromptTag, Temperature: criteria.Temperature, Generation: generation}
+	results := make([][]SpellingProblem, batches)
+	errs := runAIBatch(ctx, batches, aiBatchWorkers, func(ctx context.Context, i int) error {
+		sub := criteria
+		sub.WordCount = spellingBatchSize
+		if i == batches-1 {
+			sub.WordCount = criteria.WordCount - i*spellingBatchSize
+		}
+		prompt := h.buildSpellingPrompt(sub, choice)
+		if batches > 1 {
+			from, to := spellingLetterRange(i, batches)
+			prompt += fmt.Sprintf("\n\nFor variety, only use words whose first letter is between %c and %c.", from, to)
+		}
+
+		var generated struct {
+			Problems []SpellingProblem `json:"problems"`
+		}
+		err := h.generateJSON(ctx, prompt, call, spellingSchema, &generated, func() error {
+			return validateSpellingProblems(generated.Problems)
+		})
+		results[i] = generated.Problems
+		return err
+	})
+
+	var problems []SpellingProblem
+	seen := make(map[string]bool)
+	var lastErr error
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("⚠️  Spelling batch %d/%d failed: %v", i+1, batches, err)
+			lastErr = err
+			continue
+		}
+		for _, problem := range results[i] {
+			word := strings.ToLower(problem.Word)
+			if !seen[word] {
+				seen[word] = true
+				problem.GenerationID = generation.ID
+				problem.PromptTag = choice.PromptTag
+				problems = append(problems, problem)
+			}
+		}
+	}
+	if len(problems) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	h.saveGeneration(generation, call, choice)
+	return problems, nil
+}
+
+type WritingBatchRequest struct {
+	Essays []WritingAnalysisRequest `json:"essays" binding:"required"`
+}
+
+// analyzeWritingBatch analyzes several essays concurrently; essays the AI
+// couldn't analyze get readability metrics only, like single analyses
+func (h *PuzzleHub) analyzeWritingBatch(c *gin.Context) {
+	var request WritingBatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if len(request.Essays) == 0 || len(request.Essays) > maxWritingBatch {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Send between 1 and %d essays", maxWritingBatch))
+		return
+	}
+	for i, essay := range request.Essays {
+		if essay.GradeLevel < 1 || essay.GradeLevel > 12 {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Essay %d: grade level must be between 1 and 12", i+1))
+			return
+		}
+		if text := strings.TrimSpace(essay.Text); len(text) < 10 || len(text) > maxWritingBatchLen {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Essay %d must be between 10 and %d characters long", i+1, maxWritingBatchLen))
+			return
+		}
+	}
+
+	// Each essay counts against the writing quota
+	if _, ok := h.consumeAIQuota(c, "writing", len(request.Essays)); !ok {
+		return
+	}
+
+	userID := optionalUserID(c)
+	results := make([]*WritingAnalysisResponse, len(request.Essays))
+	runAIBatch(c.Request.Context(), len(request.Essays), aiBatchWorkers, func(ctx context.Context, i int) error {
+		results[i] = h.AnalyzeWriting(ctx, request.Essays[i], userID)
+		return nil
+	})
+
+	fallbacks := 0
+	for i, analysis := range results {
+		if analysis == nil {
+			// Not started before the client went away
+			results[i] = localWritingAnalysis(request.Essays[i])
+		}
+		if results[i].Source == sourceFallback {
+			fallbacks++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"fallbacks": fallbacks,
+	})
+}
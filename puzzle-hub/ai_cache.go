@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AI response cache
+//
+// Responses are cached in puzzle-hub-ai-cache under a hash of the feature
+// and the request parameters that shape the prompt, so identical requests
+// (same age, theme, grade...) reuse an earlier answer instead of paying for
+// another model call. Callers normalize free-text parameters with
+// normalizeCacheParam where case and spacing don't change the answer.
+// Each feature's TTL can be overridden with AI_CACHE_TTL_<FEATURE> as a Go
+// duration ("36h"); 0 disables caching for that feature. Each generation_id
+// in a cached response points back at its entry, so reported content can be
+// pulled from the cache (see content_reports.go).
+
+var aiCacheDefaultTTLs = map[string]time.Duration{
+	"spelling":   24 * time.Hour,
+	"writing":    7 * 24 * time.Hour,
+	"story":      1 * time.Hour,
+	"log_fields": 7 * 24 * time.Hour,
+	"math":       24 * time.Hour,
+}
+
+type AICacheEntry struct {
+	CacheKey  string    `dynamodbav:"cache_key"`
+	Feature   string    `dynamodbav:"feature"`
+	Value     string    `dynamodbav:"value"` // JSON encoded response
+	CreatedAt time.Time `dynamodbav:"created_at"`
+	ExpiresAt int64     `dynamodbav:"expires_at"` // Unix seconds, also the table TTL
+}
+
+// aiCacheTTL returns how long a feature's responses are cached
+func aiCacheTTL(feature string) time.Duration {
+	return featureDurationEnv("AI_CACHE_TTL_", feature, aiCacheDefaultTTLs[feature])
+}
+
+// normalizeCacheParam lowercases and collapses whitespace
+func normalizeCacheParam(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+// normalizeCacheParams normalizes a list whose order doesn't matter
+func normalizeCacheParams(values []string) []string {
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = normalizeCacheParam(value); value != "" {
+			normalized = append(normalized, value)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// aiCacheKey hashes the feature and its parameters. Params should be a
+// struct or map; map keys are encoded sorted, so the key is stable.
+func aiCacheKey(feature string, params interface{}) (string, error) {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cache params: %v", err)
+	}
+	sum := sha256.Sum256(append([]byte(feature+":"), encoded...))
+	return feature + "#" + hex.EncodeToString(sum[:]), nil
+}
+
+// loadAICache decodes a live cached response into out
+func (h *PuzzleHub) loadAICache(ctx context.Context, feature string, params, out interface{}) bool {
+	if _, overridden := aiOverrideFrom(ctx); overridden || aiCacheTTL(feature) == 0 {
+		return false
+	}
+	ctx, span := tracer.Start(ctx, "ai.cache.load", trace.WithAttributes(attribute.String("ai.feature", feature)))
+	hit := false
+	defer func() {
+		observeCacheLookup(feature, hit)
+		span.SetAttributes(attribute.Bool("cache.hit", hit))
+		span.End()
+	}()
+
+	key, err := aiCacheKey(feature, params)
+	if err != nil {
+		log.Printf("Error building AI cache key: %v", err)
+		return false
+	}
+
+	// The shared cache sits in front of the table
+	if data, found, err := h.Cache.Get(ctx, "ai:"+key); err != nil {
+		log.Printf("Error reading AI response from cache: %v", err)
+	} else if found && json.Unmarshal(data, out) == nil {
+		hit = true
+		return true
+	}
+
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-cache")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"cache_key": {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		log.Printf("Error reading AI cache: %v", err)
+		return false
+	}
+	if result.Item == nil {
+		return false
+	}
+
+	var entry AICacheEntry
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &entry); err != nil {
+		log.Printf("Error unmarshaling AI cache entry: %v", err)
+		return false
+	}
+	// TTL deletion lags, so expired entries may still be returned
+	remaining := time.Until(time.Unix(entry.ExpiresAt, 0))
+	if remaining <= 0 {
+		return false
+	}
+	if err := json.Unmarshal([]byte(entry.Value), out); err != nil {
+		log.Printf("Error decoding AI cache entry: %v", err)
+		return false
+	}
+	if err := h.Cache.Set(ctx, "ai:"+key, []byte(entry.Value), remaining); err != nil {
+		log.Printf("Error writing AI response to cache: %v", err)
+	}
+
+	hit = true
+	return true
+}
+
+// storeAICache caches a response for the feature's TTL
+func (h *PuzzleHub) storeAICache(ctx context.Context, feature string, params, value interface{}) {
+	ttl := aiCacheTTL(feature)
+	if _, overridden := aiOverrideFrom(ctx); overridden || ttl == 0 {
+		return
+	}
+	// Keep the write going if the client has gone away meanwhile
+	ctx, span := tracer.Start(context.WithoutCancel(ctx), "ai.cache.store", trace.WithAttributes(attribute.String("ai.feature", feature)))
+	defer span.End()
+
+	key, err := aiCacheKey(feature, params)
+	if err != nil {
+		log.Printf("Error building AI cache key: %v", err)
+		return
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Error encoding AI cache entry: %v", err)
+		return
+	}
+
+	if err := h.Cache.Set(ctx, "ai:"+key, encoded, ttl); err != nil {
+		log.Printf("Error writing AI response to cache: %v", err)
+	}
+
+	now := time.Now()
+	item, err := dynamodbattribute.MarshalMap(AICacheEntry{
+		CacheKey:  key,
+		Feature:   feature,
+		Value:     string(encoded),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		log.Printf("Error marshaling AI cache entry: %v", err)
+		return
+	}
+	if _, err := h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-cache")),
+		Item:      item,
+	}); err != nil {
+		log.Printf("Error saving AI cache entry: %v", err)
+		return
+	}
+	h.indexCachedGenerations(ctx, key, encoded, now.Add(ttl).Unix())
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Graceful degradation
+//
+// When AI is unavailable (no budget, circuit open, timeouts, invalid or
+// moderated replies) each feature falls back to something built in rather
+// than failing:
+//
+//	spelling    curated word bank (fallbackSpellingWords)
+//	yohaku hint rule-based solver over the grid sent by the client
+//	story       canned prompt library (fallbackStories); titles for saved
+//	            stories from the names and words they use most
+//	writing     local readability metrics only
+//	log fields  generic field sets by log type
+//	math        templated word problems; explanations from the expression
+//	quiz        none; the question bank is left as it was
+//	remediation sentences from the word bank or the question's explanation,
+//	            spelling hints that break the word into chunks
+//
+// Every response says where it came from in "source": "ai" for model
+// output, cached or not, and "fallback" for the built-in content.
+
+const (
+	sourceAI       = "ai"
+	sourceFallback = "fallback"
+)
+
+type fallbackWord struct {
+	Word       string
+	Definition string
+	Sentence   string
+}
+
+// fallbackSpellingWords is a curated bank per difficulty level, all words
+// at least 6 letters like the AI ones
+var fallbackSpellingWords = map[string][]fallbackWord{
+	"elementary": {
+		{"rabbit", "A small animal with long ears and a fluffy tail", "The rabbit hopped across the garden."},
+		{"turtle", "A reptile that carries a hard shell on its back", "The turtle slowly crossed the road."},
+		{"butter", "A soft yellow food made from cream", "She spread butter on her toast."},
+		{"castle", "A large strong building where kings and queens lived", "The knight rode up to the castle gate."},
+		{"garden", "A piece of land where flowers or vegetables grow", "We planted tomatoes in the garden."},
+		{"pencil", "A tool for writing or drawing", "He sharpened his pencil before the test."},
+		{"school", "A place where children go to learn", "Our school has a big playground."},
+		{"friend", "Someone you like and enjoy spending time with", "My friend shared her lunch with me."},
+		{"family", "A group of people related to each other", "My family eats dinner together."},
+		{"yellow", "The color of a ripe banana", "The yellow sun shone brightly."},
+		{"kitten", "A young cat", "The kitten played with a ball of yarn."},
+		{"basket", "A container woven from thin strips, used for carrying things", "She filled the basket with apples."},
+	},
+	"middle": {
+		{"elephant", "A very large gray animal with a long trunk", "The elephant sprayed water with its trunk."},
+		{"bicycle", "A vehicle with two wheels that you pedal", "He rides his bicycle to school."},
+		{"mountain", "A very high hill", "We climbed to the top of the mountain."},
+		{"computer", "An electronic machine that stores and processes information", "She wrote her report on the computer."},
+		{"adventure", "An exciting or unusual experience", "Camping in the woods was a real adventure."},
+		{"beautiful", "Very pleasing to look at", "The sunset was beautiful tonight."},
+		{"mystery", "Something that is hard to explain or understand", "Where the cookies went was a mystery."},
+		{"journey", "A trip from one place to another", "The journey across the country took three days."},
+		{"crystal", "A clear, hard mineral that looks like glass", "The crystal sparkled in the sunlight."},
+		{"thunder", "The loud noise that follows lightning", "The thunder made the dog hide under the bed."},
+		{"library", "A place where books are kept for people to borrow", "I borrowed two books from the library."},
+		{"science", "The study of the natural world through observation and experiment", "Science class is my favorite."},
+	},
+	"intermediate": {
+		{"magnificent", "Extremely beautiful or impressive", "The palace had a magnificent ballroom."},
+		{"extraordinary", "Very unusual or remarkable", "She has an extraordinary talent for music."},
+		{"responsibility", "A duty to take care of something or someone", "Feeding the dog is my responsibility."},
+		{"entrepreneur", "A person who starts and runs a business", "The young entrepreneur opened a bakery."},
+		{"sophisticated", "Complex and advanced, or refined", "The robot has a sophisticated design."},
+		{"unprecedented", "Never having happened before", "The team had an unprecedented winning streak."},
+		{"revolutionary", "Involving a great or complete change", "The invention was revolutionary for its time."},
+		{"environment", "The natural world around us", "We should protect the environment."},
+		{"temperature", "How hot or cold something is", "The temperature dropped below freezing."},
+		{"imagination", "The ability to create pictures and ideas in your mind", "Writers use their imagination to tell stories."},
+		{"necessary", "Needed or required", "Water is necessary for all living things."},
+		{"archaeologist", "A scientist who studies objects from the past", "The archaeologist found an ancient pot."},
+	},
+	"advanced": {
+		{"pneumonia", "A serious infection of the lungs", "He stayed in the hospital with pneumonia."},
+		{"conscientious", "Careful to do things well and thoroughly", "She is a conscientious student who checks her work."},
+		{"acquiesce", "To accept something without arguing", "He decided to acquiesce to the new rules."},
+		{"perspicacious", "Quick to notice and understand things", "The perspicacious detective solved the case."},
+		{"onomatopoeia", "A word that imitates the sound it describes", "Buzz is an example of onomatopoeia."},
+		{"rhythm", "A regular, repeated pattern of sounds or movements", "The drummer kept a steady rhythm."},
+		{"bureaucracy", "A system of government with many rules and officials", "The bureaucracy slowed down the project."},
+		{"camaraderie", "Trust and friendship among people in a group", "The team's camaraderie helped them win."},
+		{"idiosyncrasy", "A habit or way of behaving that is unusual", "Humming while reading is his idiosyncrasy."},
+		{"silhouette", "The dark outline of someone or something against light", "We saw the silhouette of a cat in the window."},
+		{"mischievous", "Playfully causing small trouble", "The mischievous puppy hid my shoe."},
+		{"liaison", "A person who helps groups communicate", "She acted as liaison between the two teams."},
+	},
+}
+
+// fallbackStories is the canned prompt library per story request type
+var fallbackStories = map[string][]string{
+	"prompt": {
+		"You find a door in your school that was never there before. It is slightly open, and you can hear music coming from the other side. What do you do?",
+		"Your pet wakes you up in the middle of the night and says, \"We need to talk.\" Write what happens next.",
+		"A hot air balloon lands in your backyard with nobody inside, only a map and a note that says \"Finish the journey.\"",
+	},
+	"character": {
+		"Meet Juniper, a shy inventor who builds gadgets out of things other people throw away. She dreams of entering the city science fair but is afraid to speak in front of crowds.",
+		"Meet Captain Bramble, a retired pirate who now runs a bakery. He still talks like a sailor and hides treasure maps inside his muffins.",
+		"Meet Pip, a tiny dragon who can't breathe fire, only bubbles. Pip wants to prove that being different can be a superpower.",
+	},
+	"plot": {
+		"A group of friends discover that the town's clock tower controls the weather. When it stops working, they have one day to fix it before a snowstorm arrives in summer.",
+		"A young chef enters a cooking contest, but someone keeps swapping her ingredients. She must find the trickster and still cook a winning dish.",
+		"Two rival kids are stuck together on a school trip to a museum where the exhibits come alive at closing time.",
+	},
+	"twist": {
+		"The mysterious stranger helping the hero turns out to be the hero from the future.",
+		"The monster everyone was afraid of was only trying to return something it found.",
+		"The treasure map was drawn by the main character's grandmother, and the treasure is a letter to them.",
+	},
+	"setting": {
+		"A floating market in the clouds, where boats sail between islands of fog and merchants sell jars of bottled rainbows.",
+		"An underwater city inside a giant glass dome, lit by glowing fish and connected by bubble elevators.",
+		"A quiet mountain village where every house is built inside a hollow tree and the paths are lit by fireflies.",
+	},
+	"idea": {
+		"Write about a kid who can talk to plants and learns that the old oak tree in the park is worried about something.",
+		"Write about a robot who wants to learn how to tell jokes and practices on everyone it meets.",
+		"Write about a library where the books rearrange themselves each night to tell a brand new story.",
+	},
+}
+
+// generateFallbackSpellingProblems picks words from the curated bank
+func (h *PuzzleHub) generateFallbackSpellingProblems(criteria GenerationCriteria) []SpellingProblem {
+	words := fallbackSpellingWords[criteria.DifficultyLevel]
+	if len(words) == 0 {
+		words = fallbackSpellingWords["middle"]
+	}
+
+	var problems []SpellingProblem
+	for _, i := range rand.Perm(len(words)) {
+		if len(problems) >= criteria.WordCount {
+			break
+		}
+		word := words[i]
+
+		problem := SpellingProblem{
+			Word:       word.Word,
+			Definition: word.Definition,
+			Sentence:   word.Sentence,
+			Difficulty: criteria.DifficultyLevel,
+			AgeGroup:   criteria.AgeGroup,
+		}
+		if criteria.IncludeHints {
+			problem.Hints = []string{
+				fmt.Sprintf("Starts with %s", strings.ToUpper(word.Word[:1])),
+				fmt.Sprintf("Has %d letters", len(word.Word)),
+			}
+		}
+		if criteria.IncludePhonetics {
+			problem.PhoneticGuide = fmt.Sprintf("/%s/", word.Word)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// fallbackStory picks a canned story starter for the request type
+func fallbackStory(req StoryRequest) *StoryResponse {
+	requestType := req.RequestType
+	if _, ok := fallbackStories[requestType]; !ok {
+		requestType = "idea"
+	}
+	options := fallbackStories[requestType]
+	content := options[rand.Intn(len(options))]
+	if req.Genre != "" {
+		content = fmt.Sprintf("%s\n\n(Try telling it as a %s story.)", content, req.Genre)
+	}
+	return &StoryResponse{
+		Content:     content,
+		GeneratedAt: time.Now(),
+		Source:      sourceFallback,
+	}
+}
+
+type ReadabilityMetrics struct {
+	Words                int     `json:"words"`
+	Sentences            int     `json:"sentences"`
+	Paragraphs           int     `json:"paragraphs"`
+	AverageSentenceWords float64 `json:"averageSentenceWords"`
+	LongWords            int     `json:"longWords"` // Three or more syllables
+	FleschReadingEase    float64 `json:"fleschReadingEase"`
+	GradeLevel           float64 `json:"gradeLevel"` // Flesch-Kincaid
+}
+
+// localWritingAnalysis reports readability metrics computed without AI
+func localWritingAnalysis(request WritingAnalysisRequest) *WritingAnalysisResponse {
+	metrics := readabilityMetrics(request.Text)
+
+	summary := fmt.Sprintf("Detailed feedback isn't available right now. Your writing has %d words in %d sentences and reads at about grade %.0f.",
+		metrics.Words, metrics.Sentences, math.Max(1, math.Round(metrics.GradeLevel)))
+	if metrics.AverageSentenceWords > 20 {
+		summary += " Some sentences are long; try splitting them up."
+	}
+
+	return &WritingAnalysisResponse{
+		GrammarErrors:      []GrammarError{},
+		VocabularyTips:     []VocabularyTip{},
+		ContextSuggestions: []ContextSuggestion{},
+		NarrativeAnalysis: NarrativeAnalysis{
+			Strengths:    []string{},
+			Improvements: []string{},
+		},
+		Summary:     summary,
+		Readability: &metrics,
+		Source:      sourceFallback,
+	}
+}
+
+func readabilityMetrics(text string) ReadabilityMetrics {
+	var metrics ReadabilityMetrics
+	for _, paragraph := range strings.Split(text, "\n") {
+		if strings.TrimSpace(paragraph) != "" {
+			metrics.Paragraphs++
+		}
+	}
+	metrics.Sentences = len(strings.FieldsFunc(text, func(r rune) bool {
+		return r == '.' || r == '!' || r == '?'
+	}))
+
+	syllables := 0
+	for _, word := range strings.Fields(text) {
+		word = strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) })
+		if word == "" {
+			continue
+		}
+		metrics.Words++
+		count := countSyllables(word)
+		syllables += count
+		if count >= 3 {
+			metrics.LongWords++
+		}
+	}
+	if metrics.Words == 0 {
+		return metrics
+	}
+	if metrics.Sentences == 0 {
+		metrics.Sentences = 1
+	}
+
+	wordsPerSentence := float64(metrics.Words) / float64(metrics.Sentences)
+	syllablesPerWord := float64(syllables) / float64(metrics.Words)
+	metrics.AverageSentenceWords = math.Round(wordsPerSentence*10) / 10
+	metrics.FleschReadingEase = math.Round((206.835-1.015*wordsPerSentence-84.6*syllablesPerWord)*10) / 10
+	metrics.GradeLevel = math.Round((0.39*wordsPerSentence+11.8*syllablesPerWord-15.59)*10) / 10
+	return metrics
+}
+
+// countSyllables estimates syllables from vowel groups
+func countSyllables(word string) int {
+	word = strings.ToLower(word)
+	count := 0
+	previousVowel := false
+	for _, r := range word {
+		vowel := strings.ContainsRune("aeiouy", r)
+		if vowel && !previousVowel {
+			count++
+		}
+		previousVowel = vowel
+	}
+	if strings.HasSuffix(word, "e") && !strings.HasSuffix(word, "le") && count > 1 {
+		count--
+	}
+	if count == 0 {
+		count = 1
+	}
+	return count
+}
+
+// yohakuHint finds the easiest next step in a partly filled grid: a row
+// or column with a single empty cell, whose value follows from its sum.
+// The hint is written in language.
+func yohakuHint(grid [][]Cell, operation, language string) (string, bool) {
+	size := len(grid) - 1
+	if size < 1 {
+		return "", false
+	}
+	for _, row := range grid {
+		if len(row) != size+1 {
+			return "", false
+		}
+	}
+
+	type line struct {
+		name  string
+		cells func(k int) Cell
+	}
+	var lines []line
+	for i := 0; i < size; i++ {
+		i := i
+		lines = append(lines, line{localize(language, "row %d", i+1), func(k int) Cell { return grid[i][k] }})
+	}
+	for j := 0; j < size; j++ {
+		j := j
+		lines = append(lines, line{localize(language, "column %d", j+1), func(k int) Cell { return grid[k][j] }})
+	}
+
+	for _, l := range lines {
+		empty := -1
+		for k := 0; k < size; k++ {
+			if cell := l.cells(k); !cell.IsGiven && cell.Value == 0 {
+				if empty >= 0 {
+					empty = -2
+					break
+				}
+				empty = k
+			}
+		}
+		if empty < 0 {
+			continue
+		}
+		target := l.cells(size).Value
+		switch operation {
+		case "multiplication":
+			return localize(language, "In %s only one cell is empty. Which number times the others gives %d?", l.name, target), true
+		case "subtraction":
+			return localize(language, "In %s only one cell is empty. Work through the subtraction from left to right to reach %d.", l.name, target), true
+		default:
+			return localize(language, "In %s only one cell is empty. Add up the other numbers and see how far they are from %d.", l.name, target), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AI latency watchdog
+//
+// Tracks the p95 latency of each feature's calls to its provider's usual
+// model over a rolling window (AI_LATENCY_WINDOW, default 5m). When it goes
+// over the feature's threshold (AI_LATENCY_P95_<FEATURE>, or AI_LATENCY_P95
+// for every feature; unset leaves the watchdog off), the feature is
+// degraded: new requests go to the provider's fast model (AI_FAST_MODEL_
+// <PROVIDER>, defaulting to the budget model) except for a small share
+// that keeps probing the usual model. Once its p95 is back under 80% of
+// the threshold, the feature recovers.
+//
+// Every change is logged as an alert, counted in
+// puzzle_hub_ai_latency_events_total and reflected in the
+// puzzle_hub_ai_feature_degraded gauge, which is what to alert on. Recent
+// events and each feature's state are at GET /api/v1/admin/ai-latency.
+// State is per instance; each instance decides from its own calls.
+
+const (
+	defaultAILatencyWindow  = 5 * time.Minute
+	aiLatencyMinSamples     = 20   // Calls in the window before a feature can be degraded
+	aiLatencyRecoverSamples = 5    // Calls in the window before a feature can recover
+	aiLatencyRecoverRatio   = 0.8  // Share of the threshold the p95 must fall under to recover
+	aiLatencyProbeRate      = 0.1  // Share of a degraded feature's requests still sent to the usual model
+	aiLatencyMaxSamples     = 1000 // Per feature, oldest dropped first
+	aiLatencyMaxEvents      = 50
+)
+
+type AILatencyWatchdog struct {
+	mu        sync.Mutex
+	window    time.Duration
+	threshold time.Duration // For features without their own
+	features  map[string]*aiFeatureLatency
+	events    []AILatencyEvent // Newest last
+}
+
+type aiFeatureLatency struct {
+	threshold time.Duration // Zero when the watchdog is off for the feature
+	samples   []aiLatencySample
+	degraded  bool
+	since     time.Time
+}
+
+type aiLatencySample struct {
+	at      time.Time
+	elapsed time.Duration
+}
+
+// AILatencyEvent is a feature being degraded or recovering
+type AILatencyEvent struct {
+	Feature     string    `json:"feature"`
+	Provider    string    `json:"provider"`
+	Event       string    `json:"event"` // degraded or recovered
+	Model       string    `json:"model"` // Fast model while degraded, usual model once recovered
+	P95Ms       int64     `json:"p95_ms"`
+	ThresholdMs int64     `json:"threshold_ms"`
+	Samples     int       `json:"samples"`
+	At          time.Time `json:"at"`
+}
+
+type AILatencyStatus struct {
+	Feature       string     `json:"feature"`
+	ThresholdMs   int64      `json:"threshold_ms"`
+	P95Ms         int64      `json:"p95_ms"`
+	Samples       int        `json:"samples"`
+	Degraded      bool       `json:"degraded"`
+	DegradedSince *time.Time `json:"degraded_since,omitempty"`
+}
+
+func NewAILatencyWatchdog() *AILatencyWatchdog {
+	return &AILatencyWatchdog{
+		window:    featureDurationEnv("AI_LATENCY_WINDOW", "", defaultAILatencyWindow),
+		threshold: featureDurationEnv("AI_LATENCY_P95", "", 0),
+		features:  make(map[string]*aiFeatureLatency),
+	}
+}
+
+// aiFastModel returns the model degraded features use on the provider, or
+// "" when it has none
+func aiFastModel(provider string) string {
+	if model := strings.TrimSpace(os.Getenv("AI_FAST_MODEL_" + strings.ToUpper(provider))); model != "" {
+		return model
+	}
+	return aiBudgetModels[provider]
+}
+
+// isAITimeout reports whether a failed call failed by being too slow
+func isAITimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// feature returns the feature's state, reading its threshold the first
+// time. The caller holds w.mu.
+func (w *AILatencyWatchdog) feature(feature string) *aiFeatureLatency {
+	state, ok := w.features[feature]
+	if !ok {
+		state = &aiFeatureLatency{threshold: featureDurationEnv("AI_LATENCY_P95_", feature, w.threshold)}
+		w.features[feature] = state
+	}
+	return state
+}
+
+// prune drops samples older than the window
+func (f *aiFeatureLatency) prune(now time.Time, window time.Duration) {
+	keep := 0
+	for keep < len(f.samples) && now.Sub(f.samples[keep].at) > window {
+		keep++
+	}
+	f.samples = f.samples[keep:]
+	if len(f.samples) > aiLatencyMaxSamples {
+		f.samples = f.samples[len(f.samples)-aiLatencyMaxSamples:]
+	}
+}
+
+// p95 returns the 95th percentile of the samples
+func (f *aiFeatureLatency) p95() time.Duration {
+	if len(f.samples) == 0 {
+		return 0
+	}
+	durations := make([]time.Duration, len(f.samples))
+	for i, sample := range f.samples {
+		durations[i] = sample.elapsed
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[int(math.Ceil(float64(len(durations))*0.95))-1]
+}
+
+// observe records a call to the provider's usual model and degrades or
+// recovers the feature. Calls to other models, and failures other than
+// timeouts, say nothing about the usual model's latency.
+func (w *AILatencyWatchdog) observe(feature, provider, model string, start time.Time, err error) {
+	if w == nil || model != aiDefaultModels[provider] || (err != nil && !isAITimeout(err)) {
+		return
+	}
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state := w.feature(feature)
+	if state.threshold == 0 {
+		return
+	}
+	state.samples = append(state.samples, aiLatencySample{at: now, elapsed: now.Sub(start)})
+	state.prune(now, w.window)
+
+	p95 := state.p95()
+	switch {
+	case !state.degraded && len(state.samples) >= aiLatencyMinSamples && p95 > state.threshold:
+		state.degraded = true
+		state.since = now
+		w.emit(feature, provider, "degraded", aiFastModel(provider), p95, state)
+	case state.degraded && len(state.samples) >= aiLatencyRecoverSamples &&
+		float64(p95) < float64(state.threshold)*aiLatencyRecoverRatio:
+		state.degraded = false
+		state.since = time.Time{}
+		w.emit(feature, provider, "recovered", model, p95, state)
+	}
+}
+
+// emit raises the alert for a degraded or recovered feature. The caller
+// holds w.mu.
+func (w *AILatencyWatchdog) emit(feature, provider, event, model string, p95 time.Duration, state *aiFeatureLatency) {
+	if event == "degraded" {
+		if model == "" {
+			model = aiDefaultModels[provider] // Nothing faster to switch to
+		}
+		log.Printf("🐢 AI latency alert: %s p95 %v is over %v on %s, routing to %s", feature, p95.Round(time.Millisecond), state.threshold, provider, model)
+		aiFeatureDegraded.WithLabelValues(feature).Set(1)
+	} else {
+		log.Printf("✅ AI latency recovered: %s p95 %v on %s, back to %s", feature, p95.Round(time.Millisecond), provider, model)
+		aiFeatureDegraded.WithLabelValues(feature).Set(0)
+	}
+	aiLatencyEvents.WithLabelValues(feature, event).Inc()
+
+	w.events = append(w.events, AILatencyEvent{
+		Feature:     feature,
+		Provider:    provider,
+		Event:       event,
+		Model:       model,
+		P95Ms:       p95.Milliseconds(),
+		ThresholdMs: state.threshold.Milliseconds(),
+		Samples:     len(state.samples),
+		At:          time.Now(),
+	})
+	if len(w.events) > aiLatencyMaxEvents {
+		w.events = w.events[len(w.events)-aiLatencyMaxEvents:]
+	}
+}
+
+// fastModel returns the model to use instead of the provider's usual one
+// while the feature is degraded, or false to use the usual model
+func (w *AILatencyWatchdog) fastModel(feature, provider string) (string, bool) {
+	if w == nil {
+		return "", false
+	}
+	w.mu.Lock()
+	state, ok := w.features[feature]
+	degraded := ok && state.degraded
+	w.mu.Unlock()
+	if !degraded || rand.Float64() < aiLatencyProbeRate {
+		return "", false
+	}
+	model := aiFastModel(provider)
+	if model == "" || model == aiDefaultModels[provider] {
+		return "", false
+	}
+	return model, true
+}
+
+// status returns each watched feature's state and the recent events
+func (w *AILatencyWatchdog) status() ([]AILatencyStatus, []AILatencyEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+
+	statuses := []AILatencyStatus{}
+	for name, state := range w.features {
+		if state.threshold == 0 {
+			continue
+		}
+		state.prune(now, w.window)
+		status := AILatencyStatus{
+			Feature:     name,
+			ThresholdMs: state.threshold.Milliseconds(),
+			P95Ms:       state.p95().Milliseconds(),
+			Samples:     len(state.samples),
+			Degraded:    state.degraded,
+		}
+		if state.degraded {
+			since := state.since
+			status.DegradedSince = &since
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Feature < statuses[j].Feature })
+
+	events := make([]AILatencyEvent, len(w.events))
+	for i, event := range w.events {
+		events[len(events)-1-i] = event // Newest first
+	}
+	return statuses, events
+}
+
+// recordAICall records the metrics of one AI provider call and feeds the
+// latency watchdog
+func (h *PuzzleHub) recordAICall(call aiCall, provider, model string, start time.Time, err error) {
+	observeAICall(provider, start, err)
+	h.AILatency.observe(call.Feature, provider, model, start, err)
+}
+
+// adminGetAILatency returns the watchdog's view of every feature with a
+// threshold, and its recent alerts
+func (h *PuzzleHub) adminGetAILatency(c *gin.Context) {
+	features, events := h.AILatency.status()
+	c.JSON(http.StatusOK, gin.H{
+		"window_seconds": int(h.AILatency.window.Seconds()),
+		"features":       features,
+		"events":         events,
+	})
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+)
+
+// AI output moderation
+//
+// Every AI response is checked before it reaches a child. When
+// OPENAI_API_KEY is set the OpenAI moderation endpoint is used (whatever
+// provider generated the text), otherwise, or when that call fails, a
+// local word list. AI_MODERATION picks the strictness:
+//
+//	off      no checks
+//	standard OpenAI's own flag, or the severe word list (default)
+//	strict   any category scoring above aiStrictModerationScore, or the
+//	         severe and mild word lists
+//
+// Extra words can be blocked with AI_MODERATION_BLOCKLIST (comma separated).
+// A flagged response is logged to puzzle-hub-moderation-flags for admin
+// review and regenerated up to aiModerationRegenerations times; if every
+// attempt is flagged the call fails with errAIModerationRejected.
+
+const (
+	aiModerationRegenerations = 2
+	aiStrictModerationScore   = 0.2
+	moderationExcerptLength   = 500
+)
+
+var errAIModerationRejected = errors.New("AI response rejected by content moderation")
+
+var moderationSevereWords = []string{
+	"fuck", "fucking", "shit", "bitch", "cunt", "asshole", "bastard", "whore",
+	"slut", "porn", "nude", "naked", "sex", "rape", "suicide", "cocaine", "heroin",
+}
+
+var moderationMildWords = []string{
+	"damn", "hell", "crap", "piss", "stupid", "idiot", "kill", "killed", "murder",
+	"blood", "bloody", "gun", "drunk", "beer", "wine", "cigarette", "gore",
+}
+
+type ModerationFlag struct {
+	FlagID     string     `json:"flag_id" dynamodbav:"flag_id"`
+	Feature    string     `json:"feature" dynamodbav:"feature"`
+	Provider   string     `json:"provider" dynamodbav:"provider"`
+	UserID     string     `json:"user_id,omitempty" dynamodbav:"user_id,omitempty"`
+	Source     string     `json:"source" dynamodbav:"source"` // openai or wordlist
+	Categories []string   `json:"categories" dynamodbav:"categories"`
+	Excerpt    string     `json:"excerpt" dynamodbav:"excerpt"`
+	Attempt    int        `json:"attempt" dynamodbav:"attempt"`
+	Action     string     `json:"action" dynamodbav:"action"` // regenerated or rejected
+	Reviewed   bool       `json:"reviewed" dynamodbav:"reviewed"`
+	ReviewedBy string     `json:"reviewed_by,omitempty" dynamodbav:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty" dynamodbav:"reviewed_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt  int64      `json:"-" dynamodbav:"expires_at"` // Unix seconds, flags are kept 90 days
+}
+
+// moderationVerdict is the outcome of checking one response
+type moderationVerdict struct {
+	Flagged    bool
+	Source     string
+	Categories []string
+}
+
+type AIModerator struct {
+	db         *dynamodb.DynamoDB
+	client     *openai.Client
+	strictness string
+	words      *regexp.Regexp
+}
+
+func NewAIModerator(db *dynamodb.DynamoDB) *AIModerator {
+	m := &AIModerator{db: db, strictness: strings.ToLower(os.Getenv("AI_MODERATION"))}
+	if m.strictness == "" {
+		m.strictness = "standard"
+	}
+	if m.strictness != "off" && m.strictness != "standard" && m.strictness != "strict" {
+		log.Printf("⚠️  Unknown AI_MODERATION %q, using standard", m.strictness)
+		m.strictness = "standard"
+	}
+	if key := os.Getenv("OPENAI_API_KEY"); key != "" {
+		m.client = openai.NewClient(key)
+	}
+
+	words := append([]string{}, moderationSevereWords...)
+	if m.strictness == "strict" {
+		words = append(words, moderationMildWords...)
+	}
+	for _, word := range strings.Split(os.Getenv("AI_MODERATION_BLOCKLIST"), ",") {
+		if word = strings.TrimSpace(strings.ToLower(word)); word != "" {
+			words = append(words, word)
+		}
+	}
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	m.words = regexp.MustCompile(`(?i)\b(` + strings.Join(words, "|") + `)\b`)
+
+	source := "word list"
+	if m.client != nil {
+		source = "OpenAI moderation"
+	}
+	log.Printf("🛡️  AI moderation: %s (%s)", m.strictness, source)
+	return m
+}
+
+// check moderates one response
+func (m *AIModerator) check(ctx context.Context, text string) moderationVerdict {
+	if m.client != nil {
+		verdict, err := m.checkWithOpenAI(ctx, text)
+		if err == nil {
+			return verdict
+		}
+		log.Printf("⚠️  OpenAI moderation failed, using the word list: %v", err)
+	}
+	return m.checkWordList(text)
+}
+
+func (m *AIModerator) checkWithOpenAI(ctx context.Context, text string) (moderationVerdict, error) {
+	resp, err := m.client.Moderations(ctx, openai.ModerationRequest{
+		Input: text,
+		Model: openai.ModerationOmniLatest,
+	})
+	if err != nil {
+		return moderationVerdict{}, err
+	}
+
+	verdict := moderationVerdict{Source: "openai"}
+	for _, result := range resp.Results {
+		scores := result.CategoryScores
+		categories := []struct {
+			name    string
+			flagged bool
+			score   float32
+		}{
+			{"hate", result.Categories.Hate, scores.Hate},
+			{"hate/threatening", result.Categories.HateThreatening, scores.HateThreatening},
+			{"harassment", result.Categories.Harassment, scores.Harassment},
+			{"harassment/threatening", result.Categories.HarassmentThreatening, scores.HarassmentThreatening},
+			{"self-harm", result.Categories.SelfHarm, scores.SelfHarm},
+			{"self-harm/intent", result.Categories.SelfHarmIntent, scores.SelfHarmIntent},
+			{"self-harm/instructions", result.Categories.SelfHarmInstructions, scores.SelfHarmInstructions},
+			{"sexual", result.Categories.Sexual, scores.Sexual},
+			{"sexual/minors", result.Categories.SexualMinors, scores.SexualMinors},
+			{"violence", result.Categories.Violence, scores.Violence},
+			{"violence/graphic", result.Categories.ViolenceGraphic, scores.ViolenceGraphic},
+		}
+		for _, category := range categories {
+			if category.flagged || (m.strictness == "strict" && category.score >= aiStrictModerationScore) {
+				verdict.Categories = append(verdict.Categories, category.name)
+			}
+		}
+		if result.Flagged && len(verdict.Categories) == 0 {
+			verdict.Categories = append(verdict.Categories, "flagged")
+		}
+	}
+	verdict.Flagged = len(verdict.Categories) > 0
+	return verdict, nil
+}
+
+func (m *AIModerator) checkWordList(text string) moderationVerdict {
+	verdict := moderationVerdict{Source: "wordlist"}
+	seen := map[string]bool{}
+	for _, match := range m.words.FindAllString(text, -1) {
+		match = strings.ToLower(match)
+		if !seen[match] {
+			seen[match] = true
+			verdict.Categories = append(verdict.Categories, "word:"+match)
+		}
+	}
+	sort.Strings(verdict.Categories)
+	verdict.Flagged = len(verdict.Categories) > 0
+	return verdict
+}
+
+// logFlag stores a flagged response for admin review in the background
+func (m *AIModerator) logFlag(call aiCall, provider string, verdict moderationVerdict, text string, attempt int, action string) {
+	log.Printf("🛡️  %s response for %s flagged by %s (%s), %s", provider, call.Feature, verdict.Source, strings.Join(verdict.Categories, ", "), action)
+	aiModerationFlags.WithLabelValues(call.Feature, verdict.Source).Inc()
+
+	flagID, err := randomToken(12)
+	if err != nil {
+		flagID = fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	if len(text) > moderationExcerptLength {
+		text = text[:moderationExcerptLength]
+	}
+	flag := ModerationFlag{
+		FlagID:     flagID,
+		Feature:    call.Feature,
+		Provider:   provider,
+		UserID:     call.UserID,
+		Source:     verdict.Source,
+		Categories: verdict.Categories,
+		Excerpt:    text,
+		Attempt:    attempt,
+		Action:     action,
+		CreatedAt:  time.Now(),
+	}
+	flag.ExpiresAt = flag.CreatedAt.Add(90 * 24 * time.Hour).Unix()
+
+	go func() {
+		item, err := dynamodbattribute.MarshalMap(flag)
+		if err != nil {
+			log.Printf("Error marshaling moderation flag: %v", err)
+			return
+		}
+		if _, err := m.db.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-moderation-flags")),
+			Item:      item,
+		}); err != nil {
+			log.Printf("Error saving moderation flag: %v", err)
+		}
+	}()
+}
+
+// allowsChunk reports whether part of a streamed response may be sent
+// before the whole response is checked; only the word list is fast enough
+func (m *AIModerator) allowsChunk(text string) bool {
+	return m.strictness == "off" || !m.checkWordList(text).Flagged
+}
+
+// final checks a complete streamed response. It has already been sent,
+// so a flagged response can only be rejected, not regenerated.
+func (m *AIModerator) final(ctx context.Context, call aiCall, provider, content string) error {
+	if m.strictness == "off" {
+		return nil
+	}
+	verdict := m.check(ctx, content)
+	if !verdict.Flagged {
+		return nil
+	}
+	m.logFlag(call, provider, verdict, content, 1, "rejected")
+	return fmt.Errorf("%w: %s", errAIModerationRejected, call.Feature)
+}
+
+// moderate checks a response and regenerates it while it is flagged
+func (m *AIModerator) moderate(ctx context.Context, call aiCall, provider, prompt, content string, generate func(prompt string) (string, error)) (string, error) {
+	if m.strictness == "off" {
+		return content, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		verdict := m.check(ctx, content)
+		if !verdict.Flagged {
+			return content, nil
+		}
+		if attempt > aiModerationRegenerations {
+			m.logFlag(call, provider, verdict, content, attempt, "rejected")
+			return "", fmt.Errorf("%w: %s", errAIModerationRejected, call.Feature)
+		}
+		m.logFlag(call, provider, verdict, content, attempt, "regenerated")
+
+		var err error
+		content, err = generate(prompt + "\n\nYour previous reply was blocked by the content filter. This is for children: keep every word age-appropriate, with no violence, profanity or adult themes.")
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (h *PuzzleHub) adminListModerationFlags(c *gin.Context) {
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-moderation-flags")),
+	}
+	var filters []string
+	values := map[string]*dynamodb.AttributeValue{}
+	if feature := c.Query("feature"); feature != "" {
+		filters = append(filters, "feature = :feature")
+		values[":feature"] = &dynamodb.AttributeValue{S: aws.String(feature)}
+	}
+	if reviewed := c.Query("reviewed"); reviewed != "" {
+		filters = append(filters, "reviewed = :reviewed")
+		values[":reviewed"] = &dynamodb.AttributeValue{BOOL: aws.Bool(reviewed == "true")}
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+		input.ExpressionAttributeValues = values
+	}
+
+	flags := []ModerationFlag{}
+	var unmarshalErr error
+	err := h.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var batch []ModerationFlag
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &batch); unmarshalErr != nil {
+			return false
+		}
+		flags = append(flags, batch...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		requestLogger(c).Error("error scanning moderation flags", "error", err)
+		respondStorageError(c, err, "Failed to fetch moderation flags")
+		return
+	}
+
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].CreatedAt.After(flags[j].CreatedAt)
+	})
+	respondPage(c, "flags", flags, page, nil)
+}
+
+func (h *PuzzleHub) adminReviewModerationFlag(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	_, err := h.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-moderation-flags")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"flag_id": {S: aws.String(c.Param("id"))},
+		},
+		ConditionExpression: aws.String("attribute_exists(flag_id)"),
+		UpdateExpression:    aws.String("SET reviewed = :true, reviewed_by = :by, reviewed_at = :at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true": {BOOL: aws.Bool(true)},
+			":by":   {S: aws.String(user.ID)},
+			":at":   {S: aws.String(time.Now().Format(time.RFC3339Nano))},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Moderation flag not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error reviewing moderation flag", "error", err)
+		respondStorageError(c, err, "Failed to update moderation flag")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Moderation flag marked as reviewed"})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Per-request AI provider override
+//
+// Admins can force the provider and/or model of any AI-backed request with
+// the X-AI-Provider and X-AI-Model headers (or ?ai_provider= and
+// ?ai_model=), to compare output quality or debug an incident without
+// redeploying with a different AI_PROVIDER. A model without a provider
+// applies to whichever provider the feature uses. The provider needs its
+// API key in the environment even when no feature uses it by default.
+//
+// Overridden requests skip the AI response cache both ways, so they never
+// return, or leave behind, another model's answer. The response carries
+// the override in X-AI-Override (e.g. "provider=claude; model=...") and
+// the generation record the provider and model that were actually used.
+
+const (
+	aiProviderHeader = "X-AI-Provider"
+	aiModelHeader    = "X-AI-Model"
+	aiOverrideHeader = "X-AI-Override"
+)
+
+var aiModelNamePattern = regexp.MustCompile(`^[A-Za-z0-9._:/-]{1,100}$`)
+
+type aiOverride struct {
+	Provider string // Empty to keep the feature's provider
+	Model    string // Empty for the provider's usual model
+}
+
+type aiOverrideKey struct{}
+
+// aiOverrideFrom returns the request's override, if any
+func aiOverrideFrom(ctx context.Context) (aiOverride, bool) {
+	override, ok := ctx.Value(aiOverrideKey{}).(aiOverride)
+	return override, ok
+}
+
+func (o aiOverride) String() string {
+	var parts []string
+	if o.Provider != "" {
+		parts = append(parts, "provider="+o.Provider)
+	}
+	if o.Model != "" {
+		parts = append(parts, "model="+o.Model)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// aiOverrideMiddleware reads the override headers, which only admins may
+// send
+func (h *PuzzleHub) aiOverrideMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		override := aiOverride{
+			Provider: strings.ToLower(strings.TrimSpace(c.GetHeader(aiProviderHeader))),
+			Model:    strings.TrimSpace(c.GetHeader(aiModelHeader)),
+		}
+		if override.Provider == "" {
+			override.Provider = strings.ToLower(strings.TrimSpace(c.Query("ai_provider")))
+		}
+		if override.Model == "" {
+			override.Model = strings.TrimSpace(c.Query("ai_model"))
+		}
+		if override.Provider == "" && override.Model == "" {
+			c.Next()
+			return
+		}
+
+		user, _ := c.Get("user")
+		userObj, _ := user.(*User)
+		if userObj == nil || !h.isAdmin(userObj) {
+			respondError(c, http.StatusForbidden, "Only admins can override the AI provider")
+			c.Abort()
+			return
+		}
+		if override.Provider != "" && !h.providerConfigured(override.Provider) {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("AI provider %q is not configured", override.Provider))
+			c.Abort()
+			return
+		}
+		if override.Model != "" && !aiModelNamePattern.MatchString(override.Model) {
+			respondError(c, http.StatusBadRequest, "Invalid AI model name")
+			c.Abort()
+			return
+		}
+
+		log.Printf("🔀 %s overrides AI for %s %s: %s", userObj.ID, c.Request.Method, c.FullPath(), override)
+		c.Header(aiOverrideHeader, override.String())
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), aiOverrideKey{}, override))
+		c.Next()
+	}
+}
+
+// providerConfigured reports whether the provider's API key was loaded
+func (h *PuzzleHub) providerConfigured(provider string) bool {
+	switch provider {
+	case "openai":
+		return h.OpenAIClient != nil
+	case "perplexity":
+		return h.PerplexityKey != ""
+	case "claude":
+		return h.AnthropicKey != ""
+	case "gemini":
+		return h.GeminiKey != ""
+	}
+	return false
+}
+
+// providerForCall returns the provider for a call, honoring an override
+func (h *PuzzleHub) providerForCall(ctx context.Context, feature string) string {
+	if override, ok := aiOverrideFrom(ctx); ok && override.Provider != "" {
+		return override.Provider
+	}
+	return h.providerFor(feature)
+}
+
+// modelFor returns the model to call on the provider for the feature,
+// honoring an override. The spending limits still apply, and a feature the
+// latency watchdog degraded gets the fast model (see ai_latency.go).
+func (h *PuzzleHub) modelFor(ctx context.Context, feature, provider string) (string, error) {
+	model, err := h.AIUsage.chooseModel(provider)
+	if err != nil {
+		return "", err
+	}
+	if override, ok := aiOverrideFrom(ctx); ok && override.Model != "" && (override.Provider == "" || override.Provider == provider) {
+		return override.Model, nil
+	}
+	if model == aiDefaultModels[provider] {
+		if fast, ok := h.AILatency.fastModel(feature, provider); ok {
+			return fast, nil
+		}
+	}
+	return model, nil
+}
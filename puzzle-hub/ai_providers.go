@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AI provider selection
+//
+// AI_PROVIDER picks the default provider: openai, perplexity, claude or
+// gemini. A feature can use a different one with AI_PROVIDER_<FEATURE>,
+// e.g. AI_PROVIDER_WRITING=claude. Every provider in use needs its API key;
+// keys for other providers are optional and only used by admin overrides
+// (see ai_override.go).
+
+var aiProviderKeyEnv = map[string]string{
+	"openai":     "OPENAI_API_KEY",
+	"perplexity": "PERPLEXITY_API_KEY",
+	"claude":     "ANTHROPIC_API_KEY",
+	"gemini":     "GEMINI_API_KEY",
+}
+
+// aiFeatures are the features that can override the default provider
+var aiFeatures = []string{"spelling", "writing", "story", "log_fields", "math", "quiz"}
+
+// configureAIProviders sets up the default and per-feature providers and
+// their credentials
+func (h *PuzzleHub) configureAIProviders(provider string) error {
+	h.FeatureProviders = make(map[string]string)
+	if demoMode {
+		return nil
+	}
+
+	inUse := map[string]bool{provider: true}
+	for _, feature := range aiFeatures {
+		override := os.Getenv("AI_PROVIDER_" + strings.ToUpper(feature))
+		if override == "" {
+			continue
+		}
+		h.FeatureProviders[feature] = override
+		inUse[override] = true
+	}
+
+	for name := range inUse {
+		keyEnv, ok := aiProviderKeyEnv[name]
+		if !ok {
+			return fmt.Errorf("unknown AI provider %q: must be 'openai', 'perplexity', 'claude' or 'gemini'", name)
+		}
+		apiKey := os.Getenv(keyEnv)
+		if apiKey == "" {
+			return fmt.Errorf("%s environment variable is required for the %s provider", keyEnv, name)
+		}
+		h.setProviderKey(name, apiKey)
+	}
+
+	// Other providers with a key can still be picked by admins per request,
+	// see ai_override.go
+	for name, keyEnv := range aiProviderKeyEnv {
+		apiKey := os.Getenv(keyEnv)
+		if !inUse[name] && apiKey != "" {
+			h.setProviderKey(name, apiKey)
+		}
+	}
+
+	for feature, name := range h.FeatureProviders {
+		log.Printf("🤖 %s uses the %s provider", feature, name)
+	}
+	return nil
+}
+
+// setProviderKey sets up a provider's credentials
+func (h *PuzzleHub) setProviderKey(provider, apiKey string) {
+	switch provider {
+	case "openai":
+		h.OpenAIClient = openai.NewClient(apiKey)
+	case "perplexity":
+		h.PerplexityKey = apiKey
+	case "claude":
+		h.AnthropicKey = apiKey
+	case "gemini":
+		h.GeminiKey = apiKey
+	}
+}
+
+// providerFor returns the provider configured for a feature
+func (h *PuzzleHub) providerFor(feature string) string {
+	if provider, ok := h.FeatureProviders[feature]; ok {
+		return provider
+	}
+	return h.Provider
+}
+
+// aiDefaultTimeouts bound each feature's AI call, retries included. Override
+// with AI_TIMEOUT_<FEATURE> as a Go duration ("2m").
+var aiDefaultTimeouts = map[string]time.Duration{
+	"spelling":   45 * time.Second,
+	"writing":    90 * time.Second,
+	"story":      45 * time.Second,
+	"log_fields": 30 * time.Second,
+	"math":       45 * time.Second,
+	"quiz":       60 * time.Second,
+}
+
+// featureDurationEnv reads <prefix><FEATURE> as a Go duration, falling back
+// to the given default when unset or invalid
+func featureDurationEnv(prefix, feature string, fallback time.Duration) time.Duration {
+	name := prefix + strings.ToUpper(feature)
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Printf("⚠️  Ignoring invalid %s=%q", name, value)
+		return fallback
+	}
+	return duration
+}
+
+// generateAI sends the prompt to the provider configured for call.Feature,
+// retrying transient failures (see ai_retry.go). The call is abandoned when
+// ctx is cancelled, e.g. because the client went away, or the feature's
+// timeout passes.
+func (h *PuzzleHub) generateAI(ctx context.Context, prompt string, call aiCall) (content string, err error) {
+	if demoMode {
+		return "", errAIDemoMode
+	}
+
+	provider := h.providerForCall(ctx, call.Feature)
+	var generate func(context.Context, string, aiCall) (string, error)
+	switch provider {
+	case "openai":
+		generate = h.generateWithOpenAI
+	case "perplexity":
+		generate = h.generateWithPerplexity
+	case "claude":
+		generate = h.generateWithClaude
+	case "gemini":
+		generate = h.generateWithGemini
+	default:
+		return "", fmt.Errorf("invalid AI provider: %s", provider)
+	}
+
+	timeout := featureDurationEnv("AI_TIMEOUT_", call.Feature, aiDefaultTimeouts[call.Feature])
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracer.Start(ctx, "ai.generate", trace.WithAttributes(aiSpanAttributes(call, provider)...))
+	defer func() { endSpan(span, err) }()
+
+	attempt := func(prompt string) (string, error) {
+		return h.withAIRetries(ctx, provider, func() (string, error) {
+			return generate(ctx, prompt, call)
+		})
+	}
+
+	content, err = attempt(prompt)
+	if err != nil {
+		return "", err
+	}
+	return h.Moderation.moderate(ctx, call, provider, prompt, content, attempt)
+}
+
+type claudeRequest struct {
+	Model       string    `json:"model"`
+	MaxTokens   int       `json:"max_tokens"`
+	System      string    `json:"system,omitempty"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+}
+
+type claudeResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+func (h *PuzzleHub) generateWithClaude(ctx context.Context, prompt string, call aiCall) (content string, err error) {
+	model, err := h.modelFor(ctx, call.Feature, "claude")
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	defer func() { h.recordAICall(call, "claude", model, start, err) }()
+
+	request := claudeRequest{
+		Model:       model,
+		MaxTokens:   4096,
+		System:      call.System,
+		Messages:    []Message{{Role: "user", Content: prompt}},
+		Temperature: providerTemperature("claude", call.Temperature),
+	}
+	headers := map[string]string{
+		"x-api-key":         h.AnthropicKey,
+		"anthropic-version": "2023-06-01",
+	}
+
+	var claudeResp claudeResponse
+	if err := h.postAIRequest(ctx, "https://api.anthropic.com/v1/messages", headers, request, &claudeResp); err != nil {
+		return "", err
+	}
+	h.AIUsage.record("claude", model, call, claudeResp.Usage.InputTokens, claudeResp.Usage.OutputTokens)
+
+	var text strings.Builder
+	for _, block := range claudeResp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("no text in response")
+	}
+	return text.String(), nil
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent         `json:"contents"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+// geminiConfig returns the generation config for the call, or nil for the
+// defaults
+func geminiConfig(call aiCall) *geminiGenerationConfig {
+	if temperature := providerTemperature("gemini", call.Temperature); temperature != nil {
+		return &geminiGenerationConfig{Temperature: temperature}
+	}
+	return nil
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (h *PuzzleHub) generateWithGemini(ctx context.Context, prompt string, call aiCall) (content string, err error) {
+	model, err := h.modelFor(ctx, call.Feature, "gemini")
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	defer func() { h.recordAICall(call, "gemini", model, start, err) }()
+
+	request := geminiRequest{
+		Contents:         []geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}},
+		GenerationConfig: geminiConfig(call),
+	}
+	if call.System != "" {
+		request.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: call.System}}}
+	}
+	headers := map[string]string{
+		"x-goog-api-key": h.GeminiKey,
+	}
+
+	var geminiResp geminiResponse
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent", model)
+	if err := h.postAIRequest(ctx, url, headers, request, &geminiResp); err != nil {
+		return "", err
+	}
+	h.AIUsage.record("gemini", model, call, geminiResp.UsageMetadata.PromptTokenCount, geminiResp.UsageMetadata.CandidatesTokenCount)
+
+	if len(geminiResp.Candidates) == 0 {
+		return "", fmt.Errorf("no candidates in response")
+	}
+	var text strings.Builder
+	for _, part := range geminiResp.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String(), nil
+}
+
+// postAIRequest posts a JSON request to a provider API and decodes the
+// JSON response into out
+func (h *PuzzleHub) postAIRequest(ctx context.Context, url string, headers map[string]string, request, out interface{}) error {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := h.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make API call: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return newAIHTTPError(resp, body)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+	return nil
+}
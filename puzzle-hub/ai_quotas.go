@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Daily AI quotas
+//
+// On top of the per-minute AI rate limit, each caller gets a number of AI
+// requests per feature per UTC day, counted in Storage. Callers are keyed
+// like the rate limiter: by user ID when signed in, by client IP otherwise,
+// and guests by client IP too. Defaults are in defaultAIQuotas and can be
+// changed with AI_QUOTA_<FEATURE>_PER_DAY (e.g. AI_QUOTA_WRITING_PER_DAY=10);
+// 0 turns a feature's quota off. Admins can give a user their own limits, and
+// admins themselves are never limited.
+//
+// A request is counted before it runs and refunded if it ends in an error,
+// so invalid requests don't use up the day. Counter errors fail open, like
+// the screen time check.
+
+const aiQuotaRetention = 3 * 24 * time.Hour
+
+// defaultAIQuotas are the daily limits per AI feature (see aiCall.Feature)
+var defaultAIQuotas = map[string]int{
+	"spelling":   20,
+	"writing":    5,
+	"story":      10,
+	"log_fields": 10,
+	"math":       20,
+}
+
+type AIQuotaOverride struct {
+	UserID    string         `json:"user_id" dynamodbav:"user_id"`
+	Limits    map[string]int `json:"limits" dynamodbav:"limits"` // Per feature, 0 for unlimited
+	Reason    string         `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+	UpdatedBy string         `json:"updated_by" dynamodbav:"updated_by"`
+	UpdatedAt time.Time      `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+type UpdateAIQuotaRequest struct {
+	Limits map[string]int `json:"limits" binding:"required"`
+	Reason string         `json:"reason"`
+}
+
+type AIQuotaStatus struct {
+	Feature   string `json:"feature"`
+	Limit     int    `json:"limit"` // 0 for unlimited
+	Used      int    `json:"used"`
+	Remaining int    `json:"remaining,omitempty"`
+	Unlimited bool   `json:"unlimited,omitempty"`
+}
+
+// loadAIQuotas applies AI_QUOTA_<FEATURE>_PER_DAY over the defaults
+func loadAIQuotas() map[string]int {
+	quotas := make(map[string]int, len(defaultAIQuotas))
+	for feature, limit := range defaultAIQuotas {
+		name := "AI_QUOTA_" + strings.ToUpper(feature) + "_PER_DAY"
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				log.Printf("⚠️  Ignoring invalid %s=%q", name, value)
+			} else {
+				limit = parsed
+			}
+		}
+		quotas[feature] = limit
+	}
+	return quotas
+}
+
+// aiQuotaKey identifies the caller, or "" for callers without a quota.
+// Guests count against their IP: anyone can start a new guest session, so
+// a quota per guest would be a fresh quota per session.
+func aiQuotaKey(c *gin.Context) string {
+	if user, ok := c.Get("user"); ok {
+		userObj := user.(*User)
+		if userObj.HasRole(RoleAdmin) {
+			return ""
+		}
+		if userObj.IsGuest {
+			return "ip:" + c.ClientIP()
+		}
+	}
+	return rateLimitKey(c)
+}
+
+func aiQuotaDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// aiQuotaLimits returns the caller's limits, with any admin override
+func (h *PuzzleHub) aiQuotaLimits(ctx context.Context, key string) map[string]int {
+	limits := make(map[string]int, len(h.AIQuotas))
+	for feature, limit := range h.AIQuotas {
+		limits[feature] = limit
+	}
+	userID, isUser := strings.CutPrefix(key, "user:")
+	if !isUser {
+		return limits
+	}
+
+	override, err := h.getAIQuotaOverride(ctx, userID)
+	if err != nil {
+		log.Printf("Error fetching AI quota override for %s: %v", userID, err)
+		return limits
+	}
+	if override != nil {
+		for feature, limit := range override.Limits {
+			limits[feature] = limit
+		}
+	}
+	return limits
+}
+
+// aiQuota counts one request against the feature's daily quota, and gives
+// it back if the handler responds with an error
+func (h *PuzzleHub) aiQuota(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		refund, ok := h.consumeAIQuota(c, feature, 1)
+		if !ok {
+			return
+		}
+		c.Next()
+		if c.Writer.Status() >= http.StatusBadRequest {
+			refund()
+		}
+	}
+}
+
+// consumeAIQuota takes n requests from the caller's quota for feature. Over
+// quota it aborts with 429 and returns false. Handlers that only know n
+// after validating the body (batches) call it themselves; refund gives the
+// requests back.
+func (h *PuzzleHub) consumeAIQuota(c *gin.Context, feature string, n int) (refund func(), ok bool) {
+	noRefund := func() {}
+	key := aiQuotaKey(c)
+	if key == "" {
+		return noRefund, true
+	}
+	limit := h.aiQuotaLimits(c.Request.Context(), key)[feature]
+	if limit == 0 {
+		return noRefund, true
+	}
+
+	now := time.Now()
+	day := aiQuotaDay(now)
+	used, err := h.Store.AddAIQuotaUsage(key, day, feature, n, limit)
+	if errors.Is(err, errStorageConflict) {
+		aiQuotaExceeded.WithLabelValues(feature).Inc()
+		resetsAt := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(resetsAt).Seconds())+1))
+		abortWithError(c, http.StatusTooManyRequests, "quota_exceeded",
+			tr(c, "You've used today's %d %s requests. Your quota resets at midnight UTC.", limit, translate(requestLanguage(c), strings.ReplaceAll(feature, "_", " "))),
+			gin.H{"feature": feature, "limit": limit, "resets_at": resetsAt})
+		return noRefund, false
+	}
+	if err != nil {
+		requestLogger(c).Error("error counting AI quota", "error", err, "key", key)
+		return noRefund, true
+	}
+
+	c.Header("X-Quota-Limit", strconv.Itoa(limit))
+	c.Header("X-Quota-Remaining", strconv.Itoa(max(limit-used, 0)))
+	return func() {
+		if _, err := h.Store.AddAIQuotaUsage(key, day, feature, -n, 0); err != nil {
+			requestLogger(c).Error("error refunding AI quota", "error", err, "key", key)
+		}
+	}, true
+}
+
+// aiQuotaStatus reports the caller's quota for each feature today
+func (h *PuzzleHub) aiQuotaStatus(ctx context.Context, key string) ([]AIQuotaStatus, error) {
+	limits := h.aiQuotaLimits(ctx, key)
+	used := map[string]int{}
+	if key != "" {
+		var err error
+		used, err = h.Store.GetAIQuotaUsage(key, aiQuotaDay(time.Now()))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]AIQuotaStatus, 0, len(limits))
+	for feature, limit := range limits {
+		status := AIQuotaStatus{Feature: feature, Limit: limit, Used: used[feature]}
+		if key == "" || limit == 0 {
+			status.Limit = 0
+			status.Unlimited = true
+		} else {
+			status.Remaining = max(limit-status.Used, 0)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Feature < statuses[j].Feature })
+	return statuses, nil
+}
+
+// getUsage shows the caller's remaining AI quota for today
+func (h *PuzzleHub) getUsage(c *gin.Context) {
+	statuses, err := h.aiQuotaStatus(c.Request.Context(), aiQuotaKey(c))
+	if err != nil {
+		requestLogger(c).Error("error fetching AI quota usage", "error", err)
+		respondStorageError(c, err, "Failed to fetch usage")
+		return
+	}
+	now := time.Now().UTC()
+	c.JSON(http.StatusOK, gin.H{
+		"day":       aiQuotaDay(now),
+		"resets_at": now.Truncate(24 * time.Hour).Add(24 * time.Hour),
+		"quotas":    statuses,
+	})
+}
+
+func (h *PuzzleHub) getAIQuotaOverride(ctx context.Context, userID string) (*AIQuotaOverride, error) {
+	key := "ai-quota-override:" + userID
+	var cached cachedLookup[*AIQuotaOverride]
+	if getCachedJSON(ctx, h.Cache, "ai_quota_override", key, &cached) {
+		return cached.Value, nil
+	}
+
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-quota-overrides")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var override *AIQuotaOverride
+	if result.Item != nil {
+		override = &AIQuotaOverride{}
+		if err := dynamodbattribute.UnmarshalMap(result.Item, override); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal AI quota override: %v", err)
+		}
+	}
+	setCachedJSON(ctx, h.Cache, key, cachedLookup[*AIQuotaOverride]{Found: override != nil, Value: override}, cachedValueTTL)
+	return override, nil
+}
+
+// adminGetUserAIQuota shows a user's quota today and any override
+func (h *PuzzleHub) adminGetUserAIQuota(c *gin.Context) {
+	userID := c.Param("id")
+	override, err := h.getAIQuotaOverride(c.Request.Context(), userID)
+	if err != nil {
+		requestLogger(c).Error("error fetching AI quota override", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to fetch quota")
+		return
+	}
+	statuses, err := h.aiQuotaStatus(c.Request.Context(), "user:"+userID)
+	if err != nil {
+		requestLogger(c).Error("error fetching AI quota usage", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to fetch quota")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  userID,
+		"quotas":   statuses,
+		"override": override,
+		"defaults": h.AIQuotas,
+	})
+}
+
+// adminSetUserAIQuota gives a user their own daily limits for some features
+func (h *PuzzleHub) adminSetUserAIQuota(c *gin.Context) {
+	admin := c.MustGet("user").(*User)
+	userID := c.Param("id")
+
+	var request UpdateAIQuotaRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	for feature, limit := range request.Limits {
+		if _, ok := defaultAIQuotas[feature]; !ok {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Unknown feature %q", feature))
+			return
+		}
+		if limit < 0 {
+			respondError(c, http.StatusBadRequest, "Limits must be 0 (unlimited) or more")
+			return
+		}
+	}
+
+	override := AIQuotaOverride{
+		UserID:    userID,
+		Limits:    request.Limits,
+		Reason:    strings.TrimSpace(request.Reason),
+		UpdatedBy: admin.Email,
+		UpdatedAt: time.Now(),
+	}
+	item, err := dynamodbattribute.MarshalMap(override)
+	if err != nil {
+		requestLogger(c).Error("error marshaling AI quota override", "error", err)
+		respondStorageError(c, err, "Failed to save quota")
+		return
+	}
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-quota-overrides")),
+		Item:      item,
+	})
+	invalidateCache(c.Request.Context(), h.Cache, "ai-quota-override:"+userID)
+	if err != nil {
+		requestLogger(c).Error("error saving AI quota override", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to save quota")
+		return
+	}
+
+	requestLogger(c).Info("set AI quota override", "target_user_id", userID, "limits", request.Limits)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Quota updated",
+		"override": override,
+	})
+}
+
+// adminDeleteUserAIQuota puts a user back on the default limits
+func (h *PuzzleHub) adminDeleteUserAIQuota(c *gin.Context) {
+	userID := c.Param("id")
+	_, err := h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-quota-overrides")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+	})
+	invalidateCache(c.Request.Context(), h.Cache, "ai-quota-override:"+userID)
+	if err != nil {
+		requestLogger(c).Error("error deleting AI quota override", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to reset quota")
+		return
+	}
+
+	requestLogger(c).Info("removed AI quota override", "target_user_id", userID)
+	c.JSON(http.StatusOK, gin.H{"message": "Quota reset to defaults"})
+}
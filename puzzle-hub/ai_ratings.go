@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Ratings of AI output
+//
+// Each generated artifact (spelling set, writing analysis, story, field
+// suggestions) carries a generation_id. Its record in
+// puzzle-hub-ai-generations says which provider, model and prompt version
+// produced it, so a thumbs up or down through POST /api/ai/rate can be
+// compared across models and prompts in /api/admin/ai-ratings. Cached
+// results keep their original generation_id. Generations are kept 90 days;
+// ratings on prompt experiments also count towards the variant's stats.
+
+const aiGenerationRetention = 90 * 24 * time.Hour
+
+// aiGeneration collects the provider and model used while generating one
+// artifact; AIUsageTracker.record fills it in
+type aiGeneration struct {
+	ID string
+
+	mu       sync.Mutex
+	provider string
+	model    string
+}
+
+func newAIGeneration() *aiGeneration {
+	id, err := randomToken(12)
+	if err != nil {
+		id = fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return &aiGeneration{ID: id}
+}
+
+func (g *aiGeneration) setModel(provider, model string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.provider = provider
+	g.model = model
+}
+
+type AIGenerationRecord struct {
+	GenerationID  string    `json:"generation_id" dynamodbav:"generation_id"`
+	Feature       string    `json:"feature" dynamodbav:"feature"`
+	UserID        string    `json:"user_id,omitempty" dynamodbav:"user_id,omitempty"`
+	Provider      string    `json:"provider" dynamodbav:"provider"`
+	Model         string    `json:"model" dynamodbav:"model"`
+	Prompt        string    `json:"prompt" dynamodbav:"prompt"` // Template name
+	PromptVersion int       `json:"prompt_version" dynamodbav:"prompt_version"`
+	Experiment    string    `json:"experiment,omitempty" dynamodbav:"experiment,omitempty"`
+	Variant       string    `json:"variant,omitempty" dynamodbav:"variant,omitempty"`
+	Temperature   *float64  `json:"temperature,omitempty" dynamodbav:"temperature,omitempty"` // Only when the request chose one
+	CreatedAt     time.Time `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt     int64     `json:"-" dynamodbav:"expires_at"`
+}
+
+type AIRating struct {
+	GenerationID  string    `json:"generation_id" dynamodbav:"generation_id"`
+	UserID        string    `json:"user_id" dynamodbav:"user_id"`
+	ThumbsUp      bool      `json:"thumbs_up" dynamodbav:"thumbs_up"`
+	Comment       string    `json:"comment,omitempty" dynamodbav:"comment,omitempty"`
+	Feature       string    `json:"feature" dynamodbav:"feature"`
+	Provider      string    `json:"provider" dynamodbav:"provider"`
+	Model         string    `json:"model" dynamodbav:"model"`
+	Prompt        string    `json:"prompt" dynamodbav:"prompt"`
+	PromptVersion int       `json:"prompt_version" dynamodbav:"prompt_version"`
+	Experiment    string    `json:"experiment,omitempty" dynamodbav:"experiment,omitempty"`
+	Variant       string    `json:"variant,omitempty" dynamodbav:"variant,omitempty"`
+	Temperature   *float64  `json:"temperature,omitempty" dynamodbav:"temperature,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+type AIRatingRequest struct {
+	GenerationID string `json:"generation_id" binding:"required"`
+	ThumbsUp     *bool  `json:"thumbs_up" binding:"required"`
+	Comment      string `json:"comment"`
+}
+
+// saveGeneration records which provider, model and prompt produced an
+// artifact, in the background
+func (h *PuzzleHub) saveGeneration(generation *aiGeneration, call aiCall, choice promptChoice) {
+	generation.mu.Lock()
+	record := AIGenerationRecord{
+		GenerationID:  generation.ID,
+		Feature:       call.Feature,
+		UserID:        call.UserID,
+		Provider:      generation.provider,
+		Model:         generation.model,
+		Prompt:        choice.Name,
+		PromptVersion: choice.Version,
+		Experiment:    choice.Experiment,
+		Variant:       choice.Variant,
+		Temperature:   providerTemperature(generation.provider, call.Temperature),
+		CreatedAt:     time.Now(),
+	}
+	generation.mu.Unlock()
+	record.ExpiresAt = record.CreatedAt.Add(aiGenerationRetention).Unix()
+
+	go func() {
+		item, err := dynamodbattribute.MarshalMap(record)
+		if err != nil {
+			log.Printf("Error marshaling AI generation: %v", err)
+			return
+		}
+		if _, err := h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-ai-generations")),
+			Item:      item,
+		}); err != nil {
+			log.Printf("Error saving AI generation %s: %v", record.GenerationID, err)
+		}
+	}()
+}
+
+// rateAIOutput records the user's thumbs up or down for a generated
+// artifact, replacing any earlier rating of it
+func (h *PuzzleHub) rateAIOutput(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+
+	var request AIRatingRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if len(request.Comment) > 1000 {
+		respondError(c, http.StatusBadRequest, "Comment must be 1000 characters or fewer")
+		return
+	}
+
+	result, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-generations")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"generation_id": {S: aws.String(request.GenerationID)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching AI generation", "error", err)
+		respondStorageError(c, err, "Failed to save rating")
+		return
+	}
+	if result.Item == nil {
+		respondError(c, http.StatusNotFound, "Generated content not found")
+		return
+	}
+	var generation AIGenerationRecord
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &generation); err != nil {
+		requestLogger(c).Error("error unmarshaling AI generation", "error", err)
+		respondStorageError(c, err, "Failed to save rating")
+		return
+	}
+
+	rating := AIRating{
+		GenerationID:  generation.GenerationID,
+		UserID:        user.ID,
+		ThumbsUp:      *request.ThumbsUp,
+		Comment:       request.Comment,
+		Feature:       generation.Feature,
+		Provider:      generation.Provider,
+		Model:         generation.Model,
+		Prompt:        generation.Prompt,
+		PromptVersion: generation.PromptVersion,
+		Experiment:    generation.Experiment,
+		Variant:       generation.Variant,
+		Temperature:   generation.Temperature,
+		UpdatedAt:     time.Now(),
+	}
+	item, err := dynamodbattribute.MarshalMap(rating)
+	if err != nil {
+		requestLogger(c).Error("error marshaling AI rating", "error", err)
+		respondStorageError(c, err, "Failed to save rating")
+		return
+	}
+	previous, err := h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName:    aws.String(tableName("puzzle-hub-ai-ratings")),
+		Item:         item,
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if err != nil {
+		requestLogger(c).Error("error saving AI rating", "error", err)
+		respondStorageError(c, err, "Failed to save rating")
+		return
+	}
+
+	// Experiment stats count each user's current rating once
+	tag := PromptTag{Experiment: generation.Experiment, Variant: generation.Variant}
+	var old AIRating
+	hadRating := len(previous.Attributes) > 0 && dynamodbattribute.UnmarshalMap(previous.Attributes, &old) == nil
+	if !hadRating || old.ThumbsUp != rating.ThumbsUp {
+		if hadRating {
+			h.Prompts.recordExperimentDelta(tag, thumbsCounter(old.ThumbsUp), -1)
+		}
+		h.Prompts.recordExperimentDelta(tag, thumbsCounter(rating.ThumbsUp), 1)
+	}
+
+	log.Printf("👍 %s rated %s generation %s (thumbs up: %t)", user.ID, generation.Feature, generation.GenerationID, rating.ThumbsUp)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"rating":  rating,
+	})
+}
+
+func thumbsCounter(thumbsUp bool) string {
+	if thumbsUp {
+		return "thumbs_up"
+	}
+	return "thumbs_down"
+}
+
+type AIRatingSummary struct {
+	Feature       string  `json:"feature"`
+	Provider      string  `json:"provider"`
+	Model         string  `json:"model"`
+	Prompt        string  `json:"prompt"`
+	PromptVersion int     `json:"prompt_version"`
+	ThumbsUp      int     `json:"thumbs_up"`
+	ThumbsDown    int     `json:"thumbs_down"`
+	ThumbsUpRate  float64 `json:"thumbs_up_rate"`
+}
+
+// adminGetAIRatings summarizes ratings per feature, provider, model and
+// prompt version, optionally for one feature
+func (h *PuzzleHub) adminGetAIRatings(c *gin.Context) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-ratings")),
+	}
+	if feature := c.Query("feature"); feature != "" {
+		input.FilterExpression = aws.String("feature = :feature")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":feature": {S: aws.String(feature)},
+		}
+	}
+
+	summaries := make(map[string]*AIRatingSummary)
+	var unmarshalErr error
+	err := h.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var ratings []AIRating
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &ratings); unmarshalErr != nil {
+			return false
+		}
+		for _, rating := range ratings {
+			key := fmt.Sprintf("%s|%s|%s|%s|%d", rating.Feature, rating.Provider, rating.Model, rating.Prompt, rating.PromptVersion)
+			summary, ok := summaries[key]
+			if !ok {
+				summary = &AIRatingSummary{
+					Feature:       rating.Feature,
+					Provider:      rating.Provider,
+					Model:         rating.Model,
+					Prompt:        rating.Prompt,
+					PromptVersion: rating.PromptVersion,
+				}
+				summaries[key] = summary
+			}
+			if rating.ThumbsUp {
+				summary.ThumbsUp++
+			} else {
+				summary.ThumbsDown++
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		requestLogger(c).Error("error scanning AI ratings", "error", err)
+		respondStorageError(c, err, "Failed to fetch AI ratings")
+		return
+	}
+
+	result := make([]*AIRatingSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		summary.ThumbsUpRate = float64(summary.ThumbsUp) / float64(summary.ThumbsUp+summary.ThumbsDown)
+		result = append(result, summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Feature != result[j].Feature {
+			return result[i].Feature < result[j].Feature
+		}
+		return result[i].ThumbsUpRate > result[j].ThumbsUpRate
+	})
+
+	c.JSON(http.StatusOK, gin.H{"ratings": result})
+}
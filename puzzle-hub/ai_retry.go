@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Retries and circuit breaking for AI calls
+//
+// generateAI retries rate limits (429), provider 5xx errors and network
+// timeouts with exponential backoff and full jitter, honouring Retry-After
+// when the provider sends one. Each provider has a circuit breaker: after
+// aiBreakerThreshold consecutive retryable failures it opens and calls fail
+// fast with errAICircuitOpen for aiBreakerCooldown, then a single trial call
+// decides whether it closes again. Client errors such as a bad API key are
+// returned at once and don't count against the breaker.
+
+const (
+	aiMaxAttempts      = 3
+	aiBaseBackoff      = 1 * time.Second
+	aiMaxBackoff       = 10 * time.Second
+	aiBreakerThreshold = 5
+	aiBreakerCooldown  = 30 * time.Second
+)
+
+var errAICircuitOpen = errors.New("AI provider temporarily unavailable")
+
+// aiHTTPError is a non-200 response from a provider API
+type aiHTTPError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // From the Retry-After header, 0 if absent
+}
+
+func (e *aiHTTPError) Error() string {
+	return fmt.Sprintf("API call failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+func newAIHTTPError(resp *http.Response, body []byte) *aiHTTPError {
+	err := &aiHTTPError{StatusCode: resp.StatusCode, Body: string(body)}
+	if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
+}
+
+// isRetryableAIError reports whether a failed call may succeed if repeated
+func isRetryableAIError(err error) bool {
+	if errors.Is(err, errAIStreamInterrupted) {
+		return false
+	}
+	var httpErr *aiHTTPError
+	if errors.As(err, &httpErr) {
+		return retryableStatus(httpErr.StatusCode)
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.HTTPStatusCode)
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return retryableStatus(requestErr.HTTPStatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "deadline exceeded") ||
+		strings.Contains(err.Error(), "connection reset")
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// aiBackoff returns how long to wait before the given retry (1-based)
+func aiBackoff(retry int, err error) time.Duration {
+	var httpErr *aiHTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		return min(httpErr.RetryAfter, aiMaxBackoff)
+	}
+	backoff := min(aiBaseBackoff<<(retry-1), aiMaxBackoff)
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
+
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int       // Consecutive retryable failures
+	openUntil time.Time // Calls fail fast until then
+	probing   bool      // A trial call is in flight after the cooldown
+}
+
+// aiBreakers holds one breaker per provider
+type aiBreakers struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func (b *aiBreakers) get(provider string) *circuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.breakers == nil {
+		b.breakers = make(map[string]*circuitBreaker)
+	}
+	breaker, ok := b.breakers[provider]
+	if !ok {
+		breaker = &circuitBreaker{}
+		b.breakers[provider] = breaker
+	}
+	return breaker
+}
+
+// allow reports whether a call may go ahead
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < aiBreakerThreshold {
+		return true
+	}
+	if time.Now().Before(cb.openUntil) || cb.probing {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+// record updates the breaker with a call's outcome. Calls cancelled by
+// the caller say nothing about the provider and are ignored.
+func (cb *circuitBreaker) record(provider string, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if err == nil || !isRetryableAIError(err) {
+		if cb.failures >= aiBreakerThreshold {
+			log.Printf("🟢 %s circuit closed", provider)
+		}
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.failures >= aiBreakerThreshold {
+		cb.openUntil = time.Now().Add(aiBreakerCooldown)
+		log.Printf("🔴 %s circuit open for %v after %d consecutive failures: %v", provider, aiBreakerCooldown, cb.failures, err)
+	}
+}
+
+// withAIRetries runs call with retries behind the provider's breaker,
+// giving up early once ctx is done
+func (h *PuzzleHub) withAIRetries(ctx context.Context, provider string, call func() (string, error)) (string, error) {
+	breaker := h.AIBreakers.get(provider)
+
+	var lastErr error
+	for attempt := 1; attempt <= aiMaxAttempts; attempt++ {
+		if !breaker.allow() {
+			if lastErr != nil {
+				return "", fmt.Errorf("%w: %v", errAICircuitOpen, lastErr)
+			}
+			return "", errAICircuitOpen
+		}
+
+		release, err := h.AILimits.acquire(ctx, provider)
+		if err != nil {
+			if lastErr != nil {
+				return "", fmt.Errorf("%v (gave up waiting for a free slot: %w)", lastErr, err)
+			}
+			return "", err
+		}
+		_, span := tracer.Start(ctx, "ai.attempt", trace.WithAttributes(
+			attribute.String("ai.provider", provider),
+			attribute.Int("ai.attempt", attempt),
+		))
+		content, err := call()
+		release()
+		endSpan(span, err)
+		breaker.record(provider, err)
+		if err == nil {
+			return content, nil
+		}
+		lastErr = err
+
+		if !isRetryableAIError(err) || attempt == aiMaxAttempts || ctx.Err() != nil {
+			break
+		}
+		wait := aiBackoff(attempt, err)
+		aiRequestRetries.WithLabelValues(provider).Inc()
+		log.Printf("⚠️  %s attempt %d/%d failed, retrying in %v: %v", provider, attempt, aiMaxAttempts, wait.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", fmt.Errorf("%v (gave up waiting to retry: %w)", lastErr, ctx.Err())
+		case <-timer.C:
+		}
+	}
+	return "", lastErr
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Streaming AI responses
+//
+// streamAI is the streaming counterpart of generateAI: the reply arrives on
+// a token channel as the provider writes it, behind the same budget,
+// retries, circuit breaker and concurrency limits. A call is only retried
+// if it failed before any text was sent. Text is released a sentence at a
+// time once it passes the moderation word list, and the complete reply
+// gets the full moderation check at the end; a stream flagged either way
+// ends with errAIModerationRejected.
+//
+// The SSE endpoints send "token" events with {"text": ...} and finish with
+// one "done" event holding the complete response, which clients should
+// show in place of the streamed text, since it may be a fallback.
+
+var errAIStreamInterrupted = errors.New("AI stream interrupted")
+
+// streamAI streams the reply to prompt. tokens is closed when the reply
+// ends; result then receives the full reply text or the error.
+func (h *PuzzleHub) streamAI(ctx context.Context, prompt string, call aiCall) (<-chan string, <-chan aiStreamResult) {
+	tokens := make(chan string, 16)
+	result := make(chan aiStreamResult, 1)
+	go func() {
+		content, err := h.runAIStream(ctx, prompt, call, func(text string) {
+			select {
+			case tokens <- text:
+			case <-ctx.Done():
+			}
+		})
+		close(tokens)
+		result <- aiStreamResult{Content: content, Err: err}
+	}()
+	return tokens, result
+}
+
+type aiStreamResult struct {
+	Content string
+	Err     error
+}
+
+func (h *PuzzleHub) runAIStream(ctx context.Context, prompt string, call aiCall, send func(string)) (_ string, err error) {
+	if demoMode {
+		return "", errAIDemoMode
+	}
+
+	provider := h.providerForCall(ctx, call.Feature)
+	var stream func(context.Context, string, aiCall, func(string) error) (string, error)
+	switch provider {
+	case "openai":
+		stream = h.streamWithOpenAI
+	case "perplexity":
+		stream = h.streamWithPerplexity
+	case "claude":
+		stream = h.streamWithClaude
+	case "gemini":
+		stream = h.streamWithGemini
+	default:
+		return "", fmt.Errorf("invalid AI provider: %s", provider)
+	}
+
+	ctx, span := tracer.Start(ctx, "ai.stream", trace.WithAttributes(aiSpanAttributes(call, provider)...))
+	defer func() { endSpan(span, err) }()
+
+	timeout := featureDurationEnv("AI_TIMEOUT_", call.Feature, aiDefaultTimeouts[call.Feature])
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Text is held back until a sentence ends, so the word list sees whole
+	// words before anything reaches the client
+	sent := false
+	var pending strings.Builder
+	release := func(final bool) error {
+		text := pending.String()
+		end := len(text)
+		if !final {
+			end = strings.LastIndexAny(text, ".!?\n") + 1
+		}
+		if end == 0 {
+			return nil
+		}
+		if !h.Moderation.allowsChunk(text[:end]) {
+			return errAIModerationRejected
+		}
+		send(text[:end])
+		sent = true
+		pending.Reset()
+		pending.WriteString(text[end:])
+		return nil
+	}
+
+	content, err := h.withAIRetries(ctx, provider, func() (string, error) {
+		pending.Reset()
+		content, err := stream(ctx, prompt, call, func(token string) error {
+			pending.WriteString(token)
+			return release(false)
+		})
+		if err == nil {
+			err = release(true)
+		}
+		if err != nil && sent && !errors.Is(err, errAIModerationRejected) {
+			return "", fmt.Errorf("%w: %v", errAIStreamInterrupted, err)
+		}
+		return content, err
+	})
+	if errors.Is(err, errAIModerationRejected) {
+		held := pending.String()
+		h.Moderation.logFlag(call, provider, h.Moderation.checkWordList(held), held, 1, "rejected")
+		return "", fmt.Errorf("%w: %s", errAIModerationRejected, call.Feature)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := h.Moderation.final(ctx, call, provider, content); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+func (h *PuzzleHub) streamWithOpenAI(ctx context.Context, prompt string, call aiCall, emit func(string) error) (content string, err error) {
+	model, err := h.modelFor(ctx, call.Feature, "openai")
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	defer func() { h.recordAICall(call, "openai", model, start, err) }()
+
+	request := openAIChatRequest(model, prompt, call)
+	request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := h.OpenAIClient.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	var text strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if chunk.Usage != nil {
+			h.AIUsage.record("openai", model, call, chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			text.WriteString(choice.Delta.Content)
+			if err := emit(choice.Delta.Content); err != nil {
+				return "", err
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+type openAIStyleChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+func (h *PuzzleHub) streamWithPerplexity(ctx context.Context, prompt string, call aiCall, emit func(string) error) (content string, err error) {
+	model, err := h.modelFor(ctx, call.Feature, "perplexity")
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	defer func() { h.recordAICall(call, "perplexity", model, start, err) }()
+
+	request := PerplexityRequest{Model: model, Temperature: providerTemperature("perplexity", call.Temperature), Stream: true}
+	if call.System != "" {
+		request.Messages = append(request.Messages, Message{Role: "system", Content: call.System})
+	}
+	request.Messages = append(request.Messages, Message{Role: "user", Content: prompt})
+	headers := map[string]string{
+		"Authorization": "Bearer " + h.PerplexityKey,
+	}
+
+	var text strings.Builder
+	var usage openAIStyleChunk
+	err = h.postAIStream(ctx, "https://api.perplexity.ai/chat/completions", headers, request, func(data []byte) error {
+		if string(data) == "[DONE]" {
+			return nil
+		}
+		var chunk openAIStyleChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("failed to parse stream chunk: %v", err)
+		}
+		if chunk.Usage != nil {
+			usage.Usage = chunk.Usage
+		}
+		// Perplexity repeats the running message in later chunks, so
+		// only the delta is used
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			text.WriteString(choice.Delta.Content)
+			if err := emit(choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if usage.Usage != nil {
+		h.AIUsage.record("perplexity", model, call, usage.Usage.PromptTokens, usage.Usage.CompletionTokens)
+	}
+	return text.String(), nil
+}
+
+type claudeStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (h *PuzzleHub) streamWithClaude(ctx context.Context, prompt string, call aiCall, emit func(string) error) (content string, err error) {
+	model, err := h.modelFor(ctx, call.Feature, "claude")
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	defer func() { h.recordAICall(call, "claude", model, start, err) }()
+
+	request := claudeRequest{
+		Model:       model,
+		MaxTokens:   4096,
+		System:      call.System,
+		Messages:    []Message{{Role: "user", Content: prompt}},
+		Temperature: providerTemperature("claude", call.Temperature),
+		Stream:      true,
+	}
+	headers := map[string]string{
+		"x-api-key":         h.AnthropicKey,
+		"anthropic-version": "2023-06-01",
+	}
+
+	var text strings.Builder
+	inputTokens, outputTokens := 0, 0
+	err = h.postAIStream(ctx, "https://api.anthropic.com/v1/messages", headers, request, func(data []byte) error {
+		var event claudeStreamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed to parse stream event: %v", err)
+		}
+		switch event.Type {
+		case "message_start":
+			inputTokens = event.Message.Usage.InputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				text.WriteString(event.Delta.Text)
+				return emit(event.Delta.Text)
+			}
+		case "message_delta":
+			outputTokens = event.Usage.OutputTokens
+		case "error":
+			return fmt.Errorf("stream error: %s", event.Error.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	h.AIUsage.record("claude", model, call, inputTokens, outputTokens)
+	return text.String(), nil
+}
+
+func (h *PuzzleHub) streamWithGemini(ctx context.Context, prompt string, call aiCall, emit func(string) error) (content string, err error) {
+	model, err := h.modelFor(ctx, call.Feature, "gemini")
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	defer func() { h.recordAICall(call, "gemini", model, start, err) }()
+
+	request := geminiRequest{
+		Contents:         []geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}},
+		GenerationConfig: geminiConfig(call),
+	}
+	if call.System != "" {
+		request.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: call.System}}}
+	}
+	headers := map[string]string{
+		"x-goog-api-key": h.GeminiKey,
+	}
+
+	var text strings.Builder
+	var last geminiResponse
+	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:streamGenerateContent?alt=sse", model)
+	err = h.postAIStream(ctx, url, headers, request, func(data []byte) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("failed to parse stream chunk: %v", err)
+		}
+		last = chunk
+		if len(chunk.Candidates) == 0 {
+			return nil
+		}
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			if part.Text == "" {
+				continue
+			}
+			text.WriteString(part.Text)
+			if err := emit(part.Text); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	h.AIUsage.record("gemini", model, call, last.UsageMetadata.PromptTokenCount, last.UsageMetadata.CandidatesTokenCount)
+	return text.String(), nil
+}
+
+// postAIStream posts a JSON request to a provider's streaming API and
+// passes the data of each server-sent event to onData
+func (h *PuzzleHub) postAIStream(ctx context.Context, url string, headers map[string]string, request interface{}, onData func(data []byte) error) error {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := h.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make API call: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return newAIHTTPError(resp, body)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		if err := onData(bytes.TrimSpace(line[len("data:"):])); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %v", err)
+	}
+	return nil
+}
+
+// startSSE sets the headers for a server-sent event response
+func startSSE(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+}
+
+// sendSSE writes one event and flushes it to the client
+func sendSSE(c *gin.Context, event string, data interface{}) {
+	c.SSEvent(event, data)
+	c.Writer.Flush()
+}
+
+// streamStory streams a story starter over SSE
+func (h *PuzzleHub) streamStory(c *gin.Context) {
+	var req StoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if err := req.normalize(); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID := c.MustGet("user").(*User).ID
+	ctx := c.Request.Context()
+
+	choice := h.Prompts.choose(storyPromptName(req), userID)
+	cacheParams := choice.cacheParams(storyCacheParams(req))
+	startSSE(c)
+
+	var cached StoryResponse
+	if h.loadAICache(ctx, "story", cacheParams, &cached) {
+		cached.Source = sourceAI
+		sendSSE(c, "token", gin.H{"text": cached.Content})
+		sendSSE(c, "done", cached)
+		return
+	}
+
+	call := aiCall{
+		Feature:     "story",
+		UserID:      userID,
+		System:      h.Prompts.render("story_system", req),
+		Prompt:      choice.PromptTag,
+		Temperature: req.Temperature,
+		Generation:  newAIGeneration(),
+	}
+	h.Prompts.recordExperiment(choice.PromptTag, "generations")
+
+	tokens, result := h.streamAI(ctx, h.Prompts.renderChoice(choice, req), call)
+	for token := range tokens {
+		sendSSE(c, "token", gin.H{"text": token})
+	}
+	outcome := <-result
+	if outcome.Err != nil {
+		requestLogger(c).Error("story stream failed, using a canned starter", "error", outcome.Err, "provider", h.providerFor("story"))
+		sendSSE(c, "done", fallbackStory(req))
+		return
+	}
+
+	story := &StoryResponse{
+		Content:      outcome.Content,
+		GeneratedAt:  time.Now(),
+		Source:       sourceAI,
+		GenerationID: call.Generation.ID,
+		PromptTag:    choice.PromptTag,
+	}
+	h.saveGeneration(call.Generation, call, choice)
+	h.storeAICache(ctx, "story", cacheParams, story)
+	sendSSE(c, "done", story)
+}
+
+// streamWritingAnalysis streams the analysis JSON as it is written, so the
+// client can show progress, then sends the validated analysis
+func (h *PuzzleHub) streamWritingAnalysis(c *gin.Context) {
+	var request WritingAnalysisRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if request.GradeLevel < 1 || request.GradeLevel > 12 {
+		respondError(c, http.StatusBadRequest, "Grade level must be between 1 and 12")
+		return
+	}
+	if len(strings.TrimSpace(request.Text)) < 10 {
+		respondError(c, http.StatusBadRequest, "Text must be at least 10 characters long")
+		return
+	}
+	userID := optionalUserID(c)
+	ctx := c.Request.Context()
+
+	choice := h.Prompts.choose("writing_analysis", userID)
+	cacheParams := choice.cacheParams(writingCacheParams(request))
+	startSSE(c)
+
+	var analysis WritingAnalysisResponse
+	if h.loadAICache(ctx, "writing", cacheParams, &analysis) {
+		analysis.anchor(request.Text)
+		analysis.Source = sourceAI
+		h.pushFlashcards(userID, "writing", flashcardsForVocabulary(&analysis))
+		h.queueWritingFeedback(c, request, &analysis)
+		sendSSE(c, "done", analysis)
+		return
+	}
+
+	call := aiCall{
+		Feature:    "writing",
+		UserID:     userID,
+		Schema:     &writingAnalysisSchema,
+		Prompt:     choice.PromptTag,
+		Generation: newAIGeneration(),
+	}
+	h.Prompts.recordExperiment(choice.PromptTag, "generations")
+
+	tokens, result := h.streamAI(ctx, h.buildWritingAnalysisPrompt(request, choice), call)
+	for token := range tokens {
+		sendSSE(c, "token", gin.H{"text": token})
+	}
+	outcome := <-result
+	if outcome.Err == nil {
+		outcome.Err = decodeStructuredResponse(outcome.Content, &analysis, analysis.validate)
+	}
+	if outcome.Err != nil {
+		// The regular path repairs bad replies and falls back when it must
+		requestLogger(c).Warn("writing analysis stream failed, retrying without streaming", "error", outcome.Err)
+		fallback := h.AnalyzeWriting(ctx, request, userID)
+		h.queueWritingFeedback(c, request, fallback)
+		sendSSE(c, "done", fallback)
+		return
+	}
+
+	analysis.dropOutOfRange(len(request.Text))
+	analysis.anchor(request.Text)
+	analysis.Source = sourceAI
+	analysis.PromptTag = choice.PromptTag
+	analysis.GenerationID = call.Generation.ID
+	h.saveGeneration(call.Generation, call, choice)
+	h.storeAICache(ctx, "writing", cacheParams, analysis)
+	h.pushFlashcards(userID, "writing", flashcardsForVocabulary(&analysis))
+	h.queueWritingFeedback(c, request, &analysis)
+	sendSSE(c, "done", analysis)
+}
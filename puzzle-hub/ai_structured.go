@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// Structured AI output
+//
+// Features that expect JSON describe it with a JSON schema. OpenAI enforces
+// the schema natively through response_format; for other providers the
+// schema is only a prompt hint, so replies are extracted, decoded strictly
+// and validated, and a reply that fails gets one repair attempt that shows
+// the model its own output and the error.
+
+var errAIInvalidResponse = errors.New("invalid AI response")
+
+// aiSchema is a named JSON schema in OpenAI's strict subset: every property
+// required and no additional properties
+type aiSchema struct {
+	Name   string
+	Schema json.RawMessage
+}
+
+var spellingSchema = aiSchema{
+	Name: "spelling_problems",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "problems": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "word": {"type": "string"},
+          "definition": {"type": "string"},
+          "sentence": {"type": "string"},
+          "hints": {"type": "array", "items": {"type": "string"}},
+          "phonetic": {"type": "string"},
+          "difficulty": {"type": "string"},
+          "age_group": {"type": "string"}
+        },
+        "required": ["word", "definition", "sentence", "hints", "phonetic", "difficulty", "age_group"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["problems"],
+  "additionalProperties": false
+}`),
+}
+
+var writingAnalysisSchema = aiSchema{
+	Name: "writing_analysis",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "overallRating": {"type": "integer"},
+    "grammarErrors": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "startIndex": {"type": "integer"},
+          "endIndex": {"type": "integer"},
+          "errorType": {"type": "string"},
+          "original": {"type": "string"},
+          "suggestion": {"type": "string"},
+          "explanation": {"type": "string"}
+        },
+        "required": ["startIndex", "endIndex", "errorType", "original", "suggestion", "explanation"],
+        "additionalProperties": false
+      }
+    },
+    "vocabularyTips": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "startIndex": {"type": "integer"},
+          "endIndex": {"type": "integer"},
+          "original": {"type": "string"},
+          "suggestions": {"type": "array", "items": {"type": "string"}},
+          "explanation": {"type": "string"}
+        },
+        "required": ["startIndex", "endIndex", "original", "suggestions", "explanation"],
+        "additionalProperties": false
+      }
+    },
+    "contextSuggestions": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "paragraphIndex": {"type": "integer"},
+          "suggestion": {"type": "string"},
+          "reason": {"type": "string"}
+        },
+        "required": ["paragraphIndex", "suggestion", "reason"],
+        "additionalProperties": false
+      }
+    },
+    "narrativeAnalysis": {
+      "type": "object",
+      "properties": {
+        "structure": {
+          "type": "object",
+          "properties": {
+            "hasIntroduction": {"type": "boolean"},
+            "hasRisingAction": {"type": "boolean"},
+            "hasClimax": {"type": "boolean"},
+            "hasResolution": {"type": "boolean"},
+            "feedback": {"type": "string"}
+          },
+          "required": ["hasIntroduction", "hasRisingAction", "hasClimax", "hasResolution", "feedback"],
+          "additionalProperties": false
+        },
+        "strengths": {"type": "array", "items": {"type": "string"}},
+        "improvements": {"type": "array", "items": {"type": "string"}},
+        "rating": {"type": "integer"}
+      },
+      "required": ["structure", "strengths", "improvements", "rating"],
+      "additionalProperties": false
+    },
+    "summary": {"type": "string"}
+  },
+  "required": ["overallRating", "grammarErrors", "vocabularyTips", "contextSuggestions", "narrativeAnalysis", "summary"],
+  "additionalProperties": false
+}`),
+}
+
+var fieldSuggestionsSchema = aiSchema{
+	Name: "field_suggestions",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "suggested_fields": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "field_name": {"type": "string"},
+          "field_type": {"type": "string", "enum": ["text", "number", "textarea", "select", "checkbox", "multiselect", "tags"]},
+          "required": {"type": "boolean"},
+          "default_value": {"type": "string"},
+          "options": {"type": "string"},
+          "description": {"type": "string"}
+        },
+        "required": ["field_name", "field_type", "required", "default_value", "options", "description"],
+        "additionalProperties": false
+      }
+    },
+    "explanation": {"type": "string"}
+  },
+  "required": ["suggested_fields", "explanation"],
+  "additionalProperties": false
+}`),
+}
+
+var wordProblemsSchema = aiSchema{
+	Name: "word_problems",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "problems": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "question": {"type": "string"},
+          "unit": {"type": "string"},
+          "expression": {"type": "string"},
+          "answer": {"type": "number"}
+        },
+        "required": ["question", "unit", "expression", "answer"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["problems"],
+  "additionalProperties": false
+}`),
+}
+
+var wordProblemExplanationSchema = aiSchema{
+	Name: "word_problem_explanation",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "steps": {"type": "array", "items": {"type": "string"}}
+  },
+  "required": ["steps"],
+  "additionalProperties": false
+}`),
+}
+
+var quizQuestionsSchema = aiSchema{
+	Name: "quiz_questions",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "questions": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "question": {"type": "string"},
+          "answer": {"type": "string"},
+          "wrong_answers": {"type": "array", "items": {"type": "string"}},
+          "explanation": {"type": "string"}
+        },
+        "required": ["question", "answer", "wrong_answers", "explanation"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["questions"],
+  "additionalProperties": false
+}`),
+}
+
+var remediationSchema = aiSchema{
+	Name: "remediation",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "items": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "item": {"type": "string"},
+          "sentences": {"type": "array", "items": {"type": "string"}},
+          "mnemonic": {"type": "string"}
+        },
+        "required": ["item", "sentences", "mnemonic"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["items"],
+  "additionalProperties": false
+}`),
+}
+
+var storyTitlesSchema = aiSchema{
+	Name: "story_titles",
+	Schema: json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "titles": {"type": "array", "items": {"type": "string"}},
+    "blurb": {"type": "string"}
+  },
+  "required": ["titles", "blurb"],
+  "additionalProperties": false
+}`),
+}
+
+// hasNativeSchema reports whether the provider enforces response schemas
+func hasNativeSchema(provider string) bool {
+	return provider == "openai"
+}
+
+// generateJSON asks the feature's provider for JSON matching schema,
+// decodes it into out and checks it with validate. A reply that fails
+// decoding or validation is sent back once for repair.
+func (h *PuzzleHub) generateJSON(ctx context.Context, prompt string, call aiCall, schema aiSchema, out interface{}, validate func() error) error {
+	call.Schema = &schema
+	h.Prompts.recordExperiment(call.Prompt, "generations")
+
+	response, err := h.generateAI(ctx, prompt, call)
+	if err != nil {
+		return err
+	}
+
+	parseErr := traceDecode(ctx, response, out, validate)
+	if parseErr == nil {
+		return nil
+	}
+	log.Printf("⚠️  %s reply for %s failed validation, asking for a repair: %v", h.providerFor(call.Feature), call.Feature, parseErr)
+
+	repairPrompt := fmt.Sprintf(`%s
+
+Your previous reply could not be used: %v
+
+Previous reply:
+%s
+
+Reply again with ONLY a JSON object that fixes this problem and matches this JSON schema, with no other text:
+%s`, prompt, parseErr, response, string(schema.Schema))
+
+	response, err = h.generateAI(ctx, repairPrompt, call)
+	if err != nil {
+		return err
+	}
+	if err := traceDecode(ctx, response, out, validate); err != nil {
+		h.Prompts.recordExperiment(call.Prompt, "parse_failures")
+		return fmt.Errorf("%w: %s after repair: %v", errAIInvalidResponse, schema.Name, err)
+	}
+	return nil
+}
+
+// traceDecode is decodeStructuredResponse in an ai.parse span
+func traceDecode(ctx context.Context, response string, out interface{}, validate func() error) error {
+	_, span := tracer.Start(ctx, "ai.parse")
+	err := decodeStructuredResponse(response, out, validate)
+	endSpan(span, err)
+	return err
+}
+
+// decodeStructuredResponse strictly decodes the JSON object in response
+func decodeStructuredResponse(response string, out interface{}, validate func() error) error {
+	jsonStr := extractJSONObject(response)
+	if jsonStr == "" {
+		return fmt.Errorf("no JSON object found in response")
+	}
+
+	// Start from zero so nothing survives from a rejected earlier reply
+	target := reflect.ValueOf(out).Elem()
+	target.Set(reflect.Zero(target.Type()))
+
+	decoder := json.NewDecoder(strings.NewReader(jsonStr))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("failed to parse JSON: %v", err)
+	}
+	if validate != nil {
+		return validate()
+	}
+	return nil
+}
+
+// extractJSONObject returns the JSON object in a reply, which models may
+// wrap in a code fence or surround with prose
+func extractJSONObject(response string) string {
+	if start := strings.Index(response, "```"); start != -1 {
+		body := response[start+3:]
+		body = strings.TrimPrefix(body, "json")
+		if end := strings.Index(body, "```"); end != -1 {
+			response = body[:end]
+		}
+	}
+
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start == -1 || end < start {
+		return ""
+	}
+	return strings.TrimSpace(response[start : end+1])
+}
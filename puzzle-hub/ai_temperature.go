@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AI temperature
+//
+// Story and spelling requests take an optional "creativity" preset or an
+// exact "temperature", so a teacher can ask for predictable word lists or
+// wildly creative story starters. The temperature is bounded to 0 to
+// AI_MAX_TEMPERATURE (default 1.2), a preset is just a named temperature,
+// and Claude, which accepts at most 1, gets the value clamped. Without
+// either, each provider uses its usual temperature.
+//
+// Requests with a temperature are cached apart from the others, and the
+// temperature actually sent is recorded with the generation (see
+// ai_ratings.go), so ratings can be compared across settings.
+
+const (
+	defaultMaxAITemperature  = 1.2
+	maxAITemperatureLimit    = 2 // Highest any provider accepts
+	claudeMaxTemperature     = 1
+	openAIDefaultTemperature = 0.7
+)
+
+var creativityTemperatures = map[string]float64{
+	"predictable": 0.2,
+	"balanced":    0.7,
+	"creative":    1.0,
+	"wild":        1.2,
+}
+
+// CreativityOptions is embedded in AI-backed generation requests
+type CreativityOptions struct {
+	Creativity  string   `json:"creativity,omitempty"`  // predictable, balanced, creative or wild
+	Temperature *float64 `json:"temperature,omitempty"` // 0 to AI_MAX_TEMPERATURE, instead of creativity
+}
+
+// maxAITemperature returns the highest temperature a request may ask for
+func maxAITemperature() float64 {
+	value := os.Getenv("AI_MAX_TEMPERATURE")
+	if value == "" {
+		return defaultMaxAITemperature
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || parsed > maxAITemperatureLimit {
+		log.Printf("⚠️  Ignoring invalid AI_MAX_TEMPERATURE=%q", value)
+		return defaultMaxAITemperature
+	}
+	return parsed
+}
+
+// normalize checks the options and resolves a preset into Temperature,
+// capped at AI_MAX_TEMPERATURE
+func (o *CreativityOptions) normalize() error {
+	limit := maxAITemperature()
+	if o.Temperature != nil {
+		if math.IsNaN(*o.Temperature) || *o.Temperature < 0 || *o.Temperature > limit {
+			return fmt.Errorf("Temperature must be between 0 and %g", limit)
+		}
+		return nil
+	}
+	if o.Creativity == "" {
+		return nil
+	}
+
+	temperature, ok := creativityTemperatures[strings.ToLower(strings.TrimSpace(o.Creativity))]
+	if !ok {
+		presets := make([]string, 0, len(creativityTemperatures))
+		for name := range creativityTemperatures {
+			presets = append(presets, name)
+		}
+		sort.Slice(presets, func(i, j int) bool {
+			return creativityTemperatures[presets[i]] < creativityTemperatures[presets[j]]
+		})
+		return fmt.Errorf("Creativity must be %s", strings.Join(presets, ", "))
+	}
+	temperature = min(temperature, limit)
+	o.Temperature = &temperature
+	return nil
+}
+
+// addCacheParam keeps responses generated at a chosen temperature apart.
+// It adds nothing otherwise, so existing entries keep their keys.
+func (o CreativityOptions) addCacheParam(params map[string]interface{}) {
+	if o.Temperature != nil {
+		params["temperature"] = strconv.FormatFloat(*o.Temperature, 'f', 2, 64)
+	}
+}
+
+// providerTemperature returns the temperature to send the provider, or nil
+// for its default
+func providerTemperature(provider string, temperature *float64) *float64 {
+	if temperature == nil {
+		return nil
+	}
+	value := *temperature
+	if provider == "claude" {
+		value = min(value, claudeMaxTemperature)
+	}
+	return &value
+}
+
+// openAITemperature returns the call's temperature for go-openai, which
+// leaves out a zero temperature (meaning the API's default of 1), so zero
+// is sent as the smallest float instead
+func openAITemperature(call aiCall) float32 {
+	temperature := providerTemperature("openai", call.Temperature)
+	if temperature == nil {
+		return openAIDefaultTemperature
+	}
+	if *temperature == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return float32(*temperature)
+}
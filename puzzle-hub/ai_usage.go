@@ -0,0 +1,422 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// AI cost and token accounting
+//
+// Every AI call records its token usage and estimated cost (from
+// aiModelPrices) per provider, model, feature and user, and adds the cost
+// to the month's running spend. With AI_MONTHLY_BUDGET_USD set, calls over
+// budget switch to the provider's cheaper model, or fail with
+// errAIBudgetExceeded when it has none so callers use their fallbacks.
+// AI_MONTHLY_HARD_LIMIT_USD stops AI calls entirely.
+
+var errAIBudgetExceeded = errors.New("monthly AI budget exceeded")
+
+// aiCall describes who an AI request is for, for accounting
+type aiCall struct {
+	Feature string    // spelling, writing, story, log_fields, math, quiz
+	UserID  string    // Empty for anonymous players
+	System  string    // Optional system prompt
+	Schema  *aiSchema // Expected JSON reply, see ai_structured.go
+	Prompt  PromptTag // Prompt experiment variant, if any
+
+	Temperature *float64 // Requested temperature, nil for the provider's default, see ai_temperature.go
+
+	Generation *aiGeneration // Collects the model used, see ai_ratings.go
+}
+
+type aiModelPrice struct {
+	InputPerMillion  float64 // USD per million prompt tokens
+	OutputPerMillion float64 // USD per million completion tokens
+}
+
+// Published list prices; estimates only, real invoices may differ
+var aiModelPrices = map[string]aiModelPrice{
+	openai.GPT4:       {InputPerMillion: 30, OutputPerMillion: 60},
+	openai.GPT4o:      {InputPerMillion: 2.5, OutputPerMillion: 10},
+	openai.GPT4oMini:  {InputPerMillion: 0.15, OutputPerMillion: 0.6},
+	"sonar":           {InputPerMillion: 1, OutputPerMillion: 1},
+	claudeModel:       {InputPerMillion: 3, OutputPerMillion: 15},
+	claudeBudgetModel: {InputPerMillion: 0.8, OutputPerMillion: 4},
+	geminiModel:       {InputPerMillion: 0.3, OutputPerMillion: 2.5},
+	geminiBudgetModel: {InputPerMillion: 0.1, OutputPerMillion: 0.4},
+}
+
+const (
+	claudeModel       = "claude-sonnet-4-20250514"
+	claudeBudgetModel = "claude-3-5-haiku-20241022"
+	geminiModel       = "gemini-2.5-flash"
+	geminiBudgetModel = "gemini-2.5-flash-lite"
+)
+
+var aiDefaultModels = map[string]string{
+	"openai":     openai.GPT4o, // Oldest family with json_schema response formats
+	"perplexity": "sonar",
+	"claude":     claudeModel,
+	"gemini":     geminiModel,
+}
+
+// aiBudgetModels are used once the monthly budget is spent
+var aiBudgetModels = map[string]string{
+	"openai": openai.GPT4oMini,
+	"claude": claudeBudgetModel,
+	"gemini": geminiBudgetModel,
+}
+
+type AIUsageRecord struct {
+	Period           string    `json:"period" dynamodbav:"period"` // YYYY-MM, UTC
+	CallID           string    `json:"id" dynamodbav:"call_id"`    // <unix nanos>#<seq>
+	Provider         string    `json:"provider" dynamodbav:"provider"`
+	Model            string    `json:"model" dynamodbav:"model"`
+	Feature          string    `json:"feature" dynamodbav:"feature"`
+	UserID           string    `json:"user_id,omitempty" dynamodbav:"user_id,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens" dynamodbav:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens" dynamodbav:"completion_tokens"`
+	CostUSD          float64   `json:"cost_usd" dynamodbav:"cost_usd"`
+	CreatedAt        time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+type AIUsageTotals struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+type AIUsageTracker struct {
+	db        *dynamodb.DynamoDB
+	budget    float64 // Monthly soft budget in USD, 0 for none
+	hardLimit float64 // Monthly hard limit in USD, 0 for none
+
+	mu          sync.Mutex
+	sequence    int64
+	totalCost   float64 // Since process start
+	period      string
+	periodSpend float64
+	refreshedAt time.Time
+}
+
+func NewAIUsageTracker(db *dynamodb.DynamoDB) *AIUsageTracker {
+	t := &AIUsageTracker{db: db}
+	t.budget = parseUSDEnv("AI_MONTHLY_BUDGET_USD")
+	t.hardLimit = parseUSDEnv("AI_MONTHLY_HARD_LIMIT_USD")
+	if t.budget > 0 || t.hardLimit > 0 {
+		log.Printf("💰 AI budget: $%.2f/month (cheaper models), hard limit: $%.2f/month", t.budget, t.hardLimit)
+	}
+	return t
+}
+
+func parseUSDEnv(name string) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount < 0 {
+		log.Printf("⚠️  Ignoring invalid %s=%q", name, value)
+		return 0
+	}
+	return amount
+}
+
+// chooseModel picks the model for the provider given this month's spend
+func (t *AIUsageTracker) chooseModel(provider string) (string, error) {
+	model := aiDefaultModels[provider]
+	if t == nil || (t.budget == 0 && t.hardLimit == 0) {
+		return model, nil
+	}
+
+	spend := t.monthSpend()
+	if t.hardLimit > 0 && spend >= t.hardLimit {
+		return "", errAIBudgetExceeded
+	}
+	if t.budget > 0 && spend >= t.budget {
+		cheaper, ok := aiBudgetModels[provider]
+		if !ok {
+			return "", errAIBudgetExceeded
+		}
+		return cheaper, nil
+	}
+	return model, nil
+}
+
+// monthSpend returns the current month's spend, re-reading the shared total
+// every few minutes so other instances' spending counts too
+func (t *AIUsageTracker) monthSpend() float64 {
+	period := time.Now().UTC().Format("2006-01")
+
+	t.mu.Lock()
+	fresh := t.period == period && time.Since(t.refreshedAt) < 5*time.Minute
+	spend := t.periodSpend
+	t.mu.Unlock()
+	if fresh {
+		return spend
+	}
+
+	result, err := t.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-spend")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"period": {S: aws.String(period)},
+		},
+	})
+	if err != nil {
+		log.Printf("Error fetching AI spend: %v", err)
+		return spend
+	}
+
+	spend = 0
+	if result.Item != nil && result.Item["cost_usd"] != nil {
+		spend, _ = strconv.ParseFloat(aws.StringValue(result.Item["cost_usd"].N), 64)
+	}
+	t.mu.Lock()
+	t.period = period
+	t.periodSpend = spend
+	t.refreshedAt = time.Now()
+	t.mu.Unlock()
+	return spend
+}
+
+// record stores one call's usage and adds its cost to the month's spend
+func (t *AIUsageTracker) record(provider, model string, call aiCall, promptTokens, completionTokens int) {
+	call.Generation.setModel(provider, model)
+	if t == nil {
+		return
+	}
+	price := aiModelPrices[model]
+	cost := (float64(promptTokens)*price.InputPerMillion + float64(completionTokens)*price.OutputPerMillion) / 1e6
+
+	now := time.Now()
+	t.mu.Lock()
+	t.sequence++
+	t.totalCost += cost
+	sequence := t.sequence
+	t.mu.Unlock()
+
+	usage := AIUsageRecord{
+		Period:           now.UTC().Format("2006-01"),
+		CallID:           fmt.Sprintf("%d#%d", now.UnixNano(), sequence),
+		Provider:         provider,
+		Model:            model,
+		Feature:          call.Feature,
+		UserID:           call.UserID,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		CostUSD:          cost,
+		CreatedAt:        now,
+	}
+	item, err := dynamodbattribute.MarshalMap(usage)
+	if err != nil {
+		log.Printf("Error marshaling AI usage: %v", err)
+		return
+	}
+	if _, err := t.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-usage")),
+		Item:      item,
+	}); err != nil {
+		log.Printf("Error saving AI usage: %v", err)
+	}
+
+	result, err := t.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-spend")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"period": {S: aws.String(usage.Period)},
+		},
+		UpdateExpression: aws.String("ADD cost_usd :cost, calls :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":cost": {N: aws.String(strconv.FormatFloat(cost, 'f', -1, 64))},
+			":one":  {N: aws.String("1")},
+		},
+		ReturnValues: aws.String("UPDATED_NEW"),
+	})
+	if err != nil {
+		log.Printf("Error updating AI spend: %v", err)
+		return
+	}
+	if spent, err := strconv.ParseFloat(aws.StringValue(result.Attributes["cost_usd"].N), 64); err == nil {
+		t.mu.Lock()
+		t.period = usage.Period
+		t.periodSpend = spent
+		t.refreshedAt = time.Now()
+		t.mu.Unlock()
+	}
+
+	log.Printf("💰 %s/%s %s call: %d+%d tokens, $%.4f", provider, model, call.Feature, promptTokens, completionTokens, cost)
+}
+
+// TotalCost is the estimated AI spend since the process started
+func (t *AIUsageTracker) TotalCost() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.totalCost
+}
+
+// getMyAIUsage reports the current user's AI usage for a month
+func (h *PuzzleHub) getMyAIUsage(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	h.respondUserAIUsage(c, userObj.ID)
+}
+
+// adminGetUserAIUsage reports one user's AI usage for a month
+func (h *PuzzleHub) adminGetUserAIUsage(c *gin.Context) {
+	h.respondUserAIUsage(c, c.Param("id"))
+}
+
+func (h *PuzzleHub) respondUserAIUsage(c *gin.Context, userID string) {
+	period, ok := usagePeriodParam(c)
+	if !ok {
+		return
+	}
+
+	records, err := h.queryAIUsage(&dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-ai-usage")),
+		IndexName:              aws.String("user_id-index"),
+		KeyConditionExpression: aws.String("user_id = :user_id AND period = :period"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+			":period":  {S: aws.String(period)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching AI usage", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to fetch AI usage")
+		return
+	}
+
+	totals, byFeature, _ := summarizeAIUsage(records)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":    userID,
+		"period":     period,
+		"totals":     totals,
+		"by_feature": byFeature,
+	})
+}
+
+// adminGetAIUsage reports the month's AI usage by feature, model and user
+func (h *PuzzleHub) adminGetAIUsage(c *gin.Context) {
+	period, ok := usagePeriodParam(c)
+	if !ok {
+		return
+	}
+
+	records, err := h.queryAIUsage(&dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-ai-usage")),
+		KeyConditionExpression: aws.String("period = :period"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":period": {S: aws.String(period)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching AI usage", "error", err, "period", period)
+		respondStorageError(c, err, "Failed to fetch AI usage")
+		return
+	}
+
+	totals, byFeature, byModel := summarizeAIUsage(records)
+
+	byUser := make(map[string]*AIUsageTotals)
+	for _, record := range records {
+		userID := record.UserID
+		if userID == "" {
+			userID = "anonymous"
+		}
+		if byUser[userID] == nil {
+			byUser[userID] = &AIUsageTotals{}
+		}
+		addAIUsage(byUser[userID], record)
+	}
+	type userTotals struct {
+		UserID string `json:"user_id"`
+		AIUsageTotals
+	}
+	topUsers := make([]userTotals, 0, len(byUser))
+	for userID, t := range byUser {
+		t.CostUSD = roundUSD(t.CostUSD)
+		topUsers = append(topUsers, userTotals{UserID: userID, AIUsageTotals: *t})
+	}
+	sort.Slice(topUsers, func(i, j int) bool {
+		return topUsers[i].CostUSD > topUsers[j].CostUSD
+	})
+	if len(topUsers) > 20 {
+		topUsers = topUsers[:20]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"period":             period,
+		"totals":             totals,
+		"by_feature":         byFeature,
+		"by_model":           byModel,
+		"top_users":          topUsers,
+		"budget_usd":         h.AIUsage.budget,
+		"hard_limit_usd":     h.AIUsage.hardLimit,
+		"process_total_cost": roundUSD(h.AIUsage.TotalCost()),
+	})
+}
+
+func (h *PuzzleHub) queryAIUsage(input *dynamodb.QueryInput) ([]AIUsageRecord, error) {
+	return queryAll[AIUsageRecord](h.DynamoDB, input)
+}
+
+func summarizeAIUsage(records []AIUsageRecord) (AIUsageTotals, map[string]*AIUsageTotals, map[string]*AIUsageTotals) {
+	var totals AIUsageTotals
+	byFeature := make(map[string]*AIUsageTotals)
+	byModel := make(map[string]*AIUsageTotals)
+	for _, record := range records {
+		addAIUsage(&totals, record)
+		if byFeature[record.Feature] == nil {
+			byFeature[record.Feature] = &AIUsageTotals{}
+		}
+		addAIUsage(byFeature[record.Feature], record)
+		if byModel[record.Model] == nil {
+			byModel[record.Model] = &AIUsageTotals{}
+		}
+		addAIUsage(byModel[record.Model], record)
+	}
+
+	totals.CostUSD = roundUSD(totals.CostUSD)
+	for _, t := range byFeature {
+		t.CostUSD = roundUSD(t.CostUSD)
+	}
+	for _, t := range byModel {
+		t.CostUSD = roundUSD(t.CostUSD)
+	}
+	return totals, byFeature, byModel
+}
+
+func addAIUsage(totals *AIUsageTotals, record AIUsageRecord) {
+	totals.Calls++
+	totals.PromptTokens += record.PromptTokens
+	totals.CompletionTokens += record.CompletionTokens
+	totals.CostUSD += record.CostUSD
+}
+
+func roundUSD(amount float64) float64 {
+	return math.Round(amount*10000) / 10000
+}
+
+// usagePeriodParam reads ?period=YYYY-MM, defaulting to the current month
+func usagePeriodParam(c *gin.Context) (string, bool) {
+	period := c.DefaultQuery("period", time.Now().UTC().Format("2006-01"))
+	if _, err := time.Parse("2006-01", period); err != nil {
+		respondError(c, http.StatusBadRequest, "period must be YYYY-MM")
+		return "", false
+	}
+	return period, true
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Site analytics: page visits and logins, counted in memory for quick
+// lookups and persisted as events so the counters survive restarts.
+//
+// Events are written through a bounded queue drained by a single writer
+// goroutine, which flushes to the store whenever a batch fills or the flush
+// interval passes. When the queue is full, callers wait briefly and then drop
+// the event (counters are still updated), so a storage slowdown can't pile up
+// goroutines or stall requests.
+//
+// Raw events expire after analyticsEventTTL. Each flush also adds its counts
+// to a per-day rollup item, and startup loads the counters from the rollups
+// rather than scanning every event. Whether a visitor or user is new is
+// decided by a conditional write to the seen keys (MarkAnalyticsSeen), so unique
+// counts stay correct across restarts without keeping every IP in memory.
+
+const (
+	analyticsQueueSize   = 1000
+	analyticsBatchSize   = 25 // BatchWriteItem limit
+	analyticsEnqueueWait = 50 * time.Millisecond
+	analyticsEventTTL    = 90 * 24 * time.Hour
+)
+
+// Analytics tracking types
+type AnalyticsEvent struct {
+	ID        string    `json:"id" dynamodbav:"id"`
+	EventType string    `json:"event_type" dynamodbav:"event_type"` // "visit", "login"
+	Timestamp time.Time `json:"timestamp" dynamodbav:"timestamp"`
+	VisitorID string    `json:"visitor_id,omitempty" dynamodbav:"visitor_id,omitempty"` // Salted hash, see visitors.go
+	IP        string    `json:"ip,omitempty" dynamodbav:"ip,omitempty"`                 // Only on events recorded before visitor IDs
+	UserID    string    `json:"user_id,omitempty" dynamodbav:"user_id,omitempty"`
+	IsNew     bool      `json:"is_new" dynamodbav:"is_new"`          // New visitor or new user
+	ExpiresAt int64     `json:"-" dynamodbav:"expires_at,omitempty"` // Unix seconds, also the table TTL
+
+	checkNew bool // Not known to be seen before; the writer decides IsNew
+}
+
+// AnalyticsRollup holds one UTC day's pre-aggregated counts
+type AnalyticsRollup struct {
+	Day         string `json:"day" dynamodbav:"day"` // YYYY-MM-DD, UTC
+	Visits      int64  `json:"visits" dynamodbav:"visits"`
+	Logins      int64  `json:"logins" dynamodbav:"logins"`
+	NewVisitors int64  `json:"new_visitors" dynamodbav:"new_visitors"`
+	NewUsers    int64  `json:"new_users" dynamodbav:"new_users"`
+}
+
+// AnalyticsSnapshot is a consistent copy of the counters
+type AnalyticsSnapshot struct {
+	TotalVisits    int64 `json:"total_visits"`
+	UniqueVisitors int64 `json:"unique_visitors"`
+	TotalLogins    int64 `json:"total_logins"`
+	UniqueUsers    int64 `json:"unique_users"`
+}
+
+// AnalyticsService owns the visit/login counters; all methods are safe for
+// concurrent use from request handlers
+type AnalyticsService struct {
+	db    *dynamodb.DynamoDB // Visitor salts and funnel events
+	store AnalyticsStore
+
+	queue    chan AnalyticsEvent
+	queueMu  sync.RWMutex // Held for reading while sending, so Close can't race a send
+	closed   bool
+	stopped  chan struct{}
+	sequence atomic.Int64 // Keeps event IDs unique within a batch
+	dropped  atomic.Int64
+
+	mu             sync.Mutex
+	totalVisits    int64
+	totalLogins    int64
+	uniqueVisitors int64
+	uniqueUsers    int64
+	knownVisitors  map[string]bool // IPs already checked against the seen table
+	knownUsers     map[string]bool // User IDs already checked against the seen table
+	knownPuzzlers  map[string]bool // User IDs whose first puzzle was already sent to the funnel
+	returnCheckDay string
+	returnChecked  map[string]bool // Visitor IDs checked for a funnel return visit on returnCheckDay
+
+	saltMu sync.Mutex
+	salts  map[string][]byte // Visitor ID salt by month
+}
+
+func NewAnalyticsService(db *dynamodb.DynamoDB, store AnalyticsStore) *AnalyticsService {
+	return &AnalyticsService{
+		db:            db,
+		store:         store,
+		queue:         make(chan AnalyticsEvent, analyticsQueueSize),
+		stopped:       make(chan struct{}),
+		knownVisitors: make(map[string]bool),
+		knownUsers:    make(map[string]bool),
+		knownPuzzlers: make(map[string]bool),
+		returnChecked: make(map[string]bool),
+		salts:         make(map[string][]byte),
+	}
+}
+
+// RecordVisit counts a page visit and persists it in the background. An
+// empty visitorID still counts the visit but can't count as a new visitor.
+func (a *AnalyticsService) RecordVisit(visitorID string) {
+	a.mu.Lock()
+	a.totalVisits++
+	checkNew := visitorID != "" && !a.knownVisitors[visitorID]
+	if visitorID != "" {
+		a.knownVisitors[visitorID] = true
+	}
+	snapshot := a.snapshotLocked()
+	a.mu.Unlock()
+
+	a.enqueue("visit", visitorID, "", checkNew)
+
+	// Log analytics every 10 visits
+	if snapshot.TotalVisits%10 == 0 {
+		logAnalyticsSnapshot(snapshot)
+	}
+}
+
+// RecordLogin counts a login and persists it in the background; visitorID
+// ties the login to the browser's funnel record
+func (a *AnalyticsService) RecordLogin(userID, visitorID string) {
+	a.mu.Lock()
+	a.totalLogins++
+	checkNew := !a.knownUsers[userID]
+	a.knownUsers[userID] = true
+	snapshot := a.snapshotLocked()
+	a.mu.Unlock()
+
+	log.Printf("🔄 User login | Total logins: %d | Unique users: %d", snapshot.TotalLogins, snapshot.UniqueUsers)
+
+	a.enqueue("login", visitorID, userID, checkNew)
+
+	// Log full analytics every 5 logins
+	if snapshot.TotalLogins%5 == 0 {
+		logAnalyticsSnapshot(snapshot)
+	}
+}
+
+// Totals adds up the stored daily rollups, so every instance reports the
+// same numbers, and returns today's (UTC) rollup alongside. Events still
+// queued on any instance are not included yet.
+func (a *AnalyticsService) Totals() (AnalyticsSnapshot, AnalyticsRollup, error) {
+	rollups, err := a.store.ListAnalyticsRollups()
+	if err != nil {
+		return AnalyticsSnapshot{}, AnalyticsRollup{}, err
+	}
+
+	todayKey := time.Now().UTC().Format("2006-01-02")
+	var totals AnalyticsSnapshot
+	today := AnalyticsRollup{Day: todayKey}
+	for _, rollup := range rollups {
+		totals.TotalVisits += rollup.Visits
+		totals.UniqueVisitors += rollup.NewVisitors
+		totals.TotalLogins += rollup.Logins
+		totals.UniqueUsers += rollup.NewUsers
+		if rollup.Day == todayKey {
+			today = rollup
+		}
+	}
+	return totals, today, nil
+}
+
+// Snapshot is this instance's view of the counters: the stored totals at
+// startup plus what this instance has recorded since. Use Totals for
+// numbers that agree across instances.
+func (a *AnalyticsService) Snapshot() AnalyticsSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snapshotLocked()
+}
+
+func (a *AnalyticsService) snapshotLocked() AnalyticsSnapshot {
+	return AnalyticsSnapshot{
+		TotalVisits:    a.totalVisits,
+		UniqueVisitors: a.uniqueVisitors,
+		TotalLogins:    a.totalLogins,
+		UniqueUsers:    a.uniqueUsers,
+	}
+}
+
+func (a *AnalyticsService) LogSummary() {
+	logAnalyticsSnapshot(a.Snapshot())
+}
+
+func logAnalyticsSnapshot(s AnalyticsSnapshot) {
+	log.Printf("📊 ANALYTICS - Total Visits: %d | Unique Visitors: %d | Total Logins: %d | Unique Users: %d",
+		s.TotalVisits, s.UniqueVisitors, s.TotalLogins, s.UniqueUsers)
+}
+
+// enqueue hands an event to the writer, waiting briefly if the queue is full
+func (a *AnalyticsService) enqueue(eventType, visitorID, userID string, checkNew bool) {
+	now := time.Now()
+	event := AnalyticsEvent{
+		ID:        fmt.Sprintf("%s_%d_%d", eventType, now.UnixNano(), a.sequence.Add(1)),
+		EventType: eventType,
+		Timestamp: now,
+		VisitorID: visitorID,
+		UserID:    userID,
+		ExpiresAt: now.Add(analyticsEventTTL).Unix(),
+		checkNew:  checkNew,
+	}
+
+	a.queueMu.RLock()
+	defer a.queueMu.RUnlock()
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+
+	select {
+	case a.queue <- event:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(analyticsEnqueueWait)
+	defer timer.Stop()
+	select {
+	case a.queue <- event:
+	case <-timer.C:
+		if n := a.dropped.Add(1); n%100 == 1 {
+			log.Printf("⚠️  Analytics queue full, dropped %d events so far", n)
+		}
+	}
+}
+
+// runEventWriter drains the queue in batches until Close is called
+func (a *AnalyticsService) runEventWriter(flushInterval time.Duration) {
+	defer close(a.stopped)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AnalyticsEvent, 0, analyticsBatchSize)
+	for {
+		select {
+		case event, ok := <-a.queue:
+			if !ok {
+				a.writeEvents(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) == analyticsBatchSize {
+				a.writeEvents(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				a.writeEvents(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// writeEvents resolves which events are new visitors/users, stores the raw
+// events and adds them to the daily rollups
+func (a *AnalyticsService) writeEvents(events []AnalyticsEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	rollups := make(map[string]*AnalyticsRollup)
+	for i := range events {
+		event := &events[i]
+		if event.checkNew {
+			a.resolveNew(event)
+		}
+
+		day := event.Timestamp.UTC().Format("2006-01-02")
+		rollup, ok := rollups[day]
+		if !ok {
+			rollup = &AnalyticsRollup{Day: day}
+			rollups[day] = rollup
+		}
+		addToRollup(rollup, event)
+		a.applyFunnel(event)
+	}
+
+	if err := a.store.PutAnalyticsEvents(events); err != nil {
+		log.Printf("Warning: Failed to store analytics events: %v", err)
+	}
+	for _, rollup := range rollups {
+		if err := a.store.AddAnalyticsRollup(rollup); err != nil {
+			log.Printf("Warning: Failed to update analytics rollup for %s: %v", rollup.Day, err)
+		}
+	}
+}
+
+// resolveNew marks the event new if this is the first time its visitor or
+// user has been recorded, and bumps the matching unique counter
+func (a *AnalyticsService) resolveNew(event *AnalyticsEvent) {
+	isNew, err := a.store.MarkAnalyticsSeen(seenKey(event), event.Timestamp)
+	if err != nil {
+		log.Printf("Warning: Failed to check analytics seen key: %v", err)
+		return
+	}
+	if !isNew {
+		return
+	}
+	event.IsNew = true
+
+	a.mu.Lock()
+	if event.EventType == "login" {
+		a.uniqueUsers++
+	} else {
+		a.uniqueVisitors++
+	}
+	snapshot := a.snapshotLocked()
+	a.mu.Unlock()
+
+	if event.EventType == "login" {
+		log.Printf("🎉 New user login | Total logins: %d | Unique users: %d", snapshot.TotalLogins, snapshot.UniqueUsers)
+	} else {
+		log.Printf("🆕 New visitor | Total visits: %d | Unique visitors: %d",
+			snapshot.TotalVisits, snapshot.UniqueVisitors)
+	}
+}
+
+// seenKey identifies the visitor or user an event belongs to. Events from
+// before visitor IDs fall back to the IP they were keyed by then.
+func seenKey(event *AnalyticsEvent) string {
+	if event.EventType == "login" {
+		return "user:" + event.UserID
+	}
+	if event.VisitorID != "" {
+		return "visitor:" + event.VisitorID
+	}
+	return "visitor:" + event.IP
+}
+
+func addToRollup(rollup *AnalyticsRollup, event *AnalyticsEvent) {
+	switch event.EventType {
+	case "visit":
+		rollup.Visits++
+		if event.IsNew {
+			rollup.NewVisitors++
+		}
+	case "login":
+		rollup.Logins++
+		if event.IsNew {
+			rollup.NewUsers++
+		}
+	}
+}
+
+// Close stops accepting events and waits for queued ones to be written
+func (a *AnalyticsService) Close(ctx context.Context) error {
+	a.queueMu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.queueMu.Unlock()
+
+	select {
+	case <-a.stopped:
+		if n := a.dropped.Load(); n > 0 {
+			log.Printf("📊 Analytics writer stopped, %d events were dropped under load", n)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("analytics flush interrupted: %v", ctx.Err())
+	}
+}
+
+// Load sets the counters from the daily rollups. The first start after
+// rollups were introduced builds them from the raw events instead.
+func (a *AnalyticsService) Load() error {
+	rollups, err := a.store.ListAnalyticsRollups()
+	if err != nil {
+		return err
+	}
+	if len(rollups) == 0 {
+		if rollups, err = a.backfillRollups(); err != nil {
+			return fmt.Errorf("failed to backfill analytics rollups: %v", err)
+		}
+	}
+
+	var total AnalyticsRollup
+	for _, rollup := range rollups {
+		total.Visits += rollup.Visits
+		total.Logins += rollup.Logins
+		total.NewVisitors += rollup.NewVisitors
+		total.NewUsers += rollup.NewUsers
+	}
+
+	// Add rather than replace, keeping anything recorded while loading
+	a.mu.Lock()
+	a.totalVisits += total.Visits
+	a.totalLogins += total.Logins
+	a.uniqueVisitors += total.NewVisitors
+	a.uniqueUsers += total.NewUsers
+	snapshot := a.snapshotLocked()
+	a.mu.Unlock()
+
+	log.Printf("📊 Loaded analytics from %d daily rollups: %d visits, %d unique visitors, %d logins, %d unique users",
+		len(rollups), snapshot.TotalVisits, snapshot.UniqueVisitors, snapshot.TotalLogins, snapshot.UniqueUsers)
+	return nil
+}
+
+// backfillRollups does the one-time migration from raw events: it builds
+// the daily rollups and seen keys, and stamps old events with an expiry
+func (a *AnalyticsService) backfillRollups() ([]AnalyticsRollup, error) {
+	events, err := a.store.ListAnalyticsEvents()
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	// Oldest first, so "new" goes to each visitor's first event
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+
+	seen := make(map[string]bool)
+	byDay := make(map[string]*AnalyticsRollup)
+	var stamped []AnalyticsEvent
+	for i := range events {
+		event := &events[i]
+		key := seenKey(event)
+		event.IsNew = !seen[key]
+		if event.IsNew {
+			seen[key] = true
+			if _, err := a.store.MarkAnalyticsSeen(key, event.Timestamp); err != nil {
+				log.Printf("Warning: Failed to record analytics seen key: %v", err)
+			}
+		}
+
+		day := event.Timestamp.UTC().Format("2006-01-02")
+		if byDay[day] == nil {
+			byDay[day] = &AnalyticsRollup{Day: day}
+		}
+		addToRollup(byDay[day], event)
+
+		if event.ExpiresAt == 0 {
+			event.ExpiresAt = event.Timestamp.Add(analyticsEventTTL).Unix()
+			stamped = append(stamped, *event)
+		}
+	}
+
+	if err := a.store.PutAnalyticsEvents(stamped); err != nil {
+		log.Printf("Warning: Failed to stamp analytics events with an expiry: %v", err)
+	}
+
+	rollups := make([]AnalyticsRollup, 0, len(byDay))
+	for _, rollup := range byDay {
+		if err := a.store.AddAnalyticsRollup(rollup); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, *rollup)
+	}
+
+	a.mu.Lock()
+	for key := range seen {
+		if visitorID, ok := strings.CutPrefix(key, "visitor:"); ok {
+			a.knownVisitors[visitorID] = true
+		} else if userID, ok := strings.CutPrefix(key, "user:"); ok {
+			a.knownUsers[userID] = true
+		}
+	}
+	a.mu.Unlock()
+
+	log.Printf("📊 Backfilled %d analytics rollups from %d events", len(rollups), len(events))
+	return rollups, nil
+}
+
+// runAnalyticsReport logs the counters periodically
+func (a *AnalyticsService) runAnalyticsReport(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		log.Println("⏰ HOURLY ANALYTICS REPORT:")
+		a.LogSummary()
+	}
+}
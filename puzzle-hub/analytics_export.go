@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Anonymized analytics export
+//
+// A nightly job writes the previous UTC day's analytics events to
+// s3://ANALYTICS_EXPORT_S3_BUCKET/analytics-events/dt=YYYY-MM-DD/events.jsonl.gz,
+// one JSON object per line, so Athena can query them with a table
+// partitioned on dt. IPs are dropped and user IDs are replaced by an HMAC
+// keyed with the month's visitor salt (see visitors.go), so exported events
+// can be joined within a month but not traced back to an account.
+
+const analyticsExportPrefix = "analytics-events"
+
+// ExportedEvent is one line of an export file
+type ExportedEvent struct {
+	EventType string    `json:"event_type"`
+	Timestamp time.Time `json:"timestamp"`
+	VisitorID string    `json:"visitor_id,omitempty"`
+	UserHash  string    `json:"user_hash,omitempty"`
+	IsNew     bool      `json:"is_new"`
+}
+
+// exportYesterdaysEvents exports yesterday's events unless an instance
+// already has; the job-runs table makes this safe across restarts. It is
+// scheduled in main.
+func (h *PuzzleHub) exportYesterdaysEvents(ctx context.Context) error {
+	day := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+
+	claimed, err := h.claimJobRun("analytics-export", day)
+	if err != nil {
+		return fmt.Errorf("failed to claim %s: %v", day, err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	count, key, err := h.exportAnalyticsDay(day)
+	if err != nil {
+		// Release the claim so the next run retries
+		h.releaseJobRun("analytics-export", day)
+		return fmt.Errorf("export for %s failed: %v", day, err)
+	}
+	log.Printf("📤 Exported %d analytics events for %s to %s", count, day, key)
+	return nil
+}
+
+// exportAnalyticsDay writes one UTC day's events to S3, replacing any
+// earlier export of that day
+func (h *PuzzleHub) exportAnalyticsDay(day string) (int, string, error) {
+	start, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid day %q: %v", day, err)
+	}
+	end := start.AddDate(0, 0, 1)
+
+	events, err := h.getAnalyticsEventsBetween(start, end)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, event := range events {
+		exported := ExportedEvent{
+			EventType: event.EventType,
+			Timestamp: event.Timestamp.UTC(),
+			VisitorID: event.VisitorID,
+			IsNew:     event.IsNew,
+		}
+		if event.UserID != "" {
+			userHash, err := h.Analytics.hashVisitor("user:"+event.UserID, event.Timestamp)
+			if err != nil {
+				return 0, "", fmt.Errorf("failed to hash user ID: %v", err)
+			}
+			exported.UserHash = userHash
+		}
+		if err := encoder.Encode(exported); err != nil {
+			return 0, "", fmt.Errorf("failed to encode event: %v", err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return 0, "", fmt.Errorf("failed to compress export: %v", err)
+	}
+
+	key := fmt.Sprintf("%s/dt=%s/events.jsonl.gz", analyticsExportPrefix, day)
+	_, err = h.S3.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(h.AnalyticsExportBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to upload export: %v", err)
+	}
+	return len(events), key, nil
+}
+
+// getAnalyticsEventsBetween returns raw events in [start, end), oldest
+// first. Timestamps may carry different zone offsets, so the range is
+// applied after reading rather than in the store.
+func (h *PuzzleHub) getAnalyticsEventsBetween(start, end time.Time) ([]AnalyticsEvent, error) {
+	all, err := h.Store.ListAnalyticsEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []AnalyticsEvent{}
+	for _, event := range all {
+		if !event.Timestamp.Before(start) && event.Timestamp.Before(end) {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events, nil
+}
+
+// adminExportAnalytics exports one day (?day=YYYY-MM-DD, default yesterday)
+// on demand
+func (h *PuzzleHub) adminExportAnalytics(c *gin.Context) {
+	if h.AnalyticsExportBucket == "" {
+		respondError(c, http.StatusServiceUnavailable, "Analytics export is not configured")
+		return
+	}
+
+	day := c.DefaultQuery("day", time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02"))
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		respondError(c, http.StatusBadRequest, "day must be YYYY-MM-DD")
+		return
+	}
+
+	count, key, err := h.exportAnalyticsDay(day)
+	if err != nil {
+		requestLogger(c).Error("error exporting analytics", "error", err, "day", day)
+		respondStorageError(c, err, "Failed to export analytics")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Analytics exported successfully",
+		"day":     day,
+		"events":  count,
+		"s3_key":  key,
+	})
+}
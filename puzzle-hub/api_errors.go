@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Error responses. Every API error has the same envelope:
+//
+//	{"error": {"code": "not_found", "message": "Log type not found",
+//	           "details": ..., "request_id": "..."}}
+//
+// code is stable and meant for clients to branch on; message is for people
+// and is translated into the request's language (see i18n.go).
+// details is optional: field-level messages for invalid request bodies, or
+// extra context such as the screen-time usage. request_id matches the
+// X-Request-ID header and the server logs.
+
+// APIError is the body of an error response
+type APIError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// FieldError describes one invalid field in a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// errorCodes is the default code for each status
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "upstream_error",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+func init() {
+	// Report fields by their JSON names rather than the Go struct names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// respondError writes an error with the default code for status
+func respondError(c *gin.Context, status int, message string) {
+	respondErrorCode(c, status, "", message, nil)
+}
+
+// respondErrorCode writes an error with a specific code and optional
+// details. An empty code uses the default for status.
+func respondErrorCode(c *gin.Context, status int, code, message string, details interface{}) {
+	c.JSON(status, gin.H{"error": newAPIError(c, status, code, message, details)})
+}
+
+// abortWithError writes an error and stops the handler chain, for middleware
+func abortWithError(c *gin.Context, status int, code, message string, details interface{}) {
+	c.AbortWithStatusJSON(status, gin.H{"error": newAPIError(c, status, code, message, details)})
+}
+
+func newAPIError(c *gin.Context, status int, code, message string, details interface{}) APIError {
+	if code == "" {
+		code = errorCodes[status]
+		if code == "" {
+			code = "error"
+		}
+	}
+	return APIError{
+		Code:      code,
+		Message:   translate(requestLanguage(c), message),
+		Details:   details,
+		RequestID: c.GetString("request_id"),
+	}
+}
+
+// respondBindError answers 400 for a request body that failed to bind.
+// Field problems are reported as validation_failed with a FieldError each.
+func respondBindError(c *gin.Context, err error) {
+	message, fields := describeBindError(err, requestLanguage(c))
+	if len(fields) == 0 {
+		respondError(c, http.StatusBadRequest, message)
+		return
+	}
+	respondErrorCode(c, http.StatusBadRequest, "validation_failed", message, fields)
+}
+
+// describeBindError turns a binding error into a summary and field errors,
+// with the field messages in language
+func describeBindError(err error, language string) (string, []FieldError) {
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &validationErrs):
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{Field: fieldPath(fe), Message: validationMessage(fe, language)})
+		}
+		return "Some fields are missing or invalid", fields
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			return "Request body has the wrong type", nil
+		}
+		return "Some fields are missing or invalid", []FieldError{{
+			Field:   field,
+			Message: localize(language, "must be %s", translate(language, jsonTypeName(typeErr.Type))),
+		}}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return "Request body is not valid JSON", nil
+	case errors.Is(err, io.EOF):
+		return "Request body is required", nil
+	}
+	return "Invalid request body", nil
+}
+
+// fieldPath is the JSON path of the field, without the top-level struct
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if _, rest, ok := strings.Cut(namespace, "."); ok {
+		return rest
+	}
+	return fe.Field()
+}
+
+func validationMessage(fe validator.FieldError, language string) string {
+	switch fe.Tag() {
+	case "required":
+		return translate(language, "is required")
+	case "min":
+		return localize(language, "must be at least %s", fe.Param())
+	case "max":
+		return localize(language, "must be at most %s", fe.Param())
+	case "oneof":
+		return localize(language, "must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "email":
+		return translate(language, "must be an email address")
+	}
+	return localize(language, "is invalid (%s)", fe.Tag())
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	}
+	return "an object"
+}
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Homework assignments
+//
+// A teacher assigns an activity to a classroom with a due date and a target
+// number of sessions: "5 yohaku puzzles", a spelling list, a quiz topic or a
+// writing prompt. Students see their assignments with GET /assignments and
+// nothing else is needed to hand one in: saveActivityResult counts every
+// finished session of the assigned activity towards each open assignment,
+// and sessions after the due date still count but mark the work late. The
+// teacher's grading view lists every student's status and scores, and the
+// teacher can add a grade and comment per student. Completions and grades
+// also go to the classroom's LMS when one is linked, see lms.go.
+//
+// Assignments are stored per classroom and cached, since every finished
+// session looks them up; submissions are one row per assignment and
+// student.
+
+const (
+	maxAssignmentTarget = 50
+	maxAssignmentWords  = 50
+	maxAssignmentPrompt = 1000
+	assignmentsCacheTTL = 5 * time.Minute
+
+	assignmentNotStarted = "not_started"
+	assignmentInProgress = "in_progress"
+	assignmentCompleted  = "completed"
+	assignmentLate       = "late" // Completed after the due date
+	assignmentMissing    = "missing"
+)
+
+type Assignment struct {
+	ClassroomID  string    `json:"classroom_id" dynamodbav:"classroom_id"`
+	ID           string    `json:"id" dynamodbav:"assignment_id"` // asg_<unix nanos>
+	TeacherID    string    `json:"teacher_id" dynamodbav:"teacher_id"`
+	Title        string    `json:"title" dynamodbav:"title"`
+	Instructions string    `json:"instructions,omitempty" dynamodbav:"instructions,omitempty"`
+	Activity     string    `json:"activity" dynamodbav:"activity"`
+	Target       int       `json:"target" dynamodbav:"target"`                             // Sessions needed to complete it
+	Words        []string  `json:"words,omitempty" dynamodbav:"words,omitempty"`           // Spelling list
+	Topic        string    `json:"topic,omitempty" dynamodbav:"topic,omitempty"`           // Quiz topic
+	Prompt       string    `json:"prompt,omitempty" dynamodbav:"prompt,omitempty"`         // Writing or story prompt
+	Difficulty   string    `json:"difficulty,omitempty" dynamodbav:"difficulty,omitempty"` // Passed through to the game
+	DueAt        time.Time `json:"due_at" dynamodbav:"due_at"`
+	CreatedAt    time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// AssignmentSubmission is one student's work on an assignment
+type AssignmentSubmission struct {
+	AssignmentID string     `json:"assignment_id" dynamodbav:"assignment_id"`
+	UserID       string     `json:"user_id" dynamodbav:"user_id"`
+	Sessions     int        `json:"sessions" dynamodbav:"sessions"`
+	PercentTotal float64    `json:"-" dynamodbav:"percent_total"`
+	BestPercent  float64    `json:"best_percent" dynamodbav:"best_percent"`
+	Minutes      float64    `json:"minutes" dynamodbav:"minutes"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty" dynamodbav:"completed_at,omitempty"`
+	LastActiveAt time.Time  `json:"last_active_at" dynamodbav:"last_active_at"`
+	Grade        string     `json:"grade,omitempty" dynamodbav:"grade,omitempty"`
+	Comment      string     `json:"comment,omitempty" dynamodbav:"comment,omitempty"`
+	GradedAt     *time.Time `json:"graded_at,omitempty" dynamodbav:"graded_at,omitempty"`
+}
+
+type CreateAssignmentRequest struct {
+	Title        string    `json:"title" binding:"required"`
+	Instructions string    `json:"instructions"`
+	Activity     string    `json:"activity" binding:"required"`
+	Target       int       `json:"target"` // Defaults to 1
+	Words        []string  `json:"words"`
+	Topic        string    `json:"topic"`
+	Prompt       string    `json:"prompt"`
+	Difficulty   string    `json:"difficulty"`
+	DueAt        time.Time `json:"due_at" binding:"required"`
+}
+
+type GradeAssignmentRequest struct {
+	Grade   string `json:"grade"`
+	Comment string `json:"comment"`
+}
+
+// StudentAssignment is an assignment as a student sees it
+type StudentAssignment struct {
+	Assignment
+	ClassroomName  string  `json:"classroom_name"`
+	Status         string  `json:"status"`
+	Sessions       int     `json:"sessions"`
+	AveragePercent float64 `json:"average_percent"`
+	Grade          string  `json:"grade,omitempty"`
+	Comment        string  `json:"comment,omitempty"`
+}
+
+// AssignmentGradeRow is one student's line in the teacher's grading view
+type AssignmentGradeRow struct {
+	UserID         string     `json:"user_id"`
+	Name           string     `json:"name"`
+	Status         string     `json:"status"`
+	Sessions       int        `json:"sessions"`
+	AveragePercent float64    `json:"average_percent"`
+	BestPercent    float64    `json:"best_percent"`
+	Minutes        float64    `json:"minutes"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	LastActiveAt   *time.Time `json:"last_active_at,omitempty"`
+	Grade          string     `json:"grade,omitempty"`
+	Comment        string     `json:"comment,omitempty"`
+}
+
+func assignmentsCacheKey(classroomID string) string {
+	return "assignments:" + classroomID
+}
+
+// validateAssignment checks a request and returns the problem, or ""
+func validateAssignment(request *CreateAssignmentRequest, now time.Time) string {
+	request.Title = strings.TrimSpace(request.Title)
+	if request.Title == "" || len(request.Title) > 100 {
+		return "Title must be between 1 and 100 characters"
+	}
+	if len(request.Instructions) > maxAssignmentPrompt {
+		return fmt.Sprintf("Instructions must be at most %d characters", maxAssignmentPrompt)
+	}
+	if !progressActivities[request.Activity] {
+		return "Activity must be spelling, writing, story, yohaku, math_facts, sudoku, quiz or flashcards"
+	}
+	if request.Target == 0 {
+		request.Target = 1
+	}
+	if request.Target < 1 || request.Target > maxAssignmentTarget {
+		return fmt.Sprintf("Target must be between 1 and %d sessions", maxAssignmentTarget)
+	}
+	if !request.DueAt.After(now) {
+		return "Due date must be in the future"
+	}
+	if request.DueAt.After(now.AddDate(1, 0, 0)) {
+		return "Due date must be within a year"
+	}
+	if len(request.Words) > 0 && request.Activity != "spelling" {
+		return "Words can only be given for spelling"
+	}
+	if len(request.Words) > maxAssignmentWords {
+		return fmt.Sprintf("A spelling list can have at most %d words", maxAssignmentWords)
+	}
+	for i, word := range request.Words {
+		request.Words[i] = strings.TrimSpace(word)
+		if request.Words[i] == "" || len(request.Words[i]) > 50 {
+			return "Spelling words must be between 1 and 50 characters"
+		}
+	}
+	if request.Topic != "" {
+		if request.Activity != "quiz" {
+			return "A topic can only be given for quizzes"
+		}
+		if _, ok := quizTopics[request.Topic]; !ok {
+			return "Unknown quiz topic"
+		}
+	}
+	if request.Prompt != "" {
+		if request.Activity != "writing" && request.Activity != "story" {
+			return "A prompt can only be given for writing or stories"
+		}
+		if len(request.Prompt) > maxAssignmentPrompt {
+			return fmt.Sprintf("Prompt must be at most %d characters", maxAssignmentPrompt)
+		}
+	}
+	if request.Difficulty != "" && !validDifficulties[DifficultyLevel(request.Difficulty)] {
+		return "Difficulty must be elementary, middle, intermediate or advanced"
+	}
+	return ""
+}
+
+// createAssignment assigns work to a classroom and tells each student
+func (h *PuzzleHub) createAssignment(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+	teacher := c.MustGet("user").(*User)
+
+	var request CreateAssignmentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	now := time.Now()
+	if problem := validateAssignment(&request, now); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+
+	assignment := Assignment{
+		ClassroomID:  classroom.ID,
+		ID:           fmt.Sprintf("asg_%d", now.UnixNano()),
+		TeacherID:    teacher.ID,
+		Title:        request.Title,
+		Instructions: strings.TrimSpace(request.Instructions),
+		Activity:     request.Activity,
+		Target:       request.Target,
+		Words:        request.Words,
+		Topic:        request.Topic,
+		Prompt:       strings.TrimSpace(request.Prompt),
+		Difficulty:   request.Difficulty,
+		DueAt:        request.DueAt.UTC(),
+		CreatedAt:    now,
+	}
+	item, err := dynamodbattribute.MarshalMap(assignment)
+	if err != nil {
+		requestLogger(c).Error("error marshaling assignment", "error", err)
+		respondStorageError(c, err, "Failed to create assignment")
+		return
+	}
+	_, err = h.DynamoDB.PutItemWithContext(c.Request.Context(), &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-assignments")),
+		Item:      item,
+	})
+	if err != nil {
+		requestLogger(c).Error("error saving assignment", "error", err)
+		respondStorageError(c, err, "Failed to create assignment")
+		return
+	}
+	invalidateCache(c.Request.Context(), h.Cache, assignmentsCacheKey(classroom.ID))
+
+	members, err := h.getClassroomMembers(classroom.ID)
+	if err != nil {
+		// The assignment is saved; students still see it in their list
+		requestLogger(c).Error("error fetching roster", "error", err, "classroom_id", classroom.ID)
+	}
+	for _, member := range members {
+		language := h.recipientLanguage(member.UserID)
+		h.notify(c.Request.Context(), Notification{
+			UserID: member.UserID,
+			Kind:   notificationAssignment,
+			Title:  localize(language, "New assignment: %s", assignment.Title),
+			Body:   localize(language, "%s, due %s", classroom.Name, localizeDate(language, assignment.DueAt)),
+			Link:   "/assignments",
+			Data:   map[string]string{"classroom_id": classroom.ID, "assignment_id": assignment.ID},
+		}, member.Email)
+	}
+
+	log.Printf("📚 %s assigned %s to class %s", teacher.ID, assignment.ID, classroom.ID)
+	c.JSON(http.StatusCreated, assignment)
+}
+
+// listClassroomAssignments lists a class's assignments with how many
+// students have completed each (teacher only)
+func (h *PuzzleHub) listClassroomAssignments(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	assignments, err := h.getClassroomAssignments(ctx, classroom.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching assignments", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to fetch assignments")
+		return
+	}
+	members, err := h.getClassroomMembers(classroom.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching roster", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to fetch assignments")
+		return
+	}
+
+	type assignmentOverview struct {
+		Assignment
+		Completed   int `json:"completed"`
+		InProgress  int `json:"in_progress"`
+		NotStarted  int `json:"not_started"`
+		NeedsGrades int `json:"needs_grades"` // Completed but not graded
+	}
+	overviews := make([]assignmentOverview, 0, len(assignments))
+	for _, assignment := range assignments {
+		submissions, err := h.getAssignmentSubmissions(ctx, assignment.ID)
+		if err != nil {
+			requestLogger(c).Error("error fetching submissions", "error", err, "assignment_id", assignment.ID)
+			respondStorageError(c, err, "Failed to fetch assignments")
+			return
+		}
+		overview := assignmentOverview{Assignment: assignment}
+		for _, member := range members {
+			submission := submissions[member.UserID]
+			switch {
+			case submission == nil:
+				overview.NotStarted++
+			case submission.CompletedAt != nil:
+				overview.Completed++
+				if submission.GradedAt == nil {
+					overview.NeedsGrades++
+				}
+			default:
+				overview.InProgress++
+			}
+		}
+		overviews = append(overviews, overview)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"classroom":     classroom,
+		"assignments":   overviews,
+		"student_count": len(members),
+	})
+}
+
+// getAssignmentGrades is the teacher's grading view of one assignment
+func (h *PuzzleHub) getAssignmentGrades(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	assignment, ok := h.requireAssignment(c, classroom.ID)
+	if !ok {
+		return
+	}
+	members, err := h.getClassroomMembers(classroom.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching roster", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to fetch grades")
+		return
+	}
+	submissions, err := h.getAssignmentSubmissions(ctx, assignment.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching submissions", "error", err, "assignment_id", assignment.ID)
+		respondStorageError(c, err, "Failed to fetch grades")
+		return
+	}
+
+	now := time.Now()
+	rows := make([]AssignmentGradeRow, 0, len(members))
+	for _, member := range members {
+		submission := submissions[member.UserID]
+		row := AssignmentGradeRow{
+			UserID: member.UserID,
+			Name:   member.Name,
+			Status: assignmentStatus(assignment, submission, now),
+		}
+		if submission != nil {
+			row.Sessions = submission.Sessions
+			row.AveragePercent = submission.averagePercent()
+			row.BestPercent = math.Round(submission.BestPercent*10) / 10
+			row.Minutes = math.Round(submission.Minutes*10) / 10
+			row.CompletedAt = submission.CompletedAt
+			if !submission.LastActiveAt.IsZero() {
+				lastActive := submission.LastActiveAt
+				row.LastActiveAt = &lastActive
+			}
+			row.Grade = submission.Grade
+			row.Comment = submission.Comment
+		}
+		rows = append(rows, row)
+	}
+
+	counts := map[string]int{}
+	for _, row := range rows {
+		counts[row.Status]++
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"assignment": assignment,
+		"students":   rows,
+		"counts":     counts,
+	})
+}
+
+// gradeAssignment records the teacher's grade and comment for one student
+func (h *PuzzleHub) gradeAssignment(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+	assignment, ok := h.requireAssignment(c, classroom.ID)
+	if !ok {
+		return
+	}
+
+	var request GradeAssignmentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	request.Grade = strings.TrimSpace(request.Grade)
+	request.Comment = strings.TrimSpace(request.Comment)
+	if len(request.Grade) > 20 {
+		respondError(c, http.StatusBadRequest, "Grade must be at most 20 characters")
+		return
+	}
+	if len(request.Comment) > maxAssignmentPrompt {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Comment must be at most %d characters", maxAssignmentPrompt))
+		return
+	}
+
+	members, err := h.getClassroomMembers(classroom.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching roster", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to save grade")
+		return
+	}
+	var student *ClassroomMember
+	for i := range members {
+		if members[i].UserID == c.Param("userId") {
+			student = &members[i]
+		}
+	}
+	if student == nil {
+		respondError(c, http.StatusNotFound, "Student is not in this class")
+		return
+	}
+
+	// Students can be graded before they start, e.g. to note an excused absence
+	now := time.Now()
+	out, err := h.DynamoDB.UpdateItemWithContext(c.Request.Context(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-assignment-submissions")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"assignment_id": {S: aws.String(assignment.ID)},
+			"user_id":       {S: aws.String(c.Param("userId"))},
+		},
+		UpdateExpression: aws.String("SET grade = :grade, #comment = :comment, graded_at = :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#comment": aws.String("comment"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":grade":   {S: aws.String(request.Grade)},
+			":comment": {S: aws.String(request.Comment)},
+			":now":     {S: aws.String(now.Format(time.RFC3339Nano))},
+		},
+		ReturnValues: aws.String("ALL_NEW"),
+	})
+	if err != nil {
+		requestLogger(c).Error("error grading", "error", err, "assignment_id", assignment.ID, "target_user_id", c.Param("userId"))
+		respondStorageError(c, err, "Failed to save grade")
+		return
+	}
+	var submission AssignmentSubmission
+	if err := dynamodbattribute.UnmarshalMap(out.Attributes, &submission); err != nil {
+		requestLogger(c).Error("error unmarshaling submission", "error", err)
+		respondStorageError(c, err, "Failed to save grade")
+		return
+	}
+	h.queueLMSEvent(c.Request.Context(), LMSEvent{
+		Event:       lmsAssignmentGraded,
+		ClassroomID: classroom.ID,
+		Student:     lmsStudentFor(*student),
+		Assignment:  lmsAssignmentResult(assignment, &submission, now),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Grade saved",
+		"submission": submission,
+	})
+}
+
+// deleteAssignment removes an assignment; submissions are left behind and
+// no longer shown
+func (h *PuzzleHub) deleteAssignment(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-assignments")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"classroom_id":  {S: aws.String(classroom.ID)},
+			"assignment_id": {S: aws.String(c.Param("assignmentId"))},
+		},
+		ConditionExpression: aws.String("attribute_exists(assignment_id)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusNotFound, "Assignment not found")
+			return
+		}
+		requestLogger(c).Error("error deleting assignment", "error", err, "assignment_id", c.Param("assignmentId"))
+		respondStorageError(c, err, "Failed to delete assignment")
+		return
+	}
+	invalidateCache(c.Request.Context(), h.Cache, assignmentsCacheKey(classroom.ID))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Assignment deleted"})
+}
+
+// listMyAssignments returns the signed-in student's assignments across
+// their classes, unfinished ones first, each by due date.
+// ?status= filters to one status.
+func (h *PuzzleHub) listMyAssignments(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	memberships, err := h.getMemberships(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching memberships", "error", err)
+		respondStorageError(c, err, "Failed to fetch assignments")
+		return
+	}
+
+	now := time.Now()
+	statusFilter := c.Query("status")
+	assignments := []StudentAssignment{}
+	for _, membership := range memberships {
+		classroom, err := h.getClassroom(membership.ClassroomID)
+		if err != nil || classroom == nil {
+			continue
+		}
+		classAssignments, err := h.getClassroomAssignments(ctx, classroom.ID)
+		if err != nil {
+			requestLogger(c).Error("error fetching assignments", "error", err, "classroom_id", classroom.ID)
+			respondStorageError(c, err, "Failed to fetch assignments")
+			return
+		}
+		for _, assignment := range classAssignments {
+			submission, err := h.getAssignmentSubmission(ctx, assignment.ID, userObj.ID)
+			if err != nil {
+				requestLogger(c).Error("error fetching submission", "error", err, "assignment_id", assignment.ID)
+				respondStorageError(c, err, "Failed to fetch assignments")
+				return
+			}
+			entry := StudentAssignment{
+				Assignment:    assignment,
+				ClassroomName: classroom.Name,
+				Status:        assignmentStatus(&assignment, submission, now),
+			}
+			if submission != nil {
+				entry.Sessions = submission.Sessions
+				entry.AveragePercent = submission.averagePercent()
+				entry.Grade = submission.Grade
+				entry.Comment = submission.Comment
+			}
+			if statusFilter == "" || entry.Status == statusFilter {
+				assignments = append(assignments, entry)
+			}
+		}
+	}
+
+	sort.Slice(assignments, func(i, j int) bool {
+		doneI := assignments[i].Status == assignmentCompleted || assignments[i].Status == assignmentLate
+		doneJ := assignments[j].Status == assignmentCompleted || assignments[j].Status == assignmentLate
+		if doneI != doneJ {
+			return !doneI
+		}
+		return assignments[i].DueAt.Before(assignments[j].DueAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"assignments": assignments,
+		"count":       len(assignments),
+	})
+}
+
+// recordAssignmentProgress counts a finished session towards the user's
+// assignments for that activity. It is called by saveActivityResult;
+// errors are logged so the session is still recorded.
+func (h *PuzzleHub) recordAssignmentProgress(ctx context.Context, user *User, result *ActivityResult) {
+	memberships, err := h.getMemberships(user.ID)
+	if err != nil {
+		log.Printf("Error fetching memberships for %s: %v", user.ID, err)
+		return
+	}
+
+	percent := result.Score / result.MaxScore * 100
+	for _, membership := range memberships {
+		assignments, err := h.getClassroomAssignments(ctx, membership.ClassroomID)
+		if err != nil {
+			log.Printf("Error fetching assignments for %s: %v", membership.ClassroomID, err)
+			continue
+		}
+		for _, assignment := range assignments {
+			if assignment.Activity != result.Activity || result.CreatedAt.Before(assignment.CreatedAt) {
+				continue
+			}
+
+			out, err := h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+				TableName: aws.String(tableName("puzzle-hub-assignment-submissions")),
+				Key: map[string]*dynamodb.AttributeValue{
+					"assignment_id": {S: aws.String(assignment.ID)},
+					"user_id":       {S: aws.String(user.ID)},
+				},
+				UpdateExpression: aws.String("ADD sessions :one, percent_total :percent, minutes :minutes SET last_active_at = :now"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":one":     {N: aws.String("1")},
+					":percent": {N: aws.String(strconv.FormatFloat(percent, 'f', -1, 64))},
+					":minutes": {N: aws.String(strconv.FormatFloat(float64(result.DurationSeconds)/60, 'f', -1, 64))},
+					":now":     {S: aws.String(result.CreatedAt.Format(time.RFC3339Nano))},
+				},
+				ReturnValues: aws.String("ALL_NEW"),
+			})
+			if err != nil {
+				log.Printf("Error recording %s towards %s: %v", result.ResultID, assignment.ID, err)
+				continue
+			}
+			var submission AssignmentSubmission
+			if err := dynamodbattribute.UnmarshalMap(out.Attributes, &submission); err != nil {
+				log.Printf("Error unmarshaling submission: %v", err)
+				continue
+			}
+
+			// Best score and completion are conditional so concurrent
+			// sessions can't lower the best or move the completion time
+			if percent > submission.BestPercent {
+				h.setSubmissionField(ctx, assignment.ID, user.ID, "best_percent",
+					&dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(percent, 'f', -1, 64))},
+					"attribute_not_exists(best_percent) OR best_percent < :value")
+			}
+			if submission.CompletedAt == nil && submission.Sessions >= assignment.Target {
+				completed := h.setSubmissionField(ctx, assignment.ID, user.ID, "completed_at",
+					&dynamodb.AttributeValue{S: aws.String(result.CreatedAt.Format(time.RFC3339Nano))},
+					"attribute_not_exists(completed_at)")
+				if completed {
+					submission.CompletedAt = &result.CreatedAt
+					submission.BestPercent = math.Max(submission.BestPercent, percent)
+					h.queueLMSEvent(ctx, LMSEvent{
+						Event:       lmsAssignmentCompleted,
+						ClassroomID: membership.ClassroomID,
+						Student:     lmsStudentFor(membership),
+						Assignment:  lmsAssignmentResult(&assignment, &submission, result.CreatedAt),
+					})
+				}
+			}
+		}
+	}
+}
+
+// setSubmissionField sets field when condition holds and reports whether
+// it did
+func (h *PuzzleHub) setSubmissionField(ctx context.Context, assignmentID, userID, field string, value *dynamodb.AttributeValue, condition string) bool {
+	_, err := h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-assignment-submissions")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"assignment_id": {S: aws.String(assignmentID)},
+			"user_id":       {S: aws.String(userID)},
+		},
+		UpdateExpression:          aws.String("SET " + field + " = :value"),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":value": value},
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		log.Printf("Error updating %s on submission %s/%s: %v", field, assignmentID, userID, err)
+	}
+	return err == nil
+}
+
+// assignmentStatus works out where a student is with an assignment
+func assignmentStatus(assignment *Assignment, submission *AssignmentSubmission, now time.Time) string {
+	switch {
+	case submission != nil && submission.CompletedAt != nil:
+		if submission.CompletedAt.After(assignment.DueAt) {
+			return assignmentLate
+		}
+		return assignmentCompleted
+	case now.After(assignment.DueAt):
+		return assignmentMissing
+	case submission != nil && submission.Sessions > 0:
+		return assignmentInProgress
+	default:
+		return assignmentNotStarted
+	}
+}
+
+func (s *AssignmentSubmission) averagePercent() float64 {
+	if s.Sessions == 0 {
+		return 0
+	}
+	return math.Round(s.PercentTotal/float64(s.Sessions)*10) / 10
+}
+
+// requireAssignment loads :assignmentId from the class, writing the error
+// response when it isn't there
+func (h *PuzzleHub) requireAssignment(c *gin.Context, classroomID string) (*Assignment, bool) {
+	assignments, err := h.getClassroomAssignments(c.Request.Context(), classroomID)
+	if err != nil {
+		requestLogger(c).Error("error fetching assignments", "error", err, "classroom_id", classroomID)
+		respondStorageError(c, err, "Failed to fetch assignment")
+		return nil, false
+	}
+	for i := range assignments {
+		if assignments[i].ID == c.Param("assignmentId") {
+			return &assignments[i], true
+		}
+	}
+	respondError(c, http.StatusNotFound, "Assignment not found")
+	return nil, false
+}
+
+// getClassroomAssignments returns a class's assignments by due date
+func (h *PuzzleHub) getClassroomAssignments(ctx context.Context, classroomID string) ([]Assignment, error) {
+	assignments := []Assignment{}
+	if getCachedJSON(ctx, h.Cache, "assignments", assignmentsCacheKey(classroomID), &assignments) {
+		return assignments, nil
+	}
+
+	assignments, err := queryLimit[Assignment](ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-assignments")),
+		KeyConditionExpression: aws.String("classroom_id = :classroom_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":classroom_id": {S: aws.String(classroomID)},
+		},
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].DueAt.Before(assignments[j].DueAt) })
+	setCachedJSON(ctx, h.Cache, assignmentsCacheKey(classroomID), assignments, assignmentsCacheTTL)
+	return assignments, nil
+}
+
+// getAssignmentSubmissions returns an assignment's submissions by user ID
+func (h *PuzzleHub) getAssignmentSubmissions(ctx context.Context, assignmentID string) (map[string]*AssignmentSubmission, error) {
+	stored, err := queryLimit[AssignmentSubmission](ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-assignment-submissions")),
+		KeyConditionExpression: aws.String("assignment_id = :assignment_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":assignment_id": {S: aws.String(assignmentID)},
+		},
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	submissions := make(map[string]*AssignmentSubmission, len(stored))
+	for i := range stored {
+		submissions[stored[i].UserID] = &stored[i]
+	}
+	return submissions, nil
+}
+
+func (h *PuzzleHub) getAssignmentSubmission(ctx context.Context, assignmentID, userID string) (*AssignmentSubmission, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-assignment-submissions")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"assignment_id": {S: aws.String(assignmentID)},
+			"user_id":       {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var submission AssignmentSubmission
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &submission); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal submission: %v", err)
+	}
+	return &submission, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Refresh tokens and access token revocation
+//
+// Access tokens (JWTs) are short-lived. Refresh tokens are opaque random
+// strings stored server-side by SHA-256 hash. Every refresh rotates the
+// refresh token; all tokens descending from one login share a family ID, and
+// presenting an already-rotated token revokes the whole family since it
+// means the token was copied.
+
+const (
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+type RefreshToken struct {
+	TokenHash  string    `json:"token_hash" dynamodbav:"token_hash"`
+	FamilyID   string    `json:"family_id" dynamodbav:"family_id"`
+	UserID     string    `json:"user_id" dynamodbav:"user_id"`
+	Email      string    `json:"email" dynamodbav:"email"`
+	Name       string    `json:"name" dynamodbav:"name"`
+	CreatedAt  time.Time `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt  int64     `json:"expires_at" dynamodbav:"expires_at"` // Unix seconds, also the table TTL
+	Revoked    bool      `json:"revoked" dynamodbav:"revoked"`
+	ReplacedBy string    `json:"replaced_by,omitempty" dynamodbav:"replaced_by,omitempty"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshAuthTokens exchanges a refresh token for a new access/refresh pair
+func (h *PuzzleHub) refreshAuthTokens(c *gin.Context) {
+	var request RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	stored, err := h.Store.GetRefreshToken(hashToken(request.RefreshToken))
+	if err != nil {
+		requestLogger(c).Error("error fetching refresh token", "error", err)
+		respondStorageError(c, err, "Failed to refresh session")
+		return
+	}
+	if stored == nil || time.Now().Unix() >= stored.ExpiresAt {
+		respondError(c, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	if stored.Revoked {
+		requestLogger(c).Error("reuse of rotated refresh token, revoking session family", "user_id", stored.UserID, "family_id", stored.FamilyID)
+		h.revokeRefreshFamily(stored.UserID, stored.FamilyID)
+		respondError(c, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	user := h.getOrRestoreUser(stored.UserID, stored.Email, stored.Name)
+
+	newToken, err := randomToken(32)
+	if err != nil {
+		requestLogger(c).Error("error generating refresh token", "error", err)
+		respondStorageError(c, err, "Failed to refresh session")
+		return
+	}
+
+	// Retire the presented token first; losing this race means another
+	// request already rotated it, which is treated as reuse
+	err = h.Store.RotateRefreshToken(stored.TokenHash, hashToken(newToken))
+	if errors.Is(err, errStorageConflict) {
+		requestLogger(c).Error("concurrent reuse of refresh token, revoking session family", "user_id", stored.UserID, "family_id", stored.FamilyID)
+		h.revokeRefreshFamily(stored.UserID, stored.FamilyID)
+		respondError(c, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error rotating refresh token", "error", err)
+		respondStorageError(c, err, "Failed to refresh session")
+		return
+	}
+
+	if err := h.putRefreshToken(user, newToken, stored.FamilyID); err != nil {
+		requestLogger(c).Error("error storing rotated refresh token", "error", err)
+		respondStorageError(c, err, "Failed to refresh session")
+		return
+	}
+
+	h.touchLoginSession(stored.UserID, stored.FamilyID)
+
+	accessToken, err := h.generateJWT(user, stored.FamilyID)
+	if err != nil {
+		requestLogger(c).Error("failed to generate JWT", "error", err)
+		respondStorageError(c, err, "Failed to refresh session")
+		return
+	}
+
+	c.JSON(http.StatusOK, LoginResponse{
+		Success:      true,
+		User:         user,
+		Token:        accessToken,
+		RefreshToken: newToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	})
+}
+
+// logout revokes the session's refresh token family and denylists the
+// presented access token until it would have expired anyway
+func (h *PuzzleHub) logout(c *gin.Context) {
+	var request LogoutRequest
+	// The body is optional; a bare POST only revokes the access token
+	_ = c.ShouldBindJSON(&request)
+
+	if request.RefreshToken != "" {
+		stored, err := h.Store.GetRefreshToken(hashToken(request.RefreshToken))
+		if err != nil {
+			requestLogger(c).Error("error fetching refresh token on logout", "error", err)
+			respondStorageError(c, err, "Failed to log out")
+			return
+		}
+		if stored != nil {
+			h.revokeRefreshFamily(stored.UserID, stored.FamilyID)
+		}
+	}
+
+	if tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		token, err := jwt.Parse(tokenString, h.jwtKeyFunc)
+		if err == nil && token.Valid {
+			claims, _ := token.Claims.(jwt.MapClaims)
+			jti, _ := claims["jti"].(string)
+			exp, _ := claims["exp"].(float64)
+			if jti != "" {
+				if err := h.Store.RevokeAccessToken(jti, int64(exp)); err != nil {
+					requestLogger(c).Error("error denylisting access token", "error", err)
+					respondStorageError(c, err, "Failed to log out")
+					return
+				}
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func (h *PuzzleHub) putRefreshToken(user *User, token, familyID string) error {
+	now := time.Now()
+	err := h.Store.PutRefreshToken(&RefreshToken{
+		TokenHash: hashToken(token),
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		Email:     user.Email,
+		Name:      user.Name,
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTokenTTL).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store refresh token: %v", err)
+	}
+	return nil
+}
+
+// revokeRefreshFamily revokes every refresh token issued for one login and
+// ends the matching login session
+func (h *PuzzleHub) revokeRefreshFamily(userID, familyID string) {
+	h.endLoginSession(userID, familyID)
+
+	if err := h.Store.RevokeRefreshFamily(familyID); err != nil {
+		log.Printf("Error revoking refresh token family %s: %v", familyID, err)
+	}
+}
+
+// isAccessTokenRevoked checks the denylist for the token itself and for the
+// login session it belongs to (stored as "sid:<session id>")
+func (h *PuzzleHub) isAccessTokenRevoked(jti, sessionID string) (bool, error) {
+	jtis := []string{}
+	if jti != "" {
+		jtis = append(jtis, jti)
+	}
+	if sessionID != "" {
+		jtis = append(jtis, "sid:"+sessionID)
+	}
+	if len(jtis) == 0 {
+		return false, nil
+	}
+	return h.Store.IsAccessTokenRevoked(jtis)
+}
+
+// randomToken returns n random bytes, hex encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Server-side cache
+//
+// Hot reads (user roles and preferences, log type schemas, AI responses)
+// go through a Cache before hitting storage. CACHE_BACKEND picks it:
+//
+//	memory  in-process LRU of CACHE_MAX_ENTRIES items (default 10000), the default
+//	redis   REDIS_URL, e.g. redis://localhost:6379/0, shared by every instance
+//	none    no caching
+//
+// The memory cache is per instance, so a write on one instance is only seen
+// by the others once their copy expires; cachedValueTTL keeps that short.
+// Use redis when running more than one instance.
+
+const defaultCacheEntries = 10000
+
+// Cache stores byte values by key with a per-entry TTL
+type Cache interface {
+	// Get returns the value and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Purge deletes every key starting with prefix ("" for all) and
+	// returns how many were deleted
+	Purge(ctx context.Context, prefix string) (int, error)
+	Ping(ctx context.Context) error
+}
+
+// newCache builds the cache selected by CACHE_BACKEND
+func newCache() (Cache, error) {
+	switch backend := strings.ToLower(os.Getenv("CACHE_BACKEND")); backend {
+	case "", "memory":
+		size := defaultCacheEntries
+		if value, err := strconv.Atoi(os.Getenv("CACHE_MAX_ENTRIES")); err == nil && value > 0 {
+			size = value
+		}
+		log.Printf("🗃️  Using in-memory cache (%d entries)", size)
+		return newMemoryCache(size), nil
+	case "redis":
+		url := os.Getenv("REDIS_URL")
+		if url == "" {
+			return nil, fmt.Errorf("REDIS_URL is required with CACHE_BACKEND=redis")
+		}
+		options, err := redis.ParseURL(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+		}
+		log.Printf("🗃️  Using Redis cache at %s", options.Addr)
+		return &redisCache{client: redis.NewClient(options)}, nil
+	case "none":
+		return noCache{}, nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q (use memory, redis or none)", backend)
+	}
+}
+
+// getCachedJSON decodes a cached value into out, counting the hit or miss
+// under name. Cache errors count as misses.
+func getCachedJSON(ctx context.Context, cache Cache, name, key string, out interface{}) bool {
+	hit := false
+	defer func() { observeCacheLookup(name, hit) }()
+
+	data, found, err := cache.Get(ctx, key)
+	if err != nil {
+		log.Printf("Error reading %s from cache: %v", name, err)
+		return false
+	}
+	if !found {
+		return false
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		log.Printf("Error decoding cached %s: %v", name, err)
+		return false
+	}
+	hit = true
+	return true
+}
+
+// setCachedJSON caches value for ttl, logging rather than failing on errors
+func setCachedJSON(ctx context.Context, cache Cache, key string, value interface{}, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Error encoding cache value for %s: %v", key, err)
+		return
+	}
+	if err := cache.Set(ctx, key, data, ttl); err != nil {
+		log.Printf("Error writing cache key %s: %v", key, err)
+	}
+}
+
+// invalidateCache deletes keys, logging rather than failing on errors
+func invalidateCache(ctx context.Context, cache Cache, keys ...string) {
+	if err := cache.Delete(ctx, keys...); err != nil {
+		log.Printf("Error invalidating cache keys %v: %v", keys, err)
+	}
+}
+
+// memoryCache is a size-bounded LRU
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Front is most recently used
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func newMemoryCache(size int) *memoryCache {
+	return &memoryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		m.order.Remove(element)
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (m *memoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := &memoryCacheEntry{key: key, value: value, expiresAt: time.Now().Add(ttl)}
+	if element, ok := m.entries[key]; ok {
+		element.Value = entry
+		m.order.MoveToFront(element)
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(entry)
+	for m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+func (m *memoryCache) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if element, ok := m.entries[key]; ok {
+			m.order.Remove(element)
+			delete(m.entries, key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryCache) Purge(_ context.Context, prefix string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	purged := 0
+	for key, element := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			m.order.Remove(element)
+			delete(m.entries, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *memoryCache) Ping(context.Context) error { return nil }
+
+type redisCache struct {
+	client *redis.Client
+}
+
+func (r *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, tableName("puzzle-hub:")+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (r *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, tableName("puzzle-hub:")+key, value, ttl).Err()
+}
+
+func (r *redisCache) Delete(ctx context.Context, keys ...string) error {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = tableName("puzzle-hub:") + key
+	}
+	return r.client.Del(ctx, prefixed...).Err()
+}
+
+// Purge walks the keys with SCAN rather than KEYS so Redis isn't blocked
+func (r *redisCache) Purge(ctx context.Context, prefix string) (int, error) {
+	purged := 0
+	iter := r.client.Scan(ctx, 0, tableName("puzzle-hub:")+prefix+"*", 500).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 500 {
+			if err := r.client.Del(ctx, batch...).Err(); err != nil {
+				return purged, err
+			}
+			purged += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return purged, err
+	}
+	if len(batch) > 0 {
+		if err := r.client.Del(ctx, batch...).Err(); err != nil {
+			return purged, err
+		}
+		purged += len(batch)
+	}
+	return purged, nil
+}
+
+func (r *redisCache) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// noCache never holds anything
+type noCache struct{}
+
+func (noCache) Get(context.Context, string) ([]byte, bool, error)        { return nil, false, nil }
+func (noCache) Set(context.Context, string, []byte, time.Duration) error { return nil }
+func (noCache) Delete(context.Context, ...string) error                  { return nil }
+func (noCache) Purge(context.Context, string) (int, error)               { return 0, nil }
+func (noCache) Ping(context.Context) error                               { return nil }
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Challenge links
+//
+// After a game a player can challenge a sibling or friend to beat their
+// score. POST /challenges stores what they played and their result under a
+// short code, and the link opens the same content for whoever follows it:
+// the same spelling words, or the same Yohaku or Sudoku game, rebuilt from
+// the seed every game session now carries (the generators only draw from
+// their own rand, so a seed and settings give identical puzzles). The
+// creator's score stays hidden until the opponent has played. When an
+// opponent posts their result it is recorded as progress, compared (higher
+// percentage wins, then the faster time) and both players are notified.
+// Links can be played by any number of opponents until they expire.
+
+const (
+	challengeTTL      = 14 * 24 * time.Hour
+	maxChallengeWords = 50
+
+	challengeWin  = "win"
+	challengeLose = "lose"
+	challengeDraw = "draw"
+)
+
+// challengeActivities are the activities whose content can be replayed
+var challengeActivities = map[string]bool{"spelling": true, "yohaku": true, "sudoku": true}
+
+type Challenge struct {
+	Code            string          `json:"code" dynamodbav:"code"`
+	Activity        string          `json:"activity" dynamodbav:"activity"`
+	CreatorID       string          `json:"creator_id" dynamodbav:"creator_id"`
+	CreatorName     string          `json:"creator_name" dynamodbav:"creator_name"`
+	CreatorEmail    string          `json:"-" dynamodbav:"creator_email,omitempty"`
+	Words           []string        `json:"words,omitempty" dynamodbav:"words,omitempty"`
+	Seed            int64           `json:"seed,omitempty" dynamodbav:"seed,omitempty"`
+	YohakuSettings  *GameSettings   `json:"yohaku_settings,omitempty" dynamodbav:"yohaku_settings,omitempty"`
+	SudokuSettings  *SudokuSettings `json:"sudoku_settings,omitempty" dynamodbav:"sudoku_settings,omitempty"`
+	Score           float64         `json:"score" dynamodbav:"score"`
+	MaxScore        float64         `json:"max_score" dynamodbav:"max_score"`
+	DurationSeconds int             `json:"duration_seconds,omitempty" dynamodbav:"duration_seconds,omitempty"`
+	CreatedAt       time.Time       `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt       int64           `json:"expires_at" dynamodbav:"expires_at"` // Unix seconds, also the table TTL
+}
+
+// ChallengeResult is one opponent's go at a challenge
+type ChallengeResult struct {
+	Code            string    `json:"-" dynamodbav:"code"`
+	UserID          string    `json:"user_id" dynamodbav:"user_id"`
+	Name            string    `json:"name" dynamodbav:"name"`
+	Score           float64   `json:"score" dynamodbav:"score"`
+	MaxScore        float64   `json:"max_score" dynamodbav:"max_score"`
+	DurationSeconds int       `json:"duration_seconds,omitempty" dynamodbav:"duration_seconds,omitempty"`
+	Outcome         string    `json:"outcome" dynamodbav:"outcome"` // For the opponent: win, lose or draw
+	CreatedAt       time.Time `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt       int64     `json:"-" dynamodbav:"expires_at"`
+}
+
+type CreateChallengeRequest struct {
+	Activity        string          `json:"activity" binding:"required"`
+	Words           []string        `json:"words"`           // Spelling
+	Seed            int64           `json:"seed"`            // Yohaku and Sudoku, from the game session
+	YohakuSettings  *GameSettings   `json:"yohaku_settings"` // The settings the session was started with
+	SudokuSettings  *SudokuSettings `json:"sudoku_settings"`
+	Score           float64         `json:"score"`
+	MaxScore        float64         `json:"max_score" binding:"required"`
+	DurationSeconds int             `json:"duration_seconds"`
+}
+
+type ChallengeResultRequest struct {
+	Score           float64 `json:"score"`
+	MaxScore        float64 `json:"max_score" binding:"required"`
+	DurationSeconds int     `json:"duration_seconds"`
+}
+
+// seededGenerator returns a generator whose puzzles depend only on seed
+func seededGenerator(seed int64) *YohakuGenerator {
+	return &YohakuGenerator{rand: rand.New(rand.NewSource(seed))}
+}
+
+// newGameSeed picks the seed for a new game session
+func newGameSeed() int64 {
+	return rand.Int63()
+}
+
+// validateChallenge checks a request and returns the problem, or ""
+func validateChallenge(request *CreateChallengeRequest) string {
+	if !challengeActivities[request.Activity] {
+		return "Activity must be spelling, yohaku or sudoku"
+	}
+	if problem := validateChallengeScore(request.Score, request.MaxScore, request.DurationSeconds); problem != "" {
+		return problem
+	}
+
+	switch request.Activity {
+	case "spelling":
+		if len(request.Words) == 0 || len(request.Words) > maxChallengeWords {
+			return fmt.Sprintf("A spelling challenge needs between 1 and %d words", maxChallengeWords)
+		}
+		for i, word := range request.Words {
+			request.Words[i] = strings.TrimSpace(word)
+			if request.Words[i] == "" || len(request.Words[i]) > 50 {
+				return "Spelling words must be between 1 and 50 characters"
+			}
+		}
+	case "yohaku":
+		if request.Seed == 0 || request.YohakuSettings == nil {
+			return "A yohaku challenge needs the game's seed and yohaku_settings"
+		}
+		// Sessions pick their own sizes per level, so only the range matters
+		settings := request.YohakuSettings
+		if settings.Range.Min < -1000 || settings.Range.Max > 1000 || settings.Range.Min > settings.Range.Max {
+			return "Yohaku range must be within -1000 to 1000"
+		}
+		if settings.Operation == "" {
+			settings.Operation = "addition"
+		}
+	case "sudoku":
+		if request.Seed == 0 || request.SudokuSettings == nil {
+			return "A sudoku challenge needs the game's seed and sudoku_settings"
+		}
+		if problem := normalizeSudokuSettings(request.SudokuSettings); problem != "" {
+			return problem
+		}
+	}
+	return ""
+}
+
+func validateChallengeScore(score, maxScore float64, durationSeconds int) string {
+	if maxScore <= 0 || score < 0 || score > maxScore {
+		return "Score must be between 0 and max_score"
+	}
+	if durationSeconds < 0 || durationSeconds > 24*60*60 {
+		return "Duration must be between 0 and 86400 seconds"
+	}
+	return ""
+}
+
+// createChallenge saves the creator's game and result and returns the link
+func (h *PuzzleHub) createChallenge(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var request CreateChallengeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if problem := validateChallenge(&request); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+
+	now := time.Now()
+	challenge := Challenge{
+		Activity:        request.Activity,
+		CreatorID:       userObj.ID,
+		CreatorName:     userObj.Name,
+		CreatorEmail:    userObj.Email,
+		Words:           request.Words,
+		Score:           request.Score,
+		MaxScore:        request.MaxScore,
+		DurationSeconds: request.DurationSeconds,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(challengeTTL).Unix(),
+	}
+	if request.Activity != "spelling" {
+		challenge.Seed = request.Seed
+		challenge.YohakuSettings = request.YohakuSettings
+		challenge.SudokuSettings = request.SudokuSettings
+	}
+
+	// Codes are short enough to read out, so retry the rare collision
+	for attempt := 0; ; attempt++ {
+		code, err := randomJoinCode()
+		if err == nil {
+			challenge.Code = code
+			err = h.putChallenge(c.Request.Context(), &challenge)
+		}
+		if err == nil {
+			break
+		}
+		if !isConditionalCheckFailed(err) || attempt == 4 {
+			requestLogger(c).Error("error saving challenge", "error", err)
+			respondStorageError(c, err, "Failed to create challenge")
+			return
+		}
+	}
+
+	log.Printf("🤺 %s created %s challenge %s", userObj.ID, challenge.Activity, challenge.Code)
+	c.JSON(http.StatusCreated, gin.H{
+		"challenge": challenge,
+		"link":      strings.TrimSuffix(os.Getenv("BASE_URL"), "/") + "/challenge/" + challenge.Code,
+	})
+}
+
+// getChallenge returns the challenge content to play. The creator's score
+// is only shown to the creator and to opponents who have played.
+func (h *PuzzleHub) getChallenge(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	challenge, ok := h.requireChallenge(c)
+	if !ok {
+		return
+	}
+	results, err := h.getChallengeResults(ctx, challenge.Code)
+	if err != nil {
+		requestLogger(c).Error("error fetching results for challenge", "error", err, "code", challenge.Code)
+		respondStorageError(c, err, "Failed to fetch challenge")
+		return
+	}
+
+	var mine *ChallengeResult
+	for i := range results {
+		if results[i].UserID == userObj.ID {
+			mine = &results[i]
+		}
+	}
+	isCreator := challenge.CreatorID == userObj.ID
+
+	response := gin.H{"challenge": challenge}
+	switch challenge.Activity {
+	case "yohaku":
+		session := seededGenerator(challenge.Seed).GenerateGameSession(*challenge.YohakuSettings)
+		session.Seed = challenge.Seed
+		response["session"] = session
+	case "sudoku":
+		session := seededGenerator(challenge.Seed).GenerateSudokuGameSession(*challenge.SudokuSettings)
+		session.Seed = challenge.Seed
+		response["session"] = session
+	}
+	if isCreator {
+		response["results"] = results
+	} else {
+		if mine != nil {
+			response["result"] = mine
+		} else {
+			hidden := *challenge
+			hidden.Score, hidden.DurationSeconds = 0, 0
+			response["challenge"] = hidden
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// submitChallengeResult records an opponent's result, compares it with the
+// creator's and notifies them both. Each opponent plays once.
+func (h *PuzzleHub) submitChallengeResult(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	var request ChallengeResultRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if problem := validateChallengeScore(request.Score, request.MaxScore, request.DurationSeconds); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+
+	challenge, ok := h.requireChallenge(c)
+	if !ok {
+		return
+	}
+	if challenge.CreatorID == userObj.ID {
+		respondError(c, http.StatusBadRequest, "You can't take your own challenge")
+		return
+	}
+
+	now := time.Now()
+	result := ChallengeResult{
+		Code:            challenge.Code,
+		UserID:          userObj.ID,
+		Name:            userObj.Name,
+		Score:           request.Score,
+		MaxScore:        request.MaxScore,
+		DurationSeconds: request.DurationSeconds,
+		Outcome:         challengeOutcome(challenge, request.Score, request.MaxScore, request.DurationSeconds),
+		CreatedAt:       now,
+		ExpiresAt:       challenge.ExpiresAt,
+	}
+	item, err := dynamodbattribute.MarshalMap(result)
+	if err != nil {
+		requestLogger(c).Error("error marshaling challenge result", "error", err)
+		respondStorageError(c, err, "Failed to save result")
+		return
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-challenge-results")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(user_id)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusConflict, "You have already played this challenge")
+			return
+		}
+		requestLogger(c).Error("error saving challenge result", "error", err)
+		respondStorageError(c, err, "Failed to save result")
+		return
+	}
+
+	activityResult, err := h.saveActivityResult(userObj, challenge.Activity, request.Score, request.MaxScore, request.DurationSeconds)
+	if err != nil {
+		// The challenge result stands; only the progress entry is missing
+		requestLogger(c).Error("error saving activity result for challenge", "error", err, "code", challenge.Code)
+	}
+
+	h.notifyChallengeOutcome(ctx, challenge, &result, userObj.Email)
+
+	response := gin.H{
+		"outcome":   result.Outcome,
+		"result":    result,
+		"challenge": challenge,
+	}
+	if activityResult != nil {
+		response["xp_awarded"] = activityResult.XPAwarded
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// challengeOutcome is the result for the opponent: the higher percentage
+// wins, and a tie goes to the faster time when both were timed
+func challengeOutcome(challenge *Challenge, score, maxScore float64, durationSeconds int) string {
+	mine := score / maxScore
+	theirs := challenge.Score / challenge.MaxScore
+	switch {
+	case mine > theirs:
+		return challengeWin
+	case mine < theirs:
+		return challengeLose
+	case durationSeconds > 0 && challenge.DurationSeconds > 0 && durationSeconds < challenge.DurationSeconds:
+		return challengeWin
+	case durationSeconds > 0 && challenge.DurationSeconds > 0 && durationSeconds > challenge.DurationSeconds:
+		return challengeLose
+	default:
+		return challengeDraw
+	}
+}
+
+func (h *PuzzleHub) notifyChallengeOutcome(ctx context.Context, challenge *Challenge, result *ChallengeResult, opponentEmail string) {
+	score := func(score, maxScore float64) string {
+		return fmt.Sprintf("%s/%s", strconv.FormatFloat(score, 'f', -1, 64), strconv.FormatFloat(maxScore, 'f', -1, 64))
+	}
+	mine, theirs := score(result.Score, result.MaxScore), score(challenge.Score, challenge.MaxScore)
+	data := map[string]string{"code": challenge.Code, "activity": challenge.Activity}
+
+	// Each player is written to in their own language
+	language := h.recipientLanguage(result.UserID)
+	opponentTitle := map[string]string{
+		challengeWin:  "You beat %s's challenge!",
+		challengeLose: "%s won this challenge",
+		challengeDraw: "It's a draw with %s!",
+	}[result.Outcome]
+	h.notify(ctx, Notification{
+		UserID: result.UserID,
+		Kind:   notificationChallenge,
+		Title:  localize(language, opponentTitle, challenge.CreatorName),
+		Body:   localize(language, "You scored %s in %s; %s scored %s.", mine, translate(language, activityLabels[challenge.Activity]), challenge.CreatorName, theirs),
+		Link:   "/challenge/" + challenge.Code,
+		Data:   data,
+	}, opponentEmail)
+
+	language = h.recipientLanguage(challenge.CreatorID)
+	creatorTitle := map[string]string{
+		challengeWin:  "%s beat your challenge",
+		challengeLose: "You won your challenge against %s!",
+		challengeDraw: "Your challenge with %s is a draw!",
+	}[result.Outcome]
+	h.notify(ctx, Notification{
+		UserID: challenge.CreatorID,
+		Kind:   notificationChallenge,
+		Title:  localize(language, creatorTitle, result.Name),
+		Body:   localize(language, "%s scored %s in %s; you scored %s.", result.Name, mine, translate(language, activityLabels[challenge.Activity]), theirs),
+		Link:   "/challenge/" + challenge.Code,
+		Data:   data,
+	}, challenge.CreatorEmail)
+}
+
+// requireChallenge loads :code, writing the error response when it is
+// missing or expired
+func (h *PuzzleHub) requireChallenge(c *gin.Context) (*Challenge, bool) {
+	code := strings.ToUpper(strings.TrimSpace(c.Param("code")))
+	result, err := h.DynamoDB.GetItemWithContext(c.Request.Context(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-challenges")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"code": {S: aws.String(code)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching challenge", "error", err, "code", code)
+		respondStorageError(c, err, "Failed to fetch challenge")
+		return nil, false
+	}
+
+	var challenge Challenge
+	if result.Item != nil {
+		if err := dynamodbattribute.UnmarshalMap(result.Item, &challenge); err != nil {
+			requestLogger(c).Error("error unmarshaling challenge", "error", err, "code", code)
+			respondStorageError(c, err, "Failed to fetch challenge")
+			return nil, false
+		}
+	}
+	// The TTL sweep can lag, so check the expiry too
+	if result.Item == nil || challenge.ExpiresAt < time.Now().Unix() {
+		respondError(c, http.StatusNotFound, "That challenge doesn't exist or has expired")
+		return nil, false
+	}
+	return &challenge, true
+}
+
+func (h *PuzzleHub) putChallenge(ctx context.Context, challenge *Challenge) error {
+	item, err := dynamodbattribute.MarshalMap(challenge)
+	if err != nil {
+		return fmt.Errorf("failed to marshal challenge: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-challenges")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(code)"),
+	})
+	return err
+}
+
+func (h *PuzzleHub) getChallengeResults(ctx context.Context, code string) ([]ChallengeResult, error) {
+	return queryLimit[ChallengeResult](ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-challenge-results")),
+		KeyConditionExpression: aws.String("code = :code"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":code": {S: aws.String(code)},
+		},
+	}, 0)
+}
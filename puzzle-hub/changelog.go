@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// In-app changelog built from completed feedback. All entries share one
+// partition ("public") sorted by publish time so the feed is a single query.
+
+const changelogFeed = "public"
+
+type ChangelogEntry struct {
+	Feed        string       `json:"-" dynamodbav:"feed"`
+	PublishedAt time.Time    `json:"published_at" dynamodbav:"published_at"`
+	FeedbackID  string       `json:"feedback_id" dynamodbav:"feedback_id"`
+	Title       string       `json:"title" dynamodbav:"title"`
+	Summary     string       `json:"summary,omitempty" dynamodbav:"summary,omitempty"`
+	Type        FeedbackType `json:"type" dynamodbav:"type"`
+	AppName     string       `json:"app_name,omitempty" dynamodbav:"app_name,omitempty"`
+	RequestedBy string       `json:"-" dynamodbav:"requested_by"` // Reporter's user ID
+
+	// Per-user view, filled in on read
+	YourRequest bool `json:"your_request" dynamodbav:"-"`
+	Unseen      bool `json:"unseen" dynamodbav:"-"`
+}
+
+// publishChangelogEntry adds a completed feedback item to the changelog
+func (h *PuzzleHub) publishChangelogEntry(feedback *Feedback, summary string) (*ChangelogEntry, error) {
+	entry := ChangelogEntry{
+		Feed:        changelogFeed,
+		PublishedAt: time.Now().UTC(),
+		FeedbackID:  feedback.ID,
+		Title:       feedback.Title,
+		Summary:     strings.TrimSpace(summary),
+		Type:        feedback.Type,
+		AppName:     feedback.AppName,
+		RequestedBy: feedback.UserID,
+	}
+
+	item, err := dynamodbattribute.MarshalMap(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal changelog entry: %v", err)
+	}
+
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-changelog")),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save changelog entry: %v", err)
+	}
+
+	log.Printf("🚀 Published feedback %s to changelog", feedback.ID)
+	return &entry, nil
+}
+
+// getChangelog lists changelog entries newest first, marking the ones the
+// user hasn't seen yet and the ones that came from their own requests.
+func (h *PuzzleHub) getChangelog(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > 200 {
+			respondError(c, http.StatusBadRequest, "limit must be between 1 and 200")
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := queryLimit[ChangelogEntry](c.Request.Context(), h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-changelog")),
+		KeyConditionExpression: aws.String("feed = :feed"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":feed": {S: aws.String(changelogFeed)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, limit)
+	if err != nil {
+		requestLogger(c).Error("error querying changelog", "error", err)
+		respondStorageError(c, err, "Failed to fetch changelog")
+		return
+	}
+
+	seenAt, err := h.getChangelogSeenAt(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching changelog marker", "error", err)
+	}
+
+	unseenCount := 0
+	yourShipped := 0
+	for i := range entries {
+		entries[i].YourRequest = entries[i].RequestedBy == userObj.ID
+		entries[i].Unseen = entries[i].PublishedAt.After(seenAt)
+		if entries[i].Unseen {
+			unseenCount++
+			if entries[i].YourRequest {
+				yourShipped++
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":               entries,
+		"count":                 len(entries),
+		"unseen_count":          unseenCount,
+		"your_requests_shipped": yourShipped,
+		"last_seen_at":          seenAt,
+	})
+}
+
+// markChangelogSeen moves the user's "seen" marker to now
+func (h *PuzzleHub) markChangelogSeen(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	seenAt := time.Now().UTC()
+	_, err := h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-changelog-seen")),
+		Item: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userObj.ID)},
+			"seen_at": {S: aws.String(seenAt.Format(time.RFC3339Nano))},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error saving changelog marker", "error", err)
+		respondStorageError(c, err, "Failed to update changelog marker")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"last_seen_at": seenAt})
+}
+
+// getChangelogSeenAt returns the zero time when the user has never opened the changelog
+func (h *PuzzleHub) getChangelogSeenAt(userID string) (time.Time, error) {
+	result, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-changelog-seen")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if result.Item == nil || result.Item["seen_at"] == nil {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, aws.StringValue(result.Item["seen_at"].S))
+}
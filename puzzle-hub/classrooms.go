@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Classrooms: teachers create classes and share a join code; students join
+// with the code and teachers see aggregate progress for their roster.
+
+// Join codes skip look-alike characters (0/O, 1/I/L) so they can be read aloud
+const joinCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+const joinCodeLength = 6
+
+type Classroom struct {
+	ID          string    `json:"id" dynamodbav:"id"`
+	TeacherID   string    `json:"teacher_id" dynamodbav:"teacher_id"`
+	TeacherName string    `json:"teacher_name" dynamodbav:"teacher_name"`
+	Name        string    `json:"name" dynamodbav:"name"`
+	GradeLevel  int       `json:"grade_level,omitempty" dynamodbav:"grade_level,omitempty"`
+	JoinCode    string    `json:"join_code,omitempty" dynamodbav:"join_code"`
+	CreatedAt   time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+type ClassroomMember struct {
+	ClassroomID string    `json:"classroom_id" dynamodbav:"classroom_id"`
+	UserID      string    `json:"user_id" dynamodbav:"user_id"`
+	Name        string    `json:"name" dynamodbav:"name"`
+	Email       string    `json:"email" dynamodbav:"email"`
+	JoinedAt    time.Time `json:"joined_at" dynamodbav:"joined_at"`
+}
+
+type CreateClassroomRequest struct {
+	Name       string `json:"name" binding:"required"`
+	GradeLevel int    `json:"grade_level"`
+}
+
+type JoinClassroomRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type StudentProgress struct {
+	UserID     string            `json:"user_id"`
+	Name       string            `json:"name"`
+	Activities []ActivitySummary `json:"activities"`
+}
+
+func (h *PuzzleHub) createClassroom(c *gin.Context) {
+	teacher := c.MustGet("user").(*User)
+
+	var request CreateClassroomRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > 100 {
+		respondError(c, http.StatusBadRequest, "Class name must be between 1 and 100 characters")
+		return
+	}
+	if request.GradeLevel < 0 || request.GradeLevel > 12 {
+		respondError(c, http.StatusBadRequest, "Grade level must be between 1 and 12")
+		return
+	}
+
+	joinCode, err := h.newUniqueJoinCode()
+	if err != nil {
+		requestLogger(c).Error("error generating join code", "error", err)
+		respondStorageError(c, err, "Failed to create class")
+		return
+	}
+
+	classroom := Classroom{
+		ID:          fmt.Sprintf("cls_%d", time.Now().UnixNano()),
+		TeacherID:   teacher.ID,
+		TeacherName: teacher.Name,
+		Name:        name,
+		GradeLevel:  request.GradeLevel,
+		JoinCode:    joinCode,
+		CreatedAt:   time.Now(),
+	}
+	if err := h.putClassroom(&classroom); err != nil {
+		requestLogger(c).Error("error saving classroom", "error", err)
+		respondStorageError(c, err, "Failed to create class")
+		return
+	}
+
+	log.Printf("🏫 %s created class %s (%s)", teacher.ID, classroom.ID, classroom.Name)
+	c.JSON(http.StatusCreated, classroom)
+}
+
+// getClassrooms lists classes the user teaches and classes they've joined
+func (h *PuzzleHub) getClassrooms(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	teaching := []Classroom{}
+	if userObj.HasRole(RoleTeacher) {
+		var err error
+		teaching, err = queryAll[Classroom](h.DynamoDB, &dynamodb.QueryInput{
+			TableName:              aws.String(tableName("puzzle-hub-classrooms")),
+			IndexName:              aws.String("teacher_id-index"),
+			KeyConditionExpression: aws.String("teacher_id = :teacher_id"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":teacher_id": {S: aws.String(userObj.ID)},
+			},
+		})
+		if err != nil {
+			requestLogger(c).Error("error fetching taught classes", "error", err)
+			respondStorageError(c, err, "Failed to fetch classes")
+			return
+		}
+	}
+
+	memberships, err := h.getMemberships(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching memberships", "error", err)
+		respondStorageError(c, err, "Failed to fetch classes")
+		return
+	}
+
+	enrolled := []Classroom{}
+	for _, membership := range memberships {
+		classroom, err := h.getClassroom(membership.ClassroomID)
+		if err != nil || classroom == nil {
+			continue
+		}
+		classroom.JoinCode = "" // Only the teacher hands out the code
+		enrolled = append(enrolled, *classroom)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"teaching": teaching,
+		"enrolled": enrolled,
+	})
+}
+
+// getClassroomDetail returns a class with its roster (teacher only)
+func (h *PuzzleHub) getClassroomDetail(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+
+	members, err := h.getClassroomMembers(classroom.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching roster", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to fetch roster")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"classroom": classroom,
+		"members":   members,
+		"count":     len(members),
+	})
+}
+
+// regenerateJoinCode invalidates the old code, e.g. after it leaked
+func (h *PuzzleHub) regenerateJoinCode(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+
+	joinCode, err := h.newUniqueJoinCode()
+	if err != nil {
+		requestLogger(c).Error("error generating join code", "error", err)
+		respondStorageError(c, err, "Failed to generate join code")
+		return
+	}
+
+	classroom.JoinCode = joinCode
+	if err := h.putClassroom(classroom); err != nil {
+		requestLogger(c).Error("error saving classroom", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to generate join code")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"join_code": joinCode})
+}
+
+func (h *PuzzleHub) joinClassroom(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	var request JoinClassroomRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	classroom, err := h.getClassroomByJoinCode(strings.ToUpper(strings.TrimSpace(request.Code)))
+	if err != nil {
+		requestLogger(c).Error("error looking up join code", "error", err)
+		respondStorageError(c, err, "Failed to join class")
+		return
+	}
+	if classroom == nil {
+		respondError(c, http.StatusNotFound, "No class found for that code")
+		return
+	}
+	if classroom.TeacherID == userObj.ID {
+		respondError(c, http.StatusBadRequest, "You teach this class")
+		return
+	}
+
+	item, err := dynamodbattribute.MarshalMap(ClassroomMember{
+		ClassroomID: classroom.ID,
+		UserID:      userObj.ID,
+		Name:        userObj.Name,
+		Email:       userObj.Email,
+		JoinedAt:    time.Now(),
+	})
+	if err != nil {
+		requestLogger(c).Error("error marshaling membership", "error", err)
+		respondStorageError(c, err, "Failed to join class")
+		return
+	}
+
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-classroom-members")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(user_id)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusConflict, "You are already in this class")
+			return
+		}
+		requestLogger(c).Error("error saving membership", "error", err)
+		respondStorageError(c, err, "Failed to join class")
+		return
+	}
+
+	classroom.JoinCode = ""
+	log.Printf("🎒 %s joined class %s", userObj.ID, classroom.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Joined " + classroom.Name,
+		"classroom": classroom,
+	})
+}
+
+// removeClassroomMember lets the teacher remove a student, or a student leave
+func (h *PuzzleHub) removeClassroomMember(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+	memberID := c.Param("userId")
+
+	classroom, err := h.getClassroom(c.Param("id"))
+	if err != nil {
+		requestLogger(c).Error("error fetching classroom", "error", err)
+		respondStorageError(c, err, "Failed to update roster")
+		return
+	}
+	isTeacher := classroom != nil && (classroom.TeacherID == userObj.ID || h.isAdmin(userObj))
+	if classroom == nil || (!isTeacher && memberID != userObj.ID) {
+		respondError(c, http.StatusNotFound, "Class not found")
+		return
+	}
+
+	_, err = h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-classroom-members")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"classroom_id": {S: aws.String(classroom.ID)},
+			"user_id":      {S: aws.String(memberID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(user_id)"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusNotFound, "Student is not in this class")
+			return
+		}
+		requestLogger(c).Error("error removing member", "error", err)
+		respondStorageError(c, err, "Failed to update roster")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Removed from class"})
+}
+
+// getClassroomProgress aggregates spelling, writing and yohaku results for
+// the whole roster, optionally limited to the last ?days=N days
+func (h *PuzzleHub) getClassroomProgress(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+
+	var since time.Time
+	if days := c.Query("days"); days != "" {
+		var n int
+		if _, err := fmt.Sscanf(days, "%d", &n); err != nil || n < 1 || n > 365 {
+			respondError(c, http.StatusBadRequest, "days must be between 1 and 365")
+			return
+		}
+		since = time.Now().AddDate(0, 0, -n)
+	}
+
+	members, err := h.getClassroomMembers(classroom.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching roster", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to fetch progress")
+		return
+	}
+
+	var allResults []ActivityResult
+	students := make([]StudentProgress, 0, len(members))
+	activeStudents := 0
+	for _, member := range members {
+		results, err := h.getActivityResults(member.UserID, since)
+		if err != nil {
+			requestLogger(c).Error("error fetching progress", "error", err, "member_id", member.UserID)
+			respondStorageError(c, err, "Failed to fetch progress")
+			return
+		}
+		if len(results) > 0 {
+			activeStudents++
+		}
+		allResults = append(allResults, results...)
+		students = append(students, StudentProgress{
+			UserID:     member.UserID,
+			Name:       member.Name,
+			Activities: summarizeActivityResults(results),
+		})
+	}
+
+	participation := 0.0
+	if len(members) > 0 {
+		participation = math.Round(float64(activeStudents)/float64(len(members))*1000) / 10
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"classroom":             classroom,
+		"class_activities":      summarizeActivityResults(allResults),
+		"students":              students,
+		"student_count":         len(members),
+		"active_students":       activeStudents,
+		"participation_percent": participation,
+	})
+}
+
+// requireClassroomTeacher loads :id and checks the user teaches it (or is an
+// admin), writing the error response when not
+func (h *PuzzleHub) requireClassroomTeacher(c *gin.Context) (*Classroom, bool) {
+	user := c.MustGet("user").(*User)
+
+	classroom, err := h.getClassroom(c.Param("id"))
+	if err != nil {
+		requestLogger(c).Error("error fetching classroom", "error", err)
+		respondStorageError(c, err, "Failed to fetch class")
+		return nil, false
+	}
+	if classroom == nil || (classroom.TeacherID != user.ID && !h.isAdmin(user)) {
+		respondError(c, http.StatusNotFound, "Class not found")
+		return nil, false
+	}
+	return classroom, true
+}
+
+func (h *PuzzleHub) getClassroom(classroomID string) (*Classroom, error) {
+	result, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-classrooms")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(classroomID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var classroom Classroom
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &classroom); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal classroom: %v", err)
+	}
+	return &classroom, nil
+}
+
+func (h *PuzzleHub) putClassroom(classroom *Classroom) error {
+	item, err := dynamodbattribute.MarshalMap(classroom)
+	if err != nil {
+		return fmt.Errorf("failed to marshal classroom: %v", err)
+	}
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-classrooms")),
+		Item:      item,
+	})
+	return err
+}
+
+func (h *PuzzleHub) getClassroomByJoinCode(code string) (*Classroom, error) {
+	classrooms, err := queryLimit[Classroom](context.Background(), h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-classrooms")),
+		IndexName:              aws.String("join_code-index"),
+		KeyConditionExpression: aws.String("join_code = :join_code"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":join_code": {S: aws.String(code)},
+		},
+	}, 1)
+	if err != nil || len(classrooms) == 0 {
+		return nil, err
+	}
+	return &classrooms[0], nil
+}
+
+func (h *PuzzleHub) getClassroomMembers(classroomID string) ([]ClassroomMember, error) {
+	members, err := queryAll[ClassroomMember](h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-classroom-members")),
+		KeyConditionExpression: aws.String("classroom_id = :classroom_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":classroom_id": {S: aws.String(classroomID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return strings.ToLower(members[i].Name) < strings.ToLower(members[j].Name)
+	})
+	return members, nil
+}
+
+// getMemberships returns the classes a user has joined
+func (h *PuzzleHub) getMemberships(userID string) ([]ClassroomMember, error) {
+	return queryAll[ClassroomMember](h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-classroom-members")),
+		IndexName:              aws.String("user_id-index"),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	})
+}
+
+// newUniqueJoinCode draws codes until one isn't already in use
+func (h *PuzzleHub) newUniqueJoinCode() (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := randomJoinCode()
+		if err != nil {
+			return "", err
+		}
+
+		existing, err := h.getClassroomByJoinCode(code)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("could not find an unused join code")
+}
+
+// randomJoinCode returns a short code drawn from joinCodeAlphabet
+func randomJoinCode() (string, error) {
+	var b strings.Builder
+	for i := 0; i < joinCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(joinCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(joinCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Content reports
+//
+// Parents and teachers can flag any generated content (a story, a spelling
+// word, a writing analysis) as inappropriate with POST /api/report-content,
+// naming it by the generation_id it was served with. Reports land in
+// puzzle-hub-content-reports for admins to review.
+//
+// When the reported generation is still in the AI response cache (found
+// through puzzle-hub-ai-cache-generations, see storeAICache) the cached
+// entry is quarantined straight away: it is copied into the report and
+// deleted, so nobody else is served it while it waits for review. An admin
+// who dismisses the report puts it back if it hasn't expired meanwhile;
+// upholding it leaves it out of the cache for good.
+
+const (
+	maxReportReasonLength  = 1000
+	maxReportExcerptLength = 2000
+)
+
+type ContentReport struct {
+	ReportID     string `json:"report_id" dynamodbav:"report_id"`
+	GenerationID string `json:"generation_id" dynamodbav:"generation_id"`
+	Feature      string `json:"feature" dynamodbav:"feature"`
+	Provider     string `json:"provider" dynamodbav:"provider"`
+	Model        string `json:"model" dynamodbav:"model"`
+	ReporterID   string `json:"reporter_id" dynamodbav:"reporter_id"`
+	ReporterRole Role   `json:"reporter_role" dynamodbav:"reporter_role"`
+	Reason       string `json:"reason" dynamodbav:"reason"`
+	Excerpt      string `json:"excerpt,omitempty" dynamodbav:"excerpt,omitempty"` // What the reporter saw, e.g. one word of a set
+
+	// The quarantined cache entry, if the generation was cached
+	Quarantined     bool       `json:"quarantined" dynamodbav:"quarantined"`
+	CacheKey        string     `json:"cache_key,omitempty" dynamodbav:"cache_key,omitempty"`
+	CachedValue     string     `json:"cached_value,omitempty" dynamodbav:"cached_value,omitempty"`
+	CacheExpiresAt  int64      `json:"-" dynamodbav:"cache_expires_at,omitempty"`
+	CacheRestoredAt *time.Time `json:"cache_restored_at,omitempty" dynamodbav:"cache_restored_at,omitempty"`
+
+	Status     string     `json:"status" dynamodbav:"status"` // open, upheld or dismissed
+	ReviewedBy string     `json:"reviewed_by,omitempty" dynamodbav:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty" dynamodbav:"reviewed_at,omitempty"`
+	ReviewNote string     `json:"review_note,omitempty" dynamodbav:"review_note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" dynamodbav:"created_at"`
+}
+
+type ReportContentRequest struct {
+	GenerationID string `json:"generation_id" binding:"required"`
+	Reason       string `json:"reason" binding:"required"`
+	Excerpt      string `json:"excerpt"`
+}
+
+type ReviewContentReportRequest struct {
+	Status string `json:"status" binding:"required,oneof=upheld dismissed"`
+	Note   string `json:"note"`
+}
+
+// cachedGeneration points a generation at the cache entry that holds it
+type cachedGeneration struct {
+	GenerationID string `dynamodbav:"generation_id"`
+	CacheKey     string `dynamodbav:"cache_key"`
+	ExpiresAt    int64  `dynamodbav:"expires_at"`
+}
+
+// indexCachedGenerations records which cache entry each generation_id in
+// an encoded response lives in
+func (h *PuzzleHub) indexCachedGenerations(ctx context.Context, key string, encoded []byte, expiresAt int64) {
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return
+	}
+	ids := make(map[string]bool)
+	collectGenerationIDs(decoded, ids)
+
+	for id := range ids {
+		item, err := dynamodbattribute.MarshalMap(cachedGeneration{GenerationID: id, CacheKey: key, ExpiresAt: expiresAt})
+		if err != nil {
+			log.Printf("Error marshaling cached generation: %v", err)
+			return
+		}
+		if _, err := h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-ai-cache-generations")),
+			Item:      item,
+		}); err != nil {
+			log.Printf("Error indexing cached generation %s: %v", id, err)
+		}
+	}
+}
+
+// collectGenerationIDs walks decoded JSON for generation_id values; a
+// spelling pool holds the words of several generations
+func collectGenerationIDs(value interface{}, ids map[string]bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if id, ok := child.(string); ok && key == "generation_id" && id != "" {
+				ids[id] = true
+				continue
+			}
+			collectGenerationIDs(child, ids)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectGenerationIDs(child, ids)
+		}
+	}
+}
+
+// contentReportID is stable per reporter and generation, so reporting the
+// same thing twice doesn't queue it twice
+func contentReportID(reporterID, generationID string) string {
+	sum := sha256.Sum256([]byte(reporterID + "#" + generationID))
+	return hex.EncodeToString(sum[:12])
+}
+
+// reportContent flags generated content for admin review and quarantines
+// its cached copy
+func (h *PuzzleHub) reportContent(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	var request ReportContentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if request.Reason == "" || len(request.Reason) > maxReportReasonLength {
+		respondError(c, http.StatusBadRequest, "Reason must be 1 to 1000 characters")
+		return
+	}
+	if len(request.Excerpt) > maxReportExcerptLength {
+		respondError(c, http.StatusBadRequest, "Excerpt must be 2000 characters or fewer")
+		return
+	}
+
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-generations")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"generation_id": {S: aws.String(request.GenerationID)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching AI generation", "error", err)
+		respondStorageError(c, err, "Failed to report content")
+		return
+	}
+	if result.Item == nil {
+		respondError(c, http.StatusNotFound, "Generated content not found")
+		return
+	}
+	var generation AIGenerationRecord
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &generation); err != nil {
+		requestLogger(c).Error("error unmarshaling AI generation", "error", err)
+		respondStorageError(c, err, "Failed to report content")
+		return
+	}
+
+	report := ContentReport{
+		ReportID:     contentReportID(userObj.ID, generation.GenerationID),
+		GenerationID: generation.GenerationID,
+		Feature:      generation.Feature,
+		Provider:     generation.Provider,
+		Model:        generation.Model,
+		ReporterID:   userObj.ID,
+		ReporterRole: userObj.Role,
+		Reason:       request.Reason,
+		Excerpt:      request.Excerpt,
+		Status:       "open",
+		CreatedAt:    time.Now(),
+	}
+	item, err := dynamodbattribute.MarshalMap(report)
+	if err != nil {
+		requestLogger(c).Error("error marshaling content report", "error", err)
+		respondStorageError(c, err, "Failed to report content")
+		return
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-content-reports")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(report_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusConflict, "You have already reported this content")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error saving content report", "error", err)
+		respondStorageError(c, err, "Failed to report content")
+		return
+	}
+
+	// The report is queued either way; quarantine is best effort
+	quarantined, err := h.quarantineGeneration(context.WithoutCancel(ctx), report.ReportID, generation.GenerationID)
+	if err != nil {
+		requestLogger(c).Error("error quarantining generation", "error", err, "generation_id", generation.GenerationID)
+	}
+
+	log.Printf("🚩 %s reported %s generation %s (quarantined: %t)", userObj.ID, generation.Feature, generation.GenerationID, quarantined)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Thanks, an admin will review this content",
+		"report_id":   report.ReportID,
+		"quarantined": quarantined,
+	})
+}
+
+// quarantineGeneration moves the cache entry holding a generation into the
+// report, reporting whether there was one to move
+func (h *PuzzleHub) quarantineGeneration(ctx context.Context, reportID, generationID string) (bool, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-cache-generations")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"generation_id": {S: aws.String(generationID)},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return false, err
+	}
+	var pointer cachedGeneration
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &pointer); err != nil {
+		return false, err
+	}
+
+	deleted, err := h.DynamoDB.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-cache")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"cache_key": {S: aws.String(pointer.CacheKey)},
+		},
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if err != nil {
+		return false, err
+	}
+	invalidateCache(ctx, h.Cache, "ai:"+pointer.CacheKey)
+	if len(deleted.Attributes) == 0 {
+		// Already quarantined by another report, or expired
+		return false, nil
+	}
+	var entry AICacheEntry
+	if err := dynamodbattribute.UnmarshalMap(deleted.Attributes, &entry); err != nil {
+		return true, err
+	}
+
+	_, err = h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-content-reports")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"report_id": {S: aws.String(reportID)},
+		},
+		UpdateExpression: aws.String("SET quarantined = :true, cache_key = :key, cached_value = :value, cache_expires_at = :expires"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true":    {BOOL: aws.Bool(true)},
+			":key":     {S: aws.String(entry.CacheKey)},
+			":value":   {S: aws.String(entry.Value)},
+			":expires": {N: aws.String(strconv.FormatInt(entry.ExpiresAt, 10))},
+		},
+	})
+	return true, err
+}
+
+func (h *PuzzleHub) adminListContentReports(c *gin.Context) {
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-content-reports")),
+	}
+	if status := c.Query("status"); status != "" {
+		input.FilterExpression = aws.String("#status = :status")
+		input.ExpressionAttributeNames = map[string]*string{"#status": aws.String("status")}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":status": {S: aws.String(status)},
+		}
+	}
+
+	reports := []ContentReport{}
+	var unmarshalErr error
+	err := h.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var batch []ContentReport
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &batch); unmarshalErr != nil {
+			return false
+		}
+		reports = append(reports, batch...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		requestLogger(c).Error("error scanning content reports", "error", err)
+		respondStorageError(c, err, "Failed to fetch content reports")
+		return
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].CreatedAt.After(reports[j].CreatedAt)
+	})
+	respondPage(c, "reports", reports, page, nil)
+}
+
+// adminReviewContentReport closes a report; dismissing it puts a
+// quarantined cache entry back while it is still live
+func (h *PuzzleHub) adminReviewContentReport(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	var request ReviewContentReportRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	now := time.Now()
+	updated, err := h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-content-reports")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"report_id": {S: aws.String(c.Param("id"))},
+		},
+		ConditionExpression:      aws.String("attribute_exists(report_id)"),
+		UpdateExpression:         aws.String("SET #status = :status, reviewed_by = :by, reviewed_at = :at, review_note = :note"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {S: aws.String(request.Status)},
+			":by":     {S: aws.String(user.ID)},
+			":at":     {S: aws.String(now.Format(time.RFC3339Nano))},
+			":note":   {S: aws.String(request.Note)},
+		},
+		ReturnValues: aws.String("ALL_NEW"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Content report not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error reviewing content report", "error", err)
+		respondStorageError(c, err, "Failed to update content report")
+		return
+	}
+
+	var report ContentReport
+	if err := dynamodbattribute.UnmarshalMap(updated.Attributes, &report); err != nil {
+		requestLogger(c).Error("error unmarshaling content report", "error", err)
+		respondStorageError(c, err, "Failed to update content report")
+		return
+	}
+
+	if request.Status == "dismissed" && report.Quarantined && report.CacheRestoredAt == nil && report.CacheExpiresAt > now.Unix() {
+		if err := h.restoreQuarantinedCache(ctx, &report, now); err != nil {
+			requestLogger(c).Error("error restoring quarantined cache entry for report", "error", err, "report_id", report.ReportID)
+		}
+	}
+
+	log.Printf("🚩 %s marked content report %s as %s", user.ID, report.ReportID, report.Status)
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// restoreQuarantinedCache writes a dismissed report's cache entry back,
+// unless the same parameters have been cached again since
+func (h *PuzzleHub) restoreQuarantinedCache(ctx context.Context, report *ContentReport, now time.Time) error {
+	item, err := dynamodbattribute.MarshalMap(AICacheEntry{
+		CacheKey:  report.CacheKey,
+		Feature:   report.Feature,
+		Value:     report.CachedValue,
+		CreatedAt: now,
+		ExpiresAt: report.CacheExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-ai-cache")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(cache_key)"),
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		return err
+	}
+
+	report.CacheRestoredAt = &now
+	_, err = h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-content-reports")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"report_id": {S: aws.String(report.ReportID)},
+		},
+		UpdateExpression: aws.String("SET cache_restored_at = :at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":at": {S: aws.String(now.Format(time.RFC3339Nano))},
+		},
+	})
+	return err
+}
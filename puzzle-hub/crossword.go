@@ -0,0 +1,643 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Crossword generator
+//
+// A crossword is laid out server-side from words and clues, either a custom
+// list from the request or spelling words (with their definitions as clues)
+// picked the same way as /spelling/generate, cached pool first. Words are
+// placed greedily, longest first, each new word crossing one already on the
+// grid; several attempts are made and the one placing the most words in the
+// smallest grid wins. Words that could not be fitted are reported back.
+//
+// Entries are numbered in reading order like a printed crossword. The
+// client gets the grid shape and clues, never the answers: those stay in
+// the crosswords table (kept 30 days) and answers are checked one entry at
+// a time with /crossword/:id/check.
+
+const (
+	minCrosswordWordLength = 2
+	maxCrosswordWordLength = 15
+	maxCrosswordSize       = 15 // Rows and columns
+	maxCrosswordWords      = 30
+	defaultCrosswordWords  = 10
+	crosswordAttempts      = 12
+	crosswordRetention     = 30 * 24 * time.Hour
+)
+
+type CrosswordWord struct {
+	Word string `json:"word"`
+	Clue string `json:"clue"`
+}
+
+type GenerateCrosswordRequest struct {
+	Words []CrosswordWord `json:"words"` // Custom list; when empty, spelling words are used
+
+	// Spelling word criteria, used when no words are given
+	DifficultyLevel string `json:"difficulty_level"`
+	AgeGroup        string `json:"age_group"`
+	Theme           string `json:"theme"`
+	WordCount       int    `json:"word_count"` // Default 10
+}
+
+type CrosswordClue struct {
+	Number    int    `json:"number"`
+	Direction string `json:"direction"` // across or down
+	Clue      string `json:"clue"`
+	Length    int    `json:"length"`
+	Row       int    `json:"row"`
+	Col       int    `json:"col"`
+}
+
+// CrosswordEntry is a clue with its answer, as stored
+type CrosswordEntry struct {
+	CrosswordClue
+	Answer string `json:"answer"`
+}
+
+type Crossword struct {
+	ID        string           `json:"id" dynamodbav:"crossword_id"`
+	Rows      int              `json:"rows"`
+	Cols      int              `json:"cols"`
+	Entries   []CrosswordEntry `json:"entries"`
+	Source    string           `json:"source"` // custom, or the spelling source (cache, ai, fallback)
+	CreatedAt time.Time        `json:"created_at"`
+	ExpiresAt int64            `json:"-" dynamodbav:"expires_at"`
+}
+
+// CrosswordPuzzle is what players see: no answers
+type CrosswordPuzzle struct {
+	ID       string          `json:"id"`
+	Rows     int             `json:"rows"`
+	Cols     int             `json:"cols"`
+	Grid     []string        `json:"grid"` // One string per row, "#" blocked, "." open
+	Across   []CrosswordClue `json:"across"`
+	Down     []CrosswordClue `json:"down"`
+	Unplaced []string        `json:"unplaced,omitempty"` // Words that didn't fit
+	Source   string          `json:"source"`
+}
+
+type CrosswordAnswer struct {
+	Number    int    `json:"number" binding:"required"`
+	Direction string `json:"direction" binding:"required"`
+	Answer    string `json:"answer"`
+}
+
+type CheckCrosswordRequest struct {
+	Answers []CrosswordAnswer `json:"answers" binding:"required,min=1"`
+}
+
+type CrosswordCheckResult struct {
+	Number    int    `json:"number"`
+	Direction string `json:"direction"`
+	Correct   bool   `json:"correct"`
+}
+
+// crosswordPlacement is a word at a grid position, coordinates relative to
+// the first word until the layout is normalized
+type crosswordPlacement struct {
+	word   CrosswordWord
+	row    int
+	col    int
+	across bool
+}
+
+type crosswordCell struct {
+	letter byte
+	across bool // Part of an across word
+	down   bool // Part of a down word
+}
+
+type crosswordLayout struct {
+	cells                          map[[2]int]*crosswordCell
+	placed                         []crosswordPlacement
+	minRow, maxRow, minCol, maxCol int
+}
+
+// normalizeCrosswordWords uppercases words, drops duplicates and returns a
+// problem description for the first invalid entry
+func normalizeCrosswordWords(words []CrosswordWord) ([]CrosswordWord, string) {
+	seen := map[string]bool{}
+	normalized := make([]CrosswordWord, 0, len(words))
+	for i, entry := range words {
+		word := strings.ToUpper(strings.TrimSpace(entry.Word))
+		clue := strings.TrimSpace(entry.Clue)
+		if clue == "" {
+			return nil, fmt.Sprintf("words[%d] needs a clue", i)
+		}
+		if len(word) < minCrosswordWordLength || len(word) > maxCrosswordWordLength {
+			return nil, fmt.Sprintf("words[%d] must be %d to %d letters", i, minCrosswordWordLength, maxCrosswordWordLength)
+		}
+		for _, r := range word {
+			if r < 'A' || r > 'Z' {
+				return nil, fmt.Sprintf("words[%d] may only contain the letters A-Z", i)
+			}
+		}
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		normalized = append(normalized, CrosswordWord{Word: word, Clue: clue})
+	}
+	if len(normalized) < 2 {
+		return nil, "a crossword needs at least 2 different words"
+	}
+	return normalized, ""
+}
+
+// crosswordWordsFromSpelling turns spelling problems into words and clues,
+// skipping words that can't go in a grid (spaces, hyphens, too long)
+func crosswordWordsFromSpelling(problems []SpellingProblem) []CrosswordWord {
+	var words []CrosswordWord
+	seen := map[string]bool{}
+	for _, problem := range problems {
+		word := strings.ToUpper(strings.TrimSpace(problem.Word))
+		clue := strings.TrimSpace(problem.Definition)
+		if clue == "" || seen[word] || len(word) < minCrosswordWordLength || len(word) > maxCrosswordWordLength {
+			continue
+		}
+		if strings.IndexFunc(word, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+			continue
+		}
+		seen[word] = true
+		words = append(words, CrosswordWord{Word: word, Clue: clue})
+	}
+	return words
+}
+
+// layoutCrossword places as many words as it can, returning the best of
+// several attempts and the words left out
+func layoutCrossword(words []CrosswordWord, rng *rand.Rand) (*crosswordLayout, []string) {
+	var best *crosswordLayout
+	for attempt := 0; attempt < crosswordAttempts; attempt++ {
+		order := make([]CrosswordWord, len(words))
+		copy(order, words)
+		if attempt == 0 {
+			sort.SliceStable(order, func(i, j int) bool { return len(order[i].Word) > len(order[j].Word) })
+		} else {
+			// Longest first still, but shuffled among similar lengths
+			rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+			sort.SliceStable(order, func(i, j int) bool { return len(order[i].Word)/3 > len(order[j].Word)/3 })
+		}
+
+		layout := buildCrossword(order, rng)
+		if best == nil || len(layout.placed) > len(best.placed) ||
+			(len(layout.placed) == len(best.placed) && layout.area() < best.area()) {
+			best = layout
+		}
+		if len(best.placed) == len(words) && attempt >= crosswordAttempts/2 {
+			break
+		}
+	}
+
+	placed := map[string]bool{}
+	for _, p := range best.placed {
+		placed[p.word.Word] = true
+	}
+	var unplaced []string
+	for _, w := range words {
+		if !placed[w.Word] {
+			unplaced = append(unplaced, w.Word)
+		}
+	}
+	return best, unplaced
+}
+
+// buildCrossword places the first word across, then keeps making passes
+// over the rest until a pass places nothing, so a word with no crossing
+// early on can still fit once more words are down
+func buildCrossword(order []CrosswordWord, rng *rand.Rand) *crosswordLayout {
+	layout := &crosswordLayout{cells: map[[2]int]*crosswordCell{}}
+	if len(order) == 0 || len(order[0].Word) > maxCrosswordSize {
+		return layout
+	}
+	layout.place(crosswordPlacement{word: order[0], across: true})
+
+	pending := order[1:]
+	for len(pending) > 0 {
+		var remaining []CrosswordWord
+		for _, word := range pending {
+			if candidate, ok := layout.bestPlacement(word, rng); ok {
+				layout.place(candidate)
+			} else {
+				remaining = append(remaining, word)
+			}
+		}
+		if len(remaining) == len(pending) {
+			break
+		}
+		pending = remaining
+	}
+	return layout
+}
+
+func (l *crosswordLayout) area() int {
+	return (l.maxRow - l.minRow + 1) * (l.maxCol - l.minCol + 1)
+}
+
+func (l *crosswordLayout) place(p crosswordPlacement) {
+	dr, dc := crosswordStep(p.across)
+	for i := 0; i < len(p.word.Word); i++ {
+		pos := [2]int{p.row + i*dr, p.col + i*dc}
+		cell, ok := l.cells[pos]
+		if !ok {
+			cell = &crosswordCell{letter: p.word.Word[i]}
+			l.cells[pos] = cell
+		}
+		if p.across {
+			cell.across = true
+		} else {
+			cell.down = true
+		}
+	}
+
+	endRow, endCol := p.row+(len(p.word.Word)-1)*dr, p.col+(len(p.word.Word)-1)*dc
+	if len(l.placed) == 0 {
+		l.minRow, l.maxRow, l.minCol, l.maxCol = p.row, endRow, p.col, endCol
+	} else {
+		l.minRow, l.maxRow = min(l.minRow, p.row), max(l.maxRow, endRow)
+		l.minCol, l.maxCol = min(l.minCol, p.col), max(l.maxCol, endCol)
+	}
+	l.placed = append(l.placed, p)
+}
+
+// bestPlacement tries every crossing of word with a letter on the grid and
+// picks the one with the most crossings, then the smallest grid
+func (l *crosswordLayout) bestPlacement(word CrosswordWord, rng *rand.Rand) (crosswordPlacement, bool) {
+	var best []crosswordPlacement
+	bestScore := 0
+	for pos, cell := range l.cells {
+		if cell.across && cell.down {
+			continue
+		}
+		across := cell.down // Cross the word already there
+		dr, dc := crosswordStep(across)
+		for i := 0; i < len(word.Word); i++ {
+			if word.Word[i] != cell.letter {
+				continue
+			}
+			candidate := crosswordPlacement{word: word, row: pos[0] - i*dr, col: pos[1] - i*dc, across: across}
+			crossings, area, ok := l.fits(candidate)
+			if !ok {
+				continue
+			}
+			score := crossings*1000 - area
+			switch {
+			case len(best) == 0 || score > bestScore:
+				best, bestScore = []crosswordPlacement{candidate}, score
+			case score == bestScore && !containsPlacement(best, candidate):
+				best = append(best, candidate)
+			}
+		}
+	}
+	if len(best) == 0 {
+		return crosswordPlacement{}, false
+	}
+	// Map order is random, so sort before picking to keep a seed repeatable
+	sort.Slice(best, func(i, j int) bool {
+		if best[i].row != best[j].row {
+			return best[i].row < best[j].row
+		}
+		if best[i].col != best[j].col {
+			return best[i].col < best[j].col
+		}
+		return best[i].across && !best[j].across
+	})
+	return best[rng.Intn(len(best))], true
+}
+
+func containsPlacement(placements []crosswordPlacement, p crosswordPlacement) bool {
+	for _, existing := range placements {
+		if existing.row == p.row && existing.col == p.col && existing.across == p.across {
+			return true
+		}
+	}
+	return false
+}
+
+// fits checks p against the grid: crossed letters must match, no running
+// along a word in the same direction, no new letter touching another word
+// side-on, and the squares just before and after must be empty. It returns
+// the number of crossings and the grid area with p added.
+func (l *crosswordLayout) fits(p crosswordPlacement) (int, int, bool) {
+	dr, dc := crosswordStep(p.across)
+	length := len(p.word.Word)
+
+	if l.cells[[2]int{p.row - dr, p.col - dc}] != nil || l.cells[[2]int{p.row + length*dr, p.col + length*dc}] != nil {
+		return 0, 0, false
+	}
+
+	crossings := 0
+	for i := 0; i < length; i++ {
+		r, c := p.row+i*dr, p.col+i*dc
+		if cell := l.cells[[2]int{r, c}]; cell != nil {
+			if cell.letter != p.word.Word[i] || (p.across && cell.across) || (!p.across && cell.down) {
+				return 0, 0, false
+			}
+			crossings++
+			continue
+		}
+		// Side neighbours of a new letter would form words nobody clued
+		if l.cells[[2]int{r + dc, c + dr}] != nil || l.cells[[2]int{r - dc, c - dr}] != nil {
+			return 0, 0, false
+		}
+	}
+	if crossings == 0 {
+		return 0, 0, false
+	}
+
+	endRow, endCol := p.row+(length-1)*dr, p.col+(length-1)*dc
+	rows := max(l.maxRow, endRow) - min(l.minRow, p.row) + 1
+	cols := max(l.maxCol, endCol) - min(l.minCol, p.col) + 1
+	if rows > maxCrosswordSize || cols > maxCrosswordSize {
+		return 0, 0, false
+	}
+	return crossings, rows * cols, true
+}
+
+func crosswordStep(across bool) (int, int) {
+	if across {
+		return 0, 1
+	}
+	return 1, 0
+}
+
+// numberCrossword moves the layout to start at 0,0 and numbers the entries
+// in reading order, across before down when both start on one square
+func numberCrossword(layout *crosswordLayout) (int, int, []CrosswordEntry) {
+	placements := make([]crosswordPlacement, len(layout.placed))
+	for i, p := range layout.placed {
+		p.row -= layout.minRow
+		p.col -= layout.minCol
+		placements[i] = p
+	}
+	sort.Slice(placements, func(i, j int) bool {
+		if placements[i].row != placements[j].row {
+			return placements[i].row < placements[j].row
+		}
+		if placements[i].col != placements[j].col {
+			return placements[i].col < placements[j].col
+		}
+		return placements[i].across && !placements[j].across
+	})
+
+	entries := make([]CrosswordEntry, 0, len(placements))
+	number := 0
+	lastRow, lastCol := -1, -1
+	for _, p := range placements {
+		if p.row != lastRow || p.col != lastCol {
+			number++
+			lastRow, lastCol = p.row, p.col
+		}
+		direction := "down"
+		if p.across {
+			direction = "across"
+		}
+		entries = append(entries, CrosswordEntry{
+			CrosswordClue: CrosswordClue{
+				Number:    number,
+				Direction: direction,
+				Clue:      p.word.Clue,
+				Length:    len(p.word.Word),
+				Row:       p.row,
+				Col:       p.col,
+			},
+			Answer: p.word.Word,
+		})
+	}
+	rows := layout.maxRow - layout.minRow + 1
+	cols := layout.maxCol - layout.minCol + 1
+	return rows, cols, entries
+}
+
+// puzzle strips the answers, leaving the grid shape and the clues
+func (cw *Crossword) puzzle() CrosswordPuzzle {
+	grid := make([][]byte, cw.Rows)
+	for r := range grid {
+		grid[r] = []byte(strings.Repeat("#", cw.Cols))
+	}
+	puzzle := CrosswordPuzzle{ID: cw.ID, Rows: cw.Rows, Cols: cw.Cols, Source: cw.Source}
+	for _, entry := range cw.Entries {
+		dr, dc := crosswordStep(entry.Direction == "across")
+		for i := 0; i < entry.Length; i++ {
+			grid[entry.Row+i*dr][entry.Col+i*dc] = '.'
+		}
+		if entry.Direction == "across" {
+			puzzle.Across = append(puzzle.Across, entry.CrosswordClue)
+		} else {
+			puzzle.Down = append(puzzle.Down, entry.CrosswordClue)
+		}
+	}
+	puzzle.Grid = make([]string, cw.Rows)
+	for r, row := range grid {
+		puzzle.Grid[r] = string(row)
+	}
+	return puzzle
+}
+
+// generateCrossword lays out a crossword from a custom list or spelling
+// words and stores it for checking
+func (h *PuzzleHub) generateCrossword(c *gin.Context) {
+	var request GenerateCrosswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var words []CrosswordWord
+	source := "custom"
+	if len(request.Words) > 0 {
+		if len(request.Words) > maxCrosswordWords {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("A crossword takes at most %d words", maxCrosswordWords))
+			return
+		}
+		normalized, problem := normalizeCrosswordWords(request.Words)
+		if problem != "" {
+			respondError(c, http.StatusBadRequest, problem)
+			return
+		}
+		words = normalized
+	} else {
+		if request.WordCount == 0 {
+			request.WordCount = defaultCrosswordWords
+		}
+		if request.WordCount < 2 || request.WordCount > maxCrosswordWords {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("word_count must be between 2 and %d", maxCrosswordWords))
+			return
+		}
+		refund, ok := h.consumeAIQuota(c, "spelling", 1)
+		if !ok {
+			return
+		}
+		criteria := GenerationCriteria{
+			DifficultyLevel: request.DifficultyLevel,
+			AgeGroup:        request.AgeGroup,
+			WordCount:       request.WordCount,
+			Theme:           request.Theme,
+		}
+		problems, spellingSource, err := h.GenerateSpellingProblems(ctx, criteria, optionalUserID(c))
+		if err != nil {
+			refund()
+			respondError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		words, source = crosswordWordsFromSpelling(problems), spellingSource
+		if len(words) < 2 {
+			refund()
+			respondError(c, http.StatusUnprocessableEntity, "Not enough usable spelling words for a crossword")
+			return
+		}
+	}
+
+	layout, unplaced := layoutCrossword(words, h.YohakuGenerator.rand)
+	if len(layout.placed) < 2 {
+		respondError(c, http.StatusUnprocessableEntity, "These words don't share enough letters to make a crossword")
+		return
+	}
+
+	now := time.Now()
+	crossword := &Crossword{
+		ID:        fmt.Sprintf("crossword_%d", now.UnixNano()),
+		Source:    source,
+		CreatedAt: now,
+		ExpiresAt: now.Add(crosswordRetention).Unix(),
+	}
+	crossword.Rows, crossword.Cols, crossword.Entries = numberCrossword(layout)
+
+	if err := h.saveCrossword(ctx, crossword); err != nil {
+		requestLogger(c).Error("error saving crossword", "error", err)
+		respondStorageError(c, err, "Failed to save crossword")
+		return
+	}
+
+	puzzle := crossword.puzzle()
+	puzzle.Unplaced = unplaced
+	c.JSON(http.StatusOK, puzzle)
+}
+
+// getCrossword returns a stored crossword without its answers
+func (h *PuzzleHub) getCrossword(c *gin.Context) {
+	crossword, err := h.loadCrossword(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		requestLogger(c).Error("error fetching crossword", "error", err, "crossword_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to fetch crossword")
+		return
+	}
+	if crossword == nil {
+		respondError(c, http.StatusNotFound, "Crossword not found")
+		return
+	}
+	c.JSON(http.StatusOK, crossword.puzzle())
+}
+
+// checkCrossword says which of the given answers are right, without
+// revealing the wrong ones
+func (h *PuzzleHub) checkCrossword(c *gin.Context) {
+	var request CheckCrosswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	crossword, err := h.loadCrossword(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		requestLogger(c).Error("error fetching crossword", "error", err, "crossword_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to fetch crossword")
+		return
+	}
+	if crossword == nil {
+		respondError(c, http.StatusNotFound, "Crossword not found")
+		return
+	}
+
+	entries := map[string]CrosswordEntry{}
+	for _, entry := range crossword.Entries {
+		entries[fmt.Sprintf("%d-%s", entry.Number, entry.Direction)] = entry
+	}
+
+	results := make([]CrosswordCheckResult, 0, len(request.Answers))
+	correct := 0
+	for _, answer := range request.Answers {
+		direction := strings.ToLower(strings.TrimSpace(answer.Direction))
+		entry, ok := entries[fmt.Sprintf("%d-%s", answer.Number, direction)]
+		if !ok {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("There is no %d %s in this crossword", answer.Number, direction))
+			return
+		}
+		given := strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return unicode.ToUpper(r)
+		}, answer.Answer)
+		result := CrosswordCheckResult{Number: entry.Number, Direction: entry.Direction, Correct: given == entry.Answer}
+		if result.Correct {
+			correct++
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"correct": correct,
+		"checked": len(results),
+		"total":   len(crossword.Entries),
+	})
+}
+
+func (h *PuzzleHub) saveCrossword(ctx context.Context, crossword *Crossword) error {
+	item, err := dynamodbattribute.MarshalMap(crossword)
+	if err != nil {
+		return fmt.Errorf("failed to marshal crossword: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-crosswords")),
+		Item:      item,
+	})
+	return err
+}
+
+// loadCrossword returns nil when there is no such crossword. Crosswords
+// never change, so they're cached while players check answers.
+func (h *PuzzleHub) loadCrossword(ctx context.Context, id string) (*Crossword, error) {
+	key := "crossword:" + id
+	var cached Crossword
+	if getCachedJSON(ctx, h.Cache, "crossword", key, &cached) {
+		return &cached, nil
+	}
+
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-crosswords")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"crossword_id": {S: aws.String(id)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	crossword := &Crossword{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, crossword); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal crossword: %v", err)
+	}
+	setCachedJSON(ctx, h.Cache, key, crossword, cachedValueTTL)
+	return crossword, nil
+}
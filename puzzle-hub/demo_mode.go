@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Demo mode
+//
+// DEMO_MODE=true runs the hub without any API keys or cloud account, for
+// public demos and for contributors trying it out:
+//
+//	storage  users, sessions, preferences, feedback, logs, analytics and
+//	         jobs are kept in memory (STORAGE_BACKEND is ignored)
+//	AWS      no request leaves the process: DynamoDB reads find nothing and
+//	         features that would write to it answer 503 "Not available in
+//	         demo mode"; S3 uploads and SES emails go nowhere (see
+//	         aws_offline.go)
+//	AI       every feature serves cached or built-in content (see
+//	         ai_fallbacks.go); no provider is called or needs a key
+//
+// Everything is lost on restart. Google sign-in still needs its OAuth
+// credentials, but guest play (POST /auth/guest) works without them.
+
+var demoMode bool
+
+// errAIDemoMode is returned for every AI call in demo mode, so callers use
+// their fallbacks
+var errAIDemoMode = errors.New("AI providers are disabled in demo mode")
+
+// configureDemoMode reads DEMO_MODE
+func configureDemoMode() {
+	demoMode, _ = strconv.ParseBool(os.Getenv("DEMO_MODE"))
+	if demoMode {
+		log.Println("🎭 Demo mode: in-memory storage, no AWS, built-in content instead of AI")
+	}
+}
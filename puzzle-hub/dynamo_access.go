@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// DynamoDB access
+//
+// Every DynamoDB call goes through the client built by newDynamoDBClient:
+//
+//   - Throttling (ProvisionedThroughputExceeded, ThrottlingException,
+//     RequestLimitExceeded) and 5xx errors are retried with exponential
+//     backoff and jitter, up to DYNAMODB_MAX_RETRIES times (default 8).
+//   - Errors come back as *DynamoDBError, naming the operation and table.
+//     errors.Is matches it against errStorageThrottled and
+//     errStorageUnavailable, and errors.As still finds the SDK's awserr.Error.
+//
+// Reads that need every matching item use queryAll or scanAll, reads of the
+// first n items queryLimit and counts countQuery; all of them follow
+// LastEvaluatedKey, so results are never cut off at DynamoDB's 1MB page.
+// Batch reads and writes retry their unprocessed items with backoff.
+//
+// Handlers answer a failed storage call with respondStorageError, which
+// turns throttling into a 503 with Retry-After instead of a 500, and a
+// write to the offline client (see aws_offline.go) into a 503
+// "not_available".
+
+const (
+	defaultDynamoDBMaxRetries = 8
+	dynamoBatchGetSize        = 100 // BatchGetItem limit
+	dynamoBatchMaxRetries     = 5   // Rounds of unprocessed items before giving up
+	dynamoBatchRetryBaseWait  = 100 * time.Millisecond
+	storageRetryAfterSeconds  = 2
+)
+
+var (
+	errStorageThrottled   = errors.New("storage throttled")
+	errStorageUnavailable = errors.New("storage unavailable")
+)
+
+// dynamoThrottleCodes are the error codes DynamoDB throttles with
+var dynamoThrottleCodes = map[string]bool{
+	dynamodb.ErrCodeProvisionedThroughputExceededException: true,
+	dynamodb.ErrCodeRequestLimitExceeded:                   true,
+	"ThrottlingException":                                  true,
+}
+
+// DynamoDBError is a failed DynamoDB call, after any retries
+type DynamoDBError struct {
+	Operation  string
+	Table      string // Empty for calls on several tables
+	Code       string // AWS error code, e.g. ConditionalCheckFailedException
+	StatusCode int
+	Retries    int
+	Err        error
+}
+
+func (e *DynamoDBError) Error() string {
+	target := e.Operation
+	if e.Table != "" {
+		target += " on " + e.Table
+	}
+	if e.Retries > 0 {
+		return fmt.Sprintf("dynamodb %s failed after %d retries: %v", target, e.Retries, e.Err)
+	}
+	return fmt.Sprintf("dynamodb %s failed: %v", target, e.Err)
+}
+
+func (e *DynamoDBError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches errStorageThrottled and errStorageUnavailable
+func (e *DynamoDBError) Is(target error) bool {
+	switch target {
+	case errStorageThrottled:
+		return dynamoThrottleCodes[e.Code]
+	case errStorageUnavailable:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// newDynamoDBClient returns the DynamoDB client the whole app shares, with
+// retries, metrics and typed errors
+func newDynamoDBClient(sess *session.Session) *dynamodb.DynamoDB {
+	retries := defaultDynamoDBMaxRetries
+	if value := os.Getenv("DYNAMODB_MAX_RETRIES"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			retries = parsed
+		} else {
+			log.Printf("⚠️  Ignoring invalid DYNAMODB_MAX_RETRIES=%q", value)
+		}
+	}
+
+	config := request.WithRetryer(aws.NewConfig(), client.DefaultRetryer{
+		NumMaxRetries:    retries,
+		MinRetryDelay:    25 * time.Millisecond,
+		MaxRetryDelay:    2 * time.Second,
+		MinThrottleDelay: 100 * time.Millisecond,
+		MaxThrottleDelay: 5 * time.Second,
+	})
+	svc := dynamodb.New(sess, config)
+	instrumentDynamoDB(svc)
+
+	// An error still set after the retry handlers is what the call returns
+	svc.Handlers.AfterRetry.PushBackNamed(request.NamedHandler{
+		Name: "puzzlehub.dynamodb-errors",
+		Fn: func(r *request.Request) {
+			if r.Error == nil {
+				return
+			}
+			var wrapped *DynamoDBError
+			if errors.As(r.Error, &wrapped) {
+				return
+			}
+			dynamoErr := &DynamoDBError{
+				Operation: r.Operation.Name,
+				Retries:   r.RetryCount,
+				Err:       r.Error,
+			}
+			if tables, err := awsutil.ValuesAtPath(r.Params, "TableName"); err == nil && len(tables) == 1 {
+				if table, ok := tables[0].(*string); ok {
+					dynamoErr.Table = aws.StringValue(table)
+				}
+			}
+			var aerr awserr.Error
+			if errors.As(r.Error, &aerr) {
+				dynamoErr.Code = aerr.Code()
+			}
+			if r.HTTPResponse != nil {
+				dynamoErr.StatusCode = r.HTTPResponse.StatusCode
+			}
+			r.Error = dynamoErr
+		},
+	})
+	return svc
+}
+
+// respondStorageError answers a request whose storage call failed: 503 with
+// Retry-After when DynamoDB is throttling or unavailable, 503 without when
+// it is offline, otherwise 500
+func respondStorageError(c *gin.Context, err error, message string) {
+	if errors.Is(err, errDynamoDBOffline) {
+		respondErrorCode(c, http.StatusServiceUnavailable, "not_available", "Not available "+dynamoDBOfflineReason, nil)
+		return
+	}
+	if errors.Is(err, errStorageThrottled) || errors.Is(err, errStorageUnavailable) {
+		c.Header("Retry-After", strconv.Itoa(storageRetryAfterSeconds))
+		respondErrorCode(c, http.StatusServiceUnavailable, "storage_busy", message, nil)
+		return
+	}
+	respondError(c, http.StatusInternalServerError, message)
+}
+
+// scanAll reads every item matching the scan, following pagination
+func scanAll[T any](db *dynamodb.DynamoDB, input *dynamodb.ScanInput) ([]T, error) {
+	items := []T{}
+	var unmarshalErr error
+	err := db.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var pageItems []T
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems); unmarshalErr != nil {
+			return false
+		}
+		items = append(items, pageItems...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	return items, err
+}
+
+// queryAll reads every item matching the query, following pagination
+func queryAll[T any](db *dynamodb.DynamoDB, input *dynamodb.QueryInput) ([]T, error) {
+	return queryLimit[T](context.Background(), db, input, 0)
+}
+
+// queryLimit reads the first limit items matching the query (all of them
+// when limit is 0), following pagination. A query's Limit caps each page,
+// and a page also ends at 1MB, so a single call can return fewer.
+func queryLimit[T any](ctx context.Context, db *dynamodb.DynamoDB, input *dynamodb.QueryInput, limit int) ([]T, error) {
+	if limit > 0 {
+		input.Limit = aws.Int64(int64(limit))
+	}
+	items := []T{}
+	var unmarshalErr error
+	err := db.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var pageItems []T
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems); unmarshalErr != nil {
+			return false
+		}
+		items = append(items, pageItems...)
+		return limit == 0 || len(items) < limit
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, err
+}
+
+// countQuery counts the items matching the query. DynamoDB counts at most
+// 1MB of items per call, so the count is summed over every page.
+func countQuery(ctx context.Context, db *dynamodb.DynamoDB, input *dynamodb.QueryInput) (int, error) {
+	input.Select = aws.String(dynamodb.SelectCount)
+	count := 0
+	err := db.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		count += int(aws.Int64Value(page.Count))
+		return true
+	})
+	return count, err
+}
+
+// batchGetItems reads keys from table in chunks of dynamoBatchGetSize,
+// retrying unprocessed keys with exponential backoff
+func batchGetItems(db *dynamodb.DynamoDB, table string, keys []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+	for start := 0; start < len(keys); start += dynamoBatchGetSize {
+		end := min(start+dynamoBatchGetSize, len(keys))
+		pending := map[string]*dynamodb.KeysAndAttributes{table: {Keys: keys[start:end]}}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == dynamoBatchMaxRetries {
+				return items, fmt.Errorf("gave up reading %d items from %s: %w", len(pending[table].Keys), table, errStorageThrottled)
+			}
+			if attempt > 0 {
+				time.Sleep(dynamoBatchRetryBaseWait << (attempt - 1))
+			}
+
+			result, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return items, err
+			}
+			items = append(items, result.Responses[table]...)
+			pending = result.UnprocessedKeys
+		}
+	}
+	return items, nil
+}
+
+// batchWriteItems writes requests in chunks of analyticsBatchSize, retrying
+// unprocessed items with exponential backoff
+func batchWriteItems(db *dynamodb.DynamoDB, table string, requests []*dynamodb.WriteRequest) error {
+	failed := 0
+	for start := 0; start < len(requests); start += analyticsBatchSize {
+		end := min(start+analyticsBatchSize, len(requests))
+		pending := map[string][]*dynamodb.WriteRequest{table: requests[start:end]}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == dynamoBatchMaxRetries {
+				failed += len(pending[table])
+				break
+			}
+			if attempt > 0 {
+				time.Sleep(dynamoBatchRetryBaseWait << (attempt - 1))
+			}
+
+			result, err := db.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				log.Printf("Warning: Failed to write to %s (attempt %d): %v", table, attempt+1, err)
+				continue
+			}
+			pending = result.UnprocessedItems
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("gave up writing %d items to %s", failed, table)
+	}
+	return nil
+}
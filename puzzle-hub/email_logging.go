@@ -0,0 +1,599 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Quick-log by email
+//
+// Users get a private address, log+<token>@INBOUND_EMAIL_DOMAIN, and can
+// log entries by emailing it one line per entry:
+//
+//	gym: squat 5x5 225
+//	water: 8 #morning
+//	mood: happy note=slept_well
+//
+// SES receives the mail and a Lambda (or any relay) POSTs it to
+// /integrations/email/inbound as JSON, signed with X-PuzzleHub-Signature:
+// sha256=<hex HMAC of the body with INBOUND_EMAIL_SECRET>. The mail is only
+// accepted from the account email the address was created with, and only
+// when SES reports SPF or DKIM passing for it.
+//
+// The text before the colon picks the log type by name (or the start of
+// one). The rest is read left to right: field=value pairs set that field,
+// NxM gives two numbers, numbers (units after them are ignored) fill the
+// number fields in order, #words go to a tags field, words matching a
+// select option or checkbox name set that field, "yesterday" or
+// date=YYYY-MM-DD sets the entry date, and the remaining words become the
+// first text field. Entries are keyed by the message ID, so a redelivered
+// mail doesn't log twice. The user gets a notification of what was logged
+// or why a line wasn't.
+
+const (
+	maxEmailLogLines     = 10
+	maxInboundEmailBytes = 256 << 10
+	emailLogAddressUser  = "log"
+)
+
+var (
+	quickLogNumber = regexp.MustCompile(`^(-?\d+(?:\.\d+)?)[a-z%]*$`)
+	quickLogTimes  = regexp.MustCompile(`^(\d+)x(\d+)$`)
+)
+
+// EmailLogAddress is a user's private logging address
+type EmailLogAddress struct {
+	UserID    string    `json:"-" dynamodbav:"user_id"`
+	Token     string    `json:"-" dynamodbav:"address_token"`
+	Sender    string    `json:"sender" dynamodbav:"sender"` // The account email mail must come from
+	Address   string    `json:"address" dynamodbav:"-"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+// InboundEmail is what the SES relay posts
+type InboundEmail struct {
+	MessageID  string   `json:"message_id" binding:"required"`
+	Recipients []string `json:"recipients" binding:"required"`
+	From       string   `json:"from" binding:"required"`
+	Subject    string   `json:"subject"`
+	Text       string   `json:"text"`
+	SPF        string   `json:"spf"`  // SES spfVerdict, e.g. PASS
+	DKIM       string   `json:"dkim"` // SES dkimVerdict
+}
+
+type EmailLogResult struct {
+	Line      string `json:"line"`
+	LogTypeID string `json:"log_type_id,omitempty"`
+	EntryID   string `json:"entry_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (a *EmailLogAddress) fill(domain string) {
+	a.Address = emailLogAddressUser + "+" + a.Token + "@" + domain
+}
+
+// emailLoggingEnabled reports whether the inbound domain and secret are set
+func (h *PuzzleHub) emailLoggingEnabled(c *gin.Context) bool {
+	if h.InboundEmailDomain == "" || h.InboundEmailSecret == "" {
+		respondErrorCode(c, http.StatusServiceUnavailable, "email_logging_not_configured", "Logging by email isn't set up on this server", nil)
+		return false
+	}
+	return true
+}
+
+// getEmailLogAddress returns the user's address, if they made one
+func (h *PuzzleHub) getEmailLogAddress(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	if !h.emailLoggingEnabled(c) {
+		return
+	}
+	address, err := h.loadEmailLogAddress(c.Request.Context(), userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching email log address", "error", err)
+		respondStorageError(c, err, "Failed to fetch address")
+		return
+	}
+	if address == nil {
+		respondError(c, http.StatusNotFound, "You don't have a logging address yet")
+		return
+	}
+	address.fill(h.InboundEmailDomain)
+	c.JSON(http.StatusOK, gin.H{"email_address": address})
+}
+
+// createEmailLogAddress gives the user a new address, replacing any old
+// one, and records their account email as the only allowed sender
+func (h *PuzzleHub) createEmailLogAddress(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	if !h.emailLoggingEnabled(c) {
+		return
+	}
+	if userObj.IsGuest || userObj.Email == "" {
+		respondError(c, http.StatusForbidden, "Sign in with an email account to log by email")
+		return
+	}
+
+	token, err := randomToken(10)
+	if err != nil {
+		requestLogger(c).Error("error creating email log address", "error", err)
+		respondStorageError(c, err, "Failed to create address")
+		return
+	}
+	address := &EmailLogAddress{
+		UserID:    userObj.ID,
+		Token:     token,
+		Sender:    strings.ToLower(userObj.Email),
+		CreatedAt: time.Now(),
+	}
+	item, err := dynamodbattribute.MarshalMap(address)
+	if err == nil {
+		_, err = h.DynamoDB.PutItemWithContext(c.Request.Context(), &dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-email-log-addresses")),
+			Item:      item,
+		})
+	}
+	if err != nil {
+		requestLogger(c).Error("error saving email log address", "error", err)
+		respondStorageError(c, err, "Failed to create address")
+		return
+	}
+	address.fill(h.InboundEmailDomain)
+	c.JSON(http.StatusCreated, gin.H{"email_address": address})
+}
+
+// deleteEmailLogAddress turns logging by email off
+func (h *PuzzleHub) deleteEmailLogAddress(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-email-log-addresses")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userObj.ID)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error deleting email log address", "error", err)
+		respondStorageError(c, err, "Failed to delete address")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logging by email is off"})
+}
+
+// receiveLogEmail logs the entries in an inbound email
+func (h *PuzzleHub) receiveLogEmail(c *gin.Context) {
+	if !h.emailLoggingEnabled(c) {
+		return
+	}
+	ctx := c.Request.Context()
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxInboundEmailBytes+1))
+	if err != nil || len(body) > maxInboundEmailBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, "Email is too large")
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(h.InboundEmailSecret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(c.GetHeader("X-PuzzleHub-Signature"))) {
+		respondError(c, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+	var email InboundEmail
+	if err := json.Unmarshal(body, &email); err != nil || email.MessageID == "" {
+		respondError(c, http.StatusBadRequest, "Invalid inbound email")
+		return
+	}
+
+	address, err := h.emailLogAddressFor(ctx, email.Recipients)
+	if err != nil {
+		requestLogger(c).Error("error looking up email log address", "error", err)
+		respondStorageError(c, err, "Failed to look up address")
+		return
+	}
+	if address == nil {
+		respondError(c, http.StatusNotFound, "No logging address matches the recipients")
+		return
+	}
+
+	from, err := mail.ParseAddress(email.From)
+	if err != nil || !strings.EqualFold(from.Address, address.Sender) {
+		requestLogger(c).Warn("email log rejected: sender doesn't match the account email", "user_id", address.UserID)
+		respondError(c, http.StatusForbidden, "Sender doesn't match the account email")
+		return
+	}
+	if !strings.EqualFold(email.SPF, "PASS") && !strings.EqualFold(email.DKIM, "PASS") {
+		requestLogger(c).Warn("email log rejected by SPF or DKIM", "user_id", address.UserID, "spf", email.SPF, "dkim", email.DKIM)
+		respondError(c, http.StatusForbidden, "Sender couldn't be verified")
+		return
+	}
+
+	results := h.logEmailLines(ctx, address.UserID, email)
+	h.notifyEmailLog(ctx, address, results)
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// emailLogLines returns the lines of an email worth reading: the body up
+// to a signature or quoted reply, or the subject when the body is empty
+func emailLogLines(email InboundEmail) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(email.Text, "\r\n", "\n"), "\n") {
+		if line == "-- " || line == "--" || strings.HasPrefix(line, ">") {
+			break
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "On ") && strings.HasSuffix(trimmed, "wrote:") {
+			break
+		}
+		if trimmed != "" {
+			lines = append(lines, trimmed)
+		}
+	}
+	if len(lines) == 0 && strings.TrimSpace(email.Subject) != "" {
+		lines = []string{strings.TrimSpace(email.Subject)}
+	}
+	if len(lines) > maxEmailLogLines {
+		lines = lines[:maxEmailLogLines]
+	}
+	return lines
+}
+
+// logEmailLines creates an entry for each line naming a log type
+func (h *PuzzleHub) logEmailLines(ctx context.Context, userID string, email InboundEmail) []EmailLogResult {
+	lines := emailLogLines(email)
+	results := make([]EmailLogResult, 0, len(lines))
+	logTypes, err := h.Store.ListLogTypes(userID)
+	if err == nil {
+		var shared []LogType
+		shared, err = h.sharedLogTypesFor(userID)
+		logTypes = append(logTypes, shared...)
+	}
+	if err != nil {
+		log.Printf("Error listing log types for email log %s: %v", userID, err)
+		for _, line := range lines {
+			results = append(results, EmailLogResult{Line: line, Error: "Couldn't read your log types, try again later"})
+		}
+		return results
+	}
+	loc := userLocation(&User{Timezone: h.savedTimezone(userID)})
+	today := time.Now().In(loc)
+
+	for i, line := range lines {
+		result := EmailLogResult{Line: line}
+		name, rest, ok := strings.Cut(line, ":")
+		logType := matchLogTypeName(logTypes, name)
+		switch {
+		case !ok:
+			result.Error = `Start the line with a log type and a colon, like "gym: squat 5x5 225"`
+		case logType == nil:
+			result.Error = fmt.Sprintf("You don't have a log type called %q", strings.TrimSpace(name))
+		default:
+			result.LogTypeID = logType.ID
+			result.EntryID, err = h.logEmailLine(userID, logType, rest, today, email.MessageID, i)
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// matchLogTypeName finds the log type named name, or the only one whose
+// name starts with it
+func matchLogTypeName(logTypes []LogType, name string) *LogType {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil
+	}
+	var prefixed []int
+	for i, logType := range logTypes {
+		typeName := strings.ToLower(logType.Name)
+		if typeName == name {
+			return &logTypes[i]
+		}
+		if strings.HasPrefix(typeName, name) {
+			prefixed = append(prefixed, i)
+		}
+	}
+	if len(prefixed) == 1 {
+		return &logTypes[prefixed[0]]
+	}
+	return nil
+}
+
+// logEmailLine parses one line's values and stores the entry. Its ID comes
+// from the message, so logging the same mail again changes nothing.
+func (h *PuzzleHub) logEmailLine(userID string, logType *LogType, text string, today time.Time, messageID string, line int) (string, error) {
+	fields, err := h.getLogFields(logType.ID)
+	if err != nil {
+		log.Printf("Error querying log fields for email log: %v", err)
+		return "", fmt.Errorf("Couldn't read the log type's fields, try again later")
+	}
+	values, entryDate, err := parseQuickLog(text, fields, today)
+	if err != nil {
+		return "", err
+	}
+	if err := validateLogEntryValues(fields, values); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(messageID + "#" + strconv.Itoa(line)))
+	now := time.Now()
+	entry := LogEntry{
+		ID:        "le_email_" + hex.EncodeToString(sum[:12]),
+		LogTypeID: logType.ID,
+		UserID:    userID,
+		EntryDate: entryDate,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Values:    values,
+		Version:   1,
+	}
+	err = h.Store.CreateLogEntry(&entry)
+	if errors.Is(err, errStorageConflict) {
+		return entry.ID, nil
+	}
+	if err != nil {
+		log.Printf("Error creating email log entry for %s: %v", userID, err)
+		return "", fmt.Errorf("Couldn't save the entry, try again later")
+	}
+	h.recordSyncChange(userID, syncOpUpsert, entry.ID, entry.Version, &entry)
+	h.adjustLogAggregates(&entry, 1)
+	return entry.ID, nil
+}
+
+// normalizeFieldKey compares field names ignoring case, spaces and
+// underscores
+func normalizeFieldKey(name string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// parseQuickLog reads a quick-log line into entry values and a date, as
+// described at the top of this file
+func parseQuickLog(text string, fields []LogField, today time.Time) (map[string]interface{}, string, error) {
+	values := map[string]interface{}{}
+	entryDate := today.Format("2006-01-02")
+	byKey := make(map[string]LogField, len(fields))
+	for _, field := range fields {
+		byKey[normalizeFieldKey(field.FieldName)] = field
+	}
+	_, hasDateField := byKey["date"]
+
+	var numbers []float64
+	var words []string
+	var tags []string
+	for _, token := range strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' }) {
+		lower := strings.ToLower(token)
+
+		if key, value, ok := strings.Cut(token, "="); ok {
+			if key := normalizeFieldKey(key); key == "date" && !hasDateField {
+				if _, err := time.Parse("2006-01-02", value); err != nil {
+					return nil, "", fmt.Errorf("Dates look like 2025-01-31, not %q", value)
+				}
+				entryDate = value
+				continue
+			}
+			field, ok := byKey[normalizeFieldKey(key)]
+			if !ok {
+				return nil, "", fmt.Errorf("The log type has no field %q", key)
+			}
+			value = strings.ReplaceAll(value, "_", " ")
+			if field.FieldType == FieldTypeNumber {
+				number, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, "", fmt.Errorf("%s must be a number", field.FieldName)
+				}
+				values[field.FieldName] = number
+			} else {
+				values[field.FieldName] = value
+			}
+			continue
+		}
+
+		switch {
+		case lower == "yesterday" && !hasDateField:
+			entryDate = today.AddDate(0, 0, -1).Format("2006-01-02")
+		case lower == "today" && !hasDateField:
+		case quickLogTimes.MatchString(lower):
+			parts := quickLogTimes.FindStringSubmatch(lower)
+			for _, part := range parts[1:] {
+				number, _ := strconv.ParseFloat(part, 64)
+				numbers = append(numbers, number)
+			}
+		case quickLogNumber.MatchString(lower):
+			number, _ := strconv.ParseFloat(quickLogNumber.FindStringSubmatch(lower)[1], 64)
+			numbers = append(numbers, number)
+		case strings.HasPrefix(token, "#") && len(token) > 1:
+			tags = append(tags, token[1:])
+		default:
+			if !setQuickLogOption(values, fields, token) {
+				words = append(words, token)
+			}
+		}
+	}
+
+	// Numbers fill the number fields that weren't named, in order
+	for _, field := range fields {
+		if len(numbers) == 0 {
+			break
+		}
+		if _, set := values[field.FieldName]; field.FieldType == FieldTypeNumber && !set {
+			values[field.FieldName] = numbers[0]
+			numbers = numbers[1:]
+		}
+	}
+	for _, number := range numbers {
+		words = append(words, strconv.FormatFloat(number, 'f', -1, 64))
+	}
+
+	if len(tags) > 0 {
+		placed := false
+		for _, field := range fields {
+			if field.FieldType == FieldTypeTags {
+				values[field.FieldName] = append(toInterfaces(values[field.FieldName]), stringsToInterfaces(tags)...)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			for _, tag := range tags {
+				words = append(words, "#"+tag)
+			}
+		}
+	}
+
+	if len(words) > 0 {
+		placed := false
+		for _, field := range fields {
+			if _, set := values[field.FieldName]; (field.FieldType == FieldTypeText || field.FieldType == FieldTypeTextarea) && !set {
+				values[field.FieldName] = strings.Join(words, " ")
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return nil, "", fmt.Errorf("Not sure where %q goes; name the field, like field=value", strings.Join(words, " "))
+		}
+	}
+
+	for _, field := range fields {
+		if _, set := values[field.FieldName]; field.Required && !set && field.FieldType != FieldTypeMultiselect && field.FieldType != FieldTypeTags {
+			return nil, "", fmt.Errorf("%s is required", field.FieldName)
+		}
+	}
+	return values, entryDate, nil
+}
+
+// setQuickLogOption sets a select, multiselect or checkbox field the word
+// names, and reports whether it did
+func setQuickLogOption(values map[string]interface{}, fields []LogField, word string) bool {
+	for _, field := range fields {
+		switch field.FieldType {
+		case FieldTypeCheckbox:
+			if normalizeFieldKey(field.FieldName) == normalizeFieldKey(word) {
+				values[field.FieldName] = true
+				return true
+			}
+		case FieldTypeSelect, FieldTypeMultiselect:
+			if _, set := values[field.FieldName]; set && field.FieldType == FieldTypeSelect {
+				continue
+			}
+			for _, option := range parseFieldOptions(field.Options) {
+				if !strings.EqualFold(option, word) {
+					continue
+				}
+				if field.FieldType == FieldTypeSelect {
+					values[field.FieldName] = option
+				} else {
+					values[field.FieldName] = append(toInterfaces(values[field.FieldName]), option)
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toInterfaces(value interface{}) []interface{} {
+	items, _ := value.([]interface{})
+	return items
+}
+
+func stringsToInterfaces(items []string) []interface{} {
+	out := make([]interface{}, len(items))
+	for i, item := range items {
+		out[i] = item
+	}
+	return out
+}
+
+// notifyEmailLog tells the user what their email logged
+func (h *PuzzleHub) notifyEmailLog(ctx context.Context, address *EmailLogAddress, results []EmailLogResult) {
+	logged := 0
+	var lines []string
+	for _, result := range results {
+		if result.Error == "" {
+			logged++
+			lines = append(lines, "✓ "+result.Line)
+		} else {
+			lines = append(lines, "✗ "+result.Line+": "+result.Error)
+		}
+	}
+	title := fmt.Sprintf("Logged %d of %d lines from your email", logged, len(results))
+	if len(results) == 0 {
+		title = "Your email had nothing to log"
+	}
+	h.notify(ctx, Notification{
+		UserID: address.UserID,
+		Kind:   notificationEmailLog,
+		Title:  title,
+		Body:   strings.Join(lines, "\n"),
+		Link:   "/logs",
+	}, address.Sender)
+}
+
+// emailLogAddressFor finds the logging address among the recipients
+func (h *PuzzleHub) emailLogAddressFor(ctx context.Context, recipients []string) (*EmailLogAddress, error) {
+	for _, recipient := range recipients {
+		parsed, err := mail.ParseAddress(recipient)
+		if err != nil {
+			continue
+		}
+		local, domain, _ := strings.Cut(strings.ToLower(parsed.Address), "@")
+		token, ok := strings.CutPrefix(local, emailLogAddressUser+"+")
+		if !ok || domain != strings.ToLower(h.InboundEmailDomain) || token == "" {
+			continue
+		}
+
+		addresses, err := queryLimit[EmailLogAddress](ctx, h.DynamoDB, &dynamodb.QueryInput{
+			TableName:              aws.String(tableName("puzzle-hub-email-log-addresses")),
+			IndexName:              aws.String("address_token-index"),
+			KeyConditionExpression: aws.String("address_token = :token"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":token": {S: aws.String(token)},
+			},
+		}, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(addresses) == 0 {
+			continue
+		}
+		return &addresses[0], nil
+	}
+	return nil, nil
+}
+
+func (h *PuzzleHub) loadEmailLogAddress(ctx context.Context, userID string) (*EmailLogAddress, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-email-log-addresses")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return nil, err
+	}
+	var address EmailLogAddress
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &address); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal address: %v", err)
+	}
+	return &address, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Error and panic reporting
+//
+// Every response carries an X-Request-ID correlation ID (reused from the
+// incoming header when a proxy already set one). Panics and 5xx responses
+// are stored with that ID, the route, the user and any stack trace in the
+// puzzle-hub-errors table, so the ID from a user's bug report leads
+// straight to the server-side error via /api/admin/errors/:requestId.
+
+const requestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{8,64}$`)
+
+type ErrorReport struct {
+	RequestID string    `json:"request_id" dynamodbav:"request_id"`
+	Kind      string    `json:"kind" dynamodbav:"kind"` // panic or http_5xx
+	Method    string    `json:"method" dynamodbav:"method"`
+	Path      string    `json:"path" dynamodbav:"path"`
+	Route     string    `json:"route,omitempty" dynamodbav:"route,omitempty"`
+	Status    int       `json:"status" dynamodbav:"status"`
+	UserID    string    `json:"user_id,omitempty" dynamodbav:"user_id,omitempty"`
+	Message   string    `json:"message" dynamodbav:"message"`
+	Stack     string    `json:"stack,omitempty" dynamodbav:"stack,omitempty"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt int64     `json:"-" dynamodbav:"expires_at"` // Unix seconds, reports are kept 30 days
+}
+
+type ErrorReporter struct {
+	db *dynamodb.DynamoDB
+}
+
+func NewErrorReporter(db *dynamodb.DynamoDB) *ErrorReporter {
+	return &ErrorReporter{db: db}
+}
+
+// report logs the error and stores it in the background
+func (r *ErrorReporter) report(report ErrorReport) {
+	log.Printf("💥 %s %s %s (request %s): %s", report.Kind, report.Method, report.Path, report.RequestID, report.Message)
+
+	report.CreatedAt = time.Now()
+	report.ExpiresAt = report.CreatedAt.Add(30 * 24 * time.Hour).Unix()
+	go func() {
+		item, err := dynamodbattribute.MarshalMap(report)
+		if err != nil {
+			log.Printf("Error marshaling error report: %v", err)
+			return
+		}
+		if _, err := r.db.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-errors")),
+			Item:      item,
+		}); err != nil {
+			log.Printf("Error saving error report %s: %v", report.RequestID, err)
+		}
+	}()
+}
+
+// requestIDMiddleware assigns each request its correlation ID
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			id, err := randomToken(12)
+			if err != nil {
+				id = fmt.Sprintf("%x", time.Now().UnixNano())
+			}
+			requestID = id
+		}
+		c.Set("request_id", requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// errorCaptureWriter keeps the start of 5xx response bodies for reports
+type errorCaptureWriter struct {
+	gin.ResponseWriter
+	body []byte
+}
+
+func (w *errorCaptureWriter) Write(b []byte) (int, error) {
+	if w.Status() >= 500 && len(w.body) < 2048 {
+		w.body = append(w.body, b[:min(len(b), 2048-len(w.body))]...)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorCaptureWriter) WriteString(str string) (int, error) {
+	return w.Write([]byte(str))
+}
+
+// errorReportingMiddleware recovers panics as 500s and reports panics and
+// 5xx responses with the request's correlation ID
+func (h *PuzzleHub) errorReportingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := &errorCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			report := h.newErrorReport(c, "panic", http.StatusInternalServerError)
+			report.Message = fmt.Sprint(recovered)
+			report.Stack = string(debug.Stack())
+			h.Errors.report(report)
+
+			if !c.Writer.Written() {
+				abortWithError(c, http.StatusInternalServerError, "",
+					"Something went wrong. Please include this ID if you report the problem.", nil)
+			} else {
+				c.Abort()
+			}
+		}()
+
+		c.Next()
+
+		if status := c.Writer.Status(); status >= 500 {
+			report := h.newErrorReport(c, "http_5xx", status)
+			switch {
+			case len(c.Errors) > 0:
+				report.Message = c.Errors.String()
+			case len(writer.body) > 0:
+				report.Message = string(writer.body)
+			default:
+				report.Message = http.StatusText(status)
+			}
+			h.Errors.report(report)
+		}
+	}
+}
+
+func (h *PuzzleHub) newErrorReport(c *gin.Context, kind string, status int) ErrorReport {
+	return ErrorReport{
+		RequestID: c.GetString("request_id"),
+		Kind:      kind,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Route:     c.FullPath(),
+		Status:    status,
+		UserID:    optionalUserID(c),
+	}
+}
+
+// adminGetErrorReport looks up the report for a correlation ID a user sent in
+func (h *PuzzleHub) adminGetErrorReport(c *gin.Context) {
+	result, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-errors")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"request_id": {S: aws.String(c.Param("requestId"))},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching error report", "error", err)
+		respondStorageError(c, err, "Failed to fetch error report")
+		return
+	}
+	if result.Item == nil {
+		respondError(c, http.StatusNotFound, "No error reported for this request ID")
+		return
+	}
+
+	var report ErrorReport
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &report); err != nil {
+		requestLogger(c).Error("error unmarshaling error report", "error", err)
+		respondStorageError(c, err, "Failed to fetch error report")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Admin feedback management: triage across all users' feedback
+
+var validFeedbackStatuses = map[string]bool{
+	"new":         true,
+	"reviewed":    true,
+	"in-progress": true,
+	"completed":   true,
+}
+
+type UpdateFeedbackStatusRequest struct {
+	Status             string `json:"status" binding:"required"`
+	PublishToChangelog bool   `json:"publish_to_changelog"` // Only with status "completed"
+	ChangelogSummary   string `json:"changelog_summary,omitempty"`
+}
+
+type AssignFeedbackRequest struct {
+	AssignedTo string `json:"assigned_to"` // Empty string unassigns
+}
+
+type AddFeedbackNoteRequest struct {
+	Note string `json:"note" binding:"required"`
+}
+
+// adminListFeedback lists feedback from every user, optionally filtered by
+// type, status and app_name, newest first. status_counts covers every
+// match, not just the page.
+func (h *PuzzleHub) adminListFeedback(c *gin.Context) {
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	feedbackList, err := h.Store.ListFeedback(FeedbackFilter{
+		Type:    c.Query("type"),
+		Status:  c.Query("status"),
+		AppName: c.Query("app"),
+	})
+	if err != nil {
+		requestLogger(c).Error("error scanning feedback for admin", "error", err)
+		respondStorageError(c, err, "Failed to fetch feedback")
+		return
+	}
+
+	statusCounts := make(map[string]int)
+	for _, feedback := range feedbackList {
+		statusCounts[feedback.Status]++
+	}
+
+	feedbackList, meta := paginate(feedbackList, page)
+	h.presignAttachmentURLs(feedbackList)
+	c.JSON(http.StatusOK, gin.H{
+		"feedback":      feedbackList,
+		"count":         len(feedbackList),
+		"page":          meta,
+		"status_counts": statusCounts,
+	})
+}
+
+func (h *PuzzleHub) adminUpdateFeedbackStatus(c *gin.Context) {
+	var request UpdateFeedbackStatusRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	if !validFeedbackStatuses[request.Status] {
+		respondError(c, http.StatusBadRequest, "Status must be one of new, reviewed, in-progress, completed")
+		return
+	}
+	if request.PublishToChangelog && request.Status != "completed" {
+		respondError(c, http.StatusBadRequest, "Only completed feedback can be published to the changelog")
+		return
+	}
+
+	feedback, status, err := h.updateFeedback(c.Param("id"), FeedbackUpdate{Status: &request.Status})
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	log.Printf("📝 Feedback %s status changed to %s", feedback.ID, feedback.Status)
+	language := h.recipientLanguage(feedback.UserID)
+	h.notify(c.Request.Context(), Notification{
+		UserID: feedback.UserID,
+		Kind:   notificationFeedbackStatus,
+		Title:  localize(language, "Your feedback is now %s", translate(language, feedback.Status)),
+		Body:   feedback.Title,
+		Data:   map[string]string{"feedback_id": feedback.ID, "status": feedback.Status},
+	}, feedback.UserEmail)
+
+	response := gin.H{
+		"message":  "Feedback status updated",
+		"feedback": feedback,
+	}
+	if request.PublishToChangelog {
+		entry, err := h.publishChangelogEntry(feedback, request.ChangelogSummary)
+		if err != nil {
+			requestLogger(c).Error("error publishing feedback to changelog", "error", err, "feedback_id", feedback.ID)
+			respondStorageError(c, err, "Status updated but failed to publish to changelog")
+			return
+		}
+		response["changelog_entry"] = entry
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *PuzzleHub) adminAssignFeedback(c *gin.Context) {
+	var request AssignFeedbackRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	assignedTo := strings.TrimSpace(request.AssignedTo)
+	feedback, status, err := h.updateFeedback(c.Param("id"), FeedbackUpdate{AssignedTo: &assignedTo})
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Feedback assignment updated",
+		"feedback": feedback,
+	})
+}
+
+func (h *PuzzleHub) adminAddFeedbackNote(c *gin.Context) {
+	admin := c.MustGet("user").(*User)
+
+	var request AddFeedbackNoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	feedback, status, err := h.updateFeedback(c.Param("id"), FeedbackUpdate{AddNote: &FeedbackNote{
+		Author:    admin.Email,
+		Note:      strings.TrimSpace(request.Note),
+		CreatedAt: time.Now(),
+	}})
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Note added",
+		"feedback": feedback,
+	})
+}
+
+// updateFeedback applies the update to an existing feedback item and
+// returns the updated item
+func (h *PuzzleHub) updateFeedback(feedbackID string, update FeedbackUpdate) (*Feedback, int, error) {
+	if feedbackID == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("Feedback ID is required")
+	}
+
+	feedback, err := h.Store.UpdateFeedback(feedbackID, update)
+	if err != nil {
+		if err == errStorageNotFound {
+			return nil, http.StatusNotFound, fmt.Errorf("Feedback not found")
+		}
+		log.Printf("Error updating feedback %s: %v", feedbackID, err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to update feedback")
+	}
+	return feedback, http.StatusOK, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Feedback attachments: clients upload screenshots straight to S3 with a
+// presigned URL, then reference the returned key when submitting feedback.
+
+const (
+	maxAttachmentSize        = 5 * 1024 * 1024 // 5MB per file
+	maxAttachmentsPerItem    = 5
+	attachmentUploadURLTTL   = 15 * time.Minute
+	attachmentDownloadURLTTL = time.Hour
+)
+
+var allowedAttachmentTypes = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+type FeedbackAttachment struct {
+	Key         string `json:"key" dynamodbav:"key"`
+	Filename    string `json:"filename" dynamodbav:"filename"`
+	ContentType string `json:"content_type" dynamodbav:"content_type"`
+	Size        int64  `json:"size" dynamodbav:"size"`
+	URL         string `json:"url,omitempty" dynamodbav:"-"` // Presigned download URL, filled in on read
+}
+
+type AttachmentUploadRequest struct {
+	Filename    string `json:"filename" binding:"required"`
+	ContentType string `json:"content_type" binding:"required"`
+	Size        int64  `json:"size" binding:"required"`
+}
+
+// AttachmentReference is what the client sends back on feedback submission
+type AttachmentReference struct {
+	Key      string `json:"key" binding:"required"`
+	Filename string `json:"filename"`
+}
+
+func (h *PuzzleHub) createAttachmentUploadURL(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+	if userObj.IsGuest {
+		respondError(c, http.StatusForbidden, "Sign in to attach files to feedback")
+		return
+	}
+
+	if h.AttachmentBucket == "" {
+		respondError(c, http.StatusServiceUnavailable, "Attachments are not enabled")
+		return
+	}
+
+	var request AttachmentUploadRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	ext, ok := allowedAttachmentTypes[request.ContentType]
+	if !ok {
+		respondError(c, http.StatusBadRequest, "Unsupported attachment type; use PNG, JPEG, GIF, WebP, PDF or plain text")
+		return
+	}
+	if request.Size <= 0 || request.Size > maxAttachmentSize {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Attachments must be between 1 byte and %d MB", maxAttachmentSize/(1024*1024)))
+		return
+	}
+
+	key := fmt.Sprintf("%s%d%s", attachmentKeyPrefix(userObj.ID), time.Now().UnixNano(), ext)
+
+	// Content type and length are part of the signature, so S3 rejects
+	// uploads that don't match what was validated here
+	req, _ := h.S3.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:        aws.String(h.AttachmentBucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(request.ContentType),
+		ContentLength: aws.Int64(request.Size),
+	})
+	uploadURL, err := req.Presign(attachmentUploadURLTTL)
+	if err != nil {
+		requestLogger(c).Error("error presigning attachment upload", "error", err)
+		respondStorageError(c, err, "Failed to create upload URL")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"upload_url": uploadURL,
+		"key":        key,
+		"method":     "PUT",
+		"headers": gin.H{
+			"Content-Type": request.ContentType,
+		},
+		"expires_in": int(attachmentUploadURLTTL.Seconds()),
+	})
+}
+
+// resolveAttachments checks that each referenced object was uploaded by the
+// user and still satisfies the size/type limits, and returns the metadata to
+// store on the feedback item.
+func (h *PuzzleHub) resolveAttachments(userID string, refs []AttachmentReference) ([]FeedbackAttachment, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	if h.AttachmentBucket == "" {
+		return nil, fmt.Errorf("Attachments are not enabled")
+	}
+	if len(refs) > maxAttachmentsPerItem {
+		return nil, fmt.Errorf("At most %d attachments are allowed", maxAttachmentsPerItem)
+	}
+
+	attachments := make([]FeedbackAttachment, 0, len(refs))
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref.Key, attachmentKeyPrefix(userID)) {
+			return nil, fmt.Errorf("Invalid attachment key: %s", ref.Key)
+		}
+
+		head, err := h.S3.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(h.AttachmentBucket),
+			Key:    aws.String(ref.Key),
+		})
+		if err != nil {
+			log.Printf("Error checking attachment %s: %v", ref.Key, err)
+			return nil, fmt.Errorf("Attachment not found: %s", ref.Key)
+		}
+
+		contentType := aws.StringValue(head.ContentType)
+		size := aws.Int64Value(head.ContentLength)
+		if _, ok := allowedAttachmentTypes[contentType]; !ok || size > maxAttachmentSize {
+			return nil, fmt.Errorf("Attachment %s exceeds the size or type limits", ref.Key)
+		}
+
+		filename := path.Base(strings.ReplaceAll(ref.Filename, "\\", "/"))
+		if filename == "" || filename == "." || filename == "/" {
+			filename = path.Base(ref.Key)
+		}
+
+		attachments = append(attachments, FeedbackAttachment{
+			Key:         ref.Key,
+			Filename:    filename,
+			ContentType: contentType,
+			Size:        size,
+		})
+	}
+
+	return attachments, nil
+}
+
+// presignAttachmentURLs fills in short-lived download URLs for list responses
+func (h *PuzzleHub) presignAttachmentURLs(feedbackList []Feedback) {
+	if h.AttachmentBucket == "" {
+		return
+	}
+
+	for i := range feedbackList {
+		for j := range feedbackList[i].Attachments {
+			attachment := &feedbackList[i].Attachments[j]
+			req, _ := h.S3.GetObjectRequest(&s3.GetObjectInput{
+				Bucket: aws.String(h.AttachmentBucket),
+				Key:    aws.String(attachment.Key),
+			})
+			url, err := req.Presign(attachmentDownloadURLTTL)
+			if err != nil {
+				log.Printf("Error presigning attachment %s: %v", attachment.Key, err)
+				continue
+			}
+			attachment.URL = url
+		}
+	}
+}
+
+func attachmentKeyPrefix(userID string) string {
+	return fmt.Sprintf("feedback-attachments/%s/", userID)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Feedback comment threads between admins and the original reporter
+
+const maxCommentLength = 5000
+
+type FeedbackComment struct {
+	FeedbackID string    `json:"feedback_id" dynamodbav:"feedback_id"`
+	ID         string    `json:"id" dynamodbav:"comment_id"` // cm_<unix nanos>, sorts chronologically
+	UserID     string    `json:"user_id" dynamodbav:"user_id"`
+	AuthorName string    `json:"author_name" dynamodbav:"author_name"`
+	AuthorRole string    `json:"author_role" dynamodbav:"author_role"` // "admin" or "reporter"
+	Body       string    `json:"body" dynamodbav:"body"`
+	CreatedAt  time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+type CreateFeedbackCommentRequest struct {
+	Body string `json:"body" binding:"required"`
+}
+
+// getFeedbackDetail returns a single feedback item with its comment thread.
+// Only the reporter and admins can see it.
+func (h *PuzzleHub) getFeedbackDetail(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	feedback, status, err := h.getVisibleFeedback(userObj, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	comments, err := h.getFeedbackComments(feedback.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching comments", "error", err, "feedback_id", feedback.ID)
+		respondStorageError(c, err, "Failed to fetch comments")
+		return
+	}
+
+	if !h.isAdmin(userObj) {
+		feedback.AssignedTo = ""
+		feedback.InternalNotes = nil
+	}
+	feedbackList := []Feedback{*feedback}
+	h.presignAttachmentURLs(feedbackList)
+
+	c.JSON(http.StatusOK, gin.H{
+		"feedback": feedbackList[0],
+		"comments": comments,
+	})
+}
+
+func (h *PuzzleHub) addFeedbackComment(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+	if userObj.IsGuest {
+		respondError(c, http.StatusForbidden, "Sign in to comment on feedback")
+		return
+	}
+
+	var request CreateFeedbackCommentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	body := strings.TrimSpace(request.Body)
+	if body == "" || len(body) > maxCommentLength {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Comment must be between 1 and %d characters", maxCommentLength))
+		return
+	}
+
+	feedback, status, err := h.getVisibleFeedback(userObj, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	role := "reporter"
+	if h.isAdmin(userObj) {
+		role = "admin"
+	}
+
+	now := time.Now()
+	comment := FeedbackComment{
+		FeedbackID: feedback.ID,
+		ID:         fmt.Sprintf("cm_%d", now.UnixNano()),
+		UserID:     userObj.ID,
+		AuthorName: userObj.Name,
+		AuthorRole: role,
+		Body:       body,
+		CreatedAt:  now,
+	}
+
+	item, err := dynamodbattribute.MarshalMap(comment)
+	if err != nil {
+		requestLogger(c).Error("error marshaling comment", "error", err)
+		respondStorageError(c, err, "Failed to add comment")
+		return
+	}
+
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-feedback-comments")),
+		Item:      item,
+	})
+	if err != nil {
+		requestLogger(c).Error("error saving comment", "error", err, "feedback_id", feedback.ID)
+		respondStorageError(c, err, "Failed to add comment")
+		return
+	}
+
+	log.Printf("💬 Comment added to feedback %s by %s (%s)", feedback.ID, userObj.ID, role)
+	if role == "admin" && feedback.UserID != userObj.ID {
+		h.notify(c.Request.Context(), Notification{
+			UserID: feedback.UserID,
+			Kind:   notificationFeedbackComment,
+			Title:  translate(h.recipientLanguage(feedback.UserID), "The Puzzle Hub team replied to your feedback"),
+			Body:   body,
+			Data:   map[string]string{"feedback_id": feedback.ID, "comment_id": comment.ID},
+		}, feedback.UserEmail)
+	}
+	c.JSON(http.StatusCreated, comment)
+}
+
+// getVisibleFeedback loads a feedback item the user is allowed to discuss.
+// Items belonging to other users are reported as not found.
+func (h *PuzzleHub) getVisibleFeedback(user *User, feedbackID string) (*Feedback, int, error) {
+	feedback, err := h.getFeedback(feedbackID)
+	if err != nil {
+		log.Printf("Error fetching feedback %s: %v", feedbackID, err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to fetch feedback")
+	}
+	if feedback == nil || (feedback.UserID != user.ID && !h.isAdmin(user)) {
+		return nil, http.StatusNotFound, fmt.Errorf("Feedback not found")
+	}
+	return feedback, http.StatusOK, nil
+}
+
+// getFeedbackComments returns the thread oldest first
+func (h *PuzzleHub) getFeedbackComments(feedbackID string) ([]FeedbackComment, error) {
+	return queryAll[FeedbackComment](h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-feedback-comments")),
+		KeyConditionExpression: aws.String("feedback_id = :feedback_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":feedback_id": {S: aws.String(feedbackID)},
+		},
+		ScanIndexForward: aws.Bool(true),
+	})
+}
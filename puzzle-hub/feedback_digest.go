@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/gin-gonic/gin"
+)
+
+// Feedback export (CSV) and the weekly admin digest email
+
+var feedbackCSVHeader = []string{
+	"id", "created_at", "type", "app_name", "status", "rating", "title", "description",
+	"ai_app_idea", "use_case", "user_id", "user_email", "user_name", "assigned_to",
+	"vote_count", "attachments",
+}
+
+// adminExportFeedback streams all feedback created in [from, to] as CSV.
+// Dates are YYYY-MM-DD in UTC; from defaults to 30 days ago, to to today.
+func (h *PuzzleHub) adminExportFeedback(c *gin.Context) {
+	now := time.Now().UTC()
+	from, err := parseExportDate(c.Query("from"), now.AddDate(0, 0, -30))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "from must be a date in YYYY-MM-DD format")
+		return
+	}
+	to, err := parseExportDate(c.Query("to"), now)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "to must be a date in YYYY-MM-DD format")
+		return
+	}
+	if to.Before(from) {
+		respondError(c, http.StatusBadRequest, "to must not be before from")
+		return
+	}
+
+	feedbackList, err := h.getFeedbackBetween(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		requestLogger(c).Error("error scanning feedback for export", "error", err)
+		respondStorageError(c, err, "Failed to export feedback")
+		return
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(feedbackCSVHeader)
+	for _, feedback := range feedbackList {
+		writer.Write([]string{
+			feedback.ID,
+			feedback.CreatedAt.UTC().Format(time.RFC3339),
+			string(feedback.Type),
+			feedback.AppName,
+			feedback.Status,
+			strconv.Itoa(feedback.Rating),
+			feedback.Title,
+			feedback.Description,
+			feedback.AIAppIdea,
+			feedback.UseCase,
+			feedback.UserID,
+			feedback.UserEmail,
+			feedback.UserName,
+			feedback.AssignedTo,
+			strconv.Itoa(feedback.VoteCount),
+			strconv.Itoa(len(feedback.Attachments)),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		requestLogger(c).Error("error writing feedback CSV", "error", err)
+		respondStorageError(c, err, "Failed to export feedback")
+		return
+	}
+
+	filename := fmt.Sprintf("feedback_%s_%s.csv", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+func parseExportDate(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Date(fallback.Year(), fallback.Month(), fallback.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// getFeedbackBetween returns feedback created in [start, end), newest first.
+// created_at strings may carry different zone offsets, so the range is
+// applied after reading rather than in the store's filter.
+func (h *PuzzleHub) getFeedbackBetween(start, end time.Time) ([]Feedback, error) {
+	all, err := h.Store.ListFeedback(FeedbackFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	feedbackList := make([]Feedback, 0, len(all))
+	for _, feedback := range all {
+		if !feedback.CreatedAt.Before(start) && feedback.CreatedAt.Before(end) {
+			feedbackList = append(feedbackList, feedback)
+		}
+	}
+	return feedbackList, nil
+}
+
+// sendWeeklyFeedbackDigest sends last week's digest if it hasn't been sent.
+// The job-runs table makes this safe across restarts and multiple
+// instances. It is scheduled in main.
+func (h *PuzzleHub) sendWeeklyFeedbackDigest(ctx context.Context) error {
+	// Digest covers the last complete ISO week (Monday to Monday, UTC)
+	weekStart, period := lastCompleteWeek(time.Now())
+	weekEnd := weekStart.AddDate(0, 0, 7)
+
+	claimed, err := h.claimJobRun("feedback-digest", period)
+	if err != nil {
+		return fmt.Errorf("failed to claim %s: %v", period, err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	feedbackList, err := h.getFeedbackBetween(weekStart, weekEnd)
+	if err == nil {
+		err = h.emailFeedbackDigest(period, weekStart, weekEnd, feedbackList)
+	}
+	if err != nil {
+		// Release the claim so the next run retries
+		h.releaseJobRun("feedback-digest", period)
+		return fmt.Errorf("digest for %s failed: %v", period, err)
+	}
+
+	log.Printf("📬 Feedback digest for %s sent (%d items)", period, len(feedbackList))
+	return nil
+}
+
+func (h *PuzzleHub) emailFeedbackDigest(period string, start, end time.Time, feedbackList []Feedback) error {
+	recipients := make([]*string, 0, len(h.AuthConfig.AdminEmails))
+	for email := range h.AuthConfig.AdminEmails {
+		recipients = append(recipients, aws.String(email))
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("no admin recipients configured")
+	}
+
+	subject := fmt.Sprintf("Puzzle Hub feedback digest %s: %d new", period, len(feedbackList))
+	body := buildFeedbackDigest(start, end, feedbackList)
+
+	_, err := h.SES.SendEmail(&ses.SendEmailInput{
+		Source:      aws.String(h.DigestFromEmail),
+		Destination: &ses.Destination{ToAddresses: recipients},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(subject), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(body), Charset: aws.String("UTF-8")},
+			},
+		},
+	})
+	return err
+}
+
+// buildFeedbackDigest renders a plain-text summary grouped by app, then type
+func buildFeedbackDigest(start, end time.Time, feedbackList []Feedback) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "New feedback from %s to %s: %d items\n", start.Format("Jan 2"), end.AddDate(0, 0, -1).Format("Jan 2, 2006"), len(feedbackList))
+	if len(feedbackList) == 0 {
+		b.WriteString("\nNo new feedback this week.\n")
+		return b.String()
+	}
+
+	groups := make(map[string]map[FeedbackType][]Feedback)
+	for _, feedback := range feedbackList {
+		app := feedback.AppName
+		if app == "" {
+			app = "General"
+		}
+		if groups[app] == nil {
+			groups[app] = make(map[FeedbackType][]Feedback)
+		}
+		groups[app][feedback.Type] = append(groups[app][feedback.Type], feedback)
+	}
+
+	apps := make([]string, 0, len(groups))
+	for app := range groups {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+
+	for _, app := range apps {
+		fmt.Fprintf(&b, "\n== %s ==\n", app)
+
+		types := make([]string, 0, len(groups[app]))
+		for feedbackType := range groups[app] {
+			types = append(types, string(feedbackType))
+		}
+		sort.Strings(types)
+
+		for _, feedbackType := range types {
+			items := groups[app][FeedbackType(feedbackType)]
+			fmt.Fprintf(&b, "%s (%d)\n", feedbackType, len(items))
+			for _, feedback := range items {
+				line := "  - " + feedback.Title
+				if feedback.Rating > 0 {
+					line += fmt.Sprintf(" [%d/5]", feedback.Rating)
+				}
+				fmt.Fprintf(&b, "%s (%s)\n", line, feedback.ID)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// claimJobRun records that a scheduled job ran for a period. It returns false
+// if the period was already claimed.
+func (h *PuzzleHub) claimJobRun(job, period string) (bool, error) {
+	return h.Store.ClaimJobRun(job, period, 0)
+}
+
+func (h *PuzzleHub) releaseJobRun(job, period string) {
+	if err := h.Store.ReleaseJobRun(job, period); err != nil {
+		log.Printf("Error releasing job run %s/%s: %v", job, period, err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Quick app ratings: one-tap 1-5 stars per app, one current rating per user
+
+type AppRating struct {
+	AppName   string    `json:"app_name" dynamodbav:"app_name"`
+	UserID    string    `json:"user_id" dynamodbav:"user_id"`
+	Rating    int       `json:"rating" dynamodbav:"rating"`
+	UpdatedAt time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+type AppRatingRequest struct {
+	AppName string `json:"app_name" binding:"required"`
+	Rating  int    `json:"rating" binding:"required"`
+}
+
+type AppRatingSummary struct {
+	AppName       string      `json:"app_name"`
+	Count         int         `json:"count"`
+	Average       float64     `json:"average"`
+	Distribution  map[int]int `json:"distribution"` // stars -> number of ratings
+	LastRatedAt   time.Time   `json:"last_rated_at"`
+	CurrentRating int         `json:"current_rating,omitempty"` // The requesting user's rating
+}
+
+// submitAppRating records the user's rating for an app, replacing any earlier one
+func (h *PuzzleHub) submitAppRating(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+	if userObj.IsGuest {
+		respondError(c, http.StatusForbidden, "Sign in to rate the app")
+		return
+	}
+
+	var request AppRatingRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	appName := strings.TrimSpace(request.AppName)
+	if appName == "" {
+		respondError(c, http.StatusBadRequest, "App name is required")
+		return
+	}
+	if request.Rating < 1 || request.Rating > 5 {
+		respondError(c, http.StatusBadRequest, "Rating must be between 1 and 5")
+		return
+	}
+
+	rating := AppRating{
+		AppName:   appName,
+		UserID:    userObj.ID,
+		Rating:    request.Rating,
+		UpdatedAt: time.Now(),
+	}
+
+	item, err := dynamodbattribute.MarshalMap(rating)
+	if err != nil {
+		requestLogger(c).Error("error marshaling app rating", "error", err)
+		respondStorageError(c, err, "Failed to save rating")
+		return
+	}
+
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-app-ratings")),
+		Item:      item,
+	})
+	if err != nil {
+		requestLogger(c).Error("error saving app rating", "error", err)
+		respondStorageError(c, err, "Failed to save rating")
+		return
+	}
+
+	log.Printf("⭐ %s rated %s %d/5", userObj.ID, appName, request.Rating)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"rating":  rating,
+	})
+}
+
+// getAppRatingsSummary returns per-app rating averages, optionally for a single app
+func (h *PuzzleHub) getAppRatingsSummary(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-app-ratings")),
+	}
+	if appName := c.Query("app"); appName != "" {
+		input.FilterExpression = aws.String("app_name = :app_name")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":app_name": {S: aws.String(appName)},
+		}
+	}
+
+	summaries := make(map[string]*AppRatingSummary)
+	totals := make(map[string]int)
+	var unmarshalErr error
+	err := h.DynamoDB.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var ratings []AppRating
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &ratings); unmarshalErr != nil {
+			return false
+		}
+		for _, rating := range ratings {
+			summary, ok := summaries[rating.AppName]
+			if !ok {
+				summary = &AppRatingSummary{
+					AppName:      rating.AppName,
+					Distribution: map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0},
+				}
+				summaries[rating.AppName] = summary
+			}
+			summary.Count++
+			summary.Distribution[rating.Rating]++
+			totals[rating.AppName] += rating.Rating
+			if rating.UpdatedAt.After(summary.LastRatedAt) {
+				summary.LastRatedAt = rating.UpdatedAt
+			}
+			if rating.UserID == userObj.ID {
+				summary.CurrentRating = rating.Rating
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		requestLogger(c).Error("error scanning app ratings", "error", err)
+		respondStorageError(c, err, "Failed to fetch ratings")
+		return
+	}
+
+	result := make([]AppRatingSummary, 0, len(summaries))
+	for appName, summary := range summaries {
+		summary.Average = math.Round(float64(totals[appName])/float64(summary.Count)*100) / 100
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].AppName < result[j].AppName
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"apps":  result,
+		"count": len(result),
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Public feature-request roadmap: admins publish feature requests, users vote on them
+
+// RoadmapItem is the public view of a feature request; submitter details
+// and admin triage fields are left out.
+type RoadmapItem struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	UseCase     string    `json:"use_case,omitempty"`
+	Status      string    `json:"status"`
+	VoteCount   int       `json:"vote_count"`
+	HasVoted    bool      `json:"has_voted"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type FeedbackVote struct {
+	FeedbackID string    `json:"feedback_id" dynamodbav:"feedback_id"`
+	UserID     string    `json:"user_id" dynamodbav:"user_id"`
+	CreatedAt  time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+type PublishFeatureRequest struct {
+	Public *bool `json:"public" binding:"required"`
+}
+
+// getFeatureRoadmap lists published feature requests sorted by votes
+// (default) or by creation time with ?sort=newest.
+func (h *PuzzleHub) getFeatureRoadmap(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	sortBy := c.DefaultQuery("sort", "votes")
+	if sortBy != "votes" && sortBy != "newest" {
+		respondError(c, http.StatusBadRequest, "sort must be votes or newest")
+		return
+	}
+
+	requests, err := h.Store.ListFeedback(FeedbackFilter{
+		Type:       string(FeedbackTypeFeatureRequest),
+		Status:     c.Query("status"),
+		PublicOnly: true,
+	})
+	if err != nil {
+		requestLogger(c).Error("error scanning feature roadmap", "error", err)
+		respondStorageError(c, err, "Failed to fetch roadmap")
+		return
+	}
+
+	ids := make([]string, len(requests))
+	for i, request := range requests {
+		ids[i] = request.ID
+	}
+	voted, err := h.getUserFeedbackVotes(userObj.ID, ids)
+	if err != nil {
+		// Vote markers are cosmetic; still serve the roadmap
+		requestLogger(c).Error("error loading votes", "error", err)
+	}
+
+	items := make([]RoadmapItem, 0, len(requests))
+	for _, request := range requests {
+		items = append(items, RoadmapItem{
+			ID:          request.ID,
+			Title:       request.Title,
+			Description: request.Description,
+			UseCase:     request.UseCase,
+			Status:      request.Status,
+			VoteCount:   request.VoteCount,
+			HasVoted:    voted[request.ID],
+			CreatedAt:   request.CreatedAt,
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if sortBy == "votes" && items[i].VoteCount != items[j].VoteCount {
+			return items[i].VoteCount > items[j].VoteCount
+		}
+		return items[i].CreatedAt.After(items[j].CreatedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": items,
+		"count": len(items),
+		"sort":  sortBy,
+	})
+}
+
+func (h *PuzzleHub) voteFeatureRequest(c *gin.Context) {
+	h.changeFeatureVote(c, true)
+}
+
+func (h *PuzzleHub) unvoteFeatureRequest(c *gin.Context) {
+	h.changeFeatureVote(c, false)
+}
+
+// changeFeatureVote records or removes the user's vote and adjusts the
+// request's vote_count in a single transaction, so a user can only ever
+// count once per request.
+func (h *PuzzleHub) changeFeatureVote(c *gin.Context, vote bool) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+	if userObj.IsGuest {
+		respondError(c, http.StatusForbidden, "Sign in to vote on feature requests")
+		return
+	}
+	feedbackID := c.Param("id")
+
+	feedback, err := h.getFeedback(feedbackID)
+	if err != nil {
+		requestLogger(c).Error("error fetching feedback", "error", err, "feedback_id", feedbackID)
+		respondStorageError(c, err, "Failed to fetch feature request")
+		return
+	}
+	if feedback == nil || feedback.Type != FeedbackTypeFeatureRequest || !feedback.Public {
+		respondError(c, http.StatusNotFound, "Feature request not found")
+		return
+	}
+
+	if err := h.Store.ChangeFeedbackVote(feedbackID, userObj.ID, vote); err != nil {
+		switch {
+		case err == errStorageConflict && vote:
+			respondError(c, http.StatusConflict, "You have already voted for this request")
+		case err == errStorageConflict:
+			respondError(c, http.StatusNotFound, "Vote not found")
+		case err == errStorageNotFound:
+			respondError(c, http.StatusNotFound, "Feature request not found")
+		default:
+			requestLogger(c).Error("error updating vote", "error", err, "feedback_id", feedbackID)
+			respondStorageError(c, err, "Failed to update vote")
+		}
+		return
+	}
+
+	voteCount := feedback.VoteCount + 1
+	if !vote {
+		voteCount = feedback.VoteCount - 1
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":         feedbackID,
+		"has_voted":  vote,
+		"vote_count": voteCount,
+	})
+}
+
+// adminPublishFeatureRequest shows or hides a feature request on the public roadmap
+func (h *PuzzleHub) adminPublishFeatureRequest(c *gin.Context) {
+	var request PublishFeatureRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	feedbackID := c.Param("id")
+	existing, err := h.getFeedback(feedbackID)
+	if err != nil {
+		requestLogger(c).Error("error fetching feedback", "error", err, "feedback_id", feedbackID)
+		respondStorageError(c, err, "Failed to fetch feedback")
+		return
+	}
+	if existing == nil {
+		respondError(c, http.StatusNotFound, "Feedback not found")
+		return
+	}
+	if existing.Type != FeedbackTypeFeatureRequest {
+		respondError(c, http.StatusBadRequest, "Only feature requests can be published to the roadmap")
+		return
+	}
+
+	feedback, status, err := h.updateFeedback(feedbackID, FeedbackUpdate{Public: request.Public})
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	log.Printf("🗺️  Feature request %s public=%t", feedback.ID, feedback.Public)
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Roadmap visibility updated",
+		"feedback": feedback,
+	})
+}
+
+// getFeedback returns nil without error when the item does not exist
+func (h *PuzzleHub) getFeedback(feedbackID string) (*Feedback, error) {
+	return h.Store.GetFeedback(feedbackID)
+}
+
+// getUserFeedbackVotes reports which of the given feedback IDs the user has voted for
+func (h *PuzzleHub) getUserFeedbackVotes(userID string, feedbackIDs []string) (map[string]bool, error) {
+	return h.Store.GetFeedbackVotes(userID, feedbackIDs)
+}
@@ -0,0 +1,792 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Flashcards
+//
+// Each player has decks of cards (puzzle-hub-flashcard-decks) and the
+// cards themselves (puzzle-hub-flashcards), scheduled with SM-2: every
+// review is graded 0-5, grades below 3 start the card over from a one day
+// interval, and the rest lengthen the interval by the card's ease, which
+// the grade nudges up or down.
+//
+// Players make their own decks, and other modules push cards into a deck
+// of their own (flashcardSources): missed math facts when a drill is
+// submitted, vocabulary tips from writing analysis, and misspelled words,
+// which the spelling client sends since spelling is marked there.
+// Remediation packets (remediation.go) add missed words and quiz questions
+// due the next day. A pushed card has a stable ID per source and key
+// ("spelling:necessary"), so pushing it again only brings it due. All decks
+// feed one daily review queue; finished reviews are recorded as
+// "flashcards" progress.
+
+const (
+	defaultFlashcardEase  = 2.5
+	minFlashcardEase      = 1.3
+	maxFlashcardGrade     = 5
+	passingFlashcardGrade = 3
+	maxFlashcardText      = 500
+	maxFlashcardsPerDeck  = 500
+	maxFlashcardPush      = 50
+	defaultReviewQueue    = 20
+	maxReviewQueue        = 100
+	maxNewCardsPerQueue   = 10
+)
+
+type flashcardSource struct {
+	DeckName    string
+	Description string
+}
+
+// flashcardSources are the modules that push cards, each into a deck with
+// the source as its ID
+var flashcardSources = map[string]flashcardSource{
+	"spelling":   {DeckName: "Spelling words", Description: "Words you misspelled in Spelling Bee"},
+	"writing":    {DeckName: "Vocabulary", Description: "Stronger words suggested by the Writing Coach"},
+	"math_facts": {DeckName: "Math facts", Description: "Facts you missed in the tables trainer"},
+	"quiz":       {DeckName: "Quiz review", Description: "Questions you missed in quizzes"},
+}
+
+type FlashcardDeck struct {
+	UserID      string    `json:"-" dynamodbav:"user_id"`
+	DeckID      string    `json:"id" dynamodbav:"deck_id"`
+	Name        string    `json:"name" dynamodbav:"name"`
+	Description string    `json:"description,omitempty" dynamodbav:"description,omitempty"`
+	Source      string    `json:"source" dynamodbav:"source"` // custom or one of flashcardSources
+	CreatedAt   time.Time `json:"created_at" dynamodbav:"created_at"`
+	Cards       int       `json:"cards" dynamodbav:"-"`
+	Due         int       `json:"due" dynamodbav:"-"`
+}
+
+type Flashcard struct {
+	UserID         string     `json:"-" dynamodbav:"user_id"`
+	CardID         string     `json:"id" dynamodbav:"card_id"`
+	DeckID         string     `json:"deck_id" dynamodbav:"deck_id"`
+	Front          string     `json:"front" dynamodbav:"front"`
+	Back           string     `json:"back" dynamodbav:"back"`
+	Hint           string     `json:"hint,omitempty" dynamodbav:"hint,omitempty"`
+	Source         string     `json:"source" dynamodbav:"source"`
+	Ease           float64    `json:"ease" dynamodbav:"ease"`
+	IntervalDays   int        `json:"interval_days" dynamodbav:"interval_days"`
+	Repetitions    int        `json:"repetitions" dynamodbav:"repetitions"` // Passing reviews in a row
+	Reviews        int        `json:"reviews" dynamodbav:"reviews"`
+	Lapses         int        `json:"lapses" dynamodbav:"lapses"`
+	DueAt          time.Time  `json:"due_at" dynamodbav:"due_at"`
+	LastReviewedAt *time.Time `json:"last_reviewed_at,omitempty" dynamodbav:"last_reviewed_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at" dynamodbav:"created_at"`
+}
+
+type CreateFlashcardDeckRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// FlashcardDraft is a card to add; Key identifies pushed cards within
+// their source and is ignored for custom decks
+type FlashcardDraft struct {
+	Key   string `json:"key"`
+	Front string `json:"front" binding:"required"`
+	Back  string `json:"back" binding:"required"`
+	Hint  string `json:"hint"`
+}
+
+type PushFlashcardsRequest struct {
+	Source string           `json:"source" binding:"required"`
+	Cards  []FlashcardDraft `json:"cards" binding:"required,dive"`
+}
+
+type FlashcardGrade struct {
+	CardID string `json:"card_id" binding:"required"`
+	Grade  *int   `json:"grade" binding:"required"` // 0-5
+}
+
+type SubmitFlashcardReviewRequest struct {
+	Grades          []FlashcardGrade `json:"grades" binding:"required,dive"`
+	DurationSeconds int              `json:"duration_seconds"`
+}
+
+// schedule applies one SM-2 review to the card
+func (card *Flashcard) schedule(grade int, now time.Time) {
+	if grade < passingFlashcardGrade {
+		if card.Repetitions > 0 {
+			card.Lapses++
+		}
+		card.Repetitions = 0
+		card.IntervalDays = 1
+	} else {
+		switch card.Repetitions {
+		case 0:
+			card.IntervalDays = 1
+		case 1:
+			card.IntervalDays = 6
+		default:
+			card.IntervalDays = int(math.Round(float64(card.IntervalDays) * card.Ease))
+		}
+		card.Repetitions++
+	}
+	miss := float64(maxFlashcardGrade - grade)
+	card.Ease = math.Max(minFlashcardEase, card.Ease+0.1-miss*(0.08+miss*0.02))
+	card.Reviews++
+	card.DueAt = now.AddDate(0, 0, card.IntervalDays)
+	card.LastReviewedAt = &now
+}
+
+// normalizeFlashcardDraft trims a draft, returning a problem if it's
+// unusable
+func normalizeFlashcardDraft(draft *FlashcardDraft) string {
+	draft.Key = strings.ToLower(strings.TrimSpace(draft.Key))
+	draft.Front = strings.TrimSpace(draft.Front)
+	draft.Back = strings.TrimSpace(draft.Back)
+	draft.Hint = strings.TrimSpace(draft.Hint)
+	if draft.Front == "" || draft.Back == "" {
+		return "Cards need a front and a back"
+	}
+	if len(draft.Front) > maxFlashcardText || len(draft.Back) > maxFlashcardText || len(draft.Hint) > maxFlashcardText {
+		return fmt.Sprintf("Card text must be at most %d characters", maxFlashcardText)
+	}
+	return ""
+}
+
+// listFlashcardDecks lists the player's decks with their card and due
+// counts
+func (h *PuzzleHub) listFlashcardDecks(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	decks, err := h.getFlashcardDecks(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcard decks", "error", err)
+		respondStorageError(c, err, "Failed to fetch decks")
+		return
+	}
+	cards, err := h.getFlashcards(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcards", "error", err)
+		respondStorageError(c, err, "Failed to fetch decks")
+		return
+	}
+
+	now := time.Now()
+	byID := make(map[string]*FlashcardDeck, len(decks))
+	for i := range decks {
+		byID[decks[i].DeckID] = &decks[i]
+	}
+	due := 0
+	for _, card := range cards {
+		deck, ok := byID[card.DeckID]
+		if !ok {
+			continue
+		}
+		deck.Cards++
+		if !card.DueAt.After(now) {
+			deck.Due++
+			due++
+		}
+	}
+	sort.Slice(decks, func(i, j int) bool { return decks[i].CreatedAt.Before(decks[j].CreatedAt) })
+
+	c.JSON(http.StatusOK, gin.H{"decks": decks, "due": due})
+}
+
+// createFlashcardDeck starts an empty custom deck
+func (h *PuzzleHub) createFlashcardDeck(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var request CreateFlashcardDeckRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > 100 {
+		respondError(c, http.StatusBadRequest, "Deck name must be between 1 and 100 characters")
+		return
+	}
+	description := strings.TrimSpace(request.Description)
+	if len(description) > maxFlashcardText {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Description must be at most %d characters", maxFlashcardText))
+		return
+	}
+
+	deck := FlashcardDeck{
+		UserID:      userObj.ID,
+		DeckID:      fmt.Sprintf("deck_%d", time.Now().UnixNano()),
+		Name:        name,
+		Description: description,
+		Source:      "custom",
+		CreatedAt:   time.Now(),
+	}
+	if err := h.putFlashcardDeck(c.Request.Context(), deck, false); err != nil {
+		requestLogger(c).Error("error saving flashcard deck", "error", err)
+		respondStorageError(c, err, "Failed to create deck")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"deck": deck})
+}
+
+// deleteFlashcardDeck deletes a deck with all its cards. A module's deck
+// comes back the next time the module pushes a card.
+func (h *PuzzleHub) deleteFlashcardDeck(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	deckID := c.Param("id")
+	ctx := c.Request.Context()
+
+	cards, err := h.getFlashcards(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcards", "error", err)
+		respondStorageError(c, err, "Failed to delete deck")
+		return
+	}
+	var requests []*dynamodb.WriteRequest
+	for _, card := range cards {
+		if card.DeckID != deckID {
+			continue
+		}
+		requests = append(requests, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"user_id": {S: aws.String(userObj.ID)},
+				"card_id": {S: aws.String(card.CardID)},
+			},
+		}})
+	}
+	if err := batchWriteItems(h.DynamoDB, tableName("puzzle-hub-flashcards"), requests); err != nil {
+		requestLogger(c).Error("error deleting cards of deck", "error", err, "deck_id", deckID)
+		respondStorageError(c, err, "Failed to delete deck")
+		return
+	}
+
+	_, err = h.DynamoDB.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-flashcard-decks")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userObj.ID)},
+			"deck_id": {S: aws.String(deckID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(deck_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Deck not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error deleting flashcard deck", "error", err, "deck_id", deckID)
+		respondStorageError(c, err, "Failed to delete deck")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deck deleted", "cards_deleted": len(requests)})
+}
+
+// listFlashcards lists a deck's cards with their schedules
+func (h *PuzzleHub) listFlashcards(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	deckID := c.Param("id")
+	ctx := c.Request.Context()
+
+	deck, err := h.getFlashcardDeck(ctx, userObj.ID, deckID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcard deck", "error", err, "deck_id", deckID)
+		respondStorageError(c, err, "Failed to fetch cards")
+		return
+	}
+	if deck == nil {
+		respondError(c, http.StatusNotFound, "Deck not found")
+		return
+	}
+	cards, err := h.getFlashcards(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcards", "error", err)
+		respondStorageError(c, err, "Failed to fetch cards")
+		return
+	}
+
+	inDeck := []Flashcard{}
+	for _, card := range cards {
+		if card.DeckID == deckID {
+			inDeck = append(inDeck, card)
+		}
+	}
+	sort.Slice(inDeck, func(i, j int) bool { return inDeck[i].DueAt.Before(inDeck[j].DueAt) })
+	c.JSON(http.StatusOK, gin.H{"deck": deck, "cards": inDeck})
+}
+
+// addFlashcard adds a card to one of the player's decks, due now
+func (h *PuzzleHub) addFlashcard(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	deckID := c.Param("id")
+	ctx := c.Request.Context()
+
+	var draft FlashcardDraft
+	if err := c.ShouldBindJSON(&draft); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if problem := normalizeFlashcardDraft(&draft); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+
+	deck, err := h.getFlashcardDeck(ctx, userObj.ID, deckID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcard deck", "error", err, "deck_id", deckID)
+		respondStorageError(c, err, "Failed to add card")
+		return
+	}
+	if deck == nil {
+		respondError(c, http.StatusNotFound, "Deck not found")
+		return
+	}
+	cards, err := h.getFlashcards(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcards", "error", err)
+		respondStorageError(c, err, "Failed to add card")
+		return
+	}
+	inDeck := 0
+	for _, card := range cards {
+		if card.DeckID == deckID {
+			inDeck++
+		}
+	}
+	if inDeck >= maxFlashcardsPerDeck {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("A deck can hold at most %d cards", maxFlashcardsPerDeck))
+		return
+	}
+
+	now := time.Now()
+	card := newFlashcard(userObj.ID, deck.DeckID, deck.Source, fmt.Sprintf("card_%d", now.UnixNano()), draft, now)
+	item, err := dynamodbattribute.MarshalMap(card)
+	if err == nil {
+		_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-flashcards")),
+			Item:      item,
+		})
+	}
+	if err != nil {
+		requestLogger(c).Error("error saving flashcard", "error", err)
+		respondStorageError(c, err, "Failed to add card")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"card": card})
+}
+
+// deleteFlashcard removes one card
+func (h *PuzzleHub) deleteFlashcard(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	cardID := c.Param("id")
+
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-flashcards")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userObj.ID)},
+			"card_id": {S: aws.String(cardID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(card_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Card not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error deleting flashcard", "error", err, "card_id", cardID)
+		respondStorageError(c, err, "Failed to delete card")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Card deleted"})
+}
+
+// pushFlashcardsHandler lets client-side modules (spelling) push cards
+// into their deck
+func (h *PuzzleHub) pushFlashcardsHandler(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var request PushFlashcardsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if _, ok := flashcardSources[request.Source]; !ok {
+		respondError(c, http.StatusBadRequest, "Source must be spelling, writing, math_facts or quiz")
+		return
+	}
+	if len(request.Cards) == 0 || len(request.Cards) > maxFlashcardPush {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Send between 1 and %d cards", maxFlashcardPush))
+		return
+	}
+	for i := range request.Cards {
+		if problem := normalizeFlashcardDraft(&request.Cards[i]); problem != "" {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Card %d: %s", i+1, problem))
+			return
+		}
+		if request.Cards[i].Key == "" {
+			request.Cards[i].Key = strings.ToLower(request.Cards[i].Back)
+		}
+	}
+
+	added, refreshed, err := h.addSourceFlashcards(c.Request.Context(), userObj.ID, request.Source, request.Cards, time.Now())
+	if err != nil {
+		requestLogger(c).Error("error pushing flashcards", "error", err, "source", request.Source)
+		respondStorageError(c, err, "Failed to save cards")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"added": added, "due_again": refreshed})
+}
+
+// pushFlashcards adds cards to a module's deck in the background, for
+// modules marking answers on the server. Drafts need a Key.
+func (h *PuzzleHub) pushFlashcards(userID, source string, drafts []FlashcardDraft) {
+	if userID == "" || len(drafts) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, _, err := h.addSourceFlashcards(ctx, userID, source, drafts, time.Now()); err != nil {
+			log.Printf("Error pushing %s flashcards for %s: %v", source, userID, err)
+		}
+	}()
+}
+
+// addSourceFlashcards makes sure the source's deck exists and adds the
+// drafts to it, due at due. Cards already in the deck that aren't due by
+// then are brought forward instead.
+func (h *PuzzleHub) addSourceFlashcards(ctx context.Context, userID, source string, drafts []FlashcardDraft, due time.Time) (added, refreshed int, err error) {
+	info := flashcardSources[source]
+	deck := FlashcardDeck{
+		UserID:      userID,
+		DeckID:      source,
+		Name:        info.DeckName,
+		Description: info.Description,
+		Source:      source,
+		CreatedAt:   time.Now(),
+	}
+	if err := h.putFlashcardDeck(ctx, deck, true); err != nil && !isConditionalCheckFailed(err) {
+		return 0, 0, err
+	}
+
+	cards, err := h.getFlashcards(ctx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	existing := make(map[string]Flashcard, len(cards))
+	inDeck := 0
+	for _, card := range cards {
+		existing[card.CardID] = card
+		if card.DeckID == source {
+			inDeck++
+		}
+	}
+
+	now := time.Now()
+	changed := map[string]Flashcard{}
+	for _, draft := range drafts {
+		cardID := source + ":" + draft.Key
+		if card, ok := existing[cardID]; ok {
+			if card.DueAt.After(due) {
+				card.DueAt = due
+				changed[cardID] = card
+				refreshed++
+			}
+			continue
+		}
+		if _, ok := changed[cardID]; ok || inDeck >= maxFlashcardsPerDeck {
+			continue
+		}
+		card := newFlashcard(userID, source, source, cardID, draft, now)
+		card.DueAt = due
+		changed[cardID] = card
+		inDeck++
+		added++
+	}
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(changed))
+	for _, card := range changed {
+		item, err := dynamodbattribute.MarshalMap(card)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to marshal flashcard: %v", err)
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	return added, refreshed, batchWriteItems(h.DynamoDB, tableName("puzzle-hub-flashcards"), requests)
+}
+
+func newFlashcard(userID, deckID, source, cardID string, draft FlashcardDraft, now time.Time) Flashcard {
+	return Flashcard{
+		UserID:    userID,
+		CardID:    cardID,
+		DeckID:    deckID,
+		Front:     draft.Front,
+		Back:      draft.Back,
+		Hint:      draft.Hint,
+		Source:    source,
+		Ease:      defaultFlashcardEase,
+		DueAt:     now,
+		CreatedAt: now,
+	}
+}
+
+// getReviewQueue returns the cards due across every deck: cards already
+// being learned first, most overdue first, then up to maxNewCardsPerQueue
+// new ones
+func (h *PuzzleHub) getReviewQueue(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	limit := defaultReviewQueue
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxReviewQueue {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxReviewQueue))
+			return
+		}
+		limit = parsed
+	}
+
+	cards, err := h.getFlashcards(c.Request.Context(), userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcards", "error", err)
+		respondStorageError(c, err, "Failed to fetch review queue")
+		return
+	}
+
+	now := time.Now()
+	var learning, fresh []Flashcard
+	for _, card := range cards {
+		switch {
+		case card.DueAt.After(now):
+		case card.Reviews == 0:
+			fresh = append(fresh, card)
+		default:
+			learning = append(learning, card)
+		}
+	}
+	sort.Slice(learning, func(i, j int) bool { return learning[i].DueAt.Before(learning[j].DueAt) })
+	sort.Slice(fresh, func(i, j int) bool { return fresh[i].CreatedAt.Before(fresh[j].CreatedAt) })
+
+	queue := append([]Flashcard{}, learning[:min(len(learning), limit)]...)
+	newCards := min(len(fresh), maxNewCardsPerQueue, limit-len(queue))
+	queue = append(queue, fresh[:newCards]...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"cards":     queue,
+		"due":       len(learning) + len(fresh),
+		"remaining": len(learning) + len(fresh) - len(queue),
+	})
+}
+
+// submitFlashcardReview grades a review session's cards, reschedules them
+// and records the session
+func (h *PuzzleHub) submitFlashcardReview(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	var request SubmitFlashcardReviewRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if len(request.Grades) == 0 || len(request.Grades) > maxReviewQueue {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Send between 1 and %d grades", maxReviewQueue))
+		return
+	}
+	if request.DurationSeconds < 0 || request.DurationSeconds > 24*60*60 {
+		respondError(c, http.StatusBadRequest, "Duration must be between 0 and 86400 seconds")
+		return
+	}
+
+	cards, err := h.getFlashcards(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching flashcards", "error", err)
+		respondStorageError(c, err, "Failed to save review")
+		return
+	}
+	byID := make(map[string]Flashcard, len(cards))
+	for _, card := range cards {
+		byID[card.CardID] = card
+	}
+
+	now := time.Now()
+	changed := map[string]Flashcard{}
+	passed := 0
+	for _, grade := range request.Grades {
+		if *grade.Grade < 0 || *grade.Grade > maxFlashcardGrade {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Grades must be between 0 and %d", maxFlashcardGrade))
+			return
+		}
+		card, ok := changed[grade.CardID]
+		if !ok {
+			if card, ok = byID[grade.CardID]; !ok {
+				respondError(c, http.StatusBadRequest, fmt.Sprintf("Unknown card %q", grade.CardID))
+				return
+			}
+		}
+		card.schedule(*grade.Grade, now)
+		changed[card.CardID] = card
+		if *grade.Grade >= passingFlashcardGrade {
+			passed++
+		}
+	}
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(changed))
+	reviewed := make([]Flashcard, 0, len(changed))
+	for _, card := range changed {
+		item, err := dynamodbattribute.MarshalMap(card)
+		if err != nil {
+			requestLogger(c).Error("error marshaling flashcard", "error", err)
+			respondStorageError(c, err, "Failed to save review")
+			return
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+		reviewed = append(reviewed, card)
+	}
+	if err := batchWriteItems(h.DynamoDB, tableName("puzzle-hub-flashcards"), requests); err != nil {
+		requestLogger(c).Error("error saving flashcard review", "error", err)
+		respondStorageError(c, err, "Failed to save review")
+		return
+	}
+	sort.Slice(reviewed, func(i, j int) bool { return reviewed[i].DueAt.Before(reviewed[j].DueAt) })
+
+	result, err := h.saveActivityResult(userObj, "flashcards", float64(passed), float64(len(request.Grades)), request.DurationSeconds)
+	if err != nil {
+		requestLogger(c).Error("error saving review result", "error", err)
+		respondStorageError(c, err, "Failed to save review")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"cards":  reviewed,
+		"passed": passed,
+		"result": result,
+	})
+}
+
+// putFlashcardDeck saves a deck; onlyNew keeps an existing deck as it is
+// and reports a conditional check failure instead
+func (h *PuzzleHub) putFlashcardDeck(ctx context.Context, deck FlashcardDeck, onlyNew bool) error {
+	item, err := dynamodbattribute.MarshalMap(deck)
+	if err != nil {
+		return fmt.Errorf("failed to marshal flashcard deck: %v", err)
+	}
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-flashcard-decks")),
+		Item:      item,
+	}
+	if onlyNew {
+		input.ConditionExpression = aws.String("attribute_not_exists(deck_id)")
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, input)
+	return err
+}
+
+// getFlashcardDeck returns nil when the player has no such deck
+func (h *PuzzleHub) getFlashcardDeck(ctx context.Context, userID, deckID string) (*FlashcardDeck, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-flashcard-decks")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+			"deck_id": {S: aws.String(deckID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var deck FlashcardDeck
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &deck); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal flashcard deck: %v", err)
+	}
+	return &deck, nil
+}
+
+func (h *PuzzleHub) getFlashcardDecks(ctx context.Context, userID string) ([]FlashcardDeck, error) {
+	decks := []FlashcardDeck{}
+	err := queryUserItems(ctx, h.DynamoDB, "puzzle-hub-flashcard-decks", userID, func(page []map[string]*dynamodb.AttributeValue) error {
+		var items []FlashcardDeck
+		if err := dynamodbattribute.UnmarshalListOfMaps(page, &items); err != nil {
+			return err
+		}
+		decks = append(decks, items...)
+		return nil
+	})
+	return decks, err
+}
+
+// getFlashcards returns all of the player's cards, across decks
+func (h *PuzzleHub) getFlashcards(ctx context.Context, userID string) ([]Flashcard, error) {
+	var cards []Flashcard
+	err := queryUserItems(ctx, h.DynamoDB, "puzzle-hub-flashcards", userID, func(page []map[string]*dynamodb.AttributeValue) error {
+		var items []Flashcard
+		if err := dynamodbattribute.UnmarshalListOfMaps(page, &items); err != nil {
+			return err
+		}
+		cards = append(cards, items...)
+		return nil
+	})
+	return cards, err
+}
+
+// queryUserItems pages through a table keyed by user_id
+func queryUserItems(ctx context.Context, db *dynamodb.DynamoDB, table, userID string, handle func([]map[string]*dynamodb.AttributeValue) error) error {
+	var handleErr error
+	err := db.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName(table)),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		handleErr = handle(page.Items)
+		return handleErr == nil
+	})
+	if err == nil {
+		err = handleErr
+	}
+	return err
+}
+
+// flashcardsForFacts turns a drill's missed facts into cards
+func flashcardsForFacts(missed []FactResult) []FlashcardDraft {
+	drafts := make([]FlashcardDraft, 0, len(missed))
+	for _, result := range missed {
+		drafts = append(drafts, FlashcardDraft{
+			Key:   result.FactID,
+			Front: result.Prompt,
+			Back:  strconv.Itoa(result.Correct),
+		})
+	}
+	return drafts
+}
+
+// flashcardsForVocabulary turns an analysis's vocabulary tips into cards
+func flashcardsForVocabulary(analysis *WritingAnalysisResponse) []FlashcardDraft {
+	var drafts []FlashcardDraft
+	for _, tip := range analysis.VocabularyTips {
+		original := strings.TrimSpace(tip.Original)
+		if original == "" || len(tip.Suggestions) == 0 || len(original) > 100 {
+			continue
+		}
+		draft := FlashcardDraft{
+			Key:   strings.ToLower(original),
+			Front: fmt.Sprintf("A stronger word for \"%s\"", original),
+			Back:  strings.Join(tip.Suggestions, ", "),
+			Hint:  tip.Explanation,
+		}
+		if normalizeFlashcardDraft(&draft) == "" {
+			drafts = append(drafts, draft)
+		}
+	}
+	return drafts
+}
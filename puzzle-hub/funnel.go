@@ -0,0 +1,265 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Onboarding funnel: visit → login → first puzzle → return visit
+//
+// Each new visitor gets a funnel record keyed by their visitor ID, and later
+// stages are stamped onto it (first time only) as the analytics writer sees
+// login, puzzle and visit events. A return visit is a visit on a later UTC
+// day than the first puzzle. Visitor IDs rotate monthly (see visitors.go),
+// so someone who comes back in a new month starts a new funnel record.
+
+var funnelStages = []string{"visited", "logged_in", "first_puzzle", "returned"}
+
+type FunnelSubject struct {
+	SubjectID      string     `dynamodbav:"subject_id"` // Visitor ID
+	VisitedAt      time.Time  `dynamodbav:"visited_at"`
+	UserID         string     `dynamodbav:"user_id,omitempty"`
+	LoggedInAt     *time.Time `dynamodbav:"logged_in_at,omitempty"`
+	FirstPuzzleAt  *time.Time `dynamodbav:"first_puzzle_at,omitempty"`
+	FirstPuzzleDay string     `dynamodbav:"first_puzzle_day,omitempty"`
+	ReturnedAt     *time.Time `dynamodbav:"returned_at,omitempty"`
+}
+
+type FunnelStageReport struct {
+	Stage             string  `json:"stage"`
+	Count             int     `json:"count"`
+	PercentOfStart    float64 `json:"percent_of_start"`
+	PercentOfPrevious float64 `json:"percent_of_previous"`
+	DropOff           float64 `json:"drop_off_percent"`
+}
+
+// RecordPuzzle notes that a user finished a puzzle, for the funnel. Only
+// the first one per user per process is sent on.
+func (a *AnalyticsService) RecordPuzzle(userID string) {
+	a.mu.Lock()
+	known := a.knownPuzzlers[userID]
+	a.knownPuzzlers[userID] = true
+	a.mu.Unlock()
+
+	if !known {
+		a.enqueue("first_puzzle", "", userID, false)
+	}
+}
+
+// applyFunnel advances the funnel record for one event
+func (a *AnalyticsService) applyFunnel(event *AnalyticsEvent) {
+	var err error
+	switch event.EventType {
+	case "visit":
+		if event.VisitorID == "" {
+			return
+		}
+		if event.IsNew {
+			err = a.startFunnel(event)
+		} else {
+			err = a.markFunnelReturn(event)
+		}
+	case "login":
+		if event.VisitorID == "" {
+			return
+		}
+		err = a.markFunnelLogin(event)
+	case "first_puzzle":
+		err = a.markFunnelPuzzle(event)
+	}
+	if err != nil && !isConditionalCheckFailed(err) {
+		log.Printf("Warning: Failed to update funnel for %s event: %v", event.EventType, err)
+	}
+}
+
+func (a *AnalyticsService) startFunnel(event *AnalyticsEvent) error {
+	item, err := dynamodbattribute.MarshalMap(FunnelSubject{
+		SubjectID: event.VisitorID,
+		VisitedAt: event.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.db.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-funnel")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(subject_id)"),
+	})
+	return err
+}
+
+// markFunnelReturn stamps returned_at, checked at most once per visitor per
+// day since most visits fail the condition
+func (a *AnalyticsService) markFunnelReturn(event *AnalyticsEvent) error {
+	day := event.Timestamp.UTC().Format("2006-01-02")
+	a.mu.Lock()
+	if a.returnCheckDay != day {
+		a.returnCheckDay = day
+		a.returnChecked = make(map[string]bool)
+	}
+	checked := a.returnChecked[event.VisitorID]
+	a.returnChecked[event.VisitorID] = true
+	a.mu.Unlock()
+	if checked {
+		return nil
+	}
+
+	_, err := a.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-funnel")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subject_id": {S: aws.String(event.VisitorID)},
+		},
+		UpdateExpression:    aws.String("SET returned_at = :now"),
+		ConditionExpression: aws.String("attribute_exists(first_puzzle_at) AND attribute_not_exists(returned_at) AND first_puzzle_day < :today"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":   {S: aws.String(event.Timestamp.Format(time.RFC3339Nano))},
+			":today": {S: aws.String(day)},
+		},
+	})
+	return err
+}
+
+func (a *AnalyticsService) markFunnelLogin(event *AnalyticsEvent) error {
+	_, err := a.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-funnel")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subject_id": {S: aws.String(event.VisitorID)},
+		},
+		UpdateExpression:    aws.String("SET logged_in_at = if_not_exists(logged_in_at, :now), user_id = if_not_exists(user_id, :user_id)"),
+		ConditionExpression: aws.String("attribute_exists(subject_id)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":     {S: aws.String(event.Timestamp.Format(time.RFC3339Nano))},
+			":user_id": {S: aws.String(event.UserID)},
+		},
+	})
+	return err
+}
+
+// markFunnelPuzzle stamps first_puzzle_at on every funnel record the user
+// logged in from
+func (a *AnalyticsService) markFunnelPuzzle(event *AnalyticsEvent) error {
+	subjects, err := queryAll[FunnelSubject](a.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-funnel")),
+		IndexName:              aws.String("user_id-index"),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(event.UserID)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, subject := range subjects {
+		_, err := a.db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName("puzzle-hub-funnel")),
+			Key: map[string]*dynamodb.AttributeValue{
+				"subject_id": {S: aws.String(subject.SubjectID)},
+			},
+			UpdateExpression:    aws.String("SET first_puzzle_at = :now, first_puzzle_day = :day"),
+			ConditionExpression: aws.String("attribute_not_exists(first_puzzle_at)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":now": {S: aws.String(event.Timestamp.Format(time.RFC3339Nano))},
+				":day": {S: aws.String(event.Timestamp.UTC().Format("2006-01-02"))},
+			},
+		})
+		if err != nil && !isConditionalCheckFailed(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// adminGetFunnel reports how many visitors who first arrived in the date
+// range reached each stage, with drop-off between stages
+func (h *PuzzleHub) adminGetFunnel(c *gin.Context) {
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -30)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "from must be YYYY-MM-DD")
+			return
+		}
+		from = parsed
+	}
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "to must be YYYY-MM-DD")
+			return
+		}
+		to = parsed.AddDate(0, 0, 1).Add(-time.Nanosecond) // Inclusive of the whole day
+	}
+	if to.Before(from) {
+		respondError(c, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	counts := make([]int, len(funnelStages))
+	var unmarshalErr error
+	err := h.DynamoDB.ScanPages(&dynamodb.ScanInput{
+		TableName:        aws.String(tableName("puzzle-hub-funnel")),
+		FilterExpression: aws.String("visited_at BETWEEN :from AND :to"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":from": {S: aws.String(from.Format(time.RFC3339Nano))},
+			":to":   {S: aws.String(to.Format(time.RFC3339Nano))},
+		},
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var subjects []FunnelSubject
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &subjects); unmarshalErr != nil {
+			return false
+		}
+		for _, subject := range subjects {
+			// Stages only count in order, e.g. a return without a login doesn't
+			reached := []bool{true, subject.LoggedInAt != nil, subject.FirstPuzzleAt != nil, subject.ReturnedAt != nil}
+			for i := range funnelStages {
+				if !reached[i] {
+					break
+				}
+				counts[i]++
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		requestLogger(c).Error("error scanning funnel", "error", err)
+		respondStorageError(c, err, "Failed to fetch funnel")
+		return
+	}
+
+	stages := make([]FunnelStageReport, len(funnelStages))
+	for i, stage := range funnelStages {
+		report := FunnelStageReport{Stage: stage, Count: counts[i]}
+		if counts[0] > 0 {
+			report.PercentOfStart = roundPercent(counts[i], counts[0])
+		}
+		if i > 0 && counts[i-1] > 0 {
+			report.PercentOfPrevious = roundPercent(counts[i], counts[i-1])
+			report.DropOff = math.Round((100-report.PercentOfPrevious)*10) / 10
+		} else if i == 0 {
+			report.PercentOfPrevious = 100
+		}
+		stages[i] = report
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":   from.Format("2006-01-02"),
+		"to":     to.Format("2006-01-02"),
+		"stages": stages,
+	})
+}
+
+func roundPercent(part, whole int) float64 {
+	return math.Round(float64(part)/float64(whole)*1000) / 10
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// XP, levels and avatars
+//
+// Every finished session earns XP through saveActivityResult, so modules
+// don't award it themselves: a base amount per activity plus up to the same
+// again for accuracy. To stop farming, each activity has a daily XP cap in
+// the player's timezone, counted on their puzzle-hub-daily-usage row;
+// sessions past the cap still count as progress but earn nothing.
+//
+// Levels follow from total XP (level n starts at 50·n·(n-1) XP). Reaching a
+// level unlocks the avatar items for it, which are stored on the player's
+// puzzle-hub-gamification row along with the items they have equipped, and
+// sends a level_up notification.
+
+const levelXPStep = 50
+
+type activityXPRule struct {
+	Base     int // XP for finishing, doubled for a perfect score
+	DailyCap int
+}
+
+var activityXPRules = map[string]activityXPRule{
+	"spelling":   {Base: 10, DailyCap: 200},
+	"writing":    {Base: 20, DailyCap: 150},
+	"story":      {Base: 20, DailyCap: 150},
+	"yohaku":     {Base: 10, DailyCap: 200},
+	"math_facts": {Base: 10, DailyCap: 200},
+	"sudoku":     {Base: 15, DailyCap: 200},
+	"quiz":       {Base: 10, DailyCap: 200},
+	"flashcards": {Base: 5, DailyCap: 100},
+}
+
+type AvatarItem struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Slot  string `json:"slot"` // base, hat, accessory or background
+	Level int    `json:"level"`
+}
+
+var avatarSlots = []string{"base", "hat", "accessory", "background"}
+
+// avatarItems is the catalog, in unlock order
+var avatarItems = []AvatarItem{
+	{"fox", "Fox", "base", 1},
+	{"owl", "Owl", "base", 1},
+	{"sky", "Blue sky", "background", 1},
+	{"cap", "Baseball cap", "hat", 2},
+	{"scarf", "Cozy scarf", "accessory", 3},
+	{"meadow", "Meadow", "background", 4},
+	{"panda", "Panda", "base", 5},
+	{"wizard-hat", "Wizard hat", "hat", 6},
+	{"glasses", "Smart glasses", "accessory", 8},
+	{"space", "Outer space", "background", 10},
+	{"dragon", "Dragon", "base", 12},
+	{"crown", "Crown", "hat", 15},
+	{"cape", "Hero cape", "accessory", 18},
+	{"castle", "Castle", "background", 20},
+	{"unicorn", "Unicorn", "base", 25},
+}
+
+// GamificationState is a player's row in puzzle-hub-gamification
+type GamificationState struct {
+	UserID    string            `json:"-" dynamodbav:"user_id"`
+	XP        int               `json:"xp" dynamodbav:"xp"`
+	Level     int               `json:"level" dynamodbav:"level"`
+	Unlocks   []string          `json:"unlocks" dynamodbav:"unlocks,stringset,omitempty"`
+	Equipped  map[string]string `json:"equipped" dynamodbav:"equipped,omitempty"` // Slot to item ID
+	UpdatedAt time.Time         `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+type DailyXP struct {
+	Activity string `json:"activity"`
+	XP       int    `json:"xp"`
+	Cap      int    `json:"cap"`
+}
+
+type GamificationProfile struct {
+	XP            int               `json:"xp"`
+	Level         int               `json:"level"`
+	LevelXP       int               `json:"level_xp"` // XP where the current level started
+	NextLevelXP   int               `json:"next_level_xp"`
+	Unlocks       []AvatarItem      `json:"unlocks"`
+	NextUnlocks   []AvatarItem      `json:"next_unlocks"` // Items for the next level that has any
+	Equipped      map[string]string `json:"equipped"`
+	Today         []DailyXP         `json:"today"`
+	AvatarSlots   []string          `json:"avatar_slots"`
+	XPPerActivity map[string]int    `json:"xp_per_activity"` // Base XP per session
+}
+
+// UpdateAvatarRequest equips items by slot; "" takes the slot's item off
+type UpdateAvatarRequest struct {
+	Equipped map[string]string `json:"equipped" binding:"required"`
+}
+
+func levelForXP(xp int) int {
+	// Largest n with levelXPStep·n·(n-1) <= xp
+	n := int((1 + math.Sqrt(1+4*float64(xp)/levelXPStep)) / 2)
+	for levelXPStep*n*(n-1) > xp {
+		n--
+	}
+	return max(n, 1)
+}
+
+func xpForLevel(level int) int {
+	return levelXPStep * level * (level - 1)
+}
+
+// sessionXP is what one result is worth before the daily cap
+func sessionXP(activity string, score, maxScore float64) int {
+	rule, ok := activityXPRules[activity]
+	if !ok || maxScore <= 0 {
+		return 0
+	}
+	return rule.Base + int(math.Round(float64(rule.Base)*score/maxScore))
+}
+
+// awardXP gives the user XP for a finished session, within the activity's
+// daily cap, and returns the amount awarded. Errors are logged rather than
+// returned so the session is still recorded.
+func (h *PuzzleHub) awardXP(ctx context.Context, user *User, result *ActivityResult) int {
+	xp := sessionXP(result.Activity, result.Score, result.MaxScore)
+	if xp == 0 {
+		return 0
+	}
+
+	// Add to today's tally first; whatever goes past the cap is not awarded
+	attribute := "xp_" + result.Activity
+	out, err := h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-daily-usage")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(user.ID)},
+			"day":     {S: aws.String(userToday(user))},
+		},
+		UpdateExpression:         aws.String("ADD #xp :xp SET expires_at = :expires_at"),
+		ExpressionAttributeNames: map[string]*string{"#xp": aws.String(attribute)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":xp":         {N: aws.String(strconv.Itoa(xp))},
+			":expires_at": {N: aws.String(strconv.FormatInt(time.Now().AddDate(0, 0, 35).Unix(), 10))},
+		},
+		ReturnValues: aws.String("UPDATED_NEW"),
+	})
+	if err != nil {
+		log.Printf("Error counting XP for %s: %v", user.ID, err)
+		return 0
+	}
+	today := 0
+	if value := out.Attributes[attribute]; value != nil && value.N != nil {
+		today, _ = strconv.Atoi(*value.N)
+	}
+	over := today - activityXPRules[result.Activity].DailyCap
+	if over > 0 {
+		xp = max(xp-over, 0)
+	}
+	if xp == 0 {
+		return 0
+	}
+
+	if err := h.addXP(ctx, user, xp); err != nil {
+		log.Printf("Error awarding XP to %s: %v", user.ID, err)
+		return 0
+	}
+	return xp
+}
+
+// addXP adds to the user's total and handles a level up
+func (h *PuzzleHub) addXP(ctx context.Context, user *User, xp int) error {
+	out, err := h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-gamification")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(user.ID)},
+		},
+		UpdateExpression: aws.String("ADD xp :xp SET updated_at = :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":xp":  {N: aws.String(strconv.Itoa(xp))},
+			":now": {S: aws.String(time.Now().Format(time.RFC3339Nano))},
+		},
+		ReturnValues: aws.String("ALL_NEW"),
+	})
+	if err != nil {
+		return err
+	}
+	var state GamificationState
+	if err := dynamodbattribute.UnmarshalMap(out.Attributes, &state); err != nil {
+		return fmt.Errorf("failed to unmarshal gamification state: %v", err)
+	}
+
+	level := levelForXP(state.XP)
+	if level <= state.Level {
+		return nil
+	}
+
+	// The condition makes sure only one concurrent award announces the level
+	update := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-gamification")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(user.ID)},
+		},
+		UpdateExpression:    aws.String("SET #level = :level"),
+		ConditionExpression: aws.String("attribute_not_exists(#level) OR #level < :level"),
+		ExpressionAttributeNames: map[string]*string{
+			"#level": aws.String("level"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":level": {N: aws.String(strconv.Itoa(level))},
+		},
+	}
+	var unlocked []AvatarItem
+	var unlockIDs []*string
+	for _, item := range avatarItems {
+		if item.Level > state.Level && item.Level <= level {
+			unlocked = append(unlocked, item)
+			unlockIDs = append(unlockIDs, aws.String(item.ID))
+		}
+	}
+	if len(unlockIDs) > 0 {
+		update.UpdateExpression = aws.String("SET #level = :level ADD unlocks :unlocks")
+		update.ExpressionAttributeValues[":unlocks"] = &dynamodb.AttributeValue{SS: unlockIDs}
+	}
+	if _, err := h.DynamoDB.UpdateItemWithContext(ctx, update); err != nil {
+		if isConditionalCheckFailed(err) {
+			return nil
+		}
+		return err
+	}
+
+	// New players reach level 1 on their first session; that's not news
+	if level == 1 {
+		return nil
+	}
+	language := h.recipientLanguage(user.ID)
+	body := translate(language, "Keep it up!")
+	if len(unlocked) > 0 {
+		names := make([]string, len(unlocked))
+		for i, item := range unlocked {
+			names[i] = translate(language, item.Name)
+		}
+		body = localize(language, "You unlocked %s for your avatar.", strings.Join(names, ", "))
+	}
+	log.Printf("⭐ %s reached level %d", user.ID, level)
+	h.notify(ctx, Notification{
+		UserID: user.ID,
+		Kind:   notificationLevelUp,
+		Title:  localize(language, "You reached level %d!", level),
+		Body:   body,
+		Data:   map[string]string{"level": strconv.Itoa(level)},
+	}, user.Email)
+	return nil
+}
+
+// getGamificationProfile returns the user's level, XP, unlocks and today's
+// XP against each activity's cap
+func (h *PuzzleHub) getGamificationProfile(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	state, err := h.getGamificationState(c.Request.Context(), userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching gamification state", "error", err)
+		respondStorageError(c, err, "Failed to fetch profile")
+		return
+	}
+	usage, err := h.DynamoDB.GetItemWithContext(c.Request.Context(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-daily-usage")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userObj.ID)},
+			"day":     {S: aws.String(userToday(userObj))},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching today's XP", "error", err)
+		respondStorageError(c, err, "Failed to fetch profile")
+		return
+	}
+
+	level := levelForXP(state.XP)
+	profile := GamificationProfile{
+		XP:            state.XP,
+		Level:         level,
+		LevelXP:       xpForLevel(level),
+		NextLevelXP:   xpForLevel(level + 1),
+		Unlocks:       unlockedAvatarItems(state),
+		NextUnlocks:   []AvatarItem{},
+		Equipped:      state.Equipped,
+		Today:         []DailyXP{},
+		AvatarSlots:   avatarSlots,
+		XPPerActivity: map[string]int{},
+	}
+	if profile.Equipped == nil {
+		profile.Equipped = map[string]string{}
+	}
+	for _, item := range avatarItems {
+		if item.Level > level && (len(profile.NextUnlocks) == 0 || item.Level == profile.NextUnlocks[0].Level) {
+			profile.NextUnlocks = append(profile.NextUnlocks, item)
+		}
+	}
+
+	activities := make([]string, 0, len(activityXPRules))
+	for activity, rule := range activityXPRules {
+		activities = append(activities, activity)
+		profile.XPPerActivity[activity] = rule.Base
+	}
+	sort.Strings(activities)
+	for _, activity := range activities {
+		today := DailyXP{Activity: activity, Cap: activityXPRules[activity].DailyCap}
+		if value := usage.Item["xp_"+activity]; value != nil && value.N != nil {
+			today.XP, _ = strconv.Atoi(*value.N)
+			today.XP = min(today.XP, today.Cap)
+		}
+		profile.Today = append(profile.Today, today)
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// updateAvatar equips unlocked items
+func (h *PuzzleHub) updateAvatar(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var request UpdateAvatarRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	state, err := h.getGamificationState(c.Request.Context(), userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching gamification state", "error", err)
+		respondStorageError(c, err, "Failed to update avatar")
+		return
+	}
+	unlocked := map[string]AvatarItem{}
+	for _, item := range unlockedAvatarItems(state) {
+		unlocked[item.ID] = item
+	}
+
+	equipped := state.Equipped
+	if equipped == nil {
+		equipped = map[string]string{}
+	}
+	for slot, itemID := range request.Equipped {
+		if !slices.Contains(avatarSlots, slot) {
+			respondError(c, http.StatusBadRequest, "Unknown avatar slot: "+slot)
+			return
+		}
+		if itemID == "" {
+			delete(equipped, slot)
+			continue
+		}
+		item, ok := unlocked[itemID]
+		if !ok {
+			respondError(c, http.StatusBadRequest, "Item not unlocked: "+itemID)
+			return
+		}
+		if item.Slot != slot {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("%s goes in the %s slot", item.Name, item.Slot))
+			return
+		}
+		equipped[slot] = itemID
+	}
+
+	value, err := dynamodbattribute.Marshal(equipped)
+	if err != nil {
+		requestLogger(c).Error("error marshaling avatar", "error", err)
+		respondStorageError(c, err, "Failed to update avatar")
+		return
+	}
+	_, err = h.DynamoDB.UpdateItemWithContext(c.Request.Context(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-gamification")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userObj.ID)},
+		},
+		UpdateExpression: aws.String("SET equipped = :equipped, updated_at = :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":equipped": value,
+			":now":      {S: aws.String(time.Now().Format(time.RFC3339Nano))},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error saving avatar", "error", err)
+		respondStorageError(c, err, "Failed to update avatar")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  tr(c, "Avatar updated"),
+		"equipped": equipped,
+	})
+}
+
+// getGamificationState returns the user's row, zero if they have none yet
+func (h *PuzzleHub) getGamificationState(ctx context.Context, userID string) (*GamificationState, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-gamification")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	state := &GamificationState{UserID: userID}
+	if result.Item != nil {
+		if err := dynamodbattribute.UnmarshalMap(result.Item, state); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal gamification state: %v", err)
+		}
+	}
+	return state, nil
+}
+
+// unlockedAvatarItems is the stored unlocks plus the starter items, which
+// every player has from level 1
+func unlockedAvatarItems(state *GamificationState) []AvatarItem {
+	items := []AvatarItem{}
+	for _, item := range avatarItems {
+		if item.Level <= 1 || slices.Contains(state.Unlocks, item.ID) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// moveGamification adds a guest's XP to the account they signed in with.
+// The guest's row is deleted first so a retried merge can't add it twice;
+// unlocks follow from the new level.
+func (h *PuzzleHub) moveGamification(ctx context.Context, guestID string, user *User) error {
+	result, err := h.DynamoDB.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-gamification")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(guestID)},
+		},
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if err != nil {
+		return err
+	}
+	var state GamificationState
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &state); err != nil {
+		return fmt.Errorf("failed to unmarshal gamification state: %v", err)
+	}
+	if state.XP == 0 {
+		return nil
+	}
+	return h.addXP(ctx, user, state.XP)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Guest mode
+//
+// Kids can play without signing in: POST /auth/guest issues a normal signed
+// token pair for a random "guest_" user ID, and progress is stored under that
+// ID like any other user's. After signing in with Google, the client calls
+// POST /auth/link-guest with the guest token to move the guest's progress
+// and XP into the real account. Each guest can only be linked once.
+//
+// Anyone can start as many guests as they like, so guests can't do what
+// counts per person: sending feedback, app ratings, feature votes and
+// comments, exporting an account or adding webhooks all need a sign-in.
+
+const guestIDPrefix = "guest_"
+
+var errInvalidGuestToken = errors.New("invalid guest token")
+
+type GuestLink struct {
+	GuestID       string    `json:"guest_id" dynamodbav:"guest_id"`
+	UserID        string    `json:"user_id" dynamodbav:"user_id"`
+	ResultsMerged int       `json:"results_merged" dynamodbav:"results_merged"`
+	LinkedAt      time.Time `json:"linked_at" dynamodbav:"linked_at"`
+}
+
+type LinkGuestRequest struct {
+	GuestToken string `json:"guest_token" binding:"required"`
+}
+
+func isGuestID(userID string) bool {
+	return strings.HasPrefix(userID, guestIDPrefix)
+}
+
+// startGuestSession creates an anonymous user and logs it in
+func (h *PuzzleHub) startGuestSession(c *gin.Context) {
+	suffix, err := randomToken(12)
+	if err != nil {
+		requestLogger(c).Error("error generating guest ID", "error", err)
+		respondStorageError(c, err, "Failed to start guest session")
+		return
+	}
+
+	user := h.getOrRestoreUser(guestIDPrefix+suffix, "", "Guest")
+	accessToken, refreshToken, err := h.startLoginSession(c, user, "guest")
+	if err != nil {
+		requestLogger(c).Error("failed to start guest session", "error", err)
+		respondStorageError(c, err, "Failed to start guest session")
+		return
+	}
+
+	c.JSON(http.StatusCreated, LoginResponse{
+		Success:      true,
+		User:         user,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		Message:      "Playing as guest",
+	})
+}
+
+// linkGuestAccount merges a guest's progress into the signed-in account and
+// ends the guest's session
+func (h *PuzzleHub) linkGuestAccount(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+	if userObj.IsGuest {
+		respondError(c, http.StatusForbidden, "Sign in before linking guest progress")
+		return
+	}
+
+	var request LinkGuestRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	guestID, sessionID, err := h.parseGuestToken(request.GuestToken)
+	if errors.Is(err, errInvalidGuestToken) {
+		respondError(c, http.StatusBadRequest, "Invalid guest token")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error checking guest token", "error", err)
+		respondStorageError(c, err, "Failed to link guest progress")
+		return
+	}
+
+	// Claim the guest first so two accounts can't both take its progress; the
+	// same account may retry an interrupted merge
+	link := GuestLink{
+		GuestID:  guestID,
+		UserID:   userObj.ID,
+		LinkedAt: time.Now(),
+	}
+	item, err := dynamodbattribute.MarshalMap(link)
+	if err != nil {
+		requestLogger(c).Error("error marshaling guest link", "error", err)
+		respondStorageError(c, err, "Failed to link guest progress")
+		return
+	}
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-guest-links")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(guest_id) OR user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userObj.ID)},
+		},
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusConflict, "Guest progress has already been linked to another account")
+			return
+		}
+		requestLogger(c).Error("error saving guest link", "error", err)
+		respondStorageError(c, err, "Failed to link guest progress")
+		return
+	}
+
+	merged, err := h.moveActivityResults(guestID, userObj.ID)
+	if err != nil {
+		// The claim stays so only this account can retry; moved results are
+		// gone from the guest and won't be moved twice
+		requestLogger(c).Error("error merging guest", "error", err, "guest_id", guestID, "results", merged)
+		respondStorageError(c, err, "Failed to link guest progress")
+		return
+	}
+
+	_, err = h.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-guest-links")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"guest_id": {S: aws.String(guestID)},
+		},
+		UpdateExpression: aws.String("SET results_merged = :merged"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":merged": {N: aws.String(fmt.Sprint(merged))},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error updating guest link", "error", err, "guest_id", guestID)
+	}
+	link.ResultsMerged = merged
+
+	if err := h.moveGamification(c.Request.Context(), guestID, userObj); err != nil {
+		requestLogger(c).Error("error moving XP from guest", "error", err, "guest_id", guestID)
+	}
+
+	h.revokeRefreshFamily(guestID, sessionID)
+
+	requestLogger(c).Info("linked guest progress", "guest_id", guestID, "results", merged)
+	c.JSON(http.StatusOK, gin.H{
+		"message": tr(c, "Guest progress linked"),
+		"link":    link,
+	})
+}
+
+// parseGuestToken validates a guest's access token and returns its user and
+// session IDs. Expired tokens are accepted since kids may sign in well after
+// their last guest game, but only for as long as the session's refresh token
+// would have lasted, and not once the session was logged out or revoked.
+func (h *PuzzleHub) parseGuestToken(tokenString string) (string, string, error) {
+	token, err := jwt.Parse(tokenString, h.jwtKeyFunc, jwt.WithoutClaimsValidation())
+	if err != nil || !token.Valid {
+		return "", "", fmt.Errorf("%w: %v", errInvalidGuestToken, err)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	userID, _ := claims["user_id"].(string)
+	sessionID, _ := claims["sid"].(string)
+	if !isGuestID(userID) || sessionID == "" {
+		return "", "", fmt.Errorf("%w: not a guest token", errInvalidGuestToken)
+	}
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil || time.Since(issuedAt.Time) > refreshTokenTTL {
+		return "", "", fmt.Errorf("%w: issued too long ago", errInvalidGuestToken)
+	}
+
+	jti, _ := claims["jti"].(string)
+	revoked, err := h.isAccessTokenRevoked(jti, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	// The denylist only lasts as long as an access token, so an older
+	// logout is found on the session record
+	session, err := h.Store.GetLoginSession(userID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	if revoked || session == nil || session.RevokedAt != nil {
+		return "", "", fmt.Errorf("%w: session has ended", errInvalidGuestToken)
+	}
+	return userID, sessionID, nil
+}
+
+// moveActivityResults re-homes every result from one user to another and
+// returns how many were moved
+func (h *PuzzleHub) moveActivityResults(fromUserID, toUserID string) (int, error) {
+	results, err := h.getActivityResults(fromUserID, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, result := range results {
+		result.UserID = toUserID
+		item, err := dynamodbattribute.MarshalMap(result)
+		if err != nil {
+			return moved, fmt.Errorf("failed to marshal activity result: %v", err)
+		}
+
+		_, err = h.DynamoDB.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{
+					Put: &dynamodb.Put{
+						TableName: aws.String(tableName("puzzle-hub-activity-results")),
+						Item:      item,
+					},
+				},
+				{
+					Delete: &dynamodb.Delete{
+						TableName: aws.String(tableName("puzzle-hub-activity-results")),
+						Key: map[string]*dynamodb.AttributeValue{
+							"user_id":   {S: aws.String(fromUserID)},
+							"result_id": {S: aws.String(result.ResultID)},
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return moved, fmt.Errorf("failed to move activity result %s: %v", result.ResultID, err)
+		}
+		moved++
+	}
+	return moved, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// Health checks for the load balancer or orchestrator. /healthz only says
+// the process is serving, so a failing dependency never gets the instance
+// restarted; /readyz checks what requests need and answers 503 until they
+// are available, so traffic is held back instead.
+
+const readinessTimeout = 3 * time.Second
+
+// healthz is the liveness probe
+func (h *PuzzleHub) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz is the readiness probe. Every check runs and is reported, failed
+// ones with the reason.
+func (h *PuzzleHub) readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]error{
+		"dynamodb":     h.checkDynamoDB(ctx),
+		"storage":      h.Store.Ping(ctx),
+		"cache":        h.Cache.Ping(ctx),
+		"ai_providers": h.checkAIProviders(),
+		"templates":    h.checkTemplates(),
+	}
+
+	status := http.StatusOK
+	results := make(map[string]string, len(checks))
+	for name, err := range checks {
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+		} else {
+			results[name] = "ok"
+		}
+	}
+
+	response := gin.H{"status": "ready", "checks": results}
+	if status != http.StatusOK {
+		response["status"] = "not ready"
+	}
+	c.JSON(status, response)
+}
+
+// checkDynamoDB describes a table the features outside Storage rely on
+func (h *PuzzleHub) checkDynamoDB(ctx context.Context) error {
+	_, err := h.DynamoDB.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName("puzzle-hub-activity-results")),
+	})
+	return err
+}
+
+// checkAIProviders confirms every provider in use has its API key
+func (h *PuzzleHub) checkAIProviders() error {
+	if demoMode {
+		return nil // Built-in content only
+	}
+
+	inUse := map[string]bool{h.Provider: true}
+	for _, provider := range h.FeatureProviders {
+		inUse[provider] = true
+	}
+
+	for provider := range inUse {
+		if !h.providerConfigured(provider) {
+			return fmt.Errorf("%s is not configured (%s)", provider, aiProviderKeyEnv[provider])
+		}
+	}
+	return nil
+}
+
+// checkTemplates confirms the admin-published prompt templates were read.
+// Built-in prompts and the HTML pages are loaded at startup, which fails
+// outright if they are missing.
+func (h *PuzzleHub) checkTemplates() error {
+	if !h.Prompts.Loaded() {
+		return fmt.Errorf("prompt templates not loaded yet")
+	}
+	return nil
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Households: family members share selected log types (chores, grocery
+// runs) so everyone logs into the same place.
+//
+// Someone creates a household and shares its invite code; others join with
+// it. Each user belongs to at most one household. The owner of a log type
+// can share it with their household, after which every member can log
+// entries to it and read everyone's entries and analytics, filtered to one
+// member with ?member=<user_id>. Entries still belong to whoever logged
+// them: only they or the log type's owner can delete one, and when someone
+// leaves, their entries leave the shared log types with them and their own
+// log types stop being shared.
+
+const (
+	householdRoleOwner  = "owner"
+	householdRoleMember = "member"
+)
+
+type Household struct {
+	ID         string    `json:"id" dynamodbav:"id"`
+	OwnerID    string    `json:"owner_id" dynamodbav:"owner_id"`
+	Name       string    `json:"name" dynamodbav:"name"`
+	InviteCode string    `json:"invite_code,omitempty" dynamodbav:"invite_code"`
+	CreatedAt  time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+type HouseholdMember struct {
+	HouseholdID string    `json:"household_id" dynamodbav:"household_id"`
+	UserID      string    `json:"user_id" dynamodbav:"user_id"`
+	Name        string    `json:"name" dynamodbav:"name"`
+	Role        string    `json:"role" dynamodbav:"role"`
+	JoinedAt    time.Time `json:"joined_at" dynamodbav:"joined_at"`
+}
+
+type CreateHouseholdRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type JoinHouseholdRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type ShareLogTypeRequest struct {
+	Shared *bool `json:"shared" binding:"required"`
+}
+
+// getHousehold returns the user's household, its members and the log types
+// shared with it
+func (h *PuzzleHub) getHousehold(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	household, members, ok := h.requireHousehold(c, userObj.ID)
+	if !ok {
+		return
+	}
+	logTypes, err := h.householdLogTypes(household.ID, members)
+	if err != nil {
+		requestLogger(c).Error("error fetching shared log types", "error", err, "household_id", household.ID)
+		respondStorageError(c, err, "Failed to fetch household")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"household": household,
+		"members":   members,
+		"log_types": logTypes,
+	})
+}
+
+func (h *PuzzleHub) createHousehold(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var request CreateHouseholdRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > 100 {
+		respondError(c, http.StatusBadRequest, "Household name must be between 1 and 100 characters")
+		return
+	}
+
+	existing, err := h.getHouseholdMembership(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error checking household membership", "error", err)
+		respondStorageError(c, err, "Failed to create household")
+		return
+	}
+	if existing != nil {
+		respondError(c, http.StatusConflict, "You are already in a household")
+		return
+	}
+
+	inviteCode, err := h.newUniqueInviteCode()
+	if err != nil {
+		requestLogger(c).Error("error generating invite code", "error", err)
+		respondStorageError(c, err, "Failed to create household")
+		return
+	}
+
+	household := Household{
+		ID:         fmt.Sprintf("hh_%d", time.Now().UnixNano()),
+		OwnerID:    userObj.ID,
+		Name:       name,
+		InviteCode: inviteCode,
+		CreatedAt:  time.Now(),
+	}
+	if err := h.putHousehold(&household); err != nil {
+		requestLogger(c).Error("error saving household", "error", err)
+		respondStorageError(c, err, "Failed to create household")
+		return
+	}
+	if err := h.addHouseholdMember(&household, userObj, householdRoleOwner); err != nil {
+		requestLogger(c).Error("error adding owner to household", "error", err, "household_id", household.ID)
+		respondStorageError(c, err, "Failed to create household")
+		return
+	}
+
+	log.Printf("🏠 %s created household %s (%s)", userObj.ID, household.ID, household.Name)
+	c.JSON(http.StatusCreated, household)
+}
+
+func (h *PuzzleHub) joinHousehold(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var request JoinHouseholdRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	household, err := h.getHouseholdByInviteCode(strings.ToUpper(strings.TrimSpace(request.Code)))
+	if err != nil {
+		requestLogger(c).Error("error looking up invite code", "error", err)
+		respondStorageError(c, err, "Failed to join household")
+		return
+	}
+	if household == nil {
+		respondError(c, http.StatusNotFound, "No household found for that code")
+		return
+	}
+
+	existing, err := h.getHouseholdMembership(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error checking household membership", "error", err)
+		respondStorageError(c, err, "Failed to join household")
+		return
+	}
+	if existing != nil {
+		respondError(c, http.StatusConflict, "You are already in a household")
+		return
+	}
+
+	if err := h.addHouseholdMember(household, userObj, householdRoleMember); err != nil {
+		requestLogger(c).Error("error saving household membership", "error", err)
+		respondStorageError(c, err, "Failed to join household")
+		return
+	}
+
+	// Someone rejoining brings back the entries they logged before
+	h.rebuildHouseholdAggregates(household.ID)
+
+	household.InviteCode = ""
+	log.Printf("🏠 %s joined household %s", userObj.ID, household.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Joined " + household.Name,
+		"household": household,
+	})
+}
+
+// regenerateHouseholdInviteCode invalidates the old code, e.g. after it
+// leaked
+func (h *PuzzleHub) regenerateHouseholdInviteCode(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	household, ok := h.requireHouseholdOwner(c, userObj.ID)
+	if !ok {
+		return
+	}
+
+	inviteCode, err := h.newUniqueInviteCode()
+	if err != nil {
+		requestLogger(c).Error("error generating invite code", "error", err)
+		respondStorageError(c, err, "Failed to generate invite code")
+		return
+	}
+	household.InviteCode = inviteCode
+	if err := h.putHousehold(household); err != nil {
+		requestLogger(c).Error("error saving household", "error", err, "household_id", household.ID)
+		respondStorageError(c, err, "Failed to generate invite code")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invite_code": inviteCode})
+}
+
+// removeHouseholdMember lets the owner remove a member, or a member leave
+func (h *PuzzleHub) removeHouseholdMember(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	memberID := c.Param("userId")
+
+	household, members, ok := h.requireHousehold(c, userObj.ID)
+	if !ok {
+		return
+	}
+	if household.OwnerID != userObj.ID && memberID != userObj.ID {
+		respondError(c, http.StatusForbidden, "Only the household owner can remove members")
+		return
+	}
+	if memberID == household.OwnerID {
+		respondError(c, http.StatusBadRequest, "The owner can't leave; delete the household instead")
+		return
+	}
+
+	found := false
+	for _, member := range members {
+		found = found || member.UserID == memberID
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "Not a member of this household")
+		return
+	}
+
+	if err := h.deleteHouseholdMember(household.ID, memberID); err != nil {
+		requestLogger(c).Error("error removing household member", "error", err)
+		respondStorageError(c, err, "Failed to update household")
+		return
+	}
+
+	// Their log types stop being shared, and their entries drop out of
+	// everyone else's
+	left := []HouseholdMember{{HouseholdID: household.ID, UserID: memberID}}
+	h.unshareHouseholdLogTypes(household.ID, left)
+	h.rebuildHouseholdAggregates(household.ID)
+
+	log.Printf("🏠 %s left household %s", memberID, household.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Removed from household"})
+}
+
+// deleteHousehold unshares every log type and removes everyone
+func (h *PuzzleHub) deleteHousehold(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	household, ok := h.requireHouseholdOwner(c, userObj.ID)
+	if !ok {
+		return
+	}
+	members, err := h.getHouseholdMembers(household.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching members", "error", err, "household_id", household.ID)
+		respondStorageError(c, err, "Failed to delete household")
+		return
+	}
+
+	h.unshareHouseholdLogTypes(household.ID, members)
+	for _, member := range members {
+		if err := h.deleteHouseholdMember(household.ID, member.UserID); err != nil {
+			requestLogger(c).Error("error removing household member", "error", err)
+			respondStorageError(c, err, "Failed to delete household")
+			return
+		}
+	}
+	_, err = h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-households")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(household.ID)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error deleting household", "error", err, "household_id", household.ID)
+		respondStorageError(c, err, "Failed to delete household")
+		return
+	}
+
+	log.Printf("🏠 %s deleted household %s", userObj.ID, household.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Household deleted"})
+}
+
+// shareLogType shares one of the user's log types with their household, or
+// stops sharing it
+func (h *PuzzleHub) shareLogType(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var request ShareLogTypeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	logType, status, err := h.getOwnedLogType(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	householdID := ""
+	if *request.Shared {
+		membership, err := h.getHouseholdMembership(userObj.ID)
+		if err != nil {
+			requestLogger(c).Error("error checking household membership", "error", err)
+			respondStorageError(c, err, "Failed to share log type")
+			return
+		}
+		if membership == nil {
+			respondError(c, http.StatusBadRequest, "Create or join a household first")
+			return
+		}
+		householdID = membership.HouseholdID
+	}
+	if logType.HouseholdID == householdID {
+		c.JSON(http.StatusOK, gin.H{"log_type": logType})
+		return
+	}
+
+	logType.HouseholdID = householdID
+	logType.UpdatedAt = time.Now()
+	if err := h.Store.PutLogType(logType); err != nil {
+		requestLogger(c).Error("error sharing log type", "error", err, "log_type_id", logType.ID)
+		respondStorageError(c, err, "Failed to share log type")
+		return
+	}
+
+	// Other members' entries join or leave the totals with the sharing
+	if err := h.rebuildLogAggregates(logType.UserID, logType.ID); err != nil {
+		requestLogger(c).Error("error rebuilding aggregates", "error", err, "log_type_id", logType.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"log_type": logType})
+}
+
+// getSharedLogType loads a log type the user owns or that is shared with
+// their household. Like getOwnedLogType, the status is meant for the client.
+func (h *PuzzleHub) getSharedLogType(userID, logTypeID string) (*LogType, int, error) {
+	logType, status, err := h.getOwnedLogType(userID, logTypeID)
+	if status != http.StatusForbidden {
+		return logType, status, err
+	}
+
+	logType, err = h.Store.GetLogType(logTypeID)
+	if err != nil || logType == nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to fetch log type")
+	}
+	if logType.HouseholdID == "" {
+		return nil, http.StatusForbidden, fmt.Errorf("Access denied")
+	}
+	member, err := h.getHouseholdMember(logType.HouseholdID, userID)
+	if err != nil {
+		log.Printf("Error checking household membership: %v", err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to fetch log type")
+	}
+	if member == nil {
+		return nil, http.StatusForbidden, fmt.Errorf("Access denied")
+	}
+	return logType, http.StatusOK, nil
+}
+
+// logTypeMemberIDs returns whose entries belong in a log type: its owner's,
+// or every household member's when it is shared
+func (h *PuzzleHub) logTypeMemberIDs(logType *LogType) ([]string, error) {
+	if logType.HouseholdID == "" {
+		return []string{logType.UserID}, nil
+	}
+	members, err := h.getHouseholdMembers(logType.HouseholdID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{logType.UserID}
+	for _, member := range members {
+		if member.UserID != logType.UserID {
+			ids = append(ids, member.UserID)
+		}
+	}
+	return ids, nil
+}
+
+// listLogTypeEntries lists a log type's entries across its members, or
+// only query.UserID's when set. The date filters of query apply too.
+func (h *PuzzleHub) listLogTypeEntries(logType *LogType, query LogEntryQuery) ([]LogEntry, error) {
+	memberIDs, err := h.logTypeMemberIDs(logType)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []LogEntry{}
+	for _, memberID := range memberIDs {
+		if query.UserID != "" && query.UserID != memberID {
+			continue
+		}
+		memberQuery := query
+		memberQuery.UserID = memberID
+		memberQuery.LogTypeID = logType.ID
+		memberEntries, err := h.Store.ListLogEntries(memberQuery)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, memberEntries...)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].EntryDate != entries[j].EntryDate {
+			return entries[i].EntryDate < entries[j].EntryDate
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// allLogTypeEntries returns every entry counted in a log type's aggregates
+func (h *PuzzleHub) allLogTypeEntries(userID, logTypeID string) ([]LogEntry, error) {
+	logType, err := h.Store.GetLogType(logTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if logType == nil || logType.HouseholdID == "" {
+		return h.getUserLogEntries(userID, logTypeID)
+	}
+	return h.listLogTypeEntries(logType, LogEntryQuery{})
+}
+
+// sharedLogTypesFor returns the log types other members shared with the
+// user's household
+func (h *PuzzleHub) sharedLogTypesFor(userID string) ([]LogType, error) {
+	membership, err := h.getHouseholdMembership(userID)
+	if err != nil || membership == nil {
+		return nil, err
+	}
+	members, err := h.getHouseholdMembers(membership.HouseholdID)
+	if err != nil {
+		return nil, err
+	}
+
+	var others []HouseholdMember
+	for _, member := range members {
+		if member.UserID != userID {
+			others = append(others, member)
+		}
+	}
+	return h.householdLogTypes(membership.HouseholdID, others)
+}
+
+// householdLogTypes returns the log types the members shared with the
+// household
+func (h *PuzzleHub) householdLogTypes(householdID string, members []HouseholdMember) ([]LogType, error) {
+	logTypes := []LogType{}
+	for _, member := range members {
+		owned, err := h.Store.ListLogTypes(member.UserID)
+		if err != nil {
+			return nil, err
+		}
+		for _, logType := range owned {
+			if logType.HouseholdID == householdID {
+				logTypes = append(logTypes, logType)
+			}
+		}
+	}
+	return logTypes, nil
+}
+
+// unshareHouseholdLogTypes stops sharing the members' log types with the
+// household. Failures are logged so one bad log type doesn't stop the rest.
+func (h *PuzzleHub) unshareHouseholdLogTypes(householdID string, members []HouseholdMember) {
+	logTypes, err := h.householdLogTypes(householdID, members)
+	if err != nil {
+		log.Printf("Error fetching log types shared with %s: %v", householdID, err)
+		return
+	}
+	for i := range logTypes {
+		logType := &logTypes[i]
+		logType.HouseholdID = ""
+		logType.UpdatedAt = time.Now()
+		if err := h.Store.PutLogType(logType); err != nil {
+			log.Printf("Error unsharing log type %s: %v", logType.ID, err)
+			continue
+		}
+		if err := h.rebuildLogAggregates(logType.UserID, logType.ID); err != nil {
+			log.Printf("Error rebuilding aggregates for %s: %v", logType.ID, err)
+		}
+	}
+}
+
+// rebuildHouseholdAggregates recounts the log types shared with the
+// household after its members change
+func (h *PuzzleHub) rebuildHouseholdAggregates(householdID string) {
+	members, err := h.getHouseholdMembers(householdID)
+	var logTypes []LogType
+	if err == nil {
+		logTypes, err = h.householdLogTypes(householdID, members)
+	}
+	if err != nil {
+		log.Printf("Error fetching log types shared with %s: %v", householdID, err)
+		return
+	}
+	for _, logType := range logTypes {
+		if err := h.rebuildLogAggregates(logType.UserID, logType.ID); err != nil {
+			log.Printf("Error rebuilding aggregates for %s: %v", logType.ID, err)
+		}
+	}
+}
+
+// requireHousehold loads the user's household and its members, responding
+// with 404 when they aren't in one
+func (h *PuzzleHub) requireHousehold(c *gin.Context, userID string) (*Household, []HouseholdMember, bool) {
+	membership, err := h.getHouseholdMembership(userID)
+	var household *Household
+	if err == nil && membership != nil {
+		household, err = h.getHouseholdByID(membership.HouseholdID)
+	}
+	if err != nil {
+		requestLogger(c).Error("error fetching household", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to fetch household")
+		return nil, nil, false
+	}
+	if household == nil {
+		respondError(c, http.StatusNotFound, "You are not in a household")
+		return nil, nil, false
+	}
+
+	members, err := h.getHouseholdMembers(household.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching members", "error", err, "household_id", household.ID)
+		respondStorageError(c, err, "Failed to fetch household")
+		return nil, nil, false
+	}
+	if household.OwnerID != userID {
+		household.InviteCode = ""
+	}
+	return household, members, true
+}
+
+func (h *PuzzleHub) requireHouseholdOwner(c *gin.Context, userID string) (*Household, bool) {
+	household, _, ok := h.requireHousehold(c, userID)
+	if !ok {
+		return nil, false
+	}
+	if household.OwnerID != userID {
+		respondError(c, http.StatusForbidden, "Only the household owner can do that")
+		return nil, false
+	}
+	return household, true
+}
+
+func (h *PuzzleHub) getHouseholdByID(householdID string) (*Household, error) {
+	result, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-households")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(householdID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var household Household
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &household); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal household: %v", err)
+	}
+	return &household, nil
+}
+
+func (h *PuzzleHub) putHousehold(household *Household) error {
+	item, err := dynamodbattribute.MarshalMap(household)
+	if err != nil {
+		return fmt.Errorf("failed to marshal household: %v", err)
+	}
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-households")),
+		Item:      item,
+	})
+	return err
+}
+
+func (h *PuzzleHub) getHouseholdByInviteCode(code string) (*Household, error) {
+	households, err := queryLimit[Household](context.Background(), h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-households")),
+		IndexName:              aws.String("invite_code-index"),
+		KeyConditionExpression: aws.String("invite_code = :invite_code"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":invite_code": {S: aws.String(code)},
+		},
+	}, 1)
+	if err != nil || len(households) == 0 {
+		return nil, err
+	}
+	return &households[0], nil
+}
+
+func (h *PuzzleHub) addHouseholdMember(household *Household, user *User, role string) error {
+	item, err := dynamodbattribute.MarshalMap(HouseholdMember{
+		HouseholdID: household.ID,
+		UserID:      user.ID,
+		Name:        user.Name,
+		Role:        role,
+		JoinedAt:    time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal household member: %v", err)
+	}
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-household-members")),
+		Item:      item,
+	})
+	return err
+}
+
+func (h *PuzzleHub) deleteHouseholdMember(householdID, userID string) error {
+	_, err := h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-household-members")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"household_id": {S: aws.String(householdID)},
+			"user_id":      {S: aws.String(userID)},
+		},
+	})
+	return err
+}
+
+// getHouseholdMember returns nil without error when the user isn't a member
+func (h *PuzzleHub) getHouseholdMember(householdID, userID string) (*HouseholdMember, error) {
+	result, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-household-members")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"household_id": {S: aws.String(householdID)},
+			"user_id":      {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var member HouseholdMember
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &member); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal household member: %v", err)
+	}
+	return &member, nil
+}
+
+func (h *PuzzleHub) getHouseholdMembers(householdID string) ([]HouseholdMember, error) {
+	members, err := queryAll[HouseholdMember](h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-household-members")),
+		KeyConditionExpression: aws.String("household_id = :household_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":household_id": {S: aws.String(householdID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+	return members, nil
+}
+
+// getHouseholdMembership returns the user's membership, or nil when they
+// aren't in a household
+func (h *PuzzleHub) getHouseholdMembership(userID string) (*HouseholdMember, error) {
+	members, err := queryLimit[HouseholdMember](context.Background(), h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-household-members")),
+		IndexName:              aws.String("user_id-index"),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	}, 1)
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+	return &members[0], nil
+}
+
+// newUniqueInviteCode draws join codes until one isn't already in use
+func (h *PuzzleHub) newUniqueInviteCode() (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := randomJoinCode()
+		if err != nil {
+			return "", err
+		}
+
+		existing, err := h.getHouseholdByInviteCode(code)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("could not find an unused invite code")
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// Response compression and ETags
+//
+// Text responses (JSON, HTML, CSS, JS, CSV, SVG) of at least
+// minCompressBytes are compressed with brotli or gzip, whichever the
+// client's Accept-Encoding prefers, brotli winning ties. Server-sent event
+// streams and WebSocket upgrades are left alone.
+//
+// GET API responses get a weak ETag hashed from the body. A request whose
+// If-None-Match matches gets an empty 304, so a client polling unchanged
+// analytics or a log export only pays for the headers. The ETag is weak
+// because the same body may be sent compressed or not.
+
+const minCompressBytes = 1024
+
+var compressibleTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+	"text/",
+}
+
+var (
+	gzipWriters   = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(io.Discard, 5) }}
+)
+
+// compressionMiddleware compresses responses for clients that accept it
+func compressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead || c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		writer := &compressWriter{ResponseWriter: original, encoding: encoding}
+		c.Writer = writer
+		defer func() {
+			writer.finish()
+			c.Writer = original
+		}()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header, or ""
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "br" && name != "gzip" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ || (q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+func isCompressible(contentType string) bool {
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter holds back the first minCompressBytes so small responses
+// go out as they are, then streams the rest through the encoder
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	buffer   []byte
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || !isCompressible(header.Get("Content-Type")) {
+		w.decided = true
+		return w.ResponseWriter.Write(data)
+	}
+	w.buffer = append(w.buffer, data...)
+	if len(w.buffer) >= minCompressBytes {
+		if err := w.startEncoder(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Written() bool {
+	return w.ResponseWriter.Written() || len(w.buffer) > 0
+}
+
+// Flush sends what is buffered, compressed if it can be, so streamed
+// responses aren't held back
+func (w *compressWriter) Flush() {
+	if !w.decided && len(w.buffer) > 0 {
+		if err := w.startEncoder(); err != nil {
+			return
+		}
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// startEncoder switches to compressed output and writes the buffer to it
+func (w *compressWriter) startEncoder() error {
+	w.decided = true
+	header := w.Header()
+	header.Set("Content-Encoding", w.encoding)
+	header.Add("Vary", "Accept-Encoding")
+	header.Del("Content-Length")
+
+	switch w.encoding {
+	case "br":
+		encoder := brotliWriters.Get().(*brotli.Writer)
+		encoder.Reset(w.ResponseWriter)
+		w.encoder = encoder
+	default:
+		encoder := gzipWriters.Get().(*gzip.Writer)
+		encoder.Reset(w.ResponseWriter)
+		w.encoder = encoder
+	}
+
+	buffered := w.buffer
+	w.buffer = nil
+	_, err := w.encoder.Write(buffered)
+	return err
+}
+
+// finish writes a short buffered response as is, or closes the encoder
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decided = true
+		if len(w.buffer) > 0 {
+			w.Header().Add("Vary", "Accept-Encoding")
+			w.ResponseWriter.Write(w.buffer)
+			w.buffer = nil
+		}
+		return
+	}
+	if w.encoder == nil {
+		return
+	}
+	w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *brotli.Writer:
+		encoder.Reset(io.Discard)
+		brotliWriters.Put(encoder)
+	case *gzip.Writer:
+		encoder.Reset(io.Discard)
+		gzipWriters.Put(encoder)
+	}
+	w.encoder = nil
+}
+
+// etagMiddleware adds a weak ETag to successful GET responses and answers
+// 304 when the client already has that version
+func etagMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet || c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		writer := &etagWriter{ResponseWriter: original}
+		c.Writer = writer
+		c.Next()
+		c.Writer = original
+
+		if writer.streamed {
+			return
+		}
+		if writer.Status() != http.StatusOK {
+			original.Write(writer.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(writer.body.Bytes())
+		etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		header := original.Header()
+		header.Set("ETag", etag)
+		if header.Get("Cache-Control") == "" {
+			header.Set("Cache-Control", "private, no-cache")
+		}
+
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+			original.WriteHeader(http.StatusNotModified)
+			original.WriteHeaderNow()
+			return
+		}
+		original.Write(writer.body.Bytes())
+	}
+}
+
+// etagMatches compares weakly, as If-None-Match requires
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter buffers the body until the handler is done
+type etagWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	streamed bool // Flushed, so writes go straight through
+}
+
+func (w *etagWriter) Write(data []byte) (int, error) {
+	if w.streamed {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.body.Write(data)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *etagWriter) Written() bool {
+	return w.ResponseWriter.Written() || w.body.Len() > 0
+}
+
+func (w *etagWriter) Size() int {
+	if w.streamed {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+// WriteHeaderNow is held back too, since the status may become 304
+func (w *etagWriter) WriteHeaderNow() {
+	if w.streamed {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// Flush gives up on the ETag: streamed bodies go straight out
+func (w *etagWriter) Flush() {
+	if !w.streamed {
+		w.streamed = true
+		w.ResponseWriter.Write(w.body.Bytes())
+		w.body.Reset()
+	}
+	w.ResponseWriter.Flush()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORS and security headers. The web app is served from the same origin
+// as the API, so cross-origin access is off unless CORS_ALLOWED_ORIGINS
+// lists the origins of a separate frontend or app, e.g.
+// "https://app.example.com,http://localhost:5173". Clients authenticate
+// with bearer tokens, so credentialed (cookie) requests are not allowed.
+//
+// HTML pages get a Content-Security-Policy limited to the CDNs they load
+// Bootstrap and Font Awesome from, and can't be framed. HSTS is only sent
+// when BASE_URL is https, so local http development keeps working.
+
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " + // Inline handlers in the templates
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://cdnjs.cloudflare.com; " +
+	"font-src 'self' data: https://cdnjs.cloudflare.com; " +
+	"img-src 'self' data: https:; " + // Google profile pictures
+	"connect-src 'self'; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// corsAllowedOrigins parses CORS_ALLOWED_ORIGINS, "*" allowing any origin
+func corsAllowedOrigins() map[string]bool {
+	origins := make(map[string]bool)
+	for _, entry := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		entry = strings.TrimRight(strings.TrimSpace(entry), "/")
+		if entry == "" {
+			continue
+		}
+		if entry != "*" {
+			if parsed, err := url.Parse(entry); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				log.Printf("⚠️  Ignoring invalid CORS_ALLOWED_ORIGINS entry %q", entry)
+				continue
+			}
+		}
+		origins[entry] = true
+	}
+	return origins
+}
+
+// corsMiddleware answers preflight requests and sets the CORS headers on
+// /api and /auth responses for allowed origins. It runs globally, because a
+// preflight OPTIONS request has no route of its own.
+func corsMiddleware() gin.HandlerFunc {
+	allowed := corsAllowedOrigins()
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		origin := c.GetHeader("Origin")
+		if len(allowed) == 0 || origin == "" || !(strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/auth/")) {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+		if !allowed["*"] && !allowed[origin] {
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID, Retry-After, Deprecation, Link, X-AI-Override")
+
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID, X-AI-Provider, X-AI-Model")
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// securityHeadersMiddleware sets the headers every response gets
+func (h *PuzzleHub) securityHeadersMiddleware() gin.HandlerFunc {
+	hsts := h.AuthConfig != nil && h.AuthConfig.SecureCookies
+	return func(c *gin.Context) {
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("Referrer-Policy", "strict-origin-when-cross-origin")
+		if hsts {
+			c.Header("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		c.Next()
+	}
+}
+
+// htmlSecurityHeaders adds the page-only headers to HTML routes
+func htmlSecurityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Security-Policy", contentSecurityPolicy)
+		c.Header("X-Frame-Options", "DENY")
+		c.Next()
+	}
+}
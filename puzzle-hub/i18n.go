@@ -0,0 +1,174 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Localization
+//
+// API-facing text (error messages, hints, encouragement, notifications and
+// emails) is written in English in the code, and the English text is the
+// key into each language's message catalog. Catalogs live in
+// locales/<language>.json and are compiled into the binary; a message a
+// catalog doesn't have is sent in English, so new strings never break a
+// language, they just wait for a translation. Formatted messages are looked
+// up by their format string and may reorder arguments with %[n]d.
+//
+// A request's language is the user's language preference when signed in,
+// otherwise the best match for the Accept-Language header. Mail and
+// notifications use the recipient's preference.
+
+const defaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// languageNames are the supported languages, each in its own language
+var languageNames = map[string]string{
+	"en": "English",
+	"es": "Español",
+	"hi": "हिन्दी",
+}
+
+// messageCatalogs maps language to English message to translation
+var messageCatalogs = loadMessageCatalogs()
+
+func loadMessageCatalogs() map[string]map[string]string {
+	catalogs := map[string]map[string]string{}
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		log.Fatalf("Failed to read message catalogs: %v", err)
+	}
+	for _, file := range files {
+		language := strings.TrimSuffix(file.Name(), ".json")
+		if languageNames[language] == "" {
+			log.Fatalf("Message catalog %s has no entry in languageNames", file.Name())
+		}
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			log.Fatalf("Failed to read message catalog %s: %v", file.Name(), err)
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			log.Fatalf("Invalid message catalog %s: %v", file.Name(), err)
+		}
+		catalogs[language] = catalog
+	}
+	return catalogs
+}
+
+// translate returns message in language, or message itself when there is
+// no translation
+func translate(language, message string) string {
+	if translated, ok := messageCatalogs[language][message]; ok && translated != "" {
+		return translated
+	}
+	return message
+}
+
+// localize translates format and fills in args
+func localize(language, format string, args ...interface{}) string {
+	if len(args) == 0 {
+		return translate(language, format)
+	}
+	return fmt.Sprintf(translate(language, format), args...)
+}
+
+// localizeCount picks the singular or plural format for n and fills it in
+func localizeCount(language string, n int, singular, plural string) string {
+	if n == 1 {
+		return localize(language, singular, n)
+	}
+	return localize(language, plural, n)
+}
+
+// localizeDate formats a day as "Monday, January 2" in language
+func localizeDate(language string, t time.Time) string {
+	return localize(language, "%[1]s, %[2]s %[3]d", translate(language, t.Weekday().String()), translate(language, t.Month().String()), t.Day())
+}
+
+// tr localizes a message for the request's language
+func tr(c *gin.Context, format string, args ...interface{}) string {
+	return localize(requestLanguage(c), format, args...)
+}
+
+// requestLanguage is the signed-in user's preferred language, falling back
+// to the Accept-Language header
+func requestLanguage(c *gin.Context) string {
+	if user, ok := c.Get("user"); ok {
+		if userObj, ok := user.(*User); ok && userObj.Language != "" {
+			return userObj.Language
+		}
+	}
+	return negotiateLanguage(c.GetHeader("Accept-Language"))
+}
+
+// negotiateLanguage picks the supported language the Accept-Language
+// header ranks highest, matching regional variants (es-MX) to the base
+// language
+func negotiateLanguage(header string) string {
+	best, bestQuality := defaultLanguage, 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if languageNames[base] != "" && quality > bestQuality {
+			best, bestQuality = base, quality
+		}
+	}
+	return best
+}
+
+// savedLanguage returns the language from the user's preferences, or ""
+// when they haven't picked one
+func (h *PuzzleHub) savedLanguage(userID string) string {
+	prefs, err := h.getUserPreferences(userID)
+	if err != nil {
+		log.Printf("Error fetching preferences for %s: %v", userID, err)
+		return ""
+	}
+	return prefs.Language
+}
+
+// recipientLanguage is the language to write to a user in when they aren't
+// the one making the request
+func (h *PuzzleHub) recipientLanguage(userID string) string {
+	if language := h.savedLanguage(userID); language != "" {
+		return language
+	}
+	return defaultLanguage
+}
+
+// listLanguages returns the supported languages and the one this request
+// would be answered in
+func (h *PuzzleHub) listLanguages(c *gin.Context) {
+	languages := make([]gin.H, 0, len(languageNames))
+	for code, name := range languageNames {
+		languages = append(languages, gin.H{"code": code, "name": name})
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		return languages[i]["code"].(string) < languages[j]["code"].(string)
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"languages": languages,
+		"current":   requestLanguage(c),
+	})
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Background jobs
+//
+// Scheduled jobs run on a cron spec (minute hour day-of-month month
+// day-of-week, UTC, or @hourly/@daily/@weekly). Every instance runs the
+// scheduler, but each run is claimed in the job-runs table first, so a
+// slot runs on one instance only.
+//
+// Async jobs go through a queue kept in Storage. A worker takes a job by
+// pushing its visible_at forward by the visibility timeout; if the worker
+// dies the job becomes visible again and another instance retries it.
+// Finished jobs are deleted, and jobs that fail maxJobAttempts times are
+// kept as "failed" for a week so admins can look at them.
+//
+// Modules register with hub.Jobs: Schedule for periodic work and Handle
+// plus Enqueue for async work.
+
+const (
+	jobPollInterval        = 5 * time.Second
+	jobVisibilityTimeout   = 5 * time.Minute
+	jobWorkers             = 2
+	maxJobAttempts         = 5
+	failedJobRetention     = 7 * 24 * time.Hour
+	scheduledRunRetention  = 30 * 24 * time.Hour
+	jobStateQueued         = "queued"
+	jobStateFailed         = "failed"
+	runScheduledJobHandler = "run-scheduled"
+)
+
+// Job is one queued unit of async work
+type Job struct {
+	ID        string    `json:"id" dynamodbav:"job_id"`
+	Kind      string    `json:"kind" dynamodbav:"kind"`
+	Payload   string    `json:"payload" dynamodbav:"payload"` // JSON
+	State     string    `json:"state" dynamodbav:"state"`
+	VisibleAt int64     `json:"visible_at" dynamodbav:"visible_at"` // Unix seconds
+	Attempts  int       `json:"attempts" dynamodbav:"attempts"`
+	LastError string    `json:"last_error,omitempty" dynamodbav:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt int64     `json:"-" dynamodbav:"expires_at,omitempty"`
+}
+
+// JobHandler processes one async job's payload
+type JobHandler func(ctx context.Context, payload json.RawMessage) error
+
+// ScheduledJob is a periodic job
+type ScheduledJob struct {
+	Name       string
+	Spec       string // Cron spec, UTC
+	RunOnStart bool   // Also run once at startup, for jobs that check their own period
+	Run        func(ctx context.Context) error
+
+	schedule *cronSchedule
+}
+
+// JobRunner holds the scheduled jobs and queue handlers
+type JobRunner struct {
+	store JobStore
+
+	mu        sync.Mutex
+	scheduled map[string]*ScheduledJob
+	handlers  map[string]JobHandler
+	lastRuns  map[string]time.Time
+}
+
+func NewJobRunner(store JobStore) *JobRunner {
+	r := &JobRunner{
+		store:     store,
+		scheduled: make(map[string]*ScheduledJob),
+		handlers:  make(map[string]JobHandler),
+		lastRuns:  make(map[string]time.Time),
+	}
+	r.Handle(runScheduledJobHandler, r.runScheduledNow)
+	return r
+}
+
+// Schedule registers a periodic job. It panics on an invalid spec, which is
+// a programming error.
+func (r *JobRunner) Schedule(job ScheduledJob) {
+	schedule, err := parseCron(job.Spec)
+	if err != nil {
+		panic(fmt.Sprintf("job %s: %v", job.Name, err))
+	}
+	job.schedule = schedule
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scheduled[job.Name] = &job
+}
+
+// Handle registers the handler for a kind of async job
+func (r *JobRunner) Handle(kind string, handler JobHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = handler
+}
+
+// Enqueue adds an async job, to run no sooner than delay from now
+func (r *JobRunner) Enqueue(ctx context.Context, kind string, payload interface{}, delay time.Duration) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode job payload: %v", err)
+	}
+	id, err := randomToken(12)
+	if err != nil {
+		return "", err
+	}
+
+	job := Job{
+		ID:        id,
+		Kind:      kind,
+		Payload:   string(data),
+		State:     jobStateQueued,
+		VisibleAt: time.Now().Add(delay).Unix(),
+		CreatedAt: time.Now(),
+	}
+	if err := r.store.PutJob(&job); err != nil {
+		return "", fmt.Errorf("failed to enqueue %s job: %w", kind, err)
+	}
+	return id, nil
+}
+
+// Run starts the scheduler and queue workers until ctx is done
+func (r *JobRunner) Run(ctx context.Context) {
+	r.mu.Lock()
+	for _, job := range r.scheduled {
+		if job.RunOnStart {
+			go r.runScheduled(ctx, job)
+		}
+	}
+	r.mu.Unlock()
+
+	go r.runScheduler(ctx)
+	for i := 0; i < jobWorkers; i++ {
+		go r.runWorker(ctx)
+	}
+}
+
+// runScheduler wakes at the start of every minute and starts the jobs due
+func (r *JobRunner) runScheduler(ctx context.Context) {
+	for {
+		now := time.Now().UTC()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+
+		r.mu.Lock()
+		var due []*ScheduledJob
+		for _, job := range r.scheduled {
+			if job.schedule.matches(next) {
+				due = append(due, job)
+			}
+		}
+		r.mu.Unlock()
+
+		for _, job := range due {
+			slot := next.Format("2006-01-02T15:04")
+			claimed, err := r.claimScheduledRun(job.Name, slot)
+			if err != nil {
+				log.Printf("❌ Job %s: failed to claim %s: %v", job.Name, slot, err)
+				continue
+			}
+			if claimed {
+				go r.runScheduled(ctx, job)
+			}
+		}
+	}
+}
+
+func (r *JobRunner) runScheduled(ctx context.Context, job *ScheduledJob) {
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		log.Printf("❌ Job %s failed after %s: %v", job.Name, time.Since(start).Round(time.Millisecond), err)
+	}
+	r.mu.Lock()
+	r.lastRuns[job.Name] = start
+	r.mu.Unlock()
+}
+
+// claimScheduledRun claims one cron slot of a job across instances. Claims
+// expire, unlike the per-period claims jobs make for themselves.
+func (r *JobRunner) claimScheduledRun(name, slot string) (bool, error) {
+	return r.store.ClaimJobRun("cron:"+name, slot, time.Now().Add(scheduledRunRetention).Unix())
+}
+
+// runScheduledNow is the handler behind the admin "run now" action
+func (r *JobRunner) runScheduledNow(ctx context.Context, payload json.RawMessage) error {
+	var name string
+	if err := json.Unmarshal(payload, &name); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	job, ok := r.scheduled[name]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no scheduled job %q", name)
+	}
+	r.runScheduled(ctx, job)
+	return nil
+}
+
+// runWorker polls the queue and runs one job at a time
+func (r *JobRunner) runWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		for r.processNextJob(ctx) {
+			// Keep going while there is work
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNextJob takes and runs one visible job, reporting whether it found one
+func (r *JobRunner) processNextJob(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	now := time.Now().Unix()
+	jobs, err := r.store.ListVisibleJobs(now, 5)
+	if err != nil {
+		log.Printf("Error polling job queue: %v", err)
+		return false
+	}
+
+	for _, job := range jobs {
+		if !r.leaseJob(&job, now) {
+			continue // Another worker got it first
+		}
+		r.runJob(ctx, job)
+		return true
+	}
+	return false
+}
+
+// leaseJob hides the job from other workers for the visibility timeout
+func (r *JobRunner) leaseJob(job *Job, now int64) bool {
+	err := r.store.LeaseJob(job.ID, job.VisibleAt, now+int64(jobVisibilityTimeout.Seconds()))
+	if err != nil {
+		if !errors.Is(err, errStorageConflict) {
+			log.Printf("Error leasing job %s: %v", job.ID, err)
+		}
+		return false
+	}
+	job.Attempts++
+	return true
+}
+
+func (r *JobRunner) runJob(ctx context.Context, job Job) {
+	r.mu.Lock()
+	handler, ok := r.handlers[job.Kind]
+	r.mu.Unlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler for job kind %q", job.Kind)
+	} else {
+		jobCtx, cancel := context.WithTimeout(ctx, jobVisibilityTimeout)
+		err = handler(jobCtx, json.RawMessage(job.Payload))
+		cancel()
+	}
+
+	if err == nil {
+		if err := r.store.DeleteJob(job.ID); err != nil {
+			log.Printf("Error deleting finished job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	log.Printf("❌ Job %s (%s) attempt %d failed: %v", job.ID, job.Kind, job.Attempts, err)
+	if job.Attempts >= maxJobAttempts || !ok {
+		err = r.store.FailJob(job.ID, err.Error(), time.Now().Add(failedJobRetention).Unix())
+	} else {
+		// Retry with exponential backoff: 30s, 1m, 2m, 4m...
+		backoff := 30 * time.Second << (job.Attempts - 1)
+		err = r.store.RetryJob(job.ID, err.Error(), time.Now().Add(backoff).Unix())
+	}
+	if err != nil {
+		log.Printf("Error recording failure of job %s: %v", job.ID, err)
+	}
+}
+
+// failedJobs lists jobs that ran out of attempts
+func (r *JobRunner) failedJobs() ([]Job, error) {
+	return r.store.ListFailedJobs(100)
+}
+
+// adminListJobs shows the scheduled jobs and the failed queue jobs
+func (h *PuzzleHub) adminListJobs(c *gin.Context) {
+	now := time.Now().UTC()
+	h.Jobs.mu.Lock()
+	scheduled := make([]gin.H, 0, len(h.Jobs.scheduled))
+	for _, job := range h.Jobs.scheduled {
+		entry := gin.H{
+			"name":     job.Name,
+			"spec":     job.Spec,
+			"next_run": job.schedule.next(now),
+		}
+		if last, ok := h.Jobs.lastRuns[job.Name]; ok {
+			entry["last_run_here"] = last
+		}
+		scheduled = append(scheduled, entry)
+	}
+	h.Jobs.mu.Unlock()
+	sort.Slice(scheduled, func(i, j int) bool {
+		return scheduled[i]["name"].(string) < scheduled[j]["name"].(string)
+	})
+
+	failed, err := h.Jobs.failedJobs()
+	if err != nil {
+		requestLogger(c).Error("error listing failed jobs", "error", err)
+		respondStorageError(c, err, "Failed to list failed jobs")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"scheduled": scheduled,
+		"failed":    failed,
+	})
+}
+
+// adminRunJob queues a scheduled job to run now
+func (h *PuzzleHub) adminRunJob(c *gin.Context) {
+	name := c.Param("name")
+	h.Jobs.mu.Lock()
+	_, ok := h.Jobs.scheduled[name]
+	h.Jobs.mu.Unlock()
+	if !ok {
+		respondError(c, http.StatusNotFound, "Scheduled job not found")
+		return
+	}
+
+	id, err := h.Jobs.Enqueue(c.Request.Context(), runScheduledJobHandler, name, 0)
+	if err != nil {
+		requestLogger(c).Error("error queueing job", "error", err, "name", name)
+		respondStorageError(c, err, "Failed to queue job")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job queued", "job_id": id})
+}
+
+// cronSchedule is a parsed five-field cron spec, one bit per allowed value
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 1",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseCron parses "minute hour day-of-month month day-of-week", each field
+// being *, a value, a range a-b, a list, or any of those with a /step.
+// Day of week is 0-6 from Sunday.
+func parseCron(spec string) (*cronSchedule, error) {
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q needs 5 fields", spec)
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	var bits [5]uint64
+	for i, field := range fields {
+		for _, part := range strings.Split(field, ",") {
+			rangePart, stepPart, hasStep := strings.Cut(part, "/")
+			step := 1
+			if hasStep {
+				var err error
+				if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+					return nil, fmt.Errorf("cron spec %q: invalid step %q", spec, stepPart)
+				}
+			}
+
+			low, high := bounds[i][0], bounds[i][1]
+			if rangePart != "*" {
+				from, to, isRange := strings.Cut(rangePart, "-")
+				var err1, err2 error
+				low, err1 = strconv.Atoi(from)
+				high = low
+				if isRange {
+					high, err2 = strconv.Atoi(to)
+				} else if hasStep {
+					high = bounds[i][1]
+				}
+				if err1 != nil || err2 != nil || low < bounds[i][0] || high > bounds[i][1] || low > high {
+					return nil, fmt.Errorf("cron spec %q: invalid field %q", spec, part)
+				}
+			}
+			for v := low; v <= high; v += step {
+				bits[i] |= 1 << uint(v)
+			}
+		}
+	}
+	return &cronSchedule{minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4]}, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.minute&(1<<uint(t.Minute())) != 0 &&
+		s.hour&(1<<uint(t.Hour())) != 0 &&
+		s.dom&(1<<uint(t.Day())) != 0 &&
+		s.month&(1<<uint(t.Month())) != 0 &&
+		s.dow&(1<<uint(t.Weekday())) != 0
+}
+
+// next returns the first matching minute after t, searching up to a year
+func (s *cronSchedule) next(t time.Time) time.Time {
+	candidate := t.Truncate(time.Minute).Add(time.Minute)
+	for limit := candidate.AddDate(1, 0, 0); candidate.Before(limit); candidate = candidate.Add(time.Minute) {
+		if s.matches(candidate) {
+			return candidate
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// JWT signing keys
+//
+// Tokens carry a "kid" header naming the key that signed them. One key is
+// active for signing; any other configured key is accepted for verification
+// only. To rotate: add the new key, switch the active key ID, and remove the
+// old key once access tokens signed with it have expired (accessTokenTTL,
+// one hour).
+//
+// Keys are loaded from, in order of precedence:
+//   - JWT_SECRET_ID: an AWS Secrets Manager secret holding
+//     {"active_kid": "2025-06", "keys": {"2025-06": "...", "2025-01": "..."}},
+//     re-read periodically so rotations apply without a restart
+//   - JWT_SIGNING_KEYS: "kid:secret,kid:secret" with JWT_ACTIVE_KEY_ID
+//     selecting the signing key (defaults to the first entry)
+//   - JWT_SECRET: a single key with kid "default"
+//
+// With none set, a random key is generated and tokens won't survive a restart.
+
+const minJWTSecretLength = 32
+
+type JWTKeySet struct {
+	mu        sync.RWMutex
+	activeKID string
+	keys      map[string][]byte
+	secretID  string // Secrets Manager secret to reload from, if any
+}
+
+type jwtSecretPayload struct {
+	ActiveKID string            `json:"active_kid"`
+	Keys      map[string]string `json:"keys"`
+}
+
+func loadJWTKeys() (*JWTKeySet, error) {
+	if secretID := os.Getenv("JWT_SECRET_ID"); secretID != "" {
+		keySet := &JWTKeySet{secretID: secretID}
+		if err := keySet.reloadFromSecretsManager(); err != nil {
+			return nil, err
+		}
+		return keySet, nil
+	}
+
+	if signingKeys := os.Getenv("JWT_SIGNING_KEYS"); signingKeys != "" {
+		payload := jwtSecretPayload{
+			ActiveKID: os.Getenv("JWT_ACTIVE_KEY_ID"),
+			Keys:      make(map[string]string),
+		}
+		for _, pair := range strings.Split(signingKeys, ",") {
+			kid, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok || kid == "" {
+				return nil, fmt.Errorf("JWT_SIGNING_KEYS entries must be kid:secret")
+			}
+			if payload.ActiveKID == "" {
+				payload.ActiveKID = kid
+			}
+			payload.Keys[kid] = secret
+		}
+
+		keySet := &JWTKeySet{}
+		if err := keySet.apply(payload); err != nil {
+			return nil, err
+		}
+		return keySet, nil
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keySet := &JWTKeySet{}
+		if err := keySet.apply(jwtSecretPayload{
+			ActiveKID: "default",
+			Keys:      map[string]string{"default": secret},
+		}); err != nil {
+			return nil, err
+		}
+		return keySet, nil
+	}
+
+	log.Println("⚠️  No JWT_SECRET, JWT_SIGNING_KEYS or JWT_SECRET_ID set; using a random key (tokens will not survive a restart)")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate JWT secret: %v", err)
+	}
+	kid := "ephemeral-" + hex.EncodeToString(secret[:4])
+	return &JWTKeySet{
+		activeKID: kid,
+		keys:      map[string][]byte{kid: secret},
+	}, nil
+}
+
+// apply validates a key payload and swaps it in
+func (k *JWTKeySet) apply(payload jwtSecretPayload) error {
+	if len(payload.Keys) == 0 {
+		return fmt.Errorf("no JWT signing keys configured")
+	}
+
+	keys := make(map[string][]byte, len(payload.Keys))
+	for kid, secret := range payload.Keys {
+		if len(secret) < minJWTSecretLength {
+			return fmt.Errorf("JWT key %q must be at least %d characters", kid, minJWTSecretLength)
+		}
+		keys[kid] = []byte(secret)
+	}
+	if _, ok := keys[payload.ActiveKID]; !ok {
+		return fmt.Errorf("active JWT key %q is not among the configured keys", payload.ActiveKID)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.activeKID = payload.ActiveKID
+	k.keys = keys
+	return nil
+}
+
+// signingKey returns the active key ID and secret
+func (k *JWTKeySet) signingKey() (string, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activeKID, k.keys[k.activeKID]
+}
+
+// verificationKey returns the secret for a token's kid. Tokens issued before
+// key IDs were introduced have no kid and are checked against the active key.
+func (k *JWTKeySet) verificationKey(kid string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if kid == "" {
+		kid = k.activeKID
+	}
+	secret, ok := k.keys[kid]
+	return secret, ok
+}
+
+func (k *JWTKeySet) keyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (k *JWTKeySet) reloadFromSecretsManager() error {
+	sess, err := newAWSSession()
+	if err != nil {
+		return err
+	}
+
+	result, err := secretsmanager.New(sess).GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(k.secretID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read JWT secret %s: %v", k.secretID, err)
+	}
+
+	var payload jwtSecretPayload
+	if err := json.Unmarshal([]byte(aws.StringValue(result.SecretString)), &payload); err != nil {
+		return fmt.Errorf("failed to parse JWT secret %s: %v", k.secretID, err)
+	}
+	return k.apply(payload)
+}
+
+// runJWTKeyRefresh picks up rotations made in Secrets Manager
+func (k *JWTKeySet) runJWTKeyRefresh(interval time.Duration) {
+	if k.secretID == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		previous, _ := k.signingKey()
+		if err := k.reloadFromSecretsManager(); err != nil {
+			log.Printf("❌ Failed to refresh JWT keys: %v", err)
+			continue
+		}
+		if active, _ := k.signingKey(); active != previous {
+			log.Printf("🔑 JWT signing key rotated from %s to %s", previous, active)
+		}
+	}
+}
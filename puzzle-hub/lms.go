@@ -0,0 +1,846 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// LMS integrations
+//
+// Teachers who keep grades in another system can link a classroom to it,
+// and results are pushed there as they happen. A classroom has at most one
+// integration:
+//
+//	google_classroom  each assignment becomes an assignment in the linked
+//	                  Google Classroom course, and a student's average
+//	                  score (out of 100) is set as their grade when they
+//	                  complete it. A numeric teacher grade ("85", "85%")
+//	                  replaces it; other grades, comments and writing
+//	                  feedback stay here, since Google's API can't post
+//	                  comments. Students are matched by email.
+//	webhook           events are POSTed as JSON to the teacher's https URL,
+//	                  signed with X-PuzzleHub-Signature: sha256=<hex HMAC of
+//	                  the body with the secret shown when linking>
+//
+// Webhook events, all by default:
+//
+//	assignment.completed  a student finished an assignment
+//	assignment.graded     the teacher graded a student
+//	writing.feedback      a student's writing was analyzed (the rating and
+//	                      summary, never the text)
+//
+// Google Classroom is linked per classroom with the teacher's own Google
+// account: POST /classrooms/:id/integration/google returns a consent URL
+// for the Classroom scopes, Google redirects back to
+// /auth/google/classroom/callback, and the teacher then picks one of their
+// courses. Deliveries go through the job queue, so failures are retried.
+
+const (
+	lmsPushJob             = "lms-push"
+	lmsGoogleClassroom     = "google_classroom"
+	lmsWebhook             = "webhook"
+	lmsAssignmentCompleted = "assignment.completed"
+	lmsAssignmentGraded    = "assignment.graded"
+	lmsWritingFeedback     = "writing.feedback"
+	classroomLinkSession   = "puzzle_hub_classroom_link"
+	googleClassroomAPI     = "https://classroom.googleapis.com/v1/"
+)
+
+var lmsEvents = []string{lmsAssignmentCompleted, lmsAssignmentGraded, lmsWritingFeedback}
+
+// googleClassroomScopes lets us read the teacher's courses and roster and
+// grade the course work we create
+var googleClassroomScopes = []string{
+	"email",
+	"https://www.googleapis.com/auth/classroom.courses.readonly",
+	"https://www.googleapis.com/auth/classroom.rosters.readonly",
+	"https://www.googleapis.com/auth/classroom.coursework.students",
+}
+
+type ClassroomIntegration struct {
+	ClassroomID   string            `json:"classroom_id" dynamodbav:"classroom_id"`
+	Kind          string            `json:"kind" dynamodbav:"kind"` // google_classroom or webhook
+	Events        []string          `json:"events,omitempty" dynamodbav:"events,omitempty"`
+	WebhookURL    string            `json:"webhook_url,omitempty" dynamodbav:"webhook_url,omitempty"`
+	WebhookSecret string            `json:"-" dynamodbav:"webhook_secret,omitempty"`
+	GoogleEmail   string            `json:"google_email,omitempty" dynamodbav:"google_email,omitempty"` // Account that granted access
+	RefreshToken  string            `json:"-" dynamodbav:"refresh_token,omitempty"`
+	CourseID      string            `json:"course_id,omitempty" dynamodbav:"course_id,omitempty"`
+	CourseName    string            `json:"course_name,omitempty" dynamodbav:"course_name,omitempty"`
+	CourseWork    map[string]string `json:"-" dynamodbav:"course_work"` // Assignment ID to Google course work ID
+	LinkedBy      string            `json:"linked_by" dynamodbav:"linked_by"`
+	LinkedAt      time.Time         `json:"linked_at" dynamodbav:"linked_at"`
+}
+
+type LinkWebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Events []string `json:"events"` // Defaults to every event
+}
+
+type SelectCourseRequest struct {
+	CourseID string `json:"course_id" binding:"required"`
+}
+
+// LMSEvent is what is delivered, and the payload of an lms-push job
+type LMSEvent struct {
+	ID          string               `json:"id"` // Unique per event, for deduplicating retries
+	Event       string               `json:"event"`
+	ClassroomID string               `json:"classroom_id"`
+	Student     LMSStudent           `json:"student"`
+	Assignment  *LMSAssignmentResult `json:"assignment,omitempty"`
+	Writing     *LMSWritingFeedback  `json:"writing,omitempty"`
+	OccurredAt  time.Time            `json:"occurred_at"`
+}
+
+type LMSStudent struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+type LMSAssignmentResult struct {
+	ID             string     `json:"id"`
+	Title          string     `json:"title"`
+	Instructions   string     `json:"instructions,omitempty"`
+	Activity       string     `json:"activity"`
+	DueAt          time.Time  `json:"due_at"`
+	Status         string     `json:"status"`
+	Sessions       int        `json:"sessions"`
+	AveragePercent float64    `json:"average_percent"`
+	BestPercent    float64    `json:"best_percent"`
+	Minutes        float64    `json:"minutes"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	Grade          string     `json:"grade,omitempty"`
+	Comment        string     `json:"comment,omitempty"`
+}
+
+type LMSWritingFeedback struct {
+	Title         string `json:"title,omitempty"`
+	GradeLevel    int    `json:"grade_level"`
+	WordCount     int    `json:"word_count"`
+	OverallRating int    `json:"overall_rating"`
+	Summary       string `json:"summary"`
+	Source        string `json:"source"` // ai or fallback
+}
+
+func lmsStudentFor(member ClassroomMember) LMSStudent {
+	return LMSStudent{ID: member.UserID, Name: member.Name, Email: member.Email}
+}
+
+func lmsAssignmentResult(assignment *Assignment, submission *AssignmentSubmission, now time.Time) *LMSAssignmentResult {
+	result := &LMSAssignmentResult{
+		ID:           assignment.ID,
+		Title:        assignment.Title,
+		Instructions: assignment.Instructions,
+		Activity:     assignment.Activity,
+		DueAt:        assignment.DueAt,
+		Status:       assignmentStatus(assignment, submission, now),
+	}
+	if submission != nil {
+		result.Sessions = submission.Sessions
+		result.AveragePercent = submission.averagePercent()
+		result.BestPercent = submission.BestPercent
+		result.Minutes = math.Round(submission.Minutes*10) / 10
+		result.CompletedAt = submission.CompletedAt
+		result.Grade = submission.Grade
+		result.Comment = submission.Comment
+	}
+	return result
+}
+
+// wants reports whether the integration takes the event
+func (i *ClassroomIntegration) wants(event string) bool {
+	switch i.Kind {
+	case lmsWebhook:
+		return len(i.Events) == 0 || slices.Contains(i.Events, event)
+	case lmsGoogleClassroom:
+		return i.CourseID != "" && (event == lmsAssignmentCompleted || event == lmsAssignmentGraded)
+	}
+	return false
+}
+
+// validateWebhookURL returns the problem with a webhook URL, or "". Only
+// public https addresses are accepted, so a user can't point the server at
+// internal services. Names are checked again when delivering, see
+// webhook_client.go.
+func validateWebhookURL(raw string) string {
+	target, err := url.Parse(raw)
+	if err != nil || target.Scheme != "https" || target.Hostname() == "" {
+		return "Webhook URL must be an https:// URL"
+	}
+	host := strings.ToLower(target.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return "Webhook URL must be a public address"
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddress(addr) {
+		return "Webhook URL must be a public address"
+	}
+	return ""
+}
+
+// getClassroomIntegration returns the classroom's integration, or null
+func (h *PuzzleHub) getClassroomIntegration(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+	integration, err := h.loadClassroomIntegration(c.Request.Context(), classroom.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching integration", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to fetch integration")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"integration": integration})
+}
+
+// linkClassroomWebhook points the classroom at a webhook, replacing any
+// other integration. The signing secret is only shown in this response.
+func (h *PuzzleHub) linkClassroomWebhook(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+
+	var request LinkWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	request.URL = strings.TrimSpace(request.URL)
+	if problem := validateWebhookURL(request.URL); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+	for _, event := range request.Events {
+		if !slices.Contains(lmsEvents, event) {
+			respondError(c, http.StatusBadRequest, "Events must be "+strings.Join(lmsEvents, ", "))
+			return
+		}
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		requestLogger(c).Error("error generating webhook secret", "error", err)
+		respondStorageError(c, err, "Failed to link webhook")
+		return
+	}
+	integration := &ClassroomIntegration{
+		ClassroomID:   classroom.ID,
+		Kind:          lmsWebhook,
+		Events:        request.Events,
+		WebhookURL:    request.URL,
+		WebhookSecret: secret,
+		LinkedBy:      c.MustGet("user").(*User).ID,
+		LinkedAt:      time.Now(),
+	}
+	if err := h.putClassroomIntegration(c.Request.Context(), integration); err != nil {
+		requestLogger(c).Error("error saving integration", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to link webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Webhook linked",
+		"integration": integration,
+		"secret":      secret,
+	})
+}
+
+// unlinkClassroomIntegration removes the integration; Google Classroom
+// course work already created is left in place
+func (h *PuzzleHub) unlinkClassroomIntegration(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-classroom-integrations")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"classroom_id": {S: aws.String(classroom.ID)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error deleting integration", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to unlink integration")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Integration unlinked"})
+}
+
+// classroomOAuthConfig is the login client with the Classroom scopes and
+// its own callback
+func (h *PuzzleHub) classroomOAuthConfig() *oauth2.Config {
+	config := *h.AuthConfig.GoogleOAuth
+	config.RedirectURL = h.AuthConfig.BaseURL + "/auth/google/classroom/callback"
+	config.Scopes = googleClassroomScopes
+	return &config
+}
+
+// beginGoogleClassroomLink returns the Google consent URL. Like sign-in,
+// the state and PKCE verifier are kept in a signed cookie, together with
+// the classroom being linked.
+func (h *PuzzleHub) beginGoogleClassroomLink(c *gin.Context) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return
+	}
+	if h.AuthConfig.GoogleOAuth.ClientID == "" {
+		respondErrorCode(c, http.StatusServiceUnavailable, "oauth_not_configured", "Google OAuth is not configured", nil)
+		return
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		requestLogger(c).Error("error generating OAuth state", "error", err)
+		respondStorageError(c, err, "Failed to start linking")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	session, _ := h.AuthConfig.SessionStore.New(c.Request, classroomLinkSession)
+	session.Options = h.oauthCookieOptions(int(oauthStateTTL.Seconds()))
+	session.Values["state"] = state
+	session.Values["verifier"] = verifier
+	session.Values["classroom_id"] = classroom.ID
+	session.Values["user_id"] = c.MustGet("user").(*User).ID
+	session.Values["created_at"] = time.Now().Unix()
+	if err := session.Save(c.Request, c.Writer); err != nil {
+		requestLogger(c).Error("error saving classroom link state", "error", err)
+		respondStorageError(c, err, "Failed to start linking")
+		return
+	}
+
+	// Consent is forced so Google issues a refresh token every time
+	c.JSON(http.StatusOK, gin.H{"url": h.classroomOAuthConfig().AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("prompt", "consent"),
+		oauth2.S256ChallengeOption(verifier),
+	)})
+}
+
+// googleClassroomCallback stores the teacher's Google grant and sends them
+// back to the app, which then asks for the course
+func (h *PuzzleHub) googleClassroomCallback(c *gin.Context) {
+	fail := func(reason string, args ...interface{}) {
+		log.Printf("⚠️  Google Classroom link failed: "+reason, args...)
+		c.Redirect(http.StatusFound, "/?classroom_link=failed")
+	}
+
+	session, err := h.AuthConfig.SessionStore.Get(c.Request, classroomLinkSession)
+	if err != nil || session.IsNew {
+		fail("link session not found or expired")
+		return
+	}
+	expected, _ := session.Values["state"].(string)
+	verifier, _ := session.Values["verifier"].(string)
+	classroomID, _ := session.Values["classroom_id"].(string)
+	userID, _ := session.Values["user_id"].(string)
+	createdAt, _ := session.Values["created_at"].(int64)
+	session.Options = h.oauthCookieOptions(-1)
+	session.Save(c.Request, c.Writer)
+
+	actual := c.Query("state")
+	if expected == "" || actual == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		fail("state mismatch")
+		return
+	}
+	if time.Since(time.Unix(createdAt, 0)) > oauthStateTTL {
+		fail("link session expired")
+		return
+	}
+	if oauthErr := c.Query("error"); oauthErr != "" {
+		fail("consent not given: %s", oauthErr)
+		return
+	}
+
+	token, err := h.classroomOAuthConfig().Exchange(c.Request.Context(), c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		fail("code exchange: %v", err)
+		return
+	}
+	if token.RefreshToken == "" {
+		fail("no refresh token for classroom %s", classroomID)
+		return
+	}
+	account, err := h.getUserFromGoogle(token.AccessToken)
+	if err != nil {
+		fail("fetching the Google account: %v", err)
+		return
+	}
+
+	integration := &ClassroomIntegration{
+		ClassroomID:  classroomID,
+		Kind:         lmsGoogleClassroom,
+		GoogleEmail:  account.Email,
+		RefreshToken: token.RefreshToken,
+		CourseWork:   map[string]string{},
+		LinkedBy:     userID,
+		LinkedAt:     time.Now(),
+	}
+	if err := h.putClassroomIntegration(c.Request.Context(), integration); err != nil {
+		fail("saving integration for %s: %v", classroomID, err)
+		return
+	}
+	log.Printf("🔗 Classroom %s linked to Google Classroom by %s", classroomID, userID)
+	c.Redirect(http.StatusFound, "/?classroom_link=google&classroom_id="+url.QueryEscape(classroomID))
+}
+
+// requireGoogleIntegration loads the classroom's Google Classroom link,
+// writing the error response when there isn't one
+func (h *PuzzleHub) requireGoogleIntegration(c *gin.Context) (*ClassroomIntegration, bool) {
+	classroom, ok := h.requireClassroomTeacher(c)
+	if !ok {
+		return nil, false
+	}
+	integration, err := h.loadClassroomIntegration(c.Request.Context(), classroom.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching integration", "error", err, "classroom_id", classroom.ID)
+		respondStorageError(c, err, "Failed to fetch integration")
+		return nil, false
+	}
+	if integration == nil || integration.Kind != lmsGoogleClassroom {
+		respondError(c, http.StatusNotFound, "Class is not linked to Google Classroom")
+		return nil, false
+	}
+	return integration, true
+}
+
+type googleCourse struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Section string `json:"section,omitempty"`
+}
+
+// listGoogleClassroomCourses lists the active courses the linked account
+// teaches
+func (h *PuzzleHub) listGoogleClassroomCourses(c *gin.Context) {
+	integration, ok := h.requireGoogleIntegration(c)
+	if !ok {
+		return
+	}
+	var out struct {
+		Courses []googleCourse `json:"courses"`
+	}
+	query := url.Values{"teacherId": {"me"}, "courseStates": {"ACTIVE"}, "pageSize": {"100"}}
+	if err := h.googleClassroom(c.Request.Context(), integration).call(c.Request.Context(), "GET", "courses", query, nil, &out); err != nil {
+		requestLogger(c).Error("error listing Google Classroom courses", "error", err, "classroom_id", integration.ClassroomID)
+		respondError(c, http.StatusBadGateway, "Failed to fetch Google Classroom courses")
+		return
+	}
+	if out.Courses == nil {
+		out.Courses = []googleCourse{}
+	}
+	c.JSON(http.StatusOK, gin.H{"courses": out.Courses})
+}
+
+// selectGoogleClassroomCourse picks the course results go to. Changing
+// course starts over with new course work.
+func (h *PuzzleHub) selectGoogleClassroomCourse(c *gin.Context) {
+	integration, ok := h.requireGoogleIntegration(c)
+	if !ok {
+		return
+	}
+	var request SelectCourseRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	var course googleCourse
+	if err := h.googleClassroom(c.Request.Context(), integration).call(c.Request.Context(), "GET", "courses/"+url.PathEscape(request.CourseID), nil, nil, &course); err != nil {
+		var apiErr *lmsHTTPError
+		if errors.As(err, &apiErr) && apiErr.permanent() {
+			respondError(c, http.StatusNotFound, "Google Classroom course not found")
+			return
+		}
+		requestLogger(c).Error("error fetching Google Classroom course", "error", err, "course_id", request.CourseID)
+		respondError(c, http.StatusBadGateway, "Failed to fetch Google Classroom course")
+		return
+	}
+
+	if integration.CourseID != course.ID {
+		integration.CourseWork = map[string]string{}
+	}
+	integration.CourseID = course.ID
+	integration.CourseName = course.Name
+	if err := h.putClassroomIntegration(c.Request.Context(), integration); err != nil {
+		requestLogger(c).Error("error saving integration", "error", err, "classroom_id", integration.ClassroomID)
+		respondStorageError(c, err, "Failed to save course")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Course linked",
+		"integration": integration,
+	})
+}
+
+// queueLMSEvent queues delivery when the classroom has an integration that
+// takes the event. Failures are logged; grading never waits on the LMS.
+func (h *PuzzleHub) queueLMSEvent(ctx context.Context, event LMSEvent) {
+	integration, err := h.loadClassroomIntegration(ctx, event.ClassroomID)
+	if err != nil {
+		log.Printf("Error fetching integration for %s: %v", event.ClassroomID, err)
+		return
+	}
+	if integration == nil || !integration.wants(event.Event) {
+		return
+	}
+
+	if event.ID, err = randomToken(12); err != nil {
+		log.Printf("Error generating LMS event ID: %v", err)
+		return
+	}
+	event.OccurredAt = time.Now()
+	if _, err := h.Jobs.Enqueue(ctx, lmsPushJob, event, 0); err != nil {
+		log.Printf("Error queueing %s for %s: %v", event.Event, event.ClassroomID, err)
+	}
+}
+
+// queueWritingFeedback sends a signed-in student's writing analysis to
+// each of their classes
+func (h *PuzzleHub) queueWritingFeedback(c *gin.Context, request WritingAnalysisRequest, analysis *WritingAnalysisResponse) {
+	value, ok := c.Get("user")
+	if !ok || value.(*User).IsGuest {
+		return
+	}
+	user := value.(*User)
+	memberships, err := h.getMemberships(user.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching memberships", "error", err)
+		return
+	}
+	for _, membership := range memberships {
+		h.queueLMSEvent(context.Background(), LMSEvent{
+			Event:       lmsWritingFeedback,
+			ClassroomID: membership.ClassroomID,
+			Student:     lmsStudentFor(membership),
+			Writing: &LMSWritingFeedback{
+				Title:         request.Title,
+				GradeLevel:    request.GradeLevel,
+				WordCount:     len(strings.Fields(request.Text)),
+				OverallRating: analysis.OverallRating,
+				Summary:       analysis.Summary,
+				Source:        analysis.Source,
+			},
+		})
+	}
+}
+
+// deliverLMSEvent is the lms-push job handler
+func (h *PuzzleHub) deliverLMSEvent(ctx context.Context, payload json.RawMessage) error {
+	var event LMSEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("invalid LMS event payload: %v", err)
+	}
+
+	// The classroom may have been unlinked or relinked since
+	integration, err := h.loadClassroomIntegration(ctx, event.ClassroomID)
+	if err != nil {
+		return err
+	}
+	if integration == nil || !integration.wants(event.Event) {
+		return nil
+	}
+
+	switch integration.Kind {
+	case lmsWebhook:
+		err = h.postWebhook(ctx, integration, event)
+	case lmsGoogleClassroom:
+		err = h.pushToGoogleClassroom(ctx, integration, event)
+	}
+
+	// Requests the LMS rejects outright, or that go to an address webhooks
+	// can't reach, won't succeed on a retry
+	var apiErr *lmsHTTPError
+	if (errors.As(err, &apiErr) && apiErr.permanent()) || errors.Is(err, errWebhookAddress) {
+		log.Printf("⚠️  %s for %s rejected by %s: %v", event.Event, event.ClassroomID, integration.Kind, err)
+		return nil
+	}
+	return err
+}
+
+// postWebhook POSTs the signed event
+func (h *PuzzleHub) postWebhook(ctx context.Context, integration *ClassroomIntegration, event LMSEvent) error {
+	return h.postSignedWebhook(ctx, integration.WebhookURL, integration.WebhookSecret, event.Event, event.ID, event)
+}
+
+// postSignedWebhook POSTs payload as JSON, signed with X-PuzzleHub-Signature:
+// sha256=<hex HMAC of the body with secret>. Error answers are returned as
+// *lmsHTTPError.
+func (h *PuzzleHub) postSignedWebhook(ctx context.Context, target, secret, event, deliveryID string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PuzzleHub-Webhook/1")
+	req.Header.Set("X-PuzzleHub-Event", event)
+	req.Header.Set("X-PuzzleHub-Delivery", deliveryID)
+	req.Header.Set("X-PuzzleHub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := h.WebhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &lmsHTTPError{Status: resp.StatusCode, Message: "webhook answered " + resp.Status}
+	}
+	return nil
+}
+
+// numericGrade reads grades like "85" or "85%" as a score out of 100
+func numericGrade(grade string) (float64, bool) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(grade, "%")), 64)
+	if err != nil || value < 0 || value > 100 {
+		return 0, false
+	}
+	return value, true
+}
+
+// pushToGoogleClassroom sets the student's grade on the assignment's
+// course work, creating the course work the first time
+func (h *PuzzleHub) pushToGoogleClassroom(ctx context.Context, integration *ClassroomIntegration, event LMSEvent) error {
+	result := event.Assignment
+	if result == nil {
+		return nil
+	}
+	grade := result.AveragePercent
+	if event.Event == lmsAssignmentGraded {
+		var ok bool
+		if grade, ok = numericGrade(result.Grade); !ok {
+			return nil
+		}
+	}
+	if event.Student.Email == "" {
+		log.Printf("⚠️  Can't send %s's grade to Google Classroom without an email", event.Student.ID)
+		return nil
+	}
+
+	classroom := h.googleClassroom(ctx, integration)
+	courseWorkID, err := h.googleCourseWork(ctx, classroom, integration, result)
+	if err != nil {
+		return err
+	}
+
+	submissionsPath := fmt.Sprintf("courses/%s/courseWork/%s/studentSubmissions", url.PathEscape(integration.CourseID), url.PathEscape(courseWorkID))
+	var submissions struct {
+		StudentSubmissions []struct {
+			ID string `json:"id"`
+		} `json:"studentSubmissions"`
+	}
+	if err := classroom.call(ctx, "GET", submissionsPath, url.Values{"userId": {event.Student.Email}}, nil, &submissions); err != nil {
+		return err
+	}
+	if len(submissions.StudentSubmissions) == 0 {
+		log.Printf("⚠️  Student %s of classroom %s is not in Google Classroom course %s", event.Student.ID, integration.ClassroomID, integration.CourseID)
+		return nil
+	}
+
+	update := map[string]float64{"assignedGrade": grade, "draftGrade": grade}
+	query := url.Values{"updateMask": {"assignedGrade,draftGrade"}}
+	return classroom.call(ctx, "PATCH", submissionsPath+"/"+url.PathEscape(submissions.StudentSubmissions[0].ID), query, update, nil)
+}
+
+// googleCourseWork returns the course work for an assignment, creating it
+// on first use. If two deliveries race, the loser deletes its copy.
+func (h *PuzzleHub) googleCourseWork(ctx context.Context, classroom *googleClassroomClient, integration *ClassroomIntegration, result *LMSAssignmentResult) (string, error) {
+	if id := integration.CourseWork[result.ID]; id != "" {
+		return id, nil
+	}
+
+	courseWork := map[string]interface{}{
+		"title":       result.Title,
+		"description": result.Instructions,
+		"workType":    "ASSIGNMENT",
+		"state":       "PUBLISHED",
+		"maxPoints":   100,
+	}
+	// Google rejects due dates in the past
+	if due := result.DueAt.UTC(); due.After(time.Now()) {
+		courseWork["dueDate"] = map[string]int{"year": due.Year(), "month": int(due.Month()), "day": due.Day()}
+		courseWork["dueTime"] = map[string]int{"hours": due.Hour(), "minutes": due.Minute()}
+	}
+	coursePath := "courses/" + url.PathEscape(integration.CourseID) + "/courseWork"
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := classroom.call(ctx, "POST", coursePath, nil, courseWork, &created); err != nil {
+		return "", err
+	}
+
+	_, err := h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-classroom-integrations")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"classroom_id": {S: aws.String(integration.ClassroomID)},
+		},
+		UpdateExpression:         aws.String("SET course_work.#assignment = :id"),
+		ConditionExpression:      aws.String("attribute_not_exists(course_work.#assignment) AND course_id = :course"),
+		ExpressionAttributeNames: map[string]*string{"#assignment": aws.String(result.ID)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id":     {S: aws.String(created.ID)},
+			":course": {S: aws.String(integration.CourseID)},
+		},
+	})
+	if err == nil {
+		return created.ID, nil
+	}
+	if !isConditionalCheckFailed(err) {
+		return "", fmt.Errorf("failed to save course work: %v", err)
+	}
+
+	if err := classroom.call(ctx, "DELETE", coursePath+"/"+url.PathEscape(created.ID), nil, nil, nil); err != nil {
+		log.Printf("Error deleting duplicate course work %s: %v", created.ID, err)
+	}
+	current, err := h.loadClassroomIntegration(ctx, integration.ClassroomID)
+	if err != nil {
+		return "", err
+	}
+	if current == nil || current.CourseWork[result.ID] == "" {
+		return "", fmt.Errorf("classroom %s was relinked", integration.ClassroomID)
+	}
+	return current.CourseWork[result.ID], nil
+}
+
+// googleClassroomClient calls the Classroom REST API with the teacher's
+// grant, refreshing the access token as needed
+type googleClassroomClient struct {
+	client *http.Client
+}
+
+func (h *PuzzleHub) googleClassroom(ctx context.Context, integration *ClassroomIntegration) *googleClassroomClient {
+	source := h.classroomOAuthConfig().TokenSource(ctx, &oauth2.Token{RefreshToken: integration.RefreshToken})
+	return &googleClassroomClient{client: oauth2.NewClient(ctx, source)}
+}
+
+// lmsHTTPError is an error answer from Google Classroom or a webhook
+type lmsHTTPError struct {
+	Status  int
+	Message string
+}
+
+func (e *lmsHTTPError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Status, e.Message)
+}
+
+// permanent is true for client errors other than rate limiting
+func (e *lmsHTTPError) permanent() bool {
+	return e.Status >= 400 && e.Status < 500 && e.Status != http.StatusTooManyRequests && e.Status != http.StatusRequestTimeout
+}
+
+func (g *googleClassroomClient) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	endpoint := googleClassroomAPI + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Google Classroom request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read Google Classroom response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var problem struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &problem)
+		if problem.Error.Message == "" {
+			problem.Error.Message = resp.Status
+		}
+		return &lmsHTTPError{Status: resp.StatusCode, Message: problem.Error.Message}
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to parse Google Classroom response: %v", err)
+		}
+	}
+	return nil
+}
+
+func (h *PuzzleHub) loadClassroomIntegration(ctx context.Context, classroomID string) (*ClassroomIntegration, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-classroom-integrations")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"classroom_id": {S: aws.String(classroomID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var integration ClassroomIntegration
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &integration); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal integration: %v", err)
+	}
+	return &integration, nil
+}
+
+func (h *PuzzleHub) putClassroomIntegration(ctx context.Context, integration *ClassroomIntegration) error {
+	if integration.CourseWork == nil {
+		integration.CourseWork = map[string]string{}
+	}
+	item, err := dynamodbattribute.MarshalMap(integration)
+	if err != nil {
+		return fmt.Errorf("failed to marshal integration: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-classroom-integrations")),
+		Item:      item,
+	})
+	return err
+}
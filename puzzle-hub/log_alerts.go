@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Log snapshots and trend alerts
+//
+// Once a week, for every log type, the week just finished is compared with
+// the four weeks before it, read from the week buckets in
+// puzzle-hub-log-aggregates (see aggregates.go). The numbers are kept as a
+// snapshot in puzzle-hub-log-snapshots, and each metric that moved by
+// logAlertThreshold or more becomes an alert in puzzle-hub-log-alerts:
+// "Gym Workout: total weight down 40% vs last month". Metrics are the
+// number of entries and the weekly total of every number field.
+//
+// The scheduled job claims the week in puzzle-hub-job-runs and queues one
+// log-snapshot job per user, so a failure is retried on its own. Alert IDs
+// are derived from the week, log type and metric, so a retried job writes
+// the same alerts again. Users with new alerts get one notification, which
+// is emailed when they opted in to notification emails.
+
+const (
+	logSnapshotJob        = "log-snapshot"
+	logSnapshotBaseline   = 4   // Weeks compared against
+	logAlertThreshold     = 0.3 // Fraction of change that raises an alert
+	logAlertMinEntries    = 4   // Entries in the baseline weeks before alerts are raised
+	logSnapshotRetention  = 2 * 365 * 24 * time.Hour
+	logAlertRetention     = 180 * 24 * time.Hour
+	defaultLogAlertLimit  = 50
+	maxLogAlertLimit      = 200
+	logAlertEntriesMetric = "entries"
+)
+
+// logSnapshotRequest is the payload of a log-snapshot job
+type logSnapshotRequest struct {
+	UserID    string `json:"user_id"`
+	WeekStart string `json:"week_start"` // Monday, YYYY-MM-DD
+}
+
+// LogSnapshot is one log type's week next to its baseline
+type LogSnapshot struct {
+	LogTypeID     string             `json:"log_type_id" dynamodbav:"log_type_id"`
+	Week          string             `json:"week" dynamodbav:"week"` // ISO week, e.g. 2025-W07
+	UserID        string             `json:"-" dynamodbav:"user_id"`
+	WeekStart     string             `json:"week_start" dynamodbav:"week_start"`
+	Count         int                `json:"count" dynamodbav:"count"`
+	Sums          map[string]float64 `json:"sums" dynamodbav:"sums"`
+	BaselineCount float64            `json:"baseline_count" dynamodbav:"baseline_count"` // Weekly average over the baseline weeks
+	BaselineSums  map[string]float64 `json:"baseline_sums" dynamodbav:"baseline_sums"`
+	CreatedAt     time.Time          `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt     int64              `json:"-" dynamodbav:"expires_at"`
+}
+
+// LogAlert is a notable change in one metric of a log type
+type LogAlert struct {
+	UserID        string    `json:"-" dynamodbav:"user_id"`
+	ID            string    `json:"id" dynamodbav:"alert_id"` // <week>:<log type>:<metric>, sorts by week
+	LogTypeID     string    `json:"log_type_id" dynamodbav:"log_type_id"`
+	LogTypeName   string    `json:"log_type_name" dynamodbav:"log_type_name"`
+	Week          string    `json:"week" dynamodbav:"week"`
+	Metric        string    `json:"metric" dynamodbav:"metric"` // entries, or a number field's name
+	Current       float64   `json:"current" dynamodbav:"current"`
+	Baseline      float64   `json:"baseline" dynamodbav:"baseline"` // Weekly average
+	ChangePercent int       `json:"change_percent" dynamodbav:"change_percent"`
+	Message       string    `json:"message" dynamodbav:"message"`
+	CreatedAt     time.Time `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt     int64     `json:"-" dynamodbav:"expires_at"`
+}
+
+// snapshotAllLogs queues last week's snapshot for every user with a log
+// type, once per week. It is scheduled in main.
+func (h *PuzzleHub) snapshotAllLogs(ctx context.Context) error {
+	weekStart, period := lastCompleteWeek(time.Now())
+
+	claimed, err := h.claimJobRun("log-snapshots", period)
+	if err != nil {
+		return fmt.Errorf("failed to claim %s: %v", period, err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	logTypes, err := h.Store.ListAllLogTypes()
+	if err != nil {
+		// Release the claim so the next run retries
+		h.releaseJobRun("log-snapshots", period)
+		return fmt.Errorf("log snapshots for %s failed: %v", period, err)
+	}
+	users := map[string]bool{}
+	for _, logType := range logTypes {
+		users[logType.UserID] = true
+	}
+
+	queued := 0
+	for userID := range users {
+		payload := logSnapshotRequest{UserID: userID, WeekStart: weekStart.Format("2006-01-02")}
+		if _, err := h.Jobs.Enqueue(ctx, logSnapshotJob, payload, 0); err != nil {
+			log.Printf("Error queueing log snapshot for %s: %v", userID, err)
+			continue
+		}
+		queued++
+	}
+
+	log.Printf("📸 Log snapshots for %s queued (%d of %d users)", period, queued, len(users))
+	return nil
+}
+
+// snapshotUserLogs is the log-snapshot job handler
+func (h *PuzzleHub) snapshotUserLogs(ctx context.Context, payload json.RawMessage) error {
+	var job logSnapshotRequest
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("invalid log snapshot payload: %v", err)
+	}
+	weekStart, err := time.Parse("2006-01-02", job.WeekStart)
+	if err != nil {
+		return fmt.Errorf("invalid log snapshot week: %v", err)
+	}
+
+	logTypes, err := h.Store.ListLogTypes(job.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to list log types: %v", err)
+	}
+
+	var alerts []LogAlert
+	for _, logType := range logTypes {
+		fields, err := h.getLogFields(logType.ID)
+		if err != nil {
+			return fmt.Errorf("failed to read fields of %s: %v", logType.ID, err)
+		}
+		logType.Fields = fields
+
+		// Week buckets sort after the total bucket, which getLogAggregates
+		// needs to see
+		aggregates, err := h.getLogAggregates(job.UserID, logType.ID, aggregateTotalBucket)
+		if err != nil {
+			return fmt.Errorf("failed to read aggregates of %s: %v", logType.ID, err)
+		}
+		snapshot := buildLogSnapshot(logType, aggregates, weekStart)
+		if err := h.putLogSnapshot(ctx, snapshot); err != nil {
+			return fmt.Errorf("failed to save snapshot of %s: %v", logType.ID, err)
+		}
+
+		for _, alert := range logTrendAlerts(logType, snapshot, aggregates, weekStart) {
+			if err := h.putLogAlert(ctx, &alert); err != nil {
+				return fmt.Errorf("failed to save alert for %s: %v", logType.ID, err)
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if len(alerts) > 0 {
+		h.notifyLogAlerts(ctx, job.UserID, alerts)
+	}
+	return nil
+}
+
+// buildLogSnapshot compares the week starting weekStart with the average
+// of the logSnapshotBaseline weeks before it. Only number fields are
+// summed.
+func buildLogSnapshot(logType LogType, aggregates map[string]LogAggregate, weekStart time.Time) *LogSnapshot {
+	numericFields := map[string]bool{}
+	for _, field := range logType.Fields {
+		if field.FieldType == FieldTypeNumber {
+			numericFields[field.FieldName] = true
+		}
+	}
+
+	now := time.Now()
+	current := aggregates[weekBucket(weekStart)]
+	snapshot := &LogSnapshot{
+		LogTypeID:    logType.ID,
+		Week:         strings.TrimPrefix(weekBucket(weekStart), "week#"),
+		UserID:       logType.UserID,
+		WeekStart:    weekStart.Format("2006-01-02"),
+		Count:        current.Count,
+		Sums:         map[string]float64{},
+		BaselineSums: map[string]float64{},
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(logSnapshotRetention).Unix(),
+	}
+	for fieldName, sum := range current.Sums {
+		if numericFields[fieldName] {
+			snapshot.Sums[fieldName] = sum
+		}
+	}
+
+	for i := 1; i <= logSnapshotBaseline; i++ {
+		week := aggregates[weekBucket(weekStart.AddDate(0, 0, -7*i))]
+		snapshot.BaselineCount += float64(week.Count) / logSnapshotBaseline
+		for fieldName, sum := range week.Sums {
+			if numericFields[fieldName] {
+				snapshot.BaselineSums[fieldName] += sum / logSnapshotBaseline
+			}
+		}
+	}
+	return snapshot
+}
+
+// baselineEntries counts the entries in the baseline weeks
+func baselineEntries(aggregates map[string]LogAggregate, weekStart time.Time) int {
+	entries := 0
+	for i := 1; i <= logSnapshotBaseline; i++ {
+		entries += aggregates[weekBucket(weekStart.AddDate(0, 0, -7*i))].Count
+	}
+	return entries
+}
+
+// logTrendAlerts returns an alert for each metric of the snapshot that
+// moved by logAlertThreshold or more. Log types used too little in the
+// baseline weeks get none.
+func logTrendAlerts(logType LogType, snapshot *LogSnapshot, aggregates map[string]LogAggregate, weekStart time.Time) []LogAlert {
+	if baselineEntries(aggregates, weekStart) < logAlertMinEntries {
+		return nil
+	}
+
+	type metric struct {
+		name, label       string
+		current, baseline float64
+	}
+	metrics := []metric{{logAlertEntriesMetric, "entries", float64(snapshot.Count), snapshot.BaselineCount}}
+	fieldNames := make([]string, 0, len(snapshot.BaselineSums))
+	for fieldName := range snapshot.BaselineSums {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+	for _, fieldName := range fieldNames {
+		metrics = append(metrics, metric{fieldName, "total " + fieldName, snapshot.Sums[fieldName], snapshot.BaselineSums[fieldName]})
+	}
+
+	now := time.Now()
+	var alerts []LogAlert
+	for _, m := range metrics {
+		if m.baseline <= 0 {
+			continue
+		}
+		change := (m.current - m.baseline) / m.baseline
+		if math.Abs(change) < logAlertThreshold {
+			continue
+		}
+		percent := int(math.Round(change * 100))
+		direction := "up"
+		if percent < 0 {
+			direction = "down"
+		}
+		alerts = append(alerts, LogAlert{
+			UserID:        logType.UserID,
+			ID:            snapshot.Week + ":" + logType.ID + ":" + m.name,
+			LogTypeID:     logType.ID,
+			LogTypeName:   logType.Name,
+			Week:          snapshot.Week,
+			Metric:        m.name,
+			Current:       m.current,
+			Baseline:      math.Round(m.baseline*100) / 100,
+			ChangePercent: percent,
+			Message: fmt.Sprintf("%s: %s %s %d%% vs %s (%s this week against %s a week on average)",
+				logType.Name, m.label, direction, absInt(percent), "last month",
+				formatLogMetric(m.current), formatLogMetric(m.baseline)),
+			CreatedAt: now,
+			ExpiresAt: now.Add(logAlertRetention).Unix(),
+		})
+	}
+	return alerts
+}
+
+// formatLogMetric prints whole numbers without decimals and others to one
+// decimal place
+func formatLogMetric(value float64) string {
+	if value == math.Trunc(value) {
+		return strconv.FormatFloat(value, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64)
+}
+
+// notifyLogAlerts tells the user about the week's alerts in one
+// notification
+func (h *PuzzleHub) notifyLogAlerts(ctx context.Context, userID string, alerts []LogAlert) {
+	title := alerts[0].Message
+	if len(alerts) > 1 {
+		title = fmt.Sprintf("%d changes in your logs this week", len(alerts))
+	}
+	lines := make([]string, len(alerts))
+	for i, alert := range alerts {
+		lines[i] = alert.Message
+	}
+
+	var profile userProfile
+	getCachedJSON(ctx, h.Cache, "user_profile", userProfileKey(userID), &profile)
+	h.notify(ctx, Notification{
+		UserID: userID,
+		Kind:   notificationLogAlert,
+		Title:  title,
+		Body:   strings.Join(lines, "\n"),
+		Link:   "/logs/alerts",
+		Data:   map[string]string{"week": alerts[0].Week},
+	}, profile.Email)
+}
+
+// listLogAlerts returns the user's alerts newest first, optionally for one
+// log type with ?log_type_id=
+func (h *PuzzleHub) listLogAlerts(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	limit := defaultLogAlertLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxLogAlertLimit {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxLogAlertLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-log-alerts")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userObj.ID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	if logTypeID := c.Query("log_type_id"); logTypeID != "" {
+		input.FilterExpression = aws.String("log_type_id = :log_type_id")
+		input.ExpressionAttributeValues[":log_type_id"] = &dynamodb.AttributeValue{S: aws.String(logTypeID)}
+	}
+
+	alerts, err := queryLimit[LogAlert](c.Request.Context(), h.DynamoDB, input, limit)
+	if err != nil {
+		requestLogger(c).Error("error listing log alerts", "error", err)
+		respondStorageError(c, err, "Failed to fetch alerts")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+// dismissLogAlert deletes one of the user's alerts
+func (h *PuzzleHub) dismissLogAlert(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-alerts")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":  {S: aws.String(userObj.ID)},
+			"alert_id": {S: aws.String(c.Param("id"))},
+		},
+		ConditionExpression: aws.String("attribute_exists(alert_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Alert not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error dismissing log alert", "error", err, "alert_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to dismiss alert")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert dismissed"})
+}
+
+// getLogSnapshots returns a log type's weekly snapshots, newest first
+func (h *PuzzleHub) getLogSnapshots(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	logType, status, err := h.getOwnedLogType(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	snapshots, err := queryLimit[LogSnapshot](c.Request.Context(), h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-log-snapshots")),
+		KeyConditionExpression: aws.String("log_type_id = :log_type_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":log_type_id": {S: aws.String(logType.ID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, 52)
+	if err != nil {
+		requestLogger(c).Error("error listing snapshots", "error", err, "log_type_id", logType.ID)
+		respondStorageError(c, err, "Failed to fetch snapshots")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+func (h *PuzzleHub) putLogSnapshot(ctx context.Context, snapshot *LogSnapshot) error {
+	item, err := dynamodbattribute.MarshalMap(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-snapshots")),
+		Item:      item,
+	})
+	return err
+}
+
+func (h *PuzzleHub) putLogAlert(ctx context.Context, alert *LogAlert) error {
+	item, err := dynamodbattribute.MarshalMap(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-alerts")),
+		Item:      item,
+	})
+	return err
+}
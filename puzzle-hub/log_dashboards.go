@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Log dashboards
+//
+// Users lay out their own dashboards for a log type: a list of charts,
+// each naming a field (or none, to count entries), how to aggregate it,
+// the window (day, week or month), how many windows to show, the chart
+// type and where it sits on a grid. Dashboards are kept in
+// puzzle-hub-log-dashboards under the user.
+//
+// GET /logs/dashboards/:id/data returns every chart's series ready to
+// draw, read from the aggregate buckets (see aggregates.go), so the
+// analytics UI only renders. Series use the user's timezone for the
+// current window.
+
+const (
+	maxDashboardsPerLogType = 20
+	maxDashboardCharts      = 12
+	maxDashboardNameLength  = 60
+	dashboardGridColumns    = 12
+)
+
+// dashboardChartTypes are the chart types the analytics UI draws
+var dashboardChartTypes = map[string]bool{
+	"line":   true,
+	"bar":    true,
+	"area":   true,
+	"number": true, // Single value: the total over the windows
+}
+
+// dashboardWindows is the longest series allowed for each window
+var dashboardWindows = map[string]int{
+	"day":   90,
+	"week":  52,
+	"month": 24,
+}
+
+type LogDashboard struct {
+	UserID    string           `json:"-" dynamodbav:"user_id"`
+	ID        string           `json:"id" dynamodbav:"dashboard_id"` // dash_<unix nanos>
+	LogTypeID string           `json:"log_type_id" dynamodbav:"log_type_id"`
+	Name      string           `json:"name" dynamodbav:"name"`
+	Charts    []DashboardChart `json:"charts" dynamodbav:"charts"`
+	CreatedAt time.Time        `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+type DashboardChart struct {
+	Title       string          `json:"title" dynamodbav:"title"`
+	Type        string          `json:"type" dynamodbav:"type"`                       // line, bar, area or number
+	Field       string          `json:"field,omitempty" dynamodbav:"field,omitempty"` // A number field; empty counts entries
+	Aggregation string          `json:"aggregation" dynamodbav:"aggregation"`         // count, sum or average
+	Window      string          `json:"window" dynamodbav:"window"`                   // day, week or month
+	Periods     int             `json:"periods" dynamodbav:"periods"`                 // Windows shown, ending with the current one
+	Layout      DashboardLayout `json:"layout" dynamodbav:"layout"`
+}
+
+// DashboardLayout places a chart on a 12 column grid
+type DashboardLayout struct {
+	X      int `json:"x" dynamodbav:"x"`
+	Y      int `json:"y" dynamodbav:"y"`
+	Width  int `json:"width" dynamodbav:"width"`
+	Height int `json:"height" dynamodbav:"height"`
+}
+
+type SaveDashboardRequest struct {
+	Name   string           `json:"name" binding:"required"`
+	Charts []DashboardChart `json:"charts"`
+}
+
+type DashboardChartData struct {
+	DashboardChart
+	Points []DashboardPoint `json:"points"`
+	Total  float64          `json:"total"` // Over all the windows; for average charts, the average of every entry in them
+}
+
+type DashboardPoint struct {
+	Period string  `json:"period"` // 2025-01-02, 2025-W01 or 2025-01
+	Value  float64 `json:"value"`
+}
+
+// validate checks a dashboard against its log type's fields and fills in
+// defaults
+func (r *SaveDashboardRequest) validate(fields []LogField) string {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > maxDashboardNameLength {
+		return fmt.Sprintf("Name must be 1 to %d characters", maxDashboardNameLength)
+	}
+	if len(r.Charts) > maxDashboardCharts {
+		return fmt.Sprintf("A dashboard can have at most %d charts", maxDashboardCharts)
+	}
+	if r.Charts == nil {
+		r.Charts = []DashboardChart{}
+	}
+
+	fieldTypes := make(map[string]FieldType, len(fields))
+	for _, field := range fields {
+		fieldTypes[field.FieldName] = field.FieldType
+	}
+
+	for i := range r.Charts {
+		chart := &r.Charts[i]
+		position := fmt.Sprintf("Chart %d: ", i+1)
+		chart.Title = strings.TrimSpace(chart.Title)
+		if len(chart.Title) > maxDashboardNameLength {
+			return position + fmt.Sprintf("title must be at most %d characters", maxDashboardNameLength)
+		}
+		if chart.Type == "" {
+			chart.Type = "line"
+		}
+		if !dashboardChartTypes[chart.Type] {
+			return position + "type must be line, bar, area or number"
+		}
+
+		if chart.Field == "" {
+			chart.Aggregation = "count"
+		} else {
+			fieldType, ok := fieldTypes[chart.Field]
+			if !ok {
+				return position + fmt.Sprintf("the log type has no field %q", chart.Field)
+			}
+			if fieldType != FieldTypeNumber {
+				return position + fmt.Sprintf("%q isn't a number field", chart.Field)
+			}
+			if chart.Aggregation == "" {
+				chart.Aggregation = "sum"
+			}
+		}
+		switch chart.Aggregation {
+		case "count", "sum", "average":
+		default:
+			return position + "aggregation must be count, sum or average"
+		}
+
+		if chart.Window == "" {
+			chart.Window = "week"
+		}
+		maxPeriods, ok := dashboardWindows[chart.Window]
+		if !ok {
+			return position + "window must be day, week or month"
+		}
+		if chart.Periods == 0 {
+			chart.Periods = maxPeriods / 2
+		}
+		if chart.Periods < 1 || chart.Periods > maxPeriods {
+			return position + fmt.Sprintf("periods must be 1 to %d for %s windows", maxPeriods, chart.Window)
+		}
+
+		layout := &chart.Layout
+		if layout.Width == 0 {
+			layout.Width = dashboardGridColumns / 2
+		}
+		if layout.Height == 0 {
+			layout.Height = 4
+		}
+		if layout.X < 0 || layout.Y < 0 || layout.Width < 1 || layout.Height < 1 || layout.X+layout.Width > dashboardGridColumns {
+			return position + fmt.Sprintf("layout must fit a %d column grid", dashboardGridColumns)
+		}
+		if chart.Title == "" {
+			chart.Title = defaultChartTitle(chart)
+		}
+	}
+	return ""
+}
+
+// defaultChartTitle names a chart after what it shows, e.g. "Weekly
+// average reps"
+func defaultChartTitle(chart *DashboardChart) string {
+	period := map[string]string{"day": "Daily", "week": "Weekly", "month": "Monthly"}[chart.Window]
+	if chart.Field == "" {
+		return period + " entries"
+	}
+	if chart.Aggregation == "average" {
+		return period + " average " + chart.Field
+	}
+	return period + " " + chart.Field
+}
+
+// listLogDashboards lists the user's dashboards for a log type
+func (h *PuzzleHub) listLogDashboards(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	logType, status, err := h.getOwnedLogType(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	dashboards, err := h.queryLogDashboards(c.Request.Context(), userObj.ID, logType.ID)
+	if err != nil {
+		requestLogger(c).Error("error listing dashboards", "error", err, "log_type_id", logType.ID)
+		respondStorageError(c, err, "Failed to fetch dashboards")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dashboards": dashboards})
+}
+
+// createLogDashboard saves a new dashboard for a log type
+func (h *PuzzleHub) createLogDashboard(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	var request SaveDashboardRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	logType, status, err := h.getOwnedLogType(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+	fields, err := h.getLogFields(logType.ID)
+	if err != nil {
+		requestLogger(c).Error("error querying log fields", "error", err, "log_type_id", logType.ID)
+		respondStorageError(c, err, "Failed to fetch log fields")
+		return
+	}
+	if problem := request.validate(fields); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+
+	existing, err := h.queryLogDashboards(ctx, userObj.ID, logType.ID)
+	if err != nil {
+		requestLogger(c).Error("error listing dashboards", "error", err, "log_type_id", logType.ID)
+		respondStorageError(c, err, "Failed to save dashboard")
+		return
+	}
+	if len(existing) >= maxDashboardsPerLogType {
+		respondError(c, http.StatusConflict, fmt.Sprintf("A log type can have at most %d dashboards", maxDashboardsPerLogType))
+		return
+	}
+
+	now := time.Now()
+	dashboard := &LogDashboard{
+		UserID:    userObj.ID,
+		ID:        fmt.Sprintf("dash_%d", now.UnixNano()),
+		LogTypeID: logType.ID,
+		Name:      request.Name,
+		Charts:    request.Charts,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := h.putLogDashboard(ctx, dashboard); err != nil {
+		requestLogger(c).Error("error saving dashboard", "error", err, "log_type_id", logType.ID)
+		respondStorageError(c, err, "Failed to save dashboard")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"dashboard": dashboard})
+}
+
+// getLogDashboard returns one dashboard's layout
+func (h *PuzzleHub) getLogDashboard(c *gin.Context) {
+	dashboard, ok := h.requireLogDashboard(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dashboard": dashboard})
+}
+
+// updateLogDashboard replaces a dashboard's name and charts
+func (h *PuzzleHub) updateLogDashboard(c *gin.Context) {
+	var request SaveDashboardRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	dashboard, ok := h.requireLogDashboard(c)
+	if !ok {
+		return
+	}
+	fields, err := h.getLogFields(dashboard.LogTypeID)
+	if err != nil {
+		requestLogger(c).Error("error querying log fields", "error", err, "log_type_id", dashboard.LogTypeID)
+		respondStorageError(c, err, "Failed to fetch log fields")
+		return
+	}
+	if problem := request.validate(fields); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+
+	dashboard.Name = request.Name
+	dashboard.Charts = request.Charts
+	dashboard.UpdatedAt = time.Now()
+	if err := h.putLogDashboard(c.Request.Context(), dashboard); err != nil {
+		requestLogger(c).Error("error saving dashboard", "error", err, "dashboard_id", dashboard.ID)
+		respondStorageError(c, err, "Failed to save dashboard")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dashboard": dashboard})
+}
+
+// deleteLogDashboard deletes one of the user's dashboards
+func (h *PuzzleHub) deleteLogDashboard(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-dashboards")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":      {S: aws.String(userObj.ID)},
+			"dashboard_id": {S: aws.String(c.Param("id"))},
+		},
+		ConditionExpression: aws.String("attribute_exists(dashboard_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Dashboard not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error deleting dashboard", "error", err, "dashboard_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to delete dashboard")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Dashboard deleted"})
+}
+
+// getLogDashboardData returns the series for every chart on a dashboard.
+// Charts whose field was deleted since come back as zeros.
+func (h *PuzzleHub) getLogDashboardData(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	dashboard, ok := h.requireLogDashboard(c)
+	if !ok {
+		return
+	}
+
+	loc := userLocation(userObj)
+	today := time.Now().In(loc)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+
+	// Day buckets sort first, then months, the total and weeks, so the
+	// earliest bucket any chart needs covers all of them
+	from := aggregateTotalBucket
+	for _, chart := range dashboard.Charts {
+		periods := dashboardPeriods(chart, today)
+		if len(periods) > 0 && periods[0] < from {
+			from = periods[0]
+		}
+	}
+	aggregates, err := h.getLogAggregates(userObj.ID, dashboard.LogTypeID, from)
+	if err != nil {
+		requestLogger(c).Error("error reading aggregates for dashboard", "error", err, "dashboard_id", dashboard.ID)
+		respondStorageError(c, err, "Failed to fetch dashboard data")
+		return
+	}
+
+	charts := make([]DashboardChartData, len(dashboard.Charts))
+	for i, chart := range dashboard.Charts {
+		charts[i] = dashboardChartData(chart, aggregates, today)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dashboard": dashboard,
+		"charts":    charts,
+	})
+}
+
+// dashboardPeriods returns the aggregate buckets a chart shows, oldest
+// first, ending with the window containing today
+func dashboardPeriods(chart DashboardChart, today time.Time) []string {
+	buckets := make([]string, chart.Periods)
+	for i := 0; i < chart.Periods; i++ {
+		back := chart.Periods - 1 - i
+		switch chart.Window {
+		case "day":
+			buckets[i] = "day#" + today.AddDate(0, 0, -back).Format("2006-01-02")
+		case "week":
+			buckets[i] = weekBucket(today.AddDate(0, 0, -7*back))
+		case "month":
+			firstOfMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+			buckets[i] = "month#" + firstOfMonth.AddDate(0, -back, 0).Format("2006-01")
+		}
+	}
+	return buckets
+}
+
+// dashboardChartData reads one chart's points from the aggregates
+func dashboardChartData(chart DashboardChart, aggregates map[string]LogAggregate, today time.Time) DashboardChartData {
+	data := DashboardChartData{DashboardChart: chart, Points: []DashboardPoint{}}
+	var sum float64
+	var count int
+	for _, bucket := range dashboardPeriods(chart, today) {
+		agg := aggregates[bucket]
+		var value float64
+		switch chart.Aggregation {
+		case "count":
+			value = float64(agg.Count)
+			sum += value
+		case "sum":
+			value = agg.Sums[chart.Field]
+			sum += value
+		case "average":
+			if n := agg.Counts[chart.Field]; n > 0 {
+				value = agg.Sums[chart.Field] / float64(n)
+				sum += agg.Sums[chart.Field]
+				count += n
+			}
+		}
+		period := bucket[strings.Index(bucket, "#")+1:]
+		data.Points = append(data.Points, DashboardPoint{Period: period, Value: value})
+	}
+
+	data.Total = sum
+	if chart.Aggregation == "average" {
+		data.Total = 0
+		if count > 0 {
+			data.Total = sum / float64(count)
+		}
+	}
+	return data
+}
+
+// requireLogDashboard loads the caller's dashboard :id
+func (h *PuzzleHub) requireLogDashboard(c *gin.Context) (*LogDashboard, bool) {
+	userObj := c.MustGet("user").(*User)
+	result, err := h.DynamoDB.GetItemWithContext(c.Request.Context(), &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-dashboards")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":      {S: aws.String(userObj.ID)},
+			"dashboard_id": {S: aws.String(c.Param("id"))},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error fetching dashboard", "error", err, "dashboard_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to fetch dashboard")
+		return nil, false
+	}
+	if result.Item == nil {
+		respondError(c, http.StatusNotFound, "Dashboard not found")
+		return nil, false
+	}
+	var dashboard LogDashboard
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &dashboard); err != nil {
+		requestLogger(c).Error("error unmarshaling dashboard", "error", err, "dashboard_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to fetch dashboard")
+		return nil, false
+	}
+	return &dashboard, true
+}
+
+// queryLogDashboards returns the user's dashboards for a log type, oldest
+// first
+func (h *PuzzleHub) queryLogDashboards(ctx context.Context, userID, logTypeID string) ([]LogDashboard, error) {
+	return queryLimit[LogDashboard](ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-log-dashboards")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		FilterExpression:       aws.String("log_type_id = :log_type_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id":     {S: aws.String(userID)},
+			":log_type_id": {S: aws.String(logTypeID)},
+		},
+	}, 0)
+}
+
+func (h *PuzzleHub) putLogDashboard(ctx context.Context, dashboard *LogDashboard) error {
+	item, err := dynamodbattribute.MarshalMap(dashboard)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dashboard: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-dashboards")),
+		Item:      item,
+	})
+	return err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Log type schemas
+//
+// A log type's definition (name, description, color, icon and its fields
+// with their types, required flags, options and defaults) can be exported
+// as a standalone JSON document and imported by anyone, so tracking
+// templates can be passed around and collected in a community library.
+// The document carries no IDs, owners or entries; importing it always
+// creates a fresh log type for the caller.
+//
+// schema_version lets the format evolve. Imports of a newer version than
+// this server understands are rejected rather than half-applied.
+
+const (
+	logTypeSchemaVersion   = 1
+	maxSchemaFields        = 50
+	maxSchemaNameLength    = 80
+	maxSchemaOptionsLength = 4000
+)
+
+// logFieldTypes are the field types an imported schema may use
+var logFieldTypes = map[FieldType]bool{
+	FieldTypeText:        true,
+	FieldTypeNumber:      true,
+	FieldTypeDate:        true,
+	FieldTypeTime:        true,
+	FieldTypeSelect:      true,
+	FieldTypeCheckbox:    true,
+	FieldTypeTextarea:    true,
+	FieldTypeMultiselect: true,
+	FieldTypeTags:        true,
+}
+
+type LogTypeSchema struct {
+	SchemaVersion int                     `json:"schema_version"`
+	Name          string                  `json:"name" binding:"required"`
+	Description   string                  `json:"description"`
+	Color         string                  `json:"color"`
+	Icon          string                  `json:"icon"`
+	Fields        []CreateLogFieldRequest `json:"fields"`
+}
+
+// validate checks an imported schema and trims its names, returning a
+// message for the client when it can't be imported
+func (s *LogTypeSchema) validate() string {
+	if s.SchemaVersion < 1 || s.SchemaVersion > logTypeSchemaVersion {
+		return fmt.Sprintf("schema_version must be 1 to %d", logTypeSchemaVersion)
+	}
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" || len(s.Name) > maxSchemaNameLength {
+		return fmt.Sprintf("Name must be 1 to %d characters", maxSchemaNameLength)
+	}
+	if len(s.Fields) > maxSchemaFields {
+		return fmt.Sprintf("A log type can have at most %d fields", maxSchemaFields)
+	}
+
+	seen := make(map[string]bool, len(s.Fields))
+	for i := range s.Fields {
+		field := &s.Fields[i]
+		position := fmt.Sprintf("Field %d: ", i+1)
+		field.FieldName = strings.TrimSpace(field.FieldName)
+		if field.FieldName == "" || len(field.FieldName) > maxSchemaNameLength {
+			return position + fmt.Sprintf("field_name must be 1 to %d characters", maxSchemaNameLength)
+		}
+		if seen[field.FieldName] {
+			return position + fmt.Sprintf("field_name %q is used more than once", field.FieldName)
+		}
+		seen[field.FieldName] = true
+
+		fieldType := FieldType(field.FieldType)
+		if !logFieldTypes[fieldType] {
+			return position + fmt.Sprintf("unknown field_type %q", field.FieldType)
+		}
+		if len(field.Options) > maxSchemaOptionsLength {
+			return position + fmt.Sprintf("options must be at most %d characters", maxSchemaOptionsLength)
+		}
+		if (fieldType == FieldTypeSelect || fieldType == FieldTypeMultiselect) && len(parseFieldOptions(field.Options)) == 0 {
+			return position + fmt.Sprintf("%s fields need at least one option", fieldType)
+		}
+	}
+	return ""
+}
+
+// exportLogTypeSchema returns a log type's definition as a schema
+// document. ?download=true serves it as a file.
+func (h *PuzzleHub) exportLogTypeSchema(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	logType, status, err := h.getSharedLogType(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	fields, err := h.getLogFields(logType.ID)
+	if err != nil {
+		requestLogger(c).Error("error querying log fields for schema export", "error", err)
+		respondStorageError(c, err, "Failed to fetch log fields")
+		return
+	}
+
+	schema := LogTypeSchema{
+		SchemaVersion: logTypeSchemaVersion,
+		Name:          logType.Name,
+		Description:   logType.Description,
+		Color:         logType.Color,
+		Icon:          logType.Icon,
+		Fields:        make([]CreateLogFieldRequest, 0, len(fields)),
+	}
+	for _, field := range fields {
+		schema.Fields = append(schema.Fields, CreateLogFieldRequest{
+			FieldName:    field.FieldName,
+			FieldType:    string(field.FieldType),
+			Required:     field.Required,
+			DefaultValue: field.DefaultValue,
+			Options:      field.Options,
+		})
+	}
+
+	if c.Query("download") == "true" {
+		filename := fmt.Sprintf("log_type_%s.json", logType.ID)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	c.JSON(http.StatusOK, schema)
+}
+
+// importLogTypeSchema creates a new log type for the user from a schema
+// document
+func (h *PuzzleHub) importLogTypeSchema(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var schema LogTypeSchema
+	if err := c.ShouldBindJSON(&schema); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if message := schema.validate(); message != "" {
+		respondError(c, http.StatusBadRequest, message)
+		return
+	}
+
+	logTypeID, err := h.insertLogType(userObj.ID, CreateLogTypeRequest{
+		Name:        schema.Name,
+		Description: schema.Description,
+		Color:       schema.Color,
+		Icon:        schema.Icon,
+		Fields:      schema.Fields,
+	})
+	if err != nil {
+		requestLogger(c).Error("error importing log type schema", "error", err)
+		respondStorageError(c, err, "Failed to import log type")
+		return
+	}
+
+	log.Printf("📥 Imported log type schema %q with %d fields for user %s", schema.Name, len(schema.Fields), userObj.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Log type imported successfully",
+		"log_type_id": logTypeID,
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Structured logging. Everything goes through slog, in one of three
+// LOG_FORMATs:
+//
+//   - json (the default): one JSON object per line for the log pipeline
+//   - plain (or text): key=value lines for platforms that collect stderr as is
+//   - pretty: "15:04:05 INFO  message key=value" for a terminal
+//
+// Messages keep their emoji only in pretty; json and plain drop them, along
+// with the space after, so aggregators and terminals without UTF-8 (the
+// Windows console among them) get clean ASCII prefixes. Older log.Printf
+// calls are routed through the same handler, at a level picked from how the
+// message starts (see printfLogLevel). LOG_LEVEL is debug, info (default),
+// warn or error.
+//
+// Request logs carry request_id, so a line can be matched with the
+// X-Request-ID a user reports; handlers log errors through requestLogger
+// for the same reason. Identify users by ID only; emails and other personal
+// details stay out of the logs.
+
+// setupLogging installs the slog default handler; call it before anything logs
+func setupLogging() {
+	level := slog.LevelInfo
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	format := strings.ToLower(strings.TrimSpace(os.Getenv("LOG_FORMAT")))
+	switch format {
+	case "pretty":
+		handler = &prettyLogHandler{out: os.Stderr, mu: &sync.Mutex{}, level: level}
+		logEmoji = true
+	case "plain", "text":
+		handler = plainLogHandler{slog.NewTextHandler(os.Stderr, options)}
+	default:
+		handler = plainLogHandler{slog.NewJSONHandler(os.Stderr, options)}
+	}
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	log.SetOutput(printfLogWriter{})
+	if format != "" && format != "json" && format != "plain" && format != "text" && format != "pretty" {
+		slog.Warn("Ignoring invalid LOG_FORMAT, using json", "value", format)
+	}
+}
+
+// printfLogWriter passes log.Printf lines on to slog
+type printfLogWriter struct{}
+
+func (printfLogWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	slog.Log(context.Background(), printfLogLevel(message), message)
+	return len(p), nil
+}
+
+// printfLogLevel reads the level of a log.Printf line from its prefix, so
+// failures logged outside handlers still show with LOG_LEVEL=warn or error
+func printfLogLevel(message string) slog.Level {
+	for _, prefix := range []string{"Error", "Failed", "❌", "🔴", "💥"} {
+		if strings.HasPrefix(message, prefix) {
+			return slog.LevelError
+		}
+	}
+	for _, prefix := range []string{"Warning", "⚠️", "🐢"} {
+		if strings.HasPrefix(message, prefix) {
+			return slog.LevelWarn
+		}
+	}
+	return slog.LevelInfo
+}
+
+// logEmoji is set when the log format keeps emoji, for output written
+// outside slog such as the startup banner
+var logEmoji bool
+
+// consoleText returns s for the console, without emoji unless
+// LOG_FORMAT=pretty
+func consoleText(s string) string {
+	if logEmoji {
+		return s
+	}
+	return stripEmoji(s)
+}
+
+// isEmojiRune reports whether r is part of an emoji: a pictograph or
+// symbol, a skin tone, or the joiners and selectors between them
+func isEmojiRune(r rune) bool {
+	switch {
+	case r == '\u200d', r == '\u20e3', r >= '\ufe00' && r <= '\ufe0f':
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff, r >= 0x1f1e6 && r <= 0x1f1ff:
+		return true
+	}
+	return r >= 0x2190 && unicode.Is(unicode.So, r) // Past ©, ® and °
+}
+
+// stripEmoji removes emoji from s, with the spaces that followed them
+func stripEmoji(s string) string {
+	if !strings.ContainsFunc(s, isEmojiRune) {
+		return s
+	}
+	var b strings.Builder
+	skipSpace := false
+	for _, r := range s {
+		switch {
+		case isEmojiRune(r):
+			skipSpace = true
+		case skipSpace && r == ' ':
+		default:
+			skipSpace = false
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// plainLogHandler drops emoji from messages before passing them on
+type plainLogHandler struct {
+	slog.Handler
+}
+
+func (h plainLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Message = stripEmoji(r.Message)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h plainLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return plainLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h plainLogHandler) WithGroup(name string) slog.Handler {
+	return plainLogHandler{h.Handler.WithGroup(name)}
+}
+
+// prettyLogHandler writes one short line per record for reading in a
+// terminal: time, level, message, then the attributes as key=value
+type prettyLogHandler struct {
+	out    io.Writer
+	mu     *sync.Mutex // Shared by the handlers WithAttrs derives
+	level  slog.Level
+	attrs  []slog.Attr
+	prefix string // Group names, dot separated
+}
+
+func (h *prettyLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *prettyLogHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Time.Format("15:04:05"))
+	fmt.Fprintf(&b, " %-5s %s", r.Level.String(), r.Message)
+	for _, attr := range h.attrs {
+		writePrettyAttr(&b, "", attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		writePrettyAttr(&b, h.prefix, attr)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *prettyLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	derived.attrs = append(derived.attrs, h.attrs...)
+	for _, attr := range attrs {
+		if h.prefix != "" {
+			attr.Key = h.prefix + attr.Key
+		}
+		derived.attrs = append(derived.attrs, attr)
+	}
+	return &derived
+}
+
+func (h *prettyLogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	derived := *h
+	derived.prefix = h.prefix + name + "."
+	return &derived
+}
+
+// writePrettyAttr appends " key=value", quoting values with spaces and
+// flattening groups into dotted keys
+func writePrettyAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			writePrettyAttr(b, prefix, member)
+		}
+		return
+	}
+	value := attr.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, attr.Key, value)
+}
+
+// requestLogMiddleware logs every request once it completes. Probes and
+// metric scrapes are logged at debug level so they don't drown out traffic.
+func requestLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := c.Writer.Status()
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		case route == "/healthz" || route == "/readyz" || route == "/metrics":
+			level = slog.LevelDebug
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", route,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", max(c.Writer.Size(), 0), // -1 before anything is written
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		requestLogger(c).Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// requestLogger returns a logger tagged with the request ID and, once
+// authentication has run, the user ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	logger := slog.Default()
+	if requestID := c.GetString("request_id"); requestID != "" {
+		logger = logger.With("request_id", requestID)
+	}
+	if user, ok := c.Get("user"); ok {
+		if userObj, ok := user.(*User); ok && userObj != nil {
+			logger = logger.With("user_id", userObj.ID)
+		}
+	}
+	return logger
+}
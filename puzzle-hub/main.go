@@ -1,29 +1,36 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
 	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
@@ -46,6 +53,8 @@ type SpellingProblem struct {
 	AgeGroup      string   `json:"age_group"`
 	Hints         []string `json:"hints"`
 	PhoneticGuide string   `json:"phonetic,omitempty"`
+	GenerationID  string   `json:"generation_id,omitempty"`
+	PromptTag
 }
 
 type GenerationCriteria struct {
@@ -55,20 +64,18 @@ type GenerationCriteria struct {
 	Theme            string `json:"theme,omitempty"`
 	IncludePhonetics bool   `json:"include_phonetics"`
 	IncludeHints     bool   `json:"include_hints"`
-}
+	ForceRefresh     bool   `json:"force_refresh,omitempty"` // Skip cached problems
+
+	// Accessibility, also turned on by the player's preferences
+	DyslexiaFriendly   bool `json:"dyslexia_friendly,omitempty"`
+	SimplifiedLanguage bool `json:"simplified_language,omitempty"`
 
-type ProblemCache struct {
-	Problems []SpellingProblem `json:"problems"`
-	Metadata struct {
-		GeneratedAt time.Time          `json:"generated_at"`
-		Criteria    GenerationCriteria `json:"criteria"`
-		Source      string             `json:"source"`
-	} `json:"metadata"`
+	CreativityOptions // Temperature, see ai_temperature.go
 }
 
 // Writing App Types
 type WritingAnalysisRequest struct {
-	Text       string `json:"text" binding:"required"`
+	Text       string `json:"text" binding:"required" prompt:"text"`
 	GradeLevel int    `json:"gradeLevel" binding:"required"`
 	Title      string `json:"title,omitempty"`
 }
@@ -80,23 +87,29 @@ type WritingAnalysisResponse struct {
 	ContextSuggestions []ContextSuggestion `json:"contextSuggestions"`
 	NarrativeAnalysis  NarrativeAnalysis   `json:"narrativeAnalysis"`
 	Summary            string              `json:"summary"`
+	Readability        *ReadabilityMetrics `json:"readability,omitempty"` // Only when AI is unavailable
+	Source             string              `json:"source"`                // ai or fallback
+	GenerationID       string              `json:"generation_id,omitempty"`
+	PromptTag
 }
 
 type GrammarError struct {
-	StartIndex  int    `json:"startIndex"`
-	EndIndex    int    `json:"endIndex"`
-	ErrorType   string `json:"errorType"`
-	Original    string `json:"original"`
-	Suggestion  string `json:"suggestion"`
-	Explanation string `json:"explanation"`
+	StartIndex  int         `json:"startIndex"`
+	EndIndex    int         `json:"endIndex"`
+	ErrorType   string      `json:"errorType"`
+	Original    string      `json:"original"`
+	Suggestion  string      `json:"suggestion"`
+	Explanation string      `json:"explanation"`
+	Anchor      *TextAnchor `json:"anchor,omitempty"` // Survives edits, see writing_anchors.go
 }
 
 type VocabularyTip struct {
-	StartIndex  int      `json:"startIndex"`
-	EndIndex    int      `json:"endIndex"`
-	Original    string   `json:"original"`
-	Suggestions []string `json:"suggestions"`
-	Explanation string   `json:"explanation"`
+	StartIndex  int         `json:"startIndex"`
+	EndIndex    int         `json:"endIndex"`
+	Original    string      `json:"original"`
+	Suggestions []string    `json:"suggestions"`
+	Explanation string      `json:"explanation"`
+	Anchor      *TextAnchor `json:"anchor,omitempty"`
 }
 
 type ContextSuggestion struct {
@@ -127,15 +140,20 @@ type StoryRequest struct {
 	Tone        string   `json:"tone"`
 	Length      string   `json:"length"`
 	RequestType string   `json:"requestType"` // "prompt", "character", "plot", "twist", "setting"
+
+	CreativityOptions // Temperature, see ai_temperature.go
 }
 
 type StoryResponse struct {
-	Title       string    `json:"title"`
-	Content     string    `json:"content"`
-	Ideas       []string  `json:"ideas,omitempty"`
-	Tips        []string  `json:"tips,omitempty"`
-	Questions   []string  `json:"questions,omitempty"`
-	GeneratedAt time.Time `json:"generated_at"`
+	Title        string    `json:"title"`
+	Content      string    `json:"content"`
+	Ideas        []string  `json:"ideas,omitempty"`
+	Tips         []string  `json:"tips,omitempty"`
+	Questions    []string  `json:"questions,omitempty"`
+	GeneratedAt  time.Time `json:"generated_at"`
+	Source       string    `json:"source"` // ai or fallback
+	GenerationID string    `json:"generation_id,omitempty"`
+	PromptTag
 }
 
 // Feedback System Types
@@ -162,16 +180,34 @@ type Feedback struct {
 	UseCase     string       `json:"use_case,omitempty" dynamodbav:"use_case"`       // Why they want it
 	CreatedAt   time.Time    `json:"created_at" dynamodbav:"created_at"`
 	Status      string       `json:"status" dynamodbav:"status"` // "new", "reviewed", "in-progress", "completed"
+
+	// Admin triage, never shown to the submitting user
+	AssignedTo    string         `json:"assigned_to,omitempty" dynamodbav:"assigned_to,omitempty"`
+	InternalNotes []FeedbackNote `json:"internal_notes,omitempty" dynamodbav:"internal_notes,omitempty"`
+	UpdatedAt     time.Time      `json:"updated_at,omitempty" dynamodbav:"updated_at,omitempty"`
+
+	// Public roadmap (feature requests only)
+	Public    bool `json:"public,omitempty" dynamodbav:"public,omitempty"`
+	VoteCount int  `json:"vote_count,omitempty" dynamodbav:"vote_count,omitempty"`
+
+	Attachments []FeedbackAttachment `json:"attachments,omitempty" dynamodbav:"attachments,omitempty"`
+}
+
+type FeedbackNote struct {
+	Author    string    `json:"author" dynamodbav:"author"`
+	Note      string    `json:"note" dynamodbav:"note"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
 }
 
 type FeedbackSubmission struct {
-	Type        FeedbackType `json:"type" binding:"required"`
-	AppName     string       `json:"app_name,omitempty"`
-	Rating      int          `json:"rating,omitempty"`
-	Title       string       `json:"title" binding:"required"`
-	Description string       `json:"description" binding:"required"`
-	AIAppIdea   string       `json:"ai_app_idea,omitempty"`
-	UseCase     string       `json:"use_case,omitempty"`
+	Type        FeedbackType          `json:"type" binding:"required"`
+	AppName     string                `json:"app_name,omitempty"`
+	Rating      int                   `json:"rating,omitempty"`
+	Title       string                `json:"title" binding:"required"`
+	Description string                `json:"description" binding:"required"`
+	AIAppIdea   string                `json:"ai_app_idea,omitempty"`
+	UseCase     string                `json:"use_case,omitempty"`
+	Attachments []AttachmentReference `json:"attachments,omitempty"`
 }
 
 // Yohaku Types
@@ -195,6 +231,7 @@ type YohakuGameSession struct {
 	CompletedCount int            `json:"completedCount"`
 	StartTime      time.Time      `json:"startTime"`
 	Settings       GameSettings   `json:"settings"`
+	Seed           int64          `json:"seed,omitempty"` // Regenerates the same puzzles, see challenges.go
 }
 
 type Cell struct {
@@ -215,6 +252,7 @@ type GameSettings struct {
 	Operation     string      `json:"operation"`
 	Range         NumberRange `json:"range"`
 	Difficulty    string      `json:"difficulty"`
+	Untimed       bool        `json:"untimed,omitempty"` // No timer; set from the reduced_time_pressure preference
 }
 
 // Authentication Types
@@ -226,12 +264,19 @@ type User struct {
 	GoogleID    string    `json:"googleId"`
 	CreatedAt   time.Time `json:"createdAt"`
 	LastLoginAt time.Time `json:"lastLoginAt"`
+	Timezone    string    `json:"timezone,omitempty"` // IANA zone name, e.g. "America/New_York"
+	Language    string    `json:"language,omitempty"` // Preferred language, see i18n.go
+	Role        Role      `json:"role"`
+	IsGuest     bool      `json:"isGuest,omitempty"` // Anonymous player, see guest.go
 }
 
 type AuthConfig struct {
-	GoogleOAuth  *oauth2.Config
-	SessionStore *sessions.CookieStore
-	JWTSecret    []byte
+	BaseURL       string
+	GoogleOAuth   *oauth2.Config
+	SessionStore  *sessions.CookieStore
+	SecureCookies bool // Set when BASE_URL is https
+	JWTKeys       *JWTKeySet
+	AdminEmails   map[string]bool // Lower-cased emails granted admin access
 }
 
 type GoogleUserInfo struct {
@@ -242,10 +287,12 @@ type GoogleUserInfo struct {
 }
 
 type LoginResponse struct {
-	Success bool   `json:"success"`
-	User    *User  `json:"user,omitempty"`
-	Token   string `json:"token,omitempty"`
-	Message string `json:"message,omitempty"`
+	Success      bool   `json:"success"`
+	User         *User  `json:"user,omitempty"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"` // Access token lifetime in seconds
+	Message      string `json:"message,omitempty"`
 }
 
 // Custom Logging System Types
@@ -259,6 +306,12 @@ type LogType struct {
 	CreatedAt   time.Time  `json:"created_at" dynamodbav:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at" dynamodbav:"updated_at"`
 	Fields      []LogField `json:"fields,omitempty" dynamodbav:"fields"`
+
+	// RetentionDays archives entries older than this many days to S3, 0 keeps everything
+	RetentionDays int `json:"retention_days" dynamodbav:"retention_days"`
+
+	// HouseholdID shares the log type with that household's members, see households.go
+	HouseholdID string `json:"household_id,omitempty" dynamodbav:"household_id,omitempty"`
 }
 
 type FieldType string
@@ -271,6 +324,10 @@ const (
 	FieldTypeSelect   FieldType = "select"
 	FieldTypeCheckbox FieldType = "checkbox"
 	FieldTypeTextarea FieldType = "textarea"
+
+	// Multi-value field types are stored as string arrays in LogEntry.Values
+	FieldTypeMultiselect FieldType = "multiselect" // Subset of the field's Options
+	FieldTypeTags        FieldType = "tags"        // Free-form labels
 )
 
 type LogField struct {
@@ -279,7 +336,7 @@ type LogField struct {
 	FieldName    string    `json:"field_name" dynamodbav:"field_name"`
 	FieldType    FieldType `json:"field_type" dynamodbav:"field_type"`
 	Required     bool      `json:"required" dynamodbav:"required"`
-	Options      string    `json:"options" dynamodbav:"options"` // JSON string for select options
+	Options      string    `json:"options" dynamodbav:"options"` // JSON string for select/multiselect options
 	DefaultValue string    `json:"default_value" dynamodbav:"default_value"`
 	DisplayOrder int       `json:"display_order" dynamodbav:"display_order"`
 }
@@ -292,6 +349,7 @@ type LogEntry struct {
 	CreatedAt time.Time              `json:"created_at" dynamodbav:"created_at"`
 	UpdatedAt time.Time              `json:"updated_at" dynamodbav:"updated_at"`
 	Values    map[string]interface{} `json:"values,omitempty" dynamodbav:"values"`
+	Version   int64                  `json:"version" dynamodbav:"version"` // Incremented on every write, used by offline sync
 	LogType   *LogType               `json:"log_type,omitempty" dynamodbav:"-"`
 }
 
@@ -307,6 +365,30 @@ type LogAnalytics struct {
 	MonthlyTrend  []MonthlyData          `json:"monthly_trend"`
 }
 
+type HeatmapDay struct {
+	Date  string `json:"date"`  // YYYY-MM-DD
+	Count int    `json:"count"` // Entries logged that day
+	Level int    `json:"level"` // 0-4 intensity bucket for rendering
+}
+
+type CorrelationPoint struct {
+	Date string  `json:"date"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+type CorrelationResult struct {
+	XLogTypeID  string             `json:"x_log_type_id"`
+	XField      string             `json:"x_field"`
+	YLogTypeID  string             `json:"y_log_type_id"`
+	YField      string             `json:"y_field"`
+	Aggregate   string             `json:"aggregate"`   // How multiple entries on one day are combined
+	Coefficient *float64           `json:"coefficient"` // Pearson r, nil when it cannot be computed
+	Strength    string             `json:"strength"`
+	PairedDays  int                `json:"paired_days"`
+	Points      []CorrelationPoint `json:"points"`
+}
+
 type MonthlyData struct {
 	Month   string      `json:"month"`
 	Count   int         `json:"count"`
@@ -329,15 +411,28 @@ type CreateLogTypeRequest struct {
 	Fields      []CreateLogFieldRequest `json:"fields"`
 }
 
+type ReorderLogFieldsRequest struct {
+	FieldIDs []string `json:"field_ids" binding:"required"` // Field IDs in the desired display order
+}
+
 type CreateLogEntryRequest struct {
 	LogTypeID string                 `json:"log_type_id" binding:"required"`
 	EntryDate string                 `json:"entry_date" binding:"required"` // YYYY-MM-DD format
 	Values    map[string]interface{} `json:"values" binding:"required"`
 }
 
+type DuplicateLogEntryRequest struct {
+	EntryDate string                 `json:"entry_date"` // YYYY-MM-DD, defaults to today
+	Values    map[string]interface{} `json:"values"`     // Overrides merged over the copied values
+}
+
+type UpdateTimezoneRequest struct {
+	Timezone string `json:"timezone" binding:"required"` // IANA zone name
+}
+
 type SuggestFieldsRequest struct {
 	LogTypeName string `json:"log_type_name" binding:"required"`
-	Description string `json:"description"`
+	Description string `json:"description" prompt:"max=1000"`
 }
 
 type SuggestedField struct {
@@ -352,20 +447,53 @@ type SuggestedField struct {
 type SuggestFieldsResponse struct {
 	SuggestedFields []SuggestedField `json:"suggested_fields"`
 	Explanation     string           `json:"explanation"`
+	Source          string           `json:"source"` // ai or fallback
+	GenerationID    string           `json:"generation_id,omitempty"`
+	PromptTag
 }
 
 // Unified Generator
 type PuzzleHub struct {
-	OpenAIClient    *openai.Client
-	PerplexityKey   string
-	Provider        string
-	HTTPClient      *http.Client
-	CacheDir        string
-	TotalCost       float64
-	YohakuGenerator *YohakuGenerator
-	AuthConfig      *AuthConfig
-	Users           map[string]*User   // Simple in-memory user store
-	DynamoDB        *dynamodb.DynamoDB // AWS DynamoDB for logging system
+	OpenAIClient          *openai.Client
+	PerplexityKey         string
+	AnthropicKey          string
+	GeminiKey             string
+	FeatureProviders      map[string]string // Per-feature provider overrides, see ai_providers.go
+	AIBreakers            aiBreakers        // Per-provider circuit breakers for AI calls
+	AILimits              aiLimiters        // Per-provider limits on concurrent AI calls
+	Prompts               *PromptStore      // AI prompt templates, see prompts.go
+	Moderation            *AIModerator      // Content checks on AI output, see ai_moderation.go
+	Provider              string
+	HTTPClient            *http.Client
+	WebhookClient         *http.Client       // Deliveries to user-chosen URLs, see webhook_client.go
+	AIUsage               *AIUsageTracker    // AI token usage, cost and monthly budget
+	AILatency             *AILatencyWatchdog // Slow-model detection and fallback, see ai_latency.go
+	Errors                *ErrorReporter     // Panic and 5xx reports, keyed by request ID
+	Jobs                  *JobRunner         // Scheduled jobs and the async job queue, see jobs.go
+	Realtime              *RealtimeHub       // WebSocket pub/sub, see realtime.go
+	APIRateLimit          *RateLimiter       // Per-caller budget for all API requests, see ratelimit.go
+	AIRateLimit           *RateLimiter       // Stricter per-caller budget for AI-backed routes
+	AIQuotas              map[string]int     // Daily AI requests per caller and feature, see ai_quotas.go
+	YohakuGenerator       *YohakuGenerator
+	AuthConfig            *AuthConfig
+	DynamoDB              *dynamodb.DynamoDB // AWS DynamoDB for logging system
+	Store                 Storage            // Users, feedback, logs and analytics
+	Cache                 Cache              // Hot reads and AI responses, see cache.go
+	Analytics             *AnalyticsService  // Site visit and login counters
+	S3                    *s3.S3             // AWS S3 for log archives and feedback attachments
+	ArchiveBucket         string             // Bucket for log archives, archival disabled when empty
+	AttachmentBucket      string             // Bucket for feedback attachments, uploads disabled when empty
+	AnalyticsExportBucket string             // Bucket for nightly anonymized analytics exports, export disabled when empty
+	AccountExportBucket   string             // Bucket for account exports users download, see account_export.go
+	Polly                 *polly.Polly       // AWS Polly for spelling bee audio
+	SpellingBeeVoice      string             // Polly voice for spelling bee audio
+	RecordingBucket       string             // Bucket for spelling bee recordings, recordings disabled when empty
+	SES                   *ses.SES           // AWS SES for admin digest emails
+	DigestFromEmail       string             // Sender for the weekly feedback digest, digest disabled when empty
+	NotificationFromEmail string             // Sender for notification emails, see notifications.go
+	ParentReportFromEmail string             // Sender for the weekly parent report, report disabled when empty
+	InboundEmailDomain    string             // Domain SES receives quick-log mail for, see email_logging.go
+	InboundEmailSecret    string             // HMAC secret for the inbound email relay
 }
 
 type YohakuGenerator struct {
@@ -374,8 +502,10 @@ type YohakuGenerator struct {
 
 // Perplexity API types
 type PerplexityRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
 }
 
 type Message struct {
@@ -405,34 +535,82 @@ type PerplexityResponse struct {
 
 // NewPuzzleHub creates a new unified puzzle generator
 // Database initialization functions
-func initializeDynamoDB() (*dynamodb.DynamoDB, error) {
-	// AWS credentials from environment variables
-	awsAccessKey := os.Getenv("AWS_ACCESS_KEY_ID")
-	awsSecretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	awsRegion := os.Getenv("AWS_REGION")
 
-	// Validate required AWS credentials
-	if awsAccessKey == "" {
-		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID environment variable is required")
-	}
-	if awsSecretKey == "" {
-		return nil, fmt.Errorf("AWS_SECRET_ACCESS_KEY environment variable is required")
+// tablePrefix is prepended to every DynamoDB table name (DYNAMODB_TABLE_PREFIX,
+// e.g. "dev-") so several environments can share an account or a LocalStack
+// instance. It is set once at startup by newAWSSession.
+var tablePrefix string
+
+// tableName returns the name of a DynamoDB table in this environment
+func tableName(name string) string {
+	return tablePrefix + name
+}
+
+func newAWSSession() (*session.Session, error) {
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	tablePrefix = os.Getenv("DYNAMODB_TABLE_PREFIX")
+	if demoMode {
+		return newOfflineAWSSession()
+	}
+
+	// Region and credentials come from the standard chain: AWS_* environment
+	// variables, shared config profiles (AWS_PROFILE), web identity tokens,
+	// and ECS/EC2 instance roles
+	config := aws.Config{}
+	if endpoint != "" {
+		// LocalStack serves S3 on the same host, which needs path-style URLs
+		config.Endpoint = aws.String(endpoint)
+		config.S3ForcePathStyle = aws.Bool(true)
+		log.Printf("☁️  Using AWS endpoint %s", endpoint)
+
+		// DynamoDB Local and LocalStack accept any credentials
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" && os.Getenv("AWS_PROFILE") == "" {
+			config.Credentials = credentials.NewStaticCredentials("local", "local", "")
+		}
 	}
-	if awsRegion == "" {
-		awsRegion = "us-east-1" // Default region
+	if tablePrefix != "" {
+		log.Printf("📊 DynamoDB table names are prefixed with %q", tablePrefix)
 	}
 
 	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(awsRegion),
-		Credentials: credentials.NewStaticCredentials(awsAccessKey, awsSecretKey, ""),
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		SharedConfigState: session.SharedConfigEnable,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AWS session: %v", err)
 	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String("us-east-1") // Default region
+	}
+
+	// Fail at startup rather than on the first request. The SQL backends
+	// only use AWS for archives, emails and speech, so they run without.
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil && usesSQLStorage() {
+		log.Println("⚠️  No AWS credentials, so log archives, emails and speech are off")
+		return newOfflineAWSSession()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found (set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, use AWS_PROFILE, or run with an IAM role): %v", err)
+	}
+	log.Printf("☁️  AWS credentials from %s, region %s", creds.ProviderName, aws.StringValue(sess.Config.Region))
+
+	traceAWSRequests(sess)
+	return sess, nil
+}
+
+func initializeDynamoDB(sess *session.Session) (*dynamodb.DynamoDB, error) {
+	switch {
+	case demoMode:
+		return newOfflineDynamoDB("in demo mode")
+	case usesSQLStorage():
+		log.Printf("📊 DynamoDB is off with STORAGE_BACKEND=%s; features outside Storage are unavailable", storageBackend())
+		return newOfflineDynamoDB("with this storage backend")
+	}
 
-	// Create DynamoDB client
-	svc := dynamodb.New(sess)
+	// Create DynamoDB client, see dynamo_access.go
+	svc := newDynamoDBClient(sess)
 
 	// Create tables if they don't exist
 	if err := createDynamoDBTables(svc); err != nil {
@@ -443,16 +621,68 @@ func initializeDynamoDB() (*dynamodb.DynamoDB, error) {
 	return svc, nil
 }
 
+// ensureTimeToLive enables TTL on the attribute unless it's already on
+func ensureTimeToLive(svc *dynamodb.DynamoDB, table, attribute string) {
+	current, err := svc.DescribeTimeToLive(&dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(table),
+	})
+	if err == nil && current.TimeToLiveDescription != nil {
+		status := aws.StringValue(current.TimeToLiveDescription.TimeToLiveStatus)
+		if status == dynamodb.TimeToLiveStatusEnabled || status == dynamodb.TimeToLiveStatusEnabling {
+			return
+		}
+	}
+
+	_, err = svc.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(attribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		log.Printf("⚠️  Failed to enable TTL on %s: %v", table, err)
+	}
+}
+
+// dynamoBillingMode reads DYNAMODB_BILLING_MODE for new tables. On-demand is
+// the default: the schemas' 5 RCU/WCU throttle under any real traffic, and
+// PROVISIONED is only worth it for steady, well-understood load.
+func dynamoBillingMode() (string, error) {
+	switch mode := strings.ToUpper(os.Getenv("DYNAMODB_BILLING_MODE")); mode {
+	case "", dynamodb.BillingModePayPerRequest:
+		return dynamodb.BillingModePayPerRequest, nil
+	case dynamodb.BillingModeProvisioned:
+		return dynamodb.BillingModeProvisioned, nil
+	default:
+		return "", fmt.Errorf("unknown DYNAMODB_BILLING_MODE %q, use PAY_PER_REQUEST or PROVISIONED", mode)
+	}
+}
+
+// applyBillingMode adapts a table schema to the billing mode. The schemas
+// carry provisioned throughput, which on-demand tables must not specify.
+func applyBillingMode(schema *dynamodb.CreateTableInput, mode string) {
+	schema.BillingMode = aws.String(mode)
+	if mode != dynamodb.BillingModePayPerRequest {
+		return
+	}
+	schema.ProvisionedThroughput = nil
+	for _, index := range schema.GlobalSecondaryIndexes {
+		index.ProvisionedThroughput = nil
+	}
+}
+
 func createDynamoDBTables(svc *dynamodb.DynamoDB) error {
 	// Table names
 	tables := []struct {
 		name   string
 		schema *dynamodb.CreateTableInput
+		ttl    string // Optional TTL attribute (unix seconds)
 	}{
 		{
-			name: "puzzle-hub-analytics",
+			name: tableName("puzzle-hub-analytics"),
 			schema: &dynamodb.CreateTableInput{
-				TableName: aws.String("puzzle-hub-analytics"),
+				TableName: aws.String(tableName("puzzle-hub-analytics")),
 				KeySchema: []*dynamodb.KeySchemaElement{
 					{
 						AttributeName: aws.String("id"),
@@ -492,11 +722,12 @@ func createDynamoDBTables(svc *dynamodb.DynamoDB) error {
 					WriteCapacityUnits: aws.Int64(5),
 				},
 			},
+			ttl: "expires_at",
 		},
 		{
-			name: "puzzle-hub-log-types",
+			name: tableName("puzzle-hub-log-types"),
 			schema: &dynamodb.CreateTableInput{
-				TableName: aws.String("puzzle-hub-log-types"),
+				TableName: aws.String(tableName("puzzle-hub-log-types")),
 				KeySchema: []*dynamodb.KeySchemaElement{
 					{
 						AttributeName: aws.String("id"),
@@ -538,9 +769,9 @@ func createDynamoDBTables(svc *dynamodb.DynamoDB) error {
 			},
 		},
 		{
-			name: "puzzle-hub-log-fields",
+			name: tableName("puzzle-hub-log-fields"),
 			schema: &dynamodb.CreateTableInput{
-				TableName: aws.String("puzzle-hub-log-fields"),
+				TableName: aws.String(tableName("puzzle-hub-log-fields")),
 				KeySchema: []*dynamodb.KeySchemaElement{
 					{
 						AttributeName: aws.String("id"),
@@ -582,9 +813,9 @@ func createDynamoDBTables(svc *dynamodb.DynamoDB) error {
 			},
 		},
 		{
-			name: "puzzle-hub-log-entries",
+			name: tableName("puzzle-hub-log-entries"),
 			schema: &dynamodb.CreateTableInput{
-				TableName: aws.String("puzzle-hub-log-entries"),
+				TableName: aws.String(tableName("puzzle-hub-log-entries")),
 				KeySchema: []*dynamodb.KeySchemaElement{
 					{
 						AttributeName: aws.String("id"),
@@ -634,9 +865,99 @@ func createDynamoDBTables(svc *dynamodb.DynamoDB) error {
 			},
 		},
 		{
-			name: "puzzle-hub-feedback",
+			name: tableName("puzzle-hub-sync-changes"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-sync-changes")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("seq"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("seq"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-log-archives"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-log-archives")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("log_type_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("period"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("log_type_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("period"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-log-aggregates"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-log-aggregates")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("log_type_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("bucket"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("log_type_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("bucket"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-feedback"),
 			schema: &dynamodb.CreateTableInput{
-				TableName: aws.String("puzzle-hub-feedback"),
+				TableName: aws.String(tableName("puzzle-hub-feedback")),
 				KeySchema: []*dynamodb.KeySchemaElement{
 					{
 						AttributeName: aws.String("id"),
@@ -685,434 +1006,2204 @@ func createDynamoDBTables(svc *dynamodb.DynamoDB) error {
 				},
 			},
 		},
-	}
-
-	// Create each table if it doesn't exist
-	for _, table := range tables {
-		// Check if table exists
-		_, err := svc.DescribeTable(&dynamodb.DescribeTableInput{
-			TableName: aws.String(table.name),
-		})
-
-		if err != nil {
-			// Table doesn't exist, create it
-			log.Printf("Creating DynamoDB table: %s", table.name)
-			_, err = svc.CreateTable(table.schema)
-			if err != nil {
-				return fmt.Errorf("failed to create table %s: %v", table.name, err)
-			}
-
-			// Wait for table to be active
-			log.Printf("Waiting for table %s to be active...", table.name)
-			err = svc.WaitUntilTableExists(&dynamodb.DescribeTableInput{
-				TableName: aws.String(table.name),
-			})
-			if err != nil {
-				return fmt.Errorf("failed to wait for table %s: %v", table.name, err)
-			}
-		} else {
-			log.Printf("DynamoDB table %s already exists", table.name)
-		}
-	}
-
-	return nil
-}
-
-func NewPuzzleHub(provider string) (*PuzzleHub, error) {
-	cacheDir := "cache"
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create cache directory: %v", err)
-	}
-
-	// Initialize DynamoDB (creates all tables including feedback table)
-	dynamoDB, err := initializeDynamoDB()
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize DynamoDB: %v", err)
-	}
-
-	hub := &PuzzleHub{
-		Provider: provider,
-		CacheDir: cacheDir,
-		HTTPClient: &http.Client{
-			Timeout: 60 * time.Second, // Increased timeout for writing analysis
+		{
+			name: tableName("puzzle-hub-refresh-tokens"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-refresh-tokens")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("token_hash"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("token_hash"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("family_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("family_id-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("family_id"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("KEYS_ONLY"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
 		},
-		YohakuGenerator: &YohakuGenerator{
-			rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		{
+			name: tableName("puzzle-hub-revoked-tokens"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-revoked-tokens")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("jti"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("jti"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-user-roles"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-user-roles")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-classrooms"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-classrooms")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("teacher_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("join_code"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("teacher_id-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("teacher_id"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+					{
+						IndexName: aws.String("join_code-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("join_code"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-classroom-members"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-classroom-members")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("classroom_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("classroom_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("user_id-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("user_id"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-activity-results"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-activity-results")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("result_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("result_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-login-sessions"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-login-sessions")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("session_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("session_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-guest-links"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-guest-links")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("guest_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("guest_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-user-preferences"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-user-preferences")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-parental-invites"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-parental-invites")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("code"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("code"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-parental-links"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-parental-links")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("child_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("child_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("parent_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("parent_id-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("parent_id"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-daily-usage"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-daily-usage")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("day"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("day"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-analytics-rollups"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-analytics-rollups")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("day"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("day"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-analytics-seen"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-analytics-seen")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("key"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("key"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-analytics-salts"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-analytics-salts")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("period"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("period"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-funnel"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-funnel")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("subject_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("subject_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("user_id-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("user_id"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-ai-usage"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-ai-usage")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("period"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("call_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("period"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("call_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("user_id-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("user_id"),
+								KeyType:       aws.String("HASH"),
+							},
+							{
+								AttributeName: aws.String("period"),
+								KeyType:       aws.String("RANGE"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-ai-spend"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-ai-spend")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("period"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("period"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-errors"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-errors")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("request_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("request_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-ai-cache"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-ai-cache")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("cache_key"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("cache_key"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-prompt-templates"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-prompt-templates")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("name"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("version"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("name"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("version"),
+						AttributeType: aws.String("N"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-prompt-active"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-prompt-active")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("name"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("name"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-moderation-flags"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-moderation-flags")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("flag_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("flag_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-prompt-experiments"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-prompt-experiments")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("name"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("name"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-prompt-experiment-stats"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-prompt-experiment-stats")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("experiment_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("variant"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("experiment_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("variant"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-ai-generations"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-ai-generations")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("generation_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("generation_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-ai-ratings"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-ai-ratings")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("generation_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("generation_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-app-ratings"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-app-ratings")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("app_name"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("app_name"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-changelog"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-changelog")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("feed"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("published_at"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("feed"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("published_at"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-changelog-seen"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-changelog-seen")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-jobs"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-jobs")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("job_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("job_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("state"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("visible_at"),
+						AttributeType: aws.String("N"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("state-visible-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("state"),
+								KeyType:       aws.String("HASH"),
+							},
+							{
+								AttributeName: aws.String("visible_at"),
+								KeyType:       aws.String("RANGE"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-job-runs"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-job-runs")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("job"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("period"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("job"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("period"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // Cron slot claims only, see jobs.go
+		},
+		{
+			name: tableName("puzzle-hub-feedback-comments"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-feedback-comments")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("feedback_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("comment_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("feedback_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("comment_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-feedback-votes"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-feedback-votes")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("feedback_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("feedback_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-notifications"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-notifications")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("notification_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("notification_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-ai-quota-usage"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-ai-quota-usage")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("subject"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("day"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("subject"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("day"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // expires_at
+		},
+		{
+			name: tableName("puzzle-hub-ai-quota-overrides"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-ai-quota-overrides")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-fact-mastery"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-fact-mastery")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("fact_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("fact_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-crosswords"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-crosswords")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("crossword_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("crossword_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // Crosswords are kept 30 days
+		},
+		{
+			name: tableName("puzzle-hub-word-problems"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-word-problems")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("problem_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("problem_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // Word problems are kept 30 days
+		},
+		{
+			name: tableName("puzzle-hub-quiz-questions"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-quiz-questions")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("topic"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("question_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("topic"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("question_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-quiz-mastery"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-quiz-mastery")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("topic"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("topic"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-flashcard-decks"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-flashcard-decks")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("deck_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("deck_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-flashcards"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-flashcards")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("card_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("card_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-gamification"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-gamification")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-assignments"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-assignments")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("classroom_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("assignment_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("classroom_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("assignment_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-assignment-submissions"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-assignment-submissions")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("assignment_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("assignment_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-challenges"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-challenges")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("code"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("code"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // Links expire after 14 days
+		},
+		{
+			name: tableName("puzzle-hub-challenge-results"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-challenge-results")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("code"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("code"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at",
+		},
+		{
+			name: tableName("puzzle-hub-classroom-integrations"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-classroom-integrations")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("classroom_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("classroom_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-spelling-bee-sessions"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-spelling-bee-sessions")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("session_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("session_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // Spelling bee sessions, kept for 90 days
+		},
+		{
+			name: tableName("puzzle-hub-custom-yohaku"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-custom-yohaku")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("puzzle_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("puzzle_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("share_code"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("share_code-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("share_code"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-story-drafts"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-story-drafts")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("story_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("story_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-log-snapshots"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-log-snapshots")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("log_type_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("week"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("log_type_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("week"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // kept two years
+		},
+		{
+			name: tableName("puzzle-hub-log-alerts"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-log-alerts")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("alert_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("alert_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // kept 180 days
+		},
+		{
+			name: tableName("puzzle-hub-log-dashboards"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-log-dashboards")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("dashboard_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("dashboard_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-email-log-addresses"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-email-log-addresses")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("address_token"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("address_token-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("address_token"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-households"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-households")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("invite_code"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("invite_code-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("invite_code"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-household-members"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-household-members")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("household_id"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("household_id"),
+						AttributeType: aws.String("S"),
+					},
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String("user_id-index"),
+						KeySchema: []*dynamodb.KeySchemaElement{
+							{
+								AttributeName: aws.String("user_id"),
+								KeyType:       aws.String("HASH"),
+							},
+						},
+						Projection: &dynamodb.Projection{
+							ProjectionType: aws.String("ALL"),
+						},
+						ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+							ReadCapacityUnits:  aws.Int64(5),
+							WriteCapacityUnits: aws.Int64(5),
+						},
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-ai-cache-generations"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-ai-cache-generations")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("generation_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("generation_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+			ttl: "expires_at", // Follows the cached response's expiry
+		},
+		{
+			name: tableName("puzzle-hub-content-reports"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-content-reports")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("report_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("report_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
+		},
+		{
+			name: tableName("puzzle-hub-results-webhooks"),
+			schema: &dynamodb.CreateTableInput{
+				TableName: aws.String(tableName("puzzle-hub-results-webhooks")),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("user_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				AttributeDefinitions: []*dynamodb.AttributeDefinition{
+					{
+						AttributeName: aws.String("user_id"),
+						AttributeType: aws.String("S"),
+					},
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(5),
+					WriteCapacityUnits: aws.Int64(5),
+				},
+			},
 		},
-		DynamoDB: dynamoDB,
-	}
-
-	if provider == "openai" {
-		apiKey := os.Getenv("OPENAI_API_KEY")
-		if apiKey == "" {
-			return nil, fmt.Errorf("OPENAI_API_KEY environment variable is required")
-		}
-		hub.OpenAIClient = openai.NewClient(apiKey)
-	} else if provider == "perplexity" {
-		apiKey := os.Getenv("PERPLEXITY_API_KEY")
-		if apiKey == "" {
-			return nil, fmt.Errorf("PERPLEXITY_API_KEY environment variable is required")
-		}
-		hub.PerplexityKey = apiKey
-	} else {
-		return nil, fmt.Errorf("AI_PROVIDER must be 'openai' or 'perplexity'. Please set PERPLEXITY_API_KEY or OPENAI_API_KEY environment variable")
-	}
-
-	// Initialize authentication
-	authConfig, err := initializeAuth()
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize auth: %v", err)
-	}
-	hub.AuthConfig = authConfig
-	hub.Users = make(map[string]*User)
-
-	return hub, nil
-}
-
-// Spelling Bee Methods
-func (h *PuzzleHub) GenerateSpellingProblems(criteria GenerationCriteria) ([]SpellingProblem, error) {
-	log.Printf("🎯 Generating %d spelling problems for age %s, difficulty %s, theme %s",
-		criteria.WordCount, criteria.AgeGroup, criteria.DifficultyLevel, criteria.Theme)
-
-	// Try to load from cache first
-	if cachedProblems, err := h.loadFromCache(criteria); err == nil {
-		var filteredProblems []SpellingProblem
-		for _, problem := range cachedProblems {
-			if len(problem.Word) >= 6 {
-				filteredProblems = append(filteredProblems, problem)
-			}
-		}
-
-		if len(filteredProblems) >= criteria.WordCount {
-			if len(filteredProblems) > criteria.WordCount {
-				filteredProblems = filteredProblems[:criteria.WordCount]
-			}
-			log.Printf("✅ Using %d cached problems", len(filteredProblems))
-			return filteredProblems, nil
-		}
 	}
 
-	prompt := h.buildSpellingPrompt(criteria)
-
-	var response string
-	var err error
-	var source string
-
-	if h.Provider == "openai" {
-		log.Printf("🔵 Using OpenAI API")
-		response, err = h.generateWithOpenAI(prompt)
-		source = "api"
-	} else if h.Provider == "perplexity" {
-		log.Printf("🟣 Using Perplexity API")
-		response, err = h.generateWithPerplexity(prompt)
-		source = "api"
-	} else {
-		log.Printf("🔄 Using fallback mode")
-		problems := h.generateFallbackSpellingProblems(criteria)
-		source = "fallback"
-		log.Printf("✅ Successfully generated %d fallback problems", len(problems))
-		return problems, nil
+	// Create each table if it doesn't exist
+	billingMode, err := dynamoBillingMode()
+	if err != nil {
+		return err
 	}
 
-	if err != nil {
-		log.Printf("❌ AI generation failed: %v", err)
-		problems := h.generateFallbackSpellingProblems(criteria)
-		source = "fallback"
+	for _, table := range tables {
+		// Check if table exists
+		_, err := svc.DescribeTable(&dynamodb.DescribeTableInput{
+			TableName: aws.String(table.name),
+		})
+
+		if err != nil {
+			// Table doesn't exist, create it
+			log.Printf("Creating DynamoDB table: %s (%s)", table.name, billingMode)
+			applyBillingMode(table.schema, billingMode)
+			_, err = svc.CreateTable(table.schema)
+			if err != nil {
+				return fmt.Errorf("failed to create table %s: %v", table.name, err)
+			}
+
+			// Wait for table to be active
+			log.Printf("Waiting for table %s to be active...", table.name)
+			err = svc.WaitUntilTableExists(&dynamodb.DescribeTableInput{
+				TableName: aws.String(table.name),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to wait for table %s: %v", table.name, err)
+			}
 
-		if saveErr := h.saveToCache(problems, criteria, source); saveErr != nil {
-			log.Printf("⚠️  Failed to save fallback to cache: %v", saveErr)
+		} else {
+			log.Printf("DynamoDB table %s already exists", table.name)
 		}
 
-		log.Printf("✅ Successfully generated %d fallback problems", len(problems))
-		return problems, nil
-	}
-
-	problems, err := h.parseSpellingResponse(response, criteria)
-	if err != nil {
-		log.Printf("⚠️  Failed to parse AI response: %v", err)
-		problems = h.generateFallbackSpellingProblems(criteria)
-		source = "fallback"
-	} else {
-		if saveErr := h.saveToCache(problems, criteria, source); saveErr != nil {
-			log.Printf("⚠️  Failed to save to cache: %v", saveErr)
+		// Also covers tables created before they had a TTL attribute
+		if table.ttl != "" {
+			ensureTimeToLive(svc, table.name, table.ttl)
 		}
 	}
 
-	log.Printf("✅ Successfully generated %d problems", len(problems))
-	return problems, nil
+	return nil
 }
 
-func (h *PuzzleHub) buildSpellingPrompt(criteria GenerationCriteria) string {
-	theme := criteria.Theme
-	if theme == "" {
-		theme = "general"
+func NewPuzzleHub(provider string) (*PuzzleHub, error) {
+	sess, err := newAWSSession()
+	if err != nil {
+		return nil, err
 	}
 
-	phonetics := ""
-	if criteria.IncludePhonetics {
-		phonetics = "Include phonetic pronunciation for each word."
+	// Initialize DynamoDB (creates all tables including feedback table)
+	dynamoDB, err := initializeDynamoDB(sess)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize DynamoDB: %v", err)
 	}
 
-	hints := ""
-	if criteria.IncludeHints {
-		hints = "Include helpful spelling hints for each word."
+	store, err := newStorage(dynamoDB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %v", err)
 	}
 
-	return fmt.Sprintf(`Generate %d spelling bee problems for %s children with %s difficulty level.
-
-Theme: %s
-%s
-%s
-
-IMPORTANT: All words must be at least 6 characters long, regardless of difficulty level.
-
-For each word, provide:
-1. The word to spell (minimum 6 characters)
-2. A clear, age-appropriate definition
-3. A sentence using the word
-4. Helpful hints for spelling
-5. Phonetic pronunciation (if requested)
-
-Format the output as a JSON array where each problem has:
-- word: the spelling word (minimum 6 characters)
-- definition: clear definition
-- sentence: example sentence
-- hints: array of spelling hints
-- phonetic: phonetic pronunciation (if requested)
-- difficulty: the difficulty level
-- age_group: target age group
-
-Make sure the words are appropriate for %s and %s level, and ALL words must be at least 6 characters long.`,
-		criteria.WordCount, criteria.AgeGroup, criteria.DifficultyLevel, theme, phonetics, hints, criteria.AgeGroup, criteria.DifficultyLevel)
-}
+	cache, err := newCache()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cache: %v", err)
+	}
+	store = newCachedStorage(store, cache)
 
-func (h *PuzzleHub) generateWithOpenAI(prompt string) (string, error) {
-	resp, err := h.OpenAIClient.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT4,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-			Temperature: 0.7,
+	hub := &PuzzleHub{
+		Provider: provider,
+		HTTPClient: &http.Client{
+			Timeout: 60 * time.Second, // Increased timeout for writing analysis
+		},
+		WebhookClient: newWebhookClient(),
+		YohakuGenerator: &YohakuGenerator{
+			rand: rand.New(rand.NewSource(time.Now().UnixNano())),
 		},
-	)
+		DynamoDB:              dynamoDB,
+		Store:                 store,
+		Cache:                 cache,
+		Analytics:             NewAnalyticsService(dynamoDB, store),
+		AIUsage:               NewAIUsageTracker(dynamoDB),
+		AILatency:             NewAILatencyWatchdog(),
+		Prompts:               NewPromptStore(dynamoDB),
+		Moderation:            NewAIModerator(dynamoDB),
+		Errors:                NewErrorReporter(dynamoDB),
+		Jobs:                  NewJobRunner(store),
+		Realtime:              NewRealtimeHub(cache),
+		APIRateLimit:          NewRateLimiter("api", 120, 60),
+		AIRateLimit:           NewRateLimiter("ai", 10, 5),
+		AIQuotas:              loadAIQuotas(),
+		S3:                    s3.New(sess),
+		ArchiveBucket:         os.Getenv("ARCHIVE_S3_BUCKET"),
+		AttachmentBucket:      os.Getenv("FEEDBACK_S3_BUCKET"),
+		AnalyticsExportBucket: os.Getenv("ANALYTICS_EXPORT_S3_BUCKET"),
+		AccountExportBucket:   os.Getenv("ACCOUNT_EXPORT_S3_BUCKET"),
+		Polly:                 polly.New(sess),
+		SpellingBeeVoice:      spellingBeeVoice(),
+		RecordingBucket:       os.Getenv("SPELLING_BEE_S3_BUCKET"),
+		SES:                   ses.New(sess),
+		DigestFromEmail:       os.Getenv("FEEDBACK_DIGEST_FROM"),
+		NotificationFromEmail: os.Getenv("NOTIFICATION_EMAIL_FROM"),
+		ParentReportFromEmail: os.Getenv("PARENT_REPORT_FROM"),
+		InboundEmailDomain:    strings.ToLower(os.Getenv("INBOUND_EMAIL_DOMAIN")),
+		InboundEmailSecret:    os.Getenv("INBOUND_EMAIL_SECRET"),
+	}
+
+	if err := hub.configureAIProviders(provider); err != nil {
+		return nil, err
+	}
 
+	// Initialize authentication
+	authConfig, err := initializeAuth()
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to initialize auth: %v", err)
 	}
+	hub.AuthConfig = authConfig
 
-	return resp.Choices[0].Message.Content, nil
+	return hub, nil
 }
 
-func (h *PuzzleHub) generateWithPerplexity(prompt string) (string, error) {
-	request := PerplexityRequest{
-		Model: "sonar",
-		Messages: []Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
+// Spelling Bee Methods
+// GenerateSpellingProblems returns the problems and their source, ai or
+// fallback
+func (h *PuzzleHub) GenerateSpellingProblems(ctx context.Context, criteria GenerationCriteria, userID string) ([]SpellingProblem, string, error) {
+	log.Printf("🎯 Generating %d spelling problems for age %s, difficulty %s, theme %s",
+		criteria.WordCount, criteria.AgeGroup, criteria.DifficultyLevel, criteria.Theme)
+
+	// Try the cached pool of problems for these criteria first
+	choice := h.Prompts.choose("spelling", userID)
+	cacheParams := choice.cacheParams(spellingCacheParams(criteria))
+	var pool []SpellingProblem
+	if !criteria.ForceRefresh && h.loadAICache(ctx, "spelling", cacheParams, &pool) && len(pool) >= criteria.WordCount {
+		log.Printf("✅ Using %d cached problems", criteria.WordCount)
+		return pool[:criteria.WordCount], sourceAI, nil
 	}
 
-	jsonData, err := json.Marshal(request)
+	log.Printf("🤖 Using %s API", h.providerFor("spelling"))
+	generated, err := h.generateSpellingBatch(ctx, criteria, userID, choice)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
+		log.Printf("❌ AI generation failed: %v", err)
+		problems := h.generateFallbackSpellingProblems(criteria)
+		log.Printf("✅ Successfully generated %d fallback problems", len(problems))
+		return problems, sourceFallback, nil
 	}
 
-	req, err := http.NewRequest("POST", "https://api.perplexity.ai/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
+	var problems []SpellingProblem
+	for _, problem := range generated {
+		if len(problem.Word) >= 6 {
+			problems = append(problems, problem)
+		}
 	}
+	h.storeAICache(ctx, "spelling", cacheParams, mergeSpellingPool(pool, problems))
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+h.PerplexityKey)
+	log.Printf("✅ Successfully generated %d problems", len(problems))
+	return problems, sourceAI, nil
+}
 
-	resp, err := h.HTTPClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to make API call: %v", err)
-	}
-	defer resp.Body.Close()
+func (h *PuzzleHub) buildSpellingPrompt(criteria GenerationCriteria, choice promptChoice) string {
+	return h.Prompts.renderChoice(choice, criteria)
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (h *PuzzleHub) generateWithOpenAI(ctx context.Context, prompt string, call aiCall) (string, error) {
+	model, err := h.modelFor(ctx, call.Feature, "openai")
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return "", err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API call failed with status %d: %s", resp.StatusCode, string(body))
-	}
+	request := openAIChatRequest(model, prompt, call)
 
-	var perplexityResp PerplexityResponse
-	if err := json.Unmarshal(body, &perplexityResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
+	start := time.Now()
+	resp, err := h.OpenAIClient.CreateChatCompletion(ctx, request)
+	h.recordAICall(call, "openai", model, start, err)
+
+	if err != nil {
+		return "", err
 	}
+	h.AIUsage.record("openai", model, call, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
 
-	if len(perplexityResp.Choices) == 0 {
+	if len(resp.Choices) == 0 {
 		return "", fmt.Errorf("no choices in response")
 	}
 
-	return perplexityResp.Choices[0].Message.Content, nil
+	return resp.Choices[0].Message.Content, nil
 }
 
-func (h *PuzzleHub) parseSpellingResponse(response string, criteria GenerationCriteria) ([]SpellingProblem, error) {
-	var jsonStr string
-
-	if strings.Contains(response, "```json") {
-		start := strings.Index(response, "```json")
-		if start != -1 {
-			start += 7
-			end := strings.Index(response[start:], "```")
-			if end != -1 {
-				jsonStr = strings.TrimSpace(response[start : start+end])
-			}
-		}
-	} else if strings.Contains(response, "```") {
-		start := strings.Index(response, "```")
-		if start != -1 {
-			start += 3
-			end := strings.Index(response[start:], "```")
-			if end != -1 {
-				jsonStr = strings.TrimSpace(response[start : start+end])
-			}
-		}
-	} else {
-		start := strings.Index(response, "[")
-		end := strings.LastIndex(response, "]")
-		if start != -1 && end != -1 {
-			jsonStr = response[start : end+1]
-		}
+// openAIChatRequest builds a chat completion request for a call
+func openAIChatRequest(model, prompt string, call aiCall) openai.ChatCompletionRequest {
+	var messages []openai.ChatCompletionMessage
+	if call.System != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: call.System,
+		})
 	}
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: prompt,
+	})
 
-	if jsonStr == "" {
-		return nil, fmt.Errorf("no JSON array found in response")
+	request := openai.ChatCompletionRequest{
+		Model:       model,
+		Messages:    messages,
+		Temperature: openAITemperature(call),
+	}
+	if call.Schema != nil {
+		request.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   call.Schema.Name,
+				Schema: call.Schema.Schema,
+				Strict: true,
+			},
+		}
 	}
+	return request
+}
 
-	var problems []SpellingProblem
-	err := json.Unmarshal([]byte(jsonStr), &problems)
+func (h *PuzzleHub) generateWithPerplexity(ctx context.Context, prompt string, call aiCall) (content string, err error) {
+	model, err := h.modelFor(ctx, call.Feature, "perplexity")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %v", err)
-	}
-
-	var filteredProblems []SpellingProblem
-	for _, problem := range problems {
-		if len(problem.Word) >= 6 {
-			filteredProblems = append(filteredProblems, problem)
-		}
+		return "", err
 	}
 
-	return filteredProblems, nil
-}
+	start := time.Now()
+	defer func() { h.recordAICall(call, "perplexity", model, start, err) }()
 
-func (h *PuzzleHub) generateFallbackSpellingProblems(criteria GenerationCriteria) []SpellingProblem {
-	sampleWords := map[string][]string{
-		"elementary":   {"rabbit", "turtle", "butter", "castle", "garden", "pencil", "school", "friend", "family", "mother"},
-		"middle":       {"elephant", "bicycle", "mountain", "computer", "adventure", "beautiful", "mystery", "journey", "crystal", "thunder"},
-		"intermediate": {"magnificent", "extraordinary", "responsibility", "entrepreneur", "sophisticated", "unprecedented", "revolutionary", "incomprehensible", "pharmaceutical", "psychology"},
-		"advanced":     {"pneumonia", "entrepreneur", "conscientious", "acquiesce", "perspicacious", "supercalifragilisticexpialidocious", "pneumonoultramicroscopicsilicovolcanoconiosis", "floccinaucinihilipilification", "antidisestablishmentarianism", "hippopotomonstrosesquippedaliophobia"},
+	request := PerplexityRequest{Model: model, Temperature: providerTemperature("perplexity", call.Temperature)}
+	if call.System != "" {
+		request.Messages = append(request.Messages, Message{Role: "system", Content: call.System})
+	}
+	request.Messages = append(request.Messages, Message{Role: "user", Content: prompt})
+	headers := map[string]string{
+		"Authorization": "Bearer " + h.PerplexityKey,
 	}
 
-	words := sampleWords[criteria.DifficultyLevel]
-	if len(words) == 0 {
-		words = sampleWords["middle"]
+	var perplexityResp PerplexityResponse
+	if err := h.postAIRequest(ctx, "https://api.perplexity.ai/chat/completions", headers, request, &perplexityResp); err != nil {
+		return "", err
 	}
+	h.AIUsage.record("perplexity", model, call, perplexityResp.Usage.PromptTokens, perplexityResp.Usage.CompletionTokens)
 
-	var problems []SpellingProblem
-	for i, word := range words {
-		if i >= criteria.WordCount {
-			break
-		}
+	if len(perplexityResp.Choices) == 0 {
+		return "", fmt.Errorf("no choices in response")
+	}
 
-		hints := []string{
-			fmt.Sprintf("Starts with %s", strings.ToUpper(string(word[0]))),
-			fmt.Sprintf("Has %d letters", len(word)),
-		}
+	return perplexityResp.Choices[0].Message.Content, nil
+}
 
-		problem := SpellingProblem{
-			Word:       word,
-			Definition: "A common word in the English language",
-			Sentence:   "This word is commonly used in everyday speech.",
-			Difficulty: criteria.DifficultyLevel,
-			AgeGroup:   criteria.AgeGroup,
-			Hints:      hints,
+// validateSpellingProblems rejects replies missing what the game shows
+func validateSpellingProblems(problems []SpellingProblem) error {
+	if len(problems) == 0 {
+		return fmt.Errorf("no problems in response")
+	}
+	for i, problem := range problems {
+		if strings.TrimSpace(problem.Word) == "" || problem.Definition == "" || problem.Sentence == "" {
+			return fmt.Errorf("problem %d is missing its word, definition or sentence", i)
 		}
-
-		if criteria.IncludePhonetics {
-			problem.PhoneticGuide = fmt.Sprintf("/%s/", word)
+		if strings.ContainsAny(problem.Word, " 0123456789") {
+			return fmt.Errorf("problem %d word %q must be a single word", i, problem.Word)
 		}
-
-		problems = append(problems, problem)
 	}
-
-	return problems
-}
-
-// Cache methods
-func (h *PuzzleHub) getCacheFileName(criteria GenerationCriteria) string {
-	return filepath.Join(h.CacheDir, fmt.Sprintf("problems_%s_%s_%s.json",
-		criteria.DifficultyLevel, criteria.AgeGroup, criteria.Theme))
+	return nil
 }
 
-func (h *PuzzleHub) loadFromCache(criteria GenerationCriteria) ([]SpellingProblem, error) {
-	cacheFile := h.getCacheFileName(criteria)
-
-	if _, err := os.Stat(cacheFile); os.IsNotExist(err) {
-		return nil, fmt.Errorf("cache file not found")
-	}
+// maxSpellingPool caps the cached problems per criteria, keeping the
+// cache item well under DynamoDB's item size limit
+const maxSpellingPool = 100
 
-	data, err := os.ReadFile(cacheFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read cache file: %v", err)
+func spellingCacheParams(criteria GenerationCriteria) map[string]interface{} {
+	params := map[string]interface{}{
+		"difficulty": normalizeCacheParam(criteria.DifficultyLevel),
+		"age_group":  normalizeCacheParam(criteria.AgeGroup),
+		"theme":      normalizeCacheParam(criteria.Theme),
+		"phonetics":  criteria.IncludePhonetics,
+		"hints":      criteria.IncludeHints,
 	}
-
-	var cache ProblemCache
-	if err := json.Unmarshal(data, &cache); err != nil {
-		return nil, fmt.Errorf("failed to parse cache file: %v", err)
+	// Only present when set, so existing pools keep their keys
+	if criteria.DyslexiaFriendly {
+		params["dyslexia_friendly"] = true
 	}
-
-	if time.Since(cache.Metadata.GeneratedAt) > 24*time.Hour {
-		return nil, fmt.Errorf("cache expired")
+	if criteria.SimplifiedLanguage {
+		params["simplified_language"] = true
 	}
-
-	return cache.Problems, nil
+	criteria.addCacheParam(params)
+	return params
 }
 
-func (h *PuzzleHub) saveToCache(problems []SpellingProblem, criteria GenerationCriteria, source string) error {
-	cacheFile := h.getCacheFileName(criteria)
-
-	var existingCache ProblemCache
-	if data, err := os.ReadFile(cacheFile); err == nil {
-		json.Unmarshal(data, &existingCache)
-	}
-
+// mergeSpellingPool adds newly generated problems to the cached pool,
+// skipping words it already has
+func mergeSpellingPool(pool, problems []SpellingProblem) []SpellingProblem {
 	existingWords := make(map[string]bool)
-	for _, problem := range existingCache.Problems {
+	for _, problem := range pool {
 		existingWords[strings.ToLower(problem.Word)] = true
 	}
-
-	var newProblems []SpellingProblem
 	for _, problem := range problems {
 		if !existingWords[strings.ToLower(problem.Word)] {
-			newProblems = append(newProblems, problem)
+			pool = append(pool, problem)
 			existingWords[strings.ToLower(problem.Word)] = true
 		}
 	}
-
-	existingCache.Problems = append(existingCache.Problems, newProblems...)
-	existingCache.Metadata.GeneratedAt = time.Now()
-	existingCache.Metadata.Criteria = criteria
-	existingCache.Metadata.Source = source
-
-	data, err := json.MarshalIndent(existingCache, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal cache data: %v", err)
+	if len(pool) > maxSpellingPool {
+		pool = pool[len(pool)-maxSpellingPool:]
 	}
-
-	return os.WriteFile(cacheFile, data, 0644)
+	return pool
 }
 
 // Yohaku Methods
@@ -1120,8 +3211,13 @@ func (h *PuzzleHub) GenerateYohakuPuzzle(settings GameSettings) YohakuPuzzle {
 	return h.YohakuGenerator.GeneratePuzzle(settings)
 }
 
+// GenerateYohakuGameSession builds a session from a fresh seed, so it can
+// be replayed as a challenge
 func (h *PuzzleHub) GenerateYohakuGameSession(settings GameSettings) YohakuGameSession {
-	return h.YohakuGenerator.GenerateGameSession(settings)
+	seed := newGameSeed()
+	session := seededGenerator(seed).GenerateGameSession(settings)
+	session.Seed = seed
+	return session
 }
 
 func (g *YohakuGenerator) GeneratePuzzle(settings GameSettings) YohakuPuzzle {
@@ -1208,7 +3304,9 @@ func (g *YohakuGenerator) getProgressiveSettings(base GameSettings, level int) G
 	}
 
 	// Reduce timer as difficulty increases
-	if level <= 3 {
+	if settings.Untimed {
+		settings.TimerDuration = 0
+	} else if level <= 3 {
 		settings.TimerDuration = 60 // 1 minute for easy
 	} else if level <= 6 {
 		settings.TimerDuration = 45 // 45 seconds for medium
@@ -1253,72 +3351,50 @@ func (g *YohakuGenerator) generateSolution(puzzle *YohakuPuzzle, settings GameSe
 		}
 	}
 
+	fillYohakuSums(puzzle.Solution, size, settings.Operation)
+}
+
+// fillYohakuSums works out the row and column results and the corner
+// total of a solution whose inner size x size cells are filled in
+func fillYohakuSums(solution [][]int, size int, operation string) {
 	for i := 0; i < size; i++ {
-		result := puzzle.Solution[i][0]
+		result := solution[i][0]
 		for j := 1; j < size; j++ {
-			switch settings.Operation {
-			case "addition":
-				result += puzzle.Solution[i][j]
-			case "subtraction":
-				result -= puzzle.Solution[i][j]
-			case "multiplication":
-				result *= puzzle.Solution[i][j]
-			}
+			result = applyYohakuOperation(operation, result, solution[i][j])
 		}
-		puzzle.Solution[i][size] = result
+		solution[i][size] = result
 	}
 
 	for j := 0; j < size; j++ {
-		result := puzzle.Solution[0][j]
+		result := solution[0][j]
 		for i := 1; i < size; i++ {
-			switch settings.Operation {
-			case "addition":
-				result += puzzle.Solution[i][j]
-			case "subtraction":
-				result -= puzzle.Solution[i][j]
-			case "multiplication":
-				result *= puzzle.Solution[i][j]
-			}
+			result = applyYohakuOperation(operation, result, solution[i][j])
 		}
-		puzzle.Solution[size][j] = result
+		solution[size][j] = result
 	}
 
-	cornerResult := puzzle.Solution[size][0]
+	cornerResult := solution[size][0]
 	for j := 1; j < size; j++ {
-		switch settings.Operation {
-		case "addition":
-			cornerResult += puzzle.Solution[size][j]
-		case "subtraction":
-			cornerResult -= puzzle.Solution[size][j]
-		case "multiplication":
-			cornerResult *= puzzle.Solution[size][j]
-		}
+		cornerResult = applyYohakuOperation(operation, cornerResult, solution[size][j])
+	}
+	solution[size][size] = cornerResult
+}
+
+func applyYohakuOperation(operation string, result, value int) int {
+	switch operation {
+	case "addition":
+		return result + value
+	case "subtraction":
+		return result - value
+	case "multiplication":
+		return result * value
 	}
-	puzzle.Solution[size][size] = cornerResult
+	return result
 }
 
 func (g *YohakuGenerator) createPuzzleFromSolution(puzzle *YohakuPuzzle, settings GameSettings) {
 	size := settings.Size
-
-	for i := 0; i <= size; i++ {
-		for j := 0; j <= size; j++ {
-			puzzle.Grid[i][j] = Cell{
-				Value:   puzzle.Solution[i][j],
-				IsGiven: true,
-				IsSum:   i == size || j == size,
-			}
-
-			if i == size && j == size {
-				puzzle.Grid[i][j].SumType = "total"
-			} else if i == size {
-				puzzle.Grid[i][j].SumType = "column"
-			} else if j == size {
-				puzzle.Grid[i][j].SumType = "row"
-			} else {
-				puzzle.Grid[i][j].SumType = "cell"
-			}
-		}
-	}
+	puzzle.Grid = yohakuGridFromSolution(puzzle.Solution, size)
 
 	cellsToHide := g.getCellsToHide(settings.Difficulty, size)
 	hiddenCount := 0
@@ -1335,6 +3411,32 @@ func (g *YohakuGenerator) createPuzzleFromSolution(puzzle *YohakuPuzzle, setting
 	}
 }
 
+// yohakuGridFromSolution returns the grid with every cell given
+func yohakuGridFromSolution(solution [][]int, size int) [][]Cell {
+	grid := make([][]Cell, size+1)
+	for i := 0; i <= size; i++ {
+		grid[i] = make([]Cell, size+1)
+		for j := 0; j <= size; j++ {
+			grid[i][j] = Cell{
+				Value:   solution[i][j],
+				IsGiven: true,
+				IsSum:   i == size || j == size,
+			}
+
+			if i == size && j == size {
+				grid[i][j].SumType = "total"
+			} else if i == size {
+				grid[i][j].SumType = "column"
+			} else if j == size {
+				grid[i][j].SumType = "row"
+			} else {
+				grid[i][j].SumType = "cell"
+			}
+		}
+	}
+	return grid
+}
+
 func (g *YohakuGenerator) getCellsToHide(difficulty string, size int) int {
 	totalCells := size * size
 
@@ -1351,356 +3453,165 @@ func (g *YohakuGenerator) getCellsToHide(difficulty string, size int) int {
 }
 
 // Writing Analysis Methods
-func (h *PuzzleHub) AnalyzeWriting(request WritingAnalysisRequest) (*WritingAnalysisResponse, error) {
+// AnalyzeWriting falls back to local readability metrics when AI is
+// unavailable
+func (h *PuzzleHub) AnalyzeWriting(ctx context.Context, request WritingAnalysisRequest, userID string) *WritingAnalysisResponse {
 	log.Printf("🖊️ Analyzing writing for grade level %d", request.GradeLevel)
-
-	prompt := h.buildWritingAnalysisPrompt(request)
-
-	var response string
-	var err error
-	maxRetries := 2
-
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		if attempt > 1 {
-			log.Printf("🔄 Retry attempt %d/%d", attempt, maxRetries)
-			time.Sleep(2 * time.Second) // Brief delay before retry
-		}
-
-		if h.Provider == "openai" {
-			log.Printf("🔵 Using OpenAI for writing analysis")
-			response, err = h.generateWithOpenAI(prompt)
-		} else if h.Provider == "perplexity" {
-			log.Printf("🟣 Using Perplexity for writing analysis")
-			response, err = h.generateWithPerplexity(prompt)
-		} else {
-			return nil, fmt.Errorf("invalid AI provider: %s. Must be 'openai' or 'perplexity'", h.Provider)
-		}
-
-		// If successful, break out of retry loop
-		if err == nil {
-			break
-		}
-
-		// If it's the last attempt or not a timeout error, don't retry
-		isTimeout := strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "deadline exceeded")
-		if attempt == maxRetries || !isTimeout {
-			break
-		}
-
-		log.Printf("⚠️ Attempt %d failed with timeout, retrying...", attempt)
+	ctx, span := tracer.Start(ctx, "writing.analyze", trace.WithAttributes(
+		attribute.Int("writing.grade_level", request.GradeLevel),
+		attribute.Int("writing.length", len(request.Text)),
+	))
+	defer span.End()
+
+	choice := h.Prompts.choose("writing_analysis", userID)
+	cacheParams := choice.cacheParams(writingCacheParams(request))
+	var analysis WritingAnalysisResponse
+	if h.loadAICache(ctx, "writing", cacheParams, &analysis) {
+		log.Printf("✅ Using cached writing analysis")
+		analysis.anchor(request.Text)
+		analysis.Source = sourceAI
+		h.pushFlashcards(userID, "writing", flashcardsForVocabulary(&analysis))
+		return &analysis
 	}
 
-	if err != nil {
-		log.Printf("❌ AI analysis failed after %d attempts: %v", maxRetries, err)
-
-		// Check if it's a timeout error
-		if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "deadline exceeded") {
-			return nil, fmt.Errorf("writing analysis timed out after %d attempts - %s is experiencing delays. Please try again with shorter text or wait a few minutes", maxRetries, h.Provider)
-		}
-
-		return nil, fmt.Errorf("writing analysis is not available right now due to API issues with %s. Please try again later", h.Provider)
-	}
+	_, buildSpan := tracer.Start(ctx, "prompt.build")
+	prompt := h.buildWritingAnalysisPrompt(request, choice)
+	buildSpan.End()
 
-	analysis, err := h.parseWritingAnalysisResponse(response, request)
+	log.Printf("🤖 Using %s for writing analysis", h.providerFor("writing"))
+	call := aiCall{Feature: "writing", UserID: userID, Prompt: choice.PromptTag, Generation: newAIGeneration()}
+	err := h.generateJSON(ctx, prompt, call, writingAnalysisSchema, &analysis, analysis.validate)
 	if err != nil {
-		log.Printf("⚠️ Failed to parse AI response: %v", err)
-		return nil, fmt.Errorf("writing analysis is not available right now due to API response parsing issues. Please try again later")
+		log.Printf("❌ AI analysis failed, reporting readability only: %v", err)
+		span.SetAttributes(attribute.Bool("writing.fallback", true))
+		return localWritingAnalysis(request)
 	}
 
-	log.Printf("✅ Successfully analyzed writing")
-	return analysis, nil
-}
-
-func (h *PuzzleHub) buildWritingAnalysisPrompt(request WritingAnalysisRequest) string {
-	return fmt.Sprintf(`Analyze the following piece of writing for a grade %d student. Provide comprehensive feedback including grammar errors, vocabulary improvements, context suggestions, and narrative analysis.
-
-Title: %s
-Grade Level: %d
-Text: %s
-
-Please provide a detailed analysis in the following JSON format:
-{
-  "overallRating": 1-5,
-  "grammarErrors": [
-    {
-      "startIndex": 0,
-      "endIndex": 10,
-      "errorType": "subject-verb agreement",
-      "original": "text with error",
-      "suggestion": "corrected text",
-      "explanation": "why this is wrong and how to fix it"
-    }
-  ],
-  "vocabularyTips": [
-    {
-      "startIndex": 15,
-      "endIndex": 20,
-      "original": "simple word",
-      "suggestions": ["better word 1", "better word 2"],
-      "explanation": "why these alternatives are better"
-    }
-  ],
-  "contextSuggestions": [
-    {
-      "paragraphIndex": 0,
-      "suggestion": "Add more descriptive details about...",
-      "reason": "This would help readers visualize the scene better"
-    }
-  ],
-  "narrativeAnalysis": {
-    "structure": {
-      "hasIntroduction": true,
-      "hasRisingAction": false,
-      "hasClimax": true,
-      "hasResolution": false,
-      "feedback": "Your story has a good beginning and exciting moment, but needs more build-up and a proper ending."
-    },
-    "strengths": ["Good dialogue", "Creative characters"],
-    "improvements": ["Add more descriptive language", "Develop the ending"],
-    "rating": 3
-  },
-  "summary": "Overall feedback summary for the student"
-}
-
-Focus on:
-1. Grammar and spelling errors with clear explanations
-2. Vocabulary enhancement suggestions appropriate for grade %d
-3. Ways to add more context and detail to each paragraph
-4. Narrative structure analysis (introduction, rising action, climax, resolution)
-5. Age-appropriate feedback that encourages improvement
-6. Rate the writing from 1-5 (1=needs much work, 5=excellent)
-
-Make sure all feedback is constructive, encouraging, and appropriate for a grade %d student.`,
-		request.GradeLevel, request.Title, request.GradeLevel, request.Text, request.GradeLevel, request.GradeLevel)
-}
-
-func (h *PuzzleHub) parseWritingAnalysisResponse(response string, request WritingAnalysisRequest) (*WritingAnalysisResponse, error) {
-	var jsonStr string
-
-	// Extract JSON from response
-	if strings.Contains(response, "```json") {
-		start := strings.Index(response, "```json")
-		if start != -1 {
-			start += 7
-			end := strings.Index(response[start:], "```")
-			if end != -1 {
-				jsonStr = strings.TrimSpace(response[start : start+end])
-			}
-		}
-	} else if strings.Contains(response, "```") {
-		start := strings.Index(response, "```")
-		if start != -1 {
-			start += 3
-			end := strings.Index(response[start:], "```")
-			if end != -1 {
-				jsonStr = strings.TrimSpace(response[start : start+end])
-			}
-		}
-	} else {
-		start := strings.Index(response, "{")
-		end := strings.LastIndex(response, "}")
-		if start != -1 && end != -1 {
-			jsonStr = response[start : end+1]
-		}
-	}
+	analysis.dropOutOfRange(len(request.Text))
+	analysis.anchor(request.Text)
+	analysis.Source = sourceAI
+	analysis.PromptTag = choice.PromptTag
+	analysis.GenerationID = call.Generation.ID
+	h.saveGeneration(call.Generation, call, choice)
+	h.storeAICache(ctx, "writing", cacheParams, analysis)
+	h.pushFlashcards(userID, "writing", flashcardsForVocabulary(&analysis))
 
-	if jsonStr == "" {
-		return nil, fmt.Errorf("no JSON found in response")
-	}
+	log.Printf("✅ Successfully analyzed writing")
+	return &analysis
+}
 
-	var analysis WritingAnalysisResponse
-	err := json.Unmarshal([]byte(jsonStr), &analysis)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %v", err)
+// writingCacheParams keys cached analyses; highlights are character
+// offsets, so the text is cached verbatim
+func writingCacheParams(request WritingAnalysisRequest) map[string]interface{} {
+	return map[string]interface{}{
+		"grade": request.GradeLevel,
+		"title": request.Title,
+		"text":  request.Text,
 	}
-
-	return &analysis, nil
 }
 
-// Fallback method removed - Writing analysis now requires AI API keys
-
-// Story Starter Generator
-func (h *PuzzleHub) GenerateStory(req StoryRequest) (*StoryResponse, error) {
-	prompt := h.buildStoryPrompt(req)
-
-	var content string
-
-	if h.Provider == "openai" && h.OpenAIClient != nil {
-		resp, err := h.OpenAIClient.CreateChatCompletion(
-			context.Background(),
-			openai.ChatCompletionRequest{
-				Model: openai.GPT4,
-				Messages: []openai.ChatCompletionMessage{
-					{
-						Role:    openai.ChatMessageRoleSystem,
-						Content: "You are a creative writing assistant for 4th grade students. Your job is to inspire young writers with fun, age-appropriate story ideas. Be enthusiastic, encouraging, and creative. Keep language simple but engaging.",
-					},
-					{
-						Role:    openai.ChatMessageRoleUser,
-						Content: prompt,
-					},
-				},
-			},
-		)
-
-		if err != nil {
-			return nil, fmt.Errorf("OpenAI API error: %w", err)
-		}
-
-		if len(resp.Choices) > 0 {
-			content = resp.Choices[0].Message.Content
-		}
-	} else if h.Provider == "perplexity" && h.PerplexityKey != "" {
-		// Use Perplexity API
-		perplexityReq := map[string]interface{}{
-			"model": "sonar",
-			"messages": []map[string]string{
-				{
-					"role":    "system",
-					"content": "You are a creative writing assistant for 4th grade students. Your job is to inspire young writers with fun, age-appropriate story ideas. Be enthusiastic, encouraging, and creative. Keep language simple but engaging.",
-				},
-				{
-					"role":    "user",
-					"content": prompt,
-				},
-			},
-		}
-
-		jsonData, err := json.Marshal(perplexityReq)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request: %w", err)
-		}
-
-		httpReq, err := http.NewRequest("POST", "https://api.perplexity.ai/chat/completions", bytes.NewBuffer(jsonData))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		httpReq.Header.Set("Authorization", "Bearer "+h.PerplexityKey)
-		httpReq.Header.Set("Content-Type", "application/json")
-
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err := client.Do(httpReq)
-		if err != nil {
-			return nil, fmt.Errorf("failed to call API: %w", err)
-		}
-		defer resp.Body.Close()
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read response: %w", err)
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
-		}
-
-		var perplexityResp struct {
-			Choices []struct {
-				Message struct {
-					Content string `json:"content"`
-				} `json:"message"`
-			} `json:"choices"`
-		}
-
-		if err := json.Unmarshal(body, &perplexityResp); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-		}
-
-		if len(perplexityResp.Choices) == 0 {
-			return nil, fmt.Errorf("no response from API")
-		}
+func (h *PuzzleHub) buildWritingAnalysisPrompt(request WritingAnalysisRequest, choice promptChoice) string {
+	return h.Prompts.renderChoice(choice, request)
+}
 
-		content = perplexityResp.Choices[0].Message.Content
-	} else {
-		return nil, fmt.Errorf("no AI provider configured")
+// validate checks the ratings and summary the writing app relies on
+func (a *WritingAnalysisResponse) validate() error {
+	if a.OverallRating < 1 || a.OverallRating > 5 {
+		return fmt.Errorf("overallRating must be 1-5, got %d", a.OverallRating)
 	}
-
-	storyResp := &StoryResponse{
-		Content:     content,
-		GeneratedAt: time.Now(),
+	if a.NarrativeAnalysis.Rating < 1 || a.NarrativeAnalysis.Rating > 5 {
+		return fmt.Errorf("narrativeAnalysis.rating must be 1-5, got %d", a.NarrativeAnalysis.Rating)
 	}
-
-	return storyResp, nil
+	if strings.TrimSpace(a.Summary) == "" {
+		return fmt.Errorf("summary is empty")
+	}
+	return nil
 }
 
-func (h *PuzzleHub) buildStoryPrompt(req StoryRequest) string {
-	elementsStr := ""
-	if len(req.Elements) > 0 {
-		elementsStr = fmt.Sprintf("Include these elements: %v. ", req.Elements)
+// dropOutOfRange removes highlights whose indexes don't fit the text, which
+// the editor can't place
+func (a *WritingAnalysisResponse) dropOutOfRange(textLength int) {
+	inRange := func(start, end int) bool {
+		return start >= 0 && start <= end && end <= textLength
 	}
 
-	genreStr := ""
-	if req.Genre != "" {
-		genreStr = fmt.Sprintf("Genre: %s. ", req.Genre)
+	grammarErrors := a.GrammarErrors[:0]
+	for _, grammarError := range a.GrammarErrors {
+		if inRange(grammarError.StartIndex, grammarError.EndIndex) {
+			grammarErrors = append(grammarErrors, grammarError)
+		}
 	}
+	a.GrammarErrors = grammarErrors
 
-	toneStr := ""
-	if req.Tone != "" {
-		toneStr = fmt.Sprintf("Tone: %s. ", req.Tone)
+	vocabularyTips := a.VocabularyTips[:0]
+	for _, tip := range a.VocabularyTips {
+		if inRange(tip.StartIndex, tip.EndIndex) {
+			vocabularyTips = append(vocabularyTips, tip)
+		}
 	}
+	a.VocabularyTips = vocabularyTips
+}
 
-	switch req.RequestType {
-	case "prompt":
-		return fmt.Sprintf(`Generate a creative and exciting story starter for a 4th grader. %s%s%s
-
-Format your response as:
-TITLE: [Catchy story title]
-OPENING: [2-3 sentence story beginning that hooks the reader]
-IDEAS: [3 bullet points with "what happens next" ideas]
-TIPS: [2 writing tips specific to this story]
-
-Make it fun, imaginative, and age-appropriate!`, genreStr, toneStr, elementsStr)
-
-	case "character":
-		return fmt.Sprintf(`Create an interesting character for a 4th grader's story. %s%s%s
-
-Format your response as:
-NAME: [Character name]
-DESCRIPTION: [Physical description and personality - 2-3 sentences]
-BACKGROUND: [Brief backstory - 2 sentences]
-SPECIAL TRAIT: [Something unique or interesting about them]
-QUESTIONS: [3 questions to help develop the character further]
-
-Make the character relatable and fun for a 10-year-old!`, genreStr, toneStr, elementsStr)
-
-	case "plot":
-		return fmt.Sprintf(`Create an exciting plot outline for a short story. %s%s%s
-
-Format your response as:
-BEGINNING: [How the story starts]
-PROBLEM: [The main challenge or conflict]
-MIDDLE: [3 key events that happen]
-CLIMAX: [The most exciting part]
-ENDING IDEAS: [2 different ways the story could end]
-
-Make it engaging and appropriate for 4th grade reading level!`, genreStr, toneStr, elementsStr)
-
-	case "twist":
-		return fmt.Sprintf(`Generate a surprising plot twist for a story. %s%s%s
-
-Format your response as:
-TWIST: [The surprising turn of events - 2-3 sentences]
-WHY IT WORKS: [Why this twist is interesting]
-HOW TO BUILD UP: [2-3 tips for setting up this twist earlier in the story]
-ALTERNATIVE TWISTS: [2 other possible twists]
-
-Make it creative and fun, but not too scary for a 4th grader!`, genreStr, toneStr, elementsStr)
+// Story Starter Generator, falling back to the canned library when AI is
+// unavailable
+func (h *PuzzleHub) GenerateStory(ctx context.Context, req StoryRequest, userID string) *StoryResponse {
+	choice := h.Prompts.choose(storyPromptName(req), userID)
+	cacheParams := choice.cacheParams(storyCacheParams(req))
+	var cached StoryResponse
+	if h.loadAICache(ctx, "story", cacheParams, &cached) {
+		cached.Source = sourceAI
+		return &cached
+	}
+
+	prompt := h.Prompts.renderChoice(choice, req)
+	call := aiCall{
+		Feature:     "story",
+		UserID:      userID,
+		System:      h.Prompts.render("story_system", req),
+		Prompt:      choice.PromptTag,
+		Temperature: req.Temperature,
+		Generation:  newAIGeneration(),
+	}
+	h.Prompts.recordExperiment(choice.PromptTag, "generations")
+
+	content, err := h.generateAI(ctx, prompt, call)
+	if err != nil {
+		log.Printf("❌ %s story generation failed, using a canned starter: %v", h.providerFor("story"), err)
+		return fallbackStory(req)
+	}
 
-	case "setting":
-		return fmt.Sprintf(`Create a vivid and interesting setting for a story. %s%s%s
+	storyResp := &StoryResponse{
+		Content:      content,
+		GeneratedAt:  time.Now(),
+		Source:       sourceAI,
+		GenerationID: call.Generation.ID,
+		PromptTag:    choice.PromptTag,
+	}
+	h.saveGeneration(call.Generation, call, choice)
+	h.storeAICache(ctx, "story", cacheParams, storyResp)
 
-Format your response as:
-LOCATION: [Where the story takes place]
-TIME: [When it takes place]
-DESCRIPTION: [Vivid description using the 5 senses - 3-4 sentences]
-MOOD: [The feeling this setting creates]
-STORY POSSIBILITIES: [3 things that could happen in this setting]
+	return storyResp
+}
 
-Make it descriptive and imaginative for a 4th grader!`, genreStr, toneStr, elementsStr)
+func storyCacheParams(req StoryRequest) map[string]interface{} {
+	params := map[string]interface{}{
+		"type":     normalizeCacheParam(req.RequestType),
+		"genre":    normalizeCacheParam(req.Genre),
+		"tone":     normalizeCacheParam(req.Tone),
+		"length":   normalizeCacheParam(req.Length),
+		"elements": normalizeCacheParams(req.Elements),
+	}
+	req.addCacheParam(params)
+	return params
+}
 
+// storyPromptName returns the template for a story request type
+func storyPromptName(req StoryRequest) string {
+	switch req.RequestType {
+	case "prompt", "character", "plot", "twist", "setting":
+		return "story_" + req.RequestType
 	default:
-		return fmt.Sprintf(`Generate a creative story idea for a 4th grader. %s%s%s Make it exciting and fun!`, genreStr, toneStr, elementsStr)
+		return "story_idea"
 	}
 }
 
@@ -1708,20 +3619,30 @@ Make it descriptive and imaginative for a 4th grader!`, genreStr, toneStr, eleme
 func (h *PuzzleHub) submitFeedback(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 	userObj := user.(*User)
+	if userObj.IsGuest {
+		respondError(c, http.StatusForbidden, "Sign in to send feedback")
+		return
+	}
 
 	var submission FeedbackSubmission
 	if err := c.ShouldBindJSON(&submission); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindError(c, err)
 		return
 	}
 
 	// Validate rating if provided
 	if submission.Rating != 0 && (submission.Rating < 1 || submission.Rating > 5) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Rating must be between 1 and 5"})
+		respondError(c, http.StatusBadRequest, "Rating must be between 1 and 5")
+		return
+	}
+
+	attachments, err := h.resolveAttachments(userObj.ID, submission.Attachments)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -1741,36 +3662,24 @@ func (h *PuzzleHub) submitFeedback(c *gin.Context) {
 		Description: submission.Description,
 		AIAppIdea:   submission.AIAppIdea,
 		UseCase:     submission.UseCase,
+		Attachments: attachments,
 		CreatedAt:   time.Now(),
 		Status:      "new",
 	}
 
-	// Marshal feedback to DynamoDB format
-	feedbackItem, err := dynamodbattribute.MarshalMap(feedback)
-	if err != nil {
-		log.Printf("Error marshaling feedback: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit feedback"})
-		return
-	}
-
-	// Put feedback in DynamoDB
-	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String("puzzle-hub-feedback"),
-		Item:      feedbackItem,
-	})
-	if err != nil {
-		log.Printf("⚠️  Error putting feedback to DynamoDB: %v", err)
-		log.Printf("💡 Note: The table 'puzzle-hub-feedback' may not exist. Feedback recorded in logs but not persisted.")
-		// Don't fail the request - still acknowledge the feedback
-		log.Printf("📝 FEEDBACK SUBMITTED (not persisted): Type=%s, UserID=%s, Email=%s, Title=%s, Description=%s",
-			feedback.Type, feedback.UserID, feedback.UserEmail, feedback.Title, feedback.Description)
+	logger := requestLogger(c).With("feedback_id", feedbackID, "type", feedback.Type)
+	if err := h.Store.PutFeedback(&feedback); err != nil {
+		// Don't fail the request - still acknowledge the feedback, and keep
+		// its content in the log so it can be recovered
+		logger.Error("feedback not persisted, the feedback table may not exist", "error", err,
+			"title", feedback.Title, "description", feedback.Description)
 	} else {
-		log.Printf("✅ Feedback submitted to DynamoDB: Type=%s, UserID=%s, Title=%s", feedback.Type, feedback.UserID, feedback.Title)
+		logger.Info("feedback stored")
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "Thank you for your feedback!",
+		"message": tr(c, "Thank you for your feedback!"),
 		"id":      feedbackID,
 	})
 }
@@ -1778,58 +3687,29 @@ func (h *PuzzleHub) submitFeedback(c *gin.Context) {
 func (h *PuzzleHub) getAllFeedback(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 	userObj := user.(*User)
 
-	// Try to query user's feedback with index first
-	queryResult, err := h.DynamoDB.Query(&dynamodb.QueryInput{
-		TableName:              aws.String("puzzle-hub-feedback"),
-		IndexName:              aws.String("user_id-created_at-index"),
-		KeyConditionExpression: aws.String("user_id = :user_id"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":user_id": {S: aws.String(userObj.ID)},
-		},
-		ScanIndexForward: aws.Bool(false), // Most recent first
-	})
-
-	var items []map[string]*dynamodb.AttributeValue
-
+	feedbackList, err := h.Store.ListFeedback(FeedbackFilter{UserID: userObj.ID})
 	if err != nil {
-		// If index doesn't exist or table doesn't exist, try scan as fallback
-		log.Printf("⚠️  Query with index failed: %v. Trying scan...", err)
-
-		scanResult, scanErr := h.DynamoDB.Scan(&dynamodb.ScanInput{
-			TableName:        aws.String("puzzle-hub-feedback"),
-			FilterExpression: aws.String("user_id = :user_id"),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":user_id": {S: aws.String(userObj.ID)},
-			},
+		requestLogger(c).Error("failed to list feedback, the feedback table may not exist", "error", err)
+		// Return empty list instead of error
+		c.JSON(http.StatusOK, gin.H{
+			"feedback": []Feedback{},
+			"count":    0,
+			"message":  "Feedback table not yet initialized. Your feedback will be saved when you submit.",
 		})
-
-		if scanErr != nil {
-			log.Printf("❌ Scan also failed: %v. Table may not exist.", scanErr)
-			// Return empty list instead of error
-			c.JSON(http.StatusOK, gin.H{
-				"feedback": []Feedback{},
-				"count":    0,
-				"message":  "Feedback table not yet initialized. Your feedback will be saved when you submit.",
-			})
-			return
-		}
-		items = scanResult.Items
-	} else {
-		items = queryResult.Items
+		return
 	}
 
-	var feedbackList []Feedback
-	err = dynamodbattribute.UnmarshalListOfMaps(items, &feedbackList)
-	if err != nil {
-		log.Printf("Error unmarshaling feedback: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse feedback"})
-		return
+	// Triage details are for admins only
+	for i := range feedbackList {
+		feedbackList[i].AssignedTo = ""
+		feedbackList[i].InternalNotes = nil
 	}
+	h.presignAttachmentURLs(feedbackList)
 
 	c.JSON(http.StatusOK, gin.H{
 		"feedback": feedbackList,
@@ -1838,125 +3718,26 @@ func (h *PuzzleHub) getAllFeedback(c *gin.Context) {
 }
 
 // Web server setup
-// Analytics tracking types
-type AnalyticsEvent struct {
-	ID        string    `json:"id" dynamodbav:"id"`
-	EventType string    `json:"event_type" dynamodbav:"event_type"` // "visit", "login"
-	Timestamp time.Time `json:"timestamp" dynamodbav:"timestamp"`
-	IP        string    `json:"ip,omitempty" dynamodbav:"ip,omitempty"`
-	UserID    string    `json:"user_id,omitempty" dynamodbav:"user_id,omitempty"`
-	IsNew     bool      `json:"is_new" dynamodbav:"is_new"` // New visitor or new user
-}
-
-// In-memory cache for quick lookups (synced from DynamoDB on startup)
-var (
-	totalVisits    int64
-	totalLogins    int64
-	uniqueVisitors = make(map[string]bool) // Track by IP
-	uniqueUsers    = make(map[string]bool) // Track by User ID
-	analyticsDB    *dynamodb.DynamoDB
-)
-
-func logAnalytics() {
-	log.Printf("📊 ANALYTICS - Total Visits: %d | Unique Visitors: %d | Total Logins: %d | Unique Users: %d",
-		totalVisits, len(uniqueVisitors), totalLogins, len(uniqueUsers))
-}
-
-func saveAnalyticsEvent(eventType, ip, userID string, isNew bool) error {
-	event := AnalyticsEvent{
-		ID:        fmt.Sprintf("%s_%d", eventType, time.Now().UnixNano()),
-		EventType: eventType,
-		Timestamp: time.Now(),
-		IP:        ip,
-		UserID:    userID,
-		IsNew:     isNew,
-	}
-
-	item, err := dynamodbattribute.MarshalMap(event)
-	if err != nil {
-		return err
-	}
-
-	_, err = analyticsDB.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String("puzzle-hub-analytics"),
-		Item:      item,
-	})
-	return err
-}
-
-func loadAnalyticsFromDB(db *dynamodb.DynamoDB) error {
-	analyticsDB = db
-
-	// Scan analytics table to rebuild in-memory cache
-	result, err := db.Scan(&dynamodb.ScanInput{
-		TableName: aws.String("puzzle-hub-analytics"),
-	})
-	if err != nil {
-		return err
-	}
-
-	visitorIPs := make(map[string]bool)
-	userIDs := make(map[string]bool)
-
-	for _, item := range result.Items {
-		var event AnalyticsEvent
-		if err := dynamodbattribute.UnmarshalMap(item, &event); err != nil {
-			continue
-		}
-
-		if event.EventType == "visit" {
-			totalVisits++
-			if event.IP != "" {
-				visitorIPs[event.IP] = true
-			}
-		} else if event.EventType == "login" {
-			totalLogins++
-			if event.UserID != "" {
-				userIDs[event.UserID] = true
-			}
-		}
-	}
-
-	uniqueVisitors = visitorIPs
-	uniqueUsers = userIDs
-
-	log.Printf("📊 Loaded analytics from DynamoDB: %d visits, %d unique visitors, %d logins, %d unique users",
-		totalVisits, len(uniqueVisitors), totalLogins, len(uniqueUsers))
-
-	return nil
-}
-
 func setupRoutes(hub *PuzzleHub) *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Printf("⚠️  Failed to set trusted proxies: %v", err)
+	}
+	r.Use(requestIDMiddleware(), tracingMiddleware(), requestLogMiddleware(), metricsMiddleware(), hub.errorReportingMiddleware())
+	r.Use(hub.securityHeadersMiddleware(), corsMiddleware()) // See http_security.go
+	r.Use(compressionMiddleware())                           // See http_compression.go
 
 	// Analytics middleware - track every request
 	r.Use(func(c *gin.Context) {
 		// Only count page visits, not API calls or static files
 		if !strings.HasPrefix(c.Request.URL.Path, "/api/") &&
 			!strings.HasPrefix(c.Request.URL.Path, "/static/") &&
-			c.Request.URL.Path != "/favicon.ico" {
-
-			totalVisits++
-			clientIP := c.ClientIP()
-			isNewVisitor := !uniqueVisitors[clientIP]
-
-			if isNewVisitor {
-				uniqueVisitors[clientIP] = true
-				log.Printf("🆕 New visitor from IP: %s | Total visits: %d | Unique visitors: %d",
-					clientIP, totalVisits, len(uniqueVisitors))
-			}
-
-			// Save to DynamoDB (async to not slow down requests)
-			go func() {
-				if err := saveAnalyticsEvent("visit", clientIP, "", isNewVisitor); err != nil {
-					log.Printf("Warning: Failed to save visit event: %v", err)
-				}
-			}()
+			c.Request.URL.Path != "/favicon.ico" &&
+			c.Request.URL.Path != "/metrics" &&
+			c.Request.URL.Path != "/healthz" &&
+			c.Request.URL.Path != "/readyz" {
 
-			// Log analytics every 10 visits
-			if totalVisits%10 == 0 {
-				logAnalytics()
-			}
+			hub.Analytics.RecordVisit(hub.visitorID(c))
 		}
 		c.Next()
 	})
@@ -1966,21 +3747,41 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 
 	// Authentication routes (public)
 	auth := r.Group("/auth")
+	auth.Use(hub.APIRateLimit.Middleware())
 	{
 		auth.GET("/google", func(c *gin.Context) {
 			if hub.AuthConfig.GoogleOAuth.ClientID == "" {
-				c.JSON(http.StatusServiceUnavailable, gin.H{
-					"error": "Google OAuth not configured. Please set GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET environment variables.",
-				})
+				respondErrorCode(c, http.StatusServiceUnavailable, "oauth_not_configured",
+					"Google OAuth not configured. Please set GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET environment variables.", nil)
 				return
 			}
 
-			state := fmt.Sprintf("state_%d", time.Now().UnixNano())
-			url := hub.AuthConfig.GoogleOAuth.AuthCodeURL(state, oauth2.AccessTypeOffline)
+			url, err := hub.beginOAuthLogin(c)
+			if err != nil {
+				requestLogger(c).Error("failed to start OAuth login", "error", err)
+				respondStorageError(c, err, "Failed to start login")
+				return
+			}
 			c.JSON(http.StatusOK, gin.H{"url": url})
 		})
 
-		auth.GET("/google/callback", func(c *gin.Context) {
+		auth.GET("/google/callback", htmlSecurityHeaders(), func(c *gin.Context) {
+			verifier, err := hub.verifyOAuthState(c)
+			if err != nil {
+				requestLogger(c).Warn("rejected OAuth callback", "error", err)
+				c.HTML(http.StatusBadRequest, "callback.html", gin.H{
+					"error": "Login session is invalid or expired. Please try again.",
+				})
+				return
+			}
+
+			if oauthErr := c.Query("error"); oauthErr != "" {
+				c.HTML(http.StatusBadRequest, "callback.html", gin.H{
+					"error": "Google sign-in was not completed: " + oauthErr,
+				})
+				return
+			}
+
 			code := c.Query("code")
 			if code == "" {
 				c.HTML(http.StatusBadRequest, "callback.html", gin.H{
@@ -1990,9 +3791,9 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 			}
 
 			// Exchange code for token
-			token, err := hub.AuthConfig.GoogleOAuth.Exchange(context.Background(), code)
+			token, err := hub.AuthConfig.GoogleOAuth.Exchange(context.Background(), code, oauth2.VerifierOption(verifier))
 			if err != nil {
-				log.Printf("Failed to exchange code for token: %v", err)
+				requestLogger(c).Error("failed to exchange code for token", "error", err)
 				c.HTML(http.StatusInternalServerError, "callback.html", gin.H{
 					"error": "Failed to exchange authorization code",
 				})
@@ -2002,7 +3803,7 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 			// Get user info from Google
 			googleUser, err := hub.getUserFromGoogle(token.AccessToken)
 			if err != nil {
-				log.Printf("Failed to get user info from Google: %v", err)
+				requestLogger(c).Error("failed to get user info from Google", "error", err)
 				c.HTML(http.StatusInternalServerError, "callback.html", gin.H{
 					"error": "Failed to get user information",
 				})
@@ -2013,34 +3814,12 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 			user := hub.createOrUpdateUser(googleUser)
 
 			// Track login analytics
-			totalLogins++
-			isNewUser := !uniqueUsers[user.ID]
-			if isNewUser {
-				uniqueUsers[user.ID] = true
-			}
-
-			if isNewUser {
-				log.Printf("🎉 New user login | Total logins: %d | Unique users: %d", totalLogins, len(uniqueUsers))
-			} else {
-				log.Printf("🔄 Returning user login | Total logins: %d | Unique users: %d", totalLogins, len(uniqueUsers))
-			}
-
-			// Save to DynamoDB (async)
-			go func() {
-				if err := saveAnalyticsEvent("login", "", user.ID, isNewUser); err != nil {
-					log.Printf("Warning: Failed to save login event: %v", err)
-				}
-			}()
-
-			// Log full analytics every 5 logins
-			if totalLogins%5 == 0 {
-				logAnalytics()
-			}
+			hub.Analytics.RecordLogin(user.ID, hub.visitorID(c))
 
-			// Generate JWT token
-			jwtToken, err := hub.generateJWT(user)
+			// Start a login session with access and refresh tokens
+			jwtToken, refreshToken, err := hub.startLoginSession(c, user, "google")
 			if err != nil {
-				log.Printf("Failed to generate JWT: %v", err)
+				requestLogger(c).Error("failed to start login session", "error", err)
 				c.HTML(http.StatusInternalServerError, "callback.html", gin.H{
 					"error": "Failed to generate authentication token",
 				})
@@ -2051,35 +3830,36 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 			c.HTML(http.StatusOK, "callback.html", gin.H{
 				"success": true,
 				"result": LoginResponse{
-					Success: true,
-					User:    user,
-					Token:   jwtToken,
-					Message: "Login successful",
+					Success:      true,
+					User:         user,
+					Token:        jwtToken,
+					RefreshToken: refreshToken,
+					ExpiresIn:    int(accessTokenTTL.Seconds()),
+					Message:      "Login successful",
 				},
 			})
 		})
 
-		auth.POST("/logout", func(c *gin.Context) {
-			// For JWT, logout is handled client-side by removing the token
-			c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
-		})
+		// Teachers linking a class to Google Classroom, see lms.go
+		auth.GET("/google/classroom/callback", htmlSecurityHeaders(), hub.googleClassroomCallback)
 
-		auth.GET("/me", func(c *gin.Context) {
-			authHeader := c.GetHeader("Authorization")
-			if authHeader == "" {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "No authorization token provided"})
-				return
-			}
+		auth.POST("/refresh", hub.refreshAuthTokens)
+		auth.POST("/logout", hub.logout)
+		auth.GET("/sessions", hub.authMiddleware(), hub.listLoginSessions)
+		auth.DELETE("/sessions/:id", hub.authMiddleware(), hub.revokeLoginSession)
+		auth.POST("/guest", hub.startGuestSession)
+		auth.POST("/link-guest", hub.authMiddleware(), hub.linkGuestAccount)
 
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+		auth.GET("/me", func(c *gin.Context) {
+			tokenString, err := bearerToken(c)
+			if err != nil {
+				respondError(c, http.StatusUnauthorized, err.Error())
 				return
 			}
 
-			user, err := hub.validateJWT(parts[1])
+			user, err := hub.validateJWT(tokenString)
 			if err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				respondError(c, http.StatusUnauthorized, "Invalid token")
 				return
 			}
 
@@ -2088,55 +3868,97 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 	}
 
 	// Main page - puzzle selection
-	r.GET("/", func(c *gin.Context) {
+	r.GET("/", htmlSecurityHeaders(), func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.html", gin.H{
 			"title": "Puzzle Hub - Choose Your Game",
 		})
 	})
 
 	// Legal pages (public)
-	r.GET("/terms", func(c *gin.Context) {
+	r.GET("/terms", htmlSecurityHeaders(), func(c *gin.Context) {
 		c.HTML(http.StatusOK, "terms.html", gin.H{
 			"title": "Terms of Service - Puzzle Hub",
 		})
 	})
 
+	// Prometheus metrics
+	r.GET("/metrics", metricsHandler())
+
+	// Liveness and readiness probes, see health.go
+	r.GET("/healthz", hub.healthz)
+	r.GET("/readyz", hub.readyz)
+
 	// Favicon
 	r.GET("/favicon.ico", func(c *gin.Context) {
 		c.Status(http.StatusNoContent)
 	})
 
-	// API routes (protected)
-	api := r.Group("/api")
-	api.Use(hub.authMiddleware()) // Apply authentication middleware to all API routes
+	// The API is versioned under /api/v1. The unversioned /api paths stay
+	// as a deprecated alias for older clients, see openapi.go.
+	registerAPIRoutes(hub, r.Group(apiV1Prefix))
+	registerAPIRoutes(hub, r.Group("/api", deprecatedAPIMiddleware()))
+	r.GET(apiV1Prefix+"/openapi.json", etagMiddleware(), openAPIHandler(r))
+
+	return r
+}
+
+// registerAPIRoutes mounts the JSON API on base, once per API prefix
+func registerAPIRoutes(hub *PuzzleHub, base *gin.RouterGroup) {
+	// Route protection is declared per group: puzzle games are public (with
+	// the user attached when a valid token is sent), everything else under
+	// /api requires authentication.
+
+	// Realtime WebSocket, authenticated with ?access_token= (see realtime.go)
+	base.GET("/ws", hub.APIRateLimit.Middleware(), hub.realtimeConnect(realtimeUpgrader()))
+
+	// Inbound quick-log mail from the SES relay, signed with
+	// INBOUND_EMAIL_SECRET (see email_logging.go)
+	base.POST("/integrations/email/inbound", hub.APIRateLimit.Middleware(), hub.receiveLogEmail)
+
+	// Game API routes (public, optional auth)
+	games := base.Group("")
+	games.Use(hub.optionalAuthMiddleware(), hub.aiOverrideMiddleware(), hub.APIRateLimit.Middleware(), etagMiddleware())
 	{
+		// Languages, see i18n.go
+		games.GET("/languages", hub.listLanguages)
+
 		// Spelling Bee endpoints
-		api.POST("/spelling/generate", func(c *gin.Context) {
+		games.POST("/spelling/generate", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.aiQuota("spelling"), func(c *gin.Context) {
 			var criteria GenerationCriteria
 			if err := c.ShouldBindJSON(&criteria); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				respondBindError(c, err)
+				return
+			}
+			if err := criteria.normalize(); err != nil {
+				respondError(c, http.StatusBadRequest, err.Error())
 				return
 			}
+			hub.accessibilityFor(c).applyToSpelling(&criteria)
 
-			problems, err := hub.GenerateSpellingProblems(criteria)
+			problems, source, err := hub.GenerateSpellingProblems(c.Request.Context(), criteria, optionalUserID(c))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				respondError(c, http.StatusInternalServerError, err.Error())
 				return
 			}
 
-			c.JSON(http.StatusOK, gin.H{"problems": problems})
+			c.JSON(http.StatusOK, gin.H{"problems": problems, "source": source})
 		})
 
-		api.POST("/spelling/generate-for-age", func(c *gin.Context) {
+		games.POST("/spelling/generate-for-age", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.aiQuota("spelling"), func(c *gin.Context) {
 			var request struct {
 				Age          int    `json:"age" binding:"required"`
 				Count        int    `json:"count"`
 				Theme        string `json:"theme"`
 				ForceRefresh bool   `json:"force_refresh"`
+				CreativityOptions
 			}
 
 			if err := c.ShouldBindJSON(&request); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				respondBindError(c, err)
+				return
+			}
+			if err := request.normalize(); err != nil {
+				respondError(c, http.StatusBadRequest, err.Error())
 				return
 			}
 
@@ -2146,32 +3968,36 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 
 			difficulty := determineDifficultyLevel(request.Age)
 			criteria := GenerationCriteria{
-				DifficultyLevel:  string(difficulty),
-				AgeGroup:         fmt.Sprintf("%d years old", request.Age),
-				WordCount:        request.Count,
-				Theme:            request.Theme,
-				IncludePhonetics: true,
-				IncludeHints:     true,
+				DifficultyLevel:   string(difficulty),
+				AgeGroup:          fmt.Sprintf("%d years old", request.Age),
+				WordCount:         request.Count,
+				Theme:             request.Theme,
+				IncludePhonetics:  true,
+				IncludeHints:      true,
+				ForceRefresh:      request.ForceRefresh,
+				CreativityOptions: request.CreativityOptions,
 			}
+			hub.accessibilityFor(c).applyToSpelling(&criteria)
 
-			problems, err := hub.GenerateSpellingProblems(criteria)
+			problems, source, err := hub.GenerateSpellingProblems(c.Request.Context(), criteria, optionalUserID(c))
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				respondError(c, http.StatusInternalServerError, err.Error())
 				return
 			}
 
-			c.JSON(http.StatusOK, gin.H{"problems": problems})
+			c.JSON(http.StatusOK, gin.H{"problems": problems, "source": source})
 		})
 
 		// Yohaku endpoints
-		api.POST("/yohaku/generate", func(c *gin.Context) {
+		games.POST("/yohaku/generate", hub.screenTimeMiddleware(), func(c *gin.Context) {
 			var settings GameSettings
 			if err := c.ShouldBindJSON(&settings); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				respondBindError(c, err)
 				return
 			}
+			hub.accessibilityFor(c).applyToYohaku(&settings)
 
-			if settings.TimerDuration == 0 {
+			if settings.TimerDuration == 0 && !settings.Untimed {
 				settings.TimerDuration = 30
 			}
 			if settings.Size == 0 {
@@ -2194,12 +4020,13 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 			})
 		})
 
-		api.POST("/yohaku/start-game", func(c *gin.Context) {
+		games.POST("/yohaku/start-game", hub.screenTimeMiddleware(), func(c *gin.Context) {
 			var settings GameSettings
 			if err := c.ShouldBindJSON(&settings); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				respondBindError(c, err)
 				return
 			}
+			hub.accessibilityFor(c).applyToYohaku(&settings)
 
 			// Set defaults
 			if settings.Operation == "" {
@@ -2209,116 +4036,374 @@ func setupRoutes(hub *PuzzleHub) *gin.Engine {
 			session := hub.GenerateYohakuGameSession(settings)
 			c.JSON(http.StatusOK, gin.H{
 				"session": session,
-				"message": "Game session created with 10 progressive puzzles!",
+				"message": tr(c, "Game session created with 10 progressive puzzles!"),
 			})
 		})
 
-		api.POST("/yohaku/validate", func(c *gin.Context) {
+		games.POST("/yohaku/validate", func(c *gin.Context) {
 			var request struct {
 				PuzzleID string   `json:"puzzleId"`
 				Grid     [][]Cell `json:"grid"`
 			}
 
 			if err := c.ShouldBindJSON(&request); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				respondBindError(c, err)
 				return
 			}
 
 			c.JSON(http.StatusOK, gin.H{
 				"valid":   true,
-				"message": "Puzzle solved correctly!",
+				"message": tr(c, "Puzzle solved correctly!"),
 			})
 		})
 
-		api.POST("/yohaku/hint", func(c *gin.Context) {
+		games.POST("/yohaku/hint", func(c *gin.Context) {
 			var request struct {
-				PuzzleID string `json:"puzzleId"`
+				PuzzleID  string   `json:"puzzleId"`
+				Grid      [][]Cell `json:"grid"`
+				Operation string   `json:"operation"`
 			}
 
 			if err := c.ShouldBindJSON(&request); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				respondBindError(c, err)
 				return
 			}
 
+			// No AI hints yet, so hints always come from the rule-based solver
+			hint, ok := yohakuHint(request.Grid, request.Operation, requestLanguage(c))
+			if !ok {
+				hint = tr(c, "Try focusing on the cells with the smallest possible values first!")
+			}
 			c.JSON(http.StatusOK, gin.H{
-				"hint": "Try focusing on the cells with the smallest possible values first!",
+				"hint":   hint,
+				"source": sourceFallback,
 			})
 		})
 
+		// Multiplication tables trainer, see math_facts.go
+		games.POST("/math-facts/start", hub.screenTimeMiddleware(), hub.startFactDrill)
+		games.POST("/math-facts/submit", hub.submitFactDrill)
+
+		// Math word problems, see word_problems.go
+		games.POST("/math/word-problems", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.aiQuota("math"), hub.generateWordProblems)
+		games.POST("/math/word-problems/:id/submit", hub.submitWordProblem)
+		games.POST("/math/word-problems/:id/explanation", hub.AIRateLimit.Middleware(), hub.explainWordProblem)
+
+		// Sudoku, see sudoku.go
+		games.POST("/sudoku/generate", hub.screenTimeMiddleware(), hub.generateSudoku)
+		games.POST("/sudoku/start-game", hub.screenTimeMiddleware(), hub.startSudokuGame)
+		games.POST("/sudoku/validate", hub.validateSudoku)
+		games.POST("/sudoku/hint", hub.sudokuHint)
+
+		// Crosswords, see crossword.go
+		games.POST("/crossword/generate", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.generateCrossword)
+		games.GET("/crossword/:id", hub.getCrossword)
+		games.POST("/crossword/:id/check", hub.checkCrossword)
+
+		// Quizzes, see quiz.go
+		games.GET("/quiz/topics", hub.listQuizTopics)
+		games.POST("/quiz/start", hub.screenTimeMiddleware(), hub.startQuiz)
+		games.POST("/quiz/submit", hub.submitQuiz)
+
 		// Writing Analysis endpoints
-		api.POST("/writing/analyze", func(c *gin.Context) {
+		games.POST("/writing/analyze", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.aiQuota("writing"), func(c *gin.Context) {
 			var request WritingAnalysisRequest
 			if err := c.ShouldBindJSON(&request); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				respondBindError(c, err)
 				return
 			}
 
 			// Validate grade level
 			if request.GradeLevel < 1 || request.GradeLevel > 12 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Grade level must be between 1 and 12"})
+				respondError(c, http.StatusBadRequest, "Grade level must be between 1 and 12")
 				return
 			}
 
 			// Validate text length
 			if len(strings.TrimSpace(request.Text)) < 10 {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Text must be at least 10 characters long"})
-				return
-			}
-
-			analysis, err := hub.AnalyzeWriting(request)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				respondError(c, http.StatusBadRequest, "Text must be at least 10 characters long")
 				return
 			}
 
+			analysis := hub.AnalyzeWriting(c.Request.Context(), request, optionalUserID(c))
+			hub.queueWritingFeedback(c, request, analysis)
 			c.JSON(http.StatusOK, gin.H{
 				"analysis": analysis,
-				"message":  "Writing analysis completed successfully!",
+				"message":  tr(c, "Writing analysis completed successfully!"),
 			})
 		})
+		// Remaining daily AI quota, for signed-in and anonymous callers
+		games.GET("/usage", hub.getUsage)
+
+		games.POST("/writing/analyze/batch", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.analyzeWritingBatch)
+		games.POST("/writing/analyze/stream", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.aiQuota("writing"), hub.streamWritingAnalysis)
+		// Move feedback onto edited text, see writing_anchors.go
+		games.POST("/writing/reanchor", hub.reanchorWriting)
 
+	}
+
+	// API routes (authenticated)
+	api := base.Group("")
+	api.Use(hub.authMiddleware(), hub.aiOverrideMiddleware(), hub.APIRateLimit.Middleware(), etagMiddleware())
+	{
 		// Story Starter endpoints
-		api.POST("/story/generate", func(c *gin.Context) {
+		api.POST("/story/generate", hub.AIRateLimit.Middleware(), hub.aiQuota("story"), func(c *gin.Context) {
 			var request StoryRequest
 			if err := c.ShouldBindJSON(&request); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				respondBindError(c, err)
 				return
 			}
-
-			story, err := hub.GenerateStory(request)
-			if err != nil {
-				log.Printf("Error generating story: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate story"})
+			if err := request.normalize(); err != nil {
+				respondError(c, http.StatusBadRequest, err.Error())
 				return
 			}
 
+			story := hub.GenerateStory(c.Request.Context(), request, c.MustGet("user").(*User).ID)
 			c.JSON(http.StatusOK, story)
 		})
+		api.POST("/story/generate/stream", hub.AIRateLimit.Middleware(), hub.aiQuota("story"), hub.streamStory)
+
+		// Saved stories, see story_drafts.go
+		api.POST("/story", hub.createStoryDraft)
+		api.GET("/story", hub.listStoryDrafts)
+		api.GET("/story/:id", hub.getStoryDraft)
+		api.PUT("/story/:id", hub.updateStoryDraft)
+		api.DELETE("/story/:id", hub.deleteStoryDraft)
+		api.POST("/story/:id/title-suggestions", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.suggestStoryTitles)
+		api.POST("/story/:id/title", hub.pickStoryTitle)
 
 		// Feedback endpoints
 		api.POST("/feedback/submit", hub.submitFeedback)
+		api.POST("/feedback/attachments/upload-url", hub.createAttachmentUploadURL)
+		api.POST("/feedback/rating", hub.submitAppRating)
+		api.GET("/feedback/ratings/summary", hub.getAppRatingsSummary)
 		api.GET("/feedback/list", hub.getAllFeedback)
+		api.GET("/feedback/roadmap", hub.getFeatureRoadmap)
+		api.POST("/feedback/roadmap/:id/vote", hub.voteFeatureRequest)
+		api.DELETE("/feedback/roadmap/:id/vote", hub.unvoteFeatureRequest)
+		api.GET("/feedback/:id", hub.getFeedbackDetail)
+		api.POST("/feedback/:id/comments", hub.addFeedbackComment)
+
+		// Learning progress
+		api.POST("/progress", hub.recordProgress)
+		api.GET("/progress", hub.getMyProgress)
+		api.GET("/progress/summary", hub.getProgressSummary)
+		api.GET("/math-facts/mastery", hub.getFactMasteryReport)
+		api.GET("/quiz/mastery", hub.getQuizMasteryReport)
+
+		// XP, levels and avatars, see gamification.go
+		api.GET("/gamification/profile", hub.getGamificationProfile)
+		api.PUT("/gamification/avatar", hub.updateAvatar)
+
+		// Flashcards, see flashcards.go
+		api.GET("/flashcards/decks", hub.listFlashcardDecks)
+		api.POST("/flashcards/decks", hub.createFlashcardDeck)
+		api.DELETE("/flashcards/decks/:id", hub.deleteFlashcardDeck)
+		api.GET("/flashcards/decks/:id/cards", hub.listFlashcards)
+		api.POST("/flashcards/decks/:id/cards", hub.addFlashcard)
+		api.DELETE("/flashcards/cards/:id", hub.deleteFlashcard)
+		api.POST("/flashcards/push", hub.pushFlashcardsHandler)
+		api.GET("/flashcards/review", hub.getReviewQueue)
+		api.POST("/flashcards/review", hub.submitFlashcardReview)
+
+		// Parental controls
+		api.POST("/parental/invites", RequireRole(RoleParent), hub.createParentalInvite)
+		api.POST("/parental/accept", hub.acceptParentalInvite)
+		api.GET("/parental/children", RequireRole(RoleParent), hub.getChildren)
+		api.PUT("/parental/children/:childId/limits", RequireRole(RoleParent), hub.updateScreenTimeLimits)
+		api.GET("/parental/children/:childId/progress/summary", RequireRole(RoleParent), hub.getChildProgressSummary)
+		api.GET("/parental/report/preview", RequireRole(RoleParent), hub.previewParentReport)
+		api.DELETE("/parental/children/:childId", RequireRole(RoleParent), hub.unlinkChild)
+		api.GET("/parental/children/:childId/spelling-bee", RequireRole(RoleParent), hub.getChildSpellingBees)
+		api.GET("/parental/children/:childId/results-webhook", RequireRole(RoleParent), hub.getChildResultsWebhook)
+		api.PUT("/parental/children/:childId/results-webhook", RequireRole(RoleParent), hub.setChildResultsWebhook)
+		api.DELETE("/parental/children/:childId/results-webhook", RequireRole(RoleParent), hub.deleteChildResultsWebhook)
+
+		// Classrooms
+		api.GET("/classrooms", hub.getClassrooms)
+		api.POST("/classrooms", RequireRole(RoleTeacher), hub.createClassroom)
+		api.POST("/classrooms/join", hub.joinClassroom)
+		api.GET("/classrooms/:id", RequireRole(RoleTeacher), hub.getClassroomDetail)
+		api.POST("/classrooms/:id/join-code", RequireRole(RoleTeacher), hub.regenerateJoinCode)
+		api.GET("/classrooms/:id/progress", RequireRole(RoleTeacher), hub.getClassroomProgress)
+		api.DELETE("/classrooms/:id/members/:userId", hub.removeClassroomMember)
+
+		// Homework, see assignments.go
+		api.GET("/assignments", hub.listMyAssignments)
+		api.POST("/classrooms/:id/assignments", RequireRole(RoleTeacher), hub.createAssignment)
+		api.GET("/classrooms/:id/assignments", RequireRole(RoleTeacher), hub.listClassroomAssignments)
+		api.GET("/classrooms/:id/assignments/:assignmentId", RequireRole(RoleTeacher), hub.getAssignmentGrades)
+		api.PUT("/classrooms/:id/assignments/:assignmentId/grades/:userId", RequireRole(RoleTeacher), hub.gradeAssignment)
+		api.DELETE("/classrooms/:id/assignments/:assignmentId", RequireRole(RoleTeacher), hub.deleteAssignment)
+
+		// LMS integrations, see lms.go
+		api.GET("/classrooms/:id/integration", RequireRole(RoleTeacher), hub.getClassroomIntegration)
+		api.DELETE("/classrooms/:id/integration", RequireRole(RoleTeacher), hub.unlinkClassroomIntegration)
+		api.PUT("/classrooms/:id/integration/webhook", RequireRole(RoleTeacher), hub.linkClassroomWebhook)
+		api.POST("/classrooms/:id/integration/google", RequireRole(RoleTeacher), hub.beginGoogleClassroomLink)
+		api.GET("/classrooms/:id/integration/google/courses", RequireRole(RoleTeacher), hub.listGoogleClassroomCourses)
+		api.PUT("/classrooms/:id/integration/google/course", RequireRole(RoleTeacher), hub.selectGoogleClassroomCourse)
+
+		// Spelling bee sessions, see spelling_bee.go
+		api.POST("/spelling-bee/sessions", hub.AIRateLimit.Middleware(), hub.screenTimeMiddleware(), hub.aiQuota("spelling"), hub.startSpellingBee)
+		api.GET("/spelling-bee/sessions/:sessionId", hub.getSpellingBee)
+		api.GET("/spelling-bee/sessions/:sessionId/words/:index/audio", hub.getSpellingBeeAudio)
+		api.POST("/spelling-bee/sessions/:sessionId/words/:index/attempt", hub.attemptSpellingBeeWord)
+		api.POST("/spelling-bee/sessions/:sessionId/words/:index/recording", hub.createSpellingBeeRecordingURL)
+		api.POST("/spelling-bee/sessions/:sessionId/finish", hub.finishSpellingBee)
+
+		// Build your own yohaku, see yohaku_creator.go
+		api.POST("/yohaku/custom", hub.createCustomYohaku)
+		api.GET("/yohaku/custom", hub.listCustomYohaku)
+		api.GET("/yohaku/custom/:id", hub.getCustomYohaku)
+		api.DELETE("/yohaku/custom/:id", hub.deleteCustomYohaku)
+		api.POST("/yohaku/custom/:id/share", hub.shareCustomYohaku)
+		api.GET("/yohaku/shared/:code", hub.screenTimeMiddleware(), hub.playSharedYohaku)
+
+		// Remediation packets for missed words and questions, see remediation.go
+		api.POST("/remediation", hub.AIRateLimit.Middleware(), hub.createRemediationPacket)
+
+		// Challenges, see challenges.go
+		api.POST("/challenges", hub.createChallenge)
+		api.GET("/challenges/:code", hub.getChallenge)
+		api.POST("/challenges/:code/result", hub.submitChallengeResult)
+
+		// Realtime rooms for multiplayer yohaku and shared stories
+		api.POST("/realtime/rooms", hub.createRealtimeRoom)
+
+		// Changelog
+		api.GET("/changelog", hub.getChangelog)
+
+		// Notification center
+		api.GET("/notifications", hub.listNotifications)
+		api.POST("/notifications/read-all", hub.markAllNotificationsRead)
+		api.POST("/notifications/:id/read", hub.markNotificationRead)
+		api.POST("/changelog/seen", hub.markChangelogSeen)
 
 		// Custom Logging System endpoints
 		// Log Types
 		api.GET("/logs/types", hub.getLogTypes)
-		api.POST("/logs/types/suggest-fields", hub.suggestLogFields)
+		api.POST("/logs/types/suggest-fields", hub.AIRateLimit.Middleware(), hub.aiQuota("log_fields"), hub.suggestLogFields)
 		api.POST("/logs/types", hub.createLogType)
 		api.PUT("/logs/types/:id", hub.updateLogType)
 		api.DELETE("/logs/types/:id", hub.deleteLogType)
+		api.PUT("/logs/types/:id/fields/order", hub.reorderLogFields)
 
 		// Log Entries
 		api.GET("/logs/entries", hub.getLogEntries)
 		api.POST("/logs/entries", hub.createLogEntry)
 		api.PUT("/logs/entries/:id", hub.updateLogEntry)
 		api.DELETE("/logs/entries/:id", hub.deleteLogEntry)
+		api.POST("/logs/entries/:id/duplicate", hub.duplicateLogEntry)
+
+		// Retention and archives
+		api.PUT("/logs/types/:id/retention", hub.updateLogTypeRetention)
+		api.GET("/logs/types/:id/archives", hub.listLogArchives)
+		api.POST("/logs/types/:id/archives/:period/restore", hub.restoreLogArchive)
+
+		// Weekly snapshots and trend alerts, see log_alerts.go
+		api.GET("/logs/types/:id/snapshots", hub.getLogSnapshots)
+		api.GET("/logs/alerts", hub.listLogAlerts)
+		api.DELETE("/logs/alerts/:id", hub.dismissLogAlert)
+
+		// Saved dashboards, see log_dashboards.go
+		api.GET("/logs/types/:id/dashboards", hub.listLogDashboards)
+		api.POST("/logs/types/:id/dashboards", hub.createLogDashboard)
+		api.GET("/logs/dashboards/:id", hub.getLogDashboard)
+		api.PUT("/logs/dashboards/:id", hub.updateLogDashboard)
+		api.DELETE("/logs/dashboards/:id", hub.deleteLogDashboard)
+		api.GET("/logs/dashboards/:id/data", hub.getLogDashboardData)
+
+		// Quick-log by email, see email_logging.go
+		api.GET("/logs/email", hub.getEmailLogAddress)
+		api.POST("/logs/email", hub.createEmailLogAddress)
+		api.DELETE("/logs/email", hub.deleteEmailLogAddress)
+
+		// Households sharing log types, see households.go
+		api.GET("/household", hub.getHousehold)
+		api.POST("/household", hub.createHousehold)
+		api.DELETE("/household", hub.deleteHousehold)
+		api.POST("/household/join", hub.joinHousehold)
+		api.POST("/household/invite-code", hub.regenerateHouseholdInviteCode)
+		api.DELETE("/household/members/:userId", hub.removeHouseholdMember)
+		api.PUT("/logs/types/:id/household", hub.shareLogType)
+
+		// Shareable log type schemas, see log_type_schemas.go
+		api.GET("/logs/types/:id/schema", hub.exportLogTypeSchema)
+		api.POST("/logs/types/import", hub.importLogTypeSchema)
+
+		// Offline sync
+		api.POST("/logs/sync", hub.syncLogEntries)
+		api.GET("/logs/sync/changes", hub.getSyncChangesHandler)
+
+		// Admin console, see admin_console.go
+		admin := api.Group("/admin")
+		admin.Use(RequireRole(RoleAdmin))
+		{
+			admin.GET("/analytics", hub.adminGetSiteAnalytics)
+			admin.GET("/analytics/funnel", hub.adminGetFunnel)
+			admin.POST("/analytics/export", hub.adminExportAnalytics)
+			admin.GET("/ai-usage", hub.adminGetAIUsage)
+			admin.GET("/ai-latency", hub.adminGetAILatency)
+			admin.GET("/errors/:requestId", hub.adminGetErrorReport)
+			admin.GET("/jobs", hub.adminListJobs)
+			admin.POST("/jobs/:name/run", hub.adminRunJob)
+			admin.GET("/prompts", hub.adminListPrompts)
+			admin.GET("/prompts/:name", hub.adminGetPrompt)
+			admin.POST("/prompts/:name", hub.adminPublishPrompt)
+			admin.PUT("/prompts/:name/active", hub.adminActivatePrompt)
+			admin.POST("/prompts/:name/experiment", hub.adminStartPromptExperiment)
+			admin.DELETE("/prompts/:name/experiment", hub.adminStopPromptExperiment)
+			admin.GET("/prompt-experiments", hub.adminListPromptExperiments)
+			admin.GET("/prompt-experiments/:id", hub.adminGetPromptExperiment)
+			admin.GET("/moderation/flags", hub.adminListModerationFlags)
+			admin.PUT("/moderation/flags/:id/review", hub.adminReviewModerationFlag)
+			admin.GET("/content-reports", hub.adminListContentReports)
+			admin.PUT("/content-reports/:id", hub.adminReviewContentReport)
+			admin.GET("/ai-usage/users/:id", hub.adminGetUserAIUsage)
+			admin.GET("/users/:id/quota", hub.adminGetUserAIQuota)
+			admin.PUT("/users/:id/quota", hub.adminSetUserAIQuota)
+			admin.DELETE("/users/:id/quota", hub.adminDeleteUserAIQuota)
+			admin.GET("/quiz/topics/:topic/questions", hub.adminListQuizQuestions)
+			admin.POST("/quiz/topics/:topic/generate", hub.AIRateLimit.Middleware(), hub.adminGenerateQuizQuestions)
+			admin.DELETE("/quiz/topics/:topic/questions/:id", hub.adminDeleteQuizQuestion)
+			admin.GET("/ai-ratings", hub.adminGetAIRatings)
+			admin.GET("/users/roles", hub.adminListUserRoles)
+			admin.GET("/users/:id", hub.adminLookupUser)
+			admin.PUT("/users/:id/role", hub.adminUpdateUserRole)
+			admin.POST("/cache/purge", hub.adminPurgeCache)
+			admin.GET("/feedback", hub.adminListFeedback)
+			admin.GET("/feedback/export", hub.adminExportFeedback)
+			admin.PUT("/feedback/:id/status", hub.adminUpdateFeedbackStatus)
+			admin.PUT("/feedback/:id/assign", hub.adminAssignFeedback)
+			admin.POST("/feedback/:id/notes", hub.adminAddFeedbackNote)
+			admin.PUT("/feedback/:id/publish", hub.adminPublishFeatureRequest)
+		}
 
 		// Analytics
 		api.GET("/logs/analytics", hub.getLogAnalytics)
+		api.GET("/logs/analytics/heatmap", hub.getLogHeatmap)
+		api.GET("/logs/analytics/correlation", hub.getLogCorrelation)
 		api.GET("/logs/analytics/:logTypeId", hub.getLogTypeAnalytics)
-	}
 
-	return r
+		// AI usage and ratings
+		api.GET("/ai-usage", hub.getMyAIUsage)
+		api.POST("/ai/rate", hub.rateAIOutput)
+
+		// User settings
+		api.PUT("/user/timezone", hub.updateUserTimezone)
+		api.GET("/user/preferences", hub.getPreferences)
+		api.PUT("/user/preferences", hub.updatePreferences)
+		api.GET("/user/results-webhook", hub.getResultsWebhook)
+		api.PUT("/user/results-webhook", hub.setResultsWebhook)
+		api.DELETE("/user/results-webhook", hub.deleteResultsWebhook)
+
+		// Account export, see account_export.go
+		api.POST("/export/account", hub.requestAccountExport)
+
+		// Report inappropriate AI output, see content_reports.go
+		api.POST("/report-content", RequireRole(RoleParent, RoleTeacher), hub.reportContent)
+	}
 }
 
 func determineDifficultyLevel(age int) DifficultyLevel {
@@ -2352,11 +4437,13 @@ func initializeAuth() (*AuthConfig, error) {
 
 	log.Printf("🔐 Initializing OAuth with base URL: %s", baseURL)
 
-	// Generate JWT secret
-	jwtSecret := make([]byte, 32)
-	if _, err := rand.Read(jwtSecret); err != nil {
-		return nil, fmt.Errorf("failed to generate JWT secret: %v", err)
+	// Load JWT signing keys (stable across restarts when configured)
+	jwtKeys, err := loadJWTKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWT keys: %v", err)
 	}
+	activeKID, _ := jwtKeys.signingKey()
+	log.Printf("🔑 JWT signing key: %s (accepted: %s)", activeKID, strings.Join(jwtKeys.keyIDs(), ", "))
 
 	// Configure Google OAuth
 	googleOAuth := &oauth2.Config{
@@ -2367,41 +4454,72 @@ func initializeAuth() (*AuthConfig, error) {
 		Endpoint:     google.Endpoint,
 	}
 
-	// Create session store
-	sessionSecret := make([]byte, 32)
-	if _, err := rand.Read(sessionSecret); err != nil {
-		return nil, fmt.Errorf("failed to generate session secret: %v", err)
+	// Create session store (used for OAuth state). SESSION_SECRET keeps
+	// in-flight logins valid across instances and restarts.
+	sessionSecret := os.Getenv("SESSION_SECRET")
+	if sessionSecret == "" {
+		log.Println("⚠️  No SESSION_SECRET set; using a random key (logins will fail if the callback reaches another instance)")
+		if sessionSecret, err = randomToken(32); err != nil {
+			return nil, fmt.Errorf("failed to generate session secret: %v", err)
+		}
+	}
+	sessionStore := sessions.NewCookieStore([]byte(sessionSecret))
+
+	// Admins are configured by email since users are keyed by Google ID
+	adminEmails := make(map[string]bool)
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			adminEmails[email] = true
+		}
 	}
-	sessionStore := sessions.NewCookieStore(sessionSecret)
 
 	return &AuthConfig{
-		GoogleOAuth:  googleOAuth,
-		SessionStore: sessionStore,
-		JWTSecret:    jwtSecret,
+		BaseURL:       baseURL,
+		GoogleOAuth:   googleOAuth,
+		SessionStore:  sessionStore,
+		SecureCookies: strings.HasPrefix(baseURL, "https://"),
+		JWTKeys:       jwtKeys,
+		AdminEmails:   adminEmails,
 	}, nil
 }
 
-func (h *PuzzleHub) generateJWT(user *User) (string, error) {
+func (h *PuzzleHub) generateJWT(user *User, sessionID string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
 	claims := jwt.MapClaims{
 		"user_id": user.ID,
 		"email":   user.Email,
 		"name":    user.Name,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(), // 24 hour expiration
+		"jti":     jti,       // Lets logout denylist this specific token
+		"sid":     sessionID, // Login session, revocable from /auth/sessions
+		"exp":     time.Now().Add(accessTokenTTL).Unix(),
 		"iat":     time.Now().Unix(),
 	}
 
+	kid, secret := h.AuthConfig.JWTKeys.signingKey()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(h.AuthConfig.JWTSecret)
+	token.Header["kid"] = kid
+	return token.SignedString(secret)
 }
 
-func (h *PuzzleHub) validateJWT(tokenString string) (*User, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return h.AuthConfig.JWTSecret, nil
-	})
+// jwtKeyFunc resolves the verification key from the token's kid header
+func (h *PuzzleHub) jwtKeyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	secret, ok := h.AuthConfig.JWTKeys.verificationKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return secret, nil
+}
 
+func (h *PuzzleHub) validateJWT(tokenString string) (*User, error) {
+	token, err := jwt.Parse(tokenString, h.jwtKeyFunc)
 	if err != nil {
 		return nil, err
 	}
@@ -2412,17 +4530,70 @@ func (h *PuzzleHub) validateJWT(tokenString string) (*User, error) {
 			return nil, fmt.Errorf("invalid user_id in token")
 		}
 
-		user, exists := h.Users[userID]
-		if !exists {
-			return nil, fmt.Errorf("user not found")
+		jti, _ := claims["jti"].(string)
+		sessionID, _ := claims["sid"].(string)
+		revoked, err := h.isAccessTokenRevoked(jti, sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check token revocation: %v", err)
+		}
+		if revoked {
+			return nil, fmt.Errorf("token has been revoked")
 		}
 
-		return user, nil
+		email, _ := claims["email"].(string)
+		name, _ := claims["name"].(string)
+		return h.getOrRestoreUser(userID, email, name), nil
 	}
 
 	return nil, fmt.Errorf("invalid token")
 }
 
+// userProfileTTL is how long the Google profile saved at login is kept
+const userProfileTTL = 24 * time.Hour
+
+// userProfile is the part of a user only Google knows, saved at login so
+// requests on any instance can show it
+type userProfile struct {
+	Email       string    `json:"email"`
+	Picture     string    `json:"picture"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+func userProfileKey(userID string) string {
+	return "user-profile:" + userID
+}
+
+// getOrRestoreUser builds the request's user from token details. Users
+// aren't kept in memory, so every instance sees the same user: the role,
+// timezone and language are looked up each time (the storage layer caches
+// them) and the profile saved at login adds the picture while it is cached.
+func (h *PuzzleHub) getOrRestoreUser(userID, email, name string) *User {
+	now := time.Now()
+	user := &User{
+		ID:          userID,
+		Email:       email,
+		Name:        name,
+		GoogleID:    userID,
+		CreatedAt:   now,
+		LastLoginAt: now,
+		Timezone:    h.savedTimezone(userID),
+		Language:    h.savedLanguage(userID),
+		Role:        h.resolveRole(userID, email),
+	}
+	var profile userProfile
+	if getCachedJSON(context.Background(), h.Cache, "user_profile", userProfileKey(userID), &profile) {
+		user.Picture = profile.Picture
+		user.CreatedAt = profile.CreatedAt
+		user.LastLoginAt = profile.LastLoginAt
+	}
+	if isGuestID(userID) {
+		user.GoogleID = ""
+		user.IsGuest = true
+	}
+	return user
+}
+
 func (h *PuzzleHub) getUserFromGoogle(accessToken string) (*GoogleUserInfo, error) {
 	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + accessToken)
 	if err != nil {
@@ -2442,70 +4613,48 @@ func (h *PuzzleHub) createOrUpdateUser(googleUser *GoogleUserInfo) *User {
 	// Use Google ID as the stable user ID
 	// This ensures the same user gets the same ID across sessions
 	stableUserID := googleUser.ID
+	ctx := context.Background()
 
-	// Check if user already exists
-	if user, exists := h.Users[stableUserID]; exists {
-		// Update user info and last login
-		user.Email = googleUser.Email
-		user.Name = googleUser.Name
-		user.Picture = googleUser.Picture
-		user.LastLoginAt = time.Now()
+	now := time.Now()
+	profile := userProfile{CreatedAt: now}
+	if getCachedJSON(ctx, h.Cache, "user_profile", userProfileKey(stableUserID), &profile) {
 		log.Printf("✅ Existing user logged in")
-		return user
+	} else {
+		log.Printf("🆕 New user created")
 	}
+	profile.Email = googleUser.Email
+	profile.Picture = googleUser.Picture
+	profile.LastLoginAt = now
+	setCachedJSON(ctx, h.Cache, userProfileKey(stableUserID), profile, userProfileTTL)
 
-	// Create new user
-	user := &User{
+	// Resolved on every login so role changes and ADMIN_EMAILS edits apply
+	return &User{
 		ID:          stableUserID,
 		Email:       googleUser.Email,
 		Name:        googleUser.Name,
 		Picture:     googleUser.Picture,
 		GoogleID:    googleUser.ID,
-		CreatedAt:   time.Now(),
-		LastLoginAt: time.Now(),
+		CreatedAt:   profile.CreatedAt,
+		LastLoginAt: now,
+		Timezone:    h.savedTimezone(stableUserID),
+		Language:    h.savedLanguage(stableUserID),
+		Role:        h.resolveRole(stableUserID, googleUser.Email),
 	}
-
-	h.Users[stableUserID] = user
-	log.Printf("🆕 New user created")
-	return user
 }
 
-// Middleware for authentication
+// authMiddleware requires a valid bearer token and attaches the user
 func (h *PuzzleHub) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Skip auth for public endpoints and puzzle games
-		path := c.Request.URL.Path
-		if strings.HasPrefix(path, "/static/") ||
-			strings.HasPrefix(path, "/auth/") ||
-			strings.HasPrefix(path, "/api/spelling/") ||
-			strings.HasPrefix(path, "/api/yohaku/") ||
-			strings.HasPrefix(path, "/api/writing/") ||
-			path == "/" ||
-			path == "/terms" ||
-			path == "/favicon.ico" {
-			c.Next()
-			return
-		}
-
-		// Check for JWT token in Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
-		}
-
-		// Extract token from "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+		tokenString, err := bearerToken(c)
+		if err != nil {
+			respondError(c, http.StatusUnauthorized, err.Error())
 			c.Abort()
 			return
 		}
 
-		user, err := h.validateJWT(parts[1])
+		user, err := h.validateJWT(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			respondError(c, http.StatusUnauthorized, "Invalid token")
 			c.Abort()
 			return
 		}
@@ -2516,13 +4665,103 @@ func (h *PuzzleHub) authMiddleware() gin.HandlerFunc {
 	}
 }
 
+// optionalUserID returns the signed-in user's ID, or "" for anonymous requests
+func optionalUserID(c *gin.Context) string {
+	if user, exists := c.Get("user"); exists {
+		return user.(*User).ID
+	}
+	return ""
+}
+
+// optionalAuthMiddleware attaches the user when a valid bearer token is
+// present and otherwise lets the request through anonymously. Handlers must
+// check c.Get("user") themselves.
+func (h *PuzzleHub) optionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tokenString, err := bearerToken(c); err == nil {
+			if user, err := h.validateJWT(tokenString); err == nil {
+				c.Set("user", user)
+			}
+		}
+		c.Next()
+	}
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header
+func bearerToken(c *gin.Context) (string, error) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		return "", fmt.Errorf("Authorization header required")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", fmt.Errorf("Invalid authorization header format")
+	}
+	return parts[1], nil
+}
+
+// updateUserTimezone stores the IANA timezone used for the user's date math
+func (h *PuzzleHub) updateUserTimezone(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	var request UpdateTimezoneRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	if _, err := time.LoadLocation(request.Timezone); err != nil {
+		respondError(c, http.StatusBadRequest, "Unknown timezone: "+request.Timezone)
+		return
+	}
+
+	prefs, err := h.getUserPreferences(userObj.ID)
+	if err == nil {
+		prefs.Timezone = request.Timezone
+		err = h.putUserPreferences(prefs)
+	}
+	if err != nil {
+		requestLogger(c).Error("error saving timezone", "error", err)
+		respondStorageError(c, err, "Failed to update timezone")
+		return
+	}
+
+	userObj.Timezone = request.Timezone
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Timezone updated successfully",
+		"timezone": userObj.Timezone,
+		"today":    userToday(userObj),
+	})
+}
+
+// userLocation returns the user's preferred timezone, defaulting to UTC
+func userLocation(user *User) *time.Location {
+	if user != nil && user.Timezone != "" {
+		if loc, err := time.LoadLocation(user.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// userToday returns the current calendar date (YYYY-MM-DD) in the user's timezone
+func userToday(user *User) string {
+	return time.Now().In(userLocation(user)).Format("2006-01-02")
+}
+
 // Custom Logging System Handlers
 
 // Log Types handlers
 func (h *PuzzleHub) getLogTypes(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 	userObj := user.(*User)
@@ -2530,60 +4769,31 @@ func (h *PuzzleHub) getLogTypes(c *gin.Context) {
 	log.Printf("🔍 Fetching log types for user")
 
 	// Query log types for the user
-	result, err := h.DynamoDB.Query(&dynamodb.QueryInput{
-		TableName:              aws.String("puzzle-hub-log-types"),
-		IndexName:              aws.String("user-id-index"),
-		KeyConditionExpression: aws.String("user_id = :user_id"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":user_id": {
-				S: aws.String(userObj.ID),
-			},
-		},
-	})
+	stored, err := h.Store.ListLogTypes(userObj.ID)
 	if err != nil {
-		log.Printf("❌ Error querying log types: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch log types"})
+		requestLogger(c).Error("error querying log types", "error", err)
+		respondStorageError(c, err, "Failed to fetch log types")
 		return
 	}
 
-	log.Printf("📋 Found %d log type items in DynamoDB", len(result.Items))
-
-	var logTypes []LogType
-	for _, item := range result.Items {
-		var logType LogType
-		err := dynamodbattribute.UnmarshalMap(item, &logType)
-		if err != nil {
-			log.Printf("❌ Error unmarshaling log type: %v", err)
-			continue
-		}
+	// Log types other household members shared come after the user's own
+	shared, err := h.sharedLogTypesFor(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error querying shared log types", "error", err)
+		respondStorageError(c, err, "Failed to fetch log types")
+		return
+	}
+	stored = append(stored, shared...)
 
-		log.Printf("✅ Unmarshaled log type: %s (ID: %s)", logType.Name, logType.ID)
+	log.Printf("📋 Found %d log types", len(stored))
 
-		// Query fields for this log type
-		fieldsResult, err := h.DynamoDB.Query(&dynamodb.QueryInput{
-			TableName:              aws.String("puzzle-hub-log-fields"),
-			IndexName:              aws.String("log-type-id-index"),
-			KeyConditionExpression: aws.String("log_type_id = :log_type_id"),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":log_type_id": {
-					S: aws.String(logType.ID),
-				},
-			},
-		})
+	var logTypes []LogType
+	for _, logType := range stored {
+		fields, err := h.getLogFields(logType.ID)
 		if err != nil {
-			log.Printf("❌ Error querying log fields for %s: %v", logType.Name, err)
+			requestLogger(c).Error("error querying log fields", "error", err, "log_type_id", logType.ID)
 			// Continue without fields
 		} else {
-			var fields []LogField
-			for _, fieldItem := range fieldsResult.Items {
-				var field LogField
-				err := dynamodbattribute.UnmarshalMap(fieldItem, &field)
-				if err != nil {
-					log.Printf("❌ Error unmarshaling log field: %v", err)
-					continue
-				}
-				fields = append(fields, field)
-			}
 			logType.Fields = fields
 			log.Printf("📝 Added %d fields to log type: %s", len(fields), logType.Name)
 		}
@@ -2598,27 +4808,43 @@ func (h *PuzzleHub) getLogTypes(c *gin.Context) {
 func (h *PuzzleHub) createLogType(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 	userObj := user.(*User)
 
 	var request CreateLogTypeRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Printf("Error binding JSON in createLogType: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		requestLogger(c).Error("error binding JSON in createLogType", "error", err)
+		respondBindError(c, err)
 		return
 	}
 
 	log.Printf("Creating log type: %+v", request)
 
+	logTypeID, err := h.insertLogType(userObj.ID, request)
+	if err != nil {
+		requestLogger(c).Error("error putting log type", "error", err)
+		respondStorageError(c, err, "Failed to create log type")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Log type created successfully",
+		"log_type_id": logTypeID,
+	})
+}
+
+// insertLogType stores a new log type and its fields for userID, shared by
+// createLogType and schema imports.
+func (h *PuzzleHub) insertLogType(userID string, request CreateLogTypeRequest) (string, error) {
 	// Generate unique ID for log type
 	logTypeID := fmt.Sprintf("lt_%d", time.Now().UnixNano())
 
 	// Create log type
 	logType := LogType{
 		ID:          logTypeID,
-		UserID:      userObj.ID,
+		UserID:      userID,
 		Name:        request.Name,
 		Description: request.Description,
 		Color:       request.Color,
@@ -2627,26 +4853,12 @@ func (h *PuzzleHub) createLogType(c *gin.Context) {
 		UpdatedAt:   time.Now(),
 	}
 
-	// Marshal log type to DynamoDB format
-	logTypeItem, err := dynamodbattribute.MarshalMap(logType)
-	if err != nil {
-		log.Printf("Error marshaling log type: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create log type"})
-		return
-	}
-
-	// Put log type in DynamoDB
-	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String("puzzle-hub-log-types"),
-		Item:      logTypeItem,
-	})
-	if err != nil {
-		log.Printf("❌ Error putting log type: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create log type"})
-		return
+	if err := h.Store.PutLogType(&logType); err != nil {
+		return "", err
 	}
 
 	log.Printf("✅ Successfully created log type: %s (ID: %s)", logType.Name, logType.ID)
+	h.initializeLogAggregates(logTypeID)
 
 	// Create log fields
 	for i, field := range request.Fields {
@@ -2662,99 +4874,195 @@ func (h *PuzzleHub) createLogType(c *gin.Context) {
 			DisplayOrder: i,
 		}
 
-		fieldItem, err := dynamodbattribute.MarshalMap(logField)
-		if err != nil {
-			log.Printf("Error marshaling log field: %v", err)
-			continue
-		}
-
-		_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
-			TableName: aws.String("puzzle-hub-log-fields"),
-			Item:      fieldItem,
-		})
-		if err != nil {
+		if err := h.Store.PutLogField(&logField); err != nil {
 			log.Printf("Error putting log field: %v", err)
 			// Continue with other fields
 		}
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message":     "Log type created successfully",
-		"log_type_id": logTypeID,
-	})
+	return logTypeID, nil
 }
 
 func (h *PuzzleHub) updateLogType(c *gin.Context) {
 	// Implementation for updating log types
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented yet"})
+	respondError(c, http.StatusNotImplemented, "Not implemented yet")
 }
 
 func (h *PuzzleHub) deleteLogType(c *gin.Context) {
 	// Implementation for deleting log types
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented yet"})
+	respondError(c, http.StatusNotImplemented, "Not implemented yet")
+}
+
+// reorderLogFields rewrites DisplayOrder for every field of a log type
+// according to the position of its ID in the request.
+func (h *PuzzleHub) reorderLogFields(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	logTypeId := c.Param("id")
+
+	var request ReorderLogFieldsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	logType, status, err := h.getOwnedLogType(userObj.ID, logTypeId)
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	fields, err := h.getLogFields(logType.ID)
+	if err != nil {
+		requestLogger(c).Error("error querying log fields for reorder", "error", err)
+		respondStorageError(c, err, "Failed to fetch log fields")
+		return
+	}
+
+	// The request must list every field exactly once
+	if len(request.FieldIDs) != len(fields) {
+		respondError(c, http.StatusBadRequest, "field_ids must contain every field of the log type exactly once")
+		return
+	}
+
+	fieldsByID := make(map[string]*LogField, len(fields))
+	for i := range fields {
+		fieldsByID[fields[i].ID] = &fields[i]
+	}
+
+	seen := make(map[string]bool, len(request.FieldIDs))
+	for _, fieldID := range request.FieldIDs {
+		if _, ok := fieldsByID[fieldID]; !ok {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Unknown field ID: %s", fieldID))
+			return
+		}
+		if seen[fieldID] {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Duplicate field ID: %s", fieldID))
+			return
+		}
+		seen[fieldID] = true
+	}
+
+	defer h.invalidateLogFields(logType.ID)
+	for order, fieldID := range request.FieldIDs {
+		field := fieldsByID[fieldID]
+		if field.DisplayOrder == order {
+			continue
+		}
+
+		if err := h.Store.SetLogFieldOrder(fieldID, order); err != nil {
+			requestLogger(c).Error("error updating display order for field", "error", err, "field_id", fieldID)
+			respondStorageError(c, err, "Failed to reorder fields")
+			return
+		}
+		field.DisplayOrder = order
+	}
+
+	sortLogFields(fields)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Field order updated successfully",
+		"fields":  fields,
+	})
+}
+
+// getOwnedLogType loads a log type and verifies it belongs to the user.
+// The returned status code is meant to be passed straight to the client.
+func (h *PuzzleHub) getOwnedLogType(userID, logTypeID string) (*LogType, int, error) {
+	if logTypeID == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("Log type ID is required")
+	}
+
+	logType, err := h.Store.GetLogType(logTypeID)
+	if err != nil {
+		log.Printf("Error getting log type: %v", err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to fetch log type")
+	}
+
+	if logType == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("Log type not found")
+	}
+
+	if logType.UserID != userID {
+		return nil, http.StatusForbidden, fmt.Errorf("Access denied")
+	}
+
+	return logType, http.StatusOK, nil
+}
+
+// getLogFields returns the fields of a log type sorted by DisplayOrder
+func (h *PuzzleHub) getLogFields(logTypeID string) ([]LogField, error) {
+	fields, err := h.Store.ListLogFields(logTypeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+func sortLogFields(fields []LogField) {
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].DisplayOrder < fields[j].DisplayOrder
+	})
 }
 
 // AI-powered field suggestion using Perplexity
 func (h *PuzzleHub) suggestLogFields(c *gin.Context) {
-	_, exists := c.Get("user")
+	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 
 	var request SuggestFieldsRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
-		log.Printf("Error binding JSON in suggestLogFields: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		requestLogger(c).Error("error binding JSON in suggestLogFields", "error", err)
+		respondBindError(c, err)
 		return
 	}
 
 	log.Printf("Suggesting fields for log type: %s", request.LogTypeName)
 
-	// Build prompt for Perplexity
-	prompt := fmt.Sprintf(`You are an expert in data logging and tracking systems. A user wants to create a custom log type called "%s".
-
-Description: %s
-
-Please suggest 5-8 relevant fields that would be useful for tracking this type of activity. For each field, provide:
-1. Field name (concise, no spaces, use underscores)
-2. Field type (text, number, textarea, select, checkbox)
-3. Whether it should be required (true/false)
-4. Default value (if applicable)
-5. Options (if it's a select field, provide comma-separated options)
-6. Brief description of what this field tracks
-
-Focus on fields that would provide meaningful insights and analytics. For trading logs, include fields like entry_price, exit_price, quantity, profit_loss, strategy, etc. For gym logs, include fields like exercise, weight, sets, reps, duration, etc.
-
-Respond ONLY with a JSON object in this exact format:
-{
-  "suggested_fields": [
-    {
-      "field_name": "example_field",
-      "field_type": "number",
-      "required": true,
-      "default_value": "",
-      "options": "",
-      "description": "Brief description"
-    }
-  ],
-  "explanation": "Brief explanation of why these fields are useful for this log type"
-}`, request.LogTypeName, request.Description)
-
-	// Call Perplexity API
-	response, err := h.generateWithPerplexity(prompt)
-	if err != nil {
-		log.Printf("Error calling Perplexity API: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate field suggestions"})
+	choice := h.Prompts.choose("log_fields", user.(*User).ID)
+	cacheParams := choice.cacheParams(map[string]interface{}{
+		"name":        normalizeCacheParam(request.LogTypeName),
+		"description": normalizeCacheParam(request.Description),
+	})
+	var suggestionsResponse SuggestFieldsResponse
+	if h.loadAICache(c.Request.Context(), "log_fields", cacheParams, &suggestionsResponse) {
+		suggestionsResponse.Source = sourceAI
+		c.JSON(http.StatusOK, suggestionsResponse)
 		return
 	}
 
-	// Parse the JSON response
-	var suggestionsResponse SuggestFieldsResponse
-	if err := json.Unmarshal([]byte(response), &suggestionsResponse); err != nil {
-		log.Printf("Error parsing Perplexity response: %v", err)
-		// Fallback to basic suggestions
+	prompt := h.Prompts.renderChoice(choice, request)
+
+	call := aiCall{Feature: "log_fields", UserID: user.(*User).ID, Prompt: choice.PromptTag, Generation: newAIGeneration()}
+	err := h.generateJSON(c.Request.Context(), prompt, call, fieldSuggestionsSchema, &suggestionsResponse, func() error {
+		if len(suggestionsResponse.SuggestedFields) == 0 {
+			return fmt.Errorf("no suggested fields")
+		}
+		for i, field := range suggestionsResponse.SuggestedFields {
+			if field.FieldName == "" {
+				return fmt.Errorf("field %d has no field_name", i)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		requestLogger(c).Error("error getting field suggestions, using the generic ones", "error", err, "provider", h.providerFor("log_fields"))
 		suggestionsResponse = h.getFallbackFieldSuggestions(request.LogTypeName)
+	} else {
+		suggestionsResponse.Source = sourceAI
+		suggestionsResponse.PromptTag = choice.PromptTag
+		suggestionsResponse.GenerationID = call.Generation.ID
+		h.saveGeneration(call.Generation, call, choice)
+		h.storeAICache(c.Request.Context(), "log_fields", cacheParams, suggestionsResponse)
 	}
 
 	c.JSON(http.StatusOK, suggestionsResponse)
@@ -2802,6 +5110,7 @@ func (h *PuzzleHub) getFallbackFieldSuggestions(logTypeName string) SuggestField
 	return SuggestFieldsResponse{
 		SuggestedFields: fields,
 		Explanation:     explanation,
+		Source:          sourceFallback,
 	}
 }
 
@@ -2809,7 +5118,7 @@ func (h *PuzzleHub) getFallbackFieldSuggestions(logTypeName string) SuggestField
 func (h *PuzzleHub) getLogEntries(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 	userObj := user.(*User)
@@ -2817,212 +5126,380 @@ func (h *PuzzleHub) getLogEntries(c *gin.Context) {
 	// Get log_type_id from query parameter
 	logTypeId := c.Query("log_type_id")
 
-	var result *dynamodb.QueryOutput
-	var err error
-
+	// If a specific log type was requested, also return the log type info
+	var logType *LogType
 	if logTypeId != "" {
-		// Query log entries for specific log type
-		result, err = h.DynamoDB.Query(&dynamodb.QueryInput{
-			TableName:              aws.String("puzzle-hub-log-entries"),
-			IndexName:              aws.String("user-date-index"),
-			KeyConditionExpression: aws.String("user_id = :user_id"),
-			FilterExpression:       aws.String("log_type_id = :log_type_id"),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":user_id": {
-					S: aws.String(userObj.ID),
-				},
-				":log_type_id": {
-					S: aws.String(logTypeId),
-				},
-			},
-		})
+		if lt, _, err := h.getSharedLogType(userObj.ID, logTypeId); err == nil {
+			logType = lt
+		}
+	}
+
+	// A log type lists every household member's entries, or one member's
+	// with ?member=
+	var logEntries []LogEntry
+	var err error
+	if logType != nil {
+		logEntries, err = h.listLogTypeEntries(logType, LogEntryQuery{UserID: c.Query("member")})
 	} else {
-		// Query all log entries for the user
-		result, err = h.DynamoDB.Query(&dynamodb.QueryInput{
-			TableName:              aws.String("puzzle-hub-log-entries"),
-			IndexName:              aws.String("user-date-index"),
-			KeyConditionExpression: aws.String("user_id = :user_id"),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":user_id": {
-					S: aws.String(userObj.ID),
-				},
-			},
-		})
+		logEntries, err = h.Store.ListLogEntries(LogEntryQuery{UserID: userObj.ID, LogTypeID: logTypeId})
+	}
+	if err != nil {
+		requestLogger(c).Error("error querying log entries", "error", err)
+		respondStorageError(c, err, "Failed to fetch log entries")
+		return
+	}
+
+	response := gin.H{"log_entries": logEntries}
+	if logType != nil {
+		response["log_type"] = logType
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *PuzzleHub) createLogEntry(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	var request CreateLogEntryRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
 	}
 
+	// Validate entry date format
+	_, err := time.Parse("2006-01-02", request.EntryDate)
 	if err != nil {
-		log.Printf("Error querying log entries: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch log entries"})
+		respondError(c, http.StatusBadRequest, "Invalid date format. Use YYYY-MM-DD")
 		return
 	}
 
-	var logEntries []LogEntry
-	for _, item := range result.Items {
-		var entry LogEntry
-		err := dynamodbattribute.UnmarshalMap(item, &entry)
-		if err != nil {
-			log.Printf("Error unmarshaling log entry: %v", err)
+	// Household members can log to log types shared with them
+	if _, status, err := h.getSharedLogType(userObj.ID, request.LogTypeID); err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	// Normalize and validate values against the log type's field definitions
+	fields, err := h.getLogFields(request.LogTypeID)
+	if err != nil {
+		requestLogger(c).Error("error querying log fields for entry validation", "error", err)
+		respondStorageError(c, err, "Failed to create log entry")
+		return
+	}
+	if err := validateLogEntryValues(fields, request.Values); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Generate unique ID for log entry
+	entryID := fmt.Sprintf("le_%d", time.Now().UnixNano())
+
+	// Create log entry
+	logEntry := LogEntry{
+		ID:        entryID,
+		LogTypeID: request.LogTypeID,
+		UserID:    userObj.ID,
+		EntryDate: request.EntryDate,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Values:    request.Values,
+		Version:   1,
+	}
+
+	if _, err := h.Store.PutLogEntry(&logEntry); err != nil {
+		requestLogger(c).Error("error putting log entry", "error", err)
+		respondStorageError(c, err, "Failed to create log entry")
+		return
+	}
+
+	h.recordSyncChange(userObj.ID, syncOpUpsert, entryID, logEntry.Version, &logEntry)
+	h.adjustLogAggregates(&logEntry, 1)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Log entry created successfully",
+		"entry_id": entryID,
+	})
+}
+
+// duplicateLogEntry copies an existing entry to today's date (or the given
+// date), merging any value overrides from the request body.
+func (h *PuzzleHub) duplicateLogEntry(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	// The body is optional; an empty request simply logs the entry again today
+	var request DuplicateLogEntryRequest
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		respondBindError(c, err)
+		return
+	}
+
+	source, status, err := h.getOwnedLogEntry(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	entryDate := request.EntryDate
+	if entryDate == "" {
+		entryDate = userToday(userObj)
+	} else if _, err := time.Parse("2006-01-02", entryDate); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid date format. Use YYYY-MM-DD")
+		return
+	}
+
+	values := make(map[string]interface{}, len(source.Values)+len(request.Values))
+	for name, value := range source.Values {
+		values[name] = value
+	}
+	for name, value := range request.Values {
+		values[name] = value
+	}
+
+	// The user may have left the household the log type was shared with
+	if _, status, err := h.getSharedLogType(userObj.ID, source.LogTypeID); err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	fields, err := h.getLogFields(source.LogTypeID)
+	if err != nil {
+		requestLogger(c).Error("error querying log fields for entry validation", "error", err)
+		respondStorageError(c, err, "Failed to duplicate log entry")
+		return
+	}
+	if err := validateLogEntryValues(fields, values); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entryID := fmt.Sprintf("le_%d", time.Now().UnixNano())
+	logEntry := LogEntry{
+		ID:        entryID,
+		LogTypeID: source.LogTypeID,
+		UserID:    userObj.ID,
+		EntryDate: entryDate,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Values:    values,
+		Version:   1,
+	}
+
+	if _, err := h.Store.PutLogEntry(&logEntry); err != nil {
+		requestLogger(c).Error("error putting duplicated log entry", "error", err)
+		respondStorageError(c, err, "Failed to duplicate log entry")
+		return
+	}
+
+	h.recordSyncChange(userObj.ID, syncOpUpsert, entryID, logEntry.Version, &logEntry)
+	h.adjustLogAggregates(&logEntry, 1)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":         "Log entry duplicated successfully",
+		"entry_id":        entryID,
+		"source_entry_id": source.ID,
+		"entry":           logEntry,
+	})
+}
+
+// getOwnedLogEntry loads a log entry and verifies it belongs to the user.
+// The returned status code is meant to be passed straight to the client.
+func (h *PuzzleHub) getOwnedLogEntry(userID, entryID string) (*LogEntry, int, error) {
+	if entryID == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("Entry ID is required")
+	}
+
+	entry, err := h.Store.GetLogEntry(entryID)
+	if err != nil {
+		log.Printf("Error getting log entry: %v", err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to fetch entry")
+	}
+
+	if entry == nil {
+		return nil, http.StatusNotFound, fmt.Errorf("Log entry not found")
+	}
+
+	if entry.UserID != userID {
+		return nil, http.StatusForbidden, fmt.Errorf("Access denied")
+	}
+
+	return entry, http.StatusOK, nil
+}
+
+// validateLogEntryValues checks multi-value fields and normalizes them in
+// place to a deduplicated []interface{} of trimmed strings.
+func validateLogEntryValues(fields []LogField, values map[string]interface{}) error {
+	for _, field := range fields {
+		if field.FieldType != FieldTypeMultiselect && field.FieldType != FieldTypeTags {
+			continue
+		}
+
+		raw, exists := values[field.FieldName]
+		if !exists || raw == nil {
+			if field.Required {
+				return fmt.Errorf("Field %s is required", field.FieldName)
+			}
 			continue
 		}
-		logEntries = append(logEntries, entry)
-	}
 
-	// If a specific log type was requested, also return the log type info
-	var logType *LogType
-	if logTypeId != "" {
-		logTypeResult, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
-			TableName: aws.String("puzzle-hub-log-types"),
-			Key: map[string]*dynamodb.AttributeValue{
-				"id": {
-					S: aws.String(logTypeId),
-				},
-			},
-		})
-		if err == nil && logTypeResult.Item != nil {
-			var lt LogType
-			if dynamodbattribute.UnmarshalMap(logTypeResult.Item, &lt) == nil && lt.UserID == userObj.ID {
-				logType = &lt
+		items, err := toStringSlice(raw)
+		if err != nil {
+			return fmt.Errorf("Field %s must be a list of strings", field.FieldName)
+		}
+
+		if field.Required && len(items) == 0 {
+			return fmt.Errorf("Field %s requires at least one value", field.FieldName)
+		}
+
+		var allowed map[string]bool
+		if field.FieldType == FieldTypeMultiselect {
+			allowed = make(map[string]bool)
+			for _, option := range parseFieldOptions(field.Options) {
+				allowed[option] = true
+			}
+		}
+
+		seen := make(map[string]bool)
+		normalized := []interface{}{}
+		for _, item := range items {
+			if field.FieldType == FieldTypeTags {
+				item = strings.ToLower(item)
 			}
+			if item == "" || seen[item] {
+				continue
+			}
+			if allowed != nil && !allowed[item] {
+				return fmt.Errorf("Invalid option %q for field %s", item, field.FieldName)
+			}
+			seen[item] = true
+			normalized = append(normalized, item)
 		}
-	}
 
-	response := gin.H{"log_entries": logEntries}
-	if logType != nil {
-		response["log_type"] = logType
+		values[field.FieldName] = normalized
 	}
 
-	c.JSON(http.StatusOK, response)
+	return nil
 }
 
-func (h *PuzzleHub) createLogEntry(c *gin.Context) {
-	user, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		return
+// toStringSlice accepts a JSON array of strings or a single comma-separated
+// string and returns the trimmed items.
+func toStringSlice(value interface{}) ([]string, error) {
+	var items []string
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("non-string item %v", item)
+			}
+			items = append(items, strings.TrimSpace(str))
+		}
+	case []string:
+		for _, item := range v {
+			items = append(items, strings.TrimSpace(item))
+		}
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
 	}
-	userObj := user.(*User)
 
-	var request CreateLogEntryRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+	return items, nil
+}
 
-	// Validate entry date format
-	_, err := time.Parse("2006-01-02", request.EntryDate)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
-		return
+// parseFieldOptions reads field options stored as a JSON array, one option
+// per line (as the UI saves them), or a comma-separated list (as the AI
+// suggestions return them).
+func parseFieldOptions(options string) []string {
+	options = strings.TrimSpace(options)
+	if options == "" {
+		return nil
 	}
 
-	// Generate unique ID for log entry
-	entryID := fmt.Sprintf("le_%d", time.Now().UnixNano())
-
-	// Create log entry
-	logEntry := LogEntry{
-		ID:        entryID,
-		LogTypeID: request.LogTypeID,
-		UserID:    userObj.ID,
-		EntryDate: request.EntryDate,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		Values:    request.Values,
+	var parsed []string
+	if strings.HasPrefix(options, "[") && json.Unmarshal([]byte(options), &parsed) == nil {
+		return parsed
 	}
 
-	// Marshal log entry to DynamoDB format
-	entryItem, err := dynamodbattribute.MarshalMap(logEntry)
-	if err != nil {
-		log.Printf("Error marshaling log entry: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create log entry"})
-		return
+	separator := ","
+	if strings.Contains(options, "\n") {
+		separator = "\n"
 	}
 
-	// Put log entry in DynamoDB
-	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String("puzzle-hub-log-entries"),
-		Item:      entryItem,
-	})
-	if err != nil {
-		log.Printf("Error putting log entry: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create log entry"})
-		return
+	for _, option := range strings.Split(options, separator) {
+		if option = strings.TrimSpace(option); option != "" {
+			parsed = append(parsed, option)
+		}
 	}
-
-	c.JSON(http.StatusCreated, gin.H{
-		"message":  "Log entry created successfully",
-		"entry_id": entryID,
-	})
+	return parsed
 }
 
 func (h *PuzzleHub) updateLogEntry(c *gin.Context) {
 	// Implementation for updating log entries
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented yet"})
+	respondError(c, http.StatusNotImplemented, "Not implemented yet")
 }
 
 func (h *PuzzleHub) deleteLogEntry(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 	userObj := user.(*User)
 
 	entryId := c.Param("id")
 	if entryId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Entry ID is required"})
+		respondError(c, http.StatusBadRequest, "Entry ID is required")
 		return
 	}
 
 	// First, get the entry to verify ownership
-	getResult, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("puzzle-hub-log-entries"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(entryId),
-			},
-		},
-	})
+	entry, err := h.Store.GetLogEntry(entryId)
 	if err != nil {
-		log.Printf("Error getting log entry for deletion: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify entry"})
-		return
-	}
-
-	if getResult.Item == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Log entry not found"})
+		requestLogger(c).Error("error getting log entry for deletion", "error", err)
+		respondStorageError(c, err, "Failed to verify entry")
 		return
 	}
 
-	// Unmarshal to verify ownership
-	var entry LogEntry
-	err = dynamodbattribute.UnmarshalMap(getResult.Item, &entry)
-	if err != nil {
-		log.Printf("Error unmarshaling log entry: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse entry"})
+	if entry == nil {
+		respondError(c, http.StatusNotFound, "Log entry not found")
 		return
 	}
 
-	// Verify ownership
+	// Verify ownership; the owner of a shared log type can also remove
+	// other members' entries
 	if entry.UserID != userObj.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
+		if _, status, err := h.getOwnedLogType(userObj.ID, entry.LogTypeID); err != nil {
+			if status == http.StatusNotFound {
+				status = http.StatusForbidden
+			}
+			respondError(c, status, "Access denied")
+			return
+		}
 	}
 
 	// Delete the entry
-	_, err = h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String("puzzle-hub-log-entries"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(entryId),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("Error deleting log entry: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete entry"})
+	if err := h.Store.DeleteLogEntry(entryId); err != nil {
+		requestLogger(c).Error("error deleting log entry", "error", err)
+		respondStorageError(c, err, "Failed to delete entry")
 		return
 	}
 
+	h.recordSyncChange(entry.UserID, syncOpDelete, entryId, entry.Version+1, nil)
+	h.adjustLogAggregates(entry, -1)
+
 	log.Printf("Log entry %s deleted successfully by user %s", entryId, userObj.ID)
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Log entry deleted successfully",
@@ -3033,66 +5510,34 @@ func (h *PuzzleHub) deleteLogEntry(c *gin.Context) {
 func (h *PuzzleHub) getLogAnalytics(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 	userObj := user.(*User)
 
 	// Get all log types for the user
-	logTypesResult, err := h.DynamoDB.Query(&dynamodb.QueryInput{
-		TableName:              aws.String("puzzle-hub-log-types"),
-		IndexName:              aws.String("user-id-index"),
-		KeyConditionExpression: aws.String("user_id = :user_id"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":user_id": {
-				S: aws.String(userObj.ID),
-			},
-		},
-	})
+	logTypes, err := h.Store.ListLogTypes(userObj.ID)
 	if err != nil {
-		log.Printf("Error querying log types for analytics: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch analytics"})
+		requestLogger(c).Error("error querying log types for analytics", "error", err)
+		respondStorageError(c, err, "Failed to fetch analytics")
 		return
 	}
 
 	var analytics []LogAnalytics
 	totalEntries := 0
+	loc := userLocation(userObj)
 
-	for _, item := range logTypesResult.Items {
-		var logType LogType
-		err := dynamodbattribute.UnmarshalMap(item, &logType)
-		if err != nil {
-			log.Printf("Error unmarshaling log type: %v", err)
-			continue
-		}
-
-		// Get entries for this log type
-		entriesResult, err := h.DynamoDB.Query(&dynamodb.QueryInput{
-			TableName:              aws.String("puzzle-hub-log-entries"),
-			IndexName:              aws.String("user-date-index"),
-			KeyConditionExpression: aws.String("user_id = :user_id"),
-			FilterExpression:       aws.String("log_type_id = :log_type_id"),
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":user_id": {
-					S: aws.String(userObj.ID),
-				},
-				":log_type_id": {
-					S: aws.String(logType.ID),
-				},
-			},
-		})
+	for _, logType := range logTypes {
+		// Counters are served from the materialized aggregates
+		aggregates, err := h.getLogAggregates(userObj.ID, logType.ID, recentAggregatesFrom(loc))
 		if err != nil {
-			log.Printf("Error querying entries for log type %s: %v", logType.ID, err)
+			requestLogger(c).Error("error reading aggregates for log type", "error", err, "log_type_id", logType.ID)
 			continue
 		}
 
-		entryCount := len(entriesResult.Items)
+		entryCount, thisMonth, thisWeek, monthlyData := summarizeLogAggregates(aggregates, loc, nil)
 		totalEntries += entryCount
 
-		// Calculate monthly data and other analytics
-		monthlyData := h.calculateMonthlyData(entriesResult.Items)
-		thisMonth, thisWeek := h.calculateRecentActivity(entriesResult.Items)
-
 		analytics = append(analytics, LogAnalytics{
 			LogTypeID:     logType.ID,
 			LogTypeName:   logType.Name,
@@ -3107,193 +5552,398 @@ func (h *PuzzleHub) getLogAnalytics(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"analytics":       analytics,
 		"total_entries":   totalEntries,
-		"total_log_types": len(logTypesResult.Items),
+		"total_log_types": len(logTypes),
 	})
 }
 
 func (h *PuzzleHub) getLogTypeAnalytics(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		respondError(c, http.StatusUnauthorized, "User not found")
 		return
 	}
 	userObj := user.(*User)
 
 	logTypeId := c.Param("logTypeId")
 	if logTypeId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Log type ID is required"})
+		respondError(c, http.StatusBadRequest, "Log type ID is required")
 		return
 	}
 
-	// Get the log type
-	logTypeResult, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String("puzzle-hub-log-types"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(logTypeId),
-			},
-		},
-	})
+	// The log type must be the user's or shared with their household
+	logType, status, err := h.getSharedLogType(userObj.ID, logTypeId)
 	if err != nil {
-		log.Printf("Error getting log type: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch log type"})
+		respondError(c, status, err.Error())
 		return
 	}
 
-	if logTypeResult.Item == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Log type not found"})
+	// Recent entries for per-entry detail, or one member's with ?member=
+	member := c.Query("member")
+	loc := userLocation(userObj)
+	entries, err := h.listLogTypeEntries(logType, LogEntryQuery{UserID: member, From: analyticsDetailFrom(loc)})
+	if err != nil {
+		requestLogger(c).Error("error querying entries", "error", err)
+		respondStorageError(c, err, "Failed to fetch entries")
 		return
 	}
 
-	var logType LogType
-	err = dynamodbattribute.UnmarshalMap(logTypeResult.Item, &logType)
-	if err != nil {
-		log.Printf("Error unmarshaling log type: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse log type"})
-		return
+	// Fields live in their own table
+	if fields, err := h.getLogFields(logType.ID); err == nil {
+		logType.Fields = fields
+	} else {
+		requestLogger(c).Error("error querying log fields for analytics", "error", err)
 	}
 
-	// Verify ownership
-	if logType.UserID != userObj.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
+	numericFields := make(map[string]bool)
+	for _, field := range logType.Fields {
+		if field.FieldType == FieldTypeNumber {
+			numericFields[field.FieldName] = true
+		}
 	}
 
-	// Get all entries for this log type
-	entriesResult, err := h.DynamoDB.Query(&dynamodb.QueryInput{
-		TableName:              aws.String("puzzle-hub-log-entries"),
-		IndexName:              aws.String("user-date-index"),
-		KeyConditionExpression: aws.String("user_id = :user_id"),
-		FilterExpression:       aws.String("log_type_id = :log_type_id"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":user_id": {
-				S: aws.String(userObj.ID),
-			},
-			":log_type_id": {
-				S: aws.String(logTypeId),
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("Error querying entries: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch entries"})
+	// Counts, sums and daily activity come from the materialized aggregates
+	// (see aggregates.go), the rest of the detail from the recent entries
+	var aggregates map[string]LogAggregate
+	if member != "" {
+		// The stored aggregates cover every member, so one member's are
+		// counted from their entries
+		memberEntries, err := h.listLogTypeEntries(logType, LogEntryQuery{UserID: member})
+		if err != nil {
+			requestLogger(c).Error("error querying entries", "error", err)
+			respondStorageError(c, err, "Failed to fetch entries")
+			return
+		}
+		aggregates = make(map[string]LogAggregate)
+		for bucket, agg := range bucketLogEntries(memberEntries) {
+			aggregates[bucket] = *agg
+		}
+	} else if aggregates, err = h.getLogAggregates(logType.UserID, logType.ID, ""); err != nil {
+		requestLogger(c).Error("error reading aggregates", "error", err)
+		respondStorageError(c, err, "Failed to fetch analytics")
 		return
 	}
-
-	// Calculate detailed analytics
-	monthlyData := h.calculateMonthlyData(entriesResult.Items)
-	thisMonth, thisWeek := h.calculateRecentActivity(entriesResult.Items)
-	dailyActivity := h.calculateDailyActivity(entriesResult.Items)
-	fieldAnalytics := h.calculateFieldAnalytics(entriesResult.Items, logType.Fields)
+	totalCount, thisMonth, thisWeek, monthlyData := summarizeLogAggregates(aggregates, loc, numericFields)
+	dailyActivity := dailyActivityFromAggregates(aggregates, entries, numericFields)
+	fieldAnalytics := h.calculateFieldAnalytics(entries, logType.Fields)
+	applyFieldTotals(fieldAnalytics, aggregates[aggregateTotalBucket], numericFields)
 
 	analytics := LogAnalytics{
 		LogTypeID:     logType.ID,
 		LogTypeName:   logType.Name,
-		TotalEntries:  len(entriesResult.Items),
+		TotalEntries:  totalCount,
 		ThisMonth:     thisMonth,
 		ThisWeek:      thisWeek,
 		DailyActivity: dailyActivity,
 		MonthlyTrend:  monthlyData,
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"analytics":       analytics,
 		"field_analytics": fieldAnalytics,
 		"log_type":        logType,
-	})
+	}
+	if logType.HouseholdID != "" {
+		memberEntries := make(map[string]int)
+		for _, entry := range entries {
+			memberEntries[entry.UserID]++
+		}
+		response["member_entries"] = memberEntries
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-// Helper functions for analytics calculations
-func (h *PuzzleHub) calculateMonthlyData(items []map[string]*dynamodb.AttributeValue) []MonthlyData {
-	monthCounts := make(map[string]int)
+// getLogHeatmap returns per-day entry counts for a calendar year across all
+// log types (or a single one via log_type_id), GitHub contribution style.
+func (h *PuzzleHub) getLogHeatmap(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
 
-	for _, item := range items {
-		var entry LogEntry
-		err := dynamodbattribute.UnmarshalMap(item, &entry)
-		if err != nil {
-			continue
+	year := time.Now().In(userLocation(userObj)).Year()
+	if yearParam := c.Query("year"); yearParam != "" {
+		parsed, err := strconv.Atoi(yearParam)
+		if err != nil || parsed < 1970 || parsed > 9999 {
+			respondError(c, http.StatusBadRequest, "Invalid year")
+			return
+		}
+		year = parsed
+	}
+	logTypeId := c.Query("log_type_id")
+
+	startDate := fmt.Sprintf("%04d-01-01", year)
+	endDate := fmt.Sprintf("%04d-12-31", year)
+
+	query := LogEntryQuery{
+		UserID:    userObj.ID,
+		LogTypeID: logTypeId,
+		From:      startDate,
+		To:        endDate,
+	}
+	var entries []LogEntry
+	var err error
+	if logTypeId != "" {
+		// A single log type may be shared, so it counts every member (or
+		// the one in ?member=)
+		logType, status, accessErr := h.getSharedLogType(userObj.ID, logTypeId)
+		if accessErr != nil {
+			respondError(c, status, accessErr.Error())
+			return
 		}
+		query.UserID = c.Query("member")
+		entries, err = h.listLogTypeEntries(logType, query)
+	} else {
+		entries, err = h.Store.ListLogEntries(query)
+	}
+	if err != nil {
+		requestLogger(c).Error("error querying entries for heatmap", "error", err)
+		respondStorageError(c, err, "Failed to fetch heatmap data")
+		return
+	}
+
+	dayCounts := make(map[string]int)
+	for _, entry := range entries {
+		dayCounts[entry.EntryDate]++
+	}
 
-		// Parse date and get month
-		if date, err := time.Parse("2006-01-02", entry.EntryDate); err == nil {
-			monthKey := date.Format("2006-01")
-			monthCounts[monthKey]++
+	maxCount := 0
+	totalEntries := 0
+	for _, count := range dayCounts {
+		totalEntries += count
+		if count > maxCount {
+			maxCount = count
 		}
 	}
 
-	var monthlyData []MonthlyData
-	for month, count := range monthCounts {
-		monthlyData = append(monthlyData, MonthlyData{
-			Month:   month,
-			Count:   count,
-			Summary: nil, // Can be enhanced with field-specific summaries
+	// Emit every day of the year so the client can lay out the grid directly
+	var days []HeatmapDay
+	activeDays, currentStreak, longestStreak := 0, 0, 0
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for day := first; day.Year() == year; day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		count := dayCounts[date]
+
+		if count > 0 {
+			activeDays++
+			currentStreak++
+			if currentStreak > longestStreak {
+				longestStreak = currentStreak
+			}
+		} else {
+			currentStreak = 0
+		}
+
+		days = append(days, HeatmapDay{
+			Date:  date,
+			Count: count,
+			Level: heatmapLevel(count, maxCount),
 		})
 	}
 
-	return monthlyData
+	c.JSON(http.StatusOK, gin.H{
+		"year":           year,
+		"days":           days,
+		"total_entries":  totalEntries,
+		"active_days":    activeDays,
+		"max_count":      maxCount,
+		"longest_streak": longestStreak,
+	})
 }
 
-func (h *PuzzleHub) calculateRecentActivity(items []map[string]*dynamodb.AttributeValue) (int, int) {
-	now := time.Now()
-	thisMonth := 0
-	thisWeek := 0
+// getLogCorrelation correlates a numeric field of one log type with a numeric
+// field of another over the dates where both were logged.
+func (h *PuzzleHub) getLogCorrelation(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
 
-	for _, item := range items {
-		var entry LogEntry
-		err := dynamodbattribute.UnmarshalMap(item, &entry)
-		if err != nil {
-			continue
+	xLogTypeID, xField := c.Query("x_log_type_id"), c.Query("x_field")
+	yLogTypeID, yField := c.Query("y_log_type_id"), c.Query("y_field")
+	if xLogTypeID == "" || xField == "" || yLogTypeID == "" || yField == "" {
+		respondError(c, http.StatusBadRequest, "x_log_type_id, x_field, y_log_type_id and y_field are required")
+		return
+	}
+
+	aggregate := c.DefaultQuery("aggregate", "sum")
+	if aggregate != "sum" && aggregate != "avg" && aggregate != "max" {
+		respondError(c, http.StatusBadRequest, "aggregate must be one of sum, avg, max")
+		return
+	}
+
+	for _, logTypeID := range []string{xLogTypeID, yLogTypeID} {
+		if _, status, err := h.getOwnedLogType(userObj.ID, logTypeID); err != nil {
+			respondError(c, status, err.Error())
+			return
 		}
+	}
 
-		if date, err := time.Parse("2006-01-02", entry.EntryDate); err == nil {
-			// This month
-			if date.Year() == now.Year() && date.Month() == now.Month() {
-				thisMonth++
-			}
+	xDaily, err := h.dailyFieldValues(userObj.ID, xLogTypeID, xField, aggregate)
+	if err != nil {
+		requestLogger(c).Error("error querying entries for correlation", "error", err)
+		respondStorageError(c, err, "Failed to fetch entries")
+		return
+	}
+	yDaily, err := h.dailyFieldValues(userObj.ID, yLogTypeID, yField, aggregate)
+	if err != nil {
+		requestLogger(c).Error("error querying entries for correlation", "error", err)
+		respondStorageError(c, err, "Failed to fetch entries")
+		return
+	}
 
-			// This week (last 7 days)
-			if now.Sub(date).Hours() <= 7*24 {
-				thisWeek++
-			}
+	points := []CorrelationPoint{}
+	for date, x := range xDaily {
+		if y, ok := yDaily[date]; ok {
+			points = append(points, CorrelationPoint{Date: date, X: x, Y: y})
 		}
 	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Date < points[j].Date
+	})
+
+	result := CorrelationResult{
+		XLogTypeID: xLogTypeID,
+		XField:     xField,
+		YLogTypeID: yLogTypeID,
+		YField:     yField,
+		Aggregate:  aggregate,
+		PairedDays: len(points),
+		Points:     points,
+		Strength:   "insufficient data",
+	}
+	if r, ok := pearsonCorrelation(points); ok {
+		result.Coefficient = &r
+		result.Strength = describeCorrelation(r)
+	}
 
-	return thisMonth, thisWeek
+	c.JSON(http.StatusOK, gin.H{"correlation": result})
 }
 
-func (h *PuzzleHub) calculateDailyActivity(items []map[string]*dynamodb.AttributeValue) map[string]interface{} {
-	dailyActivity := make(map[string]interface{})
+// dailyFieldValues aggregates a numeric field per entry date for one log type
+func (h *PuzzleHub) dailyFieldValues(userID, logTypeID, fieldName, aggregate string) (map[string]float64, error) {
+	entries, err := h.getUserLogEntries(userID, logTypeID)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, item := range items {
-		var entry LogEntry
-		err := dynamodbattribute.UnmarshalMap(item, &entry)
-		if err != nil {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	maxes := make(map[string]float64)
+	for _, entry := range entries {
+		value, ok := numericValue(entry.Values[fieldName])
+		if !ok {
 			continue
 		}
-
-		if _, exists := dailyActivity[entry.EntryDate]; !exists {
-			dailyActivity[entry.EntryDate] = map[string]interface{}{
-				"count":   0,
-				"entries": []map[string]interface{}{},
-			}
+		if counts[entry.EntryDate] == 0 || value > maxes[entry.EntryDate] {
+			maxes[entry.EntryDate] = value
 		}
+		sums[entry.EntryDate] += value
+		counts[entry.EntryDate]++
+	}
+
+	daily := make(map[string]float64, len(sums))
+	for date, sum := range sums {
+		switch aggregate {
+		case "avg":
+			daily[date] = sum / float64(counts[date])
+		case "max":
+			daily[date] = maxes[date]
+		default:
+			daily[date] = sum
+		}
+	}
+	return daily, nil
+}
 
-		dayData := dailyActivity[entry.EntryDate].(map[string]interface{})
-		dayData["count"] = dayData["count"].(int) + 1
+// getUserLogEntries returns every entry a user logged for one log type
+func (h *PuzzleHub) getUserLogEntries(userID, logTypeID string) ([]LogEntry, error) {
+	return h.Store.ListLogEntries(LogEntryQuery{UserID: userID, LogTypeID: logTypeID})
+}
 
-		entryData := map[string]interface{}{
-			"id":     entry.ID,
-			"values": entry.Values,
+// numericValue extracts a number from an entry value. The web UI submits
+// form inputs as strings, so numeric strings are accepted too.
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+			return 0, false
 		}
-		dayData["entries"] = append(dayData["entries"].([]map[string]interface{}), entryData)
+		return parsed, true
+	default:
+		return 0, false
+	}
+}
+
+// pearsonCorrelation returns Pearson's r for the paired points. It needs at
+// least three points and non-zero variance on both axes.
+func pearsonCorrelation(points []CorrelationPoint) (float64, bool) {
+	n := float64(len(points))
+	if len(points) < 3 {
+		return 0, false
+	}
+
+	var sumX, sumY float64
+	for _, p := range points {
+		sumX += p.X
+		sumY += p.Y
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var covariance, varianceX, varianceY float64
+	for _, p := range points {
+		dx, dy := p.X-meanX, p.Y-meanY
+		covariance += dx * dy
+		varianceX += dx * dx
+		varianceY += dy * dy
+	}
+
+	if varianceX == 0 || varianceY == 0 {
+		return 0, false
+	}
+	return covariance / math.Sqrt(varianceX*varianceY), true
+}
+
+func describeCorrelation(r float64) string {
+	direction := "positive"
+	if r < 0 {
+		direction = "negative"
+	}
 
-		dailyActivity[entry.EntryDate] = dayData
+	switch abs := math.Abs(r); {
+	case abs >= 0.7:
+		return "strong " + direction
+	case abs >= 0.4:
+		return "moderate " + direction
+	case abs >= 0.2:
+		return "weak " + direction
+	default:
+		return "none"
 	}
+}
 
-	return dailyActivity
+// heatmapLevel buckets a day's count into 0-4 relative to the busiest day
+func heatmapLevel(count, maxCount int) int {
+	if count == 0 || maxCount == 0 {
+		return 0
+	}
+	level := (count*4 + maxCount - 1) / maxCount
+	if level > 4 {
+		level = 4
+	}
+	return level
 }
 
-func (h *PuzzleHub) calculateFieldAnalytics(items []map[string]*dynamodb.AttributeValue, fields []LogField) map[string]interface{} {
+// Helper functions for analytics calculations
+func (h *PuzzleHub) calculateFieldAnalytics(entries []LogEntry, fields []LogField) map[string]interface{} {
 	fieldAnalytics := make(map[string]interface{})
 
 	for _, field := range fields {
@@ -3306,14 +5956,9 @@ func (h *PuzzleHub) calculateFieldAnalytics(items []map[string]*dynamodb.Attribu
 
 		values := []interface{}{}
 		numericValues := []float64{}
+		valueCounts := make(map[string]int)
 
-		for _, item := range items {
-			var entry LogEntry
-			err := dynamodbattribute.UnmarshalMap(item, &entry)
-			if err != nil {
-				continue
-			}
-
+		for _, entry := range entries {
 			fieldStats["total_entries"] = fieldStats["total_entries"].(int) + 1
 
 			if value, exists := entry.Values[field.FieldName]; exists && value != nil {
@@ -3326,6 +5971,17 @@ func (h *PuzzleHub) calculateFieldAnalytics(items []map[string]*dynamodb.Attribu
 						numericValues = append(numericValues, numVal)
 					}
 				}
+
+				// For multi-value fields, count how often each value is used
+				if field.FieldType == FieldTypeMultiselect || field.FieldType == FieldTypeTags {
+					if items, err := toStringSlice(value); err == nil {
+						for _, item := range items {
+							if item != "" {
+								valueCounts[item]++
+							}
+						}
+					}
+				}
 			}
 		}
 
@@ -3351,6 +6007,25 @@ func (h *PuzzleHub) calculateFieldAnalytics(items []map[string]*dynamodb.Attribu
 			fieldStats["max"] = max
 		}
 
+		if field.FieldType == FieldTypeMultiselect || field.FieldType == FieldTypeTags {
+			type valueCount struct {
+				Value string `json:"value"`
+				Count int    `json:"count"`
+			}
+			var frequencies []valueCount
+			for value, count := range valueCounts {
+				frequencies = append(frequencies, valueCount{Value: value, Count: count})
+			}
+			sort.Slice(frequencies, func(i, j int) bool {
+				if frequencies[i].Count != frequencies[j].Count {
+					return frequencies[i].Count > frequencies[j].Count
+				}
+				return frequencies[i].Value < frequencies[j].Value
+			})
+			fieldStats["value_frequencies"] = frequencies
+			fieldStats["distinct_values"] = len(frequencies)
+		}
+
 		fieldStats["sample_values"] = values
 		fieldAnalytics[field.FieldName] = fieldStats
 	}
@@ -3359,19 +6034,19 @@ func (h *PuzzleHub) calculateFieldAnalytics(items []map[string]*dynamodb.Attribu
 }
 
 func main() {
-	if err := godotenv.Load(); err != nil {
+	envErr := godotenv.Load()
+	setupLogging()
+	if envErr != nil {
 		log.Println("No .env file found, using system environment variables")
 	}
+	configureDemoMode()
 
-	// Start periodic analytics reporting (every hour)
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		for range ticker.C {
-			log.Println("⏰ HOURLY ANALYTICS REPORT:")
-			logAnalytics()
-		}
-	}()
+	// Export traces when an OTLP endpoint is configured, see tracing.go
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Printf("⚠️  Tracing disabled: %v", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	}
 
 	provider := os.Getenv("AI_PROVIDER")
 	if provider == "" {
@@ -3385,11 +6060,74 @@ func main() {
 	}
 
 	// Load analytics from DynamoDB
-	if err := loadAnalyticsFromDB(hub.DynamoDB); err != nil {
+	if err := hub.Analytics.Load(); err != nil {
 		log.Printf("⚠️  Warning: Failed to load analytics from DynamoDB: %v", err)
 		log.Println("📊 Starting with fresh analytics counters")
 	}
 
+	// Load admin-published prompt templates, built-ins are used otherwise
+	if err := hub.Prompts.Load(); err != nil {
+		log.Printf("⚠️  Warning: %v", err)
+	}
+
+	// Start periodic analytics reporting (every hour) and the event writer
+	go hub.Analytics.runAnalyticsReport(1 * time.Hour)
+	go hub.Analytics.runEventWriter(5 * time.Second)
+
+	// Pick up JWT key rotations made in Secrets Manager
+	go hub.AuthConfig.JWTKeys.runJWTKeyRefresh(10 * time.Minute)
+
+	// Pick up prompt templates published on other instances
+	go hub.Prompts.runPromptRefresh(1 * time.Minute)
+
+	// Archive log entries past their log type's retention period (daily)
+	if hub.ArchiveBucket != "" {
+		hub.Jobs.Schedule(ScheduledJob{Name: "retention-archiver", Spec: "30 3 * * *", Run: hub.archiveExpiredEntries})
+	} else {
+		log.Println("🗄️  ARCHIVE_S3_BUCKET not set, log retention archival disabled")
+	}
+
+	// Export yesterday's anonymized analytics events to S3 (checked every 6 hours)
+	if hub.AnalyticsExportBucket != "" {
+		hub.Jobs.Schedule(ScheduledJob{Name: "analytics-export", Spec: "15 */6 * * *", RunOnStart: true, Run: hub.exportYesterdaysEvents})
+	} else {
+		log.Println("📤 ANALYTICS_EXPORT_S3_BUCKET not set, analytics export disabled")
+	}
+
+	// Email admins a summary of last week's feedback (checked every 6 hours)
+	if hub.DigestFromEmail != "" {
+		hub.Jobs.Schedule(ScheduledJob{Name: "feedback-digest", Spec: "0 */6 * * *", RunOnStart: true, Run: hub.sendWeeklyFeedbackDigest})
+	} else {
+		log.Println("📬 FEEDBACK_DIGEST_FROM not set, weekly feedback digest disabled")
+	}
+
+	// Email parents a summary of their children's last week (checked every 6 hours)
+	if hub.ParentReportFromEmail != "" {
+		hub.Jobs.Schedule(ScheduledJob{Name: "parent-reports", Spec: "30 */6 * * *", RunOnStart: true, Run: hub.sendWeeklyParentReports})
+		hub.Jobs.Handle(parentReportJob, hub.sendParentReport)
+	} else {
+		log.Println("📬 PARENT_REPORT_FROM not set, weekly parent reports disabled")
+	}
+
+	// Snapshot every log type's last week and raise trend alerts (checked every 6 hours), see log_alerts.go
+	hub.Jobs.Schedule(ScheduledJob{Name: "log-snapshots", Spec: "45 */6 * * *", RunOnStart: true, Run: hub.snapshotAllLogs})
+	hub.Jobs.Handle(logSnapshotJob, hub.snapshotUserLogs)
+
+	// Email copies of notifications, see notifications.go
+	hub.Jobs.Handle(notificationEmailJob, hub.sendNotificationEmail)
+
+	// Push results to linked LMSs, see lms.go
+	hub.Jobs.Handle(lmsPushJob, hub.deliverLMSEvent)
+	hub.Jobs.Handle(resultsWebhookJob, hub.deliverResultsWebhook)
+
+	// Build account exports users ask for, see account_export.go
+	hub.Jobs.Handle(accountExportJob, hub.runAccountExport)
+
+	// Scheduled jobs and the async job queue, see jobs.go
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	hub.Jobs.Run(jobsCtx)
+
 	r := setupRoutes(hub)
 
 	port := os.Getenv("PORT")
@@ -3397,11 +6135,41 @@ func main() {
 		port = "8080"
 	}
 
-	fmt.Printf("🎮 Puzzle Hub starting on port %s\n", port)
-	fmt.Printf("Using %s as AI provider\n", provider)
+	fmt.Print(consoleText(fmt.Sprintf("🎮 Puzzle Hub starting on port %s\n", port)))
+	if demoMode {
+		fmt.Println("Running in demo mode, no AI provider")
+	} else {
+		fmt.Printf("Using %s as AI provider\n", provider)
+	}
 	fmt.Printf("Visit http://localhost:%s to choose your puzzle!\n", port)
 
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Shut down gracefully so in-flight requests finish and queued
+	// analytics events get written
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Println("🛑 Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("⚠️  Server shutdown: %v", err)
+	}
+	stopJobs()
+	if err := hub.Analytics.Close(shutdownCtx); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("⚠️  Flushing traces: %v", err)
 	}
 }
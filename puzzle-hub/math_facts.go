@@ -0,0 +1,559 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Multiplication tables trainer
+//
+// A drill is a timed run of multiplication and/or division facts from the
+// chosen tables (2s through 12s, each fact up to ×12). Like Yohaku game
+// sessions, drills are generated and handed to the client, which times
+// them; facts are checked from their IDs ("7x8", "56/8") on submit, so no
+// session state is kept on the server.
+//
+// For signed-in players each fact has a mastery box (0-5, a Leitner box):
+// a fast correct answer moves it up one box, a slow one leaves it, a wrong
+// one sends it back to 0. Drills pick facts in low boxes more often and
+// repeat the weakest ones later in the same drill. Facts in box 4 or above
+// count as mastered. Finished drills are recorded as "math_facts" progress,
+// and missed facts go to the player's flashcards.
+
+const (
+	minFactTable       = 2
+	maxFactTable       = 12
+	maxFactFactor      = 12
+	defaultDrillLength = 20
+	maxDrillLength     = 60
+	defaultDrillTimer  = 120 // seconds
+	maxDrillTimer      = 15 * 60
+	fastAnswerMillis   = 3000
+	masteredFactBox    = 4
+	maxFactBox         = 5
+	maxDrillRepeats    = 3 // Weak facts asked a second time per drill
+)
+
+var factOperations = map[string]bool{
+	"multiplication": true,
+	"division":       true,
+	"mixed":          true,
+}
+
+// factBoxWeights makes facts in low boxes come up more often; new facts
+// (no record yet) count as box 1
+var factBoxWeights = [maxFactBox + 1]float64{4, 3, 2, 1.5, 0.75, 0.5}
+
+type FactDrillSettings struct {
+	Tables        []int  `json:"tables"`        // Default 2-12
+	Operation     string `json:"operation"`     // multiplication, division or mixed
+	Count         int    `json:"count"`         // Facts per drill, default 20
+	TimerDuration int    `json:"timerDuration"` // Seconds, default 120
+}
+
+type MathFact struct {
+	ID        string `json:"id"` // 7x8 or 56/8
+	Prompt    string `json:"prompt"`
+	Operation string `json:"operation"`
+	Table     int    `json:"table"`
+	Repeat    bool   `json:"repeat,omitempty"` // Second ask of a weak fact
+}
+
+type FactDrillSession struct {
+	ID            string            `json:"id"`
+	Facts         []MathFact        `json:"facts"`
+	TimerDuration int               `json:"timerDuration"`
+	StartTime     time.Time         `json:"startTime"`
+	Settings      FactDrillSettings `json:"settings"`
+	Adaptive      bool              `json:"adaptive"` // Picked from the player's mastery
+}
+
+type FactAnswer struct {
+	FactID string `json:"fact_id" binding:"required"`
+	Answer *int   `json:"answer"` // nil when skipped or out of time
+	Millis int    `json:"ms"`     // Time taken to answer
+}
+
+type SubmitFactDrillRequest struct {
+	SessionID       string       `json:"session_id"`
+	Answers         []FactAnswer `json:"answers" binding:"required"`
+	DurationSeconds int          `json:"duration_seconds"`
+}
+
+type FactMastery struct {
+	UserID        string    `json:"-" dynamodbav:"user_id"`
+	FactID        string    `json:"fact_id" dynamodbav:"fact_id"`
+	Box           int       `json:"box" dynamodbav:"box"`
+	Attempts      int       `json:"attempts" dynamodbav:"attempts"`
+	Correct       int       `json:"correct" dynamodbav:"correct"`
+	AverageMillis int       `json:"average_ms" dynamodbav:"average_ms"`
+	LastSeenAt    time.Time `json:"last_seen_at" dynamodbav:"last_seen_at"`
+}
+
+type FactResult struct {
+	FactID  string `json:"fact_id"`
+	Prompt  string `json:"prompt"`
+	Answer  *int   `json:"answer"`
+	Correct int    `json:"correct_answer"`
+	Right   bool   `json:"right"`
+	Millis  int    `json:"ms"`
+}
+
+type FactDrillSummary struct {
+	SessionID     string       `json:"session_id,omitempty"`
+	Total         int          `json:"total"`
+	Correct       int          `json:"correct"`
+	Accuracy      float64      `json:"accuracy"` // Percent
+	AverageMillis int          `json:"average_ms"`
+	Score         int          `json:"score"`
+	MaxScore      int          `json:"max_score"`
+	Missed        []FactResult `json:"missed"`
+	Slowest       []FactResult `json:"slowest"`
+	NewlyMastered []string     `json:"newly_mastered,omitempty"`
+	Results       []FactResult `json:"results"`
+}
+
+// parseFact works out a fact from its ID, rejecting facts outside the
+// tables. The returned fact has the canonical ID.
+func parseFact(id string) (fact MathFact, answer int, ok bool) {
+	if left, right, found := strings.Cut(id, "x"); found {
+		a, errA := strconv.Atoi(left)
+		b, errB := strconv.Atoi(right)
+		if errA != nil || errB != nil || a < minFactTable || a > maxFactTable || b < 1 || b > maxFactFactor {
+			return MathFact{}, 0, false
+		}
+		return MathFact{ID: fmt.Sprintf("%dx%d", a, b), Prompt: fmt.Sprintf("%d × %d", a, b), Operation: "multiplication", Table: a}, a * b, true
+	}
+	if left, right, found := strings.Cut(id, "/"); found {
+		product, errP := strconv.Atoi(left)
+		divisor, errD := strconv.Atoi(right)
+		if errP != nil || errD != nil || divisor < minFactTable || divisor > maxFactTable ||
+			product%divisor != 0 || product/divisor < 1 || product/divisor > maxFactFactor {
+			return MathFact{}, 0, false
+		}
+		return MathFact{ID: fmt.Sprintf("%d/%d", product, divisor), Prompt: fmt.Sprintf("%d ÷ %d", product, divisor), Operation: "division", Table: divisor}, product / divisor, true
+	}
+	return MathFact{}, 0, false
+}
+
+// factsForTables lists every fact the settings can ask
+func factsForTables(tables []int, operation string) []MathFact {
+	var facts []MathFact
+	for _, table := range tables {
+		for factor := 1; factor <= maxFactFactor; factor++ {
+			if operation != "division" {
+				fact, _, _ := parseFact(fmt.Sprintf("%dx%d", table, factor))
+				facts = append(facts, fact)
+			}
+			if operation != "multiplication" {
+				fact, _, _ := parseFact(fmt.Sprintf("%d/%d", table*factor, table))
+				facts = append(facts, fact)
+			}
+		}
+	}
+	return facts
+}
+
+// normalizeDrillSettings fills in defaults, returning what is wrong with
+// the settings or ""
+func normalizeDrillSettings(settings *FactDrillSettings) string {
+	if settings.Operation == "" {
+		settings.Operation = "multiplication"
+	}
+	if !factOperations[settings.Operation] {
+		return "Operation must be multiplication, division or mixed"
+	}
+	if len(settings.Tables) == 0 {
+		for table := minFactTable; table <= maxFactTable; table++ {
+			settings.Tables = append(settings.Tables, table)
+		}
+	}
+	seen := map[int]bool{}
+	tables := settings.Tables[:0]
+	for _, table := range settings.Tables {
+		if table < minFactTable || table > maxFactTable {
+			return fmt.Sprintf("Tables must be between %d and %d", minFactTable, maxFactTable)
+		}
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	sort.Ints(tables)
+	settings.Tables = tables
+
+	if settings.Count == 0 {
+		settings.Count = defaultDrillLength
+	}
+	if settings.Count < 1 || settings.Count > maxDrillLength {
+		return fmt.Sprintf("Count must be between 1 and %d", maxDrillLength)
+	}
+	if settings.TimerDuration == 0 {
+		settings.TimerDuration = defaultDrillTimer
+	}
+	if settings.TimerDuration < 10 || settings.TimerDuration > maxDrillTimer {
+		return "Timer must be between 10 and 900 seconds"
+	}
+	return ""
+}
+
+// GenerateFactDrill picks the drill's facts, favouring weak ones when the
+// player's mastery is known
+func (g *YohakuGenerator) GenerateFactDrill(settings FactDrillSettings, mastery map[string]FactMastery) FactDrillSession {
+	pool := factsForTables(settings.Tables, settings.Operation)
+	weights := make([]float64, len(pool))
+	for i, fact := range pool {
+		box := 1
+		if record, ok := mastery[fact.ID]; ok {
+			box = record.Box
+		}
+		weights[i] = factBoxWeights[box]
+	}
+
+	// Weak facts already missed come back once more later in the drill
+	var weak []MathFact
+	for _, fact := range pool {
+		if record, ok := mastery[fact.ID]; ok && record.Box == 0 && len(weak) < maxDrillRepeats {
+			weak = append(weak, fact)
+		}
+	}
+	repeats := min(len(weak), settings.Count/4)
+	unique := settings.Count - repeats
+
+	facts := make([]MathFact, 0, settings.Count)
+	picked := map[string]bool{}
+	for len(facts) < unique {
+		fact := pool[weightedIndex(g.rand, weights)]
+		// Allow repeats only once every fact in the pool has been asked
+		if picked[fact.ID] && len(picked) < len(pool) {
+			continue
+		}
+		if len(facts) > 0 && facts[len(facts)-1].ID == fact.ID && len(pool) > 1 {
+			continue
+		}
+		picked[fact.ID] = true
+		facts = append(facts, fact)
+	}
+
+	// Each repeated weak fact is asked in the first half...
+	for _, weakFact := range weak[:repeats] {
+		at := -1
+		for j, fact := range facts {
+			if fact.ID == weakFact.ID {
+				at = j
+				break
+			}
+		}
+		if at >= 0 && at < len(facts)/2 {
+			continue
+		}
+		if at >= 0 {
+			facts = append(facts[:at], facts[at+1:]...)
+		} else {
+			facts = facts[:len(facts)-1]
+		}
+		at = g.rand.Intn(len(facts)/2 + 1)
+		facts = append(facts[:at], append([]MathFact{weakFact}, facts[at:]...)...)
+	}
+
+	// ...and again three to five facts later
+	due := map[string]int{}
+	for _, weakFact := range weak[:repeats] {
+		due[weakFact.ID] = -1
+	}
+	drill := make([]MathFact, 0, settings.Count)
+	var pending []MathFact
+	emitDue := func() {
+		for len(pending) > 0 && len(drill) >= due[pending[0].ID] {
+			drill = append(drill, pending[0])
+			pending = pending[1:]
+		}
+	}
+	for _, fact := range facts {
+		emitDue()
+		drill = append(drill, fact)
+		if at, ok := due[fact.ID]; ok && at < 0 {
+			due[fact.ID] = len(drill) + 3 + g.rand.Intn(3)
+			repeat := fact
+			repeat.Repeat = true
+			pending = append(pending, repeat)
+		}
+	}
+	emitDue()
+	drill = append(drill, pending...)
+	facts = drill
+
+	return FactDrillSession{
+		ID:            fmt.Sprintf("facts_%d", time.Now().UnixNano()),
+		Facts:         facts,
+		TimerDuration: settings.TimerDuration,
+		StartTime:     time.Now(),
+		Settings:      settings,
+		Adaptive:      len(mastery) > 0,
+	}
+}
+
+func weightedIndex(r *rand.Rand, weights []float64) int {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	target := r.Float64() * total
+	for i, weight := range weights {
+		if target < weight {
+			return i
+		}
+		target -= weight
+	}
+	return len(weights) - 1
+}
+
+// startFactDrill generates a drill, adapted to the player when signed in
+func (h *PuzzleHub) startFactDrill(c *gin.Context) {
+	var settings FactDrillSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if problem := normalizeDrillSettings(&settings); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+	h.accessibilityFor(c).applyToFactDrill(&settings)
+
+	var mastery map[string]FactMastery
+	if userID := optionalUserID(c); userID != "" {
+		var err error
+		if mastery, err = h.getFactMastery(userID); err != nil {
+			// Fall back to a plain random drill
+			requestLogger(c).Error("error fetching fact mastery", "error", err)
+		}
+	}
+
+	session := h.YohakuGenerator.GenerateFactDrill(settings, mastery)
+	c.JSON(http.StatusOK, gin.H{
+		"session": session,
+		"message": tr(c, "Drill ready: %d facts in %d seconds!", len(session.Facts), session.TimerDuration),
+	})
+}
+
+// submitFactDrill marks a finished drill and, for signed-in players,
+// updates their mastery and records the result
+func (h *PuzzleHub) submitFactDrill(c *gin.Context) {
+	var request SubmitFactDrillRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if len(request.Answers) == 0 || len(request.Answers) > maxDrillLength+maxDrillRepeats {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Send between 1 and %d answers", maxDrillLength+maxDrillRepeats))
+		return
+	}
+	if request.DurationSeconds < 0 || request.DurationSeconds > 24*60*60 {
+		respondError(c, http.StatusBadRequest, "Duration must be between 0 and 86400 seconds")
+		return
+	}
+
+	summary := FactDrillSummary{SessionID: request.SessionID, Missed: []FactResult{}, Results: []FactResult{}}
+	totalMillis := 0
+	for _, answer := range request.Answers {
+		fact, correct, ok := parseFact(answer.FactID)
+		if !ok {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Unknown fact %q", answer.FactID))
+			return
+		}
+		result := FactResult{
+			FactID:  fact.ID,
+			Prompt:  fact.Prompt,
+			Answer:  answer.Answer,
+			Correct: correct,
+			Right:   answer.Answer != nil && *answer.Answer == correct,
+			Millis:  max(answer.Millis, 0),
+		}
+		summary.Results = append(summary.Results, result)
+		summary.Total++
+		totalMillis += result.Millis
+		// 10 points per right answer, 5 more when it was quick
+		summary.MaxScore += 15
+		if result.Right {
+			summary.Correct++
+			summary.Score += 10
+			if result.Millis > 0 && result.Millis <= fastAnswerMillis {
+				summary.Score += 5
+			}
+		} else {
+			summary.Missed = append(summary.Missed, result)
+		}
+	}
+	summary.Accuracy = math.Round(float64(summary.Correct)/float64(summary.Total)*1000) / 10
+	summary.AverageMillis = totalMillis / summary.Total
+
+	slowest := make([]FactResult, 0, len(summary.Results))
+	for _, result := range summary.Results {
+		if result.Right {
+			slowest = append(slowest, result)
+		}
+	}
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].Millis > slowest[j].Millis })
+	summary.Slowest = slowest[:min(3, len(slowest))]
+
+	user, signedIn := c.Get("user")
+	if !signedIn {
+		c.JSON(http.StatusOK, gin.H{"summary": summary})
+		return
+	}
+	userObj := user.(*User)
+
+	newlyMastered, err := h.updateFactMastery(userObj.ID, summary.Results)
+	if err != nil {
+		requestLogger(c).Error("error updating fact mastery", "error", err)
+		respondStorageError(c, err, "Failed to save drill")
+		return
+	}
+	summary.NewlyMastered = newlyMastered
+	h.pushFlashcards(userObj.ID, "math_facts", flashcardsForFacts(summary.Missed))
+
+	result, err := h.saveActivityResult(userObj, "math_facts", float64(summary.Score), float64(summary.MaxScore), request.DurationSeconds)
+	if err != nil {
+		requestLogger(c).Error("error saving drill result", "error", err)
+		respondStorageError(c, err, "Failed to save drill")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"summary": summary,
+		"result":  result,
+	})
+}
+
+// getFactMasteryReport shows the player's mastery for every fact they've
+// practised, grouped by table
+func (h *PuzzleHub) getFactMasteryReport(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	mastery, err := h.getFactMastery(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching fact mastery", "error", err)
+		respondStorageError(c, err, "Failed to fetch mastery")
+		return
+	}
+
+	type tableReport struct {
+		Table     int           `json:"table"`
+		Mastered  int           `json:"mastered"`
+		Practised int           `json:"practised"`
+		Facts     []FactMastery `json:"facts"`
+	}
+	byTable := map[int]*tableReport{}
+	for _, record := range mastery {
+		fact, _, ok := parseFact(record.FactID)
+		if !ok {
+			continue
+		}
+		report, exists := byTable[fact.Table]
+		if !exists {
+			report = &tableReport{Table: fact.Table}
+			byTable[fact.Table] = report
+		}
+		report.Practised++
+		if record.Box >= masteredFactBox {
+			report.Mastered++
+		}
+		report.Facts = append(report.Facts, record)
+	}
+
+	tables := make([]tableReport, 0, len(byTable))
+	for _, report := range byTable {
+		sort.Slice(report.Facts, func(i, j int) bool { return report.Facts[i].FactID < report.Facts[j].FactID })
+		tables = append(tables, *report)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Table < tables[j].Table })
+
+	c.JSON(http.StatusOK, gin.H{
+		"tables":      tables,
+		"mastered_at": masteredFactBox,
+	})
+}
+
+// getFactMastery returns the player's records keyed by fact ID
+func (h *PuzzleHub) getFactMastery(userID string) (map[string]FactMastery, error) {
+	records, err := queryAll[FactMastery](h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-fact-mastery")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	mastery := make(map[string]FactMastery, len(records))
+	for _, record := range records {
+		mastery[record.FactID] = record
+	}
+	return mastery, nil
+}
+
+// updateFactMastery moves each answered fact between boxes and returns the
+// facts that became mastered
+func (h *PuzzleHub) updateFactMastery(userID string, results []FactResult) ([]string, error) {
+	mastery, err := h.getFactMastery(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	changed := map[string]FactMastery{}
+	for _, result := range results {
+		record, ok := changed[result.FactID]
+		if !ok {
+			record, ok = mastery[result.FactID]
+			if !ok {
+				record = FactMastery{UserID: userID, FactID: result.FactID, Box: 1}
+			}
+		}
+		switch {
+		case !result.Right:
+			record.Box = 0
+		case result.Millis > 0 && result.Millis <= fastAnswerMillis:
+			record.Box = min(record.Box+1, maxFactBox)
+		}
+		if result.Millis > 0 {
+			record.AverageMillis = (record.AverageMillis*record.Attempts + result.Millis) / (record.Attempts + 1)
+		}
+		record.Attempts++
+		if result.Right {
+			record.Correct++
+		}
+		record.LastSeenAt = now
+		changed[result.FactID] = record
+	}
+
+	var newlyMastered []string
+	requests := make([]*dynamodb.WriteRequest, 0, len(changed))
+	for _, record := range changed {
+		if record.Box >= masteredFactBox && mastery[record.FactID].Box < masteredFactBox {
+			newlyMastered = append(newlyMastered, record.FactID)
+		}
+		item, err := dynamodbattribute.MarshalMap(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal fact mastery: %v", err)
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	if err := batchWriteItems(h.DynamoDB, tableName("puzzle-hub-fact-mastery"), requests); err != nil {
+		return nil, err
+	}
+	sort.Strings(newlyMastered)
+	return newlyMastered, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, served on /metrics. Set METRICS_TOKEN to require
+// "Authorization: Bearer <token>" from the scraper.
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "puzzle_hub_http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	aiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "puzzle_hub_ai_request_duration_seconds",
+		Help:    "Latency of AI provider calls.",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60},
+	}, []string{"provider", "outcome"})
+
+	aiRequestFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_ai_request_failures_total",
+		Help: "AI provider calls that returned an error.",
+	}, []string{"provider"})
+
+	aiRequestRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_ai_request_retries_total",
+		Help: "AI provider calls retried after a transient failure.",
+	}, []string{"provider"})
+
+	aiFeatureDegraded = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "puzzle_hub_ai_feature_degraded",
+		Help: "1 while the AI latency watchdog routes a feature to its fast model.",
+	}, []string{"feature"})
+
+	aiLatencyEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_ai_latency_events_total",
+		Help: "Features degraded or recovered by the AI latency watchdog.",
+	}, []string{"feature", "event"})
+
+	aiModerationFlags = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_ai_moderation_flags_total",
+		Help: "AI responses flagged by content moderation.",
+	}, []string{"feature", "source"})
+
+	promptInputFlags = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_prompt_input_flags_total",
+		Help: "User input changed before going into a prompt, by template and reason.",
+	}, []string{"template", "reason"})
+
+	dynamoDBRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_dynamodb_requests_total",
+		Help: "DynamoDB API calls by operation.",
+	}, []string{"operation"})
+
+	dynamoDBErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_dynamodb_errors_total",
+		Help: "DynamoDB API errors by operation and AWS error code.",
+	}, []string{"operation", "code"})
+
+	dynamoDBRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_dynamodb_retries_total",
+		Help: "DynamoDB calls retried after throttling or a server error, by operation.",
+	}, []string{"operation"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_cache_requests_total",
+		Help: "Cache lookups by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	rateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_rate_limited_requests_total",
+		Help: "Requests rejected with 429 by rate limit budget.",
+	}, []string{"budget"})
+
+	aiQuotaExceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "puzzle_hub_ai_quota_exceeded_total",
+		Help: "AI requests rejected with 429 because the caller's daily quota was used up.",
+	}, []string{"feature"})
+)
+
+// metricsMiddleware records request counts and latency per route template,
+// so /api/feedback/:id is one series rather than one per ID
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricsHandler serves the Prometheus exposition format
+func metricsHandler() gin.HandlerFunc {
+	token := os.Getenv("METRICS_TOKEN")
+	handler := promhttp.Handler()
+	return func(c *gin.Context) {
+		if token != "" {
+			presented, err := bearerToken(c)
+			if err != nil || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+				respondError(c, http.StatusUnauthorized, "Invalid metrics token")
+				return
+			}
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// observeAICall records the duration and outcome of one AI provider call
+func observeAICall(provider string, start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+		aiRequestFailures.WithLabelValues(provider).Inc()
+	}
+	aiRequestDuration.WithLabelValues(provider, outcome).Observe(time.Since(start).Seconds())
+}
+
+// observeCacheLookup counts a cache hit or miss
+func observeCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheRequests.WithLabelValues(cache, result).Inc()
+}
+
+// instrumentDynamoDB counts every DynamoDB call and its errors via the SDK's
+// request handlers, so no call site needs to change
+func instrumentDynamoDB(svc *dynamodb.DynamoDB) {
+	svc.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "puzzlehub.metrics",
+		Fn: func(r *request.Request) {
+			operation := r.Operation.Name
+			dynamoDBRequests.WithLabelValues(operation).Inc()
+			if r.RetryCount > 0 {
+				dynamoDBRetries.WithLabelValues(operation).Add(float64(r.RetryCount))
+			}
+			if r.Error != nil {
+				code := "unknown"
+				var aerr awserr.Error
+				if errors.As(r.Error, &aerr) {
+					code = aerr.Code()
+				}
+				dynamoDBErrors.WithLabelValues(operation, code).Inc()
+			}
+		},
+	})
+}
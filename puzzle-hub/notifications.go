@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/gin-gonic/gin"
+)
+
+// Notification center
+//
+// Modules tell a user about something with h.notify: the notification is
+// stored in puzzle-hub-notifications (kept notificationRetention), pushed
+// as a "notification" event on the user's realtime topic, and emailed when
+// NOTIFICATION_EMAIL_FROM is set and the user opted in with the
+// email.notifications preference. Emails go through the job queue so a slow
+// SES call never holds up the request that caused them.
+//
+// Kinds so far are feedback status changes, admin replies on feedback,
+// level ups (gamification.go), new homework (assignments.go), challenge
+// results (challenges.go), log trend alerts (log_alerts.go), quick-log
+// email receipts (email_logging.go) and finished account exports
+// (account_export.go); new sources add a kind and call notify.
+
+const (
+	notificationRetention    = 90 * 24 * time.Hour
+	notificationEmailJob     = "notification-email"
+	defaultNotificationLimit = 30
+	maxNotificationLimit     = 100
+
+	notificationFeedbackStatus  = "feedback_status"
+	notificationFeedbackComment = "feedback_comment"
+	notificationLevelUp         = "level_up"
+	notificationAssignment      = "assignment"
+	notificationChallenge       = "challenge_result"
+	notificationLogAlert        = "log_alert"
+	notificationEmailLog        = "email_log"
+	notificationAccountExport   = "account_export"
+)
+
+type Notification struct {
+	UserID    string            `json:"-" dynamodbav:"user_id"`
+	ID        string            `json:"id" dynamodbav:"notification_id"` // nt_<unix nanos>, sorts chronologically
+	Kind      string            `json:"kind" dynamodbav:"kind"`
+	Title     string            `json:"title" dynamodbav:"title"`
+	Body      string            `json:"body,omitempty" dynamodbav:"body,omitempty"`
+	Link      string            `json:"link,omitempty" dynamodbav:"link,omitempty"` // Path in the web app
+	Data      map[string]string `json:"data,omitempty" dynamodbav:"data,omitempty"`
+	Read      bool              `json:"read" dynamodbav:"read"`
+	ReadAt    *time.Time        `json:"read_at,omitempty" dynamodbav:"read_at,omitempty"`
+	CreatedAt time.Time         `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt int64             `json:"-" dynamodbav:"expires_at"`
+}
+
+// notificationEmail is the payload of a notification-email job
+type notificationEmail struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	Link   string `json:"link"`
+}
+
+// notify stores a notification for n.UserID and pushes it to their open
+// connections. email, when known, is used for the optional email copy.
+// Failures are logged rather than returned, so the action that triggered
+// the notification still succeeds.
+func (h *PuzzleHub) notify(ctx context.Context, n Notification, email string) {
+	now := time.Now()
+	n.ID = fmt.Sprintf("nt_%d", now.UnixNano())
+	n.Read = false
+	n.CreatedAt = now
+	n.ExpiresAt = now.Add(notificationRetention).Unix()
+
+	item, err := dynamodbattribute.MarshalMap(n)
+	if err != nil {
+		log.Printf("Error marshaling notification for %s: %v", n.UserID, err)
+		return
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-notifications")),
+		Item:      item,
+	})
+	if err != nil {
+		log.Printf("Error saving notification for %s: %v", n.UserID, err)
+		return
+	}
+
+	h.Realtime.PublishToUser(n.UserID, "notification", n)
+
+	if h.NotificationFromEmail != "" && email != "" {
+		payload := notificationEmail{UserID: n.UserID, Email: email, Title: n.Title, Body: n.Body, Link: n.Link}
+		if _, err := h.Jobs.Enqueue(ctx, notificationEmailJob, payload, 0); err != nil {
+			log.Printf("Error queueing notification email for %s: %v", n.UserID, err)
+		}
+	}
+}
+
+// sendNotificationEmail is the notification-email job handler. The
+// preference is checked when sending, so opting out applies to queued mail.
+func (h *PuzzleHub) sendNotificationEmail(ctx context.Context, payload json.RawMessage) error {
+	var message notificationEmail
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return fmt.Errorf("invalid notification email payload: %v", err)
+	}
+
+	prefs, err := h.getUserPreferences(message.UserID)
+	if err != nil {
+		return err
+	}
+	if !prefs.Email.Notifications {
+		return nil
+	}
+
+	body := message.Body
+	if message.Link != "" {
+		body += "\n\n" + strings.TrimSuffix(os.Getenv("BASE_URL"), "/") + message.Link
+	}
+	body += "\n\n" + translate(prefs.Language, "You can turn these emails off in Puzzle Hub settings.")
+
+	_, err = h.SES.SendEmailWithContext(ctx, &ses.SendEmailInput{
+		Source:      aws.String(h.NotificationFromEmail),
+		Destination: &ses.Destination{ToAddresses: []*string{aws.String(message.Email)}},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(message.Title), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(strings.TrimSpace(body)), Charset: aws.String("UTF-8")},
+			},
+		},
+	})
+	return err
+}
+
+// listNotifications returns the user's notifications newest first.
+// ?unread=true limits it to unread ones; ?before=<id> pages back from the
+// next_before of the previous page.
+func (h *PuzzleHub) listNotifications(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	limit := defaultNotificationLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxNotificationLimit {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxNotificationLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-notifications")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userObj.ID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	}
+	if before := c.Query("before"); before != "" {
+		input.KeyConditionExpression = aws.String("user_id = :user_id AND notification_id < :before")
+		input.ExpressionAttributeValues[":before"] = &dynamodb.AttributeValue{S: aws.String(before)}
+	}
+	if c.Query("unread") == "true" {
+		input.FilterExpression = aws.String("#read = :false")
+		input.ExpressionAttributeNames = map[string]*string{"#read": aws.String("read")}
+		input.ExpressionAttributeValues[":false"] = &dynamodb.AttributeValue{BOOL: aws.Bool(false)}
+	}
+
+	// The filter applies after Limit, so keep reading until the page is full
+	notifications, err := queryLimit[Notification](c.Request.Context(), h.DynamoDB, input, limit)
+	if err != nil {
+		requestLogger(c).Error("error querying notifications", "error", err)
+		respondStorageError(c, err, "Failed to fetch notifications")
+		return
+	}
+
+	response := gin.H{}
+	if len(notifications) == limit {
+		response["next_before"] = notifications[len(notifications)-1].ID
+	}
+
+	unread, err := h.countUnreadNotifications(c.Request.Context(), userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error counting unread notifications", "error", err)
+		respondStorageError(c, err, "Failed to fetch notifications")
+		return
+	}
+	response["notifications"] = notifications
+	response["unread_count"] = unread
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *PuzzleHub) countUnreadNotifications(ctx context.Context, userID string) (int64, error) {
+	count, err := countQuery(ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-notifications")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		FilterExpression:       aws.String("#read = :false"),
+		ExpressionAttributeNames: map[string]*string{
+			"#read": aws.String("read"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+			":false":   {BOOL: aws.Bool(false)},
+		},
+	})
+	return int64(count), err
+}
+
+// markNotificationRead marks one notification read
+func (h *PuzzleHub) markNotificationRead(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	found, err := h.setNotificationRead(c.Request.Context(), userObj.ID, c.Param("id"), time.Now())
+	if err != nil {
+		requestLogger(c).Error("error marking notification read", "error", err, "notification_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to update notification")
+		return
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	h.Realtime.PublishToUser(userObj.ID, "notifications_read", gin.H{"ids": []string{c.Param("id")}})
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// markAllNotificationsRead marks every unread notification read
+func (h *PuzzleHub) markAllNotificationsRead(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	unread, err := queryLimit[Notification](ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-notifications")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		FilterExpression:       aws.String("#read = :false"),
+		ProjectionExpression:   aws.String("user_id, notification_id"),
+		ExpressionAttributeNames: map[string]*string{
+			"#read": aws.String("read"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userObj.ID)},
+			":false":   {BOOL: aws.Bool(false)},
+		},
+	}, 0)
+	if err != nil {
+		requestLogger(c).Error("error querying unread notifications", "error", err)
+		respondStorageError(c, err, "Failed to update notifications")
+		return
+	}
+
+	now := time.Now()
+	ids := make([]string, 0, len(unread))
+	for _, n := range unread {
+		if _, err := h.setNotificationRead(ctx, userObj.ID, n.ID, now); err != nil {
+			requestLogger(c).Error("error marking notification read", "error", err, "notification_id", n.ID)
+			respondStorageError(c, err, "Failed to update notifications")
+			return
+		}
+		ids = append(ids, n.ID)
+	}
+
+	if len(ids) > 0 {
+		h.Realtime.PublishToUser(userObj.ID, "notifications_read", gin.H{"ids": ids})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Notifications marked as read",
+		"count":   len(ids),
+	})
+}
+
+// setNotificationRead reports false when the notification doesn't exist
+func (h *PuzzleHub) setNotificationRead(ctx context.Context, userID, notificationID string, at time.Time) (bool, error) {
+	_, err := h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-notifications")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":         {S: aws.String(userID)},
+			"notification_id": {S: aws.String(notificationID)},
+		},
+		UpdateExpression:    aws.String("SET #read = :true, read_at = if_not_exists(read_at, :now)"),
+		ConditionExpression: aws.String("attribute_exists(notification_id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#read": aws.String("read"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true": {BOOL: aws.Bool(true)},
+			":now":  {S: aws.String(at.Format(time.RFC3339Nano))},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
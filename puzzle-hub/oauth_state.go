@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+)
+
+// OAuth login CSRF protection
+//
+// Starting a login stores a random state and a PKCE verifier in a signed,
+// HttpOnly cookie scoped to /auth. The callback only proceeds when Google
+// echoes the same state back, and the code exchange must present the
+// verifier, so an injected authorization code can't be redeemed.
+
+const (
+	oauthSessionName = "puzzle_hub_oauth"
+	oauthStateTTL    = 10 * time.Minute
+)
+
+// beginOAuthLogin records state and verifier for this browser and returns the
+// Google authorization URL
+func (h *PuzzleHub) beginOAuthLogin(c *gin.Context) (string, error) {
+	state, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	session, _ := h.AuthConfig.SessionStore.New(c.Request, oauthSessionName)
+	session.Options = h.oauthCookieOptions(int(oauthStateTTL.Seconds()))
+	session.Values["state"] = state
+	session.Values["verifier"] = verifier
+	session.Values["created_at"] = time.Now().Unix()
+	if err := session.Save(c.Request, c.Writer); err != nil {
+		return "", fmt.Errorf("failed to save OAuth state: %v", err)
+	}
+
+	return h.AuthConfig.GoogleOAuth.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.S256ChallengeOption(verifier),
+	), nil
+}
+
+// verifyOAuthState checks the callback's state against the cookie and
+// returns the PKCE verifier. The cookie is cleared either way so a state can
+// only be used once.
+func (h *PuzzleHub) verifyOAuthState(c *gin.Context) (string, error) {
+	session, err := h.AuthConfig.SessionStore.Get(c.Request, oauthSessionName)
+	if err != nil || session.IsNew {
+		return "", fmt.Errorf("login session not found or expired")
+	}
+
+	expected, _ := session.Values["state"].(string)
+	verifier, _ := session.Values["verifier"].(string)
+	createdAt, _ := session.Values["created_at"].(int64)
+
+	session.Options = h.oauthCookieOptions(-1)
+	session.Save(c.Request, c.Writer)
+
+	actual := c.Query("state")
+	if expected == "" || actual == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return "", fmt.Errorf("state mismatch")
+	}
+	if time.Since(time.Unix(createdAt, 0)) > oauthStateTTL {
+		return "", fmt.Errorf("login session expired")
+	}
+	if verifier == "" {
+		return "", fmt.Errorf("missing PKCE verifier")
+	}
+	return verifier, nil
+}
+
+func (h *PuzzleHub) oauthCookieOptions(maxAge int) *sessions.Options {
+	return &sessions.Options{
+		Path:     "/auth",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.AuthConfig.SecureCookies,
+		// Lax still sends the cookie on Google's top-level redirect back to us
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API versioning and the OpenAPI 3 spec. The JSON API lives under /api/v1;
+// the old unversioned /api paths serve the same handlers but answer with
+// Deprecation and Link headers pointing at the versioned path.
+//
+// The spec at /api/v1/openapi.json is built from the registered routes, so
+// an endpoint can't be missing from it. apiOperations adds summaries and
+// request/response schemas, generated from the Go types by their json tags.
+// Anything not listed there still appears with a generic object schema.
+
+const apiV1Prefix = "/api/v1"
+
+// deprecatedAPIMiddleware marks responses on the unversioned /api alias
+func deprecatedAPIMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		successor := apiV1Prefix + strings.TrimPrefix(c.Request.URL.Path, "/api")
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// apiOperation documents one route, keyed by "METHOD /path" below the prefix
+type apiOperation struct {
+	Summary  string
+	Public   bool        // No bearer token needed (optional auth)
+	Request  interface{} // Zero value of the JSON body type, if any
+	Response interface{} // Zero value of the 200 response type, if any
+}
+
+var apiOperations = map[string]apiOperation{
+	"POST /spelling/generate":                   {Summary: "Generate spelling problems", Public: true, Request: GenerationCriteria{}},
+	"POST /spelling/generate-for-age":           {Summary: "Generate spelling problems for an age", Public: true},
+	"POST /yohaku/generate":                     {Summary: "Generate a Yohaku puzzle", Public: true, Request: GameSettings{}},
+	"POST /yohaku/start-game":                   {Summary: "Start a 10-puzzle Yohaku game", Public: true, Request: GameSettings{}},
+	"POST /yohaku/validate":                     {Summary: "Validate a Yohaku solution", Public: true},
+	"POST /math-facts/start":                    {Summary: "Start a timed multiplication or division drill", Public: true, Request: FactDrillSettings{}},
+	"POST /math-facts/submit":                   {Summary: "Mark a drill and update fact mastery", Public: true, Request: SubmitFactDrillRequest{}},
+	"POST /math/word-problems":                  {Summary: "Generate math word problems for a grade and theme", Public: true, Request: WordProblemRequest{}},
+	"POST /math/word-problems/{id}/submit":      {Summary: "Check an answer to a word problem", Public: true, Request: SubmitWordProblemRequest{}},
+	"POST /math/word-problems/{id}/explanation": {Summary: "Step-by-step solution to a word problem", Public: true},
+	"POST /sudoku/generate":                     {Summary: "Generate a graded 4x4 or 9x9 sudoku", Public: true, Request: SudokuSettings{}},
+	"POST /sudoku/start-game":                   {Summary: "Start a 5-puzzle sudoku game", Public: true, Request: SudokuSettings{}},
+	"POST /sudoku/validate":                     {Summary: "Check a finished sudoku", Public: true, Request: ValidateSudokuRequest{}},
+	"POST /sudoku/hint":                         {Summary: "Get the next logical step in a sudoku", Public: true, Request: SudokuHintRequest{}},
+	"POST /crossword/generate":                  {Summary: "Lay out a crossword from a word list or spelling words", Public: true, Request: GenerateCrosswordRequest{}, Response: CrosswordPuzzle{}},
+	"GET /crossword/{id}":                       {Summary: "Get a crossword's grid and clues", Public: true, Response: CrosswordPuzzle{}},
+	"POST /crossword/{id}/check":                {Summary: "Check crossword answers", Public: true, Request: CheckCrosswordRequest{}},
+	"GET /quiz/topics":                          {Summary: "List quiz topics", Public: true},
+	"GET /languages":                            {Summary: "Supported languages and the one in use", Public: true},
+	"POST /quiz/start":                          {Summary: "Start a multiple choice quiz on a topic", Public: true, Request: QuizSettings{}, Response: Quiz{}},
+	"POST /quiz/submit":                         {Summary: "Mark a quiz and update topic mastery", Public: true, Request: SubmitQuizRequest{}},
+	"POST /yohaku/hint":                         {Summary: "Get a Yohaku hint", Public: true},
+	"POST /writing/analyze":                     {Summary: "Analyze a piece of writing", Public: true, Request: WritingAnalysisRequest{}},
+	"POST /writing/analyze/batch":               {Summary: "Analyze several pieces of writing", Public: true, Request: WritingBatchRequest{}},
+	"POST /writing/reanchor":                    {Summary: "Move writing feedback onto edited text", Public: true, Request: ReanchorRequest{}, Response: ReanchorResponse{}},
+	"POST /writing/analyze/stream":              {Summary: "Analyze writing, streamed over SSE", Public: true, Request: WritingAnalysisRequest{}},
+
+	"POST /story/generate":               {Summary: "Generate a story starter", Request: StoryRequest{}, Response: StoryResponse{}},
+	"POST /story/generate/stream":        {Summary: "Generate a story starter, streamed over SSE", Request: StoryRequest{}},
+	"POST /story":                        {Summary: "Save a new story draft", Request: SaveStoryDraftRequest{}, Response: StoryDraft{}},
+	"GET /story":                         {Summary: "List your saved stories, without their text"},
+	"GET /story/{id}":                    {Summary: "Get a saved story", Response: StoryDraft{}},
+	"PUT /story/{id}":                    {Summary: "Update a saved story", Request: SaveStoryDraftRequest{}, Response: StoryDraft{}},
+	"DELETE /story/{id}":                 {Summary: "Delete a saved story"},
+	"POST /story/{id}/title-suggestions": {Summary: "Suggest five titles and a back-cover blurb for a saved story", Response: StoryTitleCandidates{}},
+	"POST /story/{id}/title":             {Summary: "Pick one of the suggested titles", Request: PickStoryTitleRequest{}, Response: StoryDraft{}},
+
+	"POST /feedback/submit":                   {Summary: "Submit feedback", Request: FeedbackSubmission{}},
+	"POST /feedback/attachments/upload-url":   {Summary: "Get an upload URL for a feedback attachment", Request: AttachmentUploadRequest{}},
+	"POST /feedback/rating":                   {Summary: "Rate an app", Request: AppRatingRequest{}},
+	"GET /feedback/ratings/summary":           {Summary: "App rating summaries"},
+	"GET /feedback/list":                      {Summary: "List your feedback"},
+	"GET /feedback/roadmap":                   {Summary: "Public feature-request board"},
+	"POST /feedback/roadmap/{id}/vote":        {Summary: "Vote for a feature request"},
+	"DELETE /feedback/roadmap/{id}/vote":      {Summary: "Remove a feature-request vote"},
+	"GET /feedback/{id}":                      {Summary: "Feedback detail with comments"},
+	"POST /feedback/{id}/comments":            {Summary: "Comment on feedback", Request: CreateFeedbackCommentRequest{}},
+	"POST /progress":                          {Summary: "Record an activity result", Request: RecordProgressRequest{}},
+	"GET /progress":                           {Summary: "Your learning progress"},
+	"GET /progress/summary":                   {Summary: "Dashboard across every activity: trends, streak and badges", Response: ProgressDashboard{}},
+	"GET /math-facts/mastery":                 {Summary: "Your multiplication and division fact mastery"},
+	"GET /flashcards/decks":                   {Summary: "Your flashcard decks with card and due counts"},
+	"POST /flashcards/decks":                  {Summary: "Create a flashcard deck", Request: CreateFlashcardDeckRequest{}},
+	"DELETE /flashcards/decks/{id}":           {Summary: "Delete a deck and its cards"},
+	"GET /flashcards/decks/{id}/cards":        {Summary: "A deck's cards and their schedules"},
+	"POST /flashcards/decks/{id}/cards":       {Summary: "Add a card to a deck", Request: FlashcardDraft{}},
+	"DELETE /flashcards/cards/{id}":           {Summary: "Delete a flashcard"},
+	"POST /flashcards/push":                   {Summary: "Add cards to a module's deck, e.g. misspelled words", Request: PushFlashcardsRequest{}},
+	"GET /flashcards/review":                  {Summary: "Today's review queue across all decks"},
+	"POST /flashcards/review":                 {Summary: "Grade reviewed cards and reschedule them", Request: SubmitFlashcardReviewRequest{}},
+	"POST /yohaku/custom":                     {Summary: "Build your own yohaku from its inner grid", Request: CreateCustomYohakuRequest{}, Response: CustomYohaku{}},
+	"GET /yohaku/custom":                      {Summary: "Your own yohaku puzzles"},
+	"GET /yohaku/custom/{id}":                 {Summary: "Play one of your puzzles", Response: CustomYohaku{}},
+	"DELETE /yohaku/custom/{id}":              {Summary: "Delete one of your puzzles"},
+	"POST /yohaku/custom/{id}/share":          {Summary: "Share a puzzle with friends by code"},
+	"GET /yohaku/shared/{code}":               {Summary: "Play a friend's puzzle", Response: CustomYohaku{}},
+	"POST /remediation":                       {Summary: "A practice packet for missed spelling words or quiz questions", Request: RemediationRequest{}, Response: RemediationPacket{}},
+	"GET /quiz/mastery":                       {Summary: "Your mastery of each quiz topic"},
+	"GET /gamification/profile":               {Summary: "Your level, XP, avatar unlocks and today's XP caps", Response: GamificationProfile{}},
+	"PUT /gamification/avatar":                {Summary: "Equip unlocked avatar items", Request: UpdateAvatarRequest{}},
+	"POST /parental/invites":                  {Summary: "Invite a child account"},
+	"POST /parental/accept":                   {Summary: "Accept a parental invite", Request: AcceptParentalInviteRequest{}},
+	"GET /parental/children":                  {Summary: "List linked children"},
+	"PUT /parental/children/{childId}/limits": {Summary: "Set a child's screen-time limits", Request: UpdateScreenTimeRequest{}},
+	"DELETE /parental/children/{childId}":     {Summary: "Unlink a child"},
+	"GET /classrooms":                         {Summary: "List your classrooms"},
+	"POST /classrooms":                        {Summary: "Create a classroom", Request: CreateClassroomRequest{}},
+	"POST /classrooms/join":                   {Summary: "Join a classroom by code", Request: JoinClassroomRequest{}},
+	"GET /assignments":                        {Summary: "Your homework across your classes, unfinished first"},
+	"POST /classrooms/{id}/assignments":       {Summary: "Assign an activity to a class", Request: CreateAssignmentRequest{}, Response: Assignment{}},
+	"GET /classrooms/{id}/assignments":        {Summary: "A class's assignments with completion counts"},
+	"POST /challenges":                        {Summary: "Challenge someone to beat your game", Request: CreateChallengeRequest{}},
+	"GET /challenges/{code}":                  {Summary: "A challenge's words or puzzles to play"},
+	"POST /challenges/{code}/result":          {Summary: "Submit your result for a challenge", Request: ChallengeResultRequest{}},
+	"GET /changelog":                          {Summary: "In-app changelog"},
+	"GET /notifications":                      {Summary: "Your notifications, newest first"},
+	"POST /notifications/read-all":            {Summary: "Mark all notifications read"},
+	"POST /notifications/{id}/read":           {Summary: "Mark a notification read"},
+	"GET /ws":                                 {Summary: "Realtime WebSocket, authenticated with ?access_token=", Public: true},
+	"POST /realtime/rooms":                    {Summary: "Create a multiplayer yohaku or sudoku room, or a shared story room"},
+
+	"GET /parental/children/{childId}/progress/summary": {Summary: "A child's progress dashboard", Response: ProgressDashboard{}},
+	"GET /parental/report/preview":                      {Summary: "Preview the weekly parent email", Response: ParentWeeklyReport{}},
+
+	"GET /classrooms/{id}/assignments/{assignmentId}":                 {Summary: "Grading view: every student's status and scores"},
+	"PUT /classrooms/{id}/assignments/{assignmentId}/grades/{userId}": {Summary: "Grade a student's work", Request: GradeAssignmentRequest{}},
+	"DELETE /classrooms/{id}/assignments/{assignmentId}":              {Summary: "Delete an assignment"},
+
+	"GET /classrooms/{id}/integration":                {Summary: "The class's LMS integration, if linked", Response: ClassroomIntegration{}},
+	"DELETE /classrooms/{id}/integration":             {Summary: "Unlink the class's LMS"},
+	"PUT /classrooms/{id}/integration/webhook":        {Summary: "Send the class's results to a signed webhook", Request: LinkWebhookRequest{}},
+	"POST /classrooms/{id}/integration/google":        {Summary: "Start linking the class to Google Classroom"},
+	"GET /classrooms/{id}/integration/google/courses": {Summary: "Google Classroom courses the linked account teaches"},
+	"PUT /classrooms/{id}/integration/google/course":  {Summary: "Pick the Google Classroom course to grade in", Request: SelectCourseRequest{}},
+
+	"POST /spelling-bee/sessions":                                     {Summary: "Start an audio-only spelling bee, optionally against a rival", Request: StartBeeRequest{}},
+	"GET /spelling-bee/sessions/{sessionId}":                          {Summary: "A spelling bee, with the words attempted so far", Response: SpellingBeeSession{}},
+	"GET /spelling-bee/sessions/{sessionId}/words/{index}/audio":      {Summary: "MP3 of a word, its definition or its sentence"},
+	"POST /spelling-bee/sessions/{sessionId}/words/{index}/attempt":   {Summary: "Spell a word, once", Request: BeeAttemptRequest{}},
+	"POST /spelling-bee/sessions/{sessionId}/words/{index}/recording": {Summary: "Upload URL for a recording of the word", Request: BeeRecordingRequest{}},
+	"POST /spelling-bee/sessions/{sessionId}/finish":                  {Summary: "Finish a spelling bee and record the score", Request: FinishBeeRequest{}},
+	"GET /parental/children/{childId}/spelling-bee":                   {Summary: "A child's recent spelling bees with their recordings"},
+	"GET /parental/children/{childId}/results-webhook":                {Summary: "A linked child's results webhook, or null", Response: ResultsWebhook{}},
+	"PUT /parental/children/{childId}/results-webhook":                {Summary: "Send a linked child's finished sessions to a webhook", Request: SetResultsWebhookRequest{}},
+	"DELETE /parental/children/{childId}/results-webhook":             {Summary: "Remove a linked child's results webhook"},
+
+	"GET /logs/types":                    {Summary: "List log types"},
+	"POST /logs/types":                   {Summary: "Create a log type", Request: CreateLogTypeRequest{}, Response: LogType{}},
+	"POST /logs/types/suggest-fields":    {Summary: "Suggest fields for a log type", Request: SuggestFieldsRequest{}, Response: SuggestFieldsResponse{}},
+	"PUT /logs/types/{id}/fields/order":  {Summary: "Reorder log fields", Request: ReorderLogFieldsRequest{}},
+	"PUT /logs/types/{id}/retention":     {Summary: "Set log retention", Request: UpdateRetentionRequest{}},
+	"GET /logs/entries":                  {Summary: "List log entries"},
+	"POST /logs/entries":                 {Summary: "Create a log entry", Request: CreateLogEntryRequest{}, Response: LogEntry{}},
+	"POST /logs/entries/{id}/duplicate":  {Summary: "Log an entry again", Request: DuplicateLogEntryRequest{}, Response: LogEntry{}},
+	"POST /logs/sync":                    {Summary: "Push offline log changes", Request: SyncRequest{}},
+	"GET /logs/sync/changes":             {Summary: "Pull log changes since a cursor"},
+	"GET /logs/analytics":                {Summary: "Analytics across all log types"},
+	"GET /logs/analytics/heatmap":        {Summary: "Calendar heatmap of log activity"},
+	"GET /logs/analytics/correlation":    {Summary: "Correlate two log fields"},
+	"GET /logs/analytics/{logTypeId}":    {Summary: "Analytics for one log type"},
+	"GET /logs/types/{id}/snapshots":     {Summary: "Weekly snapshots of a log type", Response: LogSnapshot{}},
+	"GET /logs/alerts":                   {Summary: "Trend alerts across your log types", Response: LogAlert{}},
+	"GET /logs/types/{id}/dashboards":    {Summary: "Your dashboards for a log type"},
+	"POST /logs/types/{id}/dashboards":   {Summary: "Save a dashboard layout for a log type", Request: SaveDashboardRequest{}, Response: LogDashboard{}},
+	"GET /logs/dashboards/{id}":          {Summary: "A saved dashboard layout", Response: LogDashboard{}},
+	"PUT /logs/dashboards/{id}":          {Summary: "Replace a dashboard's name and charts", Request: SaveDashboardRequest{}, Response: LogDashboard{}},
+	"DELETE /logs/dashboards/{id}":       {Summary: "Delete a dashboard"},
+	"GET /logs/dashboards/{id}/data":     {Summary: "Series for every chart on a dashboard", Response: DashboardChartData{}},
+	"DELETE /logs/alerts/{id}":           {Summary: "Dismiss a trend alert"},
+	"GET /logs/email":                    {Summary: "Your quick-log email address", Response: EmailLogAddress{}},
+	"POST /logs/email":                   {Summary: "Create or replace your quick-log email address", Response: EmailLogAddress{}},
+	"DELETE /logs/email":                 {Summary: "Turn off logging by email"},
+	"POST /integrations/email/inbound":   {Summary: "Log entries from an inbound email, signed by the SES relay", Public: true, Request: InboundEmail{}},
+	"GET /household":                     {Summary: "Your household, its members and shared log types"},
+	"POST /household":                    {Summary: "Create a household", Request: CreateHouseholdRequest{}, Response: Household{}},
+	"DELETE /household":                  {Summary: "Delete your household, unsharing its log types"},
+	"POST /household/join":               {Summary: "Join a household with its invite code", Request: JoinHouseholdRequest{}},
+	"POST /household/invite-code":        {Summary: "Replace the household invite code"},
+	"DELETE /household/members/{userId}": {Summary: "Remove a member, or leave the household"},
+	"PUT /logs/types/{id}/household":     {Summary: "Share a log type with your household, or stop sharing it", Request: ShareLogTypeRequest{}},
+	"GET /logs/types/{id}/schema":        {Summary: "Export a log type definition as a shareable schema", Response: LogTypeSchema{}},
+	"POST /logs/types/import":            {Summary: "Create a log type from a schema document", Request: LogTypeSchema{}},
+	"PUT /user/timezone":                 {Summary: "Set your timezone", Request: UpdateTimezoneRequest{}},
+	"GET /user/preferences":              {Summary: "Get your preferences"},
+	"PUT /user/preferences":              {Summary: "Update your preferences", Request: UpdatePreferencesRequest{}},
+	"GET /user/results-webhook":          {Summary: "Your results webhook, or null", Response: ResultsWebhook{}},
+	"PUT /user/results-webhook":          {Summary: "Send your finished sessions to a webhook", Request: SetResultsWebhookRequest{}},
+	"DELETE /user/results-webhook":       {Summary: "Remove your results webhook"},
+	"POST /export/account":               {Summary: "Email yourself a download link to a ZIP of your whole account"},
+	"GET /ai-usage":                      {Summary: "Your AI usage this month"},
+	"GET /usage":                         {Summary: "Your remaining daily AI quota", Public: true},
+	"POST /ai/rate":                      {Summary: "Rate an AI response", Request: AIRatingRequest{}},
+	"POST /report-content":               {Summary: "Report generated content as inappropriate (parents and teachers)", Request: ReportContentRequest{}},
+}
+
+// openAPIHandler serves the spec for r, built on first request once every
+// route is registered
+func openAPIHandler(r *gin.Engine) gin.HandlerFunc {
+	var once sync.Once
+	var spec map[string]interface{}
+	return func(c *gin.Context) {
+		once.Do(func() {
+			spec = buildOpenAPISpec(r.Routes())
+		})
+		c.JSON(http.StatusOK, spec)
+	}
+}
+
+var ginPathParam = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+func buildOpenAPISpec(routes gin.RoutesInfo) map[string]interface{} {
+	schemas := newSchemaRegistry()
+	schemas.components["Error"] = map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"error": schemas.schemaFor(reflect.TypeOf(APIError{}))},
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, apiV1Prefix+"/") || route.Path == apiV1Prefix+"/openapi.json" {
+			continue
+		}
+		path := ginPathParam.ReplaceAllString(strings.TrimPrefix(route.Path, apiV1Prefix), "{$1}")
+		method := strings.ToLower(route.Method)
+		doc := apiOperations[route.Method+" "+path]
+
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][method] = schemas.operation(route.Method, path, doc)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Puzzle Hub API",
+			"version": "1.0.0",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": apiV1Prefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+type schemaRegistry struct {
+	components map[string]interface{}
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{components: map[string]interface{}{}}
+}
+
+func (s *schemaRegistry) operation(method, path string, doc apiOperation) map[string]interface{} {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	summary := doc.Summary
+	if summary == "" {
+		summary = method + " " + path
+	}
+
+	op := map[string]interface{}{
+		"summary": summary,
+		"tags":    []string{segments[0]},
+	}
+
+	var params []interface{}
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, "{") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if doc.Request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": s.schemaFor(reflect.TypeOf(doc.Request))},
+			},
+		}
+	}
+
+	okSchema := map[string]interface{}{"type": "object"}
+	if doc.Response != nil {
+		okSchema = s.schemaFor(reflect.TypeOf(doc.Response))
+	}
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+	op["responses"] = map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "OK",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": okSchema},
+			},
+		},
+		"default": errorResponse,
+	}
+
+	if !doc.Public {
+		op["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+	}
+	return op
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema of t. Named structs become components
+// and are referenced by name.
+func (s *schemaRegistry) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := s.components[t.Name()]; !ok {
+			s.components[t.Name()] = map[string]interface{}{} // Placeholder for recursive types
+			s.components[t.Name()] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return s.structSchema(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{} // interface{}: any value
+}
+
+func (s *schemaRegistry) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			// Embedded structs are flattened by encoding/json
+			embedded := s.structSchema(field.Type)
+			for key, value := range embedded["properties"].(map[string]interface{}) {
+				properties[key] = value
+			}
+			if fields, ok := embedded["required"].([]string); ok {
+				required = append(required, fields...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schemaFor(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") && !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
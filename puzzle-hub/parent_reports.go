@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/gin-gonic/gin"
+)
+
+// Weekly parent report
+//
+// Every Monday parents who opted in with the email.progress_reports
+// preference get one email covering all their linked children's last week:
+// sessions and minutes, spelling words practised, puzzles solved, writing
+// ratings, the daily streak and badges earned. The scheduled job claims the
+// week in puzzle-hub-job-runs and queues one parent-report job per parent,
+// so a failed send is retried on its own. Weeks run Monday to Monday in
+// each child's timezone.
+//
+// The parent's address is recorded on the parental link when the invite is
+// redeemed; older links fall back to the profile saved at login. The email
+// is written in the parent's preferred language.
+// GET /parental/report/preview renders the same email for the signed-in
+// parent.
+
+const parentReportJob = "parent-report"
+
+// parentReport is the payload of a parent-report job
+type parentReport struct {
+	ParentID  string `json:"parent_id"`
+	Email     string `json:"email"`
+	WeekStart string `json:"week_start"` // Monday, YYYY-MM-DD
+}
+
+type ChildWeeklyReport struct {
+	ChildID        string            `json:"child_id"`
+	ChildName      string            `json:"child_name"`
+	Sessions       int               `json:"sessions"`
+	Minutes        float64           `json:"minutes"`
+	ActiveDays     int               `json:"active_days"`
+	WordsPracticed int               `json:"words_practiced"`
+	WordsCorrect   int               `json:"words_correct"`
+	PuzzlesSolved  int               `json:"puzzles_solved"` // Finished yohaku and sudoku games
+	WritingPieces  int               `json:"writing_pieces"`
+	WritingRating  *float64          `json:"writing_rating,omitempty"` // Average stars out of 5
+	Streak         ProgressStreak    `json:"streak"`                   // As of the end of the week
+	Activities     []ActivitySummary `json:"activities"`
+	NewBadges      []ProgressBadge   `json:"new_badges"`
+}
+
+type ParentWeeklyReport struct {
+	ParentName string              `json:"parent_name"`
+	WeekStart  string              `json:"week_start"` // Monday, YYYY-MM-DD
+	WeekEnd    string              `json:"week_end"`   // Sunday, YYYY-MM-DD
+	Children   []ChildWeeklyReport `json:"children"`
+}
+
+// activityLabels names activities the way the report shows them
+var activityLabels = map[string]string{
+	"spelling":   "Spelling",
+	"writing":    "Writing",
+	"story":      "Stories",
+	"yohaku":     "Yohaku",
+	"math_facts": "Math facts",
+	"sudoku":     "Sudoku",
+	"quiz":       "Quizzes",
+	"flashcards": "Flashcards",
+}
+
+// lastCompleteWeek returns the Monday starting the last full ISO week
+// before now, in UTC, and its period label (e.g. 2024-W07)
+func lastCompleteWeek(now time.Time) (time.Time, string) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	weekStart := today.AddDate(0, 0, -((int(today.Weekday())+6)%7)-7)
+	year, week := weekStart.ISOWeek()
+	return weekStart, fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// sendWeeklyParentReports queues last week's report for every parent with a
+// linked child, once per week. It is scheduled in main.
+func (h *PuzzleHub) sendWeeklyParentReports(ctx context.Context) error {
+	weekStart, period := lastCompleteWeek(time.Now())
+
+	claimed, err := h.claimJobRun("parent-reports", period)
+	if err != nil {
+		return fmt.Errorf("failed to claim %s: %v", period, err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	// Parent ID to email; links without an address still get a job, which
+	// looks for the login profile when it runs
+	parents := map[string]string{}
+	var unmarshalErr error
+	err = h.DynamoDB.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-parental-links")),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var links []ParentalLink
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &links); unmarshalErr != nil {
+			return false
+		}
+		for _, link := range links {
+			if parents[link.ParentID] == "" {
+				parents[link.ParentID] = link.ParentEmail
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		// Release the claim so the next run retries
+		h.releaseJobRun("parent-reports", period)
+		return fmt.Errorf("parent reports for %s failed: %v", period, err)
+	}
+
+	queued := 0
+	for parentID, email := range parents {
+		payload := parentReport{ParentID: parentID, Email: email, WeekStart: weekStart.Format("2006-01-02")}
+		if _, err := h.Jobs.Enqueue(ctx, parentReportJob, payload, 0); err != nil {
+			log.Printf("Error queueing parent report for %s: %v", parentID, err)
+			continue
+		}
+		queued++
+	}
+
+	log.Printf("📬 Parent reports for %s queued (%d of %d parents)", period, queued, len(parents))
+	return nil
+}
+
+// sendParentReport is the parent-report job handler. The preference is
+// checked when sending, so opting out applies to queued reports.
+func (h *PuzzleHub) sendParentReport(ctx context.Context, payload json.RawMessage) error {
+	var job parentReport
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return fmt.Errorf("invalid parent report payload: %v", err)
+	}
+	weekStart, err := time.Parse("2006-01-02", job.WeekStart)
+	if err != nil {
+		return fmt.Errorf("invalid parent report week: %v", err)
+	}
+
+	prefs, err := h.getUserPreferences(job.ParentID)
+	if err != nil {
+		return err
+	}
+	if !prefs.Email.ProgressReports {
+		return nil
+	}
+
+	email := job.Email
+	if email == "" {
+		var profile userProfile
+		if getCachedJSON(ctx, h.Cache, "user_profile", userProfileKey(job.ParentID), &profile) {
+			email = profile.Email
+		}
+	}
+	if email == "" {
+		log.Printf("⚠️  No email address for parent %s, skipping weekly report", job.ParentID)
+		return nil
+	}
+
+	links, err := h.listChildLinks(job.ParentID)
+	if err != nil {
+		return fmt.Errorf("failed to list children: %v", err)
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	report, err := h.buildParentWeeklyReport(links[0].ParentName, links, weekStart)
+	if err != nil {
+		return err
+	}
+	language := prefs.Language
+	if language == "" {
+		language = defaultLanguage
+	}
+	html, err := renderParentReportHTML(report, language)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.SES.SendEmailWithContext(ctx, &ses.SendEmailInput{
+		Source:      aws.String(h.ParentReportFromEmail),
+		Destination: &ses.Destination{ToAddresses: []*string{aws.String(email)}},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(parentReportSubject(report, language)), Charset: aws.String("UTF-8")},
+			Body: &ses.Body{
+				Html: &ses.Content{Data: aws.String(html), Charset: aws.String("UTF-8")},
+				Text: &ses.Content{Data: aws.String(renderParentReportText(report, language)), Charset: aws.String("UTF-8")},
+			},
+		},
+	})
+	return err
+}
+
+// previewParentReport renders the weekly email for the signed-in parent.
+// ?week=YYYY-MM-DD picks the week containing that day (default last week)
+// and ?format=json returns the report data instead of the HTML.
+func (h *PuzzleHub) previewParentReport(c *gin.Context) {
+	parent := c.MustGet("user").(*User)
+
+	weekStart, _ := lastCompleteWeek(time.Now())
+	if week := c.Query("week"); week != "" {
+		day, err := time.Parse("2006-01-02", week)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "week must be a date in YYYY-MM-DD format")
+			return
+		}
+		weekStart = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+
+	links, err := h.listChildLinks(parent.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching children", "error", err, "parent_id", parent.ID)
+		respondStorageError(c, err, "Failed to build report")
+		return
+	}
+	report, err := h.buildParentWeeklyReport(parent.Name, links, weekStart)
+	if err != nil {
+		requestLogger(c).Error("error building parent report", "error", err, "parent_id", parent.ID)
+		respondStorageError(c, err, "Failed to build report")
+		return
+	}
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+	html, err := renderParentReportHTML(report, requestLanguage(c))
+	if err != nil {
+		requestLogger(c).Error("error rendering parent report", "error", err, "parent_id", parent.ID)
+		respondStorageError(c, err, "Failed to build report")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+}
+
+// buildParentWeeklyReport gathers each child's week starting weekStart
+// (a date; the time of day and zone are ignored)
+func (h *PuzzleHub) buildParentWeeklyReport(parentName string, links []ParentalLink, weekStart time.Time) (*ParentWeeklyReport, error) {
+	report := &ParentWeeklyReport{
+		ParentName: parentName,
+		WeekStart:  weekStart.Format("2006-01-02"),
+		WeekEnd:    weekStart.AddDate(0, 0, 6).Format("2006-01-02"),
+		Children:   []ChildWeeklyReport{},
+	}
+	for _, link := range links {
+		results, err := h.getActivityResults(link.ChildID, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch progress for %s: %v", link.ChildID, err)
+		}
+		loc := userLocation(&User{Timezone: h.savedTimezone(link.ChildID)})
+		start := time.Date(weekStart.Year(), weekStart.Month(), weekStart.Day(), 0, 0, 0, 0, loc)
+		report.Children = append(report.Children, buildChildWeeklyReport(link, results, start, start.AddDate(0, 0, 7)))
+	}
+	return report, nil
+}
+
+func buildChildWeeklyReport(link ParentalLink, results []ActivityResult, start, end time.Time) ChildWeeklyReport {
+	child := ChildWeeklyReport{ChildID: link.ChildID, ChildName: link.ChildName, NewBadges: []ProgressBadge{}}
+
+	// Results up to the end of the week give the streak and badges as they
+	// stood on Sunday night
+	var upToEnd, week []ActivityResult
+	for _, result := range results {
+		if result.CreatedAt.Before(end) {
+			upToEnd = append(upToEnd, result)
+			if !result.CreatedAt.Before(start) {
+				week = append(week, result)
+			}
+		}
+	}
+	dashboard := buildProgressDashboard(upToEnd, start.Location(), end.Add(-time.Second))
+	child.Streak = dashboard.Streak
+	for _, badge := range dashboard.Badges {
+		if !badge.EarnedAt.Before(start) {
+			child.NewBadges = append(child.NewBadges, badge)
+		}
+	}
+
+	days := map[string]bool{}
+	var stars float64
+	for _, result := range week {
+		child.Sessions++
+		child.Minutes += float64(result.DurationSeconds) / 60
+		days[result.CreatedAt.In(start.Location()).Format("2006-01-02")] = true
+		switch result.Activity {
+		case "spelling":
+			child.WordsPracticed += int(result.MaxScore)
+			child.WordsCorrect += int(result.Score)
+		case "yohaku", "sudoku":
+			child.PuzzlesSolved++
+		case "writing":
+			child.WritingPieces++
+			stars += result.Score / result.MaxScore * 5
+		}
+	}
+	child.Minutes = math.Round(child.Minutes)
+	child.ActiveDays = len(days)
+	if child.WritingPieces > 0 {
+		rating := math.Round(stars/float64(child.WritingPieces)*10) / 10
+		child.WritingRating = &rating
+	}
+	child.Activities = summarizeActivityResults(week)
+	return child
+}
+
+func parentReportSubject(report *ParentWeeklyReport, language string) string {
+	start, _ := time.Parse("2006-01-02", report.WeekStart)
+	return localize(language, "Puzzle Hub weekly report: week of %s", localizeDate(language, start))
+}
+
+// parentReportText gives the template the report's language: T localizes
+// a message and N picks the singular or plural message for a count
+type parentReportText struct{ language string }
+
+func (t parentReportText) T(format string, args ...interface{}) string {
+	return localize(t.language, format, args...)
+}
+
+func (t parentReportText) N(n int, singular, plural string) string {
+	return localizeCount(t.language, n, singular, plural)
+}
+
+var parentReportTemplate = template.Must(template.New("parent-report").Funcs(template.FuncMap{
+	"label": func(activity string) string { return activityLabels[activity] },
+	"value": func(f *float64) float64 { return *f },
+}).Parse(`<!DOCTYPE html>
+<html lang="{{.Language}}">
+<body style="margin:0;padding:0;background:#f4f6fb;font-family:Helvetica,Arial,sans-serif;color:#1f2937;">
+<div style="max-width:560px;margin:0 auto;padding:24px;">
+  <h1 style="font-size:22px;margin:0 0 4px;">{{if .ParentName}}{{$.T "Hi %s!" .ParentName}}{{else}}{{$.T "Hi!"}}{{end}} 👋</h1>
+  <p style="margin:0 0 20px;color:#6b7280;">{{$.T "Here's what happened on Puzzle Hub from %s to %s." .WeekStartLabel .WeekEndLabel}}</p>
+  {{range .Children}}
+  <div style="background:#ffffff;border-radius:12px;padding:20px;margin-bottom:16px;">
+    <h2 style="font-size:18px;margin:0 0 12px;">{{.ChildName}}</h2>
+    {{if eq .Sessions 0}}
+    <p style="margin:0;">{{$.T "A quiet week with no sessions. A puzzle or two this week is a great way to get going again!"}}</p>
+    {{else}}
+    <p style="margin:0 0 12px;">{{$.T "%s over %s, about %.0f minutes in all." ($.N .Sessions "%d session" "%d sessions") ($.N .ActiveDays "%d day" "%d days") .Minutes}}</p>
+    <table style="width:100%;border-collapse:collapse;font-size:14px;">
+      {{if .WordsPracticed}}<tr><td style="padding:4px 0;">📝 {{$.T "Spelling words practised"}}</td><td style="text-align:right;">{{$.T "%d (%d right)" .WordsPracticed .WordsCorrect}}</td></tr>{{end}}
+      {{if .PuzzlesSolved}}<tr><td style="padding:4px 0;">🧩 {{$.T "Puzzles solved"}}</td><td style="text-align:right;">{{.PuzzlesSolved}}</td></tr>{{end}}
+      {{if .WritingRating}}<tr><td style="padding:4px 0;">✍️ {{$.T "Writing"}}</td><td style="text-align:right;">{{$.N .WritingPieces "%d piece" "%d pieces"}}, {{$.T "%.1f ★ on average" (value .WritingRating)}}</td></tr>{{end}}
+      {{range .Activities}}<tr><td style="padding:4px 0;color:#6b7280;">{{$.T (label .Activity)}}</td><td style="text-align:right;color:#6b7280;">{{$.N .Sessions "%d session" "%d sessions"}}, {{$.T "%.0f%% average" .AveragePercent}}</td></tr>{{end}}
+    </table>
+    {{end}}
+    {{if .Streak.Current}}<p style="margin:12px 0 0;">🔥 {{$.T "On a %d-day streak (best ever: %d)." .Streak.Current .Streak.Longest}}</p>{{end}}
+    {{if .NewBadges}}<p style="margin:12px 0 0;">🏅 {{$.T "New badges:"}} {{range $i, $b := .NewBadges}}{{if $i}}, {{end}}<strong>{{$.T $b.Name}}</strong>{{end}}</p>{{end}}
+  </div>
+  {{else}}
+  <p>{{$.T "No children are linked to your account yet."}}</p>
+  {{end}}
+  <p style="font-size:12px;color:#9ca3af;">{{if .BaseURL}}<a href="{{.BaseURL}}" style="color:#9ca3af;">{{$.T "Open Puzzle Hub"}}</a> · {{end}}{{$.T "You can turn these emails off in Puzzle Hub settings."}}</p>
+</div>
+</body>
+</html>
+`))
+
+func renderParentReportHTML(report *ParentWeeklyReport, language string) (string, error) {
+	start, _ := time.Parse("2006-01-02", report.WeekStart)
+	end, _ := time.Parse("2006-01-02", report.WeekEnd)
+	data := struct {
+		*ParentWeeklyReport
+		parentReportText
+		Language, WeekStartLabel, WeekEndLabel, BaseURL string
+	}{
+		report, parentReportText{language},
+		language, localizeDate(language, start), localizeDate(language, end), strings.TrimSuffix(os.Getenv("BASE_URL"), "/"),
+	}
+
+	var buf bytes.Buffer
+	if err := parentReportTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render parent report: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// renderParentReportText is the plain-text part for mail clients without HTML
+func renderParentReportText(report *ParentWeeklyReport, language string) string {
+	var b strings.Builder
+	b.WriteString(localize(language, "Puzzle Hub weekly report, %s to %s", report.WeekStart, report.WeekEnd) + "\n")
+	for _, child := range report.Children {
+		fmt.Fprintf(&b, "\n== %s ==\n", child.ChildName)
+		if child.Sessions == 0 {
+			b.WriteString(translate(language, "No sessions this week.") + "\n")
+		} else {
+			b.WriteString(localize(language, "%s on %s, about %.0f minutes",
+				localizeCount(language, child.Sessions, "%d session", "%d sessions"),
+				localizeCount(language, child.ActiveDays, "%d day", "%d days"), child.Minutes) + "\n")
+		}
+		if child.WordsPracticed > 0 {
+			b.WriteString(localize(language, "Spelling words practised: %d (%d right)", child.WordsPracticed, child.WordsCorrect) + "\n")
+		}
+		if child.PuzzlesSolved > 0 {
+			b.WriteString(localize(language, "Puzzles solved: %d", child.PuzzlesSolved) + "\n")
+		}
+		if child.WritingRating != nil {
+			b.WriteString(localize(language, "Writing pieces: %d, rated %.1f/5 on average", child.WritingPieces, *child.WritingRating) + "\n")
+		}
+		if child.Streak.Current > 0 {
+			b.WriteString(localize(language, "Streak: %d days (best %d)", child.Streak.Current, child.Streak.Longest) + "\n")
+		}
+		for _, badge := range child.NewBadges {
+			b.WriteString(localize(language, "New badge: %s", translate(language, badge.Name)) + "\n")
+		}
+	}
+	b.WriteString("\n" + translate(language, "You can turn these emails off in Puzzle Hub settings.") + "\n")
+	return b.String()
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Parental controls and screen-time limits
+//
+// A parent creates a one-time invite code and the child redeems it from
+// their own account, linking the two. The parent then sets daily limits on
+// minutes played and/or puzzles finished. Usage is counted per day in the
+// child's timezone, and starting a new game once a limit is reached returns
+// a friendly "time's up" response instead.
+//
+// Minutes are timed on the server: starting a game opens a play session,
+// and saving its progress (or starting the next game) charges the time
+// since, up to maxPlaySession per game. The duration the client reports is
+// kept on the result but not counted.
+//
+// Playing on a child's browser marks it with a signed cookie, and the
+// child's limits then also apply to games started there signed out or as a
+// guest, with their time counted as the child's. This is a nudge rather
+// than a lock: clearing cookies, a private window or another browser gets
+// around it.
+
+const (
+	parentalInviteTTL = 24 * time.Hour
+
+	maxPlaySession = time.Hour
+
+	// playSessionDay keys the open game on the daily usage table
+	playSessionDay = "playing"
+
+	screenTimeCookieName   = "ph_child"
+	screenTimeCookieMaxAge = 365 * 24 * 60 * 60
+)
+
+type ParentalInvite struct {
+	Code        string    `json:"code" dynamodbav:"code"`
+	ParentID    string    `json:"-" dynamodbav:"parent_id"`
+	ParentName  string    `json:"-" dynamodbav:"parent_name"`
+	ParentEmail string    `json:"-" dynamodbav:"parent_email,omitempty"`
+	CreatedAt   time.Time `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt   int64     `json:"expires_at" dynamodbav:"expires_at"` // Unix seconds, also the table TTL
+}
+
+type ParentalLink struct {
+	ChildID       string    `json:"child_id" dynamodbav:"child_id"`
+	ChildName     string    `json:"child_name" dynamodbav:"child_name"`
+	ParentID      string    `json:"parent_id" dynamodbav:"parent_id"`
+	ParentName    string    `json:"parent_name" dynamodbav:"parent_name"`
+	ParentEmail   string    `json:"-" dynamodbav:"parent_email,omitempty"`    // For the weekly report; missing on links made before it was recorded
+	DailyMinutes  int       `json:"daily_minutes" dynamodbav:"daily_minutes"` // 0 means no limit
+	DailyPuzzles  int       `json:"daily_puzzles" dynamodbav:"daily_puzzles"` // 0 means no limit
+	LinkedAt      time.Time `json:"linked_at" dynamodbav:"linked_at"`
+	LimitsUpdated time.Time `json:"limits_updated_at,omitempty" dynamodbav:"limits_updated_at"`
+}
+
+type DailyUsage struct {
+	UserID    string `json:"-" dynamodbav:"user_id"`
+	Day       string `json:"day" dynamodbav:"day"` // YYYY-MM-DD in the user's timezone
+	Seconds   int    `json:"seconds" dynamodbav:"seconds"`
+	Puzzles   int    `json:"puzzles" dynamodbav:"puzzles"`
+	ExpiresAt int64  `json:"-" dynamodbav:"expires_at"`
+}
+
+type AcceptParentalInviteRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// UpdateScreenTimeRequest is a partial update; 0 removes a limit
+type UpdateScreenTimeRequest struct {
+	DailyMinutes *int `json:"daily_minutes"`
+	DailyPuzzles *int `json:"daily_puzzles"`
+}
+
+type ChildOverview struct {
+	ParentalLink
+	Today DailyUsage `json:"today"`
+}
+
+// createParentalInvite issues a code the child enters to link accounts
+func (h *PuzzleHub) createParentalInvite(c *gin.Context) {
+	parent := c.MustGet("user").(*User)
+
+	code, err := randomJoinCode()
+	if err != nil {
+		requestLogger(c).Error("error generating parental invite", "error", err)
+		respondStorageError(c, err, "Failed to create invite")
+		return
+	}
+
+	now := time.Now()
+	invite := ParentalInvite{
+		Code:        code,
+		ParentID:    parent.ID,
+		ParentName:  parent.Name,
+		ParentEmail: parent.Email,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(parentalInviteTTL).Unix(),
+	}
+	item, err := dynamodbattribute.MarshalMap(invite)
+	if err != nil {
+		requestLogger(c).Error("error marshaling parental invite", "error", err)
+		respondStorageError(c, err, "Failed to create invite")
+		return
+	}
+
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-parental-invites")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(code)"),
+	})
+	if err != nil {
+		requestLogger(c).Error("error saving parental invite", "error", err)
+		respondStorageError(c, err, "Failed to create invite")
+		return
+	}
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+// acceptParentalInvite links the signed-in child to the inviting parent
+func (h *PuzzleHub) acceptParentalInvite(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	child := user.(*User)
+
+	var request AcceptParentalInviteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(request.Code))
+
+	// Deleting the invite consumes it, so each code links exactly one child
+	result, err := h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-parental-invites")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"code": {S: aws.String(code)},
+		},
+		ConditionExpression: aws.String("attribute_exists(code) AND expires_at > :now AND parent_id <> :child_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":      {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+			":child_id": {S: aws.String(child.ID)},
+		},
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusNotFound, "That code is invalid or has expired")
+			return
+		}
+		requestLogger(c).Error("error redeeming parental invite", "error", err)
+		respondStorageError(c, err, "Failed to link accounts")
+		return
+	}
+
+	var invite ParentalInvite
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &invite); err != nil {
+		requestLogger(c).Error("error unmarshaling parental invite", "error", err)
+		respondStorageError(c, err, "Failed to link accounts")
+		return
+	}
+
+	link := ParentalLink{
+		ChildID:     child.ID,
+		ChildName:   child.Name,
+		ParentID:    invite.ParentID,
+		ParentName:  invite.ParentName,
+		ParentEmail: invite.ParentEmail,
+		LinkedAt:    time.Now(),
+	}
+	if err := h.putParentalLink(&link, "attribute_not_exists(child_id)"); err != nil {
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusConflict, "This account is already linked to a parent")
+			return
+		}
+		requestLogger(c).Error("error saving parental link", "error", err)
+		respondStorageError(c, err, "Failed to link accounts")
+		return
+	}
+
+	log.Printf("👪 %s linked child %s", invite.ParentID, child.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Linked to " + invite.ParentName,
+		"link":    link,
+	})
+}
+
+// getChildren lists the parent's children with their limits and today's usage
+func (h *PuzzleHub) getChildren(c *gin.Context) {
+	parent := c.MustGet("user").(*User)
+
+	links, err := h.listChildLinks(parent.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching children", "error", err, "parent_id", parent.ID)
+		respondStorageError(c, err, "Failed to fetch children")
+		return
+	}
+
+	children := []ChildOverview{}
+	for _, link := range links {
+		usage, err := h.getDailyUsage(link.ChildID, h.childToday(link.ChildID))
+		if err != nil {
+			requestLogger(c).Error("error fetching usage", "error", err, "child_id", link.ChildID)
+			respondStorageError(c, err, "Failed to fetch children")
+			return
+		}
+		children = append(children, ChildOverview{ParentalLink: link, Today: *usage})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"children": children,
+		"count":    len(children),
+	})
+}
+
+// updateScreenTimeLimits sets a child's daily limits
+func (h *PuzzleHub) updateScreenTimeLimits(c *gin.Context) {
+	link, ok := h.requireParentOf(c)
+	if !ok {
+		return
+	}
+
+	var request UpdateScreenTimeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if request.DailyMinutes != nil {
+		if *request.DailyMinutes < 0 || *request.DailyMinutes > 24*60 {
+			respondError(c, http.StatusBadRequest, "Daily minutes must be between 0 and 1440")
+			return
+		}
+		link.DailyMinutes = *request.DailyMinutes
+	}
+	if request.DailyPuzzles != nil {
+		if *request.DailyPuzzles < 0 || *request.DailyPuzzles > 1000 {
+			respondError(c, http.StatusBadRequest, "Daily puzzles must be between 0 and 1000")
+			return
+		}
+		link.DailyPuzzles = *request.DailyPuzzles
+	}
+	link.LimitsUpdated = time.Now()
+
+	if err := h.putParentalLink(link, ""); err != nil {
+		requestLogger(c).Error("error saving limits", "error", err, "child_id", link.ChildID)
+		respondStorageError(c, err, "Failed to update limits")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Limits updated",
+		"link":    link,
+	})
+}
+
+// unlinkChild removes parental control from a child account
+func (h *PuzzleHub) unlinkChild(c *gin.Context) {
+	link, ok := h.requireParentOf(c)
+	if !ok {
+		return
+	}
+
+	_, err := h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-parental-links")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"child_id": {S: aws.String(link.ChildID)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error unlinking child", "error", err, "child_id", link.ChildID)
+		respondStorageError(c, err, "Failed to unlink child")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Child unlinked"})
+}
+
+// screenTimeMiddleware stops a linked child from starting new games once a
+// daily limit is reached, and opens a play session for the game. Requests
+// without a signed-in user pass through unless the browser is a child's.
+func (h *PuzzleHub) screenTimeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		player, onDevice := h.screenTimePlayer(c)
+		if player == nil {
+			c.Next()
+			return
+		}
+
+		link, err := h.getParentalLink(player.ID)
+		if err != nil {
+			// Fail open: a lookup error shouldn't lock kids out of the games
+			requestLogger(c).Error("error checking screen time", "error", err)
+			c.Next()
+			return
+		}
+		if link == nil && onDevice {
+			// Unlinked since the cookie was set
+			h.setScreenTimeCookie(c, "", -1)
+			c.Next()
+			return
+		}
+		if link != nil && !onDevice {
+			if device, err := h.signScreenTimeDevice(player.ID); err != nil {
+				requestLogger(c).Error("error signing screen time cookie", "error", err)
+			} else {
+				h.setScreenTimeCookie(c, device, screenTimeCookieMaxAge)
+			}
+		}
+
+		if link != nil && (link.DailyMinutes > 0 || link.DailyPuzzles > 0) {
+			usage, err := h.getDailyUsage(player.ID, userToday(player))
+			if err != nil {
+				requestLogger(c).Error("error checking screen time", "error", err)
+				c.Next()
+				return
+			}
+
+			if reason := screenTimeExceeded(link, usage, requestLanguage(c)); reason != "" {
+				abortWithError(c, http.StatusForbidden, "time_up",
+					tr(c, "Time's up for today! %s Come back tomorrow for more puzzles.", reason),
+					gin.H{
+						"usage": usage,
+						"limits": gin.H{
+							"daily_minutes": link.DailyMinutes,
+							"daily_puzzles": link.DailyPuzzles,
+						},
+					})
+				return
+			}
+		}
+
+		h.startPlaySession(player)
+		c.Next()
+	}
+}
+
+// screenTimePlayer returns whose screen time a game counts against: the
+// signed-in user, or when nobody or only a guest is signed in, the child
+// whose cookie the browser carries (reported by onDevice)
+func (h *PuzzleHub) screenTimePlayer(c *gin.Context) (*User, bool) {
+	var user *User
+	if value, exists := c.Get("user"); exists {
+		user = value.(*User)
+		if !isGuestID(user.ID) {
+			return user, false
+		}
+	}
+
+	if device, err := c.Cookie(screenTimeCookieName); err == nil {
+		if childID, err := h.parseScreenTimeDevice(device); err == nil {
+			return &User{ID: childID, Timezone: h.savedTimezone(childID)}, true
+		}
+	}
+	return user, false
+}
+
+// signScreenTimeDevice returns the cookie value marking a child's browser
+func (h *PuzzleHub) signScreenTimeDevice(childID string) (string, error) {
+	kid, secret := h.AuthConfig.JWTKeys.signingKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"child_id": childID,
+		"iat":      time.Now().Unix(),
+	})
+	token.Header["kid"] = kid
+	return token.SignedString(secret)
+}
+
+// parseScreenTimeDevice returns the child a device cookie was issued for.
+// Cookies signed with a key since rotated out stop matching until the child
+// plays signed in again.
+func (h *PuzzleHub) parseScreenTimeDevice(device string) (string, error) {
+	token, err := jwt.Parse(device, h.jwtKeyFunc)
+	if err != nil {
+		return "", err
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	childID, _ := claims["child_id"].(string)
+	if childID == "" {
+		return "", fmt.Errorf("not a screen time cookie")
+	}
+	return childID, nil
+}
+
+func (h *PuzzleHub) setScreenTimeCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     screenTimeCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.AuthConfig != nil && h.AuthConfig.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// screenTimeExceeded explains in language which limit was hit, or returns ""
+func screenTimeExceeded(link *ParentalLink, usage *DailyUsage, language string) string {
+	if link.DailyMinutes > 0 && usage.Seconds >= link.DailyMinutes*60 {
+		return localize(language, "You've played for %d minutes.", link.DailyMinutes)
+	}
+	if link.DailyPuzzles > 0 && usage.Puzzles >= link.DailyPuzzles {
+		return localize(language, "You've finished %d puzzles.", link.DailyPuzzles)
+	}
+	return ""
+}
+
+// startPlaySession opens a game for user, first charging the game still
+// open, if any, to today's usage
+func (h *PuzzleHub) startPlaySession(user *User) {
+	now := time.Now()
+	result, err := h.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-daily-usage")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(user.ID)},
+			"day":     {S: aws.String(playSessionDay)},
+		},
+		UpdateExpression: aws.String("SET started_at = :now, expires_at = :expires_at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":        {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			":expires_at": {N: aws.String(strconv.FormatInt(now.AddDate(0, 0, 1).Unix(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedOld),
+	})
+	if errors.Is(err, errDynamoDBOffline) {
+		return // Nothing to time without DynamoDB
+	}
+	if err != nil {
+		log.Printf("Error starting play session for %s: %v", user.ID, err)
+		return
+	}
+	if seconds := playSessionSeconds(result.Attributes, now); seconds > 0 {
+		h.addDailyUsage(user, seconds, 0)
+	}
+}
+
+// recordDailyUsage adds a finished activity to the user's usage for today,
+// with the time since its game started
+func (h *PuzzleHub) recordDailyUsage(user *User) {
+	result, err := h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-daily-usage")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(user.ID)},
+			"day":     {S: aws.String(playSessionDay)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if errors.Is(err, errDynamoDBOffline) {
+		return
+	}
+	seconds := 0
+	if err != nil {
+		log.Printf("Error ending play session for %s: %v", user.ID, err)
+	} else {
+		seconds = playSessionSeconds(result.Attributes, time.Now())
+	}
+	h.addDailyUsage(user, seconds, 1)
+}
+
+// playSessionSeconds is the time to charge for a play session item's game
+func playSessionSeconds(item map[string]*dynamodb.AttributeValue, now time.Time) int {
+	value, ok := item["started_at"]
+	if !ok || value.N == nil {
+		return 0
+	}
+	startedAt, err := strconv.ParseInt(*value.N, 10, 64)
+	if err != nil {
+		return 0
+	}
+	elapsed := min(max(now.Sub(time.Unix(startedAt, 0)), 0), maxPlaySession)
+	return int(elapsed / time.Second)
+}
+
+func (h *PuzzleHub) addDailyUsage(user *User, seconds, puzzles int) {
+	_, err := h.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-daily-usage")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(user.ID)},
+			"day":     {S: aws.String(userToday(user))},
+		},
+		UpdateExpression: aws.String("ADD seconds :seconds, puzzles :puzzles SET expires_at = :expires_at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":seconds":    {N: aws.String(strconv.Itoa(seconds))},
+			":puzzles":    {N: aws.String(strconv.Itoa(puzzles))},
+			":expires_at": {N: aws.String(strconv.FormatInt(time.Now().AddDate(0, 0, 35).Unix(), 10))},
+		},
+	})
+	if err != nil {
+		log.Printf("Error recording usage for %s: %v", user.ID, err)
+	}
+}
+
+// requireParentOf loads the link for :childId and checks the caller is its parent
+func (h *PuzzleHub) requireParentOf(c *gin.Context) (*ParentalLink, bool) {
+	parent := c.MustGet("user").(*User)
+
+	link, err := h.getParentalLink(c.Param("childId"))
+	if err != nil {
+		requestLogger(c).Error("error fetching parental link", "error", err)
+		respondStorageError(c, err, "Failed to fetch child")
+		return nil, false
+	}
+	if link == nil || link.ParentID != parent.ID {
+		respondError(c, http.StatusNotFound, "Child not found")
+		return nil, false
+	}
+	return link, true
+}
+
+func (h *PuzzleHub) getParentalLink(childID string) (*ParentalLink, error) {
+	result, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-parental-links")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"child_id": {S: aws.String(childID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var link ParentalLink
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &link); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal parental link: %v", err)
+	}
+	return &link, nil
+}
+
+// listChildLinks returns the links of all of a parent's children
+func (h *PuzzleHub) listChildLinks(parentID string) ([]ParentalLink, error) {
+	return queryAll[ParentalLink](h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-parental-links")),
+		IndexName:              aws.String("parent_id-index"),
+		KeyConditionExpression: aws.String("parent_id = :parent_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":parent_id": {S: aws.String(parentID)},
+		},
+	})
+}
+
+func (h *PuzzleHub) putParentalLink(link *ParentalLink, condition string) error {
+	item, err := dynamodbattribute.MarshalMap(link)
+	if err != nil {
+		return fmt.Errorf("failed to marshal parental link: %v", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-parental-links")),
+		Item:      item,
+	}
+	if condition != "" {
+		input.ConditionExpression = aws.String(condition)
+	}
+	_, err = h.DynamoDB.PutItem(input)
+	return err
+}
+
+// getDailyUsage returns the usage for one day, zero if nothing was recorded
+func (h *PuzzleHub) getDailyUsage(userID, day string) (*DailyUsage, error) {
+	result, err := h.DynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-daily-usage")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+			"day":     {S: aws.String(day)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &DailyUsage{UserID: userID, Day: day}
+	if result.Item != nil {
+		if err := dynamodbattribute.UnmarshalMap(result.Item, usage); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal daily usage: %v", err)
+		}
+	}
+	return usage, nil
+}
+
+// childToday returns today's date in the child's timezone, for parents
+// viewing usage from a different zone
+func (h *PuzzleHub) childToday(childID string) string {
+	return userToday(&User{ID: childID, Timezone: h.savedTimezone(childID)})
+}
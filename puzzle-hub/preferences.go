@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// User preferences shared by every app in the hub, so defaults like
+// difficulty and voice don't have to be asked again each session
+
+var validThemes = map[string]bool{
+	"light":  true,
+	"dark":   true,
+	"system": true,
+}
+
+var validDifficulties = map[DifficultyLevel]bool{
+	Elementary:   true,
+	Middle:       true,
+	Intermediate: true,
+	Advanced:     true,
+}
+
+type EmailPreferences struct {
+	ProductUpdates  bool `json:"product_updates" dynamodbav:"product_updates"`
+	ProgressReports bool `json:"progress_reports" dynamodbav:"progress_reports"`
+	Notifications   bool `json:"notifications" dynamodbav:"notifications"` // Email copies of notifications
+}
+
+type UserPreferences struct {
+	UserID            string                `json:"-" dynamodbav:"user_id"`
+	DefaultDifficulty DifficultyLevel       `json:"default_difficulty" dynamodbav:"default_difficulty"`
+	Theme             string                `json:"theme" dynamodbav:"theme"`
+	Timezone          string                `json:"timezone,omitempty" dynamodbav:"timezone,omitempty"`
+	TTSVoice          string                `json:"tts_voice,omitempty" dynamodbav:"tts_voice,omitempty"`
+	Language          string                `json:"language,omitempty" dynamodbav:"language,omitempty"` // Empty follows Accept-Language, see i18n.go
+	Email             EmailPreferences      `json:"email" dynamodbav:"email"`
+	Accessibility     AccessibilitySettings `json:"accessibility" dynamodbav:"accessibility"` // See accessibility.go
+	UpdatedAt         time.Time             `json:"updated_at,omitempty" dynamodbav:"updated_at"`
+}
+
+// UpdatePreferencesRequest is a partial update; omitted fields are unchanged
+type UpdatePreferencesRequest struct {
+	DefaultDifficulty *DifficultyLevel `json:"default_difficulty"`
+	Theme             *string          `json:"theme"`
+	Timezone          *string          `json:"timezone"`
+	TTSVoice          *string          `json:"tts_voice"`
+	Language          *string          `json:"language"`
+	Email             *struct {
+		ProductUpdates  *bool `json:"product_updates"`
+		ProgressReports *bool `json:"progress_reports"`
+		Notifications   *bool `json:"notifications"`
+	} `json:"email"`
+	Accessibility *UpdateAccessibilityRequest `json:"accessibility"`
+}
+
+func defaultPreferences(userID string) *UserPreferences {
+	return &UserPreferences{
+		UserID:            userID,
+		DefaultDifficulty: Elementary,
+		Theme:             "system",
+	}
+}
+
+func (h *PuzzleHub) getPreferences(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	prefs, err := h.getUserPreferences(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching preferences", "error", err)
+		respondStorageError(c, err, "Failed to fetch preferences")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": prefs})
+}
+
+func (h *PuzzleHub) updatePreferences(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	var request UpdatePreferencesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	prefs, err := h.getUserPreferences(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching preferences", "error", err)
+		respondStorageError(c, err, "Failed to update preferences")
+		return
+	}
+
+	if request.DefaultDifficulty != nil {
+		if !validDifficulties[*request.DefaultDifficulty] {
+			respondError(c, http.StatusBadRequest, "Difficulty must be elementary, middle, intermediate or advanced")
+			return
+		}
+		prefs.DefaultDifficulty = *request.DefaultDifficulty
+	}
+	if request.Theme != nil {
+		if !validThemes[*request.Theme] {
+			respondError(c, http.StatusBadRequest, "Theme must be light, dark or system")
+			return
+		}
+		prefs.Theme = *request.Theme
+	}
+	if request.Timezone != nil {
+		if *request.Timezone != "" {
+			if _, err := time.LoadLocation(*request.Timezone); err != nil {
+				respondError(c, http.StatusBadRequest, "Unknown timezone: "+*request.Timezone)
+				return
+			}
+		}
+		prefs.Timezone = *request.Timezone
+	}
+	if request.TTSVoice != nil {
+		if len(*request.TTSVoice) > 100 {
+			respondError(c, http.StatusBadRequest, "TTS voice name is too long")
+			return
+		}
+		prefs.TTSVoice = *request.TTSVoice
+	}
+	if request.Language != nil {
+		if *request.Language != "" && languageNames[*request.Language] == "" {
+			respondError(c, http.StatusBadRequest, "Language must be en, es or hi")
+			return
+		}
+		prefs.Language = *request.Language
+	}
+	if request.Accessibility != nil {
+		if problem := request.Accessibility.apply(&prefs.Accessibility); problem != "" {
+			respondError(c, http.StatusBadRequest, problem)
+			return
+		}
+	}
+	if request.Email != nil {
+		if request.Email.ProductUpdates != nil {
+			prefs.Email.ProductUpdates = *request.Email.ProductUpdates
+		}
+		if request.Email.ProgressReports != nil {
+			prefs.Email.ProgressReports = *request.Email.ProgressReports
+		}
+		if request.Email.Notifications != nil {
+			prefs.Email.Notifications = *request.Email.Notifications
+		}
+	}
+
+	if err := h.putUserPreferences(prefs); err != nil {
+		requestLogger(c).Error("error saving preferences", "error", err)
+		respondStorageError(c, err, "Failed to update preferences")
+		return
+	}
+	userObj.Timezone = prefs.Timezone
+	userObj.Language = prefs.Language
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     tr(c, "Preferences updated successfully"),
+		"preferences": prefs,
+	})
+}
+
+// getUserPreferences returns the stored preferences, or the defaults for a
+// user who hasn't saved any
+func (h *PuzzleHub) getUserPreferences(userID string) (*UserPreferences, error) {
+	prefs := defaultPreferences(userID)
+	if _, err := h.Store.GetUserPreferences(userID, prefs); err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+func (h *PuzzleHub) putUserPreferences(prefs *UserPreferences) error {
+	prefs.UpdatedAt = time.Now()
+	if err := h.Store.PutUserPreferences(prefs); err != nil {
+		return fmt.Errorf("failed to store preferences: %v", err)
+	}
+	return nil
+}
+
+// savedTimezone returns the timezone from the user's preferences, if any,
+// so it survives restarts of the in-memory user store
+func (h *PuzzleHub) savedTimezone(userID string) string {
+	prefs, err := h.getUserPreferences(userID)
+	if err != nil {
+		log.Printf("Error fetching preferences for %s: %v", userID, err)
+		return ""
+	}
+	return prefs.Timezone
+}
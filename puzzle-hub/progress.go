@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Learning progress: clients report a result when a spelling, writing,
+// story, yohaku or sudoku session finishes; math fact drills, quizzes and
+// flashcard reviews record theirs on submit.
+// Classrooms aggregate these per student.
+
+var progressActivities = map[string]bool{
+	"spelling":   true,
+	"writing":    true,
+	"story":      true,
+	"yohaku":     true,
+	"math_facts": true,
+	"sudoku":     true,
+	"quiz":       true,
+	"flashcards": true,
+}
+
+type ActivityResult struct {
+	UserID          string    `json:"user_id" dynamodbav:"user_id"`
+	ResultID        string    `json:"id" dynamodbav:"result_id"` // <activity>#<unix nanos>
+	Activity        string    `json:"activity" dynamodbav:"activity"`
+	Score           float64   `json:"score" dynamodbav:"score"`
+	MaxScore        float64   `json:"max_score" dynamodbav:"max_score"`
+	DurationSeconds int       `json:"duration_seconds,omitempty" dynamodbav:"duration_seconds,omitempty"`
+	CreatedAt       time.Time `json:"created_at" dynamodbav:"created_at"`
+	XPAwarded       int       `json:"xp_awarded,omitempty" dynamodbav:"-"` // Only set on the response that records it
+}
+
+type RecordProgressRequest struct {
+	Activity        string  `json:"activity" binding:"required"`
+	Score           float64 `json:"score"`
+	MaxScore        float64 `json:"max_score" binding:"required"`
+	DurationSeconds int     `json:"duration_seconds"`
+}
+
+type ActivitySummary struct {
+	Activity       string    `json:"activity"`
+	Sessions       int       `json:"sessions"`
+	AveragePercent float64   `json:"average_percent"`
+	BestPercent    float64   `json:"best_percent"`
+	TotalMinutes   float64   `json:"total_minutes"`
+	LastActiveAt   time.Time `json:"last_active_at"`
+}
+
+func (h *PuzzleHub) recordProgress(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	var request RecordProgressRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !progressActivities[request.Activity] {
+		respondError(c, http.StatusBadRequest, "Activity must be spelling, writing, story, yohaku, math_facts, sudoku, quiz or flashcards")
+		return
+	}
+	if request.MaxScore <= 0 || request.Score < 0 || request.Score > request.MaxScore {
+		respondError(c, http.StatusBadRequest, "Score must be between 0 and max_score")
+		return
+	}
+	if request.DurationSeconds < 0 || request.DurationSeconds > 24*60*60 {
+		respondError(c, http.StatusBadRequest, "Duration must be between 0 and 86400 seconds")
+		return
+	}
+
+	result, err := h.saveActivityResult(userObj, request.Activity, request.Score, request.MaxScore, request.DurationSeconds)
+	if err != nil {
+		requestLogger(c).Error("error saving activity result", "error", err)
+		respondStorageError(c, err, "Failed to record progress")
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// saveActivityResult stores a finished session, counts it towards the
+// user's screen time, the site's puzzle count and any homework
+// assignments, awards XP and sends it to the player's results webhook
+func (h *PuzzleHub) saveActivityResult(user *User, activity string, score, maxScore float64, durationSeconds int) (*ActivityResult, error) {
+	now := time.Now()
+	result := ActivityResult{
+		UserID:          user.ID,
+		ResultID:        fmt.Sprintf("%s#%d", activity, now.UnixNano()),
+		Activity:        activity,
+		Score:           score,
+		MaxScore:        maxScore,
+		DurationSeconds: durationSeconds,
+		CreatedAt:       now,
+	}
+
+	item, err := dynamodbattribute.MarshalMap(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal activity result: %v", err)
+	}
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-activity-results")),
+		Item:      item,
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.recordDailyUsage(user)
+	h.Analytics.RecordPuzzle(user.ID)
+	h.recordAssignmentProgress(context.Background(), user, &result)
+	result.XPAwarded = h.awardXP(context.Background(), user, &result)
+	h.queueResultsWebhook(context.Background(), user, &result)
+	return &result, nil
+}
+
+// getMyProgress summarizes the current user's results per activity
+func (h *PuzzleHub) getMyProgress(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	results, err := h.getActivityResults(userObj.ID, time.Time{})
+	if err != nil {
+		requestLogger(c).Error("error fetching progress", "error", err)
+		respondStorageError(c, err, "Failed to fetch progress")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"activities": summarizeActivityResults(results),
+		"sessions":   len(results),
+	})
+}
+
+// getActivityResults returns the user's results created at or after since
+func (h *PuzzleHub) getActivityResults(userID string, since time.Time) ([]ActivityResult, error) {
+	stored, err := queryAll[ActivityResult](h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-activity-results")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := []ActivityResult{}
+	for _, result := range stored {
+		if !result.CreatedAt.Before(since) {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// summarizeActivityResults returns one summary per activity, in a fixed order
+func summarizeActivityResults(results []ActivityResult) []ActivitySummary {
+	byActivity := make(map[string]*ActivitySummary)
+	percentTotals := make(map[string]float64)
+	for _, result := range results {
+		summary, ok := byActivity[result.Activity]
+		if !ok {
+			summary = &ActivitySummary{Activity: result.Activity}
+			byActivity[result.Activity] = summary
+		}
+
+		percent := result.Score / result.MaxScore * 100
+		summary.Sessions++
+		percentTotals[result.Activity] += percent
+		summary.BestPercent = math.Max(summary.BestPercent, percent)
+		summary.TotalMinutes += float64(result.DurationSeconds) / 60
+		if result.CreatedAt.After(summary.LastActiveAt) {
+			summary.LastActiveAt = result.CreatedAt
+		}
+	}
+
+	summaries := []ActivitySummary{}
+	for _, activity := range []string{"spelling", "writing", "story", "yohaku", "math_facts", "sudoku", "quiz", "flashcards"} {
+		summary, ok := byActivity[activity]
+		if !ok {
+			continue
+		}
+		summary.AveragePercent = math.Round(percentTotals[activity]/float64(summary.Sessions)*10) / 10
+		summary.BestPercent = math.Round(summary.BestPercent*10) / 10
+		summary.TotalMinutes = math.Round(summary.TotalMinutes*10) / 10
+		summaries = append(summaries, *summary)
+	}
+	return summaries
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Progress dashboard
+//
+// GET /progress/summary puts everything the hub home page shows in one
+// response, built from the activity results in progress.go: per-activity
+// totals with weekly accuracy trends, the daily streak, badges and the
+// latest sessions. Days and weeks (starting Monday) are in the player's
+// timezone. Badges aren't stored; they're worked out from the results
+// each time, along with when each was earned. Parents get the same view of
+// a linked child.
+
+const (
+	dashboardTrendWeeks   = 8
+	dashboardRecentLimit  = 10
+	dashboardExplorerApps = 4
+)
+
+type ActivityTrendPoint struct {
+	WeekStart      string   `json:"week_start"` // Monday, YYYY-MM-DD
+	Sessions       int      `json:"sessions"`
+	AveragePercent *float64 `json:"average_percent,omitempty"` // nil in weeks without sessions
+}
+
+type ActivityDashboard struct {
+	ActivitySummary
+	Trend []ActivityTrendPoint `json:"trend"` // Oldest week first
+}
+
+type ProgressStreak struct {
+	Current     int  `json:"current"` // Days in a row up to today, or yesterday if today has none yet
+	Longest     int  `json:"longest"`
+	ActiveDays  int  `json:"active_days"`
+	ActiveToday bool `json:"active_today"`
+}
+
+type ProgressBadge struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	EarnedAt    time.Time `json:"earned_at"`
+}
+
+type ProgressDashboard struct {
+	TotalSessions int                 `json:"total_sessions"`
+	TotalMinutes  float64             `json:"total_minutes"`
+	Activities    []ActivityDashboard `json:"activities"`
+	Streak        ProgressStreak      `json:"streak"`
+	Badges        []ProgressBadge     `json:"badges"`
+	Recent        []ActivityResult    `json:"recent"` // Newest first
+}
+
+// badgeStats is what badges are checked against, as of one result
+type badgeStats struct {
+	sessions      int
+	activities    map[string]bool
+	stories       int
+	longestStreak int
+	perfect       bool
+}
+
+var progressBadges = []struct {
+	ID, Name, Description string
+	earned                func(stats badgeStats) bool
+}{
+	{"first-steps", "First steps", "Finish your first session", func(s badgeStats) bool { return s.sessions >= 1 }},
+	{"ten-sessions", "Getting going", "Finish 10 sessions", func(s badgeStats) bool { return s.sessions >= 10 }},
+	{"hundred-sessions", "Century", "Finish 100 sessions", func(s badgeStats) bool { return s.sessions >= 100 }},
+	{"perfect-score", "Perfect score", "Get everything right in a session", func(s badgeStats) bool { return s.perfect }},
+	{"streak-3", "On a roll", "Play 3 days in a row", func(s badgeStats) bool { return s.longestStreak >= 3 }},
+	{"streak-7", "Week warrior", "Play 7 days in a row", func(s badgeStats) bool { return s.longestStreak >= 7 }},
+	{"streak-30", "Unstoppable", "Play 30 days in a row", func(s badgeStats) bool { return s.longestStreak >= 30 }},
+	{"explorer", "Explorer", "Try 4 different activities", func(s badgeStats) bool { return len(s.activities) >= dashboardExplorerApps }},
+	{"storyteller", "Storyteller", "Finish 5 stories", func(s badgeStats) bool { return s.stories >= 5 }},
+}
+
+// getProgressSummary is the dashboard for the current user
+func (h *PuzzleHub) getProgressSummary(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	h.respondProgressDashboard(c, userObj)
+}
+
+// getChildProgressSummary is the dashboard for one of the parent's children
+func (h *PuzzleHub) getChildProgressSummary(c *gin.Context) {
+	link, ok := h.requireParentOf(c)
+	if !ok {
+		return
+	}
+	h.respondProgressDashboard(c, &User{ID: link.ChildID, Timezone: h.savedTimezone(link.ChildID)})
+}
+
+func (h *PuzzleHub) respondProgressDashboard(c *gin.Context, user *User) {
+	results, err := h.getActivityResults(user.ID, time.Time{})
+	if err != nil {
+		requestLogger(c).Error("error fetching progress", "error", err, "target_user_id", user.ID)
+		respondStorageError(c, err, "Failed to fetch progress")
+		return
+	}
+	c.JSON(http.StatusOK, buildProgressDashboard(results, userLocation(user), time.Now()))
+}
+
+func buildProgressDashboard(results []ActivityResult, loc *time.Location, now time.Time) ProgressDashboard {
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.Before(results[j].CreatedAt) })
+
+	dashboard := ProgressDashboard{
+		TotalSessions: len(results),
+		Activities:    []ActivityDashboard{},
+		Badges:        []ProgressBadge{},
+		Recent:        []ActivityResult{},
+	}
+
+	// Weekly trend buckets, oldest first
+	today := now.In(loc)
+	thisWeek := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+	thisWeek = thisWeek.AddDate(0, 0, -((int(thisWeek.Weekday()) + 6) % 7))
+	firstWeek := thisWeek.AddDate(0, 0, -7*(dashboardTrendWeeks-1))
+	type bucket struct {
+		sessions int
+		percent  float64
+	}
+	trends := map[string][]bucket{}
+
+	stats := badgeStats{activities: map[string]bool{}}
+	earned := map[string]bool{}
+	days := map[string]bool{}
+	var lastDay time.Time
+	run := 0
+	for _, result := range results {
+		dashboard.TotalMinutes += float64(result.DurationSeconds) / 60
+		percent := result.Score / result.MaxScore * 100
+
+		at := result.CreatedAt.In(loc)
+		day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, loc)
+		if !days[day.Format("2006-01-02")] {
+			days[day.Format("2006-01-02")] = true
+			if !lastDay.IsZero() && day.Equal(lastDay.AddDate(0, 0, 1)) {
+				run++
+			} else {
+				run = 1
+			}
+			lastDay = day
+			stats.longestStreak = max(stats.longestStreak, run)
+		}
+
+		if !day.Before(firstWeek) {
+			week := int(math.Round(day.Sub(firstWeek).Hours()/24)) / 7
+			if week < dashboardTrendWeeks {
+				if trends[result.Activity] == nil {
+					trends[result.Activity] = make([]bucket, dashboardTrendWeeks)
+				}
+				trends[result.Activity][week].sessions++
+				trends[result.Activity][week].percent += percent
+			}
+		}
+
+		stats.sessions++
+		stats.activities[result.Activity] = true
+		if result.Activity == "story" {
+			stats.stories++
+		}
+		if result.Score >= result.MaxScore {
+			stats.perfect = true
+		}
+		for _, badge := range progressBadges {
+			if !earned[badge.ID] && badge.earned(stats) {
+				earned[badge.ID] = true
+				dashboard.Badges = append(dashboard.Badges, ProgressBadge{
+					ID: badge.ID, Name: badge.Name, Description: badge.Description, EarnedAt: result.CreatedAt,
+				})
+			}
+		}
+	}
+	dashboard.TotalMinutes = math.Round(dashboard.TotalMinutes*10) / 10
+
+	for _, summary := range summarizeActivityResults(results) {
+		activity := ActivityDashboard{ActivitySummary: summary, Trend: make([]ActivityTrendPoint, dashboardTrendWeeks)}
+		buckets := trends[summary.Activity]
+		for week := range activity.Trend {
+			activity.Trend[week].WeekStart = firstWeek.AddDate(0, 0, 7*week).Format("2006-01-02")
+			if buckets == nil || buckets[week].sessions == 0 {
+				continue
+			}
+			average := math.Round(buckets[week].percent/float64(buckets[week].sessions)*10) / 10
+			activity.Trend[week].Sessions = buckets[week].sessions
+			activity.Trend[week].AveragePercent = &average
+		}
+		dashboard.Activities = append(dashboard.Activities, activity)
+	}
+
+	// The streak is still alive until a whole day passes without a session
+	yesterday := time.Date(today.Year(), today.Month(), today.Day()-1, 0, 0, 0, 0, loc)
+	dashboard.Streak = ProgressStreak{
+		Longest:     stats.longestStreak,
+		ActiveDays:  len(days),
+		ActiveToday: days[today.Format("2006-01-02")],
+	}
+	if dashboard.Streak.ActiveToday || days[yesterday.Format("2006-01-02")] {
+		dashboard.Streak.Current = run
+	}
+
+	for i := len(results) - 1; i >= 0 && len(dashboard.Recent) < dashboardRecentLimit; i-- {
+		dashboard.Recent = append(dashboard.Recent, results[i])
+	}
+	return dashboard
+}
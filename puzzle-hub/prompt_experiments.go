@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Prompt A/B experiments
+//
+// An experiment runs two versions of one prompt template side by side
+// (0 meaning the built-in). Signed-in users are split by a hash of their
+// ID so each keeps seeing the same variant; anonymous players get a random
+// one per request. Results are tagged with the experiment and variant,
+// cached separately per variant, and each variant's generations and
+// parse failures are counted in puzzle-hub-prompt-experiment-stats, where
+// ratings of the results add thumbs up and down. At most one experiment
+// runs per template; stopping it keeps its stats.
+
+var experimentVariants = []string{"a", "b"}
+
+type PromptExperiment struct {
+	Name         string    `json:"name" dynamodbav:"name"` // Template name
+	ExperimentID string    `json:"experiment_id" dynamodbav:"experiment_id"`
+	VersionA     int       `json:"version_a" dynamodbav:"version_a"`
+	VersionB     int       `json:"version_b" dynamodbav:"version_b"`
+	PercentB     int       `json:"percent_b" dynamodbav:"percent_b"` // Share of traffic on variant b
+	Note         string    `json:"note,omitempty" dynamodbav:"note,omitempty"`
+	StartedBy    string    `json:"started_by" dynamodbav:"started_by"`
+	StartedAt    time.Time `json:"started_at" dynamodbav:"started_at"`
+}
+
+type ExperimentVariantStats struct {
+	ExperimentID  string `json:"experiment_id" dynamodbav:"experiment_id"`
+	Variant       string `json:"variant" dynamodbav:"variant"`
+	Generations   int    `json:"generations" dynamodbav:"generations"`
+	ParseFailures int    `json:"parse_failures" dynamodbav:"parse_failures"`
+	ThumbsUp      int    `json:"thumbs_up" dynamodbav:"thumbs_up"`
+	ThumbsDown    int    `json:"thumbs_down" dynamodbav:"thumbs_down"`
+}
+
+// PromptTag marks a result generated under an experiment
+type PromptTag struct {
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+}
+
+// promptChoice is the template version picked for one request
+type promptChoice struct {
+	Name    string
+	Version int // 0 for the built-in template
+	PromptTag
+}
+
+type loadedExperiment struct {
+	PromptExperiment
+	templates [2]*template.Template // Per variant, nil for the built-in
+}
+
+// choose picks the template version a user gets
+func (p *PromptStore) choose(name, userID string) promptChoice {
+	p.mu.RLock()
+	experiment, ok := p.experiments[name]
+	p.mu.RUnlock()
+	if !ok {
+		return promptChoice{Name: name, Version: p.activeVersion(name)}
+	}
+
+	variant := experimentVariant(experiment.ExperimentID, userID, experiment.PercentB)
+	choice := promptChoice{
+		Name:      name,
+		Version:   experiment.VersionA,
+		PromptTag: PromptTag{Experiment: experiment.ExperimentID, Variant: experimentVariants[variant]},
+	}
+	if variant == 1 {
+		choice.Version = experiment.VersionB
+	}
+	return choice
+}
+
+// experimentVariant returns 0 for variant a and 1 for variant b
+func experimentVariant(experimentID, userID string, percentB int) int {
+	bucket := rand.Intn(100)
+	if userID != "" {
+		hash := fnv.New32a()
+		hash.Write([]byte(experimentID + ":" + userID))
+		bucket = int(hash.Sum32() % 100)
+	}
+	if bucket < percentB {
+		return 1
+	}
+	return 0
+}
+
+// renderChoice fills in the template version chosen for a request
+func (p *PromptStore) renderChoice(choice promptChoice, data interface{}) string {
+	if choice.Experiment == "" {
+		return p.render(choice.Name, data)
+	}
+	data = sanitizePromptData(choice.Name, data)
+
+	p.mu.RLock()
+	experiment, ok := p.experiments[choice.Name]
+	p.mu.RUnlock()
+	tmpl := p.builtins[choice.Name]
+	if ok && experiment.ExperimentID == choice.Experiment {
+		variant := 0
+		if choice.Variant == experimentVariants[1] {
+			variant = 1
+		}
+		if variantTemplate := experiment.templates[variant]; variantTemplate != nil {
+			tmpl = variantTemplate
+		}
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		log.Printf("⚠️  Prompt %s variant %s failed, using the active version: %v", choice.Name, choice.Variant, err)
+		return p.render(choice.Name, data)
+	}
+	return strings.TrimSpace(rendered.String())
+}
+
+// cacheParams keeps each variant's results apart in the AI cache
+func (choice promptChoice) cacheParams(params interface{}) interface{} {
+	if choice.Experiment == "" {
+		return params
+	}
+	return map[string]interface{}{
+		"params":     params,
+		"experiment": choice.Experiment,
+		"variant":    choice.Variant,
+	}
+}
+
+// recordExperiment adds one to a variant's counter in the background
+func (p *PromptStore) recordExperiment(tag PromptTag, counter string) {
+	p.recordExperimentDelta(tag, counter, 1)
+}
+
+// recordExperimentDelta adds delta to a variant's counter in the background
+func (p *PromptStore) recordExperimentDelta(tag PromptTag, counter string, delta int) {
+	if tag.Experiment == "" {
+		return
+	}
+	go func() {
+		_, err := p.db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName("puzzle-hub-prompt-experiment-stats")),
+			Key: map[string]*dynamodb.AttributeValue{
+				"experiment_id": {S: aws.String(tag.Experiment)},
+				"variant":       {S: aws.String(tag.Variant)},
+			},
+			UpdateExpression: aws.String("ADD #counter :delta"),
+			ExpressionAttributeNames: map[string]*string{
+				"#counter": aws.String(counter),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":delta": {N: aws.String(strconv.Itoa(delta))},
+			},
+		})
+		if err != nil {
+			log.Printf("Error recording %s for experiment %s: %v", counter, tag.Experiment, err)
+		}
+	}()
+}
+
+// loadExperiments reads the running experiments and compiles their
+// variants, called from Load
+func (p *PromptStore) loadExperiments() (map[string]loadedExperiment, error) {
+	var experiments []PromptExperiment
+	var unmarshalErr error
+	err := p.db.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-prompt-experiments")),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var pageExperiments []PromptExperiment
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageExperiments); unmarshalErr != nil {
+			return false
+		}
+		experiments = append(experiments, pageExperiments...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load prompt experiments: %v", err)
+	}
+
+	loaded := make(map[string]loadedExperiment)
+	for _, experiment := range experiments {
+		if p.builtins[experiment.Name] == nil {
+			continue
+		}
+		p.mu.RLock()
+		current, ok := p.experiments[experiment.Name]
+		p.mu.RUnlock()
+		if ok && current.ExperimentID == experiment.ExperimentID {
+			loaded[experiment.Name] = current
+			continue
+		}
+
+		compiled, err := p.compileExperiment(experiment)
+		if err != nil {
+			log.Printf("❌ Prompt experiment %s is invalid: %v", experiment.ExperimentID, err)
+			continue
+		}
+		loaded[experiment.Name] = compiled
+		log.Printf("🧪 Running prompt experiment %s on %s (v%d vs v%d)", experiment.ExperimentID, experiment.Name, experiment.VersionA, experiment.VersionB)
+	}
+	return loaded, nil
+}
+
+func (p *PromptStore) compileExperiment(experiment PromptExperiment) (loadedExperiment, error) {
+	loaded := loadedExperiment{PromptExperiment: experiment}
+	for i, version := range []int{experiment.VersionA, experiment.VersionB} {
+		if version == 0 {
+			continue
+		}
+		promptVersion, err := p.getVersion(experiment.Name, version)
+		if err != nil {
+			return loaded, err
+		}
+		if promptVersion == nil {
+			return loaded, fmt.Errorf("version %d not found", version)
+		}
+		if loaded.templates[i], err = compilePrompt(experiment.Name, promptVersion.Body); err != nil {
+			return loaded, fmt.Errorf("version %d: %v", version, err)
+		}
+	}
+	return loaded, nil
+}
+
+// experimentStats returns an experiment's counters per variant
+func (p *PromptStore) experimentStats(experimentID string) ([]ExperimentVariantStats, error) {
+	return queryAll[ExperimentVariantStats](p.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-prompt-experiment-stats")),
+		KeyConditionExpression: aws.String("experiment_id = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {S: aws.String(experimentID)},
+		},
+	})
+}
+
+// experimentReport adds the rates admins compare variants on
+func experimentReport(stats []ExperimentVariantStats) []gin.H {
+	byVariant := make(map[string]ExperimentVariantStats)
+	for _, variantStats := range stats {
+		byVariant[variantStats.Variant] = variantStats
+	}
+
+	report := make([]gin.H, 0, len(experimentVariants))
+	for _, variant := range experimentVariants {
+		variantStats := byVariant[variant]
+		variantStats.Variant = variant
+		entry := gin.H{"stats": variantStats}
+		if variantStats.Generations > 0 {
+			entry["parse_failure_rate"] = float64(variantStats.ParseFailures) / float64(variantStats.Generations)
+		}
+		if ratings := variantStats.ThumbsUp + variantStats.ThumbsDown; ratings > 0 {
+			entry["thumbs_up_rate"] = float64(variantStats.ThumbsUp) / float64(ratings)
+		}
+		report = append(report, entry)
+	}
+	return report
+}
+
+// adminListPromptExperiments lists the running experiments with their stats
+func (h *PuzzleHub) adminListPromptExperiments(c *gin.Context) {
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	h.Prompts.mu.RLock()
+	running := make([]PromptExperiment, 0, len(h.Prompts.experiments))
+	for _, experiment := range h.Prompts.experiments {
+		running = append(running, experiment.PromptExperiment)
+	}
+	h.Prompts.mu.RUnlock()
+	sort.Slice(running, func(i, j int) bool { return running[i].Name < running[j].Name })
+
+	// Stats are a query per experiment, so only fetch them for the page
+	pageRunning, meta := paginate(running, page)
+	experiments := make([]gin.H, 0, len(pageRunning))
+	for _, experiment := range pageRunning {
+		stats, err := h.Prompts.experimentStats(experiment.ExperimentID)
+		if err != nil {
+			requestLogger(c).Error("error fetching experiment stats", "error", err)
+			respondStorageError(c, err, "Failed to fetch prompt experiments")
+			return
+		}
+		experiments = append(experiments, gin.H{
+			"experiment": experiment,
+			"variants":   experimentReport(stats),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"experiments": experiments,
+		"count":       len(experiments),
+		"page":        meta,
+	})
+}
+
+// adminGetPromptExperiment reports on any experiment, running or stopped
+func (h *PuzzleHub) adminGetPromptExperiment(c *gin.Context) {
+	experimentID := c.Param("id")
+	stats, err := h.Prompts.experimentStats(experimentID)
+	if err != nil {
+		requestLogger(c).Error("error fetching experiment stats", "error", err)
+		respondStorageError(c, err, "Failed to fetch experiment stats")
+		return
+	}
+	if len(stats) == 0 {
+		respondError(c, http.StatusNotFound, "No results recorded for this experiment")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"experiment_id": experimentID,
+		"variants":      experimentReport(stats),
+	})
+}
+
+type StartExperimentRequest struct {
+	VersionA *int   `json:"version_a" binding:"required"`
+	VersionB *int   `json:"version_b" binding:"required"`
+	PercentB *int   `json:"percent_b"` // Defaults to 50
+	Note     string `json:"note"`
+}
+
+// adminStartPromptExperiment starts an experiment on a template, replacing
+// any running one
+func (h *PuzzleHub) adminStartPromptExperiment(c *gin.Context) {
+	admin := c.MustGet("user").(*User)
+	name := c.Param("name")
+	if _, ok := promptSamples[name]; !ok {
+		respondError(c, http.StatusNotFound, "Prompt template not found")
+		return
+	}
+
+	var request StartExperimentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	percentB := 50
+	if request.PercentB != nil {
+		percentB = *request.PercentB
+	}
+	if percentB < 1 || percentB > 99 {
+		respondError(c, http.StatusBadRequest, "percent_b must be between 1 and 99")
+		return
+	}
+	if *request.VersionA == *request.VersionB {
+		respondError(c, http.StatusBadRequest, "The two variants must be different versions")
+		return
+	}
+
+	suffix, err := randomToken(4)
+	if err != nil {
+		requestLogger(c).Error("error generating experiment ID", "error", err)
+		respondStorageError(c, err, "Failed to start experiment")
+		return
+	}
+	experiment := PromptExperiment{
+		Name:         name,
+		ExperimentID: fmt.Sprintf("%s-%s-%s", name, time.Now().UTC().Format("20060102"), suffix),
+		VersionA:     *request.VersionA,
+		VersionB:     *request.VersionB,
+		PercentB:     percentB,
+		Note:         request.Note,
+		StartedBy:    admin.Email,
+		StartedAt:    time.Now(),
+	}
+	compiled, err := h.Prompts.compileExperiment(experiment)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Invalid variant: %v", err))
+		return
+	}
+
+	item, err := dynamodbattribute.MarshalMap(experiment)
+	if err != nil {
+		requestLogger(c).Error("error marshaling prompt experiment", "error", err)
+		respondStorageError(c, err, "Failed to start experiment")
+		return
+	}
+	if _, err := h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-prompt-experiments")),
+		Item:      item,
+	}); err != nil {
+		requestLogger(c).Error("error saving prompt experiment", "error", err)
+		respondStorageError(c, err, "Failed to start experiment")
+		return
+	}
+
+	h.Prompts.mu.Lock()
+	h.Prompts.experiments[name] = compiled
+	h.Prompts.mu.Unlock()
+
+	requestLogger(c).Info("started prompt experiment", "experiment_id", experiment.ExperimentID, "prompt", name)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Experiment started",
+		"experiment": experiment,
+	})
+}
+
+// adminStopPromptExperiment stops a template's experiment, leaving the
+// active version in use for everyone
+func (h *PuzzleHub) adminStopPromptExperiment(c *gin.Context) {
+	name := c.Param("name")
+
+	result, err := h.DynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-prompt-experiments")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name": {S: aws.String(name)},
+		},
+		ConditionExpression: aws.String("attribute_exists(#name)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#name": aws.String("name"),
+		},
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "No experiment running on this template")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error stopping prompt experiment", "error", err)
+		respondStorageError(c, err, "Failed to stop experiment")
+		return
+	}
+
+	h.Prompts.mu.Lock()
+	delete(h.Prompts.experiments, name)
+	h.Prompts.mu.Unlock()
+
+	var experiment PromptExperiment
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &experiment); err != nil {
+		requestLogger(c).Error("error unmarshaling stopped experiment", "error", err)
+	}
+	stats, err := h.Prompts.experimentStats(experiment.ExperimentID)
+	if err != nil {
+		requestLogger(c).Error("error fetching experiment stats", "error", err)
+	}
+
+	requestLogger(c).Info("stopped prompt experiment", "experiment_id", experiment.ExperimentID)
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Experiment stopped",
+		"experiment": experiment,
+		"variants":   experimentReport(stats),
+	})
+}
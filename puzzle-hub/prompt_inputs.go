@@ -0,0 +1,198 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Prompt input sanitization
+//
+// Every value a prompt template is rendered with passes through
+// sanitizePromptData first, so a theme, title, description or story element
+// can't rewrite the instructions around it or blow up the prompt:
+//
+//   - Short fields (the default) are cut to maxPromptFieldLength characters,
+//     or the field's `prompt:"max=N"` tag, and put on one line: newlines,
+//     tabs and control characters become spaces, double quotes and
+//     backticks become single quotes, so the value can't close the quotes
+//     or fences a template puts it in.
+//   - Phrases that read as instructions to the model ("ignore the previous
+//     instructions", "system:", "<|im_start|>" and the like) are removed
+//     from short fields.
+//   - Long-form fields tagged `prompt:"text"`, a student's essay or story,
+//     keep their lines and wording, since templates fence them off and the
+//     analysis refers back to them; they are only capped at
+//     maxPromptTextLength.
+//   - Lists keep their first maxPromptListItems items, or `prompt:"items=N"`.
+//
+// Changed values are logged and counted in
+// puzzle_hub_prompt_input_flags_total by template and reason.
+
+const (
+	maxPromptFieldLength = 200
+	maxPromptTextLength  = 20000
+	maxPromptListItems   = 20
+)
+
+// promptInjectionPatterns match text trying to talk to the model rather
+// than describe the request
+var promptInjectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\s+(all\s+|any\s+|the\s+|your\s+|every\s+)*(previous|prior|above|earlier|preceding|system|original)?\s*(instructions?|prompts?|rules|directions|context)\b`),
+	regexp.MustCompile(`(?i)\b(system|developer)\s+(prompt|message|instructions?)\b`),
+	regexp.MustCompile(`(?i)\b(you\s+are\s+now|from\s+now\s+on\s+you|pretend\s+(to\s+be|you\s+are)|act\s+as\s+(a|an|if))\b`),
+	regexp.MustCompile(`(?i)\bnew\s+instructions?\b`),
+	regexp.MustCompile(`(?i)\b(system|assistant|user|human)\s*:`),
+	regexp.MustCompile(`(?i)<\|[a-z_]+\|>|\[/?inst\]|</?(system|instructions?|prompt)>`),
+}
+
+var promptQuoteReplacer = strings.NewReplacer(`"`, "'", "`", "'", "“", "'", "”", "'")
+
+// promptFieldRules are the limits a struct tag sets on a field
+type promptFieldRules struct {
+	text     bool // Long-form, keeps newlines and wording
+	maxLen   int
+	maxItems int
+}
+
+func parsePromptTag(tag string) promptFieldRules {
+	rules := promptFieldRules{maxLen: maxPromptFieldLength, maxItems: maxPromptListItems}
+	for _, option := range strings.Split(tag, ",") {
+		switch {
+		case option == "text":
+			rules.text = true
+			rules.maxLen = maxPromptTextLength
+		case strings.HasPrefix(option, "max="):
+			if n, err := strconv.Atoi(strings.TrimPrefix(option, "max=")); err == nil && n > 0 {
+				rules.maxLen = n
+			}
+		case strings.HasPrefix(option, "items="):
+			if n, err := strconv.Atoi(strings.TrimPrefix(option, "items=")); err == nil && n > 0 {
+				rules.maxItems = n
+			}
+		}
+	}
+	return rules
+}
+
+// truncateRunes cuts s to at most n characters
+func truncateRunes(s string, n int) (string, bool) {
+	if len(s) <= n {
+		return s, false
+	}
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s, false
+	}
+	return string(runes[:n]), true
+}
+
+// sanitizePromptText cleans one value, returning the reasons it changed
+func sanitizePromptText(value string, rules promptFieldRules) (string, []string) {
+	var reasons []string
+	if rules.text {
+		value = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) && r != '\n' && r != '\t' && r != '\r' {
+				return -1
+			}
+			return r
+		}, value)
+		if truncated, cut := truncateRunes(value, rules.maxLen); cut {
+			value = truncated
+			reasons = append(reasons, "truncated")
+		}
+		return value, reasons
+	}
+
+	original := value
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.In(r, unicode.Cf, unicode.Zl, unicode.Zp) {
+			return ' '
+		}
+		return r
+	}, value)
+	value = promptQuoteReplacer.Replace(value)
+	if value != original {
+		reasons = append(reasons, "flattened")
+	}
+
+	injected := false
+	for _, pattern := range promptInjectionPatterns {
+		if pattern.MatchString(value) {
+			value = pattern.ReplaceAllString(value, " ")
+			injected = true
+		}
+	}
+	if injected {
+		reasons = append(reasons, "injection")
+	}
+
+	value = strings.Join(strings.Fields(value), " ")
+	if truncated, cut := truncateRunes(value, rules.maxLen); cut {
+		value = strings.TrimSpace(truncated)
+		reasons = append(reasons, "truncated")
+	}
+	return value, reasons
+}
+
+// sanitizePromptData returns a copy of a template's data with every string
+// cleaned; the caller's value is left as it was
+func sanitizePromptData(name string, data interface{}) interface{} {
+	if data == nil {
+		return nil
+	}
+	original := reflect.ValueOf(data)
+	value := reflect.New(original.Type()).Elem()
+	value.Set(original)
+	sanitizePromptValue(name, "", value, parsePromptTag(""))
+	return value.Interface()
+}
+
+// sanitizePromptValue cleans value in place. Slices are copied before
+// their items are changed, as they share memory with the caller's.
+func sanitizePromptValue(name, path string, value reflect.Value, rules promptFieldRules) {
+	switch value.Kind() {
+	case reflect.String:
+		cleaned, reasons := sanitizePromptText(value.String(), rules)
+		for _, reason := range reasons {
+			promptInputFlags.WithLabelValues(name, reason).Inc()
+		}
+		if len(reasons) > 0 {
+			log.Printf("🛡️  Sanitized prompt input %s.%s (%s)", name, path, strings.Join(reasons, ", "))
+		}
+		if value.CanSet() {
+			value.SetString(cleaned)
+		}
+	case reflect.Slice:
+		if value.IsNil() {
+			return
+		}
+		length := value.Len()
+		if length > rules.maxItems {
+			length = rules.maxItems
+			promptInputFlags.WithLabelValues(name, "truncated").Inc()
+		}
+		items := reflect.MakeSlice(value.Type(), length, length)
+		reflect.Copy(items, value)
+		for i := 0; i < length; i++ {
+			sanitizePromptValue(name, path+"["+strconv.Itoa(i)+"]", items.Index(i), rules)
+		}
+		value.Set(items)
+	case reflect.Struct:
+		typ := value.Type()
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := field.Name
+			if path != "" {
+				fieldPath = path + "." + field.Name
+			}
+			sanitizePromptValue(name, fieldPath, value.Field(i), parsePromptTag(field.Tag.Get("prompt")))
+		}
+	}
+}
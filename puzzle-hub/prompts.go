@@ -0,0 +1,524 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Prompt templates
+//
+// AI prompts are Go text/templates. The built-in version of each lives in
+// prompts/<name>.tmpl and is compiled into the binary. Admins can publish
+// new versions through /api/admin/prompts without a redeploy: versions are
+// kept in puzzle-hub-prompt-templates, the version in use per template in
+// puzzle-hub-prompt-active (0 meaning the built-in), and every instance
+// reloads the active versions each minute. A template is only accepted if
+// it renders against its sample variables, and a stored version that fails
+// at render time falls back to the built-in one.
+
+//go:embed prompts/*.tmpl
+var builtinPromptFiles embed.FS
+
+// promptSamples holds each template's variables with example values. The
+// sample's type is the type the template is rendered with.
+var promptSamples = map[string]interface{}{
+	"spelling": GenerationCriteria{
+		DifficultyLevel: "middle", AgeGroup: "10 years old", WordCount: 10,
+		Theme: "animals", IncludePhonetics: true, IncludeHints: true,
+	},
+	"writing_analysis": WritingAnalysisRequest{
+		Text: "Once upon a time there was a dragon who loved to bake.", GradeLevel: 4, Title: "The Baking Dragon",
+	},
+	"story_system":    StoryRequest{},
+	"story_prompt":    storyPromptSample,
+	"story_character": storyPromptSample,
+	"story_plot":      storyPromptSample,
+	"story_twist":     storyPromptSample,
+	"story_setting":   storyPromptSample,
+	"story_idea":      storyPromptSample,
+	"log_fields": SuggestFieldsRequest{
+		LogTypeName: "Gym Workout", Description: "Track my strength training sessions",
+	},
+	"math_word_problems": WordProblemRequest{Grade: 4, Theme: "animals", Count: 5},
+	"math_explanation": wordProblemExplanationPrompt{
+		Question:   "Maya has 3 bags with 12 apples in each and eats 5. How many apples are left?",
+		Expression: "3 * 12 - 5", Answer: "31", Unit: "apples", Grade: 3,
+	},
+	"quiz_questions": quizGenerationPrompt{
+		Title: "Science basics", Subject: "science", Description: "Elementary school science",
+		Focus: "the water cycle", Count: 10, Existing: []string{"What is the closest star to Earth?"},
+	},
+	"story_titles": storyTitlesPrompt{
+		Title: "My Story", Genre: "adventure", Count: 5,
+		Content: "Maya found a map in her grandmother's attic. It showed an island that wasn't on any other map.",
+	},
+	"remediation": remediationPrompt{
+		Activity: "spelling", AgeGroup: "8-10",
+		Items: []RemediationItem{{Item: "necessary", Attempt: "neccesary"}, {Item: "rhythm"}},
+	},
+}
+
+var storyPromptSample = StoryRequest{
+	Genre: "adventure", Elements: []string{"a map", "a talking cat"}, Tone: "funny", RequestType: "prompt",
+}
+
+var promptFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+type PromptVersion struct {
+	Name      string    `json:"name" dynamodbav:"name"`
+	Version   int       `json:"version" dynamodbav:"version"`
+	Body      string    `json:"body" dynamodbav:"body"`
+	Note      string    `json:"note,omitempty" dynamodbav:"note,omitempty"`
+	CreatedBy string    `json:"created_by" dynamodbav:"created_by"`
+	CreatedAt time.Time `json:"created_at" dynamodbav:"created_at"`
+}
+
+type ActivePrompt struct {
+	Name        string    `json:"name" dynamodbav:"name"`
+	Version     int       `json:"version" dynamodbav:"version"` // 0 for the built-in template
+	ActivatedBy string    `json:"activated_by" dynamodbav:"activated_by"`
+	ActivatedAt time.Time `json:"activated_at" dynamodbav:"activated_at"`
+}
+
+type activeTemplate struct {
+	version  int
+	template *template.Template
+}
+
+type PromptStore struct {
+	db       *dynamodb.DynamoDB
+	builtins map[string]*template.Template
+	sources  map[string]string // Built-in template text
+
+	mu          sync.RWMutex
+	loaded      bool                        // Stored versions were read at least once
+	active      map[string]activeTemplate   // Stored versions in use
+	experiments map[string]loadedExperiment // Running A/B tests, see prompt_experiments.go
+}
+
+// NewPromptStore compiles the built-in templates. They ship with the
+// binary, so one that doesn't render its sample is a bug and panics.
+func NewPromptStore(db *dynamodb.DynamoDB) *PromptStore {
+	p := &PromptStore{
+		db:          db,
+		builtins:    make(map[string]*template.Template),
+		sources:     make(map[string]string),
+		active:      make(map[string]activeTemplate),
+		experiments: make(map[string]loadedExperiment),
+	}
+	for name := range promptSamples {
+		source, err := builtinPromptFiles.ReadFile(path.Join("prompts", name+".tmpl"))
+		if err != nil {
+			panic(fmt.Sprintf("missing built-in prompt %s: %v", name, err))
+		}
+		tmpl, err := compilePrompt(name, string(source))
+		if err != nil {
+			panic(fmt.Sprintf("invalid built-in prompt %s: %v", name, err))
+		}
+		p.builtins[name] = tmpl
+		p.sources[name] = string(source)
+	}
+	return p
+}
+
+// compilePrompt parses a template and checks it renders its sample
+func compilePrompt(name, body string) (*template.Template, error) {
+	sample, ok := promptSamples[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt template %q", name)
+	}
+	tmpl, err := template.New(name).Funcs(promptFuncs).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, sample); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(rendered.String()) == "" {
+		return nil, fmt.Errorf("template renders to an empty prompt")
+	}
+	return tmpl, nil
+}
+
+// render fills in the named template, using the built-in version if the
+// stored one fails
+func (p *PromptStore) render(name string, data interface{}) string {
+	data = sanitizePromptData(name, data)
+	p.mu.RLock()
+	active, ok := p.active[name]
+	p.mu.RUnlock()
+
+	var rendered strings.Builder
+	if ok {
+		err := active.template.Execute(&rendered, data)
+		if err == nil {
+			return strings.TrimSpace(rendered.String())
+		}
+		log.Printf("⚠️  Prompt %s v%d failed, using the built-in: %v", name, active.version, err)
+		rendered.Reset()
+	}
+
+	if err := p.builtins[name].Execute(&rendered, data); err != nil {
+		log.Printf("❌ Built-in prompt %s failed: %v", name, err)
+	}
+	return strings.TrimSpace(rendered.String())
+}
+
+// Load reads the active template versions from DynamoDB
+func (p *PromptStore) Load() error {
+	var pointers []ActivePrompt
+	var unmarshalErr error
+	err := p.db.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-prompt-active")),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var pagePointers []ActivePrompt
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pagePointers); unmarshalErr != nil {
+			return false
+		}
+		pointers = append(pointers, pagePointers...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load active prompts: %v", err)
+	}
+
+	active := make(map[string]activeTemplate)
+	for _, pointer := range pointers {
+		if pointer.Version == 0 || p.builtins[pointer.Name] == nil {
+			continue
+		}
+		p.mu.RLock()
+		current, loaded := p.active[pointer.Name]
+		p.mu.RUnlock()
+		if loaded && current.version == pointer.Version {
+			active[pointer.Name] = current
+			continue
+		}
+
+		version, err := p.getVersion(pointer.Name, pointer.Version)
+		if err != nil || version == nil {
+			log.Printf("❌ Failed to load prompt %s v%d: %v", pointer.Name, pointer.Version, err)
+			continue
+		}
+		tmpl, err := compilePrompt(pointer.Name, version.Body)
+		if err != nil {
+			log.Printf("❌ Stored prompt %s v%d is invalid: %v", pointer.Name, pointer.Version, err)
+			continue
+		}
+		active[pointer.Name] = activeTemplate{version: pointer.Version, template: tmpl}
+		log.Printf("📝 Using prompt %s v%d", pointer.Name, pointer.Version)
+	}
+
+	experiments, err := p.loadExperiments()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.loaded = true
+	p.active = active
+	p.experiments = experiments
+	p.mu.Unlock()
+	return nil
+}
+
+// Loaded reports whether the stored template versions have been read, so
+// responses use the prompts admins activated rather than the built-ins
+func (p *PromptStore) Loaded() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.loaded
+}
+
+// runPromptRefresh picks up templates published on other instances
+func (p *PromptStore) runPromptRefresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := p.Load(); err != nil {
+			log.Printf("❌ %v", err)
+		}
+	}
+}
+
+func (p *PromptStore) activeVersion(name string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.active[name].version
+}
+
+func (p *PromptStore) getVersion(name string, version int) (*PromptVersion, error) {
+	result, err := p.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-prompt-templates")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name":    {S: aws.String(name)},
+			"version": {N: aws.String(strconv.Itoa(version))},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var promptVersion PromptVersion
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &promptVersion); err != nil {
+		return nil, err
+	}
+	return &promptVersion, nil
+}
+
+// getVersions returns a template's stored versions, newest first
+func (p *PromptStore) getVersions(name string) ([]PromptVersion, error) {
+	return queryAll[PromptVersion](p.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-prompt-templates")),
+		KeyConditionExpression: aws.String("#name = :name"),
+		ExpressionAttributeNames: map[string]*string{
+			"#name": aws.String("name"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":name": {S: aws.String(name)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	})
+}
+
+func (p *PromptStore) activate(name string, version int, tmpl *template.Template, admin *User) error {
+	item, err := dynamodbattribute.MarshalMap(ActivePrompt{
+		Name:        name,
+		Version:     version,
+		ActivatedBy: admin.Email,
+		ActivatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := p.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-prompt-active")),
+		Item:      item,
+	}); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if version == 0 {
+		delete(p.active, name)
+	} else {
+		p.active[name] = activeTemplate{version: version, template: tmpl}
+	}
+	return nil
+}
+
+// promptVariables lists the fields a template can use
+func promptVariables(name string) []string {
+	sampleType := reflect.TypeOf(promptSamples[name])
+	variables := make([]string, 0, sampleType.NumField())
+	for i := 0; i < sampleType.NumField(); i++ {
+		variables = append(variables, "."+sampleType.Field(i).Name)
+	}
+	return variables
+}
+
+// adminListPrompts lists every template with its version in use
+func (h *PuzzleHub) adminListPrompts(c *gin.Context) {
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	names := make([]string, 0, len(promptSamples))
+	for name := range promptSamples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	prompts := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		prompts = append(prompts, gin.H{
+			"name":           name,
+			"active_version": h.Prompts.activeVersion(name),
+			"variables":      promptVariables(name),
+		})
+	}
+	respondPage(c, "prompts", prompts, page, nil)
+}
+
+// adminGetPrompt returns a template's built-in text and stored versions
+func (h *PuzzleHub) adminGetPrompt(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := promptSamples[name]; !ok {
+		respondError(c, http.StatusNotFound, "Prompt template not found")
+		return
+	}
+
+	versions, err := h.Prompts.getVersions(name)
+	if err != nil {
+		requestLogger(c).Error("error fetching prompt versions", "error", err)
+		respondStorageError(c, err, "Failed to fetch prompt versions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":           name,
+		"active_version": h.Prompts.activeVersion(name),
+		"variables":      promptVariables(name),
+		"builtin":        h.Prompts.sources[name],
+		"versions":       versions,
+	})
+}
+
+type PublishPromptRequest struct {
+	Body string `json:"body" binding:"required"`
+	Note string `json:"note"`
+}
+
+// adminPublishPrompt stores a new version of a template and starts using it
+func (h *PuzzleHub) adminPublishPrompt(c *gin.Context) {
+	admin := c.MustGet("user").(*User)
+	name := c.Param("name")
+	if _, ok := promptSamples[name]; !ok {
+		respondError(c, http.StatusNotFound, "Prompt template not found")
+		return
+	}
+
+	var request PublishPromptRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	tmpl, err := compilePrompt(name, request.Body)
+	if err != nil {
+		respondErrorCode(c, http.StatusBadRequest, "invalid_template", fmt.Sprintf("Invalid template: %v", err), gin.H{"variables": promptVariables(name)})
+		return
+	}
+
+	versions, err := h.Prompts.getVersions(name)
+	if err != nil {
+		requestLogger(c).Error("error fetching prompt versions", "error", err)
+		respondStorageError(c, err, "Failed to publish prompt")
+		return
+	}
+	promptVersion := PromptVersion{
+		Name:      name,
+		Version:   1,
+		Body:      request.Body,
+		Note:      request.Note,
+		CreatedBy: admin.Email,
+		CreatedAt: time.Now(),
+	}
+	if len(versions) > 0 {
+		promptVersion.Version = versions[0].Version + 1
+	}
+
+	item, err := dynamodbattribute.MarshalMap(promptVersion)
+	if err != nil {
+		requestLogger(c).Error("error marshaling prompt version", "error", err)
+		respondStorageError(c, err, "Failed to publish prompt")
+		return
+	}
+	_, err = h.DynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-prompt-templates")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#version)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#version": aws.String("version"),
+		},
+	})
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusConflict, "Another version was just published, please retry")
+			return
+		}
+		requestLogger(c).Error("error saving prompt version", "error", err)
+		respondStorageError(c, err, "Failed to publish prompt")
+		return
+	}
+
+	if err := h.Prompts.activate(name, promptVersion.Version, tmpl, admin); err != nil {
+		requestLogger(c).Error("error activating prompt version", "error", err)
+		respondStorageError(c, err, "Prompt saved but could not be activated")
+		return
+	}
+
+	requestLogger(c).Info("published prompt", "prompt", name, "version", promptVersion.Version)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Prompt published successfully",
+		"version": promptVersion,
+		"preview": h.Prompts.render(name, promptSamples[name]),
+	})
+}
+
+type ActivatePromptRequest struct {
+	Version *int `json:"version" binding:"required"` // 0 returns to the built-in template
+}
+
+// adminActivatePrompt switches a template to an earlier version
+func (h *PuzzleHub) adminActivatePrompt(c *gin.Context) {
+	admin := c.MustGet("user").(*User)
+	name := c.Param("name")
+	if _, ok := promptSamples[name]; !ok {
+		respondError(c, http.StatusNotFound, "Prompt template not found")
+		return
+	}
+
+	var request ActivatePromptRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	var tmpl *template.Template
+	if *request.Version != 0 {
+		promptVersion, err := h.Prompts.getVersion(name, *request.Version)
+		if err != nil {
+			requestLogger(c).Error("error fetching prompt version", "error", err)
+			respondStorageError(c, err, "Failed to activate prompt")
+			return
+		}
+		if promptVersion == nil {
+			respondError(c, http.StatusNotFound, "Prompt version not found")
+			return
+		}
+		tmpl, err = compilePrompt(name, promptVersion.Body)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Stored template no longer renders: %v", err))
+			return
+		}
+	}
+
+	if err := h.Prompts.activate(name, *request.Version, tmpl, admin); err != nil {
+		requestLogger(c).Error("error activating prompt version", "error", err)
+		respondStorageError(c, err, "Failed to activate prompt")
+		return
+	}
+
+	requestLogger(c).Info("switched prompt version", "prompt", name, "version", *request.Version)
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Prompt activated successfully",
+		"name":           name,
+		"active_version": *request.Version,
+	})
+}
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Quizzes
+//
+// A generic multiple choice engine over topics (quizTopics). Each topic's
+// question bank is its built-in pack (quiz_packs.go) plus any questions
+// admins have had AI write for it, which are kept in
+// puzzle-hub-quiz-questions. Questions are stored with the right answer as
+// the first choice; quizzes shuffle the choices and never include answers,
+// and players submit the text of the choice they picked, so quizzes need
+// no session state on the server.
+//
+// For signed-in players each topic has one mastery record: a Leitner box
+// per question (0-3; right answers move it up, wrong ones back to 0) that
+// makes quizzes favour new and missed questions, and running totals.
+// Questions in box masteredQuizBox or above count as mastered. Finished
+// quizzes are recorded as "quiz" progress.
+
+const (
+	defaultQuizLength  = 10
+	maxQuizLength      = 25
+	quizChoices        = 4
+	masteredQuizBox    = 2
+	maxQuizBox         = 3
+	maxQuizGeneration  = 20
+	quizPointsPerRight = 10
+
+	quizSourceBuiltin = "builtin"
+	quizSourceAI      = "ai"
+)
+
+type quizTopic struct {
+	Title       string
+	Subject     string
+	Description string // Also tells AI what to write about
+}
+
+var quizTopics = map[string]quizTopic{
+	"us-capitals": {
+		Title:       "US states and capitals",
+		Subject:     "geography",
+		Description: "The 50 US states: their capitals, locations, neighbours, nicknames and landmarks",
+	},
+	"science-basics": {
+		Title:       "Science basics",
+		Subject:     "science",
+		Description: "Elementary school science: plants, animals, the human body, space, matter, energy, forces and weather",
+	},
+}
+
+// quizBoxWeights makes new and missed questions come up more often; new
+// questions count as box 1
+var quizBoxWeights = [maxQuizBox + 1]float64{4, 3, 1, 0.5}
+
+type QuizQuestion struct {
+	Topic        string     `json:"topic" dynamodbav:"topic"`
+	QuestionID   string     `json:"id" dynamodbav:"question_id"`
+	Prompt       string     `json:"prompt" dynamodbav:"prompt"`
+	Choices      []string   `json:"choices" dynamodbav:"choices"` // The right answer first
+	Explanation  string     `json:"explanation,omitempty" dynamodbav:"explanation,omitempty"`
+	Source       string     `json:"source" dynamodbav:"source"` // builtin or ai
+	GenerationID string     `json:"generation_id,omitempty" dynamodbav:"generation_id,omitempty"`
+	CreatedBy    string     `json:"created_by,omitempty" dynamodbav:"created_by,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty" dynamodbav:"created_at,omitempty"`
+}
+
+type QuizTopicInfo struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Subject     string `json:"subject"`
+	Description string `json:"description"`
+	Questions   int    `json:"questions"`
+}
+
+type QuizSettings struct {
+	Topic string `json:"topic" binding:"required"`
+	Count int    `json:"count"` // Default 10
+}
+
+// QuizItem is a question as players see it
+type QuizItem struct {
+	ID      string   `json:"id"`
+	Prompt  string   `json:"prompt"`
+	Choices []string `json:"choices"`
+}
+
+type Quiz struct {
+	ID        string     `json:"id"`
+	Topic     string     `json:"topic"`
+	Title     string     `json:"title"`
+	Questions []QuizItem `json:"questions"`
+	StartTime time.Time  `json:"startTime"`
+	Adaptive  bool       `json:"adaptive"` // Picked from the player's mastery
+}
+
+type QuizAnswer struct {
+	QuestionID string `json:"question_id" binding:"required"`
+	Answer     string `json:"answer"` // The chosen choice, empty when skipped
+}
+
+type SubmitQuizRequest struct {
+	QuizID          string       `json:"quiz_id"`
+	Topic           string       `json:"topic" binding:"required"`
+	Answers         []QuizAnswer `json:"answers" binding:"required"`
+	DurationSeconds int          `json:"duration_seconds"`
+}
+
+type QuizResult struct {
+	QuestionID    string `json:"question_id"`
+	Prompt        string `json:"prompt"`
+	Answer        string `json:"answer"`
+	CorrectAnswer string `json:"correct_answer"`
+	Right         bool   `json:"right"`
+	Explanation   string `json:"explanation,omitempty"`
+}
+
+type QuizSummary struct {
+	QuizID   string            `json:"quiz_id,omitempty"`
+	Topic    string            `json:"topic"`
+	Total    int               `json:"total"`
+	Correct  int               `json:"correct"`
+	Accuracy float64           `json:"accuracy"` // Percent
+	Score    int               `json:"score"`
+	MaxScore int               `json:"max_score"`
+	Results  []QuizResult      `json:"results"`
+	Mastery  *QuizTopicMastery `json:"mastery,omitempty"`
+}
+
+type QuizMastery struct {
+	UserID       string         `dynamodbav:"user_id"`
+	Topic        string         `dynamodbav:"topic"`
+	Boxes        map[string]int `dynamodbav:"boxes"` // By question ID
+	Answered     int            `dynamodbav:"answered"`
+	Correct      int            `dynamodbav:"correct"`
+	Quizzes      int            `dynamodbav:"quizzes"`
+	BestAccuracy float64        `dynamodbav:"best_accuracy"`
+	LastPlayedAt time.Time      `dynamodbav:"last_played_at"`
+}
+
+// QuizTopicMastery is a player's standing in one topic
+type QuizTopicMastery struct {
+	Topic         string     `json:"topic"`
+	Title         string     `json:"title"`
+	Subject       string     `json:"subject"`
+	Questions     int        `json:"questions"`
+	Seen          int        `json:"seen"`
+	Mastered      int        `json:"mastered"`
+	Percent       float64    `json:"percent"` // Of the bank mastered
+	Accuracy      float64    `json:"accuracy"`
+	Quizzes       int        `json:"quizzes"`
+	BestAccuracy  float64    `json:"best_accuracy"`
+	NewlyMastered int        `json:"newly_mastered,omitempty"`
+	LastPlayedAt  *time.Time `json:"last_played_at,omitempty"`
+}
+
+type GenerateQuizQuestionsRequest struct {
+	Count int    `json:"count"`                   // Default 10
+	Focus string `json:"focus" binding:"max=200"` // e.g. "the water cycle"
+}
+
+// quizGenerationPrompt is what the quiz_questions template sees
+type quizGenerationPrompt struct {
+	Title       string
+	Subject     string
+	Description string `prompt:"max=1000"`
+	Focus       string
+	Count       int
+	Existing    []string `prompt:"max=500,items=500"` // Prompts already in the bank, to avoid repeats
+}
+
+type quizQuestionDraft struct {
+	Question     string   `json:"question"`
+	Answer       string   `json:"answer"`
+	WrongAnswers []string `json:"wrong_answers"`
+	Explanation  string   `json:"explanation"`
+}
+
+// listQuizTopics lists the topics with the size of their banks
+func (h *PuzzleHub) listQuizTopics(c *gin.Context) {
+	ctx := c.Request.Context()
+	topics := make([]QuizTopicInfo, 0, len(quizTopics))
+	for id, topic := range quizTopics {
+		bank, err := h.quizBank(ctx, id)
+		if err != nil {
+			requestLogger(c).Error("error fetching quiz bank", "error", err, "bank_id", id)
+			respondStorageError(c, err, "Failed to fetch quiz topics")
+			return
+		}
+		topics = append(topics, QuizTopicInfo{ID: id, Title: topic.Title, Subject: topic.Subject, Description: topic.Description, Questions: len(bank)})
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].ID < topics[j].ID })
+	c.JSON(http.StatusOK, gin.H{"topics": topics})
+}
+
+// startQuiz picks a quiz's questions, favouring the player's weak ones
+// when signed in
+func (h *PuzzleHub) startQuiz(c *gin.Context) {
+	var settings QuizSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	topic, ok := quizTopics[settings.Topic]
+	if !ok {
+		respondError(c, http.StatusBadRequest, "Unknown quiz topic "+settings.Topic)
+		return
+	}
+	if settings.Count == 0 {
+		settings.Count = defaultQuizLength
+	}
+	if settings.Count < 1 || settings.Count > maxQuizLength {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxQuizLength))
+		return
+	}
+	ctx := c.Request.Context()
+
+	bank, err := h.quizBank(ctx, settings.Topic)
+	if err != nil {
+		requestLogger(c).Error("error fetching quiz bank", "error", err, "topic", settings.Topic)
+		respondStorageError(c, err, "Failed to start quiz")
+		return
+	}
+
+	var mastery *QuizMastery
+	if userID := optionalUserID(c); userID != "" {
+		if mastery, err = h.getQuizMastery(ctx, userID, settings.Topic); err != nil {
+			// Fall back to a plain random quiz
+			requestLogger(c).Error("error fetching quiz mastery", "error", err)
+		}
+	}
+
+	quiz := h.YohakuGenerator.GenerateQuiz(settings, bank, mastery)
+	quiz.Title = topic.Title
+	c.JSON(http.StatusOK, gin.H{"quiz": quiz})
+}
+
+// GenerateQuiz picks up to settings.Count questions without repeats and
+// shuffles each one's choices
+func (g *YohakuGenerator) GenerateQuiz(settings QuizSettings, bank []QuizQuestion, mastery *QuizMastery) Quiz {
+	weights := make([]float64, len(bank))
+	for i, question := range bank {
+		box := 1
+		if mastery != nil {
+			if record, ok := mastery.Boxes[question.QuestionID]; ok {
+				box = record
+			}
+		}
+		weights[i] = quizBoxWeights[box]
+	}
+
+	count := min(settings.Count, len(bank))
+	items := make([]QuizItem, 0, count)
+	for len(items) < count {
+		i := weightedIndex(g.rand, weights)
+		if weights[i] == 0 {
+			continue
+		}
+		weights[i] = 0
+		question := bank[i]
+		choices := append([]string(nil), question.Choices...)
+		g.rand.Shuffle(len(choices), func(a, b int) { choices[a], choices[b] = choices[b], choices[a] })
+		items = append(items, QuizItem{ID: question.QuestionID, Prompt: question.Prompt, Choices: choices})
+	}
+
+	return Quiz{
+		ID:        fmt.Sprintf("quiz_%d", time.Now().UnixNano()),
+		Topic:     settings.Topic,
+		Questions: items,
+		StartTime: time.Now(),
+		Adaptive:  mastery != nil && len(mastery.Boxes) > 0,
+	}
+}
+
+// submitQuiz marks a finished quiz and, for signed-in players, updates
+// their topic mastery and records the result
+func (h *PuzzleHub) submitQuiz(c *gin.Context) {
+	var request SubmitQuizRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if _, ok := quizTopics[request.Topic]; !ok {
+		respondError(c, http.StatusBadRequest, "Unknown quiz topic "+request.Topic)
+		return
+	}
+	if len(request.Answers) == 0 || len(request.Answers) > maxQuizLength {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Send between 1 and %d answers", maxQuizLength))
+		return
+	}
+	if request.DurationSeconds < 0 || request.DurationSeconds > 24*60*60 {
+		respondError(c, http.StatusBadRequest, "Duration must be between 0 and 86400 seconds")
+		return
+	}
+	ctx := c.Request.Context()
+
+	bank, err := h.quizBank(ctx, request.Topic)
+	if err != nil {
+		requestLogger(c).Error("error fetching quiz bank", "error", err, "topic", request.Topic)
+		respondStorageError(c, err, "Failed to mark quiz")
+		return
+	}
+	questions := make(map[string]QuizQuestion, len(bank))
+	for _, question := range bank {
+		questions[question.QuestionID] = question
+	}
+
+	summary := QuizSummary{QuizID: request.QuizID, Topic: request.Topic, Results: []QuizResult{}}
+	answered := map[string]bool{}
+	for _, answer := range request.Answers {
+		question, ok := questions[answer.QuestionID]
+		if !ok {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Unknown question %q", answer.QuestionID))
+			return
+		}
+		if answered[question.QuestionID] {
+			continue
+		}
+		answered[question.QuestionID] = true
+
+		given := strings.TrimSpace(answer.Answer)
+		result := QuizResult{
+			QuestionID:    question.QuestionID,
+			Prompt:        question.Prompt,
+			Answer:        given,
+			CorrectAnswer: question.Choices[0],
+			Right:         strings.EqualFold(given, question.Choices[0]),
+			Explanation:   question.Explanation,
+		}
+		summary.Results = append(summary.Results, result)
+		summary.Total++
+		summary.MaxScore += quizPointsPerRight
+		if result.Right {
+			summary.Correct++
+			summary.Score += quizPointsPerRight
+		}
+	}
+	summary.Accuracy = math.Round(float64(summary.Correct)/float64(summary.Total)*1000) / 10
+
+	user, signedIn := c.Get("user")
+	if !signedIn {
+		c.JSON(http.StatusOK, gin.H{"summary": summary})
+		return
+	}
+	userObj := user.(*User)
+
+	mastery, err := h.updateQuizMastery(ctx, userObj.ID, summary)
+	if err != nil {
+		requestLogger(c).Error("error updating quiz mastery", "error", err)
+		respondStorageError(c, err, "Failed to save quiz")
+		return
+	}
+	summary.Mastery = mastery.report(len(bank), questions)
+	summary.Mastery.NewlyMastered = mastery.newlyMastered
+
+	result, err := h.saveActivityResult(userObj, "quiz", float64(summary.Score), float64(summary.MaxScore), request.DurationSeconds)
+	if err != nil {
+		requestLogger(c).Error("error saving quiz result", "error", err)
+		respondStorageError(c, err, "Failed to save quiz")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"summary": summary,
+		"result":  result,
+	})
+}
+
+// getQuizMasteryReport shows the player's mastery of every topic
+func (h *PuzzleHub) getQuizMasteryReport(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	records, err := h.listQuizMastery(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching quiz mastery", "error", err)
+		respondStorageError(c, err, "Failed to fetch mastery")
+		return
+	}
+
+	topics := make([]QuizTopicMastery, 0, len(quizTopics))
+	for id := range quizTopics {
+		bank, err := h.quizBank(ctx, id)
+		if err != nil {
+			requestLogger(c).Error("error fetching quiz bank", "error", err, "bank_id", id)
+			respondStorageError(c, err, "Failed to fetch mastery")
+			return
+		}
+		questions := make(map[string]QuizQuestion, len(bank))
+		for _, question := range bank {
+			questions[question.QuestionID] = question
+		}
+		record, ok := records[id]
+		if !ok {
+			record = &QuizMastery{Topic: id}
+		}
+		topics = append(topics, *record.report(len(bank), questions))
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Topic < topics[j].Topic })
+
+	c.JSON(http.StatusOK, gin.H{
+		"topics":      topics,
+		"mastered_at": masteredQuizBox,
+	})
+}
+
+// report summarizes a mastery record against the topic's current bank;
+// boxes for questions since removed from the bank don't count
+func (m *QuizMastery) report(bankSize int, questions map[string]QuizQuestion) *QuizTopicMastery {
+	topic := quizTopics[m.Topic]
+	report := &QuizTopicMastery{
+		Topic:        m.Topic,
+		Title:        topic.Title,
+		Subject:      topic.Subject,
+		Questions:    bankSize,
+		Quizzes:      m.Quizzes,
+		BestAccuracy: m.BestAccuracy,
+	}
+	for id, box := range m.Boxes {
+		if _, ok := questions[id]; !ok {
+			continue
+		}
+		report.Seen++
+		if box >= masteredQuizBox {
+			report.Mastered++
+		}
+	}
+	if bankSize > 0 {
+		report.Percent = math.Round(float64(report.Mastered)/float64(bankSize)*1000) / 10
+	}
+	if m.Answered > 0 {
+		report.Accuracy = math.Round(float64(m.Correct)/float64(m.Answered)*1000) / 10
+	}
+	if !m.LastPlayedAt.IsZero() {
+		lastPlayed := m.LastPlayedAt
+		report.LastPlayedAt = &lastPlayed
+	}
+	return report
+}
+
+// quizBank returns a topic's built-in questions followed by the stored ones
+func (h *PuzzleHub) quizBank(ctx context.Context, topic string) ([]QuizQuestion, error) {
+	key := "quiz-bank:" + topic
+	var stored []QuizQuestion
+	if !getCachedJSON(ctx, h.Cache, "quiz bank", key, &stored) {
+		var err error
+		stored, err = queryLimit[QuizQuestion](ctx, h.DynamoDB, &dynamodb.QueryInput{
+			TableName:              aws.String(tableName("puzzle-hub-quiz-questions")),
+			KeyConditionExpression: aws.String("topic = :topic"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":topic": {S: aws.String(topic)},
+			},
+		}, 0)
+		if err != nil {
+			return nil, err
+		}
+		setCachedJSON(ctx, h.Cache, key, stored, cachedValueTTL)
+	}
+
+	builtin := builtinQuizQuestions[topic]
+	bank := make([]QuizQuestion, 0, len(builtin)+len(stored))
+	bank = append(bank, builtin...)
+	return append(bank, stored...), nil
+}
+
+// getQuizMastery returns nil when the player hasn't played the topic
+func (h *PuzzleHub) getQuizMastery(ctx context.Context, userID, topic string) (*QuizMastery, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-quiz-mastery")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+			"topic":   {S: aws.String(topic)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var mastery QuizMastery
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &mastery); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal quiz mastery: %v", err)
+	}
+	return &mastery, nil
+}
+
+// listQuizMastery returns the player's records keyed by topic
+func (h *PuzzleHub) listQuizMastery(ctx context.Context, userID string) (map[string]*QuizMastery, error) {
+	masteries, err := queryLimit[QuizMastery](ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-quiz-mastery")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]*QuizMastery, len(masteries))
+	for i := range masteries {
+		records[masteries[i].Topic] = &masteries[i]
+	}
+	return records, nil
+}
+
+// quizMasteryUpdate is a saved record plus how many questions it mastered
+type quizMasteryUpdate struct {
+	*QuizMastery
+	newlyMastered int
+}
+
+// updateQuizMastery moves each answered question between boxes and adds
+// the quiz to the topic's totals
+func (h *PuzzleHub) updateQuizMastery(ctx context.Context, userID string, summary QuizSummary) (quizMasteryUpdate, error) {
+	mastery, err := h.getQuizMastery(ctx, userID, summary.Topic)
+	if err != nil {
+		return quizMasteryUpdate{}, err
+	}
+	if mastery == nil {
+		mastery = &QuizMastery{UserID: userID, Topic: summary.Topic}
+	}
+	if mastery.Boxes == nil {
+		mastery.Boxes = map[string]int{}
+	}
+
+	update := quizMasteryUpdate{QuizMastery: mastery}
+	for _, result := range summary.Results {
+		box := mastery.Boxes[result.QuestionID] // New questions start in box 0
+		if !result.Right {
+			mastery.Boxes[result.QuestionID] = 0
+			continue
+		}
+		mastery.Boxes[result.QuestionID] = min(box+1, maxQuizBox)
+		if box < masteredQuizBox && box+1 >= masteredQuizBox {
+			update.newlyMastered++
+		}
+	}
+	mastery.Answered += summary.Total
+	mastery.Correct += summary.Correct
+	mastery.Quizzes++
+	mastery.BestAccuracy = max(mastery.BestAccuracy, summary.Accuracy)
+	mastery.LastPlayedAt = time.Now()
+
+	item, err := dynamodbattribute.MarshalMap(mastery)
+	if err != nil {
+		return quizMasteryUpdate{}, fmt.Errorf("failed to marshal quiz mastery: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-quiz-mastery")),
+		Item:      item,
+	})
+	return update, err
+}
+
+// adminListQuizQuestions lists a topic's whole bank, answers included
+func (h *PuzzleHub) adminListQuizQuestions(c *gin.Context) {
+	topic := c.Param("topic")
+	if _, ok := quizTopics[topic]; !ok {
+		respondError(c, http.StatusNotFound, "Quiz topic not found")
+		return
+	}
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	bank, err := h.quizBank(c.Request.Context(), topic)
+	if err != nil {
+		requestLogger(c).Error("error fetching quiz bank", "error", err, "topic", topic)
+		respondStorageError(c, err, "Failed to fetch questions")
+		return
+	}
+	respondPage(c, "questions", bank, page, gin.H{"topic": topic})
+}
+
+// adminGenerateQuizQuestions has AI write new questions for a topic and
+// adds the ones that aren't repeats to its bank
+func (h *PuzzleHub) adminGenerateQuizQuestions(c *gin.Context) {
+	topicID := c.Param("topic")
+	topic, ok := quizTopics[topicID]
+	if !ok {
+		respondError(c, http.StatusNotFound, "Quiz topic not found")
+		return
+	}
+	var request GenerateQuizQuestionsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if request.Count == 0 {
+		request.Count = defaultQuizLength
+	}
+	if request.Count < 1 || request.Count > maxQuizGeneration {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxQuizGeneration))
+		return
+	}
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	bank, err := h.quizBank(ctx, topicID)
+	if err != nil {
+		requestLogger(c).Error("error fetching quiz bank", "error", err, "topic_id", topicID)
+		respondStorageError(c, err, "Failed to generate questions")
+		return
+	}
+	existing := make(map[string]bool, len(bank))
+	prompts := make([]string, 0, len(bank))
+	for _, question := range bank {
+		existing[normalizeCacheParam(question.Prompt)] = true
+		prompts = append(prompts, question.Prompt)
+	}
+
+	choice := h.Prompts.choose("quiz_questions", userObj.ID)
+	call := aiCall{Feature: "quiz", UserID: userObj.ID, Prompt: choice.PromptTag, Generation: newAIGeneration()}
+	prompt := h.Prompts.renderChoice(choice, quizGenerationPrompt{
+		Title:       topic.Title,
+		Subject:     topic.Subject,
+		Description: topic.Description,
+		Focus:       strings.TrimSpace(request.Focus),
+		Count:       request.Count,
+		Existing:    prompts,
+	})
+	var generated struct {
+		Questions []quizQuestionDraft `json:"questions"`
+	}
+	err = h.generateJSON(ctx, prompt, call, quizQuestionsSchema, &generated, func() error {
+		if len(generated.Questions) == 0 {
+			return fmt.Errorf("no questions")
+		}
+		for i := range generated.Questions {
+			if err := generated.Questions[i].check(); err != nil {
+				return fmt.Errorf("question %d: %v", i+1, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		requestLogger(c).Error("quiz question generation failed", "error", err, "provider", h.providerFor("quiz"))
+		respondError(c, http.StatusBadGateway, "Failed to generate questions")
+		return
+	}
+	h.saveGeneration(call.Generation, call, choice)
+
+	now := time.Now()
+	added := []QuizQuestion{}
+	skipped := 0
+	for i, draft := range generated.Questions {
+		key := normalizeCacheParam(draft.Question)
+		if existing[key] || len(added) == request.Count {
+			skipped++
+			continue
+		}
+		existing[key] = true
+		added = append(added, QuizQuestion{
+			Topic:        topicID,
+			QuestionID:   fmt.Sprintf("ai-%d-%d", now.UnixNano(), i),
+			Prompt:       strings.TrimSpace(draft.Question),
+			Choices:      append([]string{strings.TrimSpace(draft.Answer)}, draft.WrongAnswers...),
+			Explanation:  strings.TrimSpace(draft.Explanation),
+			Source:       quizSourceAI,
+			GenerationID: call.Generation.ID,
+			CreatedBy:    userObj.ID,
+			CreatedAt:    &now,
+		})
+	}
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(added))
+	for _, question := range added {
+		item, err := dynamodbattribute.MarshalMap(question)
+		if err != nil {
+			requestLogger(c).Error("error marshaling quiz question", "error", err)
+			respondStorageError(c, err, "Failed to save questions")
+			return
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	if err := batchWriteItems(h.DynamoDB, tableName("puzzle-hub-quiz-questions"), requests); err != nil {
+		requestLogger(c).Error("error saving quiz questions", "error", err, "topic_id", topicID)
+		respondStorageError(c, err, "Failed to save questions")
+		return
+	}
+	invalidateCache(ctx, h.Cache, "quiz-bank:"+topicID)
+
+	requestLogger(c).Info("quiz questions generated", "topic", topicID, "added", len(added), "skipped", skipped)
+	c.JSON(http.StatusOK, gin.H{
+		"questions": added,
+		"skipped":   skipped, // Repeats of questions already in the bank
+	})
+}
+
+// adminDeleteQuizQuestion removes an AI-written question from its bank;
+// built-in questions can't be removed
+func (h *PuzzleHub) adminDeleteQuizQuestion(c *gin.Context) {
+	topic := c.Param("topic")
+	if _, ok := quizTopics[topic]; !ok {
+		respondError(c, http.StatusNotFound, "Quiz topic not found")
+		return
+	}
+	questionID := c.Param("id")
+	for _, question := range builtinQuizQuestions[topic] {
+		if question.QuestionID == questionID {
+			respondError(c, http.StatusBadRequest, "Built-in questions can't be deleted")
+			return
+		}
+	}
+
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-quiz-questions")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"topic":       {S: aws.String(topic)},
+			"question_id": {S: aws.String(questionID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(question_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Question not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error deleting quiz question", "error", err, "topic", topic, "question_id", questionID)
+		respondStorageError(c, err, "Failed to delete question")
+		return
+	}
+	invalidateCache(c.Request.Context(), h.Cache, "quiz-bank:"+topic)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Question deleted"})
+}
+
+// check trims a draft and makes sure it has one answer and three distinct
+// wrong answers
+func (d *quizQuestionDraft) check() error {
+	if strings.TrimSpace(d.Question) == "" {
+		return fmt.Errorf("question is empty")
+	}
+	answer := strings.TrimSpace(d.Answer)
+	if answer == "" {
+		return fmt.Errorf("answer is empty")
+	}
+	if len(d.WrongAnswers) != quizChoices-1 {
+		return fmt.Errorf("expected %d wrong answers, got %d", quizChoices-1, len(d.WrongAnswers))
+	}
+	seen := map[string]bool{strings.ToLower(answer): true}
+	for i, wrong := range d.WrongAnswers {
+		wrong = strings.TrimSpace(wrong)
+		if wrong == "" || seen[strings.ToLower(wrong)] {
+			return fmt.Errorf("wrong answer %q is empty or repeated", wrong)
+		}
+		seen[strings.ToLower(wrong)] = true
+		d.WrongAnswers[i] = wrong
+	}
+	return nil
+}
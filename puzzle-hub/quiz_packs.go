@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Built-in quiz packs
+//
+// These questions ship with the binary and are always part of their
+// topic's bank; AI-generated questions are added on top (see quiz.go).
+// Question IDs are stable so mastery and missed-question lists survive
+// restarts.
+
+type quizFact struct {
+	Prompt      string
+	Answer      string
+	Wrong       []string
+	Explanation string
+}
+
+var usStateCapitals = [][2]string{
+	{"Alabama", "Montgomery"}, {"Alaska", "Juneau"}, {"Arizona", "Phoenix"}, {"Arkansas", "Little Rock"},
+	{"California", "Sacramento"}, {"Colorado", "Denver"}, {"Connecticut", "Hartford"}, {"Delaware", "Dover"},
+	{"Florida", "Tallahassee"}, {"Georgia", "Atlanta"}, {"Hawaii", "Honolulu"}, {"Idaho", "Boise"},
+	{"Illinois", "Springfield"}, {"Indiana", "Indianapolis"}, {"Iowa", "Des Moines"}, {"Kansas", "Topeka"},
+	{"Kentucky", "Frankfort"}, {"Louisiana", "Baton Rouge"}, {"Maine", "Augusta"}, {"Maryland", "Annapolis"},
+	{"Massachusetts", "Boston"}, {"Michigan", "Lansing"}, {"Minnesota", "Saint Paul"}, {"Mississippi", "Jackson"},
+	{"Missouri", "Jefferson City"}, {"Montana", "Helena"}, {"Nebraska", "Lincoln"}, {"Nevada", "Carson City"},
+	{"New Hampshire", "Concord"}, {"New Jersey", "Trenton"}, {"New Mexico", "Santa Fe"}, {"New York", "Albany"},
+	{"North Carolina", "Raleigh"}, {"North Dakota", "Bismarck"}, {"Ohio", "Columbus"}, {"Oklahoma", "Oklahoma City"},
+	{"Oregon", "Salem"}, {"Pennsylvania", "Harrisburg"}, {"Rhode Island", "Providence"}, {"South Carolina", "Columbia"},
+	{"South Dakota", "Pierre"}, {"Tennessee", "Nashville"}, {"Texas", "Austin"}, {"Utah", "Salt Lake City"},
+	{"Vermont", "Montpelier"}, {"Virginia", "Richmond"}, {"Washington", "Olympia"}, {"West Virginia", "Charleston"},
+	{"Wisconsin", "Madison"}, {"Wyoming", "Cheyenne"},
+}
+
+var scienceBasics = []quizFact{
+	{"What gas do plants take in from the air to make their food?", "Carbon dioxide", []string{"Oxygen", "Nitrogen", "Helium"}, "Plants use carbon dioxide, water and sunlight to make sugar, and give off oxygen."},
+	{"What is the closest star to Earth?", "The Sun", []string{"Polaris", "Sirius", "Alpha Centauri"}, "The Sun is a star, about 150 million kilometres away."},
+	{"At what temperature does water freeze, in degrees Celsius?", "0", []string{"32", "100", "-10"}, "Water freezes at 0 °C, which is 32 °F."},
+	{"At what temperature does water boil at sea level, in degrees Celsius?", "100", []string{"212", "50", "90"}, "Water boils at 100 °C (212 °F) at sea level."},
+	{"Which planet is known as the Red Planet?", "Mars", []string{"Jupiter", "Venus", "Saturn"}, "Iron oxide (rust) in its soil makes Mars look red."},
+	{"What is the largest planet in our solar system?", "Jupiter", []string{"Saturn", "Earth", "Neptune"}, "Jupiter is more than 11 times as wide as Earth."},
+	{"What force pulls objects toward the Earth?", "Gravity", []string{"Magnetism", "Friction", "Electricity"}, "Gravity is the pull between masses; Earth's gravity keeps us on the ground."},
+	{"What do we call animals that eat only plants?", "Herbivores", []string{"Carnivores", "Omnivores", "Predators"}, "Herbivores, like cows and rabbits, eat only plants."},
+	{"What part of the plant takes in water from the soil?", "The roots", []string{"The leaves", "The flower", "The stem"}, "Roots absorb water and minerals and anchor the plant."},
+	{"What are the three states of matter we see every day?", "Solid, liquid and gas", []string{"Hot, warm and cold", "Rock, water and air", "Metal, wood and plastic"}, "Matter is usually a solid, a liquid or a gas; ice, water and steam are one substance in all three."},
+	{"Which organ pumps blood around the body?", "The heart", []string{"The lungs", "The liver", "The brain"}, "The heart is a muscle that beats about 100,000 times a day."},
+	{"How many bones are in the adult human body?", "206", []string{"106", "306", "150"}, "Babies are born with around 300 bones, some of which fuse as they grow."},
+	{"What do bees collect from flowers to make honey?", "Nectar", []string{"Pollen", "Leaves", "Seeds"}, "Bees turn sugary nectar into honey; they carry pollen too, which helps plants make seeds."},
+	{"What is the hardest natural substance?", "Diamond", []string{"Gold", "Iron", "Granite"}, "Diamond is carbon arranged in a very strong crystal."},
+	{"Which planet is closest to the Sun?", "Mercury", []string{"Venus", "Earth", "Mars"}, "Mercury orbits the Sun in just 88 days."},
+	{"What is the center of an atom called?", "The nucleus", []string{"The electron", "The shell", "The core"}, "The nucleus holds protons and neutrons; electrons move around it."},
+	{"What do caterpillars turn into?", "Butterflies or moths", []string{"Beetles", "Spiders", "Bees"}, "Inside a chrysalis or cocoon, a caterpillar changes into a butterfly or moth. This is called metamorphosis."},
+	{"Which gas do humans need to breathe in to live?", "Oxygen", []string{"Carbon dioxide", "Hydrogen", "Helium"}, "Our lungs take oxygen from the air into the blood."},
+	{"What is the name of the process where water vapor turns back into liquid, forming clouds?", "Condensation", []string{"Evaporation", "Precipitation", "Melting"}, "Cooling water vapor condenses into tiny droplets, which make clouds."},
+	{"What kind of energy does a moving object have?", "Kinetic energy", []string{"Potential energy", "Chemical energy", "Nuclear energy"}, "Kinetic energy is the energy of motion."},
+	{"Which animal group has feathers?", "Birds", []string{"Mammals", "Reptiles", "Fish"}, "Birds are the only animals with feathers."},
+	{"What causes day and night on Earth?", "Earth spinning on its axis", []string{"Earth orbiting the Sun", "The Moon blocking the Sun", "Clouds covering the sky"}, "Earth turns once a day, so each side faces the Sun and then away from it."},
+	{"How long does it take Earth to go around the Sun?", "About 365 days", []string{"About 30 days", "About 24 hours", "About 100 days"}, "One orbit of the Sun is one year, about 365 and a quarter days."},
+	{"What is the largest organ of the human body?", "The skin", []string{"The liver", "The heart", "The lungs"}, "Skin covers and protects the whole body."},
+	{"Which of these is a mammal?", "Whale", []string{"Shark", "Octopus", "Salmon"}, "Whales breathe air and feed their young milk, like all mammals."},
+	{"What simple machine is a ramp?", "An inclined plane", []string{"A lever", "A pulley", "A wheel and axle"}, "An inclined plane lets you lift a load using less force over a longer distance."},
+	{"What do magnets attract?", "Iron and steel", []string{"Wood and paper", "Glass and plastic", "Gold and silver"}, "Magnets pull on iron and a few other metals, like nickel and cobalt."},
+	{"What is the main source of energy for life on Earth?", "The Sun", []string{"The Moon", "Volcanoes", "The wind"}, "Plants capture sunlight, and almost every food chain starts with them."},
+	{"What is a baby frog called?", "A tadpole", []string{"A cub", "A kit", "A fry"}, "Tadpoles live in water and grow legs as they turn into frogs."},
+	{"Which layer of gases surrounds the Earth?", "The atmosphere", []string{"The crust", "The mantle", "The ozone hole"}, "The atmosphere is the air around Earth; it gives us oxygen and keeps us warm."},
+}
+
+// builtinQuizQuestions is each topic's built-in pack
+var builtinQuizQuestions = map[string][]QuizQuestion{
+	"us-capitals":    capitalQuestions(),
+	"science-basics": factQuestions("science-basics", "sci", scienceBasics),
+}
+
+// capitalQuestions asks both ways round: a state's capital, and whose
+// capital a city is. Wrong answers are other states' capitals (or states),
+// picked with a fixed seed so a question's choices don't change.
+func capitalQuestions() []QuizQuestion {
+	rng := rand.New(rand.NewSource(50))
+	others := func(i, column int) []string {
+		var picked []string
+		for _, j := range rng.Perm(len(usStateCapitals)) {
+			if j != i && len(picked) < 3 {
+				picked = append(picked, usStateCapitals[j][column])
+			}
+		}
+		return picked
+	}
+
+	questions := make([]QuizQuestion, 0, 2*len(usStateCapitals))
+	for i, pair := range usStateCapitals {
+		state, capital := pair[0], pair[1]
+		slug := strings.ReplaceAll(strings.ToLower(state), " ", "-")
+		questions = append(questions,
+			QuizQuestion{
+				Topic:       "us-capitals",
+				QuestionID:  "capital-of-" + slug,
+				Prompt:      fmt.Sprintf("What is the capital of %s?", state),
+				Choices:     append([]string{capital}, others(i, 1)...),
+				Explanation: fmt.Sprintf("%s is the capital of %s.", capital, state),
+				Source:      quizSourceBuiltin,
+			},
+			QuizQuestion{
+				Topic:       "us-capitals",
+				QuestionID:  "state-of-" + slug,
+				Prompt:      fmt.Sprintf("%s is the capital of which state?", capital),
+				Choices:     append([]string{state}, others(i, 0)...),
+				Explanation: fmt.Sprintf("%s is the capital of %s.", capital, state),
+				Source:      quizSourceBuiltin,
+			},
+		)
+	}
+	return questions
+}
+
+func factQuestions(topic, prefix string, facts []quizFact) []QuizQuestion {
+	questions := make([]QuizQuestion, len(facts))
+	for i, fact := range facts {
+		questions[i] = QuizQuestion{
+			Topic:       topic,
+			QuestionID:  fmt.Sprintf("%s-%d", prefix, i+1),
+			Prompt:      fact.Prompt,
+			Choices:     append([]string{fact.Answer}, fact.Wrong...),
+			Explanation: fact.Explanation,
+			Source:      quizSourceBuiltin,
+		}
+	}
+	return questions
+}
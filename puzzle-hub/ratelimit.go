@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate limiting. Every API and auth request spends a token from the
+// caller's "api" bucket; AI-backed routes also spend one from the much
+// smaller "ai" bucket, since each of those costs a provider call. Callers
+// are keyed by user ID when signed in and by client IP otherwise (see
+// trustedProxies for how the IP is found behind a load balancer).
+//
+// Budgets are per minute with a burst allowance and can be changed with
+// RATE_LIMIT_<NAME>_PER_MINUTE and RATE_LIMIT_<NAME>_BURST; a rate of 0
+// turns that limit off. Buckets live in memory, so with several instances
+// each enforces the budget on its own.
+
+const rateLimitSweepInterval = 5 * time.Minute
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter is a token bucket per caller for one budget
+type RateLimiter struct {
+	name      string
+	perSecond float64
+	burst     float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates the named budget, reading its overrides from the
+// environment
+func NewRateLimiter(name string, perMinute, burst int) *RateLimiter {
+	prefix := "RATE_LIMIT_" + strings.ToUpper(name)
+	if value, err := strconv.Atoi(os.Getenv(prefix + "_PER_MINUTE")); err == nil && value >= 0 {
+		perMinute = value
+	}
+	if value, err := strconv.Atoi(os.Getenv(prefix + "_BURST")); err == nil && value > 0 {
+		burst = value
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		name:      name,
+		perSecond: float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow spends a token for key, or reports how long until one is available
+func (l *RateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l.perSecond <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.perSecond)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / l.perSecond * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have refilled completely, which are the same as
+// a new one. Caller holds l.mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.perSecond >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Middleware rejects requests over budget with 429 and Retry-After. Put it
+// after the auth middleware so signed-in users get their own bucket.
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, wait := l.allow(rateLimitKey(c), time.Now())
+		if allowed {
+			c.Next()
+			return
+		}
+
+		rateLimitedRequests.WithLabelValues(l.name).Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		abortWithError(c, http.StatusTooManyRequests, "rate_limited", "Too many requests. Please slow down and try again shortly.", nil)
+	}
+}
+
+// rateLimitKey identifies the caller: the signed-in user, else the client IP
+func rateLimitKey(c *gin.Context) string {
+	if user, ok := c.Get("user"); ok {
+		if userObj, ok := user.(*User); ok && userObj != nil {
+			return "user:" + userObj.ID
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
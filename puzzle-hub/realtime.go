@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// Realtime gateway
+//
+// GET /api/v1/ws upgrades to a WebSocket. Browsers can't set headers on a
+// WebSocket request, so the access token comes as ?access_token=. Messages
+// are JSON in both directions:
+//
+//	client → {"type": "subscribe" | "unsubscribe", "topic": "yohaku:<room>"}
+//	client → {"type": "publish", "topic": "yohaku:<room>", "data": {...}}
+//	server → {"type": "event", "topic": "...", "event": "...", "data": ..., "from": {...}}
+//
+// Each connection is subscribed to its own "user:<id>" topic, which only
+// the server publishes to (notifications). Multiplayer yohaku and sudoku
+// and collaborative stories use "yohaku:<room>", "sudoku:<room>" and
+// "story:<room>" topics, which any signed-in member can join and publish
+// to; rooms come from POST /api/v1/realtime/rooms and are unguessable. With CACHE_BACKEND=redis,
+// events go through a Redis channel so connections on every instance see
+// them; otherwise pub/sub is in-process and only reaches this instance.
+
+const (
+	wsWriteTimeout  = 10 * time.Second
+	wsPongTimeout   = 60 * time.Second
+	wsPingInterval  = 25 * time.Second // Must be below wsPongTimeout
+	wsMaxMessage    = 16 * 1024
+	wsSendBuffer    = 32
+	wsMaxTopics     = 20
+	realtimeRoomLen = 12
+)
+
+// realtimeRoomKinds are the topic prefixes clients may join and publish to
+var realtimeRoomKinds = map[string]bool{
+	"yohaku": true,
+	"sudoku": true,
+	"story":  true,
+}
+
+// RealtimeMessage is sent to subscribers of a topic
+type RealtimeMessage struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic,omitempty"`
+	Event string          `json:"event,omitempty"`
+	Data  interface{}     `json:"data,omitempty"`
+	From  *RealtimeSender `json:"from,omitempty"` // Set on messages from other clients
+}
+
+type RealtimeSender struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+type realtimeClientMessage struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// realtimeEnvelope carries a message between instances
+type realtimeEnvelope struct {
+	Topic   string          `json:"topic"`
+	Except  string          `json:"except,omitempty"` // ID of the connection that sent it
+	Message json.RawMessage `json:"message"`
+}
+
+// RealtimeHub routes published messages to the connections subscribed to
+// each topic
+type RealtimeHub struct {
+	mu     sync.RWMutex
+	topics map[string]map[*realtimeConn]bool
+
+	redis   *redis.Client // Fans messages out to other instances, nil for in-process only
+	channel string
+}
+
+// NewRealtimeHub shares events between instances through Redis when the
+// cache is Redis-backed
+func NewRealtimeHub(cache Cache) *RealtimeHub {
+	r := &RealtimeHub{topics: make(map[string]map[*realtimeConn]bool)}
+	if rc, ok := cache.(*redisCache); ok {
+		r.redis = rc.client
+		r.channel = tableName("puzzle-hub:") + "realtime"
+		go r.listen()
+	}
+	return r
+}
+
+// Publish sends an event to every subscriber of topic on this instance
+func (r *RealtimeHub) Publish(topic, event string, data interface{}) {
+	r.publish(RealtimeMessage{Type: "event", Topic: topic, Event: event, Data: data}, nil)
+}
+
+// PublishToUser sends an event to all of a user's connections
+func (r *RealtimeHub) PublishToUser(userID, event string, data interface{}) {
+	r.Publish("user:"+userID, event, data)
+}
+
+func (r *RealtimeHub) publish(message RealtimeMessage, except *realtimeConn) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error encoding realtime message for %s: %v", message.Topic, err)
+		return
+	}
+	exceptID := ""
+	if except != nil {
+		exceptID = except.id
+	}
+
+	if r.redis != nil {
+		envelope, err := json.Marshal(realtimeEnvelope{Topic: message.Topic, Except: exceptID, Message: payload})
+		if err == nil {
+			err = r.redis.Publish(context.Background(), r.channel, envelope).Err()
+		}
+		if err == nil {
+			return // Delivered here by listen, like on every other instance
+		}
+		log.Printf("⚠️  Failed to publish realtime message through Redis, delivering locally: %v", err)
+	}
+	r.deliver(message.Topic, payload, exceptID)
+}
+
+// deliver sends a message to this instance's subscribers of topic
+func (r *RealtimeHub) deliver(topic string, payload []byte, exceptID string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for conn := range r.topics[topic] {
+		if exceptID == "" || conn.id != exceptID {
+			conn.enqueue(payload)
+		}
+	}
+}
+
+// listen delivers messages published through Redis by any instance. The
+// client reconnects and resubscribes by itself after Redis errors.
+func (r *RealtimeHub) listen() {
+	subscription := r.redis.Subscribe(context.Background(), r.channel)
+	for message := range subscription.Channel() {
+		var envelope realtimeEnvelope
+		if err := json.Unmarshal([]byte(message.Payload), &envelope); err != nil {
+			log.Printf("Error decoding realtime message from Redis: %v", err)
+			continue
+		}
+		r.deliver(envelope.Topic, envelope.Message, envelope.Except)
+	}
+}
+
+func (r *RealtimeHub) subscribe(topic string, conn *realtimeConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.topics[topic] == nil {
+		r.topics[topic] = make(map[*realtimeConn]bool)
+	}
+	r.topics[topic][conn] = true
+}
+
+func (r *RealtimeHub) unsubscribe(topic string, conn *realtimeConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.topics[topic], conn)
+	if len(r.topics[topic]) == 0 {
+		delete(r.topics, topic)
+	}
+}
+
+// realtimeConn is one WebSocket connection. Only writePump writes to ws.
+type realtimeConn struct {
+	id   string // Unique across instances
+	ws   *websocket.Conn
+	hub  *RealtimeHub
+	user *User
+	send chan []byte
+
+	mu     sync.Mutex
+	topics map[string]bool
+	closed bool
+}
+
+// enqueue queues a message without blocking; a client too slow to keep up
+// is disconnected rather than holding up the publisher
+func (c *realtimeConn) enqueue(payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.send <- payload:
+	default:
+		c.closed = true
+		close(c.send)
+	}
+}
+
+func (c *realtimeConn) close() {
+	c.mu.Lock()
+	topics := c.topics
+	c.topics = nil
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+	c.mu.Unlock()
+
+	for topic := range topics {
+		c.hub.unsubscribe(topic, c)
+	}
+}
+
+func (c *realtimeConn) join(topic string) bool {
+	c.mu.Lock()
+	if c.topics == nil || (len(c.topics) >= wsMaxTopics && !c.topics[topic]) {
+		c.mu.Unlock()
+		return false
+	}
+	c.topics[topic] = true
+	c.mu.Unlock()
+
+	c.hub.subscribe(topic, c)
+	return true
+}
+
+func (c *realtimeConn) leave(topic string) {
+	c.mu.Lock()
+	if c.topics != nil {
+		delete(c.topics, topic)
+	}
+	c.mu.Unlock()
+	c.hub.unsubscribe(topic, c)
+}
+
+func (c *realtimeConn) joined(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topics[topic]
+}
+
+// reply sends a message to this connection only
+func (c *realtimeConn) reply(message RealtimeMessage) {
+	payload, err := json.Marshal(message)
+	if err == nil {
+		c.enqueue(payload)
+	}
+}
+
+// readPump handles client messages until the connection fails or goes quiet
+func (c *realtimeConn) readPump() {
+	defer c.close()
+
+	c.ws.SetReadLimit(wsMaxMessage)
+	c.ws.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		var message realtimeClientMessage
+		if err := c.ws.ReadJSON(&message); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Realtime connection for %s closed: %v", c.user.ID, err)
+			}
+			return
+		}
+
+		switch message.Type {
+		case "subscribe":
+			if !isRealtimeRoomTopic(message.Topic) {
+				c.reply(RealtimeMessage{Type: "error", Topic: message.Topic, Data: "Unknown topic"})
+			} else if !c.join(message.Topic) {
+				c.reply(RealtimeMessage{Type: "error", Topic: message.Topic, Data: "Too many subscriptions"})
+			} else {
+				c.reply(RealtimeMessage{Type: "subscribed", Topic: message.Topic})
+			}
+		case "unsubscribe":
+			if isRealtimeRoomTopic(message.Topic) {
+				c.leave(message.Topic)
+			}
+		case "publish":
+			if !c.joined(message.Topic) || !isRealtimeRoomTopic(message.Topic) {
+				c.reply(RealtimeMessage{Type: "error", Topic: message.Topic, Data: "Subscribe to the topic before publishing"})
+				continue
+			}
+			c.hub.publish(RealtimeMessage{
+				Type:  "event",
+				Topic: message.Topic,
+				Event: message.Event,
+				Data:  message.Data,
+				From:  &RealtimeSender{UserID: c.user.ID, Name: c.user.Name},
+			}, c)
+		case "ping":
+			c.reply(RealtimeMessage{Type: "pong"})
+		default:
+			c.reply(RealtimeMessage{Type: "error", Data: "Unknown message type"})
+		}
+	}
+}
+
+// writePump sends queued messages and heartbeat pings
+func (c *realtimeConn) writePump() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case payload, ok := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				c.ws.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// isRealtimeRoomTopic reports whether clients may use topic, "<kind>:<room>"
+func isRealtimeRoomTopic(topic string) bool {
+	kind, room, ok := strings.Cut(topic, ":")
+	return ok && realtimeRoomKinds[kind] && len(room) == realtimeRoomLen*2 && isHex(room)
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// realtimeUpgrader accepts same-origin connections and CORS_ALLOWED_ORIGINS
+func realtimeUpgrader() *websocket.Upgrader {
+	allowed := corsAllowedOrigins()
+	return &websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || allowed["*"] || allowed[origin] {
+				return true
+			}
+			parsed, err := url.Parse(origin)
+			return err == nil && parsed.Host == r.Host
+		},
+	}
+}
+
+// realtimeConnect authenticates and upgrades a WebSocket connection
+func (h *PuzzleHub) realtimeConnect(upgrader *websocket.Upgrader) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("access_token")
+		if token == "" {
+			token, _ = bearerToken(c)
+		}
+		if token == "" {
+			respondError(c, http.StatusUnauthorized, "access_token is required")
+			return
+		}
+		user, err := h.validateJWT(token)
+		if err != nil {
+			respondError(c, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		c.Set("user", user)
+		connID, err := randomToken(8)
+		if err != nil {
+			requestLogger(c).Error("error creating realtime connection ID", "error", err)
+			respondStorageError(c, err, "Failed to open connection")
+			return
+		}
+
+		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return // The upgrader has already written the error response
+		}
+
+		conn := &realtimeConn{
+			id:     connID,
+			ws:     ws,
+			hub:    h.Realtime,
+			user:   user,
+			send:   make(chan []byte, wsSendBuffer),
+			topics: make(map[string]bool),
+		}
+		h.Realtime.subscribe("user:"+user.ID, conn)
+		conn.topics["user:"+user.ID] = true
+
+		go conn.writePump()
+		conn.readPump()
+	}
+}
+
+// createRealtimeRoom starts a room for multiplayer yohaku or sudoku, or a shared story
+func (h *PuzzleHub) createRealtimeRoom(c *gin.Context) {
+	var request struct {
+		Kind string `json:"kind" binding:"required,oneof=yohaku sudoku story"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	room, err := randomToken(realtimeRoomLen)
+	if err != nil {
+		requestLogger(c).Error("error creating realtime room", "error", err)
+		respondStorageError(c, err, "Failed to create room")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"room":  room,
+		"topic": request.Kind + ":" + room,
+	})
+}
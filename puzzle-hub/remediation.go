@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Remediation packets
+//
+// After a spelling or quiz session the client can ask for a packet for the
+// items the child missed: a few example sentences and a memory hint for
+// each, written by AI in one call, a small word search hiding the missed
+// words (or quiz answers), and flashcards for each item. The cards go into
+// the spelling or quiz deck due the next day, so the child studies the
+// packet today and reviews tomorrow.
+//
+// Spelling words come from the request, or from a finished spelling bee
+// session (its wrong attempts). Quiz items are question IDs from the
+// submitted quiz's results. When AI is unavailable the sentences come from
+// the word bank or the question's explanation and the hint breaks the word
+// into chunks. Packets aren't stored; ask again for a fresh one.
+
+const (
+	maxRemediationItems   = 10
+	maxRemediationWordLen = 30
+	remediationReviewIn   = 24 * time.Hour
+	minWordSearchSize     = 8
+	maxWordSearchSize     = 15
+	wordSearchAttempts    = 200
+)
+
+type RemediationRequest struct {
+	Activity    string   `json:"activity" binding:"required"` // spelling or quiz
+	Words       []string `json:"words"`                       // Spelling: the missed words
+	SessionID   string   `json:"session_id"`                  // Spelling: a spelling bee session instead of words
+	Topic       string   `json:"topic"`                       // Quiz: the topic
+	QuestionIDs []string `json:"question_ids"`                // Quiz: the missed questions
+	AgeGroup    string   `json:"age_group"`
+	Flashcards  *bool    `json:"flashcards"` // Default true
+}
+
+type RemediationPacket struct {
+	Activity   string                 `json:"activity"`
+	Topic      string                 `json:"topic,omitempty"`
+	Items      []RemediationItem      `json:"items"`
+	WordSearch *WordSearch            `json:"word_search,omitempty"`
+	Flashcards *RemediationFlashcards `json:"flashcards,omitempty"`
+	Source     string                 `json:"source"` // ai or fallback
+}
+
+type RemediationItem struct {
+	Item        string   `json:"item"`             // The word, or the quiz question
+	Answer      string   `json:"answer,omitempty"` // Quiz only
+	Attempt     string   `json:"attempt,omitempty"`
+	Explanation string   `json:"explanation,omitempty"`
+	Sentences   []string `json:"sentences"`
+	Mnemonic    string   `json:"mnemonic,omitempty"`
+
+	key      string // Flashcard key
+	sentence string // Fallback example sentence
+}
+
+type RemediationFlashcards struct {
+	Deck     string    `json:"deck"`
+	Added    int       `json:"added"`
+	DueAgain int       `json:"due_again"`
+	DueAt    time.Time `json:"due_at"`
+}
+
+// remediationPrompt is what the remediation template sees
+type remediationPrompt struct {
+	Activity string
+	Topic    string
+	AgeGroup string
+	Items    []RemediationItem
+}
+
+type remediationDraft struct {
+	Item      string   `json:"item"`
+	Sentences []string `json:"sentences"`
+	Mnemonic  string   `json:"mnemonic"`
+}
+
+// WordSearch is a square grid of letters with the words hidden across,
+// down or diagonally down and to the right
+type WordSearch struct {
+	Size     int                `json:"size"`
+	Grid     []string           `json:"grid"` // One string per row
+	Words    []WordSearchAnswer `json:"words"`
+	Unplaced []string           `json:"unplaced,omitempty"`
+}
+
+type WordSearchAnswer struct {
+	Word      string `json:"word"`
+	Row       int    `json:"row"`
+	Col       int    `json:"col"`
+	Direction string `json:"direction"` // across, down or diagonal
+}
+
+var wordSearchDirections = []struct {
+	name   string
+	dr, dc int
+}{
+	{"across", 0, 1},
+	{"down", 1, 0},
+	{"diagonal", 1, 1},
+}
+
+// createRemediationPacket builds a packet for the missed items
+func (h *PuzzleHub) createRemediationPacket(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	var request RemediationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	var items []RemediationItem
+	var ok bool
+	switch request.Activity {
+	case "spelling":
+		items, ok = h.missedSpellingItems(c, userObj, request)
+	case "quiz":
+		items, ok = h.missedQuizItems(c, request)
+	default:
+		respondError(c, http.StatusBadRequest, "Activity must be spelling or quiz")
+		return
+	}
+	if !ok {
+		return
+	}
+	if len(items) == 0 {
+		respondError(c, http.StatusBadRequest, "Nothing was missed, so there's nothing to practise")
+		return
+	}
+	if len(items) > maxRemediationItems {
+		items = items[:maxRemediationItems]
+	}
+
+	refund, ok := h.consumeAIQuota(c, request.Activity, 1)
+	if !ok {
+		return
+	}
+	packet := &RemediationPacket{Activity: request.Activity, Topic: request.Topic, Items: items}
+	packet.Source = h.writeRemediation(ctx, packet, request.AgeGroup, userObj.ID)
+	packet.WordSearch = buildWordSearch(remediationSearchWords(packet), h.YohakuGenerator.rand)
+
+	if request.Flashcards == nil || *request.Flashcards {
+		due := time.Now().Add(remediationReviewIn)
+		added, refreshed, err := h.addSourceFlashcards(ctx, userObj.ID, request.Activity, remediationFlashcards(packet), due)
+		if err != nil {
+			refund()
+			requestLogger(c).Error("error adding remediation flashcards", "error", err)
+			respondStorageError(c, err, "Failed to add flashcards")
+			return
+		}
+		packet.Flashcards = &RemediationFlashcards{Deck: request.Activity, Added: added, DueAgain: refreshed, DueAt: due}
+	}
+
+	c.JSON(http.StatusOK, packet)
+}
+
+// missedSpellingItems returns the request's words, or the words missed in
+// its spelling bee session
+func (h *PuzzleHub) missedSpellingItems(c *gin.Context, userObj *User, request RemediationRequest) ([]RemediationItem, bool) {
+	var items []RemediationItem
+	seen := map[string]bool{}
+	add := func(word, attempt, sentence string) bool {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" || len(word) > maxRemediationWordLen {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Words must be 1 to %d characters", maxRemediationWordLen))
+			return false
+		}
+		if !seen[word] {
+			seen[word] = true
+			items = append(items, RemediationItem{Item: word, Attempt: attempt, key: word, sentence: sentence})
+		}
+		return true
+	}
+
+	if request.SessionID == "" {
+		if len(request.Words) > maxRemediationItems {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Send at most %d words", maxRemediationItems))
+			return nil, false
+		}
+		for _, word := range request.Words {
+			if !add(word, "", fallbackSentence(word)) {
+				return nil, false
+			}
+		}
+		return items, true
+	}
+
+	session, err := h.loadSpellingBee(c.Request.Context(), userObj.ID, request.SessionID)
+	if err != nil {
+		requestLogger(c).Error("error fetching spelling bee", "error", err, "session_id", request.SessionID)
+		respondStorageError(c, err, "Failed to fetch spelling bee")
+		return nil, false
+	}
+	if session == nil {
+		respondError(c, http.StatusNotFound, "Spelling bee not found")
+		return nil, false
+	}
+	if session.FinishedAt == nil {
+		respondError(c, http.StatusConflict, "Finish the spelling bee first")
+		return nil, false
+	}
+	for _, word := range session.Words {
+		if word.Correct {
+			continue
+		}
+		attempt := ""
+		if word.Attempt != nil {
+			attempt = *word.Attempt
+		}
+		if !add(word.Word, attempt, word.Sentence) {
+			return nil, false
+		}
+	}
+	return items, true
+}
+
+// missedQuizItems looks up the missed questions in the topic's bank
+func (h *PuzzleHub) missedQuizItems(c *gin.Context, request RemediationRequest) ([]RemediationItem, bool) {
+	if _, ok := quizTopics[request.Topic]; !ok {
+		respondError(c, http.StatusBadRequest, "Unknown quiz topic "+request.Topic)
+		return nil, false
+	}
+	if len(request.QuestionIDs) > maxRemediationItems {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Send at most %d questions", maxRemediationItems))
+		return nil, false
+	}
+	bank, err := h.quizBank(c.Request.Context(), request.Topic)
+	if err != nil {
+		requestLogger(c).Error("error fetching quiz bank", "error", err, "topic", request.Topic)
+		respondStorageError(c, err, "Failed to fetch quiz questions")
+		return nil, false
+	}
+	questions := make(map[string]QuizQuestion, len(bank))
+	for _, question := range bank {
+		questions[question.QuestionID] = question
+	}
+
+	var items []RemediationItem
+	seen := map[string]bool{}
+	for _, id := range request.QuestionIDs {
+		question, ok := questions[id]
+		if !ok {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Unknown question %q", id))
+			return nil, false
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		items = append(items, RemediationItem{
+			Item:        question.Prompt,
+			Answer:      question.Choices[0],
+			Explanation: question.Explanation,
+			key:         question.QuestionID,
+			sentence:    question.Explanation,
+		})
+	}
+	return items, true
+}
+
+// writeRemediation fills in each item's sentences and hint, by AI when it
+// can, and returns where they came from
+func (h *PuzzleHub) writeRemediation(ctx context.Context, packet *RemediationPacket, ageGroup, userID string) string {
+	choice := h.Prompts.choose("remediation", userID)
+	keys := make([]string, len(packet.Items))
+	for i, item := range packet.Items {
+		keys[i] = item.key
+	}
+	cacheParams := choice.cacheParams(map[string]interface{}{
+		"remediation": normalizeCacheParams(keys),
+		"topic":       packet.Topic,
+		"age_group":   normalizeCacheParam(ageGroup),
+	})
+
+	drafts := map[string]remediationDraft{}
+	if !h.loadAICache(ctx, packet.Activity, cacheParams, &drafts) {
+		var generated struct {
+			Items []remediationDraft `json:"items"`
+		}
+		call := aiCall{Feature: packet.Activity, UserID: userID, Prompt: choice.PromptTag, Generation: newAIGeneration()}
+		prompt := h.Prompts.renderChoice(choice, remediationPrompt{
+			Activity: packet.Activity,
+			Topic:    quizTopics[packet.Topic].Title,
+			AgeGroup: ageGroup,
+			Items:    packet.Items,
+		})
+		err := h.generateJSON(ctx, prompt, call, remediationSchema, &generated, func() error {
+			if len(generated.Items) != len(packet.Items) {
+				return fmt.Errorf("expected %d items, got %d", len(packet.Items), len(generated.Items))
+			}
+			for i, draft := range generated.Items {
+				if len(draft.Sentences) == 0 || strings.TrimSpace(draft.Mnemonic) == "" {
+					return fmt.Errorf("item %d has no sentences or mnemonic", i+1)
+				}
+				if packet.Activity == "spelling" {
+					for _, sentence := range draft.Sentences {
+						if !strings.Contains(strings.ToLower(sentence), packet.Items[i].Item) {
+							return fmt.Errorf("item %d: sentence %q doesn't use %q", i+1, sentence, packet.Items[i].Item)
+						}
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("❌ %s remediation failed, using built-in hints: %v", h.providerFor(packet.Activity), err)
+			for i := range packet.Items {
+				item := &packet.Items[i]
+				item.Sentences = []string{}
+				if item.sentence != "" {
+					item.Sentences = append(item.Sentences, item.sentence)
+				}
+				if packet.Activity == "spelling" {
+					item.Mnemonic = chunkHint(item.Item)
+				}
+			}
+			return sourceFallback
+		}
+		for i, draft := range generated.Items {
+			drafts[packet.Items[i].key] = draft
+		}
+		h.saveGeneration(call.Generation, call, choice)
+		h.storeAICache(ctx, packet.Activity, cacheParams, drafts)
+	}
+
+	for i := range packet.Items {
+		item := &packet.Items[i]
+		draft := drafts[item.key]
+		item.Sentences, item.Mnemonic = draft.Sentences, strings.TrimSpace(draft.Mnemonic)
+		if item.Sentences == nil {
+			item.Sentences = []string{}
+		}
+	}
+	return sourceAI
+}
+
+// fallbackSentence finds the word's sentence in the built-in word bank
+func fallbackSentence(word string) string {
+	for _, words := range fallbackSpellingWords {
+		for _, candidate := range words {
+			if strings.EqualFold(candidate.Word, word) {
+				return candidate.Sentence
+			}
+		}
+	}
+	return ""
+}
+
+// chunkHint spells a word in chunks of three letters, "nec-ess-ary"
+func chunkHint(word string) string {
+	if len(word) <= 4 {
+		return fmt.Sprintf("Say each letter as you write it: %s", strings.Join(strings.Split(word, ""), "-"))
+	}
+	var chunks []string
+	for len(word) > 0 {
+		n := min(3, len(word))
+		chunks = append(chunks, word[:n])
+		word = word[n:]
+	}
+	return fmt.Sprintf("Spell it in chunks: %s", strings.Join(chunks, "-"))
+}
+
+// remediationSearchWords are the words to hide: missed spelling words, or
+// quiz answers with their spaces removed
+func remediationSearchWords(packet *RemediationPacket) []string {
+	var words []string
+	for _, item := range packet.Items {
+		word := item.Item
+		if packet.Activity == "quiz" {
+			word = item.Answer
+		}
+		word = strings.ToUpper(strings.Join(strings.Fields(word), ""))
+		if strings.IndexFunc(word, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+			continue
+		}
+		if len(word) >= 3 && len(word) <= maxWordSearchSize {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// remediationFlashcards makes a card per item: spelling cards show an
+// example sentence with the word blanked out, quiz cards the question
+func remediationFlashcards(packet *RemediationPacket) []FlashcardDraft {
+	drafts := make([]FlashcardDraft, 0, len(packet.Items))
+	for _, item := range packet.Items {
+		draft := FlashcardDraft{Key: item.key, Front: item.Item, Back: item.Answer, Hint: item.Mnemonic}
+		if packet.Activity == "spelling" {
+			draft.Front, draft.Back = "Spell the missing word", item.Item
+			for _, sentence := range item.Sentences {
+				if blanked := blankWord(sentence, item.Item); blanked != sentence {
+					draft.Front = blanked
+					break
+				}
+			}
+		}
+		if normalizeFlashcardDraft(&draft) == "" {
+			drafts = append(drafts, draft)
+		}
+	}
+	return drafts
+}
+
+// blankWord replaces the first use of word in the sentence with a blank
+func blankWord(sentence, word string) string {
+	i := strings.Index(strings.ToLower(sentence), strings.ToLower(word))
+	if i < 0 {
+		return sentence
+	}
+	return sentence[:i] + "_____" + sentence[i+len(word):]
+}
+
+// buildWordSearch hides as many words as fit in a grid a little bigger
+// than the longest one, then fills the gaps with random letters. It
+// returns nil when there are no words.
+func buildWordSearch(words []string, rng *rand.Rand) *WordSearch {
+	if len(words) == 0 {
+		return nil
+	}
+	size := minWordSearchSize
+	for _, word := range words {
+		size = max(size, len(word)+2)
+	}
+	size = min(size, maxWordSearchSize)
+
+	grid := make([][]byte, size)
+	for i := range grid {
+		grid[i] = make([]byte, size)
+	}
+	search := &WordSearch{Size: size, Words: []WordSearchAnswer{}}
+
+	// Longest first, while there's the most room
+	order := append([]string(nil), words...)
+	sort.SliceStable(order, func(i, j int) bool { return len(order[i]) > len(order[j]) })
+	for _, word := range order {
+		placed := false
+		for attempt := 0; attempt < wordSearchAttempts && !placed; attempt++ {
+			dir := wordSearchDirections[rng.Intn(len(wordSearchDirections))]
+			maxRow, maxCol := size-1-dir.dr*(len(word)-1), size-1-dir.dc*(len(word)-1)
+			if maxRow < 0 || maxCol < 0 {
+				continue
+			}
+			row, col := rng.Intn(maxRow+1), rng.Intn(maxCol+1)
+			if !fitsWordSearch(grid, word, row, col, dir.dr, dir.dc) {
+				continue
+			}
+			for i := 0; i < len(word); i++ {
+				grid[row+i*dir.dr][col+i*dir.dc] = word[i]
+			}
+			search.Words = append(search.Words, WordSearchAnswer{Word: word, Row: row, Col: col, Direction: dir.name})
+			placed = true
+		}
+		if !placed {
+			search.Unplaced = append(search.Unplaced, word)
+		}
+	}
+
+	for _, row := range grid {
+		for i := range row {
+			if row[i] == 0 {
+				row[i] = byte('A' + rng.Intn(26))
+			}
+		}
+		search.Grid = append(search.Grid, string(row))
+	}
+	return search
+}
+
+// fitsWordSearch reports whether the word can go at row, col, sharing
+// only matching letters with words already placed
+func fitsWordSearch(grid [][]byte, word string, row, col, dr, dc int) bool {
+	for i := 0; i < len(word); i++ {
+		if cell := grid[row+i*dr][col+i*dc]; cell != 0 && cell != word[i] {
+			return false
+		}
+	}
+	return true
+}
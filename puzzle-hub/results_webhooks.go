@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Results webhooks
+//
+// A player (or the parent linked to them) can point a webhook at their own
+// dashboard or home automation, e.g. to turn the lights green once the
+// day's Yohaku and spelling are done. Every finished session, whatever
+// records it (spelling bees, POST /progress, quizzes, challenges...), is
+// POSTed as a session.completed event with the score, signed like
+// classroom webhooks: X-PuzzleHub-Signature: sha256=<hex HMAC of the body
+// with the secret shown when the webhook is created>.
+//
+// Each player has at most one webhook, kept in puzzle-hub-results-webhooks,
+// optionally limited to some activities. Deliveries go through the job
+// queue, so failures are retried; answers a retry won't fix (4xx other
+// than 408 and 429) are dropped, as are deliveries to a name that resolves
+// to an internal address. Any signed-in player can set a URL, so deliveries
+// go through the webhook client, which checks the address it connects to
+// and doesn't follow redirects (see webhook_client.go).
+
+const (
+	resultsWebhookJob   = "results-webhook"
+	resultsSessionEvent = "session.completed"
+)
+
+type ResultsWebhook struct {
+	UserID     string    `json:"user_id" dynamodbav:"user_id"`
+	URL        string    `json:"url" dynamodbav:"url"`
+	Secret     string    `json:"-" dynamodbav:"secret"`
+	Activities []string  `json:"activities,omitempty" dynamodbav:"activities,omitempty"` // Empty for every activity
+	UpdatedBy  string    `json:"updated_by" dynamodbav:"updated_by"`
+	UpdatedAt  time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+type SetResultsWebhookRequest struct {
+	URL          string   `json:"url" binding:"required"`
+	Activities   []string `json:"activities"`    // Defaults to every activity
+	RotateSecret bool     `json:"rotate_secret"` // Issue a new signing secret
+}
+
+// ResultsWebhookEvent is what is delivered, and the payload of a
+// results-webhook job
+type ResultsWebhookEvent struct {
+	ID         string                `json:"id"` // Unique per event, for deduplicating retries
+	Event      string                `json:"event"`
+	Player     ResultsWebhookPlayer  `json:"player"`
+	Session    ResultsWebhookSession `json:"session"`
+	OccurredAt time.Time             `json:"occurred_at"`
+}
+
+type ResultsWebhookPlayer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ResultsWebhookSession struct {
+	ID              string  `json:"id"`
+	Activity        string  `json:"activity"`
+	Score           float64 `json:"score"`
+	MaxScore        float64 `json:"max_score"`
+	Percent         float64 `json:"percent"`
+	DurationSeconds int     `json:"duration_seconds"`
+	XPAwarded       int     `json:"xp_awarded"`
+}
+
+// wants reports whether the webhook takes results of the activity
+func (w *ResultsWebhook) wants(activity string) bool {
+	return len(w.Activities) == 0 || slices.Contains(w.Activities, activity)
+}
+
+// queueResultsWebhook queues a session.completed event when the player has
+// a webhook that wants it
+func (h *PuzzleHub) queueResultsWebhook(ctx context.Context, user *User, result *ActivityResult) {
+	if user.IsGuest {
+		return
+	}
+	webhook, err := h.loadResultsWebhook(ctx, user.ID)
+	if err != nil {
+		log.Printf("Error fetching results webhook for %s: %v", user.ID, err)
+		return
+	}
+	if webhook == nil || !webhook.wants(result.Activity) {
+		return
+	}
+
+	event := ResultsWebhookEvent{
+		Event:  resultsSessionEvent,
+		Player: ResultsWebhookPlayer{ID: user.ID, Name: user.Name},
+		Session: ResultsWebhookSession{
+			ID:              result.ResultID,
+			Activity:        result.Activity,
+			Score:           result.Score,
+			MaxScore:        result.MaxScore,
+			DurationSeconds: result.DurationSeconds,
+			XPAwarded:       result.XPAwarded,
+		},
+		OccurredAt: result.CreatedAt,
+	}
+	if result.MaxScore > 0 {
+		event.Session.Percent = math.Round(result.Score/result.MaxScore*1000) / 10
+	}
+	if event.ID, err = randomToken(12); err != nil {
+		log.Printf("Error generating results webhook event ID: %v", err)
+		return
+	}
+	if _, err := h.Jobs.Enqueue(ctx, resultsWebhookJob, event, 0); err != nil {
+		log.Printf("Error queueing results webhook for %s: %v", user.ID, err)
+	}
+}
+
+// deliverResultsWebhook is the results-webhook job handler
+func (h *PuzzleHub) deliverResultsWebhook(ctx context.Context, payload json.RawMessage) error {
+	var event ResultsWebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("invalid results webhook payload: %v", err)
+	}
+
+	// The webhook may have been removed or changed since
+	webhook, err := h.loadResultsWebhook(ctx, event.Player.ID)
+	if err != nil {
+		return err
+	}
+	if webhook == nil || !webhook.wants(event.Session.Activity) {
+		return nil
+	}
+
+	err = h.postSignedWebhook(ctx, webhook.URL, webhook.Secret, event.Event, event.ID, event)
+
+	var apiErr *lmsHTTPError
+	if (errors.As(err, &apiErr) && apiErr.permanent()) || errors.Is(err, errWebhookAddress) {
+		log.Printf("⚠️  Results webhook for %s rejected: %v", event.Player.ID, err)
+		return nil
+	}
+	return err
+}
+
+// getResultsWebhook returns the player's own webhook, or null
+func (h *PuzzleHub) getResultsWebhook(c *gin.Context) {
+	h.respondResultsWebhook(c, c.MustGet("user").(*User).ID)
+}
+
+// setResultsWebhook creates or changes the player's own webhook
+func (h *PuzzleHub) setResultsWebhook(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	if userObj.IsGuest {
+		respondError(c, http.StatusForbidden, "Sign in to add a results webhook")
+		return
+	}
+	h.saveResultsWebhook(c, userObj.ID)
+}
+
+// deleteResultsWebhook removes the player's own webhook
+func (h *PuzzleHub) deleteResultsWebhook(c *gin.Context) {
+	h.removeResultsWebhook(c, c.MustGet("user").(*User).ID)
+}
+
+// getChildResultsWebhook returns a linked child's webhook, or null
+func (h *PuzzleHub) getChildResultsWebhook(c *gin.Context) {
+	link, ok := h.requireParentOf(c)
+	if !ok {
+		return
+	}
+	h.respondResultsWebhook(c, link.ChildID)
+}
+
+// setChildResultsWebhook creates or changes a linked child's webhook
+func (h *PuzzleHub) setChildResultsWebhook(c *gin.Context) {
+	link, ok := h.requireParentOf(c)
+	if !ok {
+		return
+	}
+	h.saveResultsWebhook(c, link.ChildID)
+}
+
+// deleteChildResultsWebhook removes a linked child's webhook
+func (h *PuzzleHub) deleteChildResultsWebhook(c *gin.Context) {
+	link, ok := h.requireParentOf(c)
+	if !ok {
+		return
+	}
+	h.removeResultsWebhook(c, link.ChildID)
+}
+
+func (h *PuzzleHub) respondResultsWebhook(c *gin.Context, userID string) {
+	webhook, err := h.loadResultsWebhook(c.Request.Context(), userID)
+	if err != nil {
+		requestLogger(c).Error("error fetching results webhook", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to fetch results webhook")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhook": webhook})
+}
+
+// saveResultsWebhook stores the webhook for userID. The signing secret is
+// kept unless rotated and is only shown when it is new.
+func (h *PuzzleHub) saveResultsWebhook(c *gin.Context, userID string) {
+	ctx := c.Request.Context()
+
+	var request SetResultsWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	request.URL = strings.TrimSpace(request.URL)
+	if problem := validateWebhookURL(request.URL); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+	for _, activity := range request.Activities {
+		if !progressActivities[activity] {
+			activities := make([]string, 0, len(progressActivities))
+			for name := range progressActivities {
+				activities = append(activities, name)
+			}
+			sort.Strings(activities)
+			respondError(c, http.StatusBadRequest, "Activities must be "+strings.Join(activities, ", "))
+			return
+		}
+	}
+
+	existing, err := h.loadResultsWebhook(ctx, userID)
+	if err != nil {
+		requestLogger(c).Error("error fetching results webhook", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to save results webhook")
+		return
+	}
+
+	webhook := &ResultsWebhook{
+		UserID:     userID,
+		URL:        request.URL,
+		Activities: request.Activities,
+		UpdatedBy:  c.MustGet("user").(*User).ID,
+		UpdatedAt:  time.Now(),
+	}
+	newSecret := existing == nil || request.RotateSecret
+	if newSecret {
+		if webhook.Secret, err = randomToken(32); err != nil {
+			requestLogger(c).Error("error generating webhook secret", "error", err)
+			respondStorageError(c, err, "Failed to save results webhook")
+			return
+		}
+	} else {
+		webhook.Secret = existing.Secret
+	}
+
+	item, err := dynamodbattribute.MarshalMap(webhook)
+	if err == nil {
+		_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-results-webhooks")),
+			Item:      item,
+		})
+	}
+	if err != nil {
+		requestLogger(c).Error("error saving results webhook", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to save results webhook")
+		return
+	}
+
+	response := gin.H{
+		"message": "Results webhook saved",
+		"webhook": webhook,
+	}
+	if newSecret {
+		response["secret"] = webhook.Secret
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *PuzzleHub) removeResultsWebhook(c *gin.Context, userID string) {
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-results-webhooks")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		requestLogger(c).Error("error deleting results webhook", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to delete results webhook")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Results webhook deleted"})
+}
+
+// loadResultsWebhook returns the player's webhook, or nil if there is none
+func (h *PuzzleHub) loadResultsWebhook(ctx context.Context, userID string) (*ResultsWebhook, error) {
+	out, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-results-webhooks")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	var webhook ResultsWebhook
+	if err := dynamodbattribute.UnmarshalMap(out.Item, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Log retention and archival.
+//
+// Log types may set RetentionDays. A daily job moves entries older than that
+// out of storage into one gzipped JSON object per calendar month and member
+// in S3 and records the archive in Storage. Restoring a month copies the
+// entries back and puts the month on hold so the next archiver run does not
+// immediately move them out again.
+
+const restoreHoldPeriod = 30 * 24 * time.Hour
+
+type LogArchive struct {
+	LogTypeID  string     `json:"log_type_id" dynamodbav:"log_type_id"`
+	Period     string     `json:"period" dynamodbav:"period"`   // YYYY-MM, see logArchivePeriod
+	UserID     string     `json:"user_id" dynamodbav:"user_id"` // Whose entries
+	S3Key      string     `json:"-" dynamodbav:"s3_key"`
+	EntryCount int        `json:"entry_count" dynamodbav:"entry_count"`
+	ArchivedAt time.Time  `json:"archived_at" dynamodbav:"archived_at"`
+	RestoredAt *time.Time `json:"restored_at,omitempty" dynamodbav:"restored_at,omitempty"`
+	HoldUntil  *time.Time `json:"hold_until,omitempty" dynamodbav:"hold_until,omitempty"` // Skipped by the archiver until then
+}
+
+type LogArchiveFile struct {
+	LogTypeID string     `json:"log_type_id"`
+	UserID    string     `json:"user_id"`
+	Period    string     `json:"period"`
+	Entries   []LogEntry `json:"entries"`
+}
+
+// logArchivePeriod is the key a member's month is archived under: the
+// month itself for the log type's owner, as before log types could be
+// shared, and "<month>.<user ID>" for the other household members
+func logArchivePeriod(logType *LogType, userID, month string) string {
+	if userID == logType.UserID {
+		return month
+	}
+	return month + "." + userID
+}
+
+type UpdateRetentionRequest struct {
+	RetentionDays *int `json:"retention_days" binding:"required"` // 0 disables archival
+}
+
+func (h *PuzzleHub) updateLogTypeRetention(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	var request UpdateRetentionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	days := *request.RetentionDays
+	if days != 0 && (days < 30 || days > 3650) {
+		respondError(c, http.StatusBadRequest, "retention_days must be 0 (keep forever) or between 30 and 3650")
+		return
+	}
+
+	logType, status, err := h.getOwnedLogType(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	if err := h.Store.SetLogTypeRetention(logType.ID, days); err != nil {
+		requestLogger(c).Error("error updating retention for log type", "error", err, "log_type_id", logType.ID)
+		respondStorageError(c, err, "Failed to update retention")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Retention policy updated successfully",
+		"retention_days":    days,
+		"archiving_enabled": h.ArchiveBucket != "",
+	})
+}
+
+func (h *PuzzleHub) listLogArchives(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	logType, status, err := h.getOwnedLogType(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	archives, err := h.Store.ListLogArchives(logType.ID)
+	if err != nil {
+		requestLogger(c).Error("error querying log archives", "error", err)
+		respondStorageError(c, err, "Failed to fetch archives")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"archives":       archives,
+		"retention_days": logType.RetentionDays,
+	})
+}
+
+// restoreLogArchive copies an archived month back into the entries table
+func (h *PuzzleHub) restoreLogArchive(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	if h.ArchiveBucket == "" {
+		respondError(c, http.StatusServiceUnavailable, "Log archiving is not configured")
+		return
+	}
+
+	logType, status, err := h.getOwnedLogType(userObj.ID, c.Param("id"))
+	if err != nil {
+		respondError(c, status, err.Error())
+		return
+	}
+
+	period := c.Param("period")
+	archive, err := h.Store.GetLogArchive(logType.ID, period)
+	if err != nil {
+		requestLogger(c).Error("error getting log archive", "error", err)
+		respondStorageError(c, err, "Failed to fetch archive")
+		return
+	}
+	if archive == nil {
+		respondError(c, http.StatusNotFound, "Archive not found")
+		return
+	}
+
+	archiveFile, err := h.readArchiveFile(archive.S3Key)
+	if err != nil {
+		requestLogger(c).Error("error reading archive", "error", err, "s3_key", archive.S3Key)
+		respondStorageError(c, err, "Failed to read archive")
+		return
+	}
+
+	restored := 0
+	for _, entry := range archiveFile.Entries {
+		entry.Version++
+		entry.UpdatedAt = time.Now()
+
+		previous, err := h.Store.PutLogEntry(&entry)
+		if err != nil {
+			requestLogger(c).Error("error restoring entry", "error", err, "entry_id", entry.ID)
+			respondStorageError(c, err, "Failed to restore archive")
+			return
+		}
+
+		h.recordSyncChange(entry.UserID, syncOpUpsert, entry.ID, entry.Version, &entry)
+
+		// Restoring twice must not double count the entry
+		if previous != nil {
+			h.adjustLogAggregates(previous, -1)
+		}
+		h.adjustLogAggregates(&entry, 1)
+		restored++
+	}
+
+	now := time.Now()
+	holdUntil := now.Add(restoreHoldPeriod)
+	archive.RestoredAt = &now
+	archive.HoldUntil = &holdUntil
+	if err := h.Store.PutLogArchive(archive); err != nil {
+		requestLogger(c).Error("error updating log archive record", "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Archive restored successfully",
+		"restored_entries": restored,
+		"hold_until":       holdUntil,
+	})
+}
+
+// archiveExpiredEntries is the daily retention job, scheduled in main
+func (h *PuzzleHub) archiveExpiredEntries(ctx context.Context) error {
+	logTypes, err := h.Store.ListRetainedLogTypes()
+	if err != nil {
+		return fmt.Errorf("failed to scan log types: %v", err)
+	}
+
+	archived := 0
+	for _, logType := range logTypes {
+		count, err := h.archiveLogType(logType)
+		if err != nil {
+			log.Printf("❌ Failed to archive log type %s: %v", logType.ID, err)
+			continue
+		}
+		archived += count
+	}
+
+	log.Printf("🗄️  Retention archiver checked %d log types, archived %d entries", len(logTypes), archived)
+	return nil
+}
+
+// archiveLogType moves one log type's expired entries, from every member it
+// is shared with, to S3 and returns how many entries were archived.
+func (h *PuzzleHub) archiveLogType(logType LogType) (int, error) {
+	cutoff := time.Now().UTC().AddDate(0, 0, -logType.RetentionDays).Format("2006-01-02")
+
+	expired, err := h.listLogTypeEntries(&logType, LogEntryQuery{Before: cutoff})
+	if err != nil {
+		return 0, err
+	}
+
+	byPeriod := make(map[string][]LogEntry)
+	for _, entry := range expired {
+		if len(entry.EntryDate) < 7 {
+			continue
+		}
+		period := logArchivePeriod(&logType, entry.UserID, entry.EntryDate[:7])
+		byPeriod[period] = append(byPeriod[period], entry)
+	}
+
+	archived := 0
+	for period, entries := range byPeriod {
+		count, err := h.archivePeriod(logType, entries[0].UserID, period, entries)
+		if err != nil {
+			return archived, fmt.Errorf("period %s: %v", period, err)
+		}
+		archived += count
+	}
+
+	return archived, nil
+}
+
+// archivePeriod merges one member's entries into their month's archive
+// object, then removes them from the entries table.
+func (h *PuzzleHub) archivePeriod(logType LogType, userID, period string, entries []LogEntry) (int, error) {
+	archive, err := h.Store.GetLogArchive(logType.ID, period)
+	if err != nil {
+		return 0, err
+	}
+
+	if archive != nil && archive.HoldUntil != nil && time.Now().Before(*archive.HoldUntil) {
+		return 0, nil
+	}
+
+	month := entries[0].EntryDate[:7]
+	archiveFile := &LogArchiveFile{
+		LogTypeID: logType.ID,
+		UserID:    userID,
+		Period:    month,
+	}
+	if archive != nil {
+		if archiveFile, err = h.readArchiveFile(archive.S3Key); err != nil {
+			return 0, err
+		}
+	} else {
+		archive = &LogArchive{
+			LogTypeID: logType.ID,
+			Period:    period,
+			UserID:    userID,
+			S3Key:     fmt.Sprintf("log-archives/%s/%s/%s.json.gz", userID, logType.ID, month),
+		}
+	}
+
+	// Entries restored earlier are archived again; the table copy wins
+	merged := make(map[string]LogEntry, len(archiveFile.Entries)+len(entries))
+	for _, entry := range archiveFile.Entries {
+		merged[entry.ID] = entry
+	}
+	for _, entry := range entries {
+		merged[entry.ID] = entry
+	}
+	archiveFile.Entries = archiveFile.Entries[:0]
+	for _, entry := range merged {
+		archiveFile.Entries = append(archiveFile.Entries, entry)
+	}
+
+	if err := h.writeArchiveFile(archive.S3Key, archiveFile); err != nil {
+		return 0, err
+	}
+
+	archive.EntryCount = len(archiveFile.Entries)
+	archive.ArchivedAt = time.Now()
+	archive.HoldUntil = nil
+	if err := h.Store.PutLogArchive(archive); err != nil {
+		return 0, err
+	}
+
+	// Only delete once the archive is safely written
+	for _, entry := range entries {
+		if err := h.Store.DeleteLogEntry(entry.ID); err != nil {
+			return 0, fmt.Errorf("failed to delete archived entry %s: %v", entry.ID, err)
+		}
+		h.recordSyncChange(entry.UserID, syncOpDelete, entry.ID, entry.Version+1, nil)
+		h.adjustLogAggregates(&entry, -1)
+	}
+
+	return len(entries), nil
+}
+
+func (h *PuzzleHub) writeArchiveFile(key string, archiveFile *LogArchiveFile) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(archiveFile); err != nil {
+		return fmt.Errorf("failed to encode archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress archive: %v", err)
+	}
+
+	_, err := h.S3.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(h.ArchiveBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/gzip"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload archive: %v", err)
+	}
+	return nil
+}
+
+func (h *PuzzleHub) readArchiveFile(key string) (*LogArchiveFile, error) {
+	result, err := h.S3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(h.ArchiveBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download archive: %v", err)
+	}
+	defer result.Body.Close()
+
+	gz, err := gzip.NewReader(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive: %v", err)
+	}
+	defer gz.Close()
+
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %v", err)
+	}
+
+	var archiveFile LogArchiveFile
+	if err := json.Unmarshal(data, &archiveFile); err != nil {
+		return nil, fmt.Errorf("failed to parse archive: %v", err)
+	}
+	return &archiveFile, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Role-based access control
+//
+// Users listed in ADMIN_EMAILS are always admins. Everyone else gets the role
+// an admin assigned them (kept in the user store, since the in-memory user
+// map doesn't survive restarts), defaulting to student.
+
+type Role string
+
+const (
+	RoleAdmin   Role = "admin"
+	RoleTeacher Role = "teacher"
+	RoleParent  Role = "parent"
+	RoleStudent Role = "student"
+)
+
+var validRoles = map[Role]bool{
+	RoleAdmin:   true,
+	RoleTeacher: true,
+	RoleParent:  true,
+	RoleStudent: true,
+}
+
+type UserRoleAssignment struct {
+	UserID    string    `json:"user_id" dynamodbav:"user_id"`
+	Role      Role      `json:"role" dynamodbav:"role"`
+	UpdatedBy string    `json:"updated_by" dynamodbav:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+type UpdateUserRoleRequest struct {
+	Role Role `json:"role" binding:"required"`
+}
+
+// RequireRole rejects users without one of the given roles; it must run
+// after authMiddleware. Admins pass every check.
+func RequireRole(roles ...Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists || !user.(*User).HasRole(roles...) {
+			respondError(c, http.StatusForbidden, "Insufficient permissions")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasRole reports whether the user holds any of the roles (or is an admin)
+func (u *User) HasRole(roles ...Role) bool {
+	if u == nil {
+		return false
+	}
+	if u.Role == RoleAdmin {
+		return true
+	}
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdmin reports whether the user is allowed to use admin endpoints
+func (h *PuzzleHub) isAdmin(user *User) bool {
+	return user.HasRole(RoleAdmin)
+}
+
+// resolveRole works out a user's effective role at login or restore
+func (h *PuzzleHub) resolveRole(userID, email string) Role {
+	if isGuestID(userID) {
+		return RoleStudent
+	}
+	if h.AuthConfig.AdminEmails[strings.ToLower(email)] {
+		return RoleAdmin
+	}
+
+	assignment, err := h.Store.GetUserRole(userID)
+	if err != nil {
+		log.Printf("Error fetching role for %s: %v", userID, err)
+		return RoleStudent
+	}
+	if assignment == nil || !validRoles[assignment.Role] {
+		return RoleStudent
+	}
+	return assignment.Role
+}
+
+// adminUpdateUserRole assigns a role to a user by ID
+func (h *PuzzleHub) adminUpdateUserRole(c *gin.Context) {
+	admin := c.MustGet("user").(*User)
+	userID := c.Param("id")
+
+	var request UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !validRoles[request.Role] {
+		respondError(c, http.StatusBadRequest, "Role must be one of admin, teacher, parent, student")
+		return
+	}
+
+	assignment := UserRoleAssignment{
+		UserID:    userID,
+		Role:      request.Role,
+		UpdatedBy: admin.Email,
+		UpdatedAt: time.Now(),
+	}
+	if err := h.Store.PutUserRole(&assignment); err != nil {
+		requestLogger(c).Error("error saving role", "error", err, "target_user_id", userID)
+		respondStorageError(c, err, "Failed to update role")
+		return
+	}
+
+	// Users are rebuilt from storage on each request, so the change applies
+	// on every instance without a re-login. ADMIN_EMAILS still wins.
+	effectiveRole := request.Role
+	var profile userProfile
+	if getCachedJSON(c.Request.Context(), h.Cache, "user_profile", userProfileKey(userID), &profile) &&
+		h.AuthConfig.AdminEmails[strings.ToLower(profile.Email)] {
+		effectiveRole = RoleAdmin
+	}
+
+	requestLogger(c).Info("set user role", "target_user_id", userID, "role", request.Role)
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Role updated",
+		"assignment":     assignment,
+		"effective_role": effectiveRole,
+	})
+}
+
+// adminListUserRoles lists explicit role assignments
+func (h *PuzzleHub) adminListUserRoles(c *gin.Context) {
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	assignments, err := h.Store.ListUserRoles()
+	if err != nil {
+		requestLogger(c).Error("error scanning user roles", "error", err)
+		respondStorageError(c, err, "Failed to fetch roles")
+		return
+	}
+
+	admins := make([]string, 0, len(h.AuthConfig.AdminEmails))
+	for email := range h.AuthConfig.AdminEmails {
+		admins = append(admins, email)
+	}
+
+	sort.Strings(admins)
+
+	respondPage(c, "assignments", assignments, page, gin.H{"configured_admins": admins})
+}
+
+// adminGetSiteAnalytics exposes the visit/login counters that are otherwise only logged
+func (h *PuzzleHub) adminGetSiteAnalytics(c *gin.Context) {
+	totals, today, err := h.Analytics.Totals()
+	if err != nil {
+		requestLogger(c).Error("error loading analytics rollups", "error", err)
+		respondStorageError(c, err, "Failed to fetch analytics")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total_visits":    totals.TotalVisits,
+		"unique_visitors": totals.UniqueVisitors,
+		"total_logins":    totals.TotalLogins,
+		"unique_users":    totals.UniqueUsers,
+		"visits_today":    today.Visits,
+		"logins_today":    today.Logins,
+	})
+}
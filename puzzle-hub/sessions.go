@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Login audit log and active sessions
+//
+// Every login writes a session record keyed by the refresh token family, so
+// a session lives exactly as long as its refresh tokens. Access tokens carry
+// the session ID as "sid"; revoking a session denylists that ID until the
+// last access token issued for it has expired.
+
+type LoginSession struct {
+	UserID     string     `json:"-" dynamodbav:"user_id"`
+	SessionID  string     `json:"id" dynamodbav:"session_id"` // Refresh token family ID
+	Provider   string     `json:"provider" dynamodbav:"provider"`
+	IP         string     `json:"ip" dynamodbav:"ip"`
+	UserAgent  string     `json:"user_agent" dynamodbav:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at" dynamodbav:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at" dynamodbav:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" dynamodbav:"revoked_at,omitempty"`
+	ExpiresAt  int64      `json:"-" dynamodbav:"expires_at"` // Unix seconds, kept a while past refresh expiry for auditing
+	Current    bool       `json:"current" dynamodbav:"-"`
+}
+
+// loginSessionRetention is how long session records outlive their refresh tokens
+const loginSessionRetention = 90 * 24 * time.Hour
+
+// startLoginSession records the login and issues its access and refresh tokens
+func (h *PuzzleHub) startLoginSession(c *gin.Context, user *User, provider string) (string, string, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	session := LoginSession{
+		UserID:     user.ID,
+		SessionID:  sessionID,
+		Provider:   provider,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL + loginSessionRetention).Unix(),
+	}
+	if err := h.Store.PutLoginSession(&session); err != nil {
+		return "", "", fmt.Errorf("failed to record login session: %v", err)
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	if err := h.putRefreshToken(user, refreshToken, sessionID); err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := h.generateJWT(user, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	slog.Info("new session", "provider", provider, "user_id", user.ID, "session_id", sessionID)
+	return accessToken, refreshToken, nil
+}
+
+// touchLoginSession bumps last_used_at when the session's tokens are refreshed
+func (h *PuzzleHub) touchLoginSession(userID, sessionID string) {
+	if err := h.Store.TouchLoginSession(userID, sessionID, time.Now()); err != nil {
+		log.Printf("Error updating login session %s: %v", sessionID, err)
+	}
+}
+
+// endLoginSession marks the session revoked and denylists its access tokens
+func (h *PuzzleHub) endLoginSession(userID, sessionID string) {
+	now := time.Now()
+	if err := h.Store.EndLoginSession(userID, sessionID, now); err != nil {
+		log.Printf("Error ending login session %s: %v", sessionID, err)
+	}
+
+	if err := h.Store.RevokeAccessToken("sid:"+sessionID, now.Add(accessTokenTTL).Unix()); err != nil {
+		log.Printf("Error denylisting login session %s: %v", sessionID, err)
+	}
+}
+
+// listLoginSessions shows where the user is logged in, newest activity first
+func (h *PuzzleHub) listLoginSessions(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	sessions, err := h.Store.ListLoginSessions(userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error fetching login sessions", "error", err)
+		respondStorageError(c, err, "Failed to fetch sessions")
+		return
+	}
+
+	includeRevoked, _ := strconv.ParseBool(c.Query("include_revoked"))
+	currentID := h.currentSessionID(c)
+	now := time.Now().Unix()
+
+	active := []LoginSession{}
+	for _, session := range sessions {
+		expired := session.ExpiresAt-int64(loginSessionRetention.Seconds()) <= now
+		if (session.RevokedAt != nil || expired) && !includeRevoked {
+			continue
+		}
+		session.Current = session.SessionID == currentID
+		active = append(active, session)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].LastUsedAt.After(active[j].LastUsedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": active,
+		"count":    len(active),
+	})
+}
+
+// revokeLoginSession logs out one of the user's sessions
+func (h *PuzzleHub) revokeLoginSession(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+	sessionID := c.Param("id")
+
+	session, err := h.Store.GetLoginSession(userObj.ID, sessionID)
+	if err != nil {
+		requestLogger(c).Error("error fetching login session", "error", err, "session_id", sessionID)
+		respondStorageError(c, err, "Failed to revoke session")
+		return
+	}
+	if session == nil {
+		respondError(c, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	h.revokeRefreshFamily(userObj.ID, sessionID)
+
+	requestLogger(c).Info("revoked session", "session_id", sessionID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked",
+		"current": sessionID == h.currentSessionID(c),
+	})
+}
+
+// currentSessionID reads the sid claim of the request's (already validated) token
+func (h *PuzzleHub) currentSessionID(c *gin.Context) string {
+	tokenString, err := bearerToken(c)
+	if err != nil {
+		return ""
+	}
+	token, err := jwt.Parse(tokenString, h.jwtKeyFunc)
+	if err != nil || !token.Valid {
+		return ""
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	sessionID, _ := claims["sid"].(string)
+	return sessionID
+}
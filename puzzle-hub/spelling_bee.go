@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/polly"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// Spelling bee sessions
+//
+// Real bee conditions: the words are only ever heard. A session is a list
+// of spelling problems kept on the server, and the client gets audio for
+// each word, its definition and its use in a sentence (spoken by AWS
+// Polly, SPELLING_BEE_VOICE, default Joanna) but never the text. The child
+// types one attempt per word, which reveals the word, and can also record
+// themselves saying it: the client uploads the recording straight to S3
+// (SPELLING_BEE_S3_BUCKET) with a presigned URL. Finishing records the
+// session as "spelling" progress.
+//
+// Parents of linked children can review recent sessions with every
+// attempt and a link to each recording. Sessions and their recordings are
+// kept for spellingBeeRetention; set a matching lifecycle rule on the
+// bucket.
+
+const (
+	defaultBeeWords       = 10
+	maxBeeWords           = 25
+	maxBeeRecordingSize   = 2 * 1024 * 1024 // 2MB, about a minute of compressed audio
+	beeAudioCacheTTL      = 24 * time.Hour
+	beeParentReviewLimit  = 20
+	spellingBeeRetention  = 90 * 24 * time.Hour
+	defaultSpellingVoice  = "Joanna"
+	beeAudioWord          = "word"
+	beeAudioDefinition    = "definition"
+	beeAudioSentence      = "sentence"
+	recordingUploadURLTTL = 15 * time.Minute
+)
+
+var allowedRecordingTypes = map[string]string{
+	"audio/webm": ".webm",
+	"audio/ogg":  ".ogg",
+	"audio/mp4":  ".m4a",
+	"audio/mpeg": ".mp3",
+	"audio/wav":  ".wav",
+}
+
+// spellingBeeVoice reads SPELLING_BEE_VOICE
+func spellingBeeVoice() string {
+	if voice := os.Getenv("SPELLING_BEE_VOICE"); voice != "" {
+		return voice
+	}
+	return defaultSpellingVoice
+}
+
+type SpellingBeeSession struct {
+	UserID     string            `json:"-" dynamodbav:"user_id"`
+	ID         string            `json:"id" dynamodbav:"session_id"` // bee_<unix nanos>
+	Difficulty string            `json:"difficulty" dynamodbav:"difficulty"`
+	AgeGroup   string            `json:"age_group" dynamodbav:"age_group"`
+	Source     string            `json:"source" dynamodbav:"source"` // ai or fallback
+	Words      []SpellingBeeWord `json:"words" dynamodbav:"words"`
+	Correct    int               `json:"correct" dynamodbav:"correct"`
+	Rival      *SpellingBeeRival `json:"rival,omitempty" dynamodbav:"rival,omitempty"` // Versus mode, see spelling_rival.go
+	CreatedAt  time.Time         `json:"created_at" dynamodbav:"created_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty" dynamodbav:"finished_at,omitempty"`
+	ExpiresAt  int64             `json:"-" dynamodbav:"expires_at"`
+}
+
+// SpellingBeeWord is a word with the child's attempt. Word, definition and
+// sentence are only sent once the word has been attempted.
+type SpellingBeeWord struct {
+	Index        int        `json:"index" dynamodbav:"-"`
+	Word         string     `json:"word,omitempty" dynamodbav:"word"`
+	Definition   string     `json:"definition,omitempty" dynamodbav:"definition"`
+	Sentence     string     `json:"sentence,omitempty" dynamodbav:"sentence"`
+	Audio        gin.H      `json:"audio,omitempty" dynamodbav:"-"` // Paths of the word, definition and sentence audio
+	Attempt      *string    `json:"attempt,omitempty" dynamodbav:"attempt,omitempty"`
+	Correct      bool       `json:"correct" dynamodbav:"correct"`
+	AttemptedAt  *time.Time `json:"attempted_at,omitempty" dynamodbav:"attempted_at,omitempty"`
+	RecordingKey string     `json:"-" dynamodbav:"recording_key,omitempty"`
+	Recorded     bool       `json:"recorded" dynamodbav:"-"`
+	RecordingURL string     `json:"recording_url,omitempty" dynamodbav:"-"` // Presigned, parents only
+	RivalAttempt *string    `json:"rival_attempt,omitempty" dynamodbav:"rival_attempt,omitempty"`
+	RivalCorrect *bool      `json:"rival_correct,omitempty" dynamodbav:"rival_correct,omitempty"`
+}
+
+type BeeAttemptRequest struct {
+	Spelling string `json:"spelling" binding:"required"`
+}
+
+type BeeRecordingRequest struct {
+	ContentType string `json:"content_type" binding:"required"`
+	Size        int64  `json:"size" binding:"required"`
+}
+
+type FinishBeeRequest struct {
+	DurationSeconds int `json:"duration_seconds"`
+}
+
+// forPlayer hides what the child hasn't attempted yet and adds the audio
+// paths
+func (s *SpellingBeeSession) forPlayer() *SpellingBeeSession {
+	view := *s
+	view.Words = make([]SpellingBeeWord, len(s.Words))
+	for i, word := range s.Words {
+		word.Index = i
+		word.Recorded = word.RecordingKey != ""
+		audio := fmt.Sprintf("/api/v1/spelling-bee/sessions/%s/words/%d/audio?part=", s.ID, i)
+		word.Audio = gin.H{
+			beeAudioWord:       audio + beeAudioWord,
+			beeAudioDefinition: audio + beeAudioDefinition,
+			beeAudioSentence:   audio + beeAudioSentence,
+		}
+		if word.Attempt == nil && s.FinishedAt == nil {
+			word.Word, word.Definition, word.Sentence = "", "", ""
+			word.RivalAttempt, word.RivalCorrect = nil, nil
+		}
+		view.Words[i] = word
+	}
+	s.applyRival(&view)
+	return &view
+}
+
+// startSpellingBee generates the words and starts a session
+func (h *PuzzleHub) startSpellingBee(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	var request StartBeeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	criteria := request.GenerationCriteria
+	if err := criteria.normalize(); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if criteria.WordCount == 0 {
+		criteria.WordCount = defaultBeeWords
+	}
+	if criteria.WordCount < 1 || criteria.WordCount > maxBeeWords {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Word count must be between 1 and %d", maxBeeWords))
+		return
+	}
+	var rival *SpellingBeeRival
+	if request.Rival != nil {
+		var err error
+		if rival, err = newBeeRival(request.Rival, criteria.DifficultyLevel); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	h.accessibilityFor(c).applyToSpelling(&criteria)
+
+	problems, source, err := h.GenerateSpellingProblems(c.Request.Context(), criteria, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error generating spelling bee words", "error", err)
+		respondStorageError(c, err, "Failed to start spelling bee")
+		return
+	}
+
+	now := time.Now()
+	session := &SpellingBeeSession{
+		UserID:     userObj.ID,
+		ID:         fmt.Sprintf("bee_%d", now.UnixNano()),
+		Difficulty: criteria.DifficultyLevel,
+		AgeGroup:   criteria.AgeGroup,
+		Source:     source,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(spellingBeeRetention).Unix(),
+	}
+	for _, problem := range problems {
+		session.Words = append(session.Words, SpellingBeeWord{
+			Word:       problem.Word,
+			Definition: problem.Definition,
+			Sentence:   problem.Sentence,
+		})
+	}
+	if rival != nil {
+		session.Rival = rival
+		rival.simulateRivalAttempts(session.Words, rand.New(rand.NewSource(now.UnixNano())))
+	}
+
+	item, err := dynamodbattribute.MarshalMap(session)
+	if err == nil {
+		_, err = h.DynamoDB.PutItemWithContext(c.Request.Context(), &dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-spelling-bee-sessions")),
+			Item:      item,
+		})
+	}
+	if err != nil {
+		requestLogger(c).Error("error saving spelling bee session", "error", err)
+		respondStorageError(c, err, "Failed to start spelling bee")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"session":          session.forPlayer(),
+		"recordings":       h.RecordingBucket != "",
+		"max_recording_mb": maxBeeRecordingSize / (1024 * 1024),
+	})
+}
+
+// getSpellingBee returns one of the player's sessions
+func (h *PuzzleHub) getSpellingBee(c *gin.Context) {
+	session, ok := h.requireSpellingBee(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"session": session.forPlayer()})
+}
+
+// getSpellingBeeAudio speaks a word, its definition or its sentence
+func (h *PuzzleHub) getSpellingBeeAudio(c *gin.Context) {
+	session, ok := h.requireSpellingBee(c)
+	if !ok {
+		return
+	}
+	word, _, ok := requireBeeWord(c, session)
+	if !ok {
+		return
+	}
+
+	var ssml string
+	switch c.DefaultQuery("part", beeAudioWord) {
+	case beeAudioWord:
+		// Slowly, with a pause, like a pronouncer would
+		ssml = `<speak><prosody rate="slow">` + html.EscapeString(word.Word) + `</prosody><break time="500ms"/></speak>`
+	case beeAudioDefinition:
+		ssml = "<speak>" + html.EscapeString(word.Definition) + "</speak>"
+	case beeAudioSentence:
+		ssml = "<speak>" + html.EscapeString(word.Sentence) + "</speak>"
+	default:
+		respondError(c, http.StatusBadRequest, "Part must be word, definition or sentence")
+		return
+	}
+
+	audio, err := h.synthesizeSpeech(c.Request.Context(), ssml)
+	if err != nil {
+		requestLogger(c).Error("error synthesizing spelling bee audio", "error", err)
+		respondError(c, http.StatusServiceUnavailable, "Audio is unavailable right now")
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Data(http.StatusOK, "audio/mpeg", audio)
+}
+
+// synthesizeSpeech returns MP3 audio for the SSML, cached since a session's
+// audio is played again and again
+func (h *PuzzleHub) synthesizeSpeech(ctx context.Context, ssml string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(h.SpellingBeeVoice + "\n" + ssml))
+	key := "tts:" + hex.EncodeToString(sum[:])
+	if audio, found, err := h.Cache.Get(ctx, key); err == nil && found {
+		return audio, nil
+	}
+
+	out, err := h.Polly.SynthesizeSpeechWithContext(ctx, &polly.SynthesizeSpeechInput{
+		OutputFormat: aws.String(polly.OutputFormatMp3),
+		Text:         aws.String(ssml),
+		TextType:     aws.String(polly.TextTypeSsml),
+		VoiceId:      aws.String(h.SpellingBeeVoice),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.AudioStream == nil {
+		return nil, fmt.Errorf("no audio returned")
+	}
+	defer out.AudioStream.Close()
+	audio, err := io.ReadAll(out.AudioStream)
+	if err != nil {
+		return nil, err
+	}
+	if len(audio) == 0 {
+		return nil, fmt.Errorf("no audio returned")
+	}
+
+	if err := h.Cache.Set(ctx, key, audio, beeAudioCacheTTL); err != nil {
+		log.Printf("Error caching spelling bee audio: %v", err)
+	}
+	return audio, nil
+}
+
+// attemptSpellingBeeWord checks the child's one attempt at a word and
+// reveals it
+func (h *PuzzleHub) attemptSpellingBeeWord(c *gin.Context) {
+	session, ok := h.requireSpellingBee(c)
+	if !ok {
+		return
+	}
+	word, index, ok := requireBeeWord(c, session)
+	if !ok {
+		return
+	}
+	if session.FinishedAt != nil {
+		respondError(c, http.StatusConflict, "This spelling bee is finished")
+		return
+	}
+
+	var request BeeAttemptRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	attempt := strings.TrimSpace(request.Spelling)
+	if len(attempt) > 50 {
+		respondError(c, http.StatusBadRequest, "Spelling must be at most 50 characters")
+		return
+	}
+	correct := strings.EqualFold(attempt, word.Word)
+
+	// Conditional, so a word can only be tried once
+	now := time.Now()
+	path := fmt.Sprintf("words[%d]", index)
+	update := "SET " + path + ".attempt = :attempt, " + path + ".correct = :correct, " + path + ".attempted_at = :now"
+	values := map[string]*dynamodb.AttributeValue{
+		":attempt": {S: aws.String(attempt)},
+		":correct": {BOOL: aws.Bool(correct)},
+		":now":     {S: aws.String(now.Format(time.RFC3339Nano))},
+	}
+	if correct {
+		update += " ADD correct :one"
+		values[":one"] = &dynamodb.AttributeValue{N: aws.String("1")}
+	}
+	_, err := h.DynamoDB.UpdateItemWithContext(c.Request.Context(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName("puzzle-hub-spelling-bee-sessions")),
+		Key:                       spellingBeeKey(session),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_not_exists(" + path + ".attempt) AND attribute_not_exists(finished_at)"),
+		ExpressionAttributeValues: values,
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusConflict, "You have already spelled this word")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error saving spelling bee attempt", "error", err)
+		respondStorageError(c, err, "Failed to save attempt")
+		return
+	}
+
+	message := tr(c, "Correct! Well done!")
+	if !correct {
+		message = tr(c, "Not quite. The word is %s.", word.Word)
+	}
+	response := gin.H{
+		"correct":    correct,
+		"word":       word.Word,
+		"definition": word.Definition,
+		"message":    message,
+	}
+	if session.Rival != nil {
+		// Reveal the rival's answer to this word along with the scores
+		word.Attempt = &attempt
+		word.AttemptedAt = &now
+		if correct {
+			session.Correct++
+		}
+		view := session.forPlayer()
+		response["rival"] = gin.H{
+			"name":       view.Rival.Name,
+			"attempt":    word.RivalAttempt,
+			"correct":    word.RivalCorrect,
+			"score":      view.Rival.Score,
+			"your_score": session.Correct,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// createSpellingBeeRecordingURL returns a presigned upload URL for a
+// recording of the child saying a word. A new recording replaces the old.
+func (h *PuzzleHub) createSpellingBeeRecordingURL(c *gin.Context) {
+	if h.RecordingBucket == "" {
+		respondError(c, http.StatusServiceUnavailable, "Recordings are not enabled")
+		return
+	}
+	session, ok := h.requireSpellingBee(c)
+	if !ok {
+		return
+	}
+	_, index, ok := requireBeeWord(c, session)
+	if !ok {
+		return
+	}
+
+	var request BeeRecordingRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	ext, ok := allowedRecordingTypes[request.ContentType]
+	if !ok {
+		respondError(c, http.StatusBadRequest, "Unsupported recording type; use WebM, Ogg, MP4, MP3 or WAV audio")
+		return
+	}
+	if request.Size <= 0 || request.Size > maxBeeRecordingSize {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Recordings must be between 1 byte and %d MB", maxBeeRecordingSize/(1024*1024)))
+		return
+	}
+
+	key := fmt.Sprintf("spelling-bee/%s/%s/%d%s", session.UserID, session.ID, index, ext)
+	req, _ := h.S3.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:        aws.String(h.RecordingBucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(request.ContentType),
+		ContentLength: aws.Int64(request.Size),
+	})
+	uploadURL, err := req.Presign(recordingUploadURLTTL)
+	if err != nil {
+		requestLogger(c).Error("error presigning recording upload", "error", err)
+		respondStorageError(c, err, "Failed to create upload URL")
+		return
+	}
+
+	_, err = h.DynamoDB.UpdateItemWithContext(c.Request.Context(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName("puzzle-hub-spelling-bee-sessions")),
+		Key:                       spellingBeeKey(session),
+		UpdateExpression:          aws.String(fmt.Sprintf("SET words[%d].recording_key = :key", index)),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":key": {S: aws.String(key)}},
+	})
+	if err != nil {
+		requestLogger(c).Error("error saving recording key", "error", err)
+		respondStorageError(c, err, "Failed to create upload URL")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"upload_url": uploadURL,
+		"method":     "PUT",
+		"headers": gin.H{
+			"Content-Type": request.ContentType,
+		},
+		"expires_in": int(recordingUploadURLTTL.Seconds()),
+	})
+}
+
+// finishSpellingBee ends the session, reveals every word and records the
+// score as progress
+func (h *PuzzleHub) finishSpellingBee(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	session, ok := h.requireSpellingBee(c)
+	if !ok {
+		return
+	}
+
+	var request FinishBeeRequest
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		respondBindError(c, err)
+		return
+	}
+	if request.DurationSeconds < 0 || request.DurationSeconds > 4*60*60 {
+		respondError(c, http.StatusBadRequest, "Duration must be between 0 and 4 hours")
+		return
+	}
+
+	now := time.Now()
+	_, err := h.DynamoDB.UpdateItemWithContext(c.Request.Context(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName("puzzle-hub-spelling-bee-sessions")),
+		Key:                       spellingBeeKey(session),
+		UpdateExpression:          aws.String("SET finished_at = :now"),
+		ConditionExpression:       aws.String("attribute_not_exists(finished_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":now": {S: aws.String(now.Format(time.RFC3339Nano))}},
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusConflict, "This spelling bee is already finished")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error finishing spelling bee", "error", err, "session_id", session.ID)
+		respondStorageError(c, err, "Failed to finish spelling bee")
+		return
+	}
+	session.FinishedAt = &now
+
+	result, err := h.saveActivityResult(userObj, "spelling", float64(session.Correct), float64(len(session.Words)), request.DurationSeconds)
+	if err != nil {
+		// The session itself is finished; only the progress entry is missing
+		requestLogger(c).Error("error saving spelling bee progress", "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session": session.forPlayer(),
+		"result":  result,
+	})
+}
+
+// getChildSpellingBees lets a parent review a child's recent sessions,
+// with links to the recordings
+func (h *PuzzleHub) getChildSpellingBees(c *gin.Context) {
+	link, ok := h.requireParentOf(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := queryLimit[SpellingBeeSession](c.Request.Context(), h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-spelling-bee-sessions")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(link.ChildID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, beeParentReviewLimit)
+	if err != nil {
+		requestLogger(c).Error("error fetching spelling bees", "error", err, "child_id", link.ChildID)
+		respondStorageError(c, err, "Failed to fetch spelling bees")
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].applyRival(&sessions[i])
+		for j := range sessions[i].Words {
+			word := &sessions[i].Words[j]
+			word.Index = j
+			word.Recorded = word.RecordingKey != ""
+			if word.Recorded && h.RecordingBucket != "" {
+				req, _ := h.S3.GetObjectRequest(&s3.GetObjectInput{
+					Bucket: aws.String(h.RecordingBucket),
+					Key:    aws.String(word.RecordingKey),
+				})
+				if url, err := req.Presign(attachmentDownloadURLTTL); err == nil {
+					word.RecordingURL = url
+				} else {
+					requestLogger(c).Error("error presigning recording", "error", err, "recording_key", word.RecordingKey)
+				}
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// requireSpellingBee loads the player's :sessionId, writing the error
+// response when it isn't there
+func (h *PuzzleHub) requireSpellingBee(c *gin.Context) (*SpellingBeeSession, bool) {
+	userObj := c.MustGet("user").(*User)
+	session, err := h.loadSpellingBee(c.Request.Context(), userObj.ID, c.Param("sessionId"))
+	if err != nil {
+		requestLogger(c).Error("error fetching spelling bee", "error", err)
+		respondStorageError(c, err, "Failed to fetch spelling bee")
+		return nil, false
+	}
+	if session == nil {
+		respondError(c, http.StatusNotFound, "Spelling bee not found")
+		return nil, false
+	}
+	return session, true
+}
+
+// loadSpellingBee returns a player's session, or nil if there is none
+func (h *PuzzleHub) loadSpellingBee(ctx context.Context, userID, sessionID string) (*SpellingBeeSession, error) {
+	out, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-spelling-bee-sessions")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":    {S: aws.String(userID)},
+			"session_id": {S: aws.String(sessionID)},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return nil, err
+	}
+	var session SpellingBeeSession
+	if err := dynamodbattribute.UnmarshalMap(out.Item, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// requireBeeWord returns the word at :index
+func requireBeeWord(c *gin.Context, session *SpellingBeeSession) (*SpellingBeeWord, int, bool) {
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 || index >= len(session.Words) {
+		respondError(c, http.StatusNotFound, "Word not found")
+		return nil, 0, false
+	}
+	return &session.Words[index], index, true
+}
+
+func spellingBeeKey(session *SpellingBeeSession) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"user_id":    {S: aws.String(session.UserID)},
+		"session_id": {S: aws.String(session.ID)},
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Spelling bee rival
+//
+// A spelling bee can be played against a simulated rival, so a child
+// practicing alone still has someone to beat. The rival's answer to every
+// word is decided on the server when the session starts: it spells a word
+// right with its accuracy, a little less for long words, and otherwise
+// makes the kind of slip children make (a missing double letter, ie for
+// ei, a swapped vowel). Its answers are hidden like the words themselves
+// and revealed one by one as the child spells, with the running score.
+//
+// Accuracy defaults per difficulty (rivalDefaultAccuracy) and can be set
+// for a difficulty with SPELLING_BEE_RIVAL_ACCURACY, e.g.
+// "elementary=0.6,advanced=0.9", or for one session in the request.
+
+const (
+	defaultRivalName     = "Buzz"
+	minRivalAccuracy     = 0.05
+	maxRivalAccuracy     = 0.99
+	rivalLongWordLength  = 8    // Words longer than this are harder for the rival
+	rivalLongWordPenalty = 0.03 // Accuracy lost per extra letter
+)
+
+var rivalDefaultAccuracy = map[DifficultyLevel]float64{
+	Elementary:   0.65,
+	Middle:       0.72,
+	Intermediate: 0.8,
+	Advanced:     0.88,
+}
+
+// rivalVowelSlips are the vowels children mix up
+var rivalVowelSlips = map[rune][]rune{
+	'a': {'e', 'i'},
+	'e': {'a', 'i'},
+	'i': {'e', 'y'},
+	'o': {'u', 'a'},
+	'u': {'o'},
+}
+
+type StartBeeRequest struct {
+	GenerationCriteria
+	Rival *BeeRivalOptions `json:"rival,omitempty"` // Play against a simulated rival
+}
+
+type BeeRivalOptions struct {
+	Name     string   `json:"name"`
+	Accuracy *float64 `json:"accuracy"` // 0.05 to 0.99, defaults by difficulty
+}
+
+// SpellingBeeRival is the simulated opponent of a session. Score counts
+// the rival's correct answers among the words revealed so far.
+type SpellingBeeRival struct {
+	Name     string  `json:"name" dynamodbav:"name"`
+	Accuracy float64 `json:"accuracy" dynamodbav:"accuracy"`
+	Score    int     `json:"score" dynamodbav:"-"`
+	Outcome  string  `json:"outcome,omitempty" dynamodbav:"-"` // won, lost or tied, for the child, once finished
+}
+
+// rivalAccuracy returns the default accuracy for a difficulty, honoring
+// SPELLING_BEE_RIVAL_ACCURACY
+func rivalAccuracy(difficulty string) float64 {
+	accuracy, ok := rivalDefaultAccuracy[DifficultyLevel(difficulty)]
+	if !ok {
+		accuracy = rivalDefaultAccuracy[Intermediate]
+	}
+
+	for _, pair := range strings.Split(os.Getenv("SPELLING_BEE_RIVAL_ACCURACY"), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), difficulty) {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || parsed < minRivalAccuracy || parsed > maxRivalAccuracy {
+			log.Printf("⚠️  Ignoring invalid SPELLING_BEE_RIVAL_ACCURACY entry %q", pair)
+			continue
+		}
+		accuracy = parsed
+	}
+	return accuracy
+}
+
+// newBeeRival checks the requested options and fills in the defaults
+func newBeeRival(options *BeeRivalOptions, difficulty string) (*SpellingBeeRival, error) {
+	rival := &SpellingBeeRival{
+		Name:     strings.TrimSpace(options.Name),
+		Accuracy: rivalAccuracy(difficulty),
+	}
+	if rival.Name == "" {
+		rival.Name = defaultRivalName
+	}
+	if len(rival.Name) > 30 {
+		return nil, fmt.Errorf("Rival name must be at most 30 characters")
+	}
+	if options.Accuracy != nil {
+		if *options.Accuracy < minRivalAccuracy || *options.Accuracy > maxRivalAccuracy {
+			return nil, fmt.Errorf("Rival accuracy must be between %.2f and %.2f", minRivalAccuracy, maxRivalAccuracy)
+		}
+		rival.Accuracy = *options.Accuracy
+	}
+	return rival, nil
+}
+
+// simulateRivalAttempts decides the rival's answer to every word
+func (r *SpellingBeeRival) simulateRivalAttempts(words []SpellingBeeWord, rng *rand.Rand) {
+	for i := range words {
+		word := &words[i]
+		chance := r.Accuracy
+		if extra := len(word.Word) - rivalLongWordLength; extra > 0 {
+			chance -= float64(extra) * rivalLongWordPenalty
+		}
+		chance = max(minRivalAccuracy, chance)
+
+		attempt := word.Word
+		if rng.Float64() >= chance {
+			attempt = rivalMisspelling(word.Word, rng)
+		}
+		correct := strings.EqualFold(attempt, word.Word)
+		word.RivalAttempt = &attempt
+		word.RivalCorrect = &correct
+	}
+}
+
+// rivalMisspelling returns a plausible wrong spelling of word, or the word
+// itself when it is too short to get wrong
+func rivalMisspelling(word string, rng *rand.Rand) string {
+	letters := []rune(strings.ToLower(word))
+	var classic, candidates []string
+
+	for i := 0; i+1 < len(letters); i++ {
+		// Drop one of a double letter: necessary -> necesary
+		if letters[i] == letters[i+1] {
+			classic = append(classic, string(letters[:i])+string(letters[i+1:]))
+		}
+		// ie and ei swapped: receive -> recieve
+		if (letters[i] == 'i' && letters[i+1] == 'e') || (letters[i] == 'e' && letters[i+1] == 'i') {
+			swapped := append([]rune{}, letters...)
+			swapped[i], swapped[i+1] = swapped[i+1], swapped[i]
+			classic = append(classic, string(swapped))
+		}
+	}
+	for i, letter := range letters {
+		// Double a single consonant: until -> untill
+		if i > 0 && unicode.IsLetter(letter) && rivalVowelSlips[letter] == nil && (i+1 == len(letters) || letters[i+1] != letter) && letters[i-1] != letter {
+			candidates = append(candidates, string(letters[:i+1])+string(letters[i:]))
+		}
+		// A vowel mixed up inside the word: separate -> seperate
+		if i == 0 || i == len(letters)-1 {
+			continue
+		}
+		for _, slip := range rivalVowelSlips[letter] {
+			changed := append([]rune{}, letters...)
+			changed[i] = slip
+			candidates = append(candidates, string(changed))
+		}
+	}
+
+	// The classic slips are the likeliest when the word allows them
+	if len(classic) > 0 && (len(candidates) == 0 || rng.Float64() < 0.7) {
+		return classic[rng.Intn(len(classic))]
+	}
+	if len(candidates) == 0 {
+		return word
+	}
+	return candidates[rng.Intn(len(candidates))]
+}
+
+// applyRival fills in the rival's running score and, once the session is
+// finished, the outcome
+func (s *SpellingBeeSession) applyRival(view *SpellingBeeSession) {
+	if s.Rival == nil {
+		return
+	}
+	rival := *s.Rival
+	rival.Score = 0
+	for _, word := range view.Words {
+		if word.RivalCorrect != nil && *word.RivalCorrect {
+			rival.Score++
+		}
+	}
+	if s.FinishedAt != nil {
+		switch {
+		case s.Correct > rival.Score:
+			rival.Outcome = "won"
+		case s.Correct < rival.Score:
+			rival.Outcome = "lost"
+		default:
+			rival.Outcome = "tied"
+		}
+	}
+	view.Rival = &rival
+}
@@ -103,12 +103,6 @@ if [ ! -f "main.go" ]; then
     exit 1
 fi
 
-# Create cache directory if it doesn't exist
-if [ ! -d "cache" ]; then
-    mkdir -p cache
-    print_status "Created cache directory"
-fi
-
 # Download dependencies if needed
 if [ ! -f "go.sum" ] || [ "go.mod" -nt "go.sum" ]; then
     print_status "Downloading Go dependencies..."
@@ -4,6 +4,17 @@
 let currentPuzzleType = null; // 'spelling' or 'yohaku'
 let gameState = 'idle'; // 'idle', 'playing', 'finished'
 
+// API errors come as {"error": {"code", "message", "details", "request_id"}}
+function apiErrorMessage(data, fallback) {
+    const error = data && data.error;
+    if (!error) return fallback;
+    if (typeof error === 'string') return error;
+    if (Array.isArray(error.details) && error.details.length > 0) {
+        return error.message + ': ' + error.details.map(d => d.field + ' ' + d.message).join(', ');
+    }
+    return error.message || fallback;
+}
+
 // Authentication state
 let currentUser = null;
 let authToken = null;
@@ -164,7 +175,7 @@ async function startSpellingGame() {
     showLoading(true);
     
     try {
-        const response = await makeAuthenticatedRequest('/api/spelling/generate-for-age', {
+        const response = await makeAuthenticatedRequest('/api/v1/spelling/generate-for-age', {
             method: 'POST',
             body: JSON.stringify({
                 age: age,
@@ -428,7 +439,7 @@ async function startYohakuGame() {
     showLoading(true);
     
     try {
-        const response = await fetch('/api/yohaku/start-game', {
+        const response = await fetch('/api/v1/yohaku/start-game', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -498,11 +509,14 @@ function loadCurrentYohakuPuzzle() {
     displayYohakuPuzzle(currentYohakuPuzzle);
     updateYohakuOperationDisplay();
     
-    // Start timer with puzzle-specific duration from the progressive settings
+    // Start timer with puzzle-specific duration from the progressive settings,
+    // unless the player's accessibility preferences turned timers off
     const puzzleSettings = getPuzzleSettings(currentYohakuPuzzle.level);
     yohakuTimeRemaining = puzzleSettings.timerDuration;
     
-    startYohakuTimer();
+    if (!currentYohakuSession.settings || !currentYohakuSession.settings.untimed) {
+        startYohakuTimer();
+    }
     
     showFeedback(`Level ${currentYohakuPuzzle.level}: ${currentYohakuPuzzle.difficulty} ${currentYohakuPuzzle.size}x${currentYohakuPuzzle.size} puzzle!`, 'info');
 }
@@ -662,7 +676,7 @@ async function validateYohakuSolution() {
     const currentGrid = getCurrentYohakuGridState();
     
     try {
-        const response = await fetch('/api/yohaku/validate', {
+        const response = await fetch('/api/v1/yohaku/validate', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -759,7 +773,7 @@ async function getYohakuHint() {
     }
     
     try {
-        const response = await fetch('/api/yohaku/hint', {
+        const response = await fetch('/api/v1/yohaku/hint', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -1100,7 +1114,7 @@ async function handleWritingSubmission(event) {
 }
 
 async function analyzeWriting(request) {
-    const response = await fetch('/api/writing/analyze', {
+    const response = await fetch('/api/v1/writing/analyze', {
         method: 'POST',
         headers: {
             'Content-Type': 'application/json',
@@ -1112,7 +1126,7 @@ async function analyzeWriting(request) {
         let errorMessage = 'Failed to analyze writing';
         try {
             const errorData = await response.json();
-            errorMessage = errorData.error || errorMessage;
+            errorMessage = apiErrorMessage(errorData, errorMessage);
         } catch (parseError) {
             // If we can't parse the error response as JSON, use the status text
             errorMessage = `Server error: ${response.status} ${response.statusText}`;
@@ -1714,8 +1728,8 @@ function initializeAuth() {
         currentUser = JSON.parse(storedUser);
         isAuthenticated = true;
         
-        // Verify token is still valid
-        verifyAuthToken().then(valid => {
+        // Verify token is still valid, refreshing it if it has expired
+        verifyAuthToken().then(valid => valid || refreshAuthToken()).then(valid => {
             if (valid) {
                 updateAuthUI();
             } else {
@@ -1751,6 +1765,40 @@ async function verifyAuthToken() {
     }
 }
 
+// Exchange the stored refresh token for a new access/refresh token pair
+let refreshInFlight = null;
+function refreshAuthToken() {
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken) return Promise.resolve(false);
+
+    // Concurrent 401s share one refresh; the old refresh token is single-use
+    if (!refreshInFlight) {
+        refreshInFlight = fetch('/auth/refresh', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: refreshToken })
+        }).then(async response => {
+            if (!response.ok) {
+                localStorage.removeItem('refreshToken');
+                return false;
+            }
+            const data = await response.json();
+            authToken = data.token;
+            currentUser = data.user;
+            localStorage.setItem('authToken', authToken);
+            localStorage.setItem('refreshToken', data.refresh_token);
+            localStorage.setItem('currentUser', JSON.stringify(currentUser));
+            return true;
+        }).catch(error => {
+            console.error('Token refresh failed:', error);
+            return false;
+        }).finally(() => {
+            refreshInFlight = null;
+        });
+    }
+    return refreshInFlight;
+}
+
 // Show login screen
 function showLoginScreen() {
     document.body.innerHTML = `
@@ -1846,7 +1894,7 @@ async function loginWithGoogle() {
         const response = await fetch('/auth/google');
         if (!response.ok) {
             const error = await response.json();
-            throw new Error(error.error || 'Failed to get Google login URL');
+            throw new Error(apiErrorMessage(error, 'Failed to get Google login URL'));
         }
         
         const data = await response.json();
@@ -1906,6 +1954,7 @@ function handleLoginSuccess(loginResult) {
     
     // Store in localStorage
     localStorage.setItem('authToken', authToken);
+    localStorage.setItem('refreshToken', loginResult.refresh_token || '');
     localStorage.setItem('currentUser', JSON.stringify(currentUser));
     
     showFeedback(`Welcome back, ${currentUser.name}!`, 'success');
@@ -1918,8 +1967,22 @@ function handleLoginSuccess(loginResult) {
 
 // Logout function
 function logout() {
+    // Revoke the session server-side (best effort)
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (authToken || refreshToken) {
+        fetch('/auth/logout', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...(authToken ? { 'Authorization': `Bearer ${authToken}` } : {})
+            },
+            body: JSON.stringify({ refresh_token: refreshToken || '' })
+        }).catch(error => console.error('Logout request failed:', error));
+    }
+
     // Clear local storage
     localStorage.removeItem('authToken');
+    localStorage.removeItem('refreshToken');
     localStorage.removeItem('currentUser');
     
     // Reset state
@@ -1969,6 +2032,27 @@ function updateAuthUI() {
         `;
         navbarNav.appendChild(userNavItem);
     }
+
+    syncUserTimezone();
+}
+
+// Send the browser's timezone so server-side date math matches the user's calendar
+async function syncUserTimezone() {
+    const timezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    if (!timezone || currentUser.timezone === timezone) return;
+
+    try {
+        const response = await makeAuthenticatedRequest('/api/v1/user/timezone', {
+            method: 'PUT',
+            body: JSON.stringify({ timezone })
+        });
+        if (response.ok) {
+            currentUser.timezone = timezone;
+            localStorage.setItem('currentUser', JSON.stringify(currentUser));
+        }
+    } catch (error) {
+        console.error('Failed to sync timezone:', error);
+    }
 }
 
 // Show user profile
@@ -2001,12 +2085,20 @@ async function makeAuthenticatedRequest(url, options = {}) {
         ...options.headers
     };
     
-    const response = await fetch(url, {
+    let response = await fetch(url, {
         ...options,
         headers
     });
     
-    // If we get 401, the token might be expired
+    // Access tokens are short-lived; refresh once and retry
+    if (response.status === 401 && await refreshAuthToken()) {
+        response = await fetch(url, {
+            ...options,
+            headers: { ...headers, 'Authorization': `Bearer ${authToken}` }
+        });
+    }
+    
+    // If we still get 401, the session has expired or been revoked
     if (response.status === 401) {
         console.error('Authentication failed - token may be expired');
         localStorage.removeItem('authToken');
@@ -2025,7 +2117,7 @@ async function makeAuthenticatedRequest(url, options = {}) {
 // Load user's log types
 async function loadLogTypes() {
     try {
-        const response = await makeAuthenticatedRequest('/api/logs/types');
+        const response = await makeAuthenticatedRequest('/api/v1/logs/types');
         
         if (!response.ok) {
             throw new Error(`HTTP error! status: ${response.status}`);
@@ -2141,7 +2233,7 @@ async function viewLogEntries(logTypeId) {
     try {
         console.log('Loading entries for log type ID:', logTypeId);
         showFeedback('Loading entries...', 'info');
-        const response = await makeAuthenticatedRequest(`/api/logs/entries?log_type_id=${logTypeId}`);
+        const response = await makeAuthenticatedRequest(`/api/v1/logs/entries?log_type_id=${logTypeId}`);
         
         console.log('Response status:', response.status);
         
@@ -2341,14 +2433,14 @@ async function handleCreateLogType(e) {
     try {
         showFeedback('Creating log type...', 'info');
         
-        const response = await makeAuthenticatedRequest('/api/logs/types', {
+        const response = await makeAuthenticatedRequest('/api/v1/logs/types', {
             method: 'POST',
             body: JSON.stringify(logTypeData)
         });
         
         if (!response.ok) {
             const errorData = await response.json();
-            throw new Error(errorData.error || 'Failed to create log type');
+            throw new Error(apiErrorMessage(errorData, 'Failed to create log type'));
         }
         
         const result = await response.json();
@@ -2460,7 +2552,7 @@ async function suggestFields() {
         };
         console.log('Request body:', requestBody);
         
-        const response = await makeAuthenticatedRequest('/api/logs/types/suggest-fields', {
+        const response = await makeAuthenticatedRequest('/api/v1/logs/types/suggest-fields', {
             method: 'POST',
             body: JSON.stringify(requestBody)
         });
@@ -2552,7 +2644,7 @@ function addSuggestedField(field, index) {
 // Load log types for the entry form dropdown
 async function loadLogTypesForEntryForm() {
     try {
-        const response = await makeAuthenticatedRequest('/api/logs/types');
+        const response = await makeAuthenticatedRequest('/api/v1/logs/types');
         
         if (!response.ok) {
             throw new Error(`HTTP error! status: ${response.status}`);
@@ -2750,7 +2842,7 @@ async function handleAddLogEntry(e) {
     try {
         showFeedback('Adding log entry...', 'info');
         
-        const response = await makeAuthenticatedRequest('/api/logs/entries', {
+        const response = await makeAuthenticatedRequest('/api/v1/logs/entries', {
             method: 'POST',
             body: JSON.stringify(entryData)
         });
@@ -2759,7 +2851,7 @@ async function handleAddLogEntry(e) {
         
         if (!response.ok) {
             const errorData = await response.json();
-            throw new Error(errorData.error || 'Failed to add log entry');
+            throw new Error(apiErrorMessage(errorData, 'Failed to add log entry'));
         }
         
         const result = await response.json();
@@ -2793,7 +2885,7 @@ async function loadAnalytics() {
         console.log('Loading analytics...');
         showFeedback('Loading analytics...', 'info');
         
-        const response = await makeAuthenticatedRequest('/api/logs/analytics');
+        const response = await makeAuthenticatedRequest('/api/v1/logs/analytics');
         console.log('Analytics response status:', response.status);
         
         if (!response.ok) {
@@ -2983,7 +3075,7 @@ async function viewDetailedAnalytics(logTypeId) {
     try {
         showFeedback('Loading detailed analytics...', 'info');
         
-        const response = await makeAuthenticatedRequest(`/api/logs/analytics/${logTypeId}`);
+        const response = await makeAuthenticatedRequest(`/api/v1/logs/analytics/${logTypeId}`);
         if (!response.ok) {
             throw new Error(`HTTP error! status: ${response.status}`);
         }
@@ -3378,7 +3470,7 @@ async function renderDetailedLogTypeCalendar(logAnalytics) {
     
     // Get detailed data for this log type
     try {
-        const response = await makeAuthenticatedRequest(`/api/logs/analytics/${logAnalytics.log_type_id}`);
+        const response = await makeAuthenticatedRequest(`/api/v1/logs/analytics/${logAnalytics.log_type_id}`);
         if (!response.ok) {
             throw new Error(`Failed to load detailed analytics`);
         }
@@ -3776,6 +3868,11 @@ function calculateDayMetrics(dayActivity, calendarType, fieldAnalytics) {
             dailyPL += entryPL;
         });
         
+        // Older days come with the day's sums but without their entries
+        if (entries.length === 0 && dayActivity.sums && dayActivity.sums.profit_loss !== undefined) {
+            dailyPL = dayActivity.sums.profit_loss;
+        }
+        
         console.log('Total daily P&L:', dailyPL);
         
         return {
@@ -3907,7 +4004,7 @@ async function deleteLogEntry(entryId) {
         console.log('Deleting entry with ID:', entryId);
         showFeedback('Deleting entry...', 'info');
         
-        const response = await makeAuthenticatedRequest(`/api/logs/entries/${entryId}`, {
+        const response = await makeAuthenticatedRequest(`/api/v1/logs/entries/${entryId}`, {
             method: 'DELETE'
         });
         
@@ -3916,7 +4013,7 @@ async function deleteLogEntry(entryId) {
         if (!response.ok) {
             const errorData = await response.json();
             console.error('Delete error:', errorData);
-            throw new Error(errorData.error || 'Failed to delete entry');
+            throw new Error(apiErrorMessage(errorData, 'Failed to delete entry'));
         }
         
         // Remove the row from the table
@@ -3963,7 +4060,7 @@ async function generateStory() {
     document.getElementById('storyResult').style.display = 'none';
 
     try {
-        const response = await fetch('/api/story/generate', {
+        const response = await fetch('/api/v1/story/generate', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
@@ -3980,7 +4077,7 @@ async function generateStory() {
 
         if (!response.ok) {
             const error = await response.json();
-            throw new Error(error.error || 'Failed to generate story');
+            throw new Error(apiErrorMessage(error, 'Failed to generate story'));
         }
 
         const data = await response.json();
@@ -4110,7 +4207,7 @@ function setupFeedbackForm() {
         }
         
         try {
-            const response = await fetch('/api/feedback/submit', {
+            const response = await fetch('/api/v1/feedback/submit', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -4148,7 +4245,7 @@ async function loadFeedbackHistory() {
     if (!feedbackHistory) return;
     
     try {
-        const response = await fetch('/api/feedback/list', {
+        const response = await fetch('/api/v1/feedback/list', {
             headers: {
                 'Authorization': `Bearer ${authToken}`
             }
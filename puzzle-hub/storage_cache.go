@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// cachedStorage puts the Cache in front of the storage reads made on most
+// requests: user roles and preferences (read to build every signed-in
+// user) and log types and their fields (read for every entry written or
+// analysed).
+// Writes through it invalidate the cached copy.
+
+const cachedValueTTL = 5 * time.Minute
+
+type cachedStorage struct {
+	Storage
+	cache Cache
+}
+
+func newCachedStorage(store Storage, cache Cache) Storage {
+	if _, off := cache.(noCache); off {
+		return store
+	}
+	return &cachedStorage{Storage: store, cache: cache}
+}
+
+// cachedLookup wraps a "maybe missing" value so misses are cached too
+type cachedLookup[T any] struct {
+	Found bool `json:"found"`
+	Value T    `json:"value"`
+}
+
+func (s *cachedStorage) GetUserRole(userID string) (*UserRoleAssignment, error) {
+	ctx := context.Background()
+	key := "user-role:" + userID
+	var cached cachedLookup[*UserRoleAssignment]
+	if getCachedJSON(ctx, s.cache, "user_role", key, &cached) {
+		return cached.Value, nil
+	}
+
+	assignment, err := s.Storage.GetUserRole(userID)
+	if err != nil {
+		return nil, err
+	}
+	setCachedJSON(ctx, s.cache, key, cachedLookup[*UserRoleAssignment]{Found: assignment != nil, Value: assignment}, cachedValueTTL)
+	return assignment, nil
+}
+
+func (s *cachedStorage) PutUserRole(assignment *UserRoleAssignment) error {
+	err := s.Storage.PutUserRole(assignment)
+	invalidateCache(context.Background(), s.cache, "user-role:"+assignment.UserID)
+	return err
+}
+
+func (s *cachedStorage) GetUserPreferences(userID string, prefs *UserPreferences) (bool, error) {
+	ctx := context.Background()
+	key := "user-prefs:" + userID
+	var cached cachedLookup[*UserPreferences]
+	if getCachedJSON(ctx, s.cache, "user_preferences", key, &cached) {
+		if cached.Found && cached.Value != nil {
+			*prefs = *cached.Value
+			prefs.UserID = userID
+		}
+		return cached.Found, nil
+	}
+
+	found, err := s.Storage.GetUserPreferences(userID, prefs)
+	if err != nil {
+		return false, err
+	}
+	lookup := cachedLookup[*UserPreferences]{Found: found}
+	if found {
+		lookup.Value = prefs
+	}
+	setCachedJSON(ctx, s.cache, key, lookup, cachedValueTTL)
+	return found, nil
+}
+
+func (s *cachedStorage) PutUserPreferences(prefs *UserPreferences) error {
+	err := s.Storage.PutUserPreferences(prefs)
+	invalidateCache(context.Background(), s.cache, "user-prefs:"+prefs.UserID)
+	return err
+}
+
+// ListLogTypes is a table scan on DynamoDB, so caching it matters most
+func (s *cachedStorage) ListLogTypes(userID string) ([]LogType, error) {
+	ctx := context.Background()
+	key := "log-types:" + userID
+	var logTypes []LogType
+	if getCachedJSON(ctx, s.cache, "log_types", key, &logTypes) {
+		return logTypes, nil
+	}
+
+	logTypes, err := s.Storage.ListLogTypes(userID)
+	if err != nil {
+		return nil, err
+	}
+	setCachedJSON(ctx, s.cache, key, logTypes, cachedValueTTL)
+	return logTypes, nil
+}
+
+func (s *cachedStorage) GetLogType(logTypeID string) (*LogType, error) {
+	ctx := context.Background()
+	key := "log-type:" + logTypeID
+	var cached cachedLookup[*LogType]
+	if getCachedJSON(ctx, s.cache, "log_type", key, &cached) {
+		return cached.Value, nil
+	}
+
+	logType, err := s.Storage.GetLogType(logTypeID)
+	if err != nil {
+		return nil, err
+	}
+	setCachedJSON(ctx, s.cache, key, cachedLookup[*LogType]{Found: logType != nil, Value: logType}, cachedValueTTL)
+	return logType, nil
+}
+
+func (s *cachedStorage) PutLogType(logType *LogType) error {
+	err := s.Storage.PutLogType(logType)
+	invalidateCache(context.Background(), s.cache, "log-type:"+logType.ID, "log-types:"+logType.UserID)
+	return err
+}
+
+func (s *cachedStorage) SetLogTypeRetention(logTypeID string, days int) error {
+	err := s.Storage.SetLogTypeRetention(logTypeID, days)
+	keys := []string{"log-type:" + logTypeID}
+	if logType, lookupErr := s.Storage.GetLogType(logTypeID); lookupErr == nil && logType != nil {
+		keys = append(keys, "log-types:"+logType.UserID)
+	}
+	invalidateCache(context.Background(), s.cache, keys...)
+	return err
+}
+
+func (s *cachedStorage) ListLogFields(logTypeID string) ([]LogField, error) {
+	ctx := context.Background()
+	key := "log-fields:" + logTypeID
+	var fields []LogField
+	if getCachedJSON(ctx, s.cache, "log_fields", key, &fields) {
+		return fields, nil
+	}
+
+	fields, err := s.Storage.ListLogFields(logTypeID)
+	if err != nil {
+		return nil, err
+	}
+	setCachedJSON(ctx, s.cache, key, fields, cachedValueTTL)
+	return fields, nil
+}
+
+func (s *cachedStorage) PutLogField(field *LogField) error {
+	err := s.Storage.PutLogField(field)
+	invalidateCache(context.Background(), s.cache, "log-fields:"+field.LogTypeID)
+	return err
+}
+
+// SetLogFieldOrder only has the field ID, so callers that reorder must
+// call invalidateLogFields for the log type afterwards
+func (s *cachedStorage) SetLogFieldOrder(fieldID string, order int) error {
+	return s.Storage.SetLogFieldOrder(fieldID, order)
+}
+
+// invalidateLogFields drops a log type's cached fields
+func (h *PuzzleHub) invalidateLogFields(logTypeID string) {
+	invalidateCache(context.Background(), h.Cache, "log-fields:"+logTypeID)
+}
@@ -0,0 +1,1224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// dynamoStorage keeps each kind of record in its own DynamoDB table; the
+// tables are created by createDynamoDBTables
+type dynamoStorage struct {
+	db *dynamodb.DynamoDB
+}
+
+// getItem loads one item into out and reports whether it exists
+func (s *dynamoStorage) getItem(table string, key map[string]*dynamodb.AttributeValue, out interface{}) (bool, error) {
+	result, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key:       key,
+	})
+	if err != nil {
+		return false, err
+	}
+	if result.Item == nil {
+		return false, nil
+	}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, out); err != nil {
+		return false, fmt.Errorf("failed to unmarshal %s item: %v", table, err)
+	}
+	return true, nil
+}
+
+func (s *dynamoStorage) putItem(table string, record interface{}) error {
+	item, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s item: %v", table, err)
+	}
+	_, err = s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	return err
+}
+
+// Ping describes one of the tables, which needs both the endpoint and the
+// credentials to work
+func (s *dynamoStorage) Ping(ctx context.Context) error {
+	_, err := s.db.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName("puzzle-hub-user-roles")),
+	})
+	return err
+}
+
+// Users
+
+func (s *dynamoStorage) GetUserRole(userID string) (*UserRoleAssignment, error) {
+	var assignment UserRoleAssignment
+	found, err := s.getItem(tableName("puzzle-hub-user-roles"), map[string]*dynamodb.AttributeValue{
+		"user_id": {S: aws.String(userID)},
+	}, &assignment)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+func (s *dynamoStorage) PutUserRole(assignment *UserRoleAssignment) error {
+	return s.putItem(tableName("puzzle-hub-user-roles"), assignment)
+}
+
+// ListUserRoles sorts by user ID like the SQL backend, so pages are stable
+func (s *dynamoStorage) ListUserRoles() ([]UserRoleAssignment, error) {
+	assignments, err := scanAll[UserRoleAssignment](s.db, &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-user-roles")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].UserID < assignments[j].UserID })
+	return assignments, nil
+}
+
+func (s *dynamoStorage) GetUserPreferences(userID string, prefs *UserPreferences) (bool, error) {
+	return s.getItem(tableName("puzzle-hub-user-preferences"), map[string]*dynamodb.AttributeValue{
+		"user_id": {S: aws.String(userID)},
+	}, prefs)
+}
+
+func (s *dynamoStorage) PutUserPreferences(prefs *UserPreferences) error {
+	return s.putItem(tableName("puzzle-hub-user-preferences"), prefs)
+}
+
+// Sessions and tokens
+
+func (s *dynamoStorage) PutLoginSession(session *LoginSession) error {
+	return s.putItem(tableName("puzzle-hub-login-sessions"), session)
+}
+
+func (s *dynamoStorage) GetLoginSession(userID, sessionID string) (*LoginSession, error) {
+	var session LoginSession
+	found, err := s.getItem(tableName("puzzle-hub-login-sessions"), map[string]*dynamodb.AttributeValue{
+		"user_id":    {S: aws.String(userID)},
+		"session_id": {S: aws.String(sessionID)},
+	}, &session)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *dynamoStorage) ListLoginSessions(userID string) ([]LoginSession, error) {
+	return queryAll[LoginSession](s.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-login-sessions")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	})
+}
+
+func (s *dynamoStorage) TouchLoginSession(userID, sessionID string, at time.Time) error {
+	return s.updateLoginSession(userID, sessionID, "SET last_used_at = :now", at)
+}
+
+func (s *dynamoStorage) EndLoginSession(userID, sessionID string, at time.Time) error {
+	return s.updateLoginSession(userID, sessionID, "SET revoked_at = if_not_exists(revoked_at, :now)", at)
+}
+
+func (s *dynamoStorage) updateLoginSession(userID, sessionID, update string, at time.Time) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-login-sessions")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":    {S: aws.String(userID)},
+			"session_id": {S: aws.String(sessionID)},
+		},
+		UpdateExpression:    aws.String(update),
+		ConditionExpression: aws.String("attribute_exists(session_id)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {S: aws.String(at.Format(time.RFC3339Nano))},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *dynamoStorage) PutRefreshToken(token *RefreshToken) error {
+	return s.putItem(tableName("puzzle-hub-refresh-tokens"), token)
+}
+
+func (s *dynamoStorage) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	result, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-refresh-tokens")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"token_hash": {S: aws.String(tokenHash)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var token RefreshToken
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &token); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal refresh token: %v", err)
+	}
+	return &token, nil
+}
+
+func (s *dynamoStorage) RotateRefreshToken(tokenHash, replacedBy string) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-refresh-tokens")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"token_hash": {S: aws.String(tokenHash)},
+		},
+		UpdateExpression:    aws.String("SET revoked = :true, replaced_by = :replaced_by"),
+		ConditionExpression: aws.String("revoked = :false"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true":        {BOOL: aws.Bool(true)},
+			":false":       {BOOL: aws.Bool(false)},
+			":replaced_by": {S: aws.String(replacedBy)},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return errStorageConflict
+	}
+	return err
+}
+
+func (s *dynamoStorage) RevokeRefreshFamily(familyID string) error {
+	var errs []error
+	err := s.db.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-refresh-tokens")),
+		IndexName:              aws.String("family_id-index"),
+		KeyConditionExpression: aws.String("family_id = :family_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":family_id": {S: aws.String(familyID)},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName: aws.String(tableName("puzzle-hub-refresh-tokens")),
+				Key: map[string]*dynamodb.AttributeValue{
+					"token_hash": item["token_hash"],
+				},
+				UpdateExpression: aws.String("SET revoked = :true"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":true": {BOOL: aws.Bool(true)},
+				},
+			})
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return true
+	})
+	return errors.Join(append(errs, err)...)
+}
+
+func (s *dynamoStorage) RevokeAccessToken(jti string, expiresAt int64) error {
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-revoked-tokens")),
+		Item: map[string]*dynamodb.AttributeValue{
+			"jti":        {S: aws.String(jti)},
+			"expires_at": {N: aws.String(strconv.FormatInt(expiresAt, 10))},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) IsAccessTokenRevoked(jtis []string) (bool, error) {
+	keys := make([]map[string]*dynamodb.AttributeValue, len(jtis))
+	for i, jti := range jtis {
+		keys[i] = map[string]*dynamodb.AttributeValue{"jti": {S: aws.String(jti)}}
+	}
+	items, err := batchGetItems(s.db, tableName("puzzle-hub-revoked-tokens"), keys)
+	if err != nil {
+		return false, err
+	}
+	return len(items) > 0, nil
+}
+
+// Feedback
+
+func (s *dynamoStorage) PutFeedback(feedback *Feedback) error {
+	return s.putItem(tableName("puzzle-hub-feedback"), feedback)
+}
+
+func (s *dynamoStorage) GetFeedback(feedbackID string) (*Feedback, error) {
+	var feedback Feedback
+	found, err := s.getItem(tableName("puzzle-hub-feedback"), map[string]*dynamodb.AttributeValue{
+		"id": {S: aws.String(feedbackID)},
+	}, &feedback)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &feedback, nil
+}
+
+func (s *dynamoStorage) ListFeedback(filter FeedbackFilter) ([]Feedback, error) {
+	var filters []string
+	values := map[string]*dynamodb.AttributeValue{}
+	names := map[string]*string{}
+	for attribute, value := range map[string]string{"type": filter.Type, "status": filter.Status, "app_name": filter.AppName} {
+		if value != "" {
+			filters = append(filters, "#"+attribute+" = :"+attribute)
+			values[":"+attribute] = &dynamodb.AttributeValue{S: aws.String(value)}
+			names["#"+attribute] = aws.String(attribute)
+		}
+	}
+	if filter.PublicOnly {
+		filters = append(filters, "#public = :public")
+		values[":public"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		names["#public"] = aws.String("public")
+	}
+	sort.Strings(filters)
+
+	var feedbackList []Feedback
+	var err error
+	if filter.UserID != "" {
+		values[":user_id"] = &dynamodb.AttributeValue{S: aws.String(filter.UserID)}
+		input := &dynamodb.QueryInput{
+			TableName:                 aws.String(tableName("puzzle-hub-feedback")),
+			IndexName:                 aws.String("user_id-created_at-index"),
+			KeyConditionExpression:    aws.String("user_id = :user_id"),
+			ExpressionAttributeValues: values,
+			ScanIndexForward:          aws.Bool(false), // Most recent first
+		}
+		if len(filters) > 0 {
+			input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+			input.ExpressionAttributeNames = names
+		}
+		feedbackList, err = queryAll[Feedback](s.db, input)
+	} else {
+		input := &dynamodb.ScanInput{
+			TableName: aws.String(tableName("puzzle-hub-feedback")),
+		}
+		if len(filters) > 0 {
+			input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+			input.ExpressionAttributeValues = values
+			input.ExpressionAttributeNames = names
+		}
+		feedbackList, err = scanAll[Feedback](s.db, input)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(feedbackList, func(i, j int) bool {
+		return feedbackList[i].CreatedAt.After(feedbackList[j].CreatedAt)
+	})
+	return feedbackList, nil
+}
+
+func (s *dynamoStorage) UpdateFeedback(feedbackID string, update FeedbackUpdate) (*Feedback, error) {
+	sets := []string{"updated_at = :updated_at"}
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{
+		":updated_at": {S: aws.String(time.Now().Format(time.RFC3339Nano))},
+	}
+	if update.Status != nil {
+		sets = append(sets, "#status = :status")
+		names["#status"] = aws.String("status")
+		values[":status"] = &dynamodb.AttributeValue{S: update.Status}
+	}
+	if update.AssignedTo != nil {
+		sets = append(sets, "assigned_to = :assigned_to")
+		values[":assigned_to"] = &dynamodb.AttributeValue{S: update.AssignedTo}
+	}
+	if update.Public != nil {
+		sets = append(sets, "#public = :public")
+		names["#public"] = aws.String("public")
+		values[":public"] = &dynamodb.AttributeValue{BOOL: update.Public}
+	}
+	if update.AddNote != nil {
+		note, err := dynamodbattribute.Marshal([]FeedbackNote{*update.AddNote})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal feedback note: %v", err)
+		}
+		sets = append(sets, "internal_notes = list_append(if_not_exists(internal_notes, :empty), :note)")
+		values[":note"] = note
+		values[":empty"] = &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-feedback")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(feedbackID)},
+		},
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:       aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String("ALL_NEW"),
+	}
+	if len(names) > 0 {
+		input.ExpressionAttributeNames = names
+	}
+
+	result, err := s.db.UpdateItem(input)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return nil, errStorageNotFound
+		}
+		return nil, err
+	}
+
+	var feedback Feedback
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &feedback); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal updated feedback: %v", err)
+	}
+	return &feedback, nil
+}
+
+func (s *dynamoStorage) ChangeFeedbackVote(feedbackID, userID string, vote bool) error {
+	var voteItem *dynamodb.TransactWriteItem
+	delta := "1"
+	if vote {
+		item, err := dynamodbattribute.MarshalMap(FeedbackVote{
+			FeedbackID: feedbackID,
+			UserID:     userID,
+			CreatedAt:  time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal vote: %v", err)
+		}
+		voteItem = &dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+			TableName:           aws.String(tableName("puzzle-hub-feedback-votes")),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(user_id)"),
+		}}
+	} else {
+		delta = "-1"
+		voteItem = &dynamodb.TransactWriteItem{Delete: &dynamodb.Delete{
+			TableName: aws.String(tableName("puzzle-hub-feedback-votes")),
+			Key: map[string]*dynamodb.AttributeValue{
+				"feedback_id": {S: aws.String(feedbackID)},
+				"user_id":     {S: aws.String(userID)},
+			},
+			ConditionExpression: aws.String("attribute_exists(user_id)"),
+		}}
+	}
+
+	_, err := s.db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			voteItem,
+			{Update: &dynamodb.Update{
+				TableName: aws.String(tableName("puzzle-hub-feedback")),
+				Key: map[string]*dynamodb.AttributeValue{
+					"id": {S: aws.String(feedbackID)},
+				},
+				UpdateExpression:    aws.String("ADD vote_count :delta"),
+				ConditionExpression: aws.String("#public = :public"),
+				ExpressionAttributeNames: map[string]*string{
+					"#public": aws.String("public"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":delta":  {N: aws.String(delta)},
+					":public": {BOOL: aws.Bool(true)},
+				},
+			}},
+		},
+	})
+	var canceled *dynamodb.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) == 2 {
+		if aws.StringValue(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+			return errStorageConflict
+		}
+		if aws.StringValue(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+			return errStorageNotFound
+		}
+	}
+	return err
+}
+
+func (s *dynamoStorage) GetFeedbackVotes(userID string, feedbackIDs []string) (map[string]bool, error) {
+	voted := make(map[string]bool)
+
+	for start := 0; start < len(feedbackIDs); start += dynamoBatchGetSize {
+		end := min(start+dynamoBatchGetSize, len(feedbackIDs))
+
+		keys := make([]map[string]*dynamodb.AttributeValue, 0, end-start)
+		for _, id := range feedbackIDs[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"feedback_id": {S: aws.String(id)},
+				"user_id":     {S: aws.String(userID)},
+			})
+		}
+
+		items, err := batchGetItems(s.db, tableName("puzzle-hub-feedback-votes"), keys)
+		if err != nil {
+			return voted, err
+		}
+		var votes []FeedbackVote
+		if err := dynamodbattribute.UnmarshalListOfMaps(items, &votes); err != nil {
+			return voted, err
+		}
+		for _, vote := range votes {
+			voted[vote.FeedbackID] = true
+		}
+	}
+
+	return voted, nil
+}
+
+// Logs
+
+func (s *dynamoStorage) ListLogTypes(userID string) ([]LogType, error) {
+	return queryAll[LogType](s.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-log-types")),
+		IndexName:              aws.String("user-id-index"),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	})
+}
+
+func (s *dynamoStorage) ListRetainedLogTypes() ([]LogType, error) {
+	return scanAll[LogType](s.db, &dynamodb.ScanInput{
+		TableName:        aws.String(tableName("puzzle-hub-log-types")),
+		FilterExpression: aws.String("retention_days > :zero"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":zero": {N: aws.String("0")},
+		},
+	})
+}
+
+func (s *dynamoStorage) ListAllLogTypes() ([]LogType, error) {
+	return scanAll[LogType](s.db, &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-log-types")),
+	})
+}
+
+func (s *dynamoStorage) GetLogType(logTypeID string) (*LogType, error) {
+	var logType LogType
+	found, err := s.getItem(tableName("puzzle-hub-log-types"), map[string]*dynamodb.AttributeValue{
+		"id": {S: aws.String(logTypeID)},
+	}, &logType)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &logType, nil
+}
+
+func (s *dynamoStorage) PutLogType(logType *LogType) error {
+	return s.putItem(tableName("puzzle-hub-log-types"), logType)
+}
+
+func (s *dynamoStorage) SetLogTypeRetention(logTypeID string, days int) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-types")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(logTypeID)},
+		},
+		UpdateExpression: aws.String("SET retention_days = :days, updated_at = :updated_at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":days":       {N: aws.String(strconv.Itoa(days))},
+			":updated_at": {S: aws.String(time.Now().Format(time.RFC3339Nano))},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) ListLogFields(logTypeID string) ([]LogField, error) {
+	fields, err := queryAll[LogField](s.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-log-fields")),
+		IndexName:              aws.String("log-type-id-index"),
+		KeyConditionExpression: aws.String("log_type_id = :log_type_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":log_type_id": {S: aws.String(logTypeID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortLogFields(fields)
+	return fields, nil
+}
+
+func (s *dynamoStorage) PutLogField(field *LogField) error {
+	return s.putItem(tableName("puzzle-hub-log-fields"), field)
+}
+
+func (s *dynamoStorage) SetLogFieldOrder(fieldID string, order int) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-fields")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(fieldID)},
+		},
+		UpdateExpression: aws.String("SET display_order = :display_order"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":display_order": {N: aws.String(strconv.Itoa(order))},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) GetLogEntry(entryID string) (*LogEntry, error) {
+	var entry LogEntry
+	found, err := s.getItem(tableName("puzzle-hub-log-entries"), map[string]*dynamodb.AttributeValue{
+		"id": {S: aws.String(entryID)},
+	}, &entry)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *dynamoStorage) ListLogEntries(query LogEntryQuery) ([]LogEntry, error) {
+	keyCondition := "user_id = :user_id"
+	values := map[string]*dynamodb.AttributeValue{
+		":user_id": {S: aws.String(query.UserID)},
+	}
+	switch {
+	case query.Before != "":
+		keyCondition += " AND entry_date < :before"
+		values[":before"] = &dynamodb.AttributeValue{S: aws.String(query.Before)}
+	case query.From != "" && query.To != "":
+		keyCondition += " AND entry_date BETWEEN :from AND :to"
+		values[":from"] = &dynamodb.AttributeValue{S: aws.String(query.From)}
+		values[":to"] = &dynamodb.AttributeValue{S: aws.String(query.To)}
+	case query.From != "":
+		keyCondition += " AND entry_date >= :from"
+		values[":from"] = &dynamodb.AttributeValue{S: aws.String(query.From)}
+	case query.To != "":
+		keyCondition += " AND entry_date <= :to"
+		values[":to"] = &dynamodb.AttributeValue{S: aws.String(query.To)}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(tableName("puzzle-hub-log-entries")),
+		IndexName:                 aws.String("user-date-index"),
+		KeyConditionExpression:    aws.String(keyCondition),
+		ExpressionAttributeValues: values,
+	}
+	if query.LogTypeID != "" {
+		input.FilterExpression = aws.String("log_type_id = :log_type_id")
+		values[":log_type_id"] = &dynamodb.AttributeValue{S: aws.String(query.LogTypeID)}
+	}
+	return queryAll[LogEntry](s.db, input)
+}
+
+func (s *dynamoStorage) PutLogEntry(entry *LogEntry) (*LogEntry, error) {
+	item, err := dynamodbattribute.MarshalMap(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal log entry: %v", err)
+	}
+	result, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName:    aws.String(tableName("puzzle-hub-log-entries")),
+		Item:         item,
+		ReturnValues: aws.String("ALL_OLD"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Attributes) == 0 {
+		return nil, nil
+	}
+	var previous LogEntry
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &previous); err != nil {
+		log.Printf("Error unmarshaling replaced log entry %s: %v", entry.ID, err)
+		return nil, nil
+	}
+	return &previous, nil
+}
+
+func (s *dynamoStorage) CreateLogEntry(entry *LogEntry) error {
+	return s.putLogEntryIf(entry, "attribute_not_exists(id)", nil)
+}
+
+func (s *dynamoStorage) PutLogEntryIfVersion(entry *LogEntry, version int64) error {
+	// Entries from before offline sync have no version attribute
+	return s.putLogEntryIf(entry, "attribute_not_exists(version) OR version = :version",
+		map[string]*dynamodb.AttributeValue{":version": {N: aws.String(strconv.FormatInt(version, 10))}})
+}
+
+func (s *dynamoStorage) putLogEntryIf(entry *LogEntry, condition string, values map[string]*dynamodb.AttributeValue) error {
+	item, err := dynamodbattribute.MarshalMap(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log entry: %v", err)
+	}
+	_, err = s.db.PutItem(&dynamodb.PutItemInput{
+		TableName:                 aws.String(tableName("puzzle-hub-log-entries")),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: values,
+	})
+	if isConditionalCheckFailed(err) {
+		return errStorageConflict
+	}
+	return err
+}
+
+func (s *dynamoStorage) DeleteLogEntry(entryID string) error {
+	_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-entries")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(entryID)},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) DeleteLogEntryIfVersion(entryID string, version int64) error {
+	_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:                 aws.String(tableName("puzzle-hub-log-entries")),
+		Key:                       map[string]*dynamodb.AttributeValue{"id": {S: aws.String(entryID)}},
+		ConditionExpression:       aws.String("attribute_not_exists(version) OR version = :version"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":version": {N: aws.String(strconv.FormatInt(version, 10))}},
+	})
+	if isConditionalCheckFailed(err) {
+		return errStorageConflict
+	}
+	return err
+}
+
+// syncHeadSeq keys the item holding a user's last sequence number in the
+// sync changes table; it sorts before every real sequence
+const syncHeadSeq = "#head"
+
+func (s *dynamoStorage) PutSyncChange(change *SyncChange) error {
+	table := tableName("puzzle-hub-sync-changes")
+	headKey := map[string]*dynamodb.AttributeValue{
+		"user_id": {S: aws.String(change.UserID)},
+		"seq":     {S: aws.String(syncHeadSeq)},
+	}
+
+	// The head only advances together with the change it numbers, so
+	// writers racing for the same number retry instead of leaving a gap
+	for attempt := 0; attempt < 5; attempt++ {
+		result, err := s.db.GetItem(&dynamodb.GetItemInput{
+			TableName:      aws.String(table),
+			Key:            headKey,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		var last int64
+		if value, ok := result.Item["last_seq"]; ok && value.N != nil {
+			last, _ = strconv.ParseInt(*value.N, 10, 64)
+		}
+
+		change.Seq = syncSeq(last + 1)
+		item, err := dynamodbattribute.MarshalMap(change)
+		if err != nil {
+			return fmt.Errorf("failed to marshal sync change: %v", err)
+		}
+
+		_, err = s.db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{Update: &dynamodb.Update{
+					TableName:           aws.String(table),
+					Key:                 headKey,
+					UpdateExpression:    aws.String("SET last_seq = :next"),
+					ConditionExpression: aws.String("attribute_not_exists(last_seq) OR last_seq = :last"),
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":next": {N: aws.String(strconv.FormatInt(last+1, 10))},
+						":last": {N: aws.String(strconv.FormatInt(last, 10))},
+					},
+				}},
+				{Put: &dynamodb.Put{
+					TableName:           aws.String(table),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(seq)"),
+				}},
+			},
+		})
+		var canceled *dynamodb.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			continue
+		}
+		return err
+	}
+	return errStorageConflict
+}
+
+func (s *dynamoStorage) ListSyncChanges(userID, since string, limit int) ([]SyncChange, bool, error) {
+	if since < syncHeadSeq {
+		since = syncHeadSeq
+	}
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-sync-changes")),
+		KeyConditionExpression: aws.String("user_id = :user_id AND seq > :since"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+			":since":   {S: aws.String(since)},
+		},
+		Limit: aws.Int64(int64(limit)),
+	}
+
+	result, err := s.db.Query(input)
+	if err != nil {
+		return nil, false, err
+	}
+	changes := []SyncChange{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &changes); err != nil {
+		return nil, false, err
+	}
+	return changes, len(result.LastEvaluatedKey) > 0, nil
+}
+
+func (s *dynamoStorage) ListLogArchives(logTypeID string) ([]LogArchive, error) {
+	return queryAll[LogArchive](s.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-log-archives")),
+		KeyConditionExpression: aws.String("log_type_id = :log_type_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":log_type_id": {S: aws.String(logTypeID)},
+		},
+	})
+}
+
+func (s *dynamoStorage) GetLogArchive(logTypeID, period string) (*LogArchive, error) {
+	var archive LogArchive
+	found, err := s.getItem(tableName("puzzle-hub-log-archives"), map[string]*dynamodb.AttributeValue{
+		"log_type_id": {S: aws.String(logTypeID)},
+		"period":      {S: aws.String(period)},
+	}, &archive)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+func (s *dynamoStorage) PutLogArchive(archive *LogArchive) error {
+	return s.putItem(tableName("puzzle-hub-log-archives"), archive)
+}
+
+// Log aggregates, one item per bucket with "count", "sum:<field>" and
+// "num:<field>" attributes that are only ever ADDed to
+
+func (s *dynamoStorage) AddLogAggregates(logTypeID string, buckets []string, delta LogAggregate) error {
+	names := map[string]*string{"#count": aws.String(aggregateCountAttr)}
+	values := map[string]*dynamodb.AttributeValue{
+		":count": {N: aws.String(strconv.Itoa(delta.Count))},
+	}
+	additions := []string{"#count :count"}
+
+	i := 0
+	for fieldName, sum := range delta.Sums {
+		sumName, numName := fmt.Sprintf("#s%d", i), fmt.Sprintf("#n%d", i)
+		sumValue, numValue := fmt.Sprintf(":s%d", i), fmt.Sprintf(":n%d", i)
+		names[sumName] = aws.String(aggregateSumPrefix + fieldName)
+		names[numName] = aws.String(aggregateNumPrefix + fieldName)
+		values[sumValue] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(sum, 'f', -1, 64))}
+		values[numValue] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(delta.Counts[fieldName]))}
+		additions = append(additions, sumName+" "+sumValue, numName+" "+numValue)
+		i++
+	}
+
+	var errs []error
+	for _, bucket := range buckets {
+		_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName("puzzle-hub-log-aggregates")),
+			Key: map[string]*dynamodb.AttributeValue{
+				"log_type_id": {S: aws.String(logTypeID)},
+				"bucket":      {S: aws.String(bucket)},
+			},
+			UpdateExpression:          aws.String("ADD " + strings.Join(additions, ", ")),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bucket %s: %w", bucket, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *dynamoStorage) InitializeLogAggregates(logTypeID string) error {
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-log-aggregates")),
+		Item: map[string]*dynamodb.AttributeValue{
+			"log_type_id":            {S: aws.String(logTypeID)},
+			"bucket":                 {S: aws.String(aggregateTotalBucket)},
+			aggregateCountAttr:       {N: aws.String("0")},
+			aggregateInitializedAttr: {BOOL: aws.Bool(true)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(log_type_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *dynamoStorage) ListLogAggregates(logTypeID, fromBucket string) (map[string]LogAggregate, bool, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-log-aggregates")),
+		KeyConditionExpression: aws.String("log_type_id = :log_type_id AND bucket >= :from"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":log_type_id": {S: aws.String(logTypeID)},
+			":from":        {S: aws.String(fromBucket)},
+		},
+	}
+
+	aggregates := make(map[string]LogAggregate)
+	initialized := false
+	err := s.db.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			bucket := item["bucket"]
+			if bucket == nil || bucket.S == nil {
+				continue
+			}
+			aggregates[*bucket.S] = decodeLogAggregate(*bucket.S, item)
+			if *bucket.S == aggregateTotalBucket && item[aggregateInitializedAttr] != nil {
+				initialized = true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return aggregates, initialized, nil
+}
+
+func (s *dynamoStorage) ReplaceLogAggregates(logTypeID string, buckets map[string]*LogAggregate) error {
+	// Start from a clean slate so stale buckets do not linger
+	existing, _, err := s.ListLogAggregates(logTypeID, "")
+	if err != nil {
+		return fmt.Errorf("failed to read aggregates: %v", err)
+	}
+	for bucket := range existing {
+		_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(tableName("puzzle-hub-log-aggregates")),
+			Key: map[string]*dynamodb.AttributeValue{
+				"log_type_id": {S: aws.String(logTypeID)},
+				"bucket":      {S: aws.String(bucket)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to clear aggregate %s: %v", bucket, err)
+		}
+	}
+
+	// Write the total bucket last so a failed rebuild is retried next read
+	var bucketNames []string
+	for bucket := range buckets {
+		if bucket != aggregateTotalBucket {
+			bucketNames = append(bucketNames, bucket)
+		}
+	}
+	bucketNames = append(bucketNames, aggregateTotalBucket)
+
+	for _, bucket := range bucketNames {
+		agg := buckets[bucket]
+		if agg == nil {
+			agg = &LogAggregate{Bucket: bucket}
+		}
+		item := encodeLogAggregate(logTypeID, agg)
+		if bucket == aggregateTotalBucket {
+			item[aggregateInitializedAttr] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+		}
+
+		_, err := s.db.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(tableName("puzzle-hub-log-aggregates")),
+			Item:      item,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write aggregate %s: %v", bucket, err)
+		}
+	}
+	return nil
+}
+
+func encodeLogAggregate(logTypeID string, agg *LogAggregate) map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		"log_type_id":      {S: aws.String(logTypeID)},
+		"bucket":           {S: aws.String(agg.Bucket)},
+		aggregateCountAttr: {N: aws.String(strconv.Itoa(agg.Count))},
+	}
+	for fieldName, sum := range agg.Sums {
+		item[aggregateSumPrefix+fieldName] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(sum, 'f', -1, 64))}
+		item[aggregateNumPrefix+fieldName] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(agg.Counts[fieldName]))}
+	}
+	return item
+}
+
+func decodeLogAggregate(bucket string, item map[string]*dynamodb.AttributeValue) LogAggregate {
+	agg := LogAggregate{Bucket: bucket, Sums: map[string]float64{}, Counts: map[string]int{}}
+	for name, value := range item {
+		if value == nil || value.N == nil {
+			continue
+		}
+		if number, err := strconv.ParseFloat(*value.N, 64); err == nil {
+			addAggregateValue(&agg, name, number)
+		}
+	}
+	return agg
+}
+
+// Analytics
+
+func (s *dynamoStorage) PutAnalyticsEvents(events []AnalyticsEvent) error {
+	requests := make([]*dynamodb.WriteRequest, 0, len(events))
+	for i := range events {
+		item, err := dynamodbattribute.MarshalMap(&events[i])
+		if err != nil {
+			log.Printf("Warning: Failed to marshal analytics event: %v", err)
+			continue
+		}
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{Item: item},
+		})
+	}
+	return s.batchWrite(tableName("puzzle-hub-analytics"), requests)
+}
+
+func (s *dynamoStorage) batchWrite(table string, requests []*dynamodb.WriteRequest) error {
+	return batchWriteItems(s.db, table, requests)
+}
+
+func (s *dynamoStorage) ListAnalyticsEvents() ([]AnalyticsEvent, error) {
+	return scanAll[AnalyticsEvent](s.db, &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-analytics")),
+	})
+}
+
+func (s *dynamoStorage) MarkAnalyticsSeen(key string, firstSeen time.Time) (bool, error) {
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-analytics-seen")),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":        {S: aws.String(key)},
+			"first_seen": {S: aws.String(firstSeen.Format(time.RFC3339Nano))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key": aws.String("key"),
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *dynamoStorage) AddAnalyticsRollup(rollup *AnalyticsRollup) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-analytics-rollups")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"day": {S: aws.String(rollup.Day)},
+		},
+		UpdateExpression: aws.String("ADD visits :visits, logins :logins, new_visitors :new_visitors, new_users :new_users"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":visits":       {N: aws.String(strconv.FormatInt(rollup.Visits, 10))},
+			":logins":       {N: aws.String(strconv.FormatInt(rollup.Logins, 10))},
+			":new_visitors": {N: aws.String(strconv.FormatInt(rollup.NewVisitors, 10))},
+			":new_users":    {N: aws.String(strconv.FormatInt(rollup.NewUsers, 10))},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) ListAnalyticsRollups() ([]AnalyticsRollup, error) {
+	return scanAll[AnalyticsRollup](s.db, &dynamodb.ScanInput{
+		TableName: aws.String(tableName("puzzle-hub-analytics-rollups")),
+	})
+}
+
+// AI quotas
+
+func (s *dynamoStorage) AddAIQuotaUsage(subject, day, feature string, n, limit int) (int, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-quota-usage")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subject": {S: aws.String(subject)},
+			"day":     {S: aws.String(day)},
+		},
+		UpdateExpression:         aws.String("ADD #feature :n SET expires_at = :expires_at"),
+		ExpressionAttributeNames: map[string]*string{"#feature": aws.String(feature)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":n":          {N: aws.String(strconv.Itoa(n))},
+			":expires_at": {N: aws.String(strconv.FormatInt(time.Now().Add(aiQuotaRetention).Unix(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+	}
+	if n > 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(#feature) OR #feature <= :max_before")
+		input.ExpressionAttributeValues[":max_before"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(limit - n))}
+	}
+
+	result, err := s.db.UpdateItem(input)
+	if isConditionalCheckFailed(err) {
+		return 0, errStorageConflict
+	}
+	if err != nil {
+		return 0, err
+	}
+	used := 0
+	if value, ok := result.Attributes[feature]; ok && value.N != nil {
+		used, _ = strconv.Atoi(*value.N)
+	}
+	return used, nil
+}
+
+func (s *dynamoStorage) GetAIQuotaUsage(subject, day string) (map[string]int, error) {
+	result, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-ai-quota-usage")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subject": {S: aws.String(subject)},
+			"day":     {S: aws.String(day)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]int{}
+	for name, value := range result.Item {
+		if name == "expires_at" || value.N == nil {
+			continue
+		}
+		used[name], _ = strconv.Atoi(*value.N)
+	}
+	return used, nil
+}
+
+// Jobs
+
+func (s *dynamoStorage) ClaimJobRun(job, period string, expiresAt int64) (bool, error) {
+	item := map[string]*dynamodb.AttributeValue{
+		"job":        {S: aws.String(job)},
+		"period":     {S: aws.String(period)},
+		"claimed_at": {S: aws.String(time.Now().Format(time.RFC3339))},
+	}
+	if expiresAt > 0 {
+		item["expires_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expiresAt, 10))}
+	}
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(tableName("puzzle-hub-job-runs")),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(job)"),
+	})
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *dynamoStorage) ReleaseJobRun(job, period string) error {
+	_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-job-runs")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"job":    {S: aws.String(job)},
+			"period": {S: aws.String(period)},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) PutJob(job *Job) error {
+	return s.putItem(tableName("puzzle-hub-jobs"), job)
+}
+
+func (s *dynamoStorage) ListVisibleJobs(now int64, limit int) ([]Job, error) {
+	return queryLimit[Job](context.Background(), s.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-jobs")),
+		IndexName:              aws.String("state-visible-index"),
+		KeyConditionExpression: aws.String("#state = :queued AND visible_at <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#state": aws.String("state"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":queued": {S: aws.String(jobStateQueued)},
+			":now":    {N: aws.String(strconv.FormatInt(now, 10))},
+		},
+	}, limit)
+}
+
+func (s *dynamoStorage) LeaseJob(jobID string, seen, until int64) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-jobs")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"job_id": {S: aws.String(jobID)},
+		},
+		UpdateExpression:    aws.String("SET visible_at = :lease, attempts = attempts + :one"),
+		ConditionExpression: aws.String("#state = :queued AND visible_at = :seen"),
+		ExpressionAttributeNames: map[string]*string{
+			"#state": aws.String("state"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lease":  {N: aws.String(strconv.FormatInt(until, 10))},
+			":one":    {N: aws.String("1")},
+			":queued": {S: aws.String(jobStateQueued)},
+			":seen":   {N: aws.String(strconv.FormatInt(seen, 10))},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return errStorageConflict
+	}
+	return err
+}
+
+func (s *dynamoStorage) RetryJob(jobID, lastError string, visibleAt int64) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-jobs")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"job_id": {S: aws.String(jobID)},
+		},
+		UpdateExpression: aws.String("SET visible_at = :retry, last_error = :error"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":retry": {N: aws.String(strconv.FormatInt(visibleAt, 10))},
+			":error": {S: aws.String(lastError)},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) FailJob(jobID, lastError string, expiresAt int64) error {
+	_, err := s.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-jobs")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"job_id": {S: aws.String(jobID)},
+		},
+		UpdateExpression: aws.String("SET #state = :failed, last_error = :error, expires_at = :expires"),
+		ExpressionAttributeNames: map[string]*string{
+			"#state": aws.String("state"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":failed":  {S: aws.String(jobStateFailed)},
+			":error":   {S: aws.String(lastError)},
+			":expires": {N: aws.String(strconv.FormatInt(expiresAt, 10))},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) DeleteJob(jobID string) error {
+	_, err := s.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-jobs")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"job_id": {S: aws.String(jobID)},
+		},
+	})
+	return err
+}
+
+func (s *dynamoStorage) ListFailedJobs(limit int) ([]Job, error) {
+	return queryLimit[Job](context.Background(), s.db, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-jobs")),
+		IndexName:              aws.String("state-visible-index"),
+		KeyConditionExpression: aws.String("#state = :failed"),
+		ExpressionAttributeNames: map[string]*string{
+			"#state": aws.String("state"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":failed": {S: aws.String(jobStateFailed)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, limit)
+}
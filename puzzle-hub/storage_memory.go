@@ -0,0 +1,876 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStorage keeps records in maps for the life of the process. It
+// backs demo mode (see demo_mode.go) and needs no database, but everything
+// is gone on restart.
+type memoryStorage struct {
+	mu               sync.Mutex
+	userRoles        map[string]UserRoleAssignment
+	userPreferences  map[string]UserPreferences
+	loginSessions    map[string]LoginSession // by user ID and session ID
+	refreshTokens    map[string]RefreshToken
+	revokedTokens    map[string]int64 // jti to expiry
+	feedback         map[string]Feedback
+	feedbackVotes    map[string]map[string]bool // feedback ID to user IDs
+	logTypes         map[string]LogType
+	logFields        map[string]LogField
+	logEntries       map[string]LogEntry
+	syncChanges      map[string][]SyncChange // by user ID, in sequence order
+	syncHeads        map[string]int64        // last sequence number by user ID
+	logArchives      map[string]LogArchive   // by log type ID and period
+	logAggregates    map[string]map[string]*LogAggregate
+	aggregatesInit   map[string]bool // Log types whose aggregates are complete
+	analyticsEvents  map[string]AnalyticsEvent
+	analyticsSeen    map[string]time.Time
+	analyticsRollups map[string]AnalyticsRollup
+	aiQuotaUsage     map[string]map[string]int // by subject and day, then feature
+	jobRuns          map[string]int64          // by job and period, to expiry
+	jobs             map[string]Job
+}
+
+func newMemoryStorage() *memoryStorage {
+	log.Println("🗃️  Using in-memory storage (nothing is kept after a restart)")
+	return &memoryStorage{
+		userRoles:        make(map[string]UserRoleAssignment),
+		userPreferences:  make(map[string]UserPreferences),
+		loginSessions:    make(map[string]LoginSession),
+		refreshTokens:    make(map[string]RefreshToken),
+		revokedTokens:    make(map[string]int64),
+		feedback:         make(map[string]Feedback),
+		feedbackVotes:    make(map[string]map[string]bool),
+		logTypes:         make(map[string]LogType),
+		logFields:        make(map[string]LogField),
+		logEntries:       make(map[string]LogEntry),
+		syncChanges:      make(map[string][]SyncChange),
+		syncHeads:        make(map[string]int64),
+		logArchives:      make(map[string]LogArchive),
+		logAggregates:    make(map[string]map[string]*LogAggregate),
+		aggregatesInit:   make(map[string]bool),
+		analyticsEvents:  make(map[string]AnalyticsEvent),
+		analyticsSeen:    make(map[string]time.Time),
+		analyticsRollups: make(map[string]AnalyticsRollup),
+		aiQuotaUsage:     make(map[string]map[string]int),
+		jobRuns:          make(map[string]int64),
+		jobs:             make(map[string]Job),
+	}
+}
+
+func (s *memoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Users
+
+func (s *memoryStorage) GetUserRole(userID string) (*UserRoleAssignment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assignment, ok := s.userRoles[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &assignment, nil
+}
+
+func (s *memoryStorage) PutUserRole(assignment *UserRoleAssignment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userRoles[assignment.UserID] = *assignment
+	return nil
+}
+
+func (s *memoryStorage) ListUserRoles() ([]UserRoleAssignment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assignments := make([]UserRoleAssignment, 0, len(s.userRoles))
+	for _, assignment := range s.userRoles {
+		assignments = append(assignments, assignment)
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].UserID < assignments[j].UserID })
+	return assignments, nil
+}
+
+func (s *memoryStorage) GetUserPreferences(userID string, prefs *UserPreferences) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, ok := s.userPreferences[userID]
+	if ok {
+		*prefs = saved
+	}
+	prefs.UserID = userID
+	return ok, nil
+}
+
+func (s *memoryStorage) PutUserPreferences(prefs *UserPreferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userPreferences[prefs.UserID] = *prefs
+	return nil
+}
+
+// Sessions and tokens
+
+func (s *memoryStorage) PutLoginSession(session *LoginSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loginSessions[session.UserID+"/"+session.SessionID] = *session
+	return nil
+}
+
+func (s *memoryStorage) GetLoginSession(userID, sessionID string) (*LoginSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.loginSessions[userID+"/"+sessionID]
+	if !ok {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *memoryStorage) ListLoginSessions(userID string) ([]LoginSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := []LoginSession{}
+	for _, session := range s.loginSessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *memoryStorage) TouchLoginSession(userID, sessionID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := userID + "/" + sessionID
+	if session, ok := s.loginSessions[key]; ok {
+		session.LastUsedAt = at
+		s.loginSessions[key] = session
+	}
+	return nil
+}
+
+func (s *memoryStorage) EndLoginSession(userID, sessionID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := userID + "/" + sessionID
+	if session, ok := s.loginSessions[key]; ok && session.RevokedAt == nil {
+		session.RevokedAt = &at
+		s.loginSessions[key] = session
+	}
+	return nil
+}
+
+func (s *memoryStorage) PutRefreshToken(token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshTokens[token.TokenHash] = *token
+
+	// There's no TTL, so expired tokens are removed as new ones arrive
+	now := time.Now().Unix()
+	for hash, stored := range s.refreshTokens {
+		if stored.ExpiresAt < now {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+func (s *memoryStorage) RotateRefreshToken(tokenHash, replacedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok || token.Revoked {
+		return errStorageConflict
+	}
+	token.Revoked = true
+	token.ReplacedBy = replacedBy
+	s.refreshTokens[tokenHash] = token
+	return nil
+}
+
+func (s *memoryStorage) RevokeRefreshFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, token := range s.refreshTokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			s.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) RevokeAccessToken(jti string, expiresAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedTokens[jti] = expiresAt
+
+	now := time.Now().Unix()
+	for id, expiry := range s.revokedTokens {
+		if expiry < now {
+			delete(s.revokedTokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) IsAccessTokenRevoked(jtis []string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, jti := range jtis {
+		if _, ok := s.revokedTokens[jti]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Feedback
+
+func (s *memoryStorage) PutFeedback(feedback *Feedback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feedback[feedback.ID] = *feedback
+	return nil
+}
+
+func (s *memoryStorage) GetFeedback(feedbackID string) (*Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feedback, ok := s.feedback[feedbackID]
+	if !ok {
+		return nil, nil
+	}
+	return &feedback, nil
+}
+
+func (s *memoryStorage) ListFeedback(filter FeedbackFilter) ([]Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matches := []Feedback{}
+	for _, feedback := range s.feedback {
+		if (filter.UserID != "" && feedback.UserID != filter.UserID) ||
+			(filter.Type != "" && string(feedback.Type) != filter.Type) ||
+			(filter.Status != "" && feedback.Status != filter.Status) ||
+			(filter.AppName != "" && feedback.AppName != filter.AppName) ||
+			(filter.PublicOnly && !feedback.Public) {
+			continue
+		}
+		matches = append(matches, feedback)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].CreatedAt.After(matches[j].CreatedAt) })
+	return matches, nil
+}
+
+func (s *memoryStorage) UpdateFeedback(feedbackID string, update FeedbackUpdate) (*Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feedback, ok := s.feedback[feedbackID]
+	if !ok {
+		return nil, errStorageNotFound
+	}
+
+	if update.Status != nil {
+		feedback.Status = *update.Status
+	}
+	if update.AssignedTo != nil {
+		feedback.AssignedTo = *update.AssignedTo
+	}
+	if update.Public != nil {
+		feedback.Public = *update.Public
+	}
+	if update.AddNote != nil {
+		// Copy so the stored item and the caller's don't share notes
+		feedback.InternalNotes = append(slices.Clone(feedback.InternalNotes), *update.AddNote)
+	}
+	feedback.UpdatedAt = time.Now()
+	s.feedback[feedbackID] = feedback
+	return &feedback, nil
+}
+
+func (s *memoryStorage) ChangeFeedbackVote(feedbackID, userID string, vote bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.feedbackVotes[feedbackID][userID] == vote {
+		return errStorageConflict
+	}
+	feedback, ok := s.feedback[feedbackID]
+	if !ok || !feedback.Public {
+		return errStorageNotFound
+	}
+
+	if vote {
+		if s.feedbackVotes[feedbackID] == nil {
+			s.feedbackVotes[feedbackID] = make(map[string]bool)
+		}
+		s.feedbackVotes[feedbackID][userID] = true
+		feedback.VoteCount++
+	} else {
+		delete(s.feedbackVotes[feedbackID], userID)
+		feedback.VoteCount--
+	}
+	s.feedback[feedbackID] = feedback
+	return nil
+}
+
+func (s *memoryStorage) GetFeedbackVotes(userID string, feedbackIDs []string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	voted := make(map[string]bool)
+	for _, feedbackID := range feedbackIDs {
+		if s.feedbackVotes[feedbackID][userID] {
+			voted[feedbackID] = true
+		}
+	}
+	return voted, nil
+}
+
+// Logs
+
+func (s *memoryStorage) ListLogTypes(userID string) ([]LogType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logTypes := []LogType{}
+	for _, logType := range s.logTypes {
+		if logType.UserID == userID {
+			logTypes = append(logTypes, logType)
+		}
+	}
+	sort.Slice(logTypes, func(i, j int) bool { return logTypes[i].ID < logTypes[j].ID })
+	return logTypes, nil
+}
+
+func (s *memoryStorage) ListRetainedLogTypes() ([]LogType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logTypes := []LogType{}
+	for _, logType := range s.logTypes {
+		if logType.RetentionDays > 0 {
+			logTypes = append(logTypes, logType)
+		}
+	}
+	return logTypes, nil
+}
+
+func (s *memoryStorage) ListAllLogTypes() ([]LogType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logTypes := []LogType{}
+	for _, logType := range s.logTypes {
+		logTypes = append(logTypes, logType)
+	}
+	return logTypes, nil
+}
+
+func (s *memoryStorage) GetLogType(logTypeID string) (*LogType, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logType, ok := s.logTypes[logTypeID]
+	if !ok {
+		return nil, nil
+	}
+	return &logType, nil
+}
+
+func (s *memoryStorage) PutLogType(logType *LogType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logTypes[logType.ID] = *logType
+	return nil
+}
+
+func (s *memoryStorage) SetLogTypeRetention(logTypeID string, days int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logType, ok := s.logTypes[logTypeID]
+	if !ok {
+		return nil
+	}
+	logType.RetentionDays = days
+	logType.UpdatedAt = time.Now()
+	s.logTypes[logTypeID] = logType
+	return nil
+}
+
+func (s *memoryStorage) ListLogFields(logTypeID string) ([]LogField, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields := []LogField{}
+	for _, field := range s.logFields {
+		if field.LogTypeID == logTypeID {
+			fields = append(fields, field)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].DisplayOrder != fields[j].DisplayOrder {
+			return fields[i].DisplayOrder < fields[j].DisplayOrder
+		}
+		return fields[i].ID < fields[j].ID
+	})
+	return fields, nil
+}
+
+func (s *memoryStorage) PutLogField(field *LogField) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logFields[field.ID] = *field
+	return nil
+}
+
+func (s *memoryStorage) SetLogFieldOrder(fieldID string, order int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	field, ok := s.logFields[fieldID]
+	if !ok {
+		return nil
+	}
+	field.DisplayOrder = order
+	s.logFields[fieldID] = field
+	return nil
+}
+
+func (s *memoryStorage) GetLogEntry(entryID string) (*LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.logEntries[entryID]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (s *memoryStorage) ListLogEntries(query LogEntryQuery) ([]LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := []LogEntry{}
+	for _, entry := range s.logEntries {
+		if entry.UserID != query.UserID ||
+			(query.LogTypeID != "" && entry.LogTypeID != query.LogTypeID) ||
+			(query.From != "" && entry.EntryDate < query.From) ||
+			(query.To != "" && entry.EntryDate > query.To) ||
+			(query.Before != "" && entry.EntryDate >= query.Before) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].EntryDate != entries[j].EntryDate {
+			return entries[i].EntryDate < entries[j].EntryDate
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+func (s *memoryStorage) PutLogEntry(entry *LogEntry) (*LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var previous *LogEntry
+	if existing, ok := s.logEntries[entry.ID]; ok {
+		previous = &existing
+	}
+	s.logEntries[entry.ID] = *entry
+	return previous, nil
+}
+
+func (s *memoryStorage) CreateLogEntry(entry *LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.logEntries[entry.ID]; ok {
+		return errStorageConflict
+	}
+	s.logEntries[entry.ID] = *entry
+	return nil
+}
+
+func (s *memoryStorage) PutLogEntryIfVersion(entry *LogEntry, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.logEntries[entry.ID]; ok && existing.Version != version {
+		return errStorageConflict
+	}
+	s.logEntries[entry.ID] = *entry
+	return nil
+}
+
+func (s *memoryStorage) DeleteLogEntry(entryID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.logEntries, entryID)
+	return nil
+}
+
+func (s *memoryStorage) DeleteLogEntryIfVersion(entryID string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.logEntries[entryID]; ok {
+		if existing.Version != version {
+			return errStorageConflict
+		}
+		delete(s.logEntries, entryID)
+	}
+	return nil
+}
+
+func (s *memoryStorage) PutSyncChange(change *SyncChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncHeads[change.UserID]++
+	change.Seq = syncSeq(s.syncHeads[change.UserID])
+	s.syncChanges[change.UserID] = append(s.syncChanges[change.UserID], *change)
+	return nil
+}
+
+func (s *memoryStorage) ListSyncChanges(userID, since string, limit int) ([]SyncChange, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changes := []SyncChange{}
+	for _, change := range s.syncChanges[userID] {
+		if change.Seq <= since {
+			continue
+		}
+		if len(changes) == limit {
+			return changes, true, nil
+		}
+		changes = append(changes, change)
+	}
+	return changes, false, nil
+}
+
+func (s *memoryStorage) ListLogArchives(logTypeID string) ([]LogArchive, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	archives := []LogArchive{}
+	for _, archive := range s.logArchives {
+		if archive.LogTypeID == logTypeID {
+			archives = append(archives, archive)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Period < archives[j].Period })
+	return archives, nil
+}
+
+func (s *memoryStorage) GetLogArchive(logTypeID, period string) (*LogArchive, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	archive, ok := s.logArchives[logTypeID+"/"+period]
+	if !ok {
+		return nil, nil
+	}
+	return &archive, nil
+}
+
+func (s *memoryStorage) PutLogArchive(archive *LogArchive) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logArchives[archive.LogTypeID+"/"+archive.Period] = *archive
+	return nil
+}
+
+// Log aggregates
+
+func (s *memoryStorage) AddLogAggregates(logTypeID string, buckets []string, delta LogAggregate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := s.logAggregates[logTypeID]
+	if stored == nil {
+		stored = make(map[string]*LogAggregate)
+		s.logAggregates[logTypeID] = stored
+	}
+	for _, bucket := range buckets {
+		agg, ok := stored[bucket]
+		if !ok {
+			agg = &LogAggregate{Bucket: bucket, Sums: map[string]float64{}, Counts: map[string]int{}}
+			stored[bucket] = agg
+		}
+		agg.Count += delta.Count
+		for fieldName, sum := range delta.Sums {
+			agg.Sums[fieldName] += sum
+			agg.Counts[fieldName] += delta.Counts[fieldName]
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) InitializeLogAggregates(logTypeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.logAggregates[logTypeID][aggregateTotalBucket]; ok {
+		return nil
+	}
+	s.logAggregates[logTypeID] = map[string]*LogAggregate{
+		aggregateTotalBucket: {Bucket: aggregateTotalBucket, Sums: map[string]float64{}, Counts: map[string]int{}},
+	}
+	s.aggregatesInit[logTypeID] = true
+	return nil
+}
+
+func (s *memoryStorage) ListLogAggregates(logTypeID, fromBucket string) (map[string]LogAggregate, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	aggregates := make(map[string]LogAggregate)
+	for bucket, agg := range s.logAggregates[logTypeID] {
+		if bucket < fromBucket {
+			continue
+		}
+		aggregates[bucket] = *copyLogAggregate(bucket, agg)
+	}
+	return aggregates, s.aggregatesInit[logTypeID], nil
+}
+
+func (s *memoryStorage) ReplaceLogAggregates(logTypeID string, buckets map[string]*LogAggregate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := make(map[string]*LogAggregate, len(buckets))
+	for bucket, agg := range buckets {
+		stored[bucket] = copyLogAggregate(bucket, agg)
+	}
+	s.logAggregates[logTypeID] = stored
+	s.aggregatesInit[logTypeID] = true
+	return nil
+}
+
+// copyLogAggregate copies agg so callers can't change the stored maps
+func copyLogAggregate(bucket string, agg *LogAggregate) *LogAggregate {
+	copied := &LogAggregate{Bucket: bucket, Count: agg.Count, Sums: map[string]float64{}, Counts: map[string]int{}}
+	for fieldName, sum := range agg.Sums {
+		copied.Sums[fieldName] = sum
+		copied.Counts[fieldName] = agg.Counts[fieldName]
+	}
+	return copied
+}
+
+// Analytics
+
+func (s *memoryStorage) PutAnalyticsEvents(events []AnalyticsEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, event := range events {
+		s.analyticsEvents[event.ID] = event
+	}
+
+	// There's no TTL, so expired events are removed as new ones arrive
+	now := time.Now().Unix()
+	for id, event := range s.analyticsEvents {
+		if event.ExpiresAt > 0 && event.ExpiresAt < now {
+			delete(s.analyticsEvents, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) ListAnalyticsEvents() ([]AnalyticsEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]AnalyticsEvent, 0, len(s.analyticsEvents))
+	for _, event := range s.analyticsEvents {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events, nil
+}
+
+func (s *memoryStorage) MarkAnalyticsSeen(key string, firstSeen time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.analyticsSeen[key]; ok {
+		return false, nil
+	}
+	s.analyticsSeen[key] = firstSeen
+	return true, nil
+}
+
+func (s *memoryStorage) AddAnalyticsRollup(rollup *AnalyticsRollup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := s.analyticsRollups[rollup.Day]
+	total.Day = rollup.Day
+	total.Visits += rollup.Visits
+	total.Logins += rollup.Logins
+	total.NewVisitors += rollup.NewVisitors
+	total.NewUsers += rollup.NewUsers
+	s.analyticsRollups[rollup.Day] = total
+	return nil
+}
+
+func (s *memoryStorage) ListAnalyticsRollups() ([]AnalyticsRollup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rollups := make([]AnalyticsRollup, 0, len(s.analyticsRollups))
+	for _, rollup := range s.analyticsRollups {
+		rollups = append(rollups, rollup)
+	}
+	sort.Slice(rollups, func(i, j int) bool { return rollups[i].Day < rollups[j].Day })
+	return rollups, nil
+}
+
+// AI quotas
+
+func (s *memoryStorage) AddAIQuotaUsage(subject, day, feature string, n, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := subject + "/" + day
+	used := s.aiQuotaUsage[key]
+	if used == nil {
+		// Only today's counts matter, so older days go as a new one starts
+		for other := range s.aiQuotaUsage {
+			if !strings.HasSuffix(other, "/"+day) {
+				delete(s.aiQuotaUsage, other)
+			}
+		}
+		used = map[string]int{}
+		s.aiQuotaUsage[key] = used
+	}
+	if n > 0 && used[feature]+n > limit {
+		return 0, errStorageConflict
+	}
+	used[feature] += n
+	return used[feature], nil
+}
+
+func (s *memoryStorage) GetAIQuotaUsage(subject, day string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used := make(map[string]int, len(s.aiQuotaUsage[subject+"/"+day]))
+	for feature, count := range s.aiQuotaUsage[subject+"/"+day] {
+		used[feature] = count
+	}
+	return used, nil
+}
+
+// Jobs
+
+func (s *memoryStorage) ClaimJobRun(job, period string, expiresAt int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	for key, expiry := range s.jobRuns {
+		if expiry > 0 && expiry < now {
+			delete(s.jobRuns, key)
+		}
+	}
+	key := job + "/" + period
+	if _, ok := s.jobRuns[key]; ok {
+		return false, nil
+	}
+	s.jobRuns[key] = expiresAt
+	return true, nil
+}
+
+func (s *memoryStorage) ReleaseJobRun(job, period string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobRuns, job+"/"+period)
+	return nil
+}
+
+func (s *memoryStorage) PutJob(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = *job
+
+	now := time.Now().Unix()
+	for id, stored := range s.jobs {
+		if stored.ExpiresAt > 0 && stored.ExpiresAt < now {
+			delete(s.jobs, id)
+		}
+	}
+	return nil
+}
+
+// listJobs returns up to limit jobs matching keep, ordered by visible_at
+func (s *memoryStorage) listJobs(keep func(job Job) bool, latestFirst bool, limit int) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := []Job{}
+	for _, job := range s.jobs {
+		if keep(job) {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if latestFirst {
+			return jobs[i].VisibleAt > jobs[j].VisibleAt
+		}
+		return jobs[i].VisibleAt < jobs[j].VisibleAt
+	})
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs
+}
+
+func (s *memoryStorage) ListVisibleJobs(now int64, limit int) ([]Job, error) {
+	return s.listJobs(func(job Job) bool {
+		return job.State == jobStateQueued && job.VisibleAt <= now
+	}, false, limit), nil
+}
+
+func (s *memoryStorage) LeaseJob(jobID string, seen, until int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[jobID]
+	if !ok || job.State != jobStateQueued || job.VisibleAt != seen {
+		return errStorageConflict
+	}
+	job.VisibleAt = until
+	job.Attempts++
+	s.jobs[jobID] = job
+	return nil
+}
+
+func (s *memoryStorage) RetryJob(jobID, lastError string, visibleAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[jobID]; ok {
+		job.VisibleAt = visibleAt
+		job.LastError = lastError
+		s.jobs[jobID] = job
+	}
+	return nil
+}
+
+func (s *memoryStorage) FailJob(jobID, lastError string, expiresAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[jobID]; ok {
+		job.State = jobStateFailed
+		job.LastError = lastError
+		job.ExpiresAt = expiresAt
+		s.jobs[jobID] = job
+	}
+	return nil
+}
+
+func (s *memoryStorage) DeleteJob(jobID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, jobID)
+	return nil
+}
+
+func (s *memoryStorage) ListFailedJobs(limit int) ([]Job, error) {
+	return s.listJobs(func(job Job) bool {
+		return job.State == jobStateFailed && (job.ExpiresAt == 0 || job.ExpiresAt >= time.Now().Unix())
+	}, true, limit), nil
+}
@@ -0,0 +1,1315 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq" // Registers the postgres driver
+)
+
+// sqlStorage keeps records in Postgres or SQLite. Each record is stored as
+// a JSON document next to the columns it is looked up or ordered by, so
+// the tables mirror the DynamoDB items and new fields need no migration.
+// The schema is created at startup.
+type sqlStorage struct {
+	db     *sql.DB
+	driver string // postgres or sqlite3
+}
+
+// sqlTimeFormat is fixed width so timestamps sort as text
+const sqlTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS user_roles (
+		user_id TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_preferences (
+		user_id TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS login_sessions (
+		user_id TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (user_id, session_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		token_hash TEXT PRIMARY KEY,
+		family_id TEXT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_family_id ON refresh_tokens (family_id)`,
+	`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS feedback (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		app_name TEXT NOT NULL,
+		public INTEGER NOT NULL,
+		created_at TEXT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS feedback_user_id ON feedback (user_id, created_at)`,
+	`CREATE TABLE IF NOT EXISTS feedback_votes (
+		feedback_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		PRIMARY KEY (feedback_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS log_types (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		retention_days INTEGER NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS log_types_user_id ON log_types (user_id)`,
+	`CREATE TABLE IF NOT EXISTS log_fields (
+		id TEXT PRIMARY KEY,
+		log_type_id TEXT NOT NULL,
+		display_order INTEGER NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS log_fields_log_type_id ON log_fields (log_type_id)`,
+	`CREATE TABLE IF NOT EXISTS log_entries (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		log_type_id TEXT NOT NULL,
+		entry_date TEXT NOT NULL,
+		version BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS log_entries_user_date ON log_entries (user_id, entry_date)`,
+	`CREATE TABLE IF NOT EXISTS sync_changes (
+		user_id TEXT NOT NULL,
+		seq TEXT NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (user_id, seq)
+	)`,
+	`CREATE TABLE IF NOT EXISTS sync_heads (
+		user_id TEXT PRIMARY KEY,
+		seq BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS log_archives (
+		log_type_id TEXT NOT NULL,
+		period TEXT NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (log_type_id, period)
+	)`,
+	`CREATE TABLE IF NOT EXISTS log_aggregates (
+		log_type_id TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value DOUBLE PRECISION NOT NULL,
+		PRIMARY KEY (log_type_id, bucket, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS analytics_events (
+		id TEXT PRIMARY KEY,
+		occurred_at TEXT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS analytics_events_expires_at ON analytics_events (expires_at)`,
+	`CREATE TABLE IF NOT EXISTS analytics_seen (
+		seen_key TEXT PRIMARY KEY,
+		first_seen TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS analytics_rollups (
+		day TEXT PRIMARY KEY,
+		visits BIGINT NOT NULL,
+		logins BIGINT NOT NULL,
+		new_visitors BIGINT NOT NULL,
+		new_users BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS ai_quota_usage (
+		subject TEXT NOT NULL,
+		day TEXT NOT NULL,
+		feature TEXT NOT NULL,
+		used INTEGER NOT NULL,
+		expires_at BIGINT NOT NULL,
+		PRIMARY KEY (subject, day, feature)
+	)`,
+	`CREATE TABLE IF NOT EXISTS job_runs (
+		job TEXT NOT NULL,
+		period TEXT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		PRIMARY KEY (job, period)
+	)`,
+	`CREATE TABLE IF NOT EXISTS jobs (
+		job_id TEXT PRIMARY KEY,
+		state TEXT NOT NULL,
+		visible_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS jobs_state_visible_at ON jobs (state, visible_at)`,
+}
+
+func openSQLStorage(driver, dsn string) (*sqlStorage, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		if driver == "sqlite3" {
+			return nil, fmt.Errorf("failed to open SQLite database (is the binary built with -tags sqlite?): %v", err)
+		}
+		return nil, fmt.Errorf("failed to open %s database: %v", driver, err)
+	}
+	if driver == "sqlite3" {
+		// SQLite allows one writer at a time, so share one connection
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s database: %v", driver, err)
+	}
+
+	s := &sqlStorage{db: db, driver: driver}
+	for _, statement := range sqlSchema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create schema: %v", err)
+		}
+	}
+
+	log.Printf("🗃️  Using %s storage", driver)
+	return s, nil
+}
+
+// sqlRunner is implemented by both *sql.DB and *sql.Tx
+type sqlRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// rebind turns ? placeholders into the driver's style
+func (s *sqlStorage) rebind(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// forUpdate locks the selected rows until the transaction ends; SQLite
+// transactions already hold the only connection
+func (s *sqlStorage) forUpdate() string {
+	if s.driver == "postgres" {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+func (s *sqlStorage) exec(runner sqlRunner, query string, args ...interface{}) (sql.Result, error) {
+	return runner.Exec(s.rebind(query), args...)
+}
+
+// getDocument decodes the data column of the single row the query selects
+// into out and reports whether there was one
+func (s *sqlStorage) getDocument(runner sqlRunner, out interface{}, query string, args ...interface{}) (bool, error) {
+	var data string
+	err := runner.QueryRow(s.rebind(query), args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal([]byte(data), out); err != nil {
+		return false, fmt.Errorf("failed to decode record: %v", err)
+	}
+	return true, nil
+}
+
+// listDocuments decodes the data column of every row the query selects
+func listDocuments[T any](s *sqlStorage, query string, args ...interface{}) ([]T, error) {
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []T{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var record T
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, fmt.Errorf("failed to decode record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// withTx runs fn in a transaction, committing if it returns nil
+func (s *sqlStorage) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func encodeDocument(record interface{}) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode record: %v", err)
+	}
+	return string(data), nil
+}
+
+func sqlBool(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func (s *sqlStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Users
+
+func (s *sqlStorage) GetUserRole(userID string) (*UserRoleAssignment, error) {
+	var assignment UserRoleAssignment
+	found, err := s.getDocument(s.db, &assignment, `SELECT data FROM user_roles WHERE user_id = ?`, userID)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+func (s *sqlStorage) PutUserRole(assignment *UserRoleAssignment) error {
+	data, err := encodeDocument(assignment)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(s.db, `INSERT INTO user_roles (user_id, data) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET data = excluded.data`, assignment.UserID, data)
+	return err
+}
+
+func (s *sqlStorage) ListUserRoles() ([]UserRoleAssignment, error) {
+	return listDocuments[UserRoleAssignment](s, `SELECT data FROM user_roles ORDER BY user_id`)
+}
+
+func (s *sqlStorage) GetUserPreferences(userID string, prefs *UserPreferences) (bool, error) {
+	found, err := s.getDocument(s.db, prefs, `SELECT data FROM user_preferences WHERE user_id = ?`, userID)
+	prefs.UserID = userID // Not part of the JSON document
+	return found, err
+}
+
+func (s *sqlStorage) PutUserPreferences(prefs *UserPreferences) error {
+	data, err := encodeDocument(prefs)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(s.db, `INSERT INTO user_preferences (user_id, data) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET data = excluded.data`, prefs.UserID, data)
+	return err
+}
+
+// Sessions and tokens
+
+// loginSessionDocument keeps the user ID and expiry, which LoginSession
+// leaves out of its JSON
+type loginSessionDocument struct {
+	LoginSession
+	UserID    string `json:"user_id"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (d loginSessionDocument) session() LoginSession {
+	session := d.LoginSession
+	session.UserID = d.UserID
+	session.ExpiresAt = d.ExpiresAt
+	return session
+}
+
+func (s *sqlStorage) putLoginSession(runner sqlRunner, session *LoginSession) error {
+	data, err := encodeDocument(loginSessionDocument{LoginSession: *session, UserID: session.UserID, ExpiresAt: session.ExpiresAt})
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(runner, `INSERT INTO login_sessions (user_id, session_id, expires_at, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, session_id) DO UPDATE SET expires_at = excluded.expires_at, data = excluded.data`,
+		session.UserID, session.SessionID, session.ExpiresAt, data)
+	return err
+}
+
+func (s *sqlStorage) PutLoginSession(session *LoginSession) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := s.putLoginSession(tx, session); err != nil {
+			return err
+		}
+		// There's no TTL, so expired sessions are removed as new ones arrive
+		_, err := s.exec(tx, `DELETE FROM login_sessions WHERE expires_at < ?`, time.Now().Unix())
+		return err
+	})
+}
+
+func (s *sqlStorage) getLoginSession(runner sqlRunner, userID, sessionID, suffix string) (*LoginSession, error) {
+	var document loginSessionDocument
+	found, err := s.getDocument(runner, &document, `SELECT data FROM login_sessions WHERE user_id = ? AND session_id = ?`+suffix, userID, sessionID)
+	if err != nil || !found {
+		return nil, err
+	}
+	session := document.session()
+	return &session, nil
+}
+
+func (s *sqlStorage) GetLoginSession(userID, sessionID string) (*LoginSession, error) {
+	return s.getLoginSession(s.db, userID, sessionID, "")
+}
+
+func (s *sqlStorage) ListLoginSessions(userID string) ([]LoginSession, error) {
+	documents, err := listDocuments[loginSessionDocument](s, `SELECT data FROM login_sessions WHERE user_id = ? ORDER BY session_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]LoginSession, len(documents))
+	for i, document := range documents {
+		sessions[i] = document.session()
+	}
+	return sessions, nil
+}
+
+// updateLoginSession applies change to a stored session, if there is one
+func (s *sqlStorage) updateLoginSession(userID, sessionID string, change func(session *LoginSession)) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		session, err := s.getLoginSession(tx, userID, sessionID, s.forUpdate())
+		if err != nil || session == nil {
+			return err
+		}
+		change(session)
+		return s.putLoginSession(tx, session)
+	})
+}
+
+func (s *sqlStorage) TouchLoginSession(userID, sessionID string, at time.Time) error {
+	return s.updateLoginSession(userID, sessionID, func(session *LoginSession) {
+		session.LastUsedAt = at
+	})
+}
+
+func (s *sqlStorage) EndLoginSession(userID, sessionID string, at time.Time) error {
+	return s.updateLoginSession(userID, sessionID, func(session *LoginSession) {
+		if session.RevokedAt == nil {
+			session.RevokedAt = &at
+		}
+	})
+}
+
+func (s *sqlStorage) putRefreshToken(runner sqlRunner, token *RefreshToken) error {
+	data, err := encodeDocument(token)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(runner, `INSERT INTO refresh_tokens (token_hash, family_id, expires_at, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (token_hash) DO UPDATE SET family_id = excluded.family_id, expires_at = excluded.expires_at, data = excluded.data`,
+		token.TokenHash, token.FamilyID, token.ExpiresAt, data)
+	return err
+}
+
+func (s *sqlStorage) PutRefreshToken(token *RefreshToken) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := s.putRefreshToken(tx, token); err != nil {
+			return err
+		}
+		_, err := s.exec(tx, `DELETE FROM refresh_tokens WHERE expires_at < ?`, time.Now().Unix())
+		return err
+	})
+}
+
+func (s *sqlStorage) getRefreshToken(runner sqlRunner, tokenHash, suffix string) (*RefreshToken, error) {
+	var token RefreshToken
+	found, err := s.getDocument(runner, &token, `SELECT data FROM refresh_tokens WHERE token_hash = ?`+suffix, tokenHash)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *sqlStorage) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	return s.getRefreshToken(s.db, tokenHash, "")
+}
+
+func (s *sqlStorage) RotateRefreshToken(tokenHash, replacedBy string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		token, err := s.getRefreshToken(tx, tokenHash, s.forUpdate())
+		if err != nil {
+			return err
+		}
+		if token == nil || token.Revoked {
+			return errStorageConflict
+		}
+		token.Revoked = true
+		token.ReplacedBy = replacedBy
+		return s.putRefreshToken(tx, token)
+	})
+}
+
+func (s *sqlStorage) RevokeRefreshFamily(familyID string) error {
+	tokens, err := listDocuments[RefreshToken](s, `SELECT data FROM refresh_tokens WHERE family_id = ?`, familyID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		token.Revoked = true
+		if err := s.putRefreshToken(s.db, &token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStorage) RevokeAccessToken(jti string, expiresAt int64) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		_, err := s.exec(tx, `INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)
+			ON CONFLICT (jti) DO UPDATE SET expires_at = excluded.expires_at`, jti, expiresAt)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec(tx, `DELETE FROM revoked_tokens WHERE expires_at < ?`, time.Now().Unix())
+		return err
+	})
+}
+
+func (s *sqlStorage) IsAccessTokenRevoked(jtis []string) (bool, error) {
+	if len(jtis) == 0 {
+		return false, nil
+	}
+	placeholders := make([]string, len(jtis))
+	args := make([]interface{}, len(jtis))
+	for i, jti := range jtis {
+		placeholders[i] = "?"
+		args[i] = jti
+	}
+	var count int
+	err := s.db.QueryRow(s.rebind(`SELECT COUNT(*) FROM revoked_tokens WHERE jti IN (`+strings.Join(placeholders, ", ")+`)`), args...).Scan(&count)
+	return count > 0, err
+}
+
+// Feedback
+
+func (s *sqlStorage) putFeedback(runner sqlRunner, feedback *Feedback) error {
+	data, err := encodeDocument(feedback)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(runner, `INSERT INTO feedback (id, user_id, type, status, app_name, public, created_at, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, type = excluded.type, status = excluded.status,
+			app_name = excluded.app_name, public = excluded.public, created_at = excluded.created_at, data = excluded.data`,
+		feedback.ID, feedback.UserID, string(feedback.Type), feedback.Status, feedback.AppName,
+		sqlBool(feedback.Public), feedback.CreatedAt.UTC().Format(sqlTimeFormat), data)
+	return err
+}
+
+func (s *sqlStorage) PutFeedback(feedback *Feedback) error {
+	return s.putFeedback(s.db, feedback)
+}
+
+func (s *sqlStorage) GetFeedback(feedbackID string) (*Feedback, error) {
+	var feedback Feedback
+	found, err := s.getDocument(s.db, &feedback, `SELECT data FROM feedback WHERE id = ?`, feedbackID)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &feedback, nil
+}
+
+func (s *sqlStorage) ListFeedback(filter FeedbackFilter) ([]Feedback, error) {
+	var conditions []string
+	var args []interface{}
+	for column, value := range map[string]string{"user_id": filter.UserID, "type": filter.Type, "status": filter.Status, "app_name": filter.AppName} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if filter.PublicOnly {
+		conditions = append(conditions, "public = 1")
+	}
+
+	query := `SELECT data FROM feedback`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return listDocuments[Feedback](s, query+" ORDER BY created_at DESC", args...)
+}
+
+func (s *sqlStorage) UpdateFeedback(feedbackID string, update FeedbackUpdate) (*Feedback, error) {
+	var feedback Feedback
+	err := s.withTx(func(tx *sql.Tx) error {
+		found, err := s.getDocument(tx, &feedback, `SELECT data FROM feedback WHERE id = ?`+s.forUpdate(), feedbackID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errStorageNotFound
+		}
+
+		if update.Status != nil {
+			feedback.Status = *update.Status
+		}
+		if update.AssignedTo != nil {
+			feedback.AssignedTo = *update.AssignedTo
+		}
+		if update.Public != nil {
+			feedback.Public = *update.Public
+		}
+		if update.AddNote != nil {
+			feedback.InternalNotes = append(feedback.InternalNotes, *update.AddNote)
+		}
+		feedback.UpdatedAt = time.Now()
+		return s.putFeedback(tx, &feedback)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &feedback, nil
+}
+
+func (s *sqlStorage) ChangeFeedbackVote(feedbackID, userID string, vote bool) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var feedback Feedback
+		found, err := s.getDocument(tx, &feedback, `SELECT data FROM feedback WHERE id = ?`+s.forUpdate(), feedbackID)
+		if err != nil {
+			return err
+		}
+
+		var result sql.Result
+		if vote {
+			result, err = s.exec(tx, `INSERT INTO feedback_votes (feedback_id, user_id, created_at) VALUES (?, ?, ?)
+				ON CONFLICT (feedback_id, user_id) DO NOTHING`, feedbackID, userID, time.Now().UTC().Format(sqlTimeFormat))
+		} else {
+			result, err = s.exec(tx, `DELETE FROM feedback_votes WHERE feedback_id = ? AND user_id = ?`, feedbackID, userID)
+		}
+		if err != nil {
+			return err
+		}
+		if changed, err := result.RowsAffected(); err != nil {
+			return err
+		} else if changed == 0 {
+			return errStorageConflict
+		}
+
+		if !found || !feedback.Public {
+			return errStorageNotFound
+		}
+		if vote {
+			feedback.VoteCount++
+		} else {
+			feedback.VoteCount--
+		}
+		return s.putFeedback(tx, &feedback)
+	})
+}
+
+func (s *sqlStorage) GetFeedbackVotes(userID string, feedbackIDs []string) (map[string]bool, error) {
+	voted := make(map[string]bool)
+	if len(feedbackIDs) == 0 {
+		return voted, nil
+	}
+
+	rows, err := s.db.Query(s.rebind(`SELECT feedback_id FROM feedback_votes WHERE user_id = ?`), userID)
+	if err != nil {
+		return voted, err
+	}
+	defer rows.Close()
+
+	wanted := make(map[string]bool, len(feedbackIDs))
+	for _, id := range feedbackIDs {
+		wanted[id] = true
+	}
+	for rows.Next() {
+		var feedbackID string
+		if err := rows.Scan(&feedbackID); err != nil {
+			return voted, err
+		}
+		if wanted[feedbackID] {
+			voted[feedbackID] = true
+		}
+	}
+	return voted, rows.Err()
+}
+
+// Logs
+
+func (s *sqlStorage) ListLogTypes(userID string) ([]LogType, error) {
+	return listDocuments[LogType](s, `SELECT data FROM log_types WHERE user_id = ? ORDER BY id`, userID)
+}
+
+func (s *sqlStorage) ListRetainedLogTypes() ([]LogType, error) {
+	return listDocuments[LogType](s, `SELECT data FROM log_types WHERE retention_days > 0`)
+}
+
+func (s *sqlStorage) ListAllLogTypes() ([]LogType, error) {
+	return listDocuments[LogType](s, `SELECT data FROM log_types`)
+}
+
+func (s *sqlStorage) GetLogType(logTypeID string) (*LogType, error) {
+	var logType LogType
+	found, err := s.getDocument(s.db, &logType, `SELECT data FROM log_types WHERE id = ?`, logTypeID)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &logType, nil
+}
+
+func (s *sqlStorage) putLogType(runner sqlRunner, logType *LogType) error {
+	data, err := encodeDocument(logType)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(runner, `INSERT INTO log_types (id, user_id, retention_days, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, retention_days = excluded.retention_days, data = excluded.data`,
+		logType.ID, logType.UserID, logType.RetentionDays, data)
+	return err
+}
+
+func (s *sqlStorage) PutLogType(logType *LogType) error {
+	return s.putLogType(s.db, logType)
+}
+
+func (s *sqlStorage) SetLogTypeRetention(logTypeID string, days int) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var logType LogType
+		found, err := s.getDocument(tx, &logType, `SELECT data FROM log_types WHERE id = ?`+s.forUpdate(), logTypeID)
+		if err != nil || !found {
+			return err
+		}
+		logType.RetentionDays = days
+		logType.UpdatedAt = time.Now()
+		return s.putLogType(tx, &logType)
+	})
+}
+
+func (s *sqlStorage) ListLogFields(logTypeID string) ([]LogField, error) {
+	return listDocuments[LogField](s, `SELECT data FROM log_fields WHERE log_type_id = ? ORDER BY display_order, id`, logTypeID)
+}
+
+func (s *sqlStorage) putLogField(runner sqlRunner, field *LogField) error {
+	data, err := encodeDocument(field)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(runner, `INSERT INTO log_fields (id, log_type_id, display_order, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET log_type_id = excluded.log_type_id, display_order = excluded.display_order, data = excluded.data`,
+		field.ID, field.LogTypeID, field.DisplayOrder, data)
+	return err
+}
+
+func (s *sqlStorage) PutLogField(field *LogField) error {
+	return s.putLogField(s.db, field)
+}
+
+func (s *sqlStorage) SetLogFieldOrder(fieldID string, order int) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var field LogField
+		found, err := s.getDocument(tx, &field, `SELECT data FROM log_fields WHERE id = ?`+s.forUpdate(), fieldID)
+		if err != nil || !found {
+			return err
+		}
+		field.DisplayOrder = order
+		return s.putLogField(tx, &field)
+	})
+}
+
+func (s *sqlStorage) GetLogEntry(entryID string) (*LogEntry, error) {
+	return s.getLogEntry(s.db, entryID, "")
+}
+
+func (s *sqlStorage) getLogEntry(runner sqlRunner, entryID, suffix string) (*LogEntry, error) {
+	var entry LogEntry
+	found, err := s.getDocument(runner, &entry, `SELECT data FROM log_entries WHERE id = ?`+suffix, entryID)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *sqlStorage) ListLogEntries(query LogEntryQuery) ([]LogEntry, error) {
+	conditions := []string{"user_id = ?"}
+	args := []interface{}{query.UserID}
+	if query.LogTypeID != "" {
+		conditions = append(conditions, "log_type_id = ?")
+		args = append(args, query.LogTypeID)
+	}
+	if query.From != "" {
+		conditions = append(conditions, "entry_date >= ?")
+		args = append(args, query.From)
+	}
+	if query.To != "" {
+		conditions = append(conditions, "entry_date <= ?")
+		args = append(args, query.To)
+	}
+	if query.Before != "" {
+		conditions = append(conditions, "entry_date < ?")
+		args = append(args, query.Before)
+	}
+	return listDocuments[LogEntry](s, `SELECT data FROM log_entries WHERE `+strings.Join(conditions, " AND ")+` ORDER BY entry_date, id`, args...)
+}
+
+// insertLogEntry inserts or replaces the entry; with onlyNew it leaves an
+// existing one alone and reports whether it wrote
+func (s *sqlStorage) insertLogEntry(runner sqlRunner, entry *LogEntry, onlyNew bool) (bool, error) {
+	data, err := encodeDocument(entry)
+	if err != nil {
+		return false, err
+	}
+	conflict := `DO UPDATE SET user_id = excluded.user_id, log_type_id = excluded.log_type_id,
+		entry_date = excluded.entry_date, version = excluded.version, data = excluded.data`
+	if onlyNew {
+		conflict = `DO NOTHING`
+	}
+	result, err := s.exec(runner, `INSERT INTO log_entries (id, user_id, log_type_id, entry_date, version, data)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (id) `+conflict,
+		entry.ID, entry.UserID, entry.LogTypeID, entry.EntryDate, entry.Version, data)
+	if err != nil {
+		return false, err
+	}
+	written, err := result.RowsAffected()
+	return written > 0, err
+}
+
+func (s *sqlStorage) PutLogEntry(entry *LogEntry) (*LogEntry, error) {
+	var previous *LogEntry
+	err := s.withTx(func(tx *sql.Tx) error {
+		var err error
+		if previous, err = s.getLogEntry(tx, entry.ID, s.forUpdate()); err != nil {
+			return err
+		}
+		_, err = s.insertLogEntry(tx, entry, false)
+		return err
+	})
+	return previous, err
+}
+
+func (s *sqlStorage) CreateLogEntry(entry *LogEntry) error {
+	written, err := s.insertLogEntry(s.db, entry, true)
+	if err == nil && !written {
+		return errStorageConflict
+	}
+	return err
+}
+
+func (s *sqlStorage) PutLogEntryIfVersion(entry *LogEntry, version int64) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		existing, err := s.getLogEntry(tx, entry.ID, s.forUpdate())
+		if err != nil {
+			return err
+		}
+		if existing != nil && existing.Version != version {
+			return errStorageConflict
+		}
+		written, err := s.insertLogEntry(tx, entry, existing == nil)
+		if err == nil && !written {
+			return errStorageConflict
+		}
+		return err
+	})
+}
+
+func (s *sqlStorage) DeleteLogEntry(entryID string) error {
+	_, err := s.exec(s.db, `DELETE FROM log_entries WHERE id = ?`, entryID)
+	return err
+}
+
+func (s *sqlStorage) DeleteLogEntryIfVersion(entryID string, version int64) error {
+	result, err := s.exec(s.db, `DELETE FROM log_entries WHERE id = ? AND version = ?`, entryID, version)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		// Either changed since it was read or already deleted
+		if existing, err := s.GetLogEntry(entryID); err != nil || existing != nil {
+			return errStorageConflict
+		}
+	}
+	return nil
+}
+
+func (s *sqlStorage) PutSyncChange(change *SyncChange) error {
+	// Bumping the head row locks it until commit, so a user's changes
+	// commit in sequence order
+	return s.withTx(func(tx *sql.Tx) error {
+		if _, err := s.exec(tx, `INSERT INTO sync_heads (user_id, seq) VALUES (?, 1)
+			ON CONFLICT (user_id) DO UPDATE SET seq = sync_heads.seq + 1`, change.UserID); err != nil {
+			return err
+		}
+		var seq int64
+		if err := tx.QueryRow(s.rebind(`SELECT seq FROM sync_heads WHERE user_id = ?`), change.UserID).Scan(&seq); err != nil {
+			return err
+		}
+		change.Seq = syncSeq(seq)
+
+		data, err := encodeDocument(change)
+		if err != nil {
+			return err
+		}
+		_, err = s.exec(tx, `INSERT INTO sync_changes (user_id, seq, data) VALUES (?, ?, ?)`, change.UserID, change.Seq, data)
+		return err
+	})
+}
+
+func (s *sqlStorage) ListSyncChanges(userID, since string, limit int) ([]SyncChange, bool, error) {
+	// One more than asked for tells whether more are waiting
+	changes, err := listDocuments[SyncChange](s, `SELECT data FROM sync_changes WHERE user_id = ? AND seq > ?
+		ORDER BY seq LIMIT ?`, userID, since, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	hasMore := len(changes) > limit
+	if hasMore {
+		changes = changes[:limit]
+	}
+	for i := range changes {
+		changes[i].UserID = userID // Not part of the JSON document
+	}
+	return changes, hasMore, nil
+}
+
+// logArchiveDocument keeps the S3 key, which LogArchive leaves out of its
+// JSON
+type logArchiveDocument struct {
+	LogArchive
+	S3Key string `json:"s3_key"`
+}
+
+func (s *sqlStorage) ListLogArchives(logTypeID string) ([]LogArchive, error) {
+	documents, err := listDocuments[logArchiveDocument](s, `SELECT data FROM log_archives WHERE log_type_id = ? ORDER BY period`, logTypeID)
+	if err != nil {
+		return nil, err
+	}
+	archives := make([]LogArchive, len(documents))
+	for i, document := range documents {
+		archives[i] = document.LogArchive
+		archives[i].S3Key = document.S3Key
+	}
+	return archives, nil
+}
+
+func (s *sqlStorage) GetLogArchive(logTypeID, period string) (*LogArchive, error) {
+	var document logArchiveDocument
+	found, err := s.getDocument(s.db, &document, `SELECT data FROM log_archives WHERE log_type_id = ? AND period = ?`, logTypeID, period)
+	if err != nil || !found {
+		return nil, err
+	}
+	archive := document.LogArchive
+	archive.S3Key = document.S3Key
+	return &archive, nil
+}
+
+func (s *sqlStorage) PutLogArchive(archive *LogArchive) error {
+	data, err := encodeDocument(logArchiveDocument{LogArchive: *archive, S3Key: archive.S3Key})
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(s.db, `INSERT INTO log_archives (log_type_id, period, data) VALUES (?, ?, ?)
+		ON CONFLICT (log_type_id, period) DO UPDATE SET data = excluded.data`, archive.LogTypeID, archive.Period, data)
+	return err
+}
+
+// Log aggregates, one row per bucket and counter, named like the DynamoDB
+// attributes ("count", "sum:<field>", "num:<field>", "initialized")
+
+func (s *sqlStorage) addAggregateValue(runner sqlRunner, logTypeID, bucket, name string, value float64) error {
+	_, err := s.exec(runner, `INSERT INTO log_aggregates (log_type_id, bucket, name, value) VALUES (?, ?, ?, ?)
+		ON CONFLICT (log_type_id, bucket, name) DO UPDATE SET value = log_aggregates.value + excluded.value`,
+		logTypeID, bucket, name, value)
+	return err
+}
+
+// addLogAggregate adds delta's counters to one bucket
+func (s *sqlStorage) addLogAggregate(runner sqlRunner, logTypeID, bucket string, delta *LogAggregate) error {
+	if err := s.addAggregateValue(runner, logTypeID, bucket, aggregateCountAttr, float64(delta.Count)); err != nil {
+		return err
+	}
+	for fieldName, sum := range delta.Sums {
+		if err := s.addAggregateValue(runner, logTypeID, bucket, aggregateSumPrefix+fieldName, sum); err != nil {
+			return err
+		}
+		if err := s.addAggregateValue(runner, logTypeID, bucket, aggregateNumPrefix+fieldName, float64(delta.Counts[fieldName])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStorage) AddLogAggregates(logTypeID string, buckets []string, delta LogAggregate) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		for _, bucket := range buckets {
+			if err := s.addLogAggregate(tx, logTypeID, bucket, &delta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStorage) InitializeLogAggregates(logTypeID string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var exists int
+		err := tx.QueryRow(s.rebind(`SELECT 1 FROM log_aggregates WHERE log_type_id = ? AND bucket = ?`+s.forUpdate()),
+			logTypeID, aggregateTotalBucket).Scan(&exists)
+		if err != sql.ErrNoRows {
+			return err
+		}
+		if err := s.addAggregateValue(tx, logTypeID, aggregateTotalBucket, aggregateCountAttr, 0); err != nil {
+			return err
+		}
+		return s.addAggregateValue(tx, logTypeID, aggregateTotalBucket, aggregateInitializedAttr, 1)
+	})
+}
+
+func (s *sqlStorage) ListLogAggregates(logTypeID, fromBucket string) (map[string]LogAggregate, bool, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT bucket, name, value FROM log_aggregates WHERE log_type_id = ? AND bucket >= ?`),
+		logTypeID, fromBucket)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	aggregates := make(map[string]*LogAggregate)
+	initialized := false
+	for rows.Next() {
+		var bucket, name string
+		var value float64
+		if err := rows.Scan(&bucket, &name, &value); err != nil {
+			return nil, false, err
+		}
+		agg, ok := aggregates[bucket]
+		if !ok {
+			agg = &LogAggregate{Bucket: bucket, Sums: map[string]float64{}, Counts: map[string]int{}}
+			aggregates[bucket] = agg
+		}
+		if bucket == aggregateTotalBucket && name == aggregateInitializedAttr {
+			initialized = true
+		}
+		addAggregateValue(agg, name, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	result := make(map[string]LogAggregate, len(aggregates))
+	for bucket, agg := range aggregates {
+		result[bucket] = *agg
+	}
+	return result, initialized, nil
+}
+
+func (s *sqlStorage) ReplaceLogAggregates(logTypeID string, buckets map[string]*LogAggregate) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if _, err := s.exec(tx, `DELETE FROM log_aggregates WHERE log_type_id = ?`, logTypeID); err != nil {
+			return err
+		}
+		for bucket, agg := range buckets {
+			if err := s.addLogAggregate(tx, logTypeID, bucket, agg); err != nil {
+				return err
+			}
+		}
+		return s.addAggregateValue(tx, logTypeID, aggregateTotalBucket, aggregateInitializedAttr, 1)
+	})
+}
+
+// Analytics
+
+// analyticsEventDocument keeps the expiry, which AnalyticsEvent leaves out
+// of its JSON
+type analyticsEventDocument struct {
+	AnalyticsEvent
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+func (s *sqlStorage) PutAnalyticsEvents(events []AnalyticsEvent) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		for _, event := range events {
+			data, err := encodeDocument(analyticsEventDocument{AnalyticsEvent: event, ExpiresAt: event.ExpiresAt})
+			if err != nil {
+				return err
+			}
+			_, err = s.exec(tx, `INSERT INTO analytics_events (id, occurred_at, expires_at, data) VALUES (?, ?, ?, ?)
+				ON CONFLICT (id) DO UPDATE SET occurred_at = excluded.occurred_at, expires_at = excluded.expires_at, data = excluded.data`,
+				event.ID, event.Timestamp.UTC().Format(sqlTimeFormat), event.ExpiresAt, data)
+			if err != nil {
+				return err
+			}
+		}
+
+		// There's no TTL, so expired events are removed as new ones arrive
+		_, err := s.exec(tx, `DELETE FROM analytics_events WHERE expires_at > 0 AND expires_at < ?`, time.Now().Unix())
+		return err
+	})
+}
+
+func (s *sqlStorage) ListAnalyticsEvents() ([]AnalyticsEvent, error) {
+	documents, err := listDocuments[analyticsEventDocument](s, `SELECT data FROM analytics_events ORDER BY occurred_at`)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]AnalyticsEvent, len(documents))
+	for i, document := range documents {
+		events[i] = document.AnalyticsEvent
+		events[i].ExpiresAt = document.ExpiresAt
+	}
+	return events, nil
+}
+
+func (s *sqlStorage) MarkAnalyticsSeen(key string, firstSeen time.Time) (bool, error) {
+	result, err := s.exec(s.db, `INSERT INTO analytics_seen (seen_key, first_seen) VALUES (?, ?)
+		ON CONFLICT (seen_key) DO NOTHING`, key, firstSeen.UTC().Format(sqlTimeFormat))
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	return inserted > 0, err
+}
+
+func (s *sqlStorage) AddAnalyticsRollup(rollup *AnalyticsRollup) error {
+	_, err := s.exec(s.db, `INSERT INTO analytics_rollups (day, visits, logins, new_visitors, new_users) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (day) DO UPDATE SET visits = analytics_rollups.visits + excluded.visits,
+			logins = analytics_rollups.logins + excluded.logins,
+			new_visitors = analytics_rollups.new_visitors + excluded.new_visitors,
+			new_users = analytics_rollups.new_users + excluded.new_users`,
+		rollup.Day, rollup.Visits, rollup.Logins, rollup.NewVisitors, rollup.NewUsers)
+	return err
+}
+
+func (s *sqlStorage) ListAnalyticsRollups() ([]AnalyticsRollup, error) {
+	rows, err := s.db.Query(`SELECT day, visits, logins, new_visitors, new_users FROM analytics_rollups ORDER BY day`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rollups := []AnalyticsRollup{}
+	for rows.Next() {
+		var rollup AnalyticsRollup
+		if err := rows.Scan(&rollup.Day, &rollup.Visits, &rollup.Logins, &rollup.NewVisitors, &rollup.NewUsers); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
+
+// AI quotas
+
+func (s *sqlStorage) AddAIQuotaUsage(subject, day, feature string, n, limit int) (int, error) {
+	used := 0
+	err := s.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(s.rebind(`SELECT used FROM ai_quota_usage WHERE subject = ? AND day = ? AND feature = ?`+s.forUpdate()),
+			subject, day, feature).Scan(&used)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if n > 0 && used+n > limit {
+			return errStorageConflict
+		}
+		used += n
+		_, err = s.exec(tx, `INSERT INTO ai_quota_usage (subject, day, feature, used, expires_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (subject, day, feature) DO UPDATE SET used = excluded.used, expires_at = excluded.expires_at`,
+			subject, day, feature, used, time.Now().Add(aiQuotaRetention).Unix())
+		if err != nil {
+			return err
+		}
+
+		// There's no TTL, so old counts are removed as new ones arrive
+		_, err = s.exec(tx, `DELETE FROM ai_quota_usage WHERE expires_at < ?`, time.Now().Unix())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return used, nil
+}
+
+func (s *sqlStorage) GetAIQuotaUsage(subject, day string) (map[string]int, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT feature, used FROM ai_quota_usage WHERE subject = ? AND day = ?`), subject, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	used := map[string]int{}
+	for rows.Next() {
+		var feature string
+		var count int
+		if err := rows.Scan(&feature, &count); err != nil {
+			return nil, err
+		}
+		used[feature] = count
+	}
+	return used, rows.Err()
+}
+
+// Jobs
+
+func (s *sqlStorage) ClaimJobRun(job, period string, expiresAt int64) (bool, error) {
+	claimed := false
+	err := s.withTx(func(tx *sql.Tx) error {
+		// There's no TTL, so expired claims are removed before each claim
+		_, err := s.exec(tx, `DELETE FROM job_runs WHERE expires_at > 0 AND expires_at < ?`, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		result, err := s.exec(tx, `INSERT INTO job_runs (job, period, expires_at) VALUES (?, ?, ?)
+			ON CONFLICT (job, period) DO NOTHING`, job, period, expiresAt)
+		if err != nil {
+			return err
+		}
+		inserted, err := result.RowsAffected()
+		claimed = inserted > 0
+		return err
+	})
+	return claimed, err
+}
+
+func (s *sqlStorage) ReleaseJobRun(job, period string) error {
+	_, err := s.exec(s.db, `DELETE FROM job_runs WHERE job = ? AND period = ?`, job, period)
+	return err
+}
+
+// jobDocument keeps the expiry, which Job leaves out of its JSON
+type jobDocument struct {
+	Job
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+func (d jobDocument) job() Job {
+	job := d.Job
+	job.ExpiresAt = d.ExpiresAt
+	return job
+}
+
+func (s *sqlStorage) putJob(runner sqlRunner, job *Job) error {
+	data, err := encodeDocument(jobDocument{Job: *job, ExpiresAt: job.ExpiresAt})
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(runner, `INSERT INTO jobs (job_id, state, visible_at, expires_at, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (job_id) DO UPDATE SET state = excluded.state, visible_at = excluded.visible_at,
+			expires_at = excluded.expires_at, data = excluded.data`,
+		job.ID, job.State, job.VisibleAt, job.ExpiresAt, data)
+	return err
+}
+
+func (s *sqlStorage) PutJob(job *Job) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := s.putJob(tx, job); err != nil {
+			return err
+		}
+		// There's no TTL, so expired jobs are removed as new ones arrive
+		_, err := s.exec(tx, `DELETE FROM jobs WHERE expires_at > 0 AND expires_at < ?`, time.Now().Unix())
+		return err
+	})
+}
+
+func (s *sqlStorage) listJobs(query string, args ...interface{}) ([]Job, error) {
+	documents, err := listDocuments[jobDocument](s, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, len(documents))
+	for i, document := range documents {
+		jobs[i] = document.job()
+	}
+	return jobs, nil
+}
+
+func (s *sqlStorage) ListVisibleJobs(now int64, limit int) ([]Job, error) {
+	return s.listJobs(`SELECT data FROM jobs WHERE state = ? AND visible_at <= ? ORDER BY visible_at LIMIT ?`,
+		jobStateQueued, now, limit)
+}
+
+// updateJob applies change to a stored job, if there is one
+func (s *sqlStorage) updateJob(jobID string, change func(job *Job) error) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var document jobDocument
+		found, err := s.getDocument(tx, &document, `SELECT data FROM jobs WHERE job_id = ?`+s.forUpdate(), jobID)
+		if err != nil || !found {
+			return err
+		}
+		job := document.job()
+		if err := change(&job); err != nil {
+			return err
+		}
+		return s.putJob(tx, &job)
+	})
+}
+
+func (s *sqlStorage) LeaseJob(jobID string, seen, until int64) error {
+	leased := false
+	err := s.updateJob(jobID, func(job *Job) error {
+		if job.State != jobStateQueued || job.VisibleAt != seen {
+			return errStorageConflict
+		}
+		job.VisibleAt = until
+		job.Attempts++
+		leased = true
+		return nil
+	})
+	if err == nil && !leased {
+		return errStorageConflict
+	}
+	return err
+}
+
+func (s *sqlStorage) RetryJob(jobID, lastError string, visibleAt int64) error {
+	return s.updateJob(jobID, func(job *Job) error {
+		job.VisibleAt = visibleAt
+		job.LastError = lastError
+		return nil
+	})
+}
+
+func (s *sqlStorage) FailJob(jobID, lastError string, expiresAt int64) error {
+	return s.updateJob(jobID, func(job *Job) error {
+		job.State = jobStateFailed
+		job.LastError = lastError
+		job.ExpiresAt = expiresAt
+		return nil
+	})
+}
+
+func (s *sqlStorage) DeleteJob(jobID string) error {
+	_, err := s.exec(s.db, `DELETE FROM jobs WHERE job_id = ?`, jobID)
+	return err
+}
+
+func (s *sqlStorage) ListFailedJobs(limit int) ([]Job, error) {
+	return s.listJobs(`SELECT data FROM jobs WHERE state = ? ORDER BY visible_at DESC LIMIT ?`, jobStateFailed, limit)
+}
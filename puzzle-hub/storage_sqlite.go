@@ -0,0 +1,10 @@
+//go:build sqlite
+
+package main
+
+// The SQLite driver needs cgo, so it's only linked into binaries built for
+// STORAGE_BACKEND=sqlite:
+//
+//	CGO_ENABLED=1 go build -tags sqlite
+
+import _ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver
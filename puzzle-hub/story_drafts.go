@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Saved stories
+//
+// Students keep their story drafts in puzzle-hub-story-drafts until they
+// delete them. When a draft is ready, title suggestions read it and offer
+// five titles and a one-sentence back-cover blurb, written by AI in one
+// call. The suggestions are kept on the draft as candidates; the student
+// picks one (or keeps their own title) and the choice is saved with the
+// draft. Editing the story clears candidates that no longer fit it.
+//
+// Without AI the titles are built from the names and words the story uses
+// most, and the blurb from its opening sentence.
+
+const (
+	maxStoryDrafts        = 200
+	maxStoryTitleLength   = 100
+	maxStoryContentLength = 20000
+	minStoryForTitles     = 50 // Characters of story needed for suggestions
+	storyTitleCount       = 5
+)
+
+type StoryDraft struct {
+	UserID     string                `json:"-" dynamodbav:"user_id"`
+	ID         string                `json:"id" dynamodbav:"story_id"` // story_<unix nanos>
+	Title      string                `json:"title" dynamodbav:"title"`
+	Genre      string                `json:"genre,omitempty" dynamodbav:"genre,omitempty"`
+	Content    string                `json:"content" dynamodbav:"content"`
+	Blurb      string                `json:"blurb,omitempty" dynamodbav:"blurb,omitempty"`
+	Candidates *StoryTitleCandidates `json:"title_candidates,omitempty" dynamodbav:"title_candidates,omitempty"`
+	CreatedAt  time.Time             `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt  time.Time             `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+type StoryTitleCandidates struct {
+	Titles       []string  `json:"titles" dynamodbav:"titles"`
+	Blurb        string    `json:"blurb" dynamodbav:"blurb"`
+	Source       string    `json:"source" dynamodbav:"source"` // ai or fallback
+	GenerationID string    `json:"generation_id,omitempty" dynamodbav:"generation_id,omitempty"`
+	GeneratedAt  time.Time `json:"generated_at" dynamodbav:"generated_at"`
+}
+
+type SaveStoryDraftRequest struct {
+	Title   string `json:"title"`
+	Genre   string `json:"genre"`
+	Content string `json:"content"`
+}
+
+type PickStoryTitleRequest struct {
+	Index *int  `json:"index" binding:"required"` // Into title_candidates.titles
+	Blurb *bool `json:"blurb"`                    // Also keep the suggested blurb, default true
+}
+
+// storyTitlesPrompt is what the story_titles template sees
+type storyTitlesPrompt struct {
+	Title   string
+	Genre   string
+	Content string `prompt:"text"`
+	Count   int
+}
+
+// validate checks and tidies a draft before it is saved
+func (r *SaveStoryDraftRequest) validate() string {
+	r.Title = strings.TrimSpace(r.Title)
+	r.Genre = strings.TrimSpace(r.Genre)
+	switch {
+	case len(r.Title) > maxStoryTitleLength:
+		return fmt.Sprintf("Title must be at most %d characters", maxStoryTitleLength)
+	case len(r.Content) > maxStoryContentLength:
+		return fmt.Sprintf("Story must be at most %d characters", maxStoryContentLength)
+	case len(r.Genre) > 40:
+		return "Genre must be at most 40 characters"
+	}
+	return ""
+}
+
+// createStoryDraft saves a new draft
+func (h *PuzzleHub) createStoryDraft(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	var request SaveStoryDraftRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if problem := request.validate(); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+
+	count, err := h.countStoryDrafts(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error counting stories", "error", err)
+		respondStorageError(c, err, "Failed to save story")
+		return
+	}
+	if count >= maxStoryDrafts {
+		respondError(c, http.StatusConflict, fmt.Sprintf("You can keep up to %d stories; delete one to start another", maxStoryDrafts))
+		return
+	}
+
+	now := time.Now()
+	draft := &StoryDraft{
+		UserID:    userObj.ID,
+		ID:        fmt.Sprintf("story_%d", now.UnixNano()),
+		Title:     request.Title,
+		Genre:     request.Genre,
+		Content:   request.Content,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := h.putStoryDraft(ctx, draft); err != nil {
+		requestLogger(c).Error("error saving story", "error", err)
+		respondStorageError(c, err, "Failed to save story")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"story": draft})
+}
+
+// listStoryDrafts lists the student's stories, newest first, without
+// their text
+func (h *PuzzleHub) listStoryDrafts(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	stories, err := queryLimit[StoryDraft](c.Request.Context(), h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-story-drafts")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userObj.ID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, 0)
+	if err != nil {
+		requestLogger(c).Error("error listing stories", "error", err)
+		respondStorageError(c, err, "Failed to fetch stories")
+		return
+	}
+	for i := range stories {
+		stories[i].Content = ""
+		stories[i].Candidates = nil
+	}
+	c.JSON(http.StatusOK, gin.H{"stories": stories})
+}
+
+// getStoryDraft returns one of the student's stories
+func (h *PuzzleHub) getStoryDraft(c *gin.Context) {
+	draft, ok := h.requireStoryDraft(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"story": draft})
+}
+
+// updateStoryDraft replaces a story's title, genre and text
+func (h *PuzzleHub) updateStoryDraft(c *gin.Context) {
+	var request SaveStoryDraftRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if problem := request.validate(); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+	draft, ok := h.requireStoryDraft(c)
+	if !ok {
+		return
+	}
+
+	if request.Content != draft.Content {
+		draft.Candidates = nil
+	}
+	draft.Title = request.Title
+	draft.Genre = request.Genre
+	draft.Content = request.Content
+	draft.UpdatedAt = time.Now()
+	if err := h.putStoryDraft(c.Request.Context(), draft); err != nil {
+		requestLogger(c).Error("error saving story", "error", err, "draft_id", draft.ID)
+		respondStorageError(c, err, "Failed to save story")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"story": draft})
+}
+
+// deleteStoryDraft deletes one of the student's stories
+func (h *PuzzleHub) deleteStoryDraft(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-story-drafts")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":  {S: aws.String(userObj.ID)},
+			"story_id": {S: aws.String(c.Param("id"))},
+		},
+		ConditionExpression: aws.String("attribute_exists(story_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Story not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error deleting story", "error", err, "story_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to delete story")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Story deleted"})
+}
+
+// suggestStoryTitles writes title and blurb candidates for a story and
+// keeps them on the draft
+func (h *PuzzleHub) suggestStoryTitles(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	draft, ok := h.requireStoryDraft(c)
+	if !ok {
+		return
+	}
+	if len(strings.TrimSpace(draft.Content)) < minStoryForTitles {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Write at least %d characters of your story first", minStoryForTitles))
+		return
+	}
+
+	refund, ok := h.consumeAIQuota(c, "story", 1)
+	if !ok {
+		return
+	}
+	candidates := h.writeStoryTitles(ctx, draft, userObj.ID)
+	draft.Candidates = candidates
+
+	// Only the candidates are written, so a save in the meantime isn't lost
+	item, err := dynamodbattribute.Marshal(candidates)
+	if err == nil {
+		_, err = h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName("puzzle-hub-story-drafts")),
+			Key: map[string]*dynamodb.AttributeValue{
+				"user_id":  {S: aws.String(draft.UserID)},
+				"story_id": {S: aws.String(draft.ID)},
+			},
+			UpdateExpression:          aws.String("SET title_candidates = :candidates"),
+			ConditionExpression:       aws.String("attribute_exists(story_id)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":candidates": item},
+		})
+	}
+	if isConditionalCheckFailed(err) {
+		refund()
+		respondError(c, http.StatusNotFound, "Story not found")
+		return
+	}
+	if err != nil {
+		refund()
+		requestLogger(c).Error("error saving title suggestions for story", "error", err, "draft_id", draft.ID)
+		respondStorageError(c, err, "Failed to save title suggestions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"title_candidates": candidates})
+}
+
+// pickStoryTitle sets the story's title (and blurb) from its candidates
+func (h *PuzzleHub) pickStoryTitle(c *gin.Context) {
+	var request PickStoryTitleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	draft, ok := h.requireStoryDraft(c)
+	if !ok {
+		return
+	}
+	if draft.Candidates == nil {
+		respondError(c, http.StatusConflict, "Ask for title suggestions first")
+		return
+	}
+	if *request.Index < 0 || *request.Index >= len(draft.Candidates.Titles) {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Index must be 0 to %d", len(draft.Candidates.Titles)-1))
+		return
+	}
+
+	draft.Title = draft.Candidates.Titles[*request.Index]
+	if request.Blurb == nil || *request.Blurb {
+		draft.Blurb = draft.Candidates.Blurb
+	}
+	draft.UpdatedAt = time.Now()
+	if err := h.putStoryDraft(c.Request.Context(), draft); err != nil {
+		requestLogger(c).Error("error saving story", "error", err, "draft_id", draft.ID)
+		respondStorageError(c, err, "Failed to save story")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"story": draft})
+}
+
+// writeStoryTitles asks AI for titles and a blurb, falling back to ones
+// built from the story's own words
+func (h *PuzzleHub) writeStoryTitles(ctx context.Context, draft *StoryDraft, userID string) *StoryTitleCandidates {
+	choice := h.Prompts.choose("story_titles", userID)
+	cacheParams := choice.cacheParams(map[string]interface{}{
+		"genre": normalizeCacheParam(draft.Genre),
+		"story": draft.Content,
+	})
+
+	var generated struct {
+		Titles []string `json:"titles"`
+		Blurb  string   `json:"blurb"`
+	}
+	if h.loadAICache(ctx, "story", cacheParams, &generated) {
+		return &StoryTitleCandidates{Titles: generated.Titles, Blurb: generated.Blurb, Source: sourceAI, GeneratedAt: time.Now()}
+	}
+
+	request := StoryRequest{Genre: draft.Genre}
+	call := aiCall{
+		Feature:    "story",
+		UserID:     userID,
+		System:     h.Prompts.render("story_system", request),
+		Prompt:     choice.PromptTag,
+		Generation: newAIGeneration(),
+	}
+	prompt := h.Prompts.renderChoice(choice, storyTitlesPrompt{
+		Title:   draft.Title,
+		Genre:   draft.Genre,
+		Content: draft.Content,
+		Count:   storyTitleCount,
+	})
+	err := h.generateJSON(ctx, prompt, call, storyTitlesSchema, &generated, func() error {
+		seen := map[string]bool{}
+		for i, title := range generated.Titles {
+			title = strings.TrimSpace(title)
+			if title == "" || len(title) > maxStoryTitleLength {
+				return fmt.Errorf("title %d must be 1 to %d characters", i+1, maxStoryTitleLength)
+			}
+			if seen[strings.ToLower(title)] {
+				return fmt.Errorf("title %q is repeated", title)
+			}
+			seen[strings.ToLower(title)] = true
+			generated.Titles[i] = title
+		}
+		if len(generated.Titles) != storyTitleCount {
+			return fmt.Errorf("expected %d titles, got %d", storyTitleCount, len(generated.Titles))
+		}
+		generated.Blurb = strings.TrimSpace(generated.Blurb)
+		if generated.Blurb == "" {
+			return fmt.Errorf("blurb is empty")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("❌ %s title suggestions failed, using the story's own words: %v", h.providerFor("story"), err)
+		return fallbackStoryTitles(draft)
+	}
+
+	h.saveGeneration(call.Generation, call, choice)
+	h.storeAICache(ctx, "story", cacheParams, generated)
+	return &StoryTitleCandidates{
+		Titles:       generated.Titles,
+		Blurb:        generated.Blurb,
+		Source:       sourceAI,
+		GenerationID: call.Generation.ID,
+		GeneratedAt:  time.Now(),
+	}
+}
+
+// storyTitleStopWords are common words that make poor titles
+var storyTitleStopWords = map[string]bool{
+	"about": true, "after": true, "again": true, "because": true, "before": true,
+	"could": true, "every": true, "first": true, "going": true, "never": true,
+	"other": true, "really": true, "said": true, "should": true, "something": true,
+	"their": true, "there": true, "these": true, "thing": true, "things": true,
+	"think": true, "those": true, "through": true, "under": true, "until": true,
+	"wanted": true, "where": true, "which": true, "while": true, "would": true,
+}
+
+// fallbackStoryTitles builds titles from the names and words the story
+// uses most, and a blurb from its opening sentence
+func fallbackStoryTitles(draft *StoryDraft) *StoryTitleCandidates {
+	names := map[string]int{}
+	words := map[string]int{}
+	sentenceStart := true
+	for _, field := range strings.Fields(draft.Content) {
+		word := strings.TrimFunc(field, func(r rune) bool { return !unicode.IsLetter(r) })
+		if word != "" {
+			first := []rune(word)[0]
+			switch {
+			case unicode.IsUpper(first) && !sentenceStart && len(word) > 1:
+				names[word]++
+			case len(word) >= 5 && !storyTitleStopWords[strings.ToLower(word)]:
+				words[strings.ToLower(word)]++
+			}
+		}
+		sentenceStart = strings.ContainsAny(field[len(field)-1:], ".!?")
+	}
+
+	name := mostUsed(names, "")
+	subject := mostUsed(words, "")
+	if subject == "" {
+		subject = "surprise"
+	}
+	subject = capitalizeFirst(subject)
+	genre := capitalizeFirst(strings.ToLower(draft.Genre))
+	if genre == "" {
+		genre = "Adventure"
+	}
+
+	options := []string{
+		"The " + subject,
+		"The " + subject + " " + genre,
+		"A Story About the " + subject,
+		"The Secret of the " + subject,
+		"Beyond the " + subject,
+		"The Great " + genre,
+	}
+	if name != "" {
+		options = append([]string{
+			name + " and the " + subject,
+			"The " + genre + " of " + name,
+			name + "'s Big Day",
+		}, options...)
+	}
+	titles := options[:storyTitleCount]
+
+	blurb := "A " + strings.ToLower(genre) + " story. What happens next?"
+	opening := strings.TrimSpace(draft.Content)
+	if end := strings.IndexAny(opening, ".!?"); end >= 0 {
+		opening = opening[:end+1]
+	}
+	if opening != "" && len(opening) <= 160 {
+		blurb = opening + " What happens next?"
+	}
+
+	return &StoryTitleCandidates{Titles: titles, Blurb: blurb, Source: sourceFallback, GeneratedAt: time.Now()}
+}
+
+func capitalizeFirst(word string) string {
+	if word == "" {
+		return ""
+	}
+	runes := []rune(word)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// mostUsed returns the most frequent key, the alphabetically first on a tie
+func mostUsed(counts map[string]int, fallback string) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	best := fallback
+	for _, key := range keys {
+		if best == fallback || counts[key] > counts[best] {
+			best = key
+		}
+	}
+	return best
+}
+
+// requireStoryDraft loads the caller's story :id
+func (h *PuzzleHub) requireStoryDraft(c *gin.Context) (*StoryDraft, bool) {
+	userObj := c.MustGet("user").(*User)
+	draft, err := h.loadStoryDraft(c.Request.Context(), userObj.ID, c.Param("id"))
+	if err != nil {
+		requestLogger(c).Error("error fetching story", "error", err, "story_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to fetch story")
+		return nil, false
+	}
+	if draft == nil {
+		respondError(c, http.StatusNotFound, "Story not found")
+		return nil, false
+	}
+	return draft, true
+}
+
+func (h *PuzzleHub) loadStoryDraft(ctx context.Context, userID, storyID string) (*StoryDraft, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-story-drafts")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":  {S: aws.String(userID)},
+			"story_id": {S: aws.String(storyID)},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return nil, err
+	}
+	var draft StoryDraft
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &draft); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal story: %v", err)
+	}
+	return &draft, nil
+}
+
+func (h *PuzzleHub) countStoryDrafts(ctx context.Context, userID string) (int, error) {
+	return countQuery(ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-story-drafts")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	})
+}
+
+func (h *PuzzleHub) putStoryDraft(ctx context.Context, draft *StoryDraft) error {
+	item, err := dynamodbattribute.MarshalMap(draft)
+	if err != nil {
+		return fmt.Errorf("failed to marshal story: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-story-drafts")),
+		Item:      item,
+	})
+	return err
+}
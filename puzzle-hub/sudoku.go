@@ -0,0 +1,823 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sudoku
+//
+// Puzzles come in two sizes: 4x4 with 2x2 boxes (kid mode) and the usual
+// 9x9. A full grid is filled at random, then clues are removed one at a
+// time, keeping a removal only if the puzzle still has exactly one solution
+// and can still be solved with the techniques the difficulty allows:
+//
+//	easy    naked singles (a cell with one possible number)
+//	medium  hidden singles (a number with one possible cell in a row, column or box)
+//	hard    pointing numbers and naked pairs, to rule candidates out
+//	expert  needs trial and error
+//
+// The grade is the hardest technique the solver had to use, so a "hard"
+// puzzle really needs pairs somewhere, or the grade its clue count belongs
+// to if that is higher. 4x4 puzzles go up to medium; they all fall to naked
+// singles, so there medium just means fewer clues.
+//
+// Like Yohaku, puzzles are handed to the client and nothing is kept on the
+// server: a unique puzzle has only one valid completion, so /sudoku/validate
+// checks the rules against the givens rather than a stored solution. Game
+// sessions are a run of sudokuGamePuzzles puzzles getting harder, recorded
+// as "sudoku" progress, and "sudoku:<room>" realtime rooms work the same as
+// Yohaku ones for playing together.
+
+const (
+	sudokuGamePuzzles     = 5
+	sudokuGenerateTries   = 12
+	sudokuNakedSingle     = 0
+	sudokuHiddenSingle    = 1
+	sudokuLockedCandidate = 2 // Pointing numbers and naked pairs
+	sudokuTrialAndError   = 3
+)
+
+var sudokuDifficulties = []string{"easy", "medium", "hard", "expert"}
+
+// sudokuMaxDifficulty is the hardest grade offered per size
+var sudokuMaxDifficulty = map[int]int{4: sudokuHiddenSingle, 9: sudokuTrialAndError}
+
+// sudokuMinClues stops removal early on easier puzzles, per size and grade
+var sudokuMinClues = map[int][]int{
+	4: {8, 4},
+	9: {36, 30, 25, 17},
+}
+
+// sudokuTimers are default timer durations in seconds, per size and grade
+var sudokuTimers = map[int][]int{
+	4: {180, 240},
+	9: {900, 1200, 1800, 2700},
+}
+
+var sudokuTechniques = []string{"naked_single", "hidden_single", "locked_candidates", "trial_and_error"}
+
+type SudokuSettings struct {
+	Size          int    `json:"size"`              // 4 or 9, default 9
+	Difficulty    string `json:"difficulty"`        // easy, medium, hard or expert; default easy
+	TimerDuration int    `json:"timerDuration"`     // Seconds, default depends on size and difficulty
+	Untimed       bool   `json:"untimed,omitempty"` // No timer; set from the reduced_time_pressure preference
+}
+
+type SudokuPuzzle struct {
+	ID            string  `json:"id"`
+	Size          int     `json:"size"`
+	BoxSize       int     `json:"boxSize"`
+	Grid          [][]int `json:"grid"` // 0 for an empty cell
+	Clues         int     `json:"clues"`
+	Difficulty    string  `json:"difficulty"` // Graded, which may be easier than asked for
+	TimerDuration int     `json:"timerDuration"`
+	Level         int     `json:"level"` // Puzzle number in a game session
+	Score         int     `json:"score"` // Points for solving this puzzle
+}
+
+type SudokuGameSession struct {
+	ID             string         `json:"id"`
+	Puzzles        []SudokuPuzzle `json:"puzzles"`
+	CurrentPuzzle  int            `json:"currentPuzzle"`
+	TotalScore     int            `json:"totalScore"`
+	CompletedCount int            `json:"completedCount"`
+	StartTime      time.Time      `json:"startTime"`
+	Settings       SudokuSettings `json:"settings"`
+	Seed           int64          `json:"seed,omitempty"` // Regenerates the same puzzles, see challenges.go
+}
+
+type ValidateSudokuRequest struct {
+	Puzzle [][]int `json:"puzzle" binding:"required"` // The grid as generated
+	Grid   [][]int `json:"grid" binding:"required"`   // The player's grid
+}
+
+type SudokuHintRequest struct {
+	Grid [][]int `json:"grid" binding:"required"` // The player's grid, givens included
+}
+
+type SudokuCell struct {
+	Row int `json:"row"`
+	Col int `json:"col"`
+}
+
+// sudokuUnit is a row, column or box as cell indexes into a flat grid
+type sudokuUnit struct {
+	kind   string // row, column or box
+	number int    // From 1
+	cells  []int
+}
+
+// sudokuStep is one deduction: value goes in cell
+type sudokuStep struct {
+	cell      int
+	value     int
+	technique int
+	unit      sudokuUnit // For hidden singles, where the value has one place
+}
+
+var sudokuUnitsBySize = map[int][]sudokuUnit{4: buildSudokuUnits(4), 9: buildSudokuUnits(9)}
+
+func sudokuBoxSize(n int) int {
+	if n == 4 {
+		return 2
+	}
+	return 3
+}
+
+func buildSudokuUnits(n int) []sudokuUnit {
+	box := sudokuBoxSize(n)
+	units := make([]sudokuUnit, 0, 3*n)
+	for r := 0; r < n; r++ {
+		unit := sudokuUnit{kind: "row", number: r + 1}
+		for c := 0; c < n; c++ {
+			unit.cells = append(unit.cells, r*n+c)
+		}
+		units = append(units, unit)
+	}
+	for c := 0; c < n; c++ {
+		unit := sudokuUnit{kind: "column", number: c + 1}
+		for r := 0; r < n; r++ {
+			unit.cells = append(unit.cells, r*n+c)
+		}
+		units = append(units, unit)
+	}
+	for b := 0; b < n; b++ {
+		unit := sudokuUnit{kind: "box", number: b + 1}
+		top, left := (b/box)*box, (b%box)*box
+		for r := top; r < top+box; r++ {
+			for c := left; c < left+box; c++ {
+				unit.cells = append(unit.cells, r*n+c)
+			}
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+// sudokuBoxOf returns the box number of a cell
+func sudokuBoxOf(n, cell int) int {
+	box := sudokuBoxSize(n)
+	return (cell/n/box)*box + (cell%n)/box
+}
+
+// flattenSudoku checks a grid's shape and values and flattens it
+func flattenSudoku(grid [][]int) ([]int, int, string) {
+	n := len(grid)
+	if n != 4 && n != 9 {
+		return nil, 0, "Grid must be 4x4 or 9x9"
+	}
+	cells := make([]int, 0, n*n)
+	for _, row := range grid {
+		if len(row) != n {
+			return nil, 0, fmt.Sprintf("Every row must have %d cells", n)
+		}
+		for _, value := range row {
+			if value < 0 || value > n {
+				return nil, 0, fmt.Sprintf("Cells must hold 0 (empty) to %d", n)
+			}
+			cells = append(cells, value)
+		}
+	}
+	return cells, n, ""
+}
+
+func unflattenSudoku(cells []int, n int) [][]int {
+	grid := make([][]int, n)
+	for r := range grid {
+		grid[r] = append([]int(nil), cells[r*n:(r+1)*n]...)
+	}
+	return grid
+}
+
+// sudokuConflicts returns the filled cells that repeat a number in their
+// row, column or box
+func sudokuConflicts(cells []int, n int) []SudokuCell {
+	conflicting := map[int]bool{}
+	for _, unit := range sudokuUnitsBySize[n] {
+		seen := map[int]int{}
+		for _, cell := range unit.cells {
+			value := cells[cell]
+			if value == 0 {
+				continue
+			}
+			if other, ok := seen[value]; ok {
+				conflicting[other] = true
+				conflicting[cell] = true
+			}
+			seen[value] = cell
+		}
+	}
+	conflicts := []SudokuCell{}
+	for cell := 0; cell < n*n; cell++ {
+		if conflicting[cell] {
+			conflicts = append(conflicts, SudokuCell{Row: cell / n, Col: cell % n})
+		}
+	}
+	return conflicts
+}
+
+// sudokuSolver is a backtracking solver over bitmasks of the numbers used
+// in each row, column and box
+type sudokuSolver struct {
+	n                 int
+	cells             []int
+	rows, cols, boxes []uint16
+	rng               *rand.Rand // Shuffles the order numbers are tried, if set
+	limit             int
+	solutions         int
+	first             []int
+}
+
+// newSudokuSolver returns false when the givens already conflict
+func newSudokuSolver(cells []int, n int, rng *rand.Rand) (*sudokuSolver, bool) {
+	s := &sudokuSolver{
+		n:     n,
+		cells: append([]int(nil), cells...),
+		rows:  make([]uint16, n),
+		cols:  make([]uint16, n),
+		boxes: make([]uint16, n),
+		rng:   rng,
+	}
+	for cell, value := range s.cells {
+		if value == 0 {
+			continue
+		}
+		bit := uint16(1) << value
+		r, c, b := cell/n, cell%n, sudokuBoxOf(n, cell)
+		if (s.rows[r]|s.cols[c]|s.boxes[b])&bit != 0 {
+			return nil, false
+		}
+		s.rows[r] |= bit
+		s.cols[c] |= bit
+		s.boxes[b] |= bit
+	}
+	return s, true
+}
+
+// solve counts solutions up to limit, keeping the first one found
+func (s *sudokuSolver) solve(limit int) int {
+	s.limit = limit
+	s.solutions = 0
+	s.search()
+	return s.solutions
+}
+
+func (s *sudokuSolver) search() {
+	// Fill the most constrained empty cell first
+	best, bestCount := -1, s.n+1
+	var bestMask uint16
+	all := uint16(1<<(s.n+1)) - 2
+	for cell, value := range s.cells {
+		if value != 0 {
+			continue
+		}
+		mask := all &^ (s.rows[cell/s.n] | s.cols[cell%s.n] | s.boxes[sudokuBoxOf(s.n, cell)])
+		count := bits.OnesCount16(mask)
+		if count < bestCount {
+			best, bestCount, bestMask = cell, count, mask
+			if count <= 1 {
+				break
+			}
+		}
+	}
+	if best < 0 {
+		s.solutions++
+		if s.first == nil {
+			s.first = append([]int(nil), s.cells...)
+		}
+		return
+	}
+
+	values := make([]int, 0, bestCount)
+	for v := 1; v <= s.n; v++ {
+		if bestMask&(1<<v) != 0 {
+			values = append(values, v)
+		}
+	}
+	if s.rng != nil {
+		s.rng.Shuffle(len(values), func(i, j int) { values[i], values[j] = values[j], values[i] })
+	}
+
+	r, c, b := best/s.n, best%s.n, sudokuBoxOf(s.n, best)
+	for _, v := range values {
+		bit := uint16(1) << v
+		s.cells[best] = v
+		s.rows[r] |= bit
+		s.cols[c] |= bit
+		s.boxes[b] |= bit
+		s.search()
+		s.rows[r] &^= bit
+		s.cols[c] &^= bit
+		s.boxes[b] &^= bit
+		s.cells[best] = 0
+		if s.solutions >= s.limit {
+			return
+		}
+	}
+}
+
+// sudokuCandidates returns, for each empty cell, a bitmask of the numbers
+// not yet in its row, column or box
+func sudokuCandidates(cells []int, n int) []uint16 {
+	all := uint16(1<<(n+1)) - 2
+	used := make([]uint16, len(sudokuUnitsBySize[n]))
+	for i, unit := range sudokuUnitsBySize[n] {
+		for _, cell := range unit.cells {
+			if cells[cell] != 0 {
+				used[i] |= 1 << cells[cell]
+			}
+		}
+	}
+	candidates := make([]uint16, n*n)
+	for cell, value := range cells {
+		if value != 0 {
+			continue
+		}
+		r, c, b := cell/n, cell%n, sudokuBoxOf(n, cell)
+		candidates[cell] = all &^ (used[r] | used[n+c] | used[2*n+b])
+	}
+	return candidates
+}
+
+// placeSudokuValue fills a cell and removes the value from its peers' candidates
+func placeSudokuValue(cells []int, candidates []uint16, n, cell, value int) {
+	cells[cell] = value
+	candidates[cell] = 0
+	bit := uint16(1) << value
+	r, c, b := cell/n, cell%n, sudokuBoxOf(n, cell)
+	for _, unit := range []int{r, n + c, 2*n + b} {
+		for _, peer := range sudokuUnitsBySize[n][unit].cells {
+			candidates[peer] &^= bit
+		}
+	}
+}
+
+// findSudokuSingle looks for a naked single, then a hidden single
+func findSudokuSingle(cells []int, candidates []uint16, n int) (sudokuStep, bool) {
+	for cell, mask := range candidates {
+		if cells[cell] == 0 && bits.OnesCount16(mask) == 1 {
+			return sudokuStep{cell: cell, value: bits.TrailingZeros16(mask), technique: sudokuNakedSingle}, true
+		}
+	}
+	for _, unit := range sudokuUnitsBySize[n] {
+		for v := 1; v <= n; v++ {
+			bit := uint16(1) << v
+			place, count := -1, 0
+			for _, cell := range unit.cells {
+				if candidates[cell]&bit != 0 {
+					place = cell
+					count++
+				}
+			}
+			if count == 1 {
+				return sudokuStep{cell: place, value: v, technique: sudokuHiddenSingle, unit: unit}, true
+			}
+		}
+	}
+	return sudokuStep{}, false
+}
+
+// eliminateSudokuCandidates rules candidates out with pointing numbers
+// (a number confined to one line within a box, or one box within a line)
+// and naked pairs. It reports whether anything was removed.
+func eliminateSudokuCandidates(candidates []uint16, n int) bool {
+	units := sudokuUnitsBySize[n]
+	changed := false
+
+	// A number whose places in one unit all lie in a second unit can't go
+	// anywhere else in the second unit
+	for i, unit := range units {
+		for v := 1; v <= n; v++ {
+			bit := uint16(1) << v
+			var places []int
+			for _, cell := range unit.cells {
+				if candidates[cell]&bit != 0 {
+					places = append(places, cell)
+				}
+			}
+			if len(places) < 2 {
+				continue
+			}
+			for j, other := range units {
+				if j == i || !sudokuUnitContainsAll(other, places) {
+					continue
+				}
+				for _, cell := range other.cells {
+					if candidates[cell]&bit != 0 && !sudokuUnitContains(unit, cell) {
+						candidates[cell] &^= bit
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	// Two cells in a unit with the same two candidates take both numbers
+	for _, unit := range units {
+		for a := 0; a < len(unit.cells); a++ {
+			pair := candidates[unit.cells[a]]
+			if bits.OnesCount16(pair) != 2 {
+				continue
+			}
+			for b := a + 1; b < len(unit.cells); b++ {
+				if candidates[unit.cells[b]] != pair {
+					continue
+				}
+				for _, cell := range unit.cells {
+					if cell != unit.cells[a] && cell != unit.cells[b] && candidates[cell]&pair != 0 {
+						candidates[cell] &^= pair
+						changed = true
+					}
+				}
+			}
+		}
+	}
+	return changed
+}
+
+func sudokuUnitContains(unit sudokuUnit, cell int) bool {
+	for _, c := range unit.cells {
+		if c == cell {
+			return true
+		}
+	}
+	return false
+}
+
+func sudokuUnitContainsAll(unit sudokuUnit, cells []int) bool {
+	for _, cell := range cells {
+		if !sudokuUnitContains(unit, cell) {
+			return false
+		}
+	}
+	return true
+}
+
+// gradeSudoku solves by logic alone and returns the hardest technique it
+// needed, or sudokuTrialAndError when logic gets stuck
+func gradeSudoku(cells []int, n int) int {
+	work := append([]int(nil), cells...)
+	candidates := sudokuCandidates(work, n)
+	grade := sudokuNakedSingle
+	for {
+		complete := true
+		for _, value := range work {
+			if value == 0 {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return grade
+		}
+
+		if step, ok := findSudokuSingle(work, candidates, n); ok {
+			placeSudokuValue(work, candidates, n, step.cell, step.value)
+			grade = max(grade, step.technique)
+			continue
+		}
+		if eliminateSudokuCandidates(candidates, n) {
+			grade = max(grade, sudokuLockedCandidate)
+			continue
+		}
+		return sudokuTrialAndError
+	}
+}
+
+// GenerateSudoku makes a puzzle with one solution, as close to the asked
+// difficulty as a few tries allow
+func (g *YohakuGenerator) GenerateSudoku(settings SudokuSettings, level int) SudokuPuzzle {
+	n := settings.Size
+	target := sudokuDifficultyIndex(settings.Difficulty)
+
+	var best []int
+	bestGrade := -1
+	for try := 0; try < sudokuGenerateTries && bestGrade < target; try++ {
+		cells := g.removeSudokuClues(g.fillSudoku(n), n, target)
+		if grade := max(gradeSudoku(cells, n), sudokuClueGrade(cells, n)); grade > bestGrade {
+			best, bestGrade = cells, grade
+		}
+	}
+
+	clues := 0
+	for _, value := range best {
+		if value != 0 {
+			clues++
+		}
+	}
+	timer := settings.TimerDuration
+	if settings.Untimed {
+		timer = 0
+	} else if timer == 0 {
+		timer = sudokuTimers[n][bestGrade]
+	}
+	return SudokuPuzzle{
+		ID:            fmt.Sprintf("sudoku_%d_%d", time.Now().UnixNano(), level),
+		Size:          n,
+		BoxSize:       sudokuBoxSize(n),
+		Grid:          unflattenSudoku(best, n),
+		Clues:         clues,
+		Difficulty:    sudokuDifficulties[bestGrade],
+		TimerDuration: timer,
+		Level:         level,
+		Score:         sudokuScore(n, bestGrade, level),
+	}
+}
+
+// fillSudoku returns a random complete grid
+func (g *YohakuGenerator) fillSudoku(n int) []int {
+	solver, _ := newSudokuSolver(make([]int, n*n), n, g.rand)
+	solver.solve(1)
+	return solver.first
+}
+
+// removeSudokuClues empties cells in random order, keeping each removal
+// only if the puzzle stays unique and no harder than target
+func (g *YohakuGenerator) removeSudokuClues(solution []int, n, target int) []int {
+	cells := append([]int(nil), solution...)
+	clues := len(cells)
+	for _, cell := range g.rand.Perm(len(cells)) {
+		if clues <= sudokuMinClues[n][target] {
+			break
+		}
+		value := cells[cell]
+		cells[cell] = 0
+		solver, _ := newSudokuSolver(cells, n, nil)
+		if solver.solve(2) != 1 || (target < sudokuTrialAndError && gradeSudoku(cells, n) > target) {
+			cells[cell] = value
+			continue
+		}
+		clues--
+	}
+	return cells
+}
+
+// sudokuClueGrade is the hardest grade whose minimum clue count the puzzle
+// is down to
+func sudokuClueGrade(cells []int, n int) int {
+	clues := 0
+	for _, value := range cells {
+		if value != 0 {
+			clues++
+		}
+	}
+	grade := 0
+	for d, minClues := range sudokuMinClues[n] {
+		if clues <= minClues {
+			grade = d
+		}
+	}
+	return grade
+}
+
+func sudokuDifficultyIndex(difficulty string) int {
+	for i, name := range sudokuDifficulties {
+		if name == difficulty {
+			return i
+		}
+	}
+	return -1
+}
+
+func sudokuScore(n, grade, level int) int {
+	sizeMultiplier := 1
+	if n == 9 {
+		sizeMultiplier = 4
+	}
+	return 100*sizeMultiplier*(grade+1) + level*10
+}
+
+// normalizeSudokuSettings fills in defaults and returns a problem
+// description when the settings can't be used
+func normalizeSudokuSettings(settings *SudokuSettings) string {
+	if settings.Size == 0 {
+		settings.Size = 9
+	}
+	if settings.Size != 4 && settings.Size != 9 {
+		return "size must be 4 or 9"
+	}
+	if settings.Difficulty == "" {
+		settings.Difficulty = "easy"
+	}
+	index := sudokuDifficultyIndex(settings.Difficulty)
+	if index < 0 {
+		return "difficulty must be easy, medium, hard or expert"
+	}
+	if index > sudokuMaxDifficulty[settings.Size] {
+		return fmt.Sprintf("%dx%d puzzles go up to %s", settings.Size, settings.Size, sudokuDifficulties[sudokuMaxDifficulty[settings.Size]])
+	}
+	if settings.TimerDuration < 0 {
+		return "timerDuration must not be negative"
+	}
+	return ""
+}
+
+// GenerateSudokuGameSession starts at the chosen difficulty and steps up
+// every two puzzles, as far as the size allows
+func (g *YohakuGenerator) GenerateSudokuGameSession(base SudokuSettings) SudokuGameSession {
+	session := SudokuGameSession{
+		ID:        fmt.Sprintf("session_%d", time.Now().UnixNano()),
+		Puzzles:   make([]SudokuPuzzle, sudokuGamePuzzles),
+		StartTime: time.Now(),
+		Settings:  base,
+	}
+	start := sudokuDifficultyIndex(base.Difficulty)
+	for i := 0; i < sudokuGamePuzzles; i++ {
+		settings := base
+		settings.Difficulty = sudokuDifficulties[min(start+i/2, sudokuMaxDifficulty[base.Size])]
+		session.Puzzles[i] = g.GenerateSudoku(settings, i+1)
+	}
+	return session
+}
+
+func (h *PuzzleHub) generateSudoku(c *gin.Context) {
+	var settings SudokuSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if problem := normalizeSudokuSettings(&settings); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+	h.accessibilityFor(c).applyToSudoku(&settings)
+
+	puzzle := h.YohakuGenerator.GenerateSudoku(settings, 1)
+	c.JSON(http.StatusOK, gin.H{
+		"puzzle":   puzzle,
+		"settings": settings,
+	})
+}
+
+func (h *PuzzleHub) startSudokuGame(c *gin.Context) {
+	var settings SudokuSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if problem := normalizeSudokuSettings(&settings); problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+	h.accessibilityFor(c).applyToSudoku(&settings)
+
+	seed := newGameSeed()
+	session := seededGenerator(seed).GenerateSudokuGameSession(settings)
+	session.Seed = seed
+	c.JSON(http.StatusOK, gin.H{
+		"session": session,
+		"message": tr(c, "Game session created with %d sudoku puzzles!", sudokuGamePuzzles),
+	})
+}
+
+// validateSudoku checks a finished grid against the rules and the puzzle's
+// givens, pointing out conflicting cells
+func (h *PuzzleHub) validateSudoku(c *gin.Context) {
+	var request ValidateSudokuRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	givens, n, problem := flattenSudoku(request.Puzzle)
+	if problem != "" {
+		respondError(c, http.StatusBadRequest, "puzzle: "+problem)
+		return
+	}
+	cells, size, problem := flattenSudoku(request.Grid)
+	if problem != "" || size != n {
+		if problem == "" {
+			problem = "Grid must be the same size as the puzzle"
+		}
+		respondError(c, http.StatusBadRequest, "grid: "+problem)
+		return
+	}
+
+	changed := []SudokuCell{}
+	empty := 0
+	for cell, given := range givens {
+		if given != 0 && cells[cell] != given {
+			changed = append(changed, SudokuCell{Row: cell / n, Col: cell % n})
+		}
+		if cells[cell] == 0 {
+			empty++
+		}
+	}
+	conflicts := sudokuConflicts(cells, n)
+
+	valid := len(changed) == 0 && len(conflicts) == 0 && empty == 0
+	message := "Puzzle solved correctly!"
+	switch {
+	case len(changed) > 0:
+		message = "Some of the starting numbers have been changed"
+	case len(conflicts) > 0:
+		message = "Some numbers repeat in a row, column or box"
+	case empty > 0:
+		message = fmt.Sprintf("%d cells are still empty", empty)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"valid":     valid,
+		"message":   message,
+		"conflicts": conflicts,
+		"changed":   changed,
+		"empty":     empty,
+	})
+}
+
+// sudokuHint explains the easiest next step from the player's grid,
+// falling back to revealing a cell when logic alone won't get there
+func (h *PuzzleHub) sudokuHint(c *gin.Context) {
+	var request SudokuHintRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	cells, n, problem := flattenSudoku(request.Grid)
+	if problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+
+	if conflicts := sudokuConflicts(cells, n); len(conflicts) > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"hint":      tr(c, "Some numbers repeat in a row, column or box. Fix the highlighted cells first!"),
+			"technique": "conflict",
+			"conflicts": conflicts,
+			"source":    sourceFallback,
+		})
+		return
+	}
+
+	candidates := sudokuCandidates(cells, n)
+	eliminated := false
+	for {
+		if step, ok := findSudokuSingle(cells, candidates, n); ok {
+			hint, technique := sudokuStepHint(step, n, requestLanguage(c)), step.technique
+			if eliminated {
+				hint = tr(c, "Rule out numbers using pairs and numbers stuck in one line of a box.") + " " + hint
+				technique = sudokuLockedCandidate
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"hint":      hint,
+				"technique": sudokuTechniques[technique],
+				"cell":      SudokuCell{Row: step.cell / n, Col: step.cell % n},
+				"value":     step.value,
+				"source":    sourceFallback,
+			})
+			return
+		}
+		if !eliminateSudokuCandidates(candidates, n) {
+			break
+		}
+		eliminated = true
+	}
+
+	solver, _ := newSudokuSolver(cells, n, nil)
+	if solver.solve(1) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"hint":      tr(c, "The grid can't be finished from here. One of your numbers must be wrong, so try checking your work!"),
+			"technique": "stuck",
+			"source":    sourceFallback,
+		})
+		return
+	}
+	for cell, value := range cells {
+		if value != 0 {
+			continue
+		}
+		row, col := cell/n, cell%n
+		c.JSON(http.StatusOK, gin.H{
+			"hint":      tr(c, "This one is tricky! Try a %d in row %d, column %d.", solver.first[cell], row+1, col+1),
+			"technique": sudokuTechniques[sudokuTrialAndError],
+			"cell":      SudokuCell{Row: row, Col: col},
+			"value":     solver.first[cell],
+			"source":    sourceFallback,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"hint":   tr(c, "The grid is full. Check it to see if you've solved it!"),
+		"source": sourceFallback,
+	})
+}
+
+// sudokuHiddenSingleHints is the hint for a value with one place in each
+// kind of unit, whole sentences so each translates naturally
+var sudokuHiddenSingleHints = map[string]string{
+	"row":    "In row %d, a %d can only go in one place: row %d, column %d.",
+	"column": "In column %d, a %d can only go in one place: row %d, column %d.",
+	"box":    "In box %d, a %d can only go in one place: row %d, column %d.",
+}
+
+func sudokuStepHint(step sudokuStep, n int, language string) string {
+	row, col := step.cell/n+1, step.cell%n+1
+	if step.technique == sudokuHiddenSingle {
+		return localize(language, sudokuHiddenSingleHints[step.unit.kind], step.unit.number, step.value, row, col)
+	}
+	return localize(language, "Row %d, column %d can only be %d. Every other number is already in its row, column or box.", row, col, step.value)
+}
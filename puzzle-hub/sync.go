@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// Offline sync for the logging system.
+//
+// Every write to a log entry (from the REST handlers or from a sync push)
+// appends a record to a per-user change feed. The store numbers each record
+// from a per-user counter in the same write, so the feed has no gaps and
+// commits in order. Clients remember the last sequence they saw as their
+// sync token and pull everything after it. Pushed changes carry the version
+// the client last saw; when that is stale the change is returned as a
+// conflict with the server's entry for the client to merge and push again.
+
+const (
+	syncOpUpsert = "upsert"
+	syncOpDelete = "delete"
+
+	syncPageSize = 500
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+type SyncChange struct {
+	UserID    string    `json:"-" dynamodbav:"user_id"`
+	Seq       string    `json:"seq" dynamodbav:"seq"`
+	Op        string    `json:"op" dynamodbav:"op"` // "upsert" or "delete"
+	EntryID   string    `json:"entry_id" dynamodbav:"entry_id"`
+	Version   int64     `json:"version" dynamodbav:"version"`
+	Entry     *LogEntry `json:"entry,omitempty" dynamodbav:"entry,omitempty"` // Snapshot for upserts
+	ChangedAt time.Time `json:"changed_at" dynamodbav:"changed_at"`
+}
+
+type SyncPushChange struct {
+	Op          string                 `json:"op" binding:"required"`
+	ID          string                 `json:"id" binding:"required"` // Client-generated UUID for new entries
+	LogTypeID   string                 `json:"log_type_id"`
+	EntryDate   string                 `json:"entry_date"`
+	Values      map[string]interface{} `json:"values"`
+	BaseVersion int64                  `json:"base_version"` // Server version the client last saw, 0 for new entries
+}
+
+type SyncRequest struct {
+	SyncToken string           `json:"sync_token"`
+	Changes   []SyncPushChange `json:"changes"`
+}
+
+type SyncApplied struct {
+	ID      string `json:"id"`
+	Op      string `json:"op"`
+	Version int64  `json:"version"`
+}
+
+type SyncConflict struct {
+	ID          string    `json:"id"`
+	Reason      string    `json:"reason"`
+	ServerEntry *LogEntry `json:"server_entry,omitempty"`
+}
+
+// syncLogEntries applies pushed offline changes and returns the change feed
+// since the client's sync token.
+func (h *PuzzleHub) syncLogEntries(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	var request SyncRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	if len(request.Changes) > syncPageSize {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("At most %d changes can be pushed per request", syncPageSize))
+		return
+	}
+
+	applied := []SyncApplied{}
+	conflicts := []SyncConflict{}
+	allowedLogTypes := make(map[string]bool)
+
+	for _, change := range request.Changes {
+		result, conflict := h.applySyncChange(userObj, change, allowedLogTypes)
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+			continue
+		}
+		applied = append(applied, *result)
+	}
+
+	changes, nextToken, hasMore, err := h.getSyncChanges(userObj.ID, request.SyncToken)
+	if err != nil {
+		requestLogger(c).Error("error reading sync changes", "error", err)
+		respondStorageError(c, err, "Failed to read changes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"applied":    applied,
+		"conflicts":  conflicts,
+		"changes":    changes,
+		"sync_token": nextToken,
+		"has_more":   hasMore,
+	})
+}
+
+// getSyncChangesHandler is the pull-only variant of syncLogEntries
+func (h *PuzzleHub) getSyncChangesHandler(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+	userObj := user.(*User)
+
+	changes, nextToken, hasMore, err := h.getSyncChanges(userObj.ID, c.Query("since"))
+	if err != nil {
+		requestLogger(c).Error("error reading sync changes", "error", err)
+		respondStorageError(c, err, "Failed to read changes")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"changes":    changes,
+		"sync_token": nextToken,
+		"has_more":   hasMore,
+	})
+}
+
+// applySyncChange applies one pushed change, returning either the applied
+// result or a conflict describing why it was rejected.
+func (h *PuzzleHub) applySyncChange(user *User, change SyncPushChange, allowedLogTypes map[string]bool) (*SyncApplied, *SyncConflict) {
+	reject := func(reason string, serverEntry *LogEntry) (*SyncApplied, *SyncConflict) {
+		return nil, &SyncConflict{ID: change.ID, Reason: reason, ServerEntry: serverEntry}
+	}
+
+	if change.Op != syncOpUpsert && change.Op != syncOpDelete {
+		return reject("invalid op", nil)
+	}
+
+	existing, status, err := h.getOwnedLogEntry(user.ID, change.ID)
+	switch {
+	case status == http.StatusForbidden:
+		return reject("entry belongs to another user", nil)
+	case status == http.StatusNotFound:
+		existing = nil
+	case err != nil:
+		return reject("server error", nil)
+	}
+
+	if existing != nil && change.BaseVersion != existing.Version {
+		return reject("stale version", existing)
+	}
+
+	if change.Op == syncOpDelete {
+		if existing == nil {
+			// Already gone; report success so the client can drop it
+			return &SyncApplied{ID: change.ID, Op: syncOpDelete}, nil
+		}
+
+		if err := h.Store.DeleteLogEntryIfVersion(change.ID, existing.Version); err != nil {
+			if err == errStorageConflict {
+				return reject("concurrent modification", nil)
+			}
+			log.Printf("Error deleting synced log entry: %v", err)
+			return reject("server error", nil)
+		}
+
+		h.recordSyncChange(user.ID, syncOpDelete, existing.ID, existing.Version+1, nil)
+		h.adjustLogAggregates(existing, -1)
+		return &SyncApplied{ID: change.ID, Op: syncOpDelete, Version: existing.Version + 1}, nil
+	}
+
+	if existing == nil && !uuidPattern.MatchString(change.ID) {
+		return reject("new entries must use a UUID id", nil)
+	}
+
+	if _, err := time.Parse("2006-01-02", change.EntryDate); err != nil {
+		return reject("invalid entry_date", nil)
+	}
+
+	logTypeID := change.LogTypeID
+	if existing != nil && logTypeID == "" {
+		logTypeID = existing.LogTypeID
+	}
+	if !allowedLogTypes[logTypeID] {
+		if _, _, err := h.getSharedLogType(user.ID, logTypeID); err != nil {
+			return reject("unknown log type", nil)
+		}
+		allowedLogTypes[logTypeID] = true
+	}
+
+	values := change.Values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	fields, err := h.getLogFields(logTypeID)
+	if err != nil {
+		return reject("server error", nil)
+	}
+	if err := validateLogEntryValues(fields, values); err != nil {
+		return reject(err.Error(), nil)
+	}
+
+	now := time.Now()
+	entry := LogEntry{
+		ID:        change.ID,
+		LogTypeID: logTypeID,
+		UserID:    user.ID,
+		EntryDate: change.EntryDate,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Values:    values,
+		Version:   1,
+	}
+
+	if existing == nil {
+		err = h.Store.CreateLogEntry(&entry)
+	} else {
+		entry.CreatedAt = existing.CreatedAt
+		entry.Version = existing.Version + 1
+		err = h.Store.PutLogEntryIfVersion(&entry, existing.Version)
+	}
+	if err != nil {
+		if err == errStorageConflict {
+			return reject("concurrent modification", nil)
+		}
+		log.Printf("Error putting synced log entry: %v", err)
+		return reject("server error", nil)
+	}
+
+	h.recordSyncChange(user.ID, syncOpUpsert, entry.ID, entry.Version, &entry)
+	if existing != nil {
+		h.adjustLogAggregates(existing, -1)
+	}
+	h.adjustLogAggregates(&entry, 1)
+	return &SyncApplied{ID: entry.ID, Op: syncOpUpsert, Version: entry.Version}, nil
+}
+
+// recordSyncChange appends a change to the user's feed. Failures are logged
+// rather than returned: the entry write already succeeded and a missed feed
+// record only delays other devices until their next full refresh.
+func (h *PuzzleHub) recordSyncChange(userID, op, entryID string, version int64, entry *LogEntry) {
+	change := SyncChange{
+		UserID:    userID,
+		Op:        op,
+		EntryID:   entryID,
+		Version:   version,
+		Entry:     entry,
+		ChangedAt: time.Now(),
+	}
+
+	if err := h.Store.PutSyncChange(&change); err != nil {
+		log.Printf("Error recording sync change for entry %s: %v", entryID, err)
+	}
+}
+
+// getSyncChanges returns up to syncPageSize changes after the given token,
+// the token to use next time, and whether more changes are waiting.
+func (h *PuzzleHub) getSyncChanges(userID, since string) ([]SyncChange, string, bool, error) {
+	changes, hasMore, err := h.Store.ListSyncChanges(userID, since, syncPageSize)
+	if err != nil {
+		return nil, since, false, err
+	}
+
+	nextToken := since
+	if len(changes) > 0 {
+		nextToken = changes[len(changes)-1].Seq
+	}
+	return changes, nextToken, hasMore, nil
+}
+
+// syncSeq formats the nth change in a user's feed. The prefix sorts these
+// after the clock-based sequences written before feeds were numbered, so
+// tokens issued back then keep working.
+func syncSeq(n int64) string {
+	return fmt.Sprintf("s%019d", n)
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing
+//
+// Spans are exported over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set, e.g. http://localhost:4318;
+// tracing is off otherwise. The standard OTEL_* variables apply, such as
+// OTEL_SERVICE_NAME (default puzzle-hub), OTEL_TRACES_SAMPLER and
+// OTEL_EXPORTER_OTLP_HEADERS. Incoming traceparent headers are honoured.
+//
+// Each request gets a server span named after its route. AI features add
+// spans for the cache lookup, prompt build, each provider attempt and
+// parsing the reply, and every AWS call gets a client span. Storage methods
+// don't take a context, so their DynamoDB calls show up as separate traces.
+
+var tracer = otel.Tracer("puzzle-hub")
+
+// awsSpanKey holds the span of an AWS request in its context
+type awsSpanKey struct{}
+
+// setupTracing installs the OTLP exporter when one is configured. The
+// returned func flushes buffered spans on shutdown.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default name
+	resource, err := sdkresource.New(ctx,
+		sdkresource.WithAttributes(attribute.String("service.name", "puzzle-hub")),
+		sdkresource.WithFromEnv(),
+		sdkresource.WithTelemetrySDK(),
+		sdkresource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("🔭 Exporting traces over OTLP")
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts the server span for a request and puts it on
+// the request context, so handlers' spans become its children
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("request_id", c.GetString("request_id")),
+			),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if user, ok := c.Get("user"); ok {
+			span.SetAttributes(attribute.String("user.id", user.(*User).ID))
+		}
+		if status >= 500 {
+			span.SetStatus(codes.Error, c.Errors.String())
+		}
+	}
+}
+
+// endSpan records err, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// aiSpanAttributes describes an AI call on its spans
+func aiSpanAttributes(call aiCall, provider string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("ai.feature", call.Feature),
+		attribute.String("ai.provider", provider),
+	}
+	if call.Prompt.Experiment != "" {
+		attrs = append(attrs,
+			attribute.String("ai.prompt_experiment", call.Prompt.Experiment),
+			attribute.String("ai.prompt_variant", call.Prompt.Variant),
+		)
+	}
+	return attrs
+}
+
+// traceAWSRequests gives every AWS call made through sess a client span,
+// parented to the context passed to the *WithContext API methods
+func traceAWSRequests(sess *session.Session) {
+	// Build runs once per call; Send and Sign run again on each retry
+	sess.Handlers.Build.PushFrontNamed(request.NamedHandler{
+		Name: "puzzlehub.tracing.Start",
+		Fn: func(r *request.Request) {
+			attrs := []attribute.KeyValue{
+				attribute.String("rpc.system", "aws-api"),
+				attribute.String("rpc.service", r.ClientInfo.ServiceID),
+				attribute.String("rpc.method", r.Operation.Name),
+			}
+			if table := awsTableName(r.Params); table != "" {
+				attrs = append(attrs, attribute.String("aws.dynamodb.table_names", table))
+			}
+			ctx, span := tracer.Start(r.Context(), r.ClientInfo.ServiceID+"."+r.Operation.Name,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attrs...),
+			)
+			r.SetContext(context.WithValue(ctx, awsSpanKey{}, span))
+		},
+	})
+	sess.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "puzzlehub.tracing.End",
+		Fn: func(r *request.Request) {
+			span, ok := r.Context().Value(awsSpanKey{}).(trace.Span)
+			if !ok {
+				return // Failed validation before Build
+			}
+			if r.HTTPResponse != nil && r.HTTPResponse.StatusCode != 0 {
+				span.SetAttributes(attribute.Int("http.response.status_code", r.HTTPResponse.StatusCode))
+			}
+			span.SetAttributes(attribute.Int("aws.retries", r.RetryCount))
+			endSpan(span, r.Error)
+		},
+	})
+}
+
+// awsTableName returns the TableName field of a DynamoDB input, if any
+func awsTableName(params interface{}) string {
+	value := reflect.Indirect(reflect.ValueOf(params))
+	if value.Kind() != reflect.Struct {
+		return ""
+	}
+	field := value.FieldByName("TableName")
+	if !field.IsValid() {
+		return ""
+	}
+	if name, ok := field.Interface().(*string); ok {
+		return aws.StringValue(name)
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/gin-gonic/gin"
+)
+
+// Visitor identification for analytics
+//
+// Each browser gets a random first-party cookie, so a classroom of kids
+// behind one school IP still counts as separate visitors. The cookie value
+// is never stored: analytics only sees HMAC(salt, cookie), and the salt is
+// replaced every month and deleted once that month is over, so stored
+// visitor IDs can't be linked across months or back to a browser. Unique
+// visitors are therefore counted once per visitor per month.
+
+const (
+	visitorCookieName   = "ph_vid"
+	visitorCookieMaxAge = 365 * 24 * 60 * 60
+)
+
+// visitorID returns the hashed analytics ID for this browser, issuing the
+// visitor cookie on first visit. Returns "" if no salt is available.
+func (h *PuzzleHub) visitorID(c *gin.Context) string {
+	raw, err := c.Cookie(visitorCookieName)
+	if err != nil || len(raw) != 32 {
+		raw, err = randomToken(16)
+		if err != nil {
+			requestLogger(c).Error("error generating visitor cookie", "error", err)
+			return ""
+		}
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     visitorCookieName,
+			Value:    raw,
+			Path:     "/",
+			MaxAge:   visitorCookieMaxAge,
+			HttpOnly: true,
+			Secure:   h.AuthConfig != nil && h.AuthConfig.SecureCookies,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	id, err := h.Analytics.hashVisitor(raw, time.Now())
+	if err != nil {
+		requestLogger(c).Warn("failed to hash visitor ID", "error", err)
+		return ""
+	}
+	return id
+}
+
+// hashVisitor keys the raw ID with the current month's salt
+func (a *AnalyticsService) hashVisitor(raw string, now time.Time) (string, error) {
+	salt, err := a.visitorSalt(now.UTC().Format("2006-01"))
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(raw))
+	return hex.EncodeToString(mac.Sum(nil))[:32], nil
+}
+
+// visitorSalt returns the salt for a month, creating it on first use. The
+// salt is shared through DynamoDB so restarts and other instances agree,
+// and expires a day after its month ends.
+func (a *AnalyticsService) visitorSalt(period string) ([]byte, error) {
+	a.saltMu.Lock()
+	defer a.saltMu.Unlock()
+	if salt, ok := a.salts[period]; ok {
+		return salt, nil
+	}
+
+	start, err := time.Parse("2006-01", period)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := start.AddDate(0, 1, 1).Unix()
+
+	fresh, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	_, err = a.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-analytics-salts")),
+		Item: map[string]*dynamodb.AttributeValue{
+			"period":     {S: aws.String(period)},
+			"salt":       {S: aws.String(fresh)},
+			"expires_at": {N: aws.String(strconv.FormatInt(expiresAt, 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(period)"),
+	})
+	saltHex := fresh
+	if errors.Is(err, errDynamoDBOffline) {
+		// Nothing to share it through, so it lives as long as the process
+		err = nil
+	}
+	if err != nil {
+		if !isConditionalCheckFailed(err) {
+			return nil, fmt.Errorf("failed to store visitor salt: %v", err)
+		}
+		// Another instance created it first; use theirs
+		result, err := a.db.GetItem(&dynamodb.GetItemInput{
+			TableName: aws.String(tableName("puzzle-hub-analytics-salts")),
+			Key: map[string]*dynamodb.AttributeValue{
+				"period": {S: aws.String(period)},
+			},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch visitor salt: %v", err)
+		}
+		if result.Item == nil || result.Item["salt"] == nil {
+			return nil, fmt.Errorf("visitor salt for %s not found", period)
+		}
+		saltHex = aws.StringValue(result.Item["salt"].S)
+	}
+
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid visitor salt: %v", err)
+	}
+
+	// Only the current month is ever needed again
+	for p := range a.salts {
+		delete(a.salts, p)
+	}
+	a.salts[period] = salt
+	return salt, nil
+}
+
+// trustedProxies parses TRUSTED_PROXIES (comma-separated IPs or CIDRs). With
+// none configured, ClientIP uses the connection's remote address and ignores
+// X-Forwarded-For, which can't be spoofed but shows the proxy's address when
+// deployed behind one (e.g. on Render, set it to the platform's proxy range).
+func trustedProxies() []string {
+	var proxies []string
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				log.Printf("⚠️  Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+				continue
+			}
+		}
+		proxies = append(proxies, entry)
+	}
+	return proxies
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// Webhook client
+//
+// Classroom and results webhooks POST to URLs users choose, so they get
+// their own HTTP client rather than h.HTTPClient. Checking the URL when it
+// is saved isn't enough: a public name can resolve to 169.254.169.254 or a
+// 10.x address, or later be changed to. The client checks the address it
+// actually connects to and refuses loopback, private, link-local and other
+// non-public ranges. It doesn't follow redirects, which could lead
+// anywhere, and ignores HTTP_PROXY, which would hide the real address.
+
+const webhookTimeout = 15 * time.Second
+
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// nonPublicPrefixes are ranges netip doesn't classify but a webhook must
+// not reach: carrier-grade NAT, "this network" and the IPv6 NAT64 prefix
+// that maps onto IPv4
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// isPublicAddress reports whether a webhook may connect to addr
+func isPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookDial runs before each connection, once the name is resolved
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errWebhookAddress, address)
+	}
+	if !isPublicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", errWebhookAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// newWebhookClient returns the client webhook deliveries go through
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: checkWebhookDial,
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return fmt.Errorf("webhook redirected to %s, redirects are not followed", req.URL.Redacted())
+		},
+	}
+}
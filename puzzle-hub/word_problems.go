@@ -0,0 +1,690 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Math word problems
+//
+// Problems are written for a grade (1-8) and one of wordProblemThemes,
+// by AI when it's available and from built-in templates otherwise. Every
+// problem comes with an arithmetic expression ("3 * 12 - 5") as well as
+// its answer; AI problems whose expression doesn't work out to the answer
+// are rejected, so a wrong answer key never reaches a player.
+//
+// Answers stay on the server (puzzle-hub-word-problems, kept 30 days).
+// Players submit a number and are told whether it's right; the answer is
+// shown once they get it or after maxWordProblemAttempts tries. On request
+// AI explains the solution step by step; the explanation is saved with the
+// problem, and the expression's steps stand in when AI is unavailable.
+
+const (
+	defaultWordProblems    = 5
+	maxWordProblems        = 10
+	maxWordProblemAttempts = 3
+	wordProblemRetention   = 30 * 24 * time.Hour
+	maxExpressionLength    = 200
+	answerTolerance        = 0.005 // Answers are given to the cent at most
+)
+
+// wordProblemTheme supplies the nouns templated problems are made of
+type wordProblemTheme struct {
+	Place string
+	Items []string // Countable, plural
+	Goods []string // Things with a price, singular
+}
+
+var wordProblemThemes = map[string]wordProblemTheme{
+	"everyday": {Place: "school", Items: []string{"pencils", "books", "stickers", "crayons"}, Goods: []string{"notebook", "backpack", "lunch box"}},
+	"animals":  {Place: "zoo", Items: []string{"penguins", "parrots", "bananas", "fish"}, Goods: []string{"stuffed tiger", "zoo ticket", "bag of animal feed"}},
+	"space":    {Place: "space station", Items: []string{"rockets", "stars", "moon rocks", "astronauts"}, Goods: []string{"model rocket", "telescope", "planet poster"}},
+	"sports":   {Place: "stadium", Items: []string{"footballs", "medals", "players", "goals"}, Goods: []string{"team shirt", "water bottle", "basketball"}},
+	"food":     {Place: "bakery", Items: []string{"cupcakes", "cookies", "apples", "muffins"}, Goods: []string{"pizza", "sandwich", "smoothie"}},
+	"shopping": {Place: "market", Items: []string{"oranges", "toys", "marbles", "balloons"}, Goods: []string{"toy car", "puzzle", "board game"}},
+	"nature":   {Place: "park", Items: []string{"leaves", "flowers", "acorns", "ducks"}, Goods: []string{"bird feeder", "plant pot", "pack of seeds"}},
+}
+
+var wordProblemNames = []string{"Maya", "Leo", "Sam", "Aisha", "Noah", "Priya", "Ben", "Zoe", "Omar", "Lily"}
+
+type WordProblemRequest struct {
+	Grade int    `json:"grade" binding:"required,min=1,max=8"`
+	Theme string `json:"theme"` // One of wordProblemThemes, default everyday
+	Count int    `json:"count"` // Default 5, at most 10
+
+	SimplifiedLanguage bool `json:"simplified_language,omitempty"` // Also set by the player's preferences
+}
+
+// WordProblem is a problem as players see it, without the answer
+type WordProblem struct {
+	ID           string `json:"id"`
+	Question     string `json:"question"`
+	Unit         string `json:"unit,omitempty"` // What the answer counts, e.g. "apples" or "dollars"
+	Grade        int    `json:"grade"`
+	Theme        string `json:"theme"`
+	GenerationID string `json:"generation_id,omitempty"`
+	PromptTag
+}
+
+// wordProblemDraft is a generated problem before it is stored; AI replies
+// and cached sets have this shape
+type wordProblemDraft struct {
+	Question   string  `json:"question"`
+	Unit       string  `json:"unit"`
+	Expression string  `json:"expression"`
+	Answer     float64 `json:"answer"`
+}
+
+type StoredWordProblem struct {
+	ProblemID   string    `dynamodbav:"problem_id"`
+	Question    string    `dynamodbav:"question"`
+	Unit        string    `dynamodbav:"unit"`
+	Expression  string    `dynamodbav:"expression"`
+	Answer      float64   `dynamodbav:"answer"`
+	Grade       int       `dynamodbav:"grade"`
+	Theme       string    `dynamodbav:"theme"`
+	Source      string    `dynamodbav:"source"`
+	Attempts    int       `dynamodbav:"attempts"`
+	Solved      bool      `dynamodbav:"solved"`
+	Explanation []string  `dynamodbav:"explanation,omitempty"` // AI steps, once asked for
+	CreatedAt   time.Time `dynamodbav:"created_at"`
+	ExpiresAt   int64     `dynamodbav:"expires_at"`
+}
+
+type SubmitWordProblemRequest struct {
+	Answer *float64 `json:"answer" binding:"required"`
+}
+
+// wordProblemExplanationPrompt is what the explanation template sees
+type wordProblemExplanationPrompt struct {
+	Question   string `prompt:"max=1000"`
+	Expression string
+	Answer     string
+	Unit       string
+	Grade      int
+}
+
+// generateWordProblems writes a set of problems and stores their answers
+func (h *PuzzleHub) generateWordProblems(c *gin.Context) {
+	var request WordProblemRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if request.Theme == "" {
+		request.Theme = "everyday"
+	}
+	if _, ok := wordProblemThemes[request.Theme]; !ok {
+		respondError(c, http.StatusBadRequest, "Theme must be one of: everyday, animals, space, sports, food, shopping, nature")
+		return
+	}
+	if request.Count == 0 {
+		request.Count = defaultWordProblems
+	}
+	if request.Count < 1 || request.Count > maxWordProblems {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("count must be between 1 and %d", maxWordProblems))
+		return
+	}
+	h.accessibilityFor(c).applyToWordProblems(&request)
+	ctx := c.Request.Context()
+	userID := optionalUserID(c)
+
+	drafts, source, generation, choice := h.writeWordProblems(ctx, request, userID)
+
+	now := time.Now()
+	problems := make([]WordProblem, 0, len(drafts))
+	stored := make([]StoredWordProblem, 0, len(drafts))
+	for i, draft := range drafts {
+		id := fmt.Sprintf("wp_%d_%d", now.UnixNano(), i)
+		stored = append(stored, StoredWordProblem{
+			ProblemID:  id,
+			Question:   draft.Question,
+			Unit:       draft.Unit,
+			Expression: draft.Expression,
+			Answer:     draft.Answer,
+			Grade:      request.Grade,
+			Theme:      request.Theme,
+			Source:     source,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(wordProblemRetention).Unix(),
+		})
+		problem := WordProblem{ID: id, Question: draft.Question, Unit: draft.Unit, Grade: request.Grade, Theme: request.Theme}
+		if generation != "" {
+			problem.GenerationID = generation
+			problem.PromptTag = choice.PromptTag
+		}
+		problems = append(problems, problem)
+	}
+
+	if err := h.saveWordProblems(stored); err != nil {
+		requestLogger(c).Error("error saving word problems", "error", err)
+		respondStorageError(c, err, "Failed to save word problems")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"problems": problems, "source": source})
+}
+
+// writeWordProblems tries the AI cache, then AI, then the templates. It
+// returns the generation ID for AI problems made on this request.
+func (h *PuzzleHub) writeWordProblems(ctx context.Context, request WordProblemRequest, userID string) ([]wordProblemDraft, string, string, promptChoice) {
+	choice := h.Prompts.choose("math_word_problems", userID)
+	params := map[string]interface{}{
+		"grade": request.Grade,
+		"theme": request.Theme,
+		"count": request.Count,
+	}
+	if request.SimplifiedLanguage {
+		params["simplified_language"] = true
+	}
+	cacheParams := choice.cacheParams(params)
+	var drafts []wordProblemDraft
+	if h.loadAICache(ctx, "math", cacheParams, &drafts) {
+		return drafts, sourceAI, "", choice
+	}
+
+	var generated struct {
+		Problems []wordProblemDraft `json:"problems"`
+	}
+	call := aiCall{Feature: "math", UserID: userID, Prompt: choice.PromptTag, Generation: newAIGeneration()}
+	prompt := h.Prompts.renderChoice(choice, request)
+	err := h.generateJSON(ctx, prompt, call, wordProblemsSchema, &generated, func() error {
+		if len(generated.Problems) < request.Count {
+			return fmt.Errorf("expected %d problems, got %d", request.Count, len(generated.Problems))
+		}
+		for i := range generated.Problems {
+			if err := generated.Problems[i].check(); err != nil {
+				return fmt.Errorf("problem %d: %v", i+1, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("❌ %s word problem generation failed, using templates: %v", h.providerFor("math"), err)
+		return templateWordProblems(request, rand.New(rand.NewSource(time.Now().UnixNano()))), sourceFallback, "", choice
+	}
+
+	drafts = generated.Problems[:request.Count]
+	h.saveGeneration(call.Generation, call, choice)
+	h.storeAICache(ctx, "math", cacheParams, drafts)
+	return drafts, sourceAI, call.Generation.ID, choice
+}
+
+// check makes sure a draft's expression works out to its answer
+func (d *wordProblemDraft) check() error {
+	if strings.TrimSpace(d.Question) == "" {
+		return fmt.Errorf("question is empty")
+	}
+	value, _, err := evaluateExpression(d.Expression)
+	if err != nil {
+		return fmt.Errorf("expression %q: %v", d.Expression, err)
+	}
+	if math.Abs(value-d.Answer) > answerTolerance {
+		return fmt.Errorf("expression %q is %s, not the answer %s", d.Expression, formatNumber(value), formatNumber(d.Answer))
+	}
+	d.Answer = math.Round(d.Answer*100) / 100
+	return nil
+}
+
+// submitWordProblem checks an answer, counting the attempt
+func (h *PuzzleHub) submitWordProblem(c *gin.Context) {
+	var request SubmitWordProblemRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	problemID := c.Param("id")
+
+	problem, err := h.loadWordProblem(c.Request.Context(), problemID)
+	if err != nil {
+		requestLogger(c).Error("error fetching word problem", "error", err, "problem_id", problemID)
+		respondStorageError(c, err, "Failed to check answer")
+		return
+	}
+	if problem == nil {
+		respondError(c, http.StatusNotFound, "Word problem not found")
+		return
+	}
+
+	correct := math.Abs(*request.Answer-problem.Answer) <= answerTolerance
+	attempts, err := h.recordWordProblemAttempt(problemID, correct)
+	if err != nil {
+		requestLogger(c).Error("error recording attempt on word problem", "error", err, "problem_id", problemID)
+		respondStorageError(c, err, "Failed to check answer")
+		return
+	}
+
+	response := gin.H{
+		"correct":  correct,
+		"attempts": attempts,
+	}
+	if correct || problem.Solved || attempts >= maxWordProblemAttempts {
+		response["answer"] = problem.Answer
+		response["unit"] = problem.Unit
+	} else {
+		response["attempts_left"] = maxWordProblemAttempts - attempts
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// explainWordProblem returns a step-by-step solution, asking AI the first
+// time and reusing its answer afterwards. Only the AI call counts towards
+// the math quota.
+func (h *PuzzleHub) explainWordProblem(c *gin.Context) {
+	ctx := c.Request.Context()
+	problemID := c.Param("id")
+
+	problem, err := h.loadWordProblem(ctx, problemID)
+	if err != nil {
+		requestLogger(c).Error("error fetching word problem", "error", err, "problem_id", problemID)
+		respondStorageError(c, err, "Failed to explain word problem")
+		return
+	}
+	if problem == nil {
+		respondError(c, http.StatusNotFound, "Word problem not found")
+		return
+	}
+
+	respond := func(steps []string, source string) {
+		c.JSON(http.StatusOK, gin.H{
+			"id":     problem.ProblemID,
+			"steps":  steps,
+			"answer": problem.Answer,
+			"unit":   problem.Unit,
+			"source": source,
+		})
+	}
+	if len(problem.Explanation) > 0 {
+		respond(problem.Explanation, sourceAI)
+		return
+	}
+
+	if _, ok := h.consumeAIQuota(c, "math", 1); !ok {
+		return
+	}
+	userID := optionalUserID(c)
+	choice := h.Prompts.choose("math_explanation", userID)
+	prompt := h.Prompts.renderChoice(choice, wordProblemExplanationPrompt{
+		Question:   problem.Question,
+		Expression: problem.Expression,
+		Answer:     formatNumber(problem.Answer),
+		Unit:       problem.Unit,
+		Grade:      problem.Grade,
+	})
+	var explanation struct {
+		Steps []string `json:"steps"`
+	}
+	call := aiCall{Feature: "math", UserID: userID, Prompt: choice.PromptTag, Generation: newAIGeneration()}
+	err = h.generateJSON(ctx, prompt, call, wordProblemExplanationSchema, &explanation, func() error {
+		if len(explanation.Steps) == 0 {
+			return fmt.Errorf("no steps")
+		}
+		return nil
+	})
+	if err != nil {
+		requestLogger(c).Error("word problem explanation failed, using the expression's steps", "error", err, "provider", h.providerFor("math"))
+		respond(expressionSteps(problem), sourceFallback)
+		return
+	}
+	h.saveGeneration(call.Generation, call, choice)
+
+	if err := h.saveWordProblemExplanation(problemID, explanation.Steps); err != nil {
+		requestLogger(c).Error("error saving explanation for word problem", "error", err, "problem_id", problemID)
+	}
+	respond(explanation.Steps, sourceAI)
+}
+
+// expressionSteps explains a problem from its expression, one operation
+// at a time
+func expressionSteps(problem *StoredWordProblem) []string {
+	_, operations, err := evaluateExpression(problem.Expression)
+	if err != nil {
+		return []string{fmt.Sprintf("The answer is %s.", answerWithUnit(problem))}
+	}
+	steps := []string{fmt.Sprintf("Write the problem as a number sentence: %s.", prettyExpression(problem.Expression))}
+	for i, operation := range operations {
+		if i == 0 {
+			steps = append(steps, "Work out "+operation+".")
+		} else {
+			steps = append(steps, "Then "+operation+".")
+		}
+	}
+	return append(steps, fmt.Sprintf("So the answer is %s.", answerWithUnit(problem)))
+}
+
+func answerWithUnit(problem *StoredWordProblem) string {
+	if problem.Unit == "" {
+		return formatNumber(problem.Answer)
+	}
+	return formatNumber(problem.Answer) + " " + problem.Unit
+}
+
+func (h *PuzzleHub) saveWordProblems(problems []StoredWordProblem) error {
+	requests := make([]*dynamodb.WriteRequest, 0, len(problems))
+	for _, problem := range problems {
+		item, err := dynamodbattribute.MarshalMap(problem)
+		if err != nil {
+			return fmt.Errorf("failed to marshal word problem: %v", err)
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	return batchWriteItems(h.DynamoDB, tableName("puzzle-hub-word-problems"), requests)
+}
+
+// loadWordProblem returns nil when there is no such problem
+func (h *PuzzleHub) loadWordProblem(ctx context.Context, problemID string) (*StoredWordProblem, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-word-problems")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"problem_id": {S: aws.String(problemID)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var problem StoredWordProblem
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &problem); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal word problem: %v", err)
+	}
+	return &problem, nil
+}
+
+// recordWordProblemAttempt counts an attempt and returns the new count
+func (h *PuzzleHub) recordWordProblemAttempt(problemID string, correct bool) (int, error) {
+	update := "ADD attempts :one"
+	values := map[string]*dynamodb.AttributeValue{
+		":one": {N: aws.String("1")},
+	}
+	if correct {
+		update += " SET solved = :true"
+		values[":true"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	result, err := h.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-word-problems")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"problem_id": {S: aws.String(problemID)},
+		},
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
+		ReturnValues:              aws.String("UPDATED_NEW"),
+	})
+	if err != nil {
+		return 0, err
+	}
+	attempts, _ := strconv.Atoi(aws.StringValue(result.Attributes["attempts"].N))
+	return attempts, nil
+}
+
+func (h *PuzzleHub) saveWordProblemExplanation(problemID string, steps []string) error {
+	_, err := h.DynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName("puzzle-hub-word-problems")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"problem_id": {S: aws.String(problemID)},
+		},
+		UpdateExpression: aws.String("SET explanation = :steps"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":steps": {L: stringListAttribute(steps)},
+		},
+	})
+	return err
+}
+
+func stringListAttribute(values []string) []*dynamodb.AttributeValue {
+	list := make([]*dynamodb.AttributeValue, len(values))
+	for i, value := range values {
+		list[i] = &dynamodb.AttributeValue{S: aws.String(value)}
+	}
+	return list
+}
+
+// wordProblemTemplate writes one kind of problem for a range of grades
+type wordProblemTemplate struct {
+	minGrade, maxGrade int
+	write              func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft
+}
+
+var wordProblemTemplates = []wordProblemTemplate{
+	{1, 2, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		item, name := pickOne(rng, theme.Items), pickOne(rng, wordProblemNames)
+		limit := 10 * grade
+		a, b := 1+rng.Intn(limit), 1+rng.Intn(limit)
+		return wordProblemDraft{
+			Question:   fmt.Sprintf("%s sees %d %s at the %s. Then %d more arrive. How many %s are there now?", name, a, item, theme.Place, b, item),
+			Unit:       item,
+			Expression: fmt.Sprintf("%d + %d", a, b),
+		}
+	}},
+	{1, 3, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		item, name := pickOne(rng, theme.Items), pickOne(rng, wordProblemNames)
+		a := 5 + rng.Intn(10*grade+5)
+		b := 1 + rng.Intn(a)
+		return wordProblemDraft{
+			Question:   fmt.Sprintf("%s has %d %s and gives %d of them away. How many %s does %s have left?", name, a, item, b, item, name),
+			Unit:       item,
+			Expression: fmt.Sprintf("%d - %d", a, b),
+		}
+	}},
+	{3, 4, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		item := pickOne(rng, theme.Items)
+		a, b := 2+rng.Intn(10), 2+rng.Intn(10)
+		return wordProblemDraft{
+			Question:   fmt.Sprintf("At the %s there are %d groups of %s with %d in each group. How many %s are there altogether?", theme.Place, a, item, b, item),
+			Unit:       item,
+			Expression: fmt.Sprintf("%d * %d", a, b),
+		}
+	}},
+	{3, 5, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		item, name := pickOne(rng, theme.Items), pickOne(rng, wordProblemNames)
+		friends, each := 2+rng.Intn(8), 2+rng.Intn(10)
+		return wordProblemDraft{
+			Question:   fmt.Sprintf("%s shares %d %s equally among %d friends. How many %s does each friend get?", name, friends*each, item, friends, item),
+			Unit:       item,
+			Expression: fmt.Sprintf("%d / %d", friends*each, friends),
+		}
+	}},
+	{3, 6, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		item, name := pickOne(rng, theme.Items), pickOne(rng, wordProblemNames)
+		packs, each := 2+rng.Intn(8), 3+rng.Intn(10)
+		given := 1 + rng.Intn(packs*each-1)
+		return wordProblemDraft{
+			Question:   fmt.Sprintf("%s buys %d packs of %s with %d in each pack, then gives away %d. How many %s are left?", name, packs, item, each, given, item),
+			Unit:       item,
+			Expression: fmt.Sprintf("%d * %d - %d", packs, each, given),
+		}
+	}},
+	{4, 8, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		goods, name := pickOne(rng, theme.Goods), pickOne(rng, wordProblemNames)
+		cents, quantity := 25*(4+rng.Intn(36)), 2+rng.Intn(7)
+		price := fmt.Sprintf("%d.%02d", cents/100, cents%100)
+		return wordProblemDraft{
+			Question:   fmt.Sprintf("One %s costs $%s. %s buys %d of them. How many dollars does %s spend?", goods, price, name, quantity, name),
+			Unit:       "dollars",
+			Expression: fmt.Sprintf("%s * %d", price, quantity),
+		}
+	}},
+	{5, 8, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		item, name := pickOne(rng, theme.Items), pickOne(rng, wordProblemNames)
+		parts := 3 + rng.Intn(6)
+		taken := 1 + rng.Intn(parts-1)
+		total := parts * (2 + rng.Intn(8))
+		return wordProblemDraft{
+			Question:   fmt.Sprintf("There are %d %s at the %s. %s takes %d/%d of them. How many %s does %s take?", total, item, theme.Place, name, taken, parts, item, name),
+			Unit:       item,
+			Expression: fmt.Sprintf("%d * %d / %d", total, taken, parts),
+		}
+	}},
+	{6, 8, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		goods := pickOne(rng, theme.Goods)
+		price := 4 * (2 + rng.Intn(19))
+		percent := []int{10, 15, 20, 25, 30, 50}[rng.Intn(6)]
+		return wordProblemDraft{
+			Question:   fmt.Sprintf("A %s costs $%d. In a sale it is %d%% off. What is the sale price in dollars?", goods, price, percent),
+			Unit:       "dollars",
+			Expression: fmt.Sprintf("%d - %d * %d / 100", price, price, percent),
+		}
+	}},
+	{7, 8, func(rng *rand.Rand, grade int, theme wordProblemTheme) wordProblemDraft {
+		first := rng.Intn(len(theme.Items))
+		second := (first + 1 + rng.Intn(len(theme.Items)-1)) % len(theme.Items)
+		a, b, scale := 2+rng.Intn(5), 2+rng.Intn(7), 2+rng.Intn(8)
+		return wordProblemDraft{
+			Question: fmt.Sprintf("At the %s, the ratio of %s to %s is %d:%d. If there are %d %s, how many %s are there?",
+				theme.Place, theme.Items[first], theme.Items[second], a, b, a*scale, theme.Items[first], theme.Items[second]),
+			Unit:       theme.Items[second],
+			Expression: fmt.Sprintf("%d / %d * %d", a*scale, a, b),
+		}
+	}},
+}
+
+// templateWordProblems writes problems from the templates for the grade
+func templateWordProblems(request WordProblemRequest, rng *rand.Rand) []wordProblemDraft {
+	var templates []wordProblemTemplate
+	for _, t := range wordProblemTemplates {
+		if request.Grade >= t.minGrade && request.Grade <= t.maxGrade {
+			templates = append(templates, t)
+		}
+	}
+	theme := wordProblemThemes[request.Theme]
+
+	drafts := make([]wordProblemDraft, 0, request.Count)
+	for i := 0; i < request.Count; i++ {
+		draft := templates[(i+rng.Intn(len(templates)))%len(templates)].write(rng, request.Grade, theme)
+		draft.Answer, _, _ = evaluateExpression(draft.Expression)
+		draft.Answer = math.Round(draft.Answer*100) / 100
+		drafts = append(drafts, draft)
+	}
+	return drafts
+}
+
+func pickOne(rng *rand.Rand, options []string) string {
+	return options[rng.Intn(len(options))]
+}
+
+// evaluateExpression works out + - * / and parentheses over decimal
+// numbers, listing each operation as it goes ("3 × 12 = 36")
+func evaluateExpression(expression string) (float64, []string, error) {
+	if len(expression) > maxExpressionLength {
+		return 0, nil, fmt.Errorf("expression is too long")
+	}
+	p := &expressionParser{input: strings.ReplaceAll(expression, " ", "")}
+	value, err := p.sum()
+	if err != nil {
+		return 0, nil, err
+	}
+	if p.pos < len(p.input) {
+		return 0, nil, fmt.Errorf("unexpected %q at %d", p.input[p.pos], p.pos)
+	}
+	return value, p.steps, nil
+}
+
+type expressionParser struct {
+	input string
+	pos   int
+	steps []string
+}
+
+func (p *expressionParser) sum() (float64, error) {
+	value, err := p.product()
+	if err != nil {
+		return 0, err
+	}
+	for p.pos < len(p.input) && (p.input[p.pos] == '+' || p.input[p.pos] == '-') {
+		op := p.input[p.pos]
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return 0, err
+		}
+		value = p.apply(value, op, right)
+	}
+	return value, nil
+}
+
+func (p *expressionParser) product() (float64, error) {
+	value, err := p.operand()
+	if err != nil {
+		return 0, err
+	}
+	for p.pos < len(p.input) && (p.input[p.pos] == '*' || p.input[p.pos] == '/') {
+		op := p.input[p.pos]
+		p.pos++
+		right, err := p.operand()
+		if err != nil {
+			return 0, err
+		}
+		if op == '/' && right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		value = p.apply(value, op, right)
+	}
+	return value, nil
+}
+
+func (p *expressionParser) operand() (float64, error) {
+	if p.pos >= len(p.input) {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	if p.input[p.pos] == '(' {
+		p.pos++
+		value, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return 0, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return value, nil
+	}
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] >= '0' && p.input[p.pos] <= '9' || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("expected a number at %d", start)
+	}
+	return strconv.ParseFloat(p.input[start:p.pos], 64)
+}
+
+func (p *expressionParser) apply(left float64, op byte, right float64) float64 {
+	var result float64
+	switch op {
+	case '+':
+		result = left + right
+	case '-':
+		result = left - right
+	case '*':
+		result = left * right
+	case '/':
+		result = left / right
+	}
+	p.steps = append(p.steps, fmt.Sprintf("%s %s %s = %s", formatNumber(left), prettyOperators[op], formatNumber(right), formatNumber(result)))
+	return result
+}
+
+var prettyOperators = map[byte]string{'+': "+", '-': "−", '*': "×", '/': "÷"}
+
+// prettyExpression writes an expression with × and ÷ signs
+func prettyExpression(expression string) string {
+	return strings.NewReplacer("*", "×", "/", "÷", "-", "−").Replace(expression)
+}
+
+// formatNumber writes a number with at most two decimals and no trailing zeros
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
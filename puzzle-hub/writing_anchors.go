@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Writing Feedback Anchors
+//
+// Grammar errors and vocabulary tips point into the text by character
+// offset, which goes stale as soon as the student edits anything before
+// them. Each highlight also carries an anchor: a hash of the sentence it
+// starts in, plus its offset within that sentence. After an edit the
+// client posts the new text and its highlights to /writing/reanchor, which
+// finds each sentence again and moves the offsets to match. Highlights
+// whose sentence was changed or removed come back as detached, since the
+// feedback may no longer apply.
+
+// maxReanchorItems caps the highlights accepted in one reanchor request
+const maxReanchorItems = 500
+
+// TextAnchor ties a highlight to the sentence it starts in
+type TextAnchor struct {
+	SentenceHash  string `json:"sentenceHash"`
+	SentenceIndex int    `json:"sentenceIndex"`
+	Offset        int    `json:"offset"` // From the start of the sentence
+}
+
+type sentenceSpan struct {
+	Start, End int
+	Hash       string
+}
+
+// splitSentences breaks text into sentences ending at ., ! or ? (with any
+// closing quotes or brackets) or at a line break. Offsets are byte
+// offsets, like the highlight indexes.
+func splitSentences(text string) []sentenceSpan {
+	var spans []sentenceSpan
+	add := func(start, end int) {
+		for start < end && unicode.IsSpace(rune(text[start])) {
+			start++
+		}
+		if start < end {
+			spans = append(spans, sentenceSpan{Start: start, End: end, Hash: sentenceHash(text[start:end])})
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			add(start, i)
+			start = i + 1
+		case '.', '!', '?':
+			end := i + 1
+			for end < len(text) && strings.ContainsRune(".!?\"')]", rune(text[end])) {
+				end++
+			}
+			add(start, end)
+			start = end
+			i = end - 1
+		}
+	}
+	add(start, len(text))
+	return spans
+}
+
+// sentenceHash ignores case and spacing, so reflowing a paragraph keeps
+// its anchors
+func sentenceHash(sentence string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(sentence), " "))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+// anchorAt anchors the highlight starting at offset, or returns nil when
+// it falls outside every sentence
+func anchorAt(sentences []sentenceSpan, offset int) *TextAnchor {
+	for i, sentence := range sentences {
+		if offset >= sentence.Start && offset < sentence.End {
+			return &TextAnchor{SentenceHash: sentence.Hash, SentenceIndex: i, Offset: offset - sentence.Start}
+		}
+	}
+	return nil
+}
+
+// anchor attaches anchors to the analysis highlights for text
+func (a *WritingAnalysisResponse) anchor(text string) {
+	sentences := splitSentences(text)
+	for i := range a.GrammarErrors {
+		a.GrammarErrors[i].Anchor = anchorAt(sentences, a.GrammarErrors[i].StartIndex)
+	}
+	for i := range a.VocabularyTips {
+		a.VocabularyTips[i].Anchor = anchorAt(sentences, a.VocabularyTips[i].StartIndex)
+	}
+}
+
+// relocate finds where a highlight now sits in text, returning its new
+// start, end and anchor
+func relocate(text string, sentences []sentenceSpan, anchor *TextAnchor, start, end int, original string) (int, int, *TextAnchor, bool) {
+	length := end - start
+	if length < 0 {
+		return 0, 0, nil, false
+	}
+	matches := func(from int) bool {
+		to := from + length
+		if from < 0 || to > len(text) {
+			return false
+		}
+		return original == "" || text[from:to] == original
+	}
+
+	// Highlights from before anchors existed stay put if the text under
+	// them is unchanged
+	if anchor == nil {
+		if !matches(start) {
+			return 0, 0, nil, false
+		}
+		newAnchor := anchorAt(sentences, start)
+		return start, end, newAnchor, newAnchor != nil
+	}
+
+	// The same sentence may appear more than once; prefer the copy
+	// nearest its old position
+	best := -1
+	for i, sentence := range sentences {
+		if sentence.Hash != anchor.SentenceHash {
+			continue
+		}
+		if best < 0 || absInt(i-anchor.SentenceIndex) < absInt(best-anchor.SentenceIndex) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, 0, nil, false
+	}
+	sentence := sentences[best]
+
+	newStart := sentence.Start + anchor.Offset
+	if !matches(newStart) {
+		// Case or spacing changed inside the sentence; look for the
+		// original words instead
+		if original == "" {
+			return 0, 0, nil, false
+		}
+		index := strings.Index(text[sentence.Start:sentence.End], original)
+		if index < 0 {
+			return 0, 0, nil, false
+		}
+		newStart = sentence.Start + index
+	}
+	return newStart, newStart + length, &TextAnchor{
+		SentenceHash:  sentence.Hash,
+		SentenceIndex: best,
+		Offset:        newStart - sentence.Start,
+	}, true
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+type ReanchorRequest struct {
+	Text           string          `json:"text" binding:"required"`
+	GrammarErrors  []GrammarError  `json:"grammarErrors"`
+	VocabularyTips []VocabularyTip `json:"vocabularyTips"`
+}
+
+type ReanchorResponse struct {
+	GrammarErrors  []GrammarError  `json:"grammarErrors"`
+	VocabularyTips []VocabularyTip `json:"vocabularyTips"`
+	Detached       struct {
+		GrammarErrors  []GrammarError  `json:"grammarErrors"`
+		VocabularyTips []VocabularyTip `json:"vocabularyTips"`
+	} `json:"detached"` // Their sentence was edited or removed
+}
+
+// reanchorWriting moves highlights from an earlier analysis onto edited
+// text
+func (h *PuzzleHub) reanchorWriting(c *gin.Context) {
+	var request ReanchorRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if len(request.GrammarErrors)+len(request.VocabularyTips) > maxReanchorItems {
+		respondError(c, http.StatusBadRequest, "Too many highlights to re-anchor")
+		return
+	}
+
+	sentences := splitSentences(request.Text)
+	var response ReanchorResponse
+	response.GrammarErrors = []GrammarError{}
+	response.VocabularyTips = []VocabularyTip{}
+	response.Detached.GrammarErrors = []GrammarError{}
+	response.Detached.VocabularyTips = []VocabularyTip{}
+
+	for _, grammarError := range request.GrammarErrors {
+		start, end, anchor, ok := relocate(request.Text, sentences, grammarError.Anchor, grammarError.StartIndex, grammarError.EndIndex, grammarError.Original)
+		if !ok {
+			response.Detached.GrammarErrors = append(response.Detached.GrammarErrors, grammarError)
+			continue
+		}
+		grammarError.StartIndex, grammarError.EndIndex, grammarError.Anchor = start, end, anchor
+		response.GrammarErrors = append(response.GrammarErrors, grammarError)
+	}
+	for _, tip := range request.VocabularyTips {
+		start, end, anchor, ok := relocate(request.Text, sentences, tip.Anchor, tip.StartIndex, tip.EndIndex, tip.Original)
+		if !ok {
+			response.Detached.VocabularyTips = append(response.Detached.VocabularyTips, tip)
+			continue
+		}
+		tip.StartIndex, tip.EndIndex, tip.Anchor = start, end, anchor
+		response.VocabularyTips = append(response.VocabularyTips, tip)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
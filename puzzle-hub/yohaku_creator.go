@@ -0,0 +1,689 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Build your own Yohaku
+//
+// Kids author a puzzle by filling in the inner grid. The server works out
+// the row and column results and the total, then picks which cells to hide
+// (or checks the cells the kid picked) so that exactly one way of filling
+// them in is left: the solver fills any row or column with one hidden cell
+// left, and tries every value in the puzzle's range when it gets stuck,
+// within a budget. Puzzles are kept under the author's profile
+// (puzzle-hub-custom-yohaku) until they delete them.
+//
+// Sharing gives a puzzle a short code; friends open it by code and each
+// play counts towards the puzzle's plays. Titles are checked against the
+// moderation word list since other children see them.
+
+const (
+	minCustomYohakuSize    = 2
+	maxCustomYohakuSize    = 4
+	maxCustomYohakuTitle   = 60
+	maxCustomYohaku        = 100    // Per author
+	customYohakuAttempts   = 300    // Random choices of hidden cells to try
+	customYohakuBudget     = 100000 // Values tried per puzzle
+	customYohakuValueLimit = 1000
+)
+
+type YohakuCellRef struct {
+	Row int `json:"row" dynamodbav:"row"`
+	Col int `json:"col" dynamodbav:"col"`
+}
+
+type CustomYohaku struct {
+	UserID     string          `json:"-" dynamodbav:"user_id"`
+	ID         string          `json:"id" dynamodbav:"puzzle_id"` // custom_<unix nanos>
+	Title      string          `json:"title" dynamodbav:"title"`
+	AuthorName string          `json:"author_name" dynamodbav:"author_name"`
+	Size       int             `json:"size" dynamodbav:"size"`
+	Operation  string          `json:"operation" dynamodbav:"operation"`
+	Range      NumberRange     `json:"range" dynamodbav:"range"`
+	Cells      [][]int         `json:"-" dynamodbav:"cells"` // The inner grid
+	Hidden     []YohakuCellRef `json:"-" dynamodbav:"hidden"`
+	ShareCode  string          `json:"share_code,omitempty" dynamodbav:"share_code,omitempty"`
+	Plays      int             `json:"plays" dynamodbav:"plays"`
+	CreatedAt  time.Time       `json:"created_at" dynamodbav:"created_at"`
+	Puzzle     *YohakuPuzzle   `json:"puzzle,omitempty" dynamodbav:"-"`
+}
+
+type CreateCustomYohakuRequest struct {
+	Title       string          `json:"title"`
+	Operation   string          `json:"operation"` // Default addition
+	Cells       [][]int         `json:"cells" binding:"required"`
+	Hidden      int             `json:"hidden"`       // Cells to hide, default half
+	HiddenCells []YohakuCellRef `json:"hidden_cells"` // Which ones; picked by the server when empty
+	Range       *NumberRange    `json:"range"`        // Values players may try; default covers the cells and 1-10
+}
+
+// solution returns the full grid, results included
+func (p *CustomYohaku) solution() [][]int {
+	solution := make([][]int, p.Size+1)
+	for i := range solution {
+		solution[i] = make([]int, p.Size+1)
+		if i < p.Size {
+			copy(solution[i], p.Cells[i])
+		}
+	}
+	fillYohakuSums(solution, p.Size, p.Operation)
+	return solution
+}
+
+// puzzle returns the playable puzzle
+func (p *CustomYohaku) puzzle() *YohakuPuzzle {
+	solution := p.solution()
+	grid := yohakuGridFromSolution(solution, p.Size)
+	for _, cell := range p.Hidden {
+		grid[cell.Row][cell.Col].Value = 0
+		grid[cell.Row][cell.Col].IsGiven = false
+	}
+	return &YohakuPuzzle{
+		ID:         p.ID,
+		Size:       p.Size,
+		Grid:       grid,
+		Solution:   solution,
+		Operation:  p.Operation,
+		Range:      p.Range,
+		Difficulty: "custom",
+		Level:      1,
+	}
+}
+
+// createCustomYohaku checks the kid's grid, hides cells so the answer is
+// unique and saves the puzzle
+func (h *PuzzleHub) createCustomYohaku(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+
+	var request CreateCustomYohakuRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	puzzle, problem := newCustomYohaku(&request)
+	if problem != "" {
+		respondError(c, http.StatusBadRequest, problem)
+		return
+	}
+	if h.Moderation.checkWordList(puzzle.Title).Flagged {
+		respondError(c, http.StatusBadRequest, "Please choose a different title")
+		return
+	}
+
+	if len(request.HiddenCells) > 0 {
+		switch countYohakuSolutions(puzzle, request.HiddenCells) {
+		case 1:
+			puzzle.Hidden = request.HiddenCells
+		case -1:
+			respondError(c, http.StatusUnprocessableEntity, "Too many possible answers to check; hide fewer cells or use a smaller range")
+			return
+		default:
+			respondError(c, http.StatusUnprocessableEntity, "With those cells hidden the puzzle has more than one answer; hide different or fewer cells")
+			return
+		}
+	} else {
+		puzzle.Hidden = pickYohakuHiddenCells(puzzle, request.Hidden, h.YohakuGenerator.rand)
+		if puzzle.Hidden == nil {
+			respondError(c, http.StatusUnprocessableEntity, fmt.Sprintf("Couldn't hide %d cells and keep one answer; try hiding fewer", request.Hidden))
+			return
+		}
+	}
+
+	count, err := h.countCustomYohaku(ctx, userObj.ID)
+	if err != nil {
+		requestLogger(c).Error("error counting puzzles", "error", err)
+		respondStorageError(c, err, "Failed to save puzzle")
+		return
+	}
+	if count >= maxCustomYohaku {
+		respondError(c, http.StatusConflict, fmt.Sprintf("You can keep up to %d puzzles; delete one to make another", maxCustomYohaku))
+		return
+	}
+
+	now := time.Now()
+	puzzle.UserID = userObj.ID
+	puzzle.ID = fmt.Sprintf("custom_%d", now.UnixNano())
+	puzzle.AuthorName = userObj.Name
+	puzzle.CreatedAt = now
+	if err := h.putCustomYohaku(ctx, puzzle); err != nil {
+		requestLogger(c).Error("error saving puzzle", "error", err)
+		respondStorageError(c, err, "Failed to save puzzle")
+		return
+	}
+
+	log.Printf("🧩 %s built a %dx%d %s yohaku", userObj.ID, puzzle.Size, puzzle.Size, puzzle.Operation)
+	puzzle.Puzzle = puzzle.puzzle()
+	c.JSON(http.StatusCreated, gin.H{"puzzle": puzzle})
+}
+
+// newCustomYohaku validates the request, filling in defaults, and returns
+// the unsaved puzzle or a problem
+func newCustomYohaku(request *CreateCustomYohakuRequest) (*CustomYohaku, string) {
+	size := len(request.Cells)
+	if size < minCustomYohakuSize || size > maxCustomYohakuSize {
+		return nil, fmt.Sprintf("The grid must be %d to %d rows", minCustomYohakuSize, maxCustomYohakuSize)
+	}
+	for _, row := range request.Cells {
+		if len(row) != size {
+			return nil, "The grid must be square"
+		}
+	}
+	low, high := request.Cells[0][0], request.Cells[0][0]
+	for _, row := range request.Cells {
+		for _, value := range row {
+			if value < -customYohakuValueLimit || value > customYohakuValueLimit {
+				return nil, fmt.Sprintf("Numbers must be between %d and %d", -customYohakuValueLimit, customYohakuValueLimit)
+			}
+			low, high = min(low, value), max(high, value)
+		}
+	}
+
+	if request.Operation == "" {
+		request.Operation = "addition"
+	}
+	if request.Operation != "addition" && request.Operation != "subtraction" && request.Operation != "multiplication" {
+		return nil, "Operation must be addition, subtraction or multiplication"
+	}
+
+	valueRange := NumberRange{Min: min(1, low), Max: max(10, high)}
+	if request.Range != nil {
+		valueRange = *request.Range
+		if valueRange.Min > low || valueRange.Max < high {
+			return nil, "The range must include every number in the grid"
+		}
+		if valueRange.Min < -customYohakuValueLimit || valueRange.Max > customYohakuValueLimit {
+			return nil, fmt.Sprintf("The range must be within %d to %d", -customYohakuValueLimit, customYohakuValueLimit)
+		}
+	}
+
+	if len(request.HiddenCells) > 0 {
+		seen := map[YohakuCellRef]bool{}
+		for _, cell := range request.HiddenCells {
+			if cell.Row < 0 || cell.Row >= size || cell.Col < 0 || cell.Col >= size {
+				return nil, "Hidden cells must be inside the grid"
+			}
+			if seen[cell] {
+				return nil, "Each hidden cell may only be listed once"
+			}
+			seen[cell] = true
+		}
+		if request.Hidden != 0 && request.Hidden != len(request.HiddenCells) {
+			return nil, "hidden doesn't match the number of hidden_cells"
+		}
+		request.Hidden = len(request.HiddenCells)
+	}
+	if request.Hidden == 0 {
+		request.Hidden = size * size / 2
+	}
+	if request.Hidden < 1 || request.Hidden > size*size {
+		return nil, fmt.Sprintf("Hide between 1 and %d cells", size*size)
+	}
+
+	title := strings.TrimSpace(request.Title)
+	if len(title) > maxCustomYohakuTitle {
+		return nil, fmt.Sprintf("The title must be at most %d characters", maxCustomYohakuTitle)
+	}
+	if title == "" {
+		title = fmt.Sprintf("My %dx%d %s puzzle", size, size, request.Operation)
+	}
+
+	return &CustomYohaku{
+		Title:     title,
+		Size:      size,
+		Operation: request.Operation,
+		Range:     valueRange,
+		Cells:     request.Cells,
+	}, ""
+}
+
+// pickYohakuHiddenCells tries random sets of cells until hiding one leaves
+// a single answer, returning nil if none is found within the budget
+func pickYohakuHiddenCells(puzzle *CustomYohaku, count int, rng *rand.Rand) []YohakuCellRef {
+	var cells []YohakuCellRef
+	for i := 0; i < puzzle.Size; i++ {
+		for j := 0; j < puzzle.Size; j++ {
+			cells = append(cells, YohakuCellRef{Row: i, Col: j})
+		}
+	}
+	solver := newYohakuSolver(puzzle)
+	for attempt := 0; attempt < customYohakuAttempts; attempt++ {
+		rng.Shuffle(len(cells), func(i, j int) { cells[i], cells[j] = cells[j], cells[i] })
+		hidden := append([]YohakuCellRef(nil), cells[:count]...)
+		// Once the budget is spent only sets the solver fills in without
+		// guessing can still pass
+		if solver.countHidden(puzzle, hidden) == 1 {
+			return hidden
+		}
+	}
+	return nil
+}
+
+// countYohakuSolutions counts the ways to fill the hidden cells, stopping
+// at 2. It returns -1 when the search runs out of budget.
+func countYohakuSolutions(puzzle *CustomYohaku, hidden []YohakuCellRef) int {
+	return newYohakuSolver(puzzle).countHidden(puzzle, hidden)
+}
+
+// yohakuSolver counts fillings of a grid's unknown cells that match its
+// row and column results. The budget of values tried is shared by every
+// count.
+type yohakuSolver struct {
+	size       int
+	operation  string
+	valueRange NumberRange
+	rowTargets []int
+	colTargets []int
+	budget     int
+}
+
+func newYohakuSolver(puzzle *CustomYohaku) *yohakuSolver {
+	solver := &yohakuSolver{size: puzzle.Size, operation: puzzle.Operation, valueRange: puzzle.Range, budget: customYohakuBudget}
+	full := puzzle.solution()
+	for i := 0; i < puzzle.Size; i++ {
+		solver.rowTargets = append(solver.rowTargets, full[i][puzzle.Size])
+		solver.colTargets = append(solver.colTargets, full[puzzle.Size][i])
+	}
+	return solver
+}
+
+// countHidden counts the fillings of the puzzle with the cells hidden
+func (s *yohakuSolver) countHidden(puzzle *CustomYohaku, hidden []YohakuCellRef) int {
+	values := make([][]int, puzzle.Size)
+	known := make([][]bool, puzzle.Size)
+	for i := range values {
+		values[i] = append([]int(nil), puzzle.Cells[i]...)
+		known[i] = make([]bool, puzzle.Size)
+		for j := range known[i] {
+			known[i][j] = true
+		}
+	}
+	for _, cell := range hidden {
+		values[cell.Row][cell.Col], known[cell.Row][cell.Col] = 0, false
+	}
+	return s.count(values, known, 2)
+}
+
+func (s *yohakuSolver) count(values [][]int, known [][]bool, limit int) int {
+	if !s.propagate(values, known) {
+		return 0
+	}
+	row, col := -1, -1
+	for i := 0; i < s.size && row < 0; i++ {
+		for j := 0; j < s.size; j++ {
+			if !known[i][j] {
+				row, col = i, j
+				break
+			}
+		}
+	}
+	if row < 0 {
+		return 1
+	}
+
+	total := 0
+	for value := s.valueRange.Min; value <= s.valueRange.Max && total < limit; value++ {
+		if s.budget--; s.budget < 0 {
+			return -1
+		}
+		nextValues, nextKnown := copyYohakuState(values, known)
+		nextValues[row][col], nextKnown[row][col] = value, true
+		n := s.count(nextValues, nextKnown, limit-total)
+		if n < 0 {
+			return -1
+		}
+		total += n
+	}
+	return total
+}
+
+// propagate fills in every row and column with one unknown cell left, and
+// reports false if the grid can't match its results
+func (s *yohakuSolver) propagate(values [][]int, known [][]bool) bool {
+	for changed := true; changed; {
+		changed = false
+		for line := 0; line < 2*s.size; line++ {
+			cell := func(k int) (int, int) { return line, k }
+			target := 0
+			if line < s.size {
+				target = s.rowTargets[line]
+			} else {
+				col := line - s.size
+				cell = func(k int) (int, int) { return k, col }
+				target = s.colTargets[col]
+			}
+
+			unknown := -1
+			lineValues := make([]int, s.size)
+			for k := 0; k < s.size; k++ {
+				i, j := cell(k)
+				lineValues[k] = values[i][j]
+				if !known[i][j] {
+					if unknown >= 0 {
+						unknown = -2
+						break
+					}
+					unknown = k
+				}
+			}
+			switch {
+			case unknown == -1:
+				result := lineValues[0]
+				for _, value := range lineValues[1:] {
+					result = applyYohakuOperation(s.operation, result, value)
+				}
+				if result != target {
+					return false
+				}
+			case unknown >= 0:
+				value, ok, determined := solveYohakuLine(s.operation, lineValues, unknown, target)
+				if !ok || (determined && (value < s.valueRange.Min || value > s.valueRange.Max)) {
+					return false
+				}
+				if determined {
+					i, j := cell(unknown)
+					values[i][j], known[i][j] = value, true
+					changed = true
+				}
+			}
+		}
+	}
+	return true
+}
+
+// solveYohakuLine finds the value at k that makes the line work out to
+// target. ok is false if none does; determined is false if any would.
+func solveYohakuLine(operation string, values []int, k, target int) (value int, ok, determined bool) {
+	switch operation {
+	case "addition":
+		value = target
+		for i, v := range values {
+			if i != k {
+				value -= v
+			}
+		}
+	case "subtraction":
+		if k == 0 {
+			value = target
+			for _, v := range values[1:] {
+				value += v
+			}
+		} else {
+			value = values[0] - target
+			for i, v := range values[1:] {
+				if i+1 != k {
+					value -= v
+				}
+			}
+		}
+	case "multiplication":
+		product := 1
+		for i, v := range values {
+			if i != k {
+				product *= v
+			}
+		}
+		if product == 0 {
+			return 0, target == 0, false
+		}
+		if target%product != 0 {
+			return 0, false, false
+		}
+		value = target / product
+	}
+	return value, true, true
+}
+
+func copyYohakuState(values [][]int, known [][]bool) ([][]int, [][]bool) {
+	nextValues := make([][]int, len(values))
+	nextKnown := make([][]bool, len(known))
+	for i := range values {
+		nextValues[i] = append([]int(nil), values[i]...)
+		nextKnown[i] = append([]bool(nil), known[i]...)
+	}
+	return nextValues, nextKnown
+}
+
+// listCustomYohaku lists the author's puzzles, newest first
+func (h *PuzzleHub) listCustomYohaku(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+
+	puzzles, err := queryLimit[CustomYohaku](c.Request.Context(), h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-custom-yohaku")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userObj.ID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, 0)
+	if err != nil {
+		requestLogger(c).Error("error listing puzzles", "error", err)
+		respondStorageError(c, err, "Failed to fetch puzzles")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"puzzles": puzzles})
+}
+
+// getCustomYohaku returns one of the author's puzzles, ready to play
+func (h *PuzzleHub) getCustomYohaku(c *gin.Context) {
+	puzzle, ok := h.requireCustomYohaku(c)
+	if !ok {
+		return
+	}
+	puzzle.Puzzle = puzzle.puzzle()
+	c.JSON(http.StatusOK, gin.H{"puzzle": puzzle})
+}
+
+// deleteCustomYohaku deletes one of the author's puzzles; its share code
+// stops working with it
+func (h *PuzzleHub) deleteCustomYohaku(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	_, err := h.DynamoDB.DeleteItemWithContext(c.Request.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName("puzzle-hub-custom-yohaku")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":   {S: aws.String(userObj.ID)},
+			"puzzle_id": {S: aws.String(c.Param("id"))},
+		},
+		ConditionExpression: aws.String("attribute_exists(puzzle_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		respondError(c, http.StatusNotFound, "Puzzle not found")
+		return
+	}
+	if err != nil {
+		requestLogger(c).Error("error deleting puzzle", "error", err, "puzzle_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to delete puzzle")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Puzzle deleted"})
+}
+
+// shareCustomYohaku gives a puzzle its share code, or returns the one it
+// already has
+func (h *PuzzleHub) shareCustomYohaku(c *gin.Context) {
+	ctx := c.Request.Context()
+	puzzle, ok := h.requireCustomYohaku(c)
+	if !ok {
+		return
+	}
+
+	if puzzle.ShareCode == "" {
+		code, err := h.newUniqueShareCode(ctx)
+		var out *dynamodb.UpdateItemOutput
+		if err == nil {
+			// if_not_exists keeps the code of a share that got there first
+			out, err = h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+				TableName: aws.String(tableName("puzzle-hub-custom-yohaku")),
+				Key: map[string]*dynamodb.AttributeValue{
+					"user_id":   {S: aws.String(puzzle.UserID)},
+					"puzzle_id": {S: aws.String(puzzle.ID)},
+				},
+				UpdateExpression:    aws.String("SET share_code = if_not_exists(share_code, :code)"),
+				ConditionExpression: aws.String("attribute_exists(puzzle_id)"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":code": {S: aws.String(code)},
+				},
+				ReturnValues: aws.String(dynamodb.ReturnValueUpdatedNew),
+			})
+		}
+		if isConditionalCheckFailed(err) {
+			respondError(c, http.StatusNotFound, "Puzzle not found")
+			return
+		}
+		if err != nil {
+			requestLogger(c).Error("error sharing puzzle", "error", err, "puzzle_id", puzzle.ID)
+			respondStorageError(c, err, "Failed to share puzzle")
+			return
+		}
+		puzzle.ShareCode = code
+		if stored := out.Attributes["share_code"]; stored != nil {
+			puzzle.ShareCode = aws.StringValue(stored.S)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"share_code": puzzle.ShareCode,
+		"link":       strings.TrimSuffix(os.Getenv("BASE_URL"), "/") + "/yohaku/shared/" + puzzle.ShareCode,
+	})
+}
+
+// playSharedYohaku opens a friend's puzzle by its share code. Plays by
+// anyone but the author are counted.
+func (h *PuzzleHub) playSharedYohaku(c *gin.Context) {
+	userObj := c.MustGet("user").(*User)
+	ctx := c.Request.Context()
+	code := strings.ToUpper(strings.TrimSpace(c.Param("code")))
+
+	puzzle, err := h.getCustomYohakuByShareCode(ctx, code)
+	if err != nil {
+		requestLogger(c).Error("error fetching shared puzzle", "error", err, "code", code)
+		respondStorageError(c, err, "Failed to fetch puzzle")
+		return
+	}
+	if puzzle == nil {
+		respondError(c, http.StatusNotFound, "That puzzle doesn't exist or was deleted")
+		return
+	}
+
+	if puzzle.UserID != userObj.ID {
+		_, err := h.DynamoDB.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName("puzzle-hub-custom-yohaku")),
+			Key: map[string]*dynamodb.AttributeValue{
+				"user_id":   {S: aws.String(puzzle.UserID)},
+				"puzzle_id": {S: aws.String(puzzle.ID)},
+			},
+			UpdateExpression:          aws.String("ADD plays :one"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":one": {N: aws.String("1")}},
+		})
+		if err != nil {
+			requestLogger(c).Error("error counting a play of puzzle", "error", err, "puzzle_id", puzzle.ID)
+		} else {
+			puzzle.Plays++
+		}
+	}
+
+	puzzle.Puzzle = puzzle.puzzle()
+	c.JSON(http.StatusOK, gin.H{"puzzle": puzzle})
+}
+
+// requireCustomYohaku loads the caller's puzzle :id
+func (h *PuzzleHub) requireCustomYohaku(c *gin.Context) (*CustomYohaku, bool) {
+	userObj := c.MustGet("user").(*User)
+	puzzle, err := h.loadCustomYohaku(c.Request.Context(), userObj.ID, c.Param("id"))
+	if err != nil {
+		requestLogger(c).Error("error fetching puzzle", "error", err, "puzzle_id", c.Param("id"))
+		respondStorageError(c, err, "Failed to fetch puzzle")
+		return nil, false
+	}
+	if puzzle == nil {
+		respondError(c, http.StatusNotFound, "Puzzle not found")
+		return nil, false
+	}
+	return puzzle, true
+}
+
+func (h *PuzzleHub) loadCustomYohaku(ctx context.Context, userID, puzzleID string) (*CustomYohaku, error) {
+	result, err := h.DynamoDB.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName("puzzle-hub-custom-yohaku")),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id":   {S: aws.String(userID)},
+			"puzzle_id": {S: aws.String(puzzleID)},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return nil, err
+	}
+	var puzzle CustomYohaku
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &puzzle); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal puzzle: %v", err)
+	}
+	return &puzzle, nil
+}
+
+func (h *PuzzleHub) getCustomYohakuByShareCode(ctx context.Context, code string) (*CustomYohaku, error) {
+	puzzles, err := queryLimit[CustomYohaku](ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-custom-yohaku")),
+		IndexName:              aws.String("share_code-index"),
+		KeyConditionExpression: aws.String("share_code = :code"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":code": {S: aws.String(code)},
+		},
+	}, 1)
+	if err != nil || len(puzzles) == 0 {
+		return nil, err
+	}
+	return &puzzles[0], nil
+}
+
+// newUniqueShareCode draws codes until one isn't already in use
+func (h *PuzzleHub) newUniqueShareCode(ctx context.Context) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := randomJoinCode()
+		if err != nil {
+			return "", err
+		}
+		existing, err := h.getCustomYohakuByShareCode(ctx, code)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("could not find an unused share code")
+}
+
+func (h *PuzzleHub) countCustomYohaku(ctx context.Context, userID string) (int, error) {
+	return countQuery(ctx, h.DynamoDB, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName("puzzle-hub-custom-yohaku")),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":user_id": {S: aws.String(userID)},
+		},
+	})
+}
+
+func (h *PuzzleHub) putCustomYohaku(ctx context.Context, puzzle *CustomYohaku) error {
+	item, err := dynamodbattribute.MarshalMap(puzzle)
+	if err != nil {
+		return fmt.Errorf("failed to marshal puzzle: %v", err)
+	}
+	_, err = h.DynamoDB.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName("puzzle-hub-custom-yohaku")),
+		Item:      item,
+	})
+	return err
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -308,7 +307,13 @@ func main() {
 		port = "8080"
 	}
 
-	fmt.Printf("🧮 Yohaku Mathematical Puzzle Game starting on port %s\n", port)
+	// Emoji only with LOG_FORMAT=pretty; terminals without UTF-8, like the
+	// Windows console, print them as mojibake
+	banner := "Yohaku Mathematical Puzzle Game"
+	if strings.ToLower(strings.TrimSpace(os.Getenv("LOG_FORMAT"))) == "pretty" {
+		banner = "🧮 " + banner
+	}
+	fmt.Printf("%s starting on port %s\n", banner, port)
 	fmt.Printf("Visit http://localhost:%s to play!\n", port)
 
 	// Start server